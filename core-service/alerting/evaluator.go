@@ -0,0 +1,118 @@
+package alerting
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"time"
+
+	"github.com/charmbracelet/log"
+	"github.com/jmoiron/sqlx"
+	"github.com/lu1a/lcaas/core-service/db"
+	"github.com/lu1a/lcaas/core-service/kubeOps"
+	"github.com/lu1a/lcaas/core-service/metrics"
+	"github.com/lu1a/lcaas/core-service/types"
+)
+
+const evaluationInterval = time.Minute
+
+// Evaluates every project's alert rules every evaluationInterval. What a rule finds wrong gets notified about once
+// when it starts, again every repeat_after_minutes while it keeps going if the rule says so, and once more when it's
+// resolved. What's firing is kept in alert_firing between rounds.
+type Evaluator struct {
+	log          log.Logger
+	adminDB      *sqlx.DB
+	zoneRegistry *kubeOps.ZoneRegistry
+}
+
+func NewEvaluator(log log.Logger, adminDB *sqlx.DB, zoneRegistry *kubeOps.ZoneRegistry) *Evaluator {
+	return &Evaluator{log: log, adminDB: adminDB, zoneRegistry: zoneRegistry}
+}
+
+// Evaluates every evaluationInterval until ctx is done
+func (e *Evaluator) Run(ctx context.Context) {
+	ticker := time.NewTicker(evaluationInterval)
+	defer ticker.Stop()
+	for {
+		select {
+		case <-ctx.Done():
+			return
+		case now := <-ticker.C:
+			metrics.WorkerRan("alert_evaluator", e.evaluate(ctx, now))
+		}
+	}
+}
+
+func (e *Evaluator) evaluate(ctx context.Context, now time.Time) error {
+	rules, err := db.GetAllAlertRules(e.adminDB)
+	if err != nil {
+		e.log.Error(err.Error())
+		return err
+	}
+
+	var errs []error
+	for _, rule := range rules {
+		err = e.evaluateRule(ctx, rule, now)
+		if err != nil {
+			e.log.Error("Evaluating alert rule failed", "rule", rule.AlertRuleID, "project", rule.ProjectID, "err", err)
+			errs = append(errs, fmt.Errorf("alert rule %v: %w", rule.AlertRuleID, err))
+		}
+	}
+	return errors.Join(errs...)
+}
+
+func (e *Evaluator) evaluateRule(ctx context.Context, rule types.AlertRule, now time.Time) error {
+	project, err := db.GetProjectByID(e.adminDB, rule.ProjectID)
+	if err != nil {
+		return err
+	}
+	alerts, err := e.check(ctx, rule, project, now)
+	if err != nil {
+		return err
+	}
+	firings, err := db.GetAlertFirings(e.adminDB, rule)
+	if err != nil {
+		return err
+	}
+
+	firingByFingerprint := map[string]types.AlertFiring{}
+	for _, firing := range firings {
+		firingByFingerprint[firing.Fingerprint] = firing
+	}
+
+	for _, alert := range alerts {
+		firing, alreadyFiring := firingByFingerprint[alert.Fingerprint]
+		delete(firingByFingerprint, alert.Fingerprint)
+		if alreadyFiring && !rule.IsDueAgain(firing.LastNotifiedAt, now) {
+			continue
+		}
+
+		// only saved as firing once it's been notified about, so it gets tried again next round if that failed
+		err = e.notify(ctx, rule, project, types.Notification{Fingerprint: alert.Fingerprint, Title: alert.Title, Message: alert.Message})
+		if err != nil {
+			return err
+		}
+		err = db.SaveAlertFiring(e.adminDB, rule, alert, now)
+		if err != nil {
+			return err
+		}
+	}
+
+	// whatever's left isn't wrong anymore
+	for _, firing := range firingByFingerprint {
+		err = e.notify(ctx, rule, project, types.Notification{
+			Fingerprint: firing.Fingerprint,
+			Title:       "Resolved: " + firing.Title,
+			Message:     fmt.Sprintf("It had been going on since %s.", firing.FiringSince.Format("2006-01-02 15:04 MST")),
+			Resolved:    true,
+		})
+		if err != nil {
+			return err
+		}
+		err = db.DeleteAlertFiring(e.adminDB, firing)
+		if err != nil {
+			return err
+		}
+	}
+	return nil
+}
@@ -0,0 +1,104 @@
+package alerting
+
+import (
+	"bytes"
+	"context"
+	"encoding/json"
+	"fmt"
+	"net/http"
+	"slices"
+	"time"
+
+	"github.com/lu1a/lcaas/core-service/db"
+	"github.com/lu1a/lcaas/core-service/types"
+	"github.com/lu1a/lcaas/core-service/webhooks"
+)
+
+// only reaches public addresses, see webhooks.NewClient
+var webhookClient = webhooks.NewClient(10 * time.Second)
+
+// What generic webhooks get
+type webhookNotification struct {
+	Project     string    `json:"project"`
+	Rule        string    `json:"rule"`
+	Kind        string    `json:"kind"`
+	Fingerprint string    `json:"fingerprint"`
+	Title       string    `json:"title"`
+	Message     string    `json:"message"`
+	Resolved    bool      `json:"resolved"`
+	CreatedAt   time.Time `json:"created_at"`
+}
+
+// Keeps the notification, which is what shows up in the app, and sends it to the rule's channels unless the rule's
+// silenced. How that went is kept with it, a channel that didn't take it doesn't stop the others.
+func (e *Evaluator) notify(ctx context.Context, rule types.AlertRule, project types.Project, notification types.Notification) (err error) {
+	notification.ProjectID = project.ProjectID
+	notification.AlertRuleID = rule.AlertRuleID
+	notification.Silenced = rule.IsSilenced(time.Now())
+	notification, err = db.InsertNotification(e.adminDB, notification)
+	if err != nil {
+		return err
+	}
+	if notification.Silenced || len(rule.NotificationChannelIDs) == 0 {
+		return nil
+	}
+
+	channels, err := db.GetNotificationChannelsByProject(e.adminDB, project)
+	if err != nil {
+		return err
+	}
+	for _, channel := range channels {
+		if !slices.Contains(rule.NotificationChannelIDs, int64(channel.NotificationChannelID)) {
+			continue
+		}
+		delivery := types.NotificationDelivery{NotificationChannelID: channel.NotificationChannelID, ChannelName: channel.Name, OK: true}
+		err = deliver(ctx, channel, project, rule, notification)
+		if err != nil {
+			e.log.Warn("Sending notification failed", "project", project.Name, "channel", channel.Name, "err", err)
+			delivery.OK = false
+			delivery.Error = err.Error()
+		}
+		notification.Deliveries = append(notification.Deliveries, delivery)
+	}
+	return db.SaveNotificationDeliveries(e.adminDB, notification)
+}
+
+func deliver(ctx context.Context, channel types.NotificationChannel, project types.Project, rule types.AlertRule, notification types.Notification) error {
+	var payload any
+	switch channel.Kind {
+	case "slack":
+		payload = map[string]string{"text": fmt.Sprintf("*[%s] %s*\n%s", project.Name, notification.Title, notification.Message)}
+	case "discord":
+		payload = map[string]string{"content": fmt.Sprintf("**[%s] %s**\n%s", project.Name, notification.Title, notification.Message)}
+	default:
+		payload = webhookNotification{
+			Project:     project.Name,
+			Rule:        rule.Name,
+			Kind:        rule.Kind,
+			Fingerprint: notification.Fingerprint,
+			Title:       notification.Title,
+			Message:     notification.Message,
+			Resolved:    notification.Resolved,
+			CreatedAt:   notification.CreatedAt,
+		}
+	}
+	body, err := json.Marshal(payload)
+	if err != nil {
+		return err
+	}
+
+	req, err := http.NewRequestWithContext(ctx, http.MethodPost, channel.URL, bytes.NewReader(body))
+	if err != nil {
+		return err
+	}
+	req.Header.Set("Content-Type", "application/json")
+	resp, err := webhookClient.Do(req)
+	if err != nil {
+		return err
+	}
+	defer resp.Body.Close()
+	if resp.StatusCode >= 300 {
+		return fmt.Errorf("%s answered %s", channel.Kind, resp.Status)
+	}
+	return nil
+}
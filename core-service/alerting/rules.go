@@ -0,0 +1,192 @@
+package alerting
+
+import (
+	"context"
+	"crypto/tls"
+	"database/sql"
+	"errors"
+	"fmt"
+	"net"
+	"slices"
+	"time"
+
+	"github.com/lu1a/lcaas/core-service/db"
+	"github.com/lu1a/lcaas/core-service/postgresOps"
+	"github.com/lu1a/lcaas/core-service/types"
+	"github.com/lu1a/lcaas/core-service/webhooks"
+)
+
+// What the rule finds wrong in the project right now
+func (e *Evaluator) check(ctx context.Context, rule types.AlertRule, project types.Project, now time.Time) (alerts []types.Alert, err error) {
+	switch rule.Kind {
+	case "container_crash_looping", "container_oom_killed", "job_failed":
+		return e.checkContainers(rule, project)
+	case "db_storage":
+		return e.checkDBStorage(rule, project)
+	case "quota":
+		return e.checkQuota(rule, project)
+	case "certificate_expiring":
+		return checkCertificate(ctx, rule, now), nil
+	}
+	return alerts, fmt.Errorf("unknown alert rule kind %s", rule.Kind)
+}
+
+// Goes by the zone statuses the informers keep up to date, so it's only as fresh as they are
+func (e *Evaluator) checkContainers(rule types.AlertRule, project types.Project) (alerts []types.Alert, err error) {
+	containers, err := db.GetContainersByProject(e.adminDB, project)
+	if err != nil {
+		return alerts, err
+	}
+	zoneStatuses, err := db.GetContainerZoneStatusesByProject(e.adminDB, project)
+	if err != nil {
+		return alerts, err
+	}
+
+	for _, container := range containers {
+		if rule.Target != "" && rule.Target != container.Name {
+			continue
+		}
+		for _, zoneStatus := range zoneStatuses[container.ContainerClaimID] {
+			if !slices.Contains(container.Zones, zoneStatus.ZoneName) {
+				continue
+			}
+			lastTermination := "-"
+			if zoneStatus.LastTerminationReason != nil {
+				lastTermination = *zoneStatus.LastTerminationReason
+			}
+
+			switch rule.Kind {
+			case "container_crash_looping":
+				if container.IsRunOnce() || !zoneStatus.IsDown() || float64(zoneStatus.RestartCount) < rule.Threshold {
+					continue
+				}
+				alerts = append(alerts, types.Alert{
+					Fingerprint: fmt.Sprintf("container:%v:%s", container.ContainerClaimID, zoneStatus.ZoneName),
+					Title:       fmt.Sprintf("Container %s is crash-looping in %s", container.Name, zoneStatus.ZoneName),
+					Message:     fmt.Sprintf("It's restarted %v times without staying up. It last stopped with: %s.", zoneStatus.RestartCount, lastTermination),
+				})
+			case "container_oom_killed":
+				if lastTermination != "OOMKilled" {
+					continue
+				}
+				// a new OOM kill means a new restart, so it counts as something new
+				alerts = append(alerts, types.Alert{
+					Fingerprint: fmt.Sprintf("container:%v:%s:oom:%v", container.ContainerClaimID, zoneStatus.ZoneName, zoneStatus.RestartCount),
+					Title:       fmt.Sprintf("Container %s got OOM killed in %s", container.Name, zoneStatus.ZoneName),
+					Message:     fmt.Sprintf("It ran out of its %vMB of memory. Either give it more or have it use less.", container.MemoryMB),
+				})
+			case "job_failed":
+				if !container.IsRunOnce() || zoneStatus.PodPhase != "Failed" {
+					continue
+				}
+				alerts = append(alerts, types.Alert{
+					Fingerprint: fmt.Sprintf("container:%v:%s:failed", container.ContainerClaimID, zoneStatus.ZoneName),
+					Title:       fmt.Sprintf("Job %s failed in %s", container.Name, zoneStatus.ZoneName),
+					Message:     fmt.Sprintf("It stopped with: %s.", lastTermination),
+				})
+			}
+		}
+	}
+	return alerts, nil
+}
+
+func (e *Evaluator) checkDBStorage(rule types.AlertRule, project types.Project) (alerts []types.Alert, err error) {
+	userDBClaim, err := db.GetUserDBClaimByProject(e.adminDB, project)
+	if errors.Is(err, sql.ErrNoRows) {
+		return alerts, nil
+	}
+	if err != nil {
+		return alerts, err
+	}
+	if userDBClaim.Status != "active" || userDBClaim.StorageGB == 0 {
+		return alerts, nil
+	}
+
+	sizes, err := postgresOps.GetDatabaseSizes(e.zoneRegistry.UserDBConnections(), project, userDBClaim)
+	if err != nil {
+		return alerts, err
+	}
+	for zoneName, size := range sizes {
+		full := float64(size) / float64(int64(userDBClaim.StorageGB)<<30)
+		if full < rule.Threshold {
+			continue
+		}
+		alerts = append(alerts, types.Alert{
+			Fingerprint: fmt.Sprintf("db:%v:%s", userDBClaim.UserDBClaimID, zoneName),
+			Title:       fmt.Sprintf("The project database is %.0f%% full in %s", full*100, zoneName),
+			Message:     fmt.Sprintf("It's using %.1fGB of its %vGB.", float64(size)/(1<<30), userDBClaim.StorageGB),
+		})
+	}
+	return alerts, nil
+}
+
+// Quotas are per account, so every member of the project gets checked in every zone
+func (e *Evaluator) checkQuota(rule types.AlertRule, project types.Project) (alerts []types.Alert, err error) {
+	accounts, err := db.GetAccountsByProject(e.adminDB, project)
+	if err != nil {
+		return alerts, err
+	}
+	for _, account := range accounts {
+		if rule.Target != "" && rule.Target != account.Username {
+			continue
+		}
+		quotas, err := db.GetResourceQuotasForAccount(e.adminDB, account)
+		if err != nil {
+			return alerts, err
+		}
+		for _, quota := range quotas {
+			for _, resource := range []struct {
+				name  string
+				limit types.ResourceQuotaLimit
+			}{{"CPU", quota.CPUMilliCores}, {"memory", quota.MemoryMB}} {
+				if resource.limit.Limit == 0 || float64(resource.limit.Used) < rule.Threshold*float64(resource.limit.Limit) {
+					continue
+				}
+				alerts = append(alerts, types.Alert{
+					Fingerprint: fmt.Sprintf("quota:%v:%s:%s", account.AccountID, quota.ZoneName, resource.name),
+					Title:       fmt.Sprintf("%s has used %.0f%% of their %s quota in %s", account.Username, float64(resource.limit.Used)/float64(resource.limit.Limit)*100, resource.name, quota.ZoneName),
+					Message:     quota.Explain() + ".",
+				})
+			}
+		}
+	}
+	return alerts, nil
+}
+
+// Connects to the target and looks at the certificate it serves. Not being able to check it is alerted about too,
+// since an expired or otherwise invalid certificate fails the handshake.
+func checkCertificate(ctx context.Context, rule types.AlertRule, now time.Time) (alerts []types.Alert) {
+	address := rule.Target
+	host, _, err := net.SplitHostPort(address)
+	if err != nil {
+		host = address
+		address = net.JoinHostPort(address, "443")
+	}
+
+	// the target's the project's pick, so it only gets to be somewhere public
+	dialer := &tls.Dialer{NetDialer: &net.Dialer{Timeout: 10 * time.Second, Control: webhooks.DialPublicOnly}, Config: &tls.Config{ServerName: host}}
+	conn, err := dialer.DialContext(ctx, "tcp", address)
+	if err != nil {
+		return []types.Alert{{
+			Fingerprint: fmt.Sprintf("certificate:%s:unchecked", address),
+			Title:       fmt.Sprintf("Couldn't check the certificate of %s", address),
+			Message:     err.Error(),
+		}}
+	}
+	defer conn.Close()
+
+	certificates := conn.(*tls.Conn).ConnectionState().PeerCertificates
+	if len(certificates) == 0 {
+		return alerts
+	}
+	expiresAt := certificates[0].NotAfter
+	daysLeft := expiresAt.Sub(now).Hours() / 24
+	if daysLeft >= rule.Threshold {
+		return alerts
+	}
+	return []types.Alert{{
+		Fingerprint: fmt.Sprintf("certificate:%s", address),
+		Title:       fmt.Sprintf("The certificate of %s expires in %.0f days", address, daysLeft),
+		Message:     fmt.Sprintf("It's valid until %s.", expiresAt.Format("2006-01-02 15:04 MST")),
+	}}
+}
@@ -0,0 +1,115 @@
+package adminOps
+
+import (
+	"time"
+
+	"github.com/lu1a/lcaas/core-service/types"
+)
+
+/*
+Route: /api/admin/zone/list
+Type: query
+*/
+type IGetAllZonesResponse struct {
+	Zones   []types.ContainerZone `json:"zones"`
+	DBZones []types.UserDB        `json:"db_zones"`
+}
+
+/*
+Route: /api/admin/zone/register
+Type: mutation
+*/
+type IRegisterZoneResponse struct {
+	Zone types.ContainerZone `json:"zone"`
+}
+
+/*
+Route: /api/admin/zone/{zoneName}/update
+Type: mutation
+*/
+type IUpdateZoneResponse struct {
+	Zone types.ContainerZone `json:"zone"`
+}
+
+/*
+Route: /api/admin/zone/{zoneName}/capacity
+Type: query
+*/
+type IGetZoneCapacityHistoryResponse struct {
+	Zone    types.ContainerZone  `json:"zone"`
+	History []types.ZoneCapacity `json:"history"` // oldest first
+}
+
+/*
+Route: /api/admin/zone/{zoneName}/retire
+Type: mutation
+*/
+type IRetireZoneResponse struct {
+	Zone              types.ContainerZone `json:"zone"`
+	ContainersToDrain int                 `json:"containers_to_drain"`
+}
+
+/*
+Route: /api/admin/db-zone/register, /api/admin/db-zone/{userDBID}/update, /api/admin/db-zone/{userDBID}/retire
+Type: mutation
+*/
+type IDBZoneResponse struct {
+	DBZones []types.UserDB `json:"db_zones"` // all the DB instances after the change
+}
+
+/*
+Route: /api/admin/account/{accountID}/quota
+Type: mutation
+*/
+type IAccountQuotaResponse struct {
+	Account types.Account         `json:"account"`
+	Quotas  []types.ResourceQuota `json:"quotas"` // after the change
+}
+
+/*
+Route: /api/admin/project/{projectID}/credits
+Type: mutation
+*/
+type IProjectCreditsResponse struct {
+	Project types.Project      `json:"project"` // with its suspension as it is now
+	Entry   types.BillingEntry `json:"entry"`
+}
+
+/*
+Route: /api/admin/usage-report
+Type: query
+*/
+type IUsageReportResponse struct {
+	From time.Time              `json:"from"`
+	To   time.Time              `json:"to"`
+	Rows []types.UsageReportRow `json:"rows"` // one per container per zone
+}
+
+/*
+Route: /api/admin/quota/drift, /api/admin/quota/reconcile
+Type: query
+*/
+type IResourceUsageDriftResponse struct {
+	Drifts []types.ResourceUsageDrift `json:"drifts"` // newest first
+}
+
+type IDonatedNode struct {
+	types.DonatedNode
+	Uptime float64 `json:"uptime"` // from 0 to 1
+}
+
+/*
+Route: /api/admin/donation/list
+Type: query
+*/
+type IGetDonatedNodesResponse struct {
+	DonatedNodes []IDonatedNode `json:"donated_nodes"`
+}
+
+/*
+Route: /api/admin/donation/{donatedNodeID}/approve, /api/admin/donation/{donatedNodeID}/retire
+Type: mutation
+*/
+type IDonatedNodeResponse struct {
+	DonatedNode IDonatedNode `json:"donated_node"`
+}
@@ -0,0 +1,351 @@
+package alertOps
+
+import (
+	"encoding/json"
+	"fmt"
+	"net/http"
+	"strconv"
+	"time"
+
+	"github.com/lu1a/lcaas/core-service/db"
+	"github.com/lu1a/lcaas/core-service/types"
+
+	"github.com/charmbracelet/log"
+
+	"github.com/jmoiron/sqlx"
+)
+
+func AlertOpsRouter(log *log.Logger, adminDB *sqlx.DB) *http.ServeMux {
+	r := http.NewServeMux()
+	// Everything's POST here too, same as containerOps
+
+	// The project's alert rules, notification channels and what's firing right now
+	r.HandleFunc("POST /project/{projectName}/alerts/list", func(w http.ResponseWriter, r *http.Request) {
+		apiResponse := IGetAlertsResponse{}
+		projectName := r.PathValue("projectName")
+		value := r.Context().Value(types.Account{})
+		account := value.(types.Account)
+		thisProject, err := db.GetProjectByAccountAndName(adminDB, account, projectName)
+		if err != nil {
+			http.Error(w, err.Error(), http.StatusInternalServerError)
+			return
+		}
+		apiResponse.Rules, err = db.GetAlertRulesByProject(adminDB, thisProject)
+		if err != nil {
+			http.Error(w, err.Error(), http.StatusInternalServerError)
+			return
+		}
+		apiResponse.Channels, err = db.GetNotificationChannelsByProject(adminDB, thisProject)
+		if err != nil {
+			http.Error(w, err.Error(), http.StatusInternalServerError)
+			return
+		}
+		apiResponse.Firing, err = db.GetAlertFiringsByProject(adminDB, thisProject)
+		if err != nil {
+			http.Error(w, err.Error(), http.StatusInternalServerError)
+			return
+		}
+
+		apiResponseJSON, err := json.Marshal(apiResponse)
+		if err != nil {
+			http.Error(w, "Error encoding to JSON", http.StatusInternalServerError)
+			return
+		}
+
+		w.Header().Set("Content-Type", "application/json")
+		_, err = w.Write(apiResponseJSON)
+		if err != nil {
+			http.Error(w, "Error writing out JSON", http.StatusNotFound)
+		}
+	})
+
+	// Create an alert rule, ie. name=api-ooms&kind=container_oom_killed&target=api&channel[]=ops-slack&repeat-after-minutes=60
+	r.HandleFunc("POST /project/{projectName}/alerts/create-rule", func(w http.ResponseWriter, r *http.Request) {
+		apiResponse := ICreateAlertRuleResponse{}
+		projectName := r.PathValue("projectName")
+		value := r.Context().Value(types.Account{})
+		account := value.(types.Account)
+		thisProject, err := db.GetProjectByAccountAndName(adminDB, account, projectName)
+		if err != nil {
+			http.Error(w, err.Error(), http.StatusInternalServerError)
+			return
+		}
+
+		err = r.ParseForm()
+		if err != nil {
+			http.Error(w, err.Error(), http.StatusBadRequest)
+			return
+		}
+		channels, err := db.GetNotificationChannelsByProject(adminDB, thisProject)
+		if err != nil {
+			http.Error(w, err.Error(), http.StatusInternalServerError)
+			return
+		}
+		newRule := types.AlertRule{}
+		err = newRule.ParseFromHTTPForm(r, channels)
+		if err != nil {
+			http.Error(w, err.Error(), http.StatusBadRequest)
+			return
+		}
+
+		apiResponse.Rule, err = db.CreateAlertRuleForProject(adminDB, account, thisProject, newRule)
+		if err != nil {
+			http.Error(w, err.Error(), http.StatusInternalServerError)
+			return
+		}
+
+		apiResponseJSON, err := json.Marshal(apiResponse)
+		if err != nil {
+			http.Error(w, "Error encoding to JSON", http.StatusInternalServerError)
+			return
+		}
+
+		w.Header().Set("Content-Type", "application/json")
+		_, err = w.Write(apiResponseJSON)
+		if err != nil {
+			http.Error(w, "Error writing out JSON", http.StatusNotFound)
+		}
+	})
+
+	// Keep the rule's notifications from being sent for the next minutes=N, or send them again with minutes=0
+	r.HandleFunc("POST /project/{projectName}/alerts/rule/{ruleName}/silence", func(w http.ResponseWriter, r *http.Request) {
+		apiResponse := ISilenceAlertRuleResponse{}
+		projectName := r.PathValue("projectName")
+		ruleName := r.PathValue("ruleName")
+		value := r.Context().Value(types.Account{})
+		account := value.(types.Account)
+		thisProject, err := db.GetProjectByAccountAndName(adminDB, account, projectName)
+		if err != nil {
+			http.Error(w, err.Error(), http.StatusInternalServerError)
+			return
+		}
+		thisRule, err := db.GetAlertRuleByProjectAndName(adminDB, thisProject, ruleName)
+		if err != nil {
+			http.Error(w, err.Error(), http.StatusNotFound)
+			return
+		}
+
+		silencedUntil, err := parseSilenceMinutes(r)
+		if err != nil {
+			http.Error(w, err.Error(), http.StatusBadRequest)
+			return
+		}
+		apiResponse.Rule, err = db.SilenceAlertRule(adminDB, thisRule, silencedUntil)
+		if err != nil {
+			http.Error(w, err.Error(), http.StatusInternalServerError)
+			return
+		}
+
+		apiResponseJSON, err := json.Marshal(apiResponse)
+		if err != nil {
+			http.Error(w, "Error encoding to JSON", http.StatusInternalServerError)
+			return
+		}
+
+		w.Header().Set("Content-Type", "application/json")
+		_, err = w.Write(apiResponseJSON)
+		if err != nil {
+			http.Error(w, "Error writing out JSON", http.StatusNotFound)
+		}
+	})
+
+	r.HandleFunc("POST /project/{projectName}/alerts/rule/{ruleName}/delete", func(w http.ResponseWriter, r *http.Request) {
+		apiResponse := IDeleteAlertRuleResponse{}
+		projectName := r.PathValue("projectName")
+		ruleName := r.PathValue("ruleName")
+		value := r.Context().Value(types.Account{})
+		account := value.(types.Account)
+		thisProject, err := db.GetProjectByAccountAndName(adminDB, account, projectName)
+		if err != nil {
+			http.Error(w, err.Error(), http.StatusInternalServerError)
+			return
+		}
+		thisRule, err := db.GetAlertRuleByProjectAndName(adminDB, thisProject, ruleName)
+		if err != nil {
+			http.Error(w, err.Error(), http.StatusNotFound)
+			return
+		}
+
+		err = db.DeleteAlertRule(adminDB, thisRule)
+		if err != nil {
+			http.Error(w, err.Error(), http.StatusInternalServerError)
+			return
+		}
+		apiResponse.Rule = thisRule
+
+		apiResponseJSON, err := json.Marshal(apiResponse)
+		if err != nil {
+			http.Error(w, "Error encoding to JSON", http.StatusInternalServerError)
+			return
+		}
+
+		w.Header().Set("Content-Type", "application/json")
+		_, err = w.Write(apiResponseJSON)
+		if err != nil {
+			http.Error(w, "Error writing out JSON", http.StatusNotFound)
+		}
+	})
+
+	// Add somewhere to send alerts to, ie. name=ops-slack&kind=slack&url=https://hooks.slack.com/services/...
+	r.HandleFunc("POST /project/{projectName}/alerts/create-channel", func(w http.ResponseWriter, r *http.Request) {
+		apiResponse := ICreateNotificationChannelResponse{}
+		projectName := r.PathValue("projectName")
+		value := r.Context().Value(types.Account{})
+		account := value.(types.Account)
+		thisProject, err := db.GetProjectByAccountAndName(adminDB, account, projectName)
+		if err != nil {
+			http.Error(w, err.Error(), http.StatusInternalServerError)
+			return
+		}
+
+		newChannel := types.NotificationChannel{}
+		err = newChannel.ParseFromHTTPForm(r)
+		if err != nil {
+			http.Error(w, err.Error(), http.StatusBadRequest)
+			return
+		}
+		apiResponse.Channel, err = db.CreateNotificationChannelForProject(adminDB, thisProject, newChannel)
+		if err != nil {
+			http.Error(w, err.Error(), http.StatusInternalServerError)
+			return
+		}
+
+		apiResponseJSON, err := json.Marshal(apiResponse)
+		if err != nil {
+			http.Error(w, "Error encoding to JSON", http.StatusInternalServerError)
+			return
+		}
+
+		w.Header().Set("Content-Type", "application/json")
+		_, err = w.Write(apiResponseJSON)
+		if err != nil {
+			http.Error(w, "Error writing out JSON", http.StatusNotFound)
+		}
+	})
+
+	r.HandleFunc("POST /project/{projectName}/alerts/channel/{channelName}/delete", func(w http.ResponseWriter, r *http.Request) {
+		apiResponse := IDeleteNotificationChannelResponse{}
+		projectName := r.PathValue("projectName")
+		channelName := r.PathValue("channelName")
+		value := r.Context().Value(types.Account{})
+		account := value.(types.Account)
+		thisProject, err := db.GetProjectByAccountAndName(adminDB, account, projectName)
+		if err != nil {
+			http.Error(w, err.Error(), http.StatusInternalServerError)
+			return
+		}
+
+		err = db.DeleteNotificationChannelByProjectAndName(adminDB, thisProject, channelName)
+		if err != nil {
+			http.Error(w, err.Error(), http.StatusNotFound)
+			return
+		}
+		apiResponse.ChannelName = channelName
+
+		apiResponseJSON, err := json.Marshal(apiResponse)
+		if err != nil {
+			http.Error(w, "Error encoding to JSON", http.StatusInternalServerError)
+			return
+		}
+
+		w.Header().Set("Content-Type", "application/json")
+		_, err = w.Write(apiResponseJSON)
+		if err != nil {
+			http.Error(w, "Error writing out JSON", http.StatusNotFound)
+		}
+	})
+
+	// The notification history for the last week or since=2024-07-01T00:00:00Z, and how many of them are unread
+	r.HandleFunc("POST /project/{projectName}/alerts/notifications", func(w http.ResponseWriter, r *http.Request) {
+		apiResponse := IGetNotificationsResponse{}
+		projectName := r.PathValue("projectName")
+		value := r.Context().Value(types.Account{})
+		account := value.(types.Account)
+		thisProject, err := db.GetProjectByAccountAndName(adminDB, account, projectName)
+		if err != nil {
+			http.Error(w, err.Error(), http.StatusInternalServerError)
+			return
+		}
+		since := time.Now().AddDate(0, 0, -7)
+		if r.FormValue("since") != "" {
+			since, err = time.Parse(time.RFC3339, r.FormValue("since"))
+			if err != nil {
+				http.Error(w, "since has to be an RFC 3339 timestamp", http.StatusBadRequest)
+				return
+			}
+		}
+
+		apiResponse.Unread, err = db.CountUnreadNotifications(adminDB, thisProject)
+		if err != nil {
+			http.Error(w, err.Error(), http.StatusInternalServerError)
+			return
+		}
+		apiResponse.Notifications, err = db.GetNotificationsByProject(adminDB, thisProject, since)
+		if err != nil {
+			http.Error(w, err.Error(), http.StatusInternalServerError)
+			return
+		}
+
+		apiResponseJSON, err := json.Marshal(apiResponse)
+		if err != nil {
+			http.Error(w, "Error encoding to JSON", http.StatusInternalServerError)
+			return
+		}
+
+		w.Header().Set("Content-Type", "application/json")
+		_, err = w.Write(apiResponseJSON)
+		if err != nil {
+			http.Error(w, "Error writing out JSON", http.StatusNotFound)
+		}
+	})
+
+	// Mark all of the project's notifications as read
+	r.HandleFunc("POST /project/{projectName}/alerts/notifications/read", func(w http.ResponseWriter, r *http.Request) {
+		apiResponse := IGetNotificationsResponse{}
+		projectName := r.PathValue("projectName")
+		value := r.Context().Value(types.Account{})
+		account := value.(types.Account)
+		thisProject, err := db.GetProjectByAccountAndName(adminDB, account, projectName)
+		if err != nil {
+			http.Error(w, err.Error(), http.StatusInternalServerError)
+			return
+		}
+
+		err = db.MarkNotificationsAsRead(adminDB, thisProject)
+		if err != nil {
+			http.Error(w, err.Error(), http.StatusInternalServerError)
+			return
+		}
+		apiResponse.Notifications = []types.Notification{}
+
+		apiResponseJSON, err := json.Marshal(apiResponse)
+		if err != nil {
+			http.Error(w, "Error encoding to JSON", http.StatusInternalServerError)
+			return
+		}
+
+		w.Header().Set("Content-Type", "application/json")
+		_, err = w.Write(apiResponseJSON)
+		if err != nil {
+			http.Error(w, "Error writing out JSON", http.StatusNotFound)
+		}
+	})
+
+	return r
+}
+
+// How long to silence a rule for from minutes, nil to unsilence it
+func parseSilenceMinutes(r *http.Request) (silencedUntil *time.Time, err error) {
+	minutes := 0
+	if r.FormValue("minutes") != "" {
+		minutes, err = strconv.Atoi(r.FormValue("minutes"))
+		if err != nil || minutes < 0 {
+			return silencedUntil, fmt.Errorf("minutes has to be a number, 0 or more")
+		}
+	}
+	if minutes == 0 {
+		return nil, nil
+	}
+	until := time.Now().Add(time.Duration(minutes) * time.Minute)
+	return &until, nil
+}
@@ -0,0 +1,64 @@
+package alertOps
+
+import (
+	"github.com/lu1a/lcaas/core-service/types"
+)
+
+/*
+Route: /api/project/{projectName}/alerts/list
+Type: query
+*/
+type IGetAlertsResponse struct {
+	Rules    []types.AlertRule           `json:"rules"`
+	Channels []types.NotificationChannel `json:"channels"`
+	Firing   []types.AlertFiring         `json:"firing"`
+}
+
+/*
+Route: /api/project/{projectName}/alerts/create-rule
+Type: mutation
+*/
+type ICreateAlertRuleResponse struct {
+	Rule types.AlertRule `json:"rule"`
+}
+
+/*
+Route: /api/project/{projectName}/alerts/rule/{ruleName}/silence
+Type: mutation
+*/
+type ISilenceAlertRuleResponse struct {
+	Rule types.AlertRule `json:"rule"`
+}
+
+/*
+Route: /api/project/{projectName}/alerts/create-channel
+Type: mutation
+*/
+type ICreateNotificationChannelResponse struct {
+	Channel types.NotificationChannel `json:"channel"`
+}
+
+/*
+Route: /api/project/{projectName}/alerts/notifications, /api/project/{projectName}/alerts/notifications/read
+Type: query
+*/
+type IGetNotificationsResponse struct {
+	Unread        int                  `json:"unread"`
+	Notifications []types.Notification `json:"notifications"` // newest first
+}
+
+/*
+Route: /api/project/{projectName}/alerts/rule/{ruleName}/delete
+Type: mutation
+*/
+type IDeleteAlertRuleResponse struct {
+	Rule types.AlertRule `json:"rule"`
+}
+
+/*
+Route: /api/project/{projectName}/alerts/channel/{channelName}/delete
+Type: mutation
+*/
+type IDeleteNotificationChannelResponse struct {
+	ChannelName string `json:"channel_name"`
+}
@@ -5,16 +5,39 @@ import (
 
 	"github.com/charmbracelet/log"
 	"github.com/jmoiron/sqlx"
+	"github.com/lu1a/lcaas/core-service/api/adminOps"
+	"github.com/lu1a/lcaas/core-service/api/alertOps"
 	"github.com/lu1a/lcaas/core-service/api/auth"
+	"github.com/lu1a/lcaas/core-service/api/buildOps"
 	"github.com/lu1a/lcaas/core-service/api/containerOps"
+	"github.com/lu1a/lcaas/core-service/api/imageRegistryOps"
+	"github.com/lu1a/lcaas/core-service/api/manifestOps"
+	"github.com/lu1a/lcaas/core-service/api/sharedConfigOps"
+	"github.com/lu1a/lcaas/core-service/api/webhookOps"
+	"github.com/lu1a/lcaas/core-service/kubeOps"
+	"github.com/lu1a/lcaas/core-service/metrics"
 	"github.com/lu1a/lcaas/core-service/types"
 )
 
-func APIRouter(log log.Logger, db *sqlx.DB, kubeClients []types.ContainerZone, config types.Config) *http.ServeMux {
+func APIRouter(log log.Logger, db *sqlx.DB, zoneRegistry *kubeOps.ZoneRegistry, config types.Config) *http.ServeMux {
 	r := http.NewServeMux()
 	authLog := log.With("auth")
 	containerOpsLog := log.With("container-ops")
-	r.Handle("/auth/", http.StripPrefix("/auth", auth.AuthRouter(authLog, db, &config)))
-	r.Handle("/", containerOps.ContaineropsRouter(containerOpsLog, db, &config, kubeClients))
+	sharedConfigOpsLog := log.With("shared-config-ops")
+	alertOpsLog := log.With("alert-ops")
+	webhookOpsLog := log.With("webhook-ops")
+	buildOpsLog := log.With("build-ops")
+	imageRegistryOpsLog := log.With("image-registry-ops")
+	manifestOpsLog := log.With("manifest-ops")
+	adminOpsLog := log.With("admin-ops")
+	r.Handle("/auth/", http.StripPrefix("/auth", metrics.InstrumentRouter("auth", auth.AuthRouter(authLog, db, &config))))
+	r.Handle("/project/{projectName}/shared-config/", metrics.InstrumentRouter("shared-config-ops", sharedConfigOps.SharedConfigOpsRouter(sharedConfigOpsLog, db, zoneRegistry)))
+	r.Handle("/project/{projectName}/alerts/", metrics.InstrumentRouter("alert-ops", alertOps.AlertOpsRouter(alertOpsLog, db)))
+	r.Handle("/project/{projectName}/webhooks/", metrics.InstrumentRouter("webhook-ops", webhookOps.WebhookOpsRouter(webhookOpsLog, db)))
+	r.Handle("/project/{projectName}/builds/", metrics.InstrumentRouter("build-ops", buildOps.BuildOpsRouter(buildOpsLog, db, zoneRegistry)))
+	r.Handle("/project/{projectName}/registry/", metrics.InstrumentRouter("image-registry-ops", imageRegistryOps.ImageRegistryOpsRouter(imageRegistryOpsLog, db, zoneRegistry, &config)))
+	r.Handle("/project/{projectName}/apply", metrics.InstrumentRouter("manifest-ops", manifestOps.ManifestOpsRouter(manifestOpsLog, db, &config, zoneRegistry)))
+	r.Handle("/admin/", adminOps.AdminOpsRouter(adminOpsLog, db, &config, zoneRegistry))
+	r.Handle("/", metrics.InstrumentRouter("container-ops", containerOps.ContaineropsRouter(containerOpsLog, db, &config, zoneRegistry)))
 	return r
 }
@@ -0,0 +1,122 @@
+package buildOps
+
+import (
+	"encoding/json"
+	"net/http"
+	"strconv"
+	"time"
+
+	"github.com/lu1a/lcaas/core-service/db"
+	"github.com/lu1a/lcaas/core-service/imageBuilds"
+	"github.com/lu1a/lcaas/core-service/kubeOps"
+	"github.com/lu1a/lcaas/core-service/types"
+
+	"github.com/charmbracelet/log"
+
+	"github.com/jmoiron/sqlx"
+)
+
+func BuildOpsRouter(log *log.Logger, adminDB *sqlx.DB, zoneRegistry *kubeOps.ZoneRegistry) *http.ServeMux {
+	r := http.NewServeMux()
+	// Everything's POST here too, same as containerOps. Builds get started by create-container with build-source set.
+
+	// The project's image builds, from the last week unless there's a since
+	r.HandleFunc("POST /project/{projectName}/builds/list", func(w http.ResponseWriter, r *http.Request) {
+		apiResponse := IGetBuildsResponse{}
+		projectName := r.PathValue("projectName")
+		value := r.Context().Value(types.Account{})
+		account := value.(types.Account)
+		thisProject, err := db.GetProjectByAccountAndName(adminDB, account, projectName)
+		if err != nil {
+			http.Error(w, err.Error(), http.StatusInternalServerError)
+			return
+		}
+		since := time.Now().AddDate(0, 0, -7)
+		if r.FormValue("since") != "" {
+			since, err = time.Parse(time.RFC3339, r.FormValue("since"))
+			if err != nil {
+				http.Error(w, "since has to be an RFC 3339 timestamp", http.StatusBadRequest)
+				return
+			}
+		}
+
+		apiResponse.Builds, err = db.GetImageBuildsByProject(adminDB, thisProject, since)
+		if err != nil {
+			http.Error(w, err.Error(), http.StatusInternalServerError)
+			return
+		}
+
+		apiResponseJSON, err := json.Marshal(apiResponse)
+		if err != nil {
+			http.Error(w, "Error encoding to JSON", http.StatusInternalServerError)
+			return
+		}
+
+		w.Header().Set("Content-Type", "application/json")
+		_, err = w.Write(apiResponseJSON)
+		if err != nil {
+			http.Error(w, "Error writing out JSON", http.StatusNotFound)
+		}
+	})
+
+	r.HandleFunc("POST /project/{projectName}/builds/build/{buildID}", func(w http.ResponseWriter, r *http.Request) {
+		apiResponse := IGetBuildResponse{}
+		projectName := r.PathValue("projectName")
+		value := r.Context().Value(types.Account{})
+		account := value.(types.Account)
+		thisProject, err := db.GetProjectByAccountAndName(adminDB, account, projectName)
+		if err != nil {
+			http.Error(w, err.Error(), http.StatusInternalServerError)
+			return
+		}
+		buildID, err := strconv.Atoi(r.PathValue("buildID"))
+		if err != nil {
+			http.Error(w, "The build ID has to be a number", http.StatusBadRequest)
+			return
+		}
+
+		apiResponse.Build, err = db.GetImageBuildByProjectAndID(adminDB, thisProject, buildID)
+		if err != nil {
+			http.Error(w, err.Error(), http.StatusNotFound)
+			return
+		}
+
+		apiResponseJSON, err := json.Marshal(apiResponse)
+		if err != nil {
+			http.Error(w, "Error encoding to JSON", http.StatusInternalServerError)
+			return
+		}
+
+		w.Header().Set("Content-Type", "application/json")
+		_, err = w.Write(apiResponseJSON)
+		if err != nil {
+			http.Error(w, "Error writing out JSON", http.StatusNotFound)
+		}
+	})
+
+	// Plain text, streamed as it comes while the build's going, ie. curl -N -X POST .../builds/build/12/logs
+	r.HandleFunc("POST /project/{projectName}/builds/build/{buildID}/logs", func(w http.ResponseWriter, r *http.Request) {
+		projectName := r.PathValue("projectName")
+		value := r.Context().Value(types.Account{})
+		account := value.(types.Account)
+		thisProject, err := db.GetProjectByAccountAndName(adminDB, account, projectName)
+		if err != nil {
+			http.Error(w, err.Error(), http.StatusInternalServerError)
+			return
+		}
+		buildID, err := strconv.Atoi(r.PathValue("buildID"))
+		if err != nil {
+			http.Error(w, "The build ID has to be a number", http.StatusBadRequest)
+			return
+		}
+
+		build, err := db.GetImageBuildByProjectAndID(adminDB, thisProject, buildID)
+		if err != nil {
+			http.Error(w, err.Error(), http.StatusNotFound)
+			return
+		}
+		imageBuilds.ServeLogs(*log, w, r, zoneRegistry, thisProject, build)
+	})
+
+	return r
+}
@@ -0,0 +1,21 @@
+package buildOps
+
+import (
+	"github.com/lu1a/lcaas/core-service/types"
+)
+
+/*
+Route: /api/project/{projectName}/builds/list
+Type: query
+*/
+type IGetBuildsResponse struct {
+	Builds []types.ImageBuild `json:"builds"` // newest first
+}
+
+/*
+Route: /api/project/{projectName}/builds/build/{buildID}
+Type: query
+*/
+type IGetBuildResponse struct {
+	Build types.ImageBuild `json:"build"`
+}
@@ -2,22 +2,149 @@ package containerOps
 
 import (
 	"encoding/json"
+	"fmt"
 	"net/http"
+	"strconv"
 	"strings"
+	"time"
 
 	"github.com/lu1a/lcaas/core-service/db"
+	"github.com/lu1a/lcaas/core-service/deployHooks"
+	"github.com/lu1a/lcaas/core-service/imageBuilds"
+	"github.com/lu1a/lcaas/core-service/internalRegistry"
 	"github.com/lu1a/lcaas/core-service/kubeOps"
+	"github.com/lu1a/lcaas/core-service/registryOps"
 	"github.com/lu1a/lcaas/core-service/types"
+	"github.com/lu1a/lcaas/core-service/webhooks"
 
 	"github.com/charmbracelet/log"
 
 	"github.com/jmoiron/sqlx"
 )
 
-func ContaineropsRouter(log *log.Logger, adminDB *sqlx.DB, config *types.Config, kubeClients []types.ContainerZone) *http.ServeMux {
+func ContaineropsRouter(log *log.Logger, adminDB *sqlx.DB, config *types.Config, zoneRegistry *kubeOps.ZoneRegistry) *http.ServeMux {
 	r := http.NewServeMux()
 	// Everything's POST, to reduce argument over REST stupidity
 
+	// Explain the account's resource quota in every zone, ie. how much CPU and memory is left for new containers
+	r.HandleFunc("POST /quota", func(w http.ResponseWriter, r *http.Request) {
+		apiResponse := IGetQuotaResponse{}
+		value := r.Context().Value(types.Account{})
+		account := value.(types.Account)
+
+		var err error
+		apiResponse.Quotas, err = db.GetResourceQuotasForAccount(adminDB, account)
+		if err != nil {
+			http.Error(w, err.Error(), http.StatusInternalServerError)
+			return
+		}
+		for _, quota := range apiResponse.Quotas {
+			apiResponse.Explanations = append(apiResponse.Explanations, quota.Explain())
+		}
+
+		apiResponseJSON, err := json.Marshal(apiResponse)
+		if err != nil {
+			http.Error(w, "Error encoding to JSON", http.StatusInternalServerError)
+			return
+		}
+
+		w.Header().Set("Content-Type", "application/json")
+		_, err = w.Write(apiResponseJSON)
+		if err != nil {
+			http.Error(w, "Error writing out JSON", http.StatusNotFound)
+		}
+	})
+
+	// The project's credit balance and its ledger, for the last month or since=2024-07-01T00:00:00Z
+	r.HandleFunc("POST /project/{projectName}/billing", func(w http.ResponseWriter, r *http.Request) {
+		apiResponse := IGetBillingResponse{}
+		projectName := r.PathValue("projectName")
+		value := r.Context().Value(types.Account{})
+		account := value.(types.Account)
+		thisProject, err := db.GetProjectByAccountAndName(adminDB, account, projectName)
+		if err != nil {
+			http.Error(w, err.Error(), http.StatusInternalServerError)
+			return
+		}
+		since := time.Now().AddDate(0, -1, 0)
+		if r.FormValue("since") != "" {
+			since, err = time.Parse(time.RFC3339, r.FormValue("since"))
+			if err != nil {
+				http.Error(w, "since has to be an RFC 3339 timestamp", http.StatusBadRequest)
+				return
+			}
+		}
+
+		apiResponse.SuspendedAt = thisProject.SuspendedAt
+		apiResponse.Balance, err = db.GetProjectBalance(adminDB, thisProject)
+		if err != nil {
+			http.Error(w, err.Error(), http.StatusInternalServerError)
+			return
+		}
+		apiResponse.Entries, err = db.GetBillingEntriesByProject(adminDB, thisProject, since)
+		if err != nil {
+			http.Error(w, err.Error(), http.StatusInternalServerError)
+			return
+		}
+
+		apiResponseJSON, err := json.Marshal(apiResponse)
+		if err != nil {
+			http.Error(w, "Error encoding to JSON", http.StatusInternalServerError)
+			return
+		}
+
+		w.Header().Set("Content-Type", "application/json")
+		_, err = w.Write(apiResponseJSON)
+		if err != nil {
+			http.Error(w, "Error writing out JSON", http.StatusNotFound)
+		}
+	})
+
+	// What the project's containers actually used next to what they asked for, per zone, between from and to (RFC
+	// 3339, the last week by default), as format=json or format=csv
+	r.HandleFunc("POST /project/{projectName}/usage-report", func(w http.ResponseWriter, r *http.Request) {
+		projectName := r.PathValue("projectName")
+		value := r.Context().Value(types.Account{})
+		account := value.(types.Account)
+		thisProject, err := db.GetProjectByAccountAndName(adminDB, account, projectName)
+		if err != nil {
+			http.Error(w, err.Error(), http.StatusInternalServerError)
+			return
+		}
+		filter := types.UsageReportFilter{ProjectID: thisProject.ProjectID}
+		err = filter.ParseFromHTTPForm(r)
+		if err != nil {
+			http.Error(w, err.Error(), http.StatusBadRequest)
+			return
+		}
+
+		rows, err := db.GetUsageReport(adminDB, filter)
+		if err != nil {
+			http.Error(w, err.Error(), http.StatusInternalServerError)
+			return
+		}
+		writeUsageReport(w, filter, rows, "usage-"+thisProject.Name)
+	})
+
+	// Same as the project one, for every container the account created in any project
+	r.HandleFunc("POST /usage-report", func(w http.ResponseWriter, r *http.Request) {
+		value := r.Context().Value(types.Account{})
+		account := value.(types.Account)
+		filter := types.UsageReportFilter{AccountID: account.AccountID}
+		err := filter.ParseFromHTTPForm(r)
+		if err != nil {
+			http.Error(w, err.Error(), http.StatusBadRequest)
+			return
+		}
+
+		rows, err := db.GetUsageReport(adminDB, filter)
+		if err != nil {
+			http.Error(w, err.Error(), http.StatusInternalServerError)
+			return
+		}
+		writeUsageReport(w, filter, rows, "usage-"+account.Username)
+	})
+
 	// Get all containers
 	r.HandleFunc("POST /project/{projectName}/get-all-containers", func(w http.ResponseWriter, r *http.Request) {
 		apiResponse := IGetAllContainersResponse{}
@@ -68,7 +195,13 @@ func ContaineropsRouter(log *log.Logger, adminDB *sqlx.DB, config *types.Config,
 		}
 		apiResponse.Container = containerClaim
 
-		logs, err := kubeOps.GetContainerLogs(*log, kubeClients, thisProject, containerClaim)
+		apiResponse.ZoneStatuses, err = db.GetContainerZoneStatuses(adminDB, containerClaim)
+		if err != nil {
+			http.Error(w, err.Error(), http.StatusInternalServerError)
+			return
+		}
+
+		logs, err := kubeOps.GetContainerLogs(*log, zoneRegistry.Zones(), thisProject, containerClaim)
 		if err != nil {
 			http.Error(w, err.Error(), http.StatusInternalServerError)
 			return
@@ -88,10 +221,12 @@ func ContaineropsRouter(log *log.Logger, adminDB *sqlx.DB, config *types.Config,
 		}
 	})
 
-	// Create a container
-	r.HandleFunc("POST /project/{projectName}/create-container", func(w http.ResponseWriter, r *http.Request) {
-		apiResponse := ICreateContainerResponse{}
+	// What the container used per zone next to what it requested, over range=1h|6h|24h|7d|30d (24h by default) or
+	// between from and to (RFC 3339), with its limit hits and OOM kills
+	r.HandleFunc("POST /project/{projectName}/container/{containerName}/metrics", func(w http.ResponseWriter, r *http.Request) {
+		apiResponse := IGetContainerMetricsResponse{}
 		projectName := r.PathValue("projectName")
+		containerName := r.PathValue("containerName")
 		value := r.Context().Value(types.Account{})
 		account := value.(types.Account)
 		thisProject, err := db.GetProjectByAccountAndName(adminDB, account, projectName)
@@ -99,29 +234,108 @@ func ContaineropsRouter(log *log.Logger, adminDB *sqlx.DB, config *types.Config,
 			http.Error(w, err.Error(), http.StatusInternalServerError)
 			return
 		}
+		containerClaim, err := db.GetContainerByProjectAndName(adminDB, thisProject, containerName)
+		if err != nil {
+			http.Error(w, err.Error(), http.StatusInternalServerError)
+			return
+		}
 
-		newContainer := types.ContainerClaim{}
-		newContainer, err = newContainer.ParseContainerFieldsFromHTTPFormZoneProject(r, types.GetZonesFromContainerZones(kubeClients), thisProject.ProjectID)
+		metricsRange := types.MetricsRange{}
+		err = metricsRange.ParseFromHTTPForm(r)
+		if err != nil {
+			http.Error(w, err.Error(), http.StatusBadRequest)
+			return
+		}
+		apiResponse.Range = metricsRange.Name
+		apiResponse.Metrics = kubeOps.GetContainerMetrics(r.Context(), adminDB, zoneRegistry.Zones(), config.PrometheusURLs, thisProject, containerClaim, metricsRange)
+
+		apiResponseJSON, err := json.Marshal(apiResponse)
+		if err != nil {
+			http.Error(w, "Error encoding to JSON", http.StatusInternalServerError)
+			return
+		}
+
+		w.Header().Set("Content-Type", "application/json")
+		_, err = w.Write(apiResponseJSON)
+		if err != nil {
+			http.Error(w, "Error writing out JSON", http.StatusNotFound)
+		}
+	})
+
+	// Create a container
+	r.HandleFunc("POST /project/{projectName}/create-container", func(w http.ResponseWriter, r *http.Request) {
+		apiResponse := ICreateContainerResponse{}
+		projectName := r.PathValue("projectName")
+		value := r.Context().Value(types.Account{})
+		account := value.(types.Account)
+		thisProject, err := db.GetProjectByAccountAndName(adminDB, account, projectName)
 		if err != nil {
 			http.Error(w, err.Error(), http.StatusInternalServerError)
 			return
 		}
 
-		newContainer, err = db.CreateContainerClaimForProject(adminDB, account, thisProject, newContainer)
+		newContainer := types.ContainerClaim{}
+		newContainer, err = newContainer.ParseContainerFieldsFromHTTPFormZoneProject(r, types.GetZonesFromContainerZones(zoneRegistry.Zones()), thisProject.ProjectID)
 		if err != nil {
 			http.Error(w, err.Error(), http.StatusInternalServerError)
 			return
 		}
 
-		// actually go and create the container
-		go func() {
-			err = kubeOps.CreateContainerFromClaim(*log, adminDB, kubeClients, thisProject, newContainer, true)
+		// built from a git repo or an uploaded tarball first, with the container deployed once that's done
+		if r.FormValue("build-source") != "" {
+			newBuild := types.ImageBuild{}
+			err = newBuild.ParseFromHTTPForm(r, int64(config.BuildPolicy.MaxContextMB)<<20)
 			if err != nil {
-				log.Error(err.Error())
+				http.Error(w, err.Error(), http.StatusBadRequest)
 				return
 			}
-		}()
-		apiResponse.Container = newContainer
+			newContainer, newBuild, err = imageBuilds.CreateContainerFromBuild(*log, adminDB, zoneRegistry, *config, account, thisProject, newContainer, newBuild, deployHooks.BaseURL(r))
+			if err != nil {
+				http.Error(w, err.Error(), http.StatusInternalServerError)
+				return
+			}
+			webhooks.EmitContainer(*log, adminDB, thisProject, "container.created", newContainer)
+			apiResponse.Container = newContainer
+			apiResponse.Build = &newBuild
+		} else {
+			// pulled with one of the project's stored credentials instead of the image-pull-secret-* fields, if one's named
+			if pullCredentialName := r.FormValue("pull-credential"); pullCredentialName != "" {
+				credential, err := db.OpenPullCredentialByProjectAndName(adminDB, config.CredentialsKey, thisProject, pullCredentialName)
+				if err != nil {
+					http.Error(w, err.Error(), http.StatusBadRequest)
+					return
+				}
+				newContainer.UsePullCredential(credential)
+			}
+			// images in the zones' registries get pulled with the project's own credential
+			newContainer, err = internalRegistry.AttachPullSecret(adminDB, zoneRegistry.Zones(), config.RegistryPolicy, config.CredentialsKey, thisProject, newContainer)
+			if err != nil {
+				http.Error(w, err.Error(), http.StatusInternalServerError)
+				return
+			}
+			newContainer, err = registryOps.VerifyImage(config.ImagePolicy, newContainer)
+			if err != nil {
+				http.Error(w, err.Error(), registryOps.VerifyImageStatus(err))
+				return
+			}
+
+			newContainer, err = db.CreateContainerClaimForProject(adminDB, account, thisProject, newContainer)
+			if err != nil {
+				http.Error(w, err.Error(), http.StatusInternalServerError)
+				return
+			}
+			webhooks.EmitContainer(*log, adminDB, thisProject, "container.created", newContainer)
+
+			// actually go and create the container
+			go func() {
+				err = kubeOps.CreateContainerFromClaim(*log, adminDB, zoneRegistry.Zones(), config.CredentialsKey, thisProject, newContainer, true)
+				if err != nil {
+					log.Error(err.Error())
+					return
+				}
+			}()
+			apiResponse.Container = newContainer
+		}
 
 		apiResponseJSON, err := json.Marshal(apiResponse)
 		if err != nil {
@@ -163,7 +377,7 @@ func ContaineropsRouter(log *log.Logger, adminDB *sqlx.DB, config *types.Config,
 
 		// actually go and delete the container
 		go func() {
-			err = kubeOps.DeleteContainer(*log, kubeClients, thisProject, thisContainer, false)
+			err = kubeOps.DeleteContainer(*log, zoneRegistry.Zones(), thisProject, thisContainer, false)
 			if err != nil {
 				log.Error(err.Error())
 			}
@@ -173,6 +387,12 @@ func ContaineropsRouter(log *log.Logger, adminDB *sqlx.DB, config *types.Config,
 				log.Error(err.Error())
 				return
 			}
+			webhooks.EmitContainer(*log, adminDB, thisProject, "container.deleted", thisContainer)
+
+			err = db.DeleteDeployHookByProjectAndContainerName(adminDB, thisProject, containerName)
+			if err != nil {
+				log.Error(err.Error())
+			}
 		}()
 		apiResponse.Container = thisContainer
 
@@ -191,7 +411,7 @@ func ContaineropsRouter(log *log.Logger, adminDB *sqlx.DB, config *types.Config,
 
 	// Re-run a container
 	r.HandleFunc("POST /project/{projectName}/container/{containerName}/rerun-once", func(w http.ResponseWriter, r *http.Request) {
-		apiResponse := IDeleteContainerResponse{}
+		apiResponse := IRerunContainerResponse{}
 		projectName := r.PathValue("projectName")
 		value := r.Context().Value(types.Account{})
 		account := value.(types.Account)
@@ -208,15 +428,24 @@ func ContaineropsRouter(log *log.Logger, adminDB *sqlx.DB, config *types.Config,
 			return
 		}
 
-		// retain the same env vars, since we can't recreate them (we don't know the values of the secrets)
 		newContainer := oldContainer
-		for _, envVarName := range oldContainer.EnvVarNames {
-			if strings.HasSuffix(envVarName, "image-pull-secret") { // do the image-pull-secret separately
-				continue
+		newContainer.DeployReason = "rerun"
+
+		// a stored credential's login is known, so the image pull secret gets written again instead of being reused
+		if newContainer.PullCredentialName != nil {
+			credential, err := db.OpenPullCredentialByProjectAndName(adminDB, config.CredentialsKey, thisProject, *newContainer.PullCredentialName)
+			if err != nil {
+				http.Error(w, err.Error(), http.StatusConflict)
+				return
 			}
-			newContainer.EnvVars = append(newContainer.EnvVars, types.EnvVar{Name: envVarName})
+			newContainer.UsePullCredential(credential)
+		}
+		// the digest stays pinned, but the image still has to pass the policy as it is now
+		newContainer, err = registryOps.VerifyImage(config.ImagePolicy, newContainer)
+		if err != nil {
+			http.Error(w, err.Error(), registryOps.VerifyImageStatus(err))
+			return
 		}
-		newContainer.ImagePullSecret = &types.ImagePullSecret{}
 
 		err = db.SetContainerAsDeactivating(adminDB, oldContainer)
 		if err != nil {
@@ -226,23 +455,170 @@ func ContaineropsRouter(log *log.Logger, adminDB *sqlx.DB, config *types.Config,
 
 		// delete the old container, then instantiate the new one
 		go func() {
-			err = kubeOps.DeleteContainer(*log, kubeClients, thisProject, oldContainer, true)
-			if err != nil {
-				log.Error(err.Error())
-				return
-			}
-			err = db.DeleteContainerByProjectAndName(adminDB, thisProject, containerName)
+			err := kubeOps.RecreateContainer(*log, adminDB, zoneRegistry.Zones(), config.CredentialsKey, account, thisProject, oldContainer, newContainer)
 			if err != nil {
 				log.Error(err.Error())
 				return
 			}
+		}()
+		apiResponse.Container = newContainer
 
-			newContainer, err = db.CreateContainerClaimForProject(adminDB, account, thisProject, newContainer)
+		apiResponseJSON, err := json.Marshal(apiResponse)
+		if err != nil {
+			http.Error(w, "Error encoding to JSON", http.StatusInternalServerError)
+			return
+		}
+
+		w.Header().Set("Content-Type", "application/json")
+		_, err = w.Write(apiResponseJSON)
+		if err != nil {
+			http.Error(w, "Error writing out JSON", http.StatusNotFound)
+		}
+	})
+	// Get the deploy history of a container
+	r.HandleFunc("POST /project/{projectName}/container/{containerName}/revisions", func(w http.ResponseWriter, r *http.Request) {
+		apiResponse := IGetContainerRevisionsResponse{}
+		projectName := r.PathValue("projectName")
+		containerName := r.PathValue("containerName")
+		value := r.Context().Value(types.Account{})
+		account := value.(types.Account)
+		thisProject, err := db.GetProjectByAccountAndName(adminDB, account, projectName)
+		if err != nil {
+			http.Error(w, err.Error(), http.StatusInternalServerError)
+			return
+		}
+		revisions, err := db.GetContainerRevisionsByProjectAndName(adminDB, thisProject, containerName)
+		if err != nil {
+			http.Error(w, err.Error(), http.StatusInternalServerError)
+			return
+		}
+		apiResponse.Revisions = revisions
+
+		apiResponseJSON, err := json.Marshal(apiResponse)
+		if err != nil {
+			http.Error(w, "Error encoding to JSON", http.StatusInternalServerError)
+			return
+		}
+
+		w.Header().Set("Content-Type", "application/json")
+		_, err = w.Write(apiResponseJSON)
+		if err != nil {
+			http.Error(w, "Error writing out JSON", http.StatusNotFound)
+		}
+	})
+
+	// Diff two revisions of a container, ie. ?from=3&to=5
+	r.HandleFunc("POST /project/{projectName}/container/{containerName}/revisions/diff", func(w http.ResponseWriter, r *http.Request) {
+		apiResponse := IDiffContainerRevisionsResponse{}
+		projectName := r.PathValue("projectName")
+		containerName := r.PathValue("containerName")
+		value := r.Context().Value(types.Account{})
+		account := value.(types.Account)
+		fromRevision, err := strconv.Atoi(r.FormValue("from"))
+		if err != nil {
+			http.Error(w, "from has to be a revision number", http.StatusBadRequest)
+			return
+		}
+		toRevision, err := strconv.Atoi(r.FormValue("to"))
+		if err != nil {
+			http.Error(w, "to has to be a revision number", http.StatusBadRequest)
+			return
+		}
+		thisProject, err := db.GetProjectByAccountAndName(adminDB, account, projectName)
+		if err != nil {
+			http.Error(w, err.Error(), http.StatusInternalServerError)
+			return
+		}
+		apiResponse.From, err = db.GetContainerRevision(adminDB, thisProject, containerName, fromRevision)
+		if err != nil {
+			http.Error(w, err.Error(), http.StatusNotFound)
+			return
+		}
+		apiResponse.To, err = db.GetContainerRevision(adminDB, thisProject, containerName, toRevision)
+		if err != nil {
+			http.Error(w, err.Error(), http.StatusNotFound)
+			return
+		}
+		apiResponse.Changes = apiResponse.From.Diff(apiResponse.To)
+
+		apiResponseJSON, err := json.Marshal(apiResponse)
+		if err != nil {
+			http.Error(w, "Error encoding to JSON", http.StatusInternalServerError)
+			return
+		}
+
+		w.Header().Set("Content-Type", "application/json")
+		_, err = w.Write(apiResponseJSON)
+		if err != nil {
+			http.Error(w, "Error writing out JSON", http.StatusNotFound)
+		}
+	})
+
+	// Roll a container back to an earlier revision
+	r.HandleFunc("POST /project/{projectName}/container/{containerName}/rollback/{revision}", func(w http.ResponseWriter, r *http.Request) {
+		apiResponse := IRollbackContainerResponse{}
+		projectName := r.PathValue("projectName")
+		containerName := r.PathValue("containerName")
+		value := r.Context().Value(types.Account{})
+		account := value.(types.Account)
+		revisionNumber, err := strconv.Atoi(r.PathValue("revision"))
+		if err != nil {
+			http.Error(w, "revision has to be a number", http.StatusBadRequest)
+			return
+		}
+		thisProject, err := db.GetProjectByAccountAndName(adminDB, account, projectName)
+		if err != nil {
+			http.Error(w, err.Error(), http.StatusInternalServerError)
+			return
+		}
+
+		oldContainer, err := db.GetContainerByProjectAndName(adminDB, thisProject, containerName)
+		if err != nil {
+			http.Error(w, err.Error(), http.StatusInternalServerError)
+			return
+		}
+		revision, err := db.GetContainerRevision(adminDB, thisProject, containerName, revisionNumber)
+		if err != nil {
+			http.Error(w, err.Error(), http.StatusNotFound)
+			return
+		}
+		if missing := revision.MissingEnvVarNames(oldContainer); len(missing) > 0 {
+			http.Error(w, fmt.Sprintf("Can't roll back, the secrets for %s don't exist anymore", strings.Join(missing, ", ")), http.StatusConflict)
+			return
+		}
+		// its env var and image pull secrets get reused as they are, and they only exist in the zones it's in now
+		if zones := revision.ZonesNotIn(oldContainer); len(zones) > 0 {
+			http.Error(w, fmt.Sprintf("Can't roll back, the revision ran in %s, which the container isn't in anymore", strings.Join(zones, ", ")), http.StatusConflict)
+			return
+		}
+
+		newContainer := revision.AppliedTo(oldContainer)
+		newContainer.DeployReason = fmt.Sprintf("rollback to revision %d", revision.Revision)
+
+		// a stored credential's login is known, so the image pull secret gets written again instead of being reused
+		if newContainer.PullCredentialName != nil {
+			credential, err := db.OpenPullCredentialByProjectAndName(adminDB, config.CredentialsKey, thisProject, *newContainer.PullCredentialName)
 			if err != nil {
-				http.Error(w, err.Error(), http.StatusInternalServerError)
+				http.Error(w, err.Error(), http.StatusConflict)
 				return
 			}
-			err = kubeOps.CreateContainerFromClaim(*log, adminDB, kubeClients, thisProject, newContainer, true)
+			newContainer.UsePullCredential(credential)
+		}
+		// the digest stays pinned, but the image still has to pass the policy as it is now
+		newContainer, err = registryOps.VerifyImage(config.ImagePolicy, newContainer)
+		if err != nil {
+			http.Error(w, err.Error(), registryOps.VerifyImageStatus(err))
+			return
+		}
+
+		err = db.SetContainerAsDeactivating(adminDB, oldContainer)
+		if err != nil {
+			http.Error(w, err.Error(), http.StatusInternalServerError)
+			return
+		}
+
+		go func() {
+			err := kubeOps.RecreateContainer(*log, adminDB, zoneRegistry.Zones(), config.CredentialsKey, account, thisProject, oldContainer, newContainer)
 			if err != nil {
 				log.Error(err.Error())
 				return
@@ -263,5 +639,178 @@ func ContaineropsRouter(log *log.Logger, adminDB *sqlx.DB, config *types.Config,
 		}
 	})
 	// ...
+	// The container's deploy hook and its secret URL, with deploy_hook being null if it has none
+	r.HandleFunc("POST /project/{projectName}/container/{containerName}/deploy-hook", func(w http.ResponseWriter, r *http.Request) {
+		apiResponse := IDeployHookResponse{}
+		projectName := r.PathValue("projectName")
+		containerName := r.PathValue("containerName")
+		value := r.Context().Value(types.Account{})
+		account := value.(types.Account)
+		thisProject, err := db.GetProjectByAccountAndName(adminDB, account, projectName)
+		if err != nil {
+			http.Error(w, err.Error(), http.StatusInternalServerError)
+			return
+		}
+		hook, err := db.GetDeployHookByProjectAndContainerName(adminDB, thisProject, containerName)
+		if err == nil {
+			apiResponse.DeployHook = &hook
+			apiResponse.URL = hook.URL(deployHooks.BaseURL(r))
+		}
+
+		apiResponseJSON, err := json.Marshal(apiResponse)
+		if err != nil {
+			http.Error(w, "Error encoding to JSON", http.StatusInternalServerError)
+			return
+		}
+
+		w.Header().Set("Content-Type", "application/json")
+		_, err = w.Write(apiResponseJSON)
+		if err != nil {
+			http.Error(w, "Error writing out JSON", http.StatusNotFound)
+		}
+	})
+
+	// Gives the container a deploy hook, or a new URL for the one it has, with follow-tag=true to also deploy its
+	// tag's new digests as they show up in the registry
+	r.HandleFunc("POST /project/{projectName}/container/{containerName}/deploy-hook/create", func(w http.ResponseWriter, r *http.Request) {
+		apiResponse := IDeployHookResponse{}
+		projectName := r.PathValue("projectName")
+		containerName := r.PathValue("containerName")
+		value := r.Context().Value(types.Account{})
+		account := value.(types.Account)
+		thisProject, err := db.GetProjectByAccountAndName(adminDB, account, projectName)
+		if err != nil {
+			http.Error(w, err.Error(), http.StatusInternalServerError)
+			return
+		}
+		thisContainer, err := db.GetContainerByProjectAndName(adminDB, thisProject, containerName)
+		if err != nil {
+			http.Error(w, err.Error(), http.StatusNotFound)
+			return
+		}
+
+		followTag := r.FormValue("follow-tag") == "true" || r.FormValue("follow-tag") == "on"
+		hook, err := db.CreateDeployHookForContainer(adminDB, account, thisProject, thisContainer, followTag)
+		if err != nil {
+			http.Error(w, err.Error(), http.StatusInternalServerError)
+			return
+		}
+		apiResponse.DeployHook = &hook
+		apiResponse.URL = hook.URL(deployHooks.BaseURL(r))
+
+		apiResponseJSON, err := json.Marshal(apiResponse)
+		if err != nil {
+			http.Error(w, "Error encoding to JSON", http.StatusInternalServerError)
+			return
+		}
+
+		w.Header().Set("Content-Type", "application/json")
+		_, err = w.Write(apiResponseJSON)
+		if err != nil {
+			http.Error(w, "Error writing out JSON", http.StatusNotFound)
+		}
+	})
+
+	// Turn following the tag on or off with follow-tag=true|false
+	r.HandleFunc("POST /project/{projectName}/container/{containerName}/deploy-hook/follow-tag", func(w http.ResponseWriter, r *http.Request) {
+		apiResponse := IDeployHookResponse{}
+		projectName := r.PathValue("projectName")
+		containerName := r.PathValue("containerName")
+		value := r.Context().Value(types.Account{})
+		account := value.(types.Account)
+		thisProject, err := db.GetProjectByAccountAndName(adminDB, account, projectName)
+		if err != nil {
+			http.Error(w, err.Error(), http.StatusInternalServerError)
+			return
+		}
+		hook, err := db.GetDeployHookByProjectAndContainerName(adminDB, thisProject, containerName)
+		if err != nil {
+			http.Error(w, fmt.Sprintf("Container %s has no deploy hook", containerName), http.StatusNotFound)
+			return
+		}
+
+		followTag := r.FormValue("follow-tag") == "true" || r.FormValue("follow-tag") == "on"
+		hook, err = db.SetDeployHookFollowTag(adminDB, hook, followTag)
+		if err != nil {
+			http.Error(w, err.Error(), http.StatusInternalServerError)
+			return
+		}
+		apiResponse.DeployHook = &hook
+		apiResponse.URL = hook.URL(deployHooks.BaseURL(r))
+
+		apiResponseJSON, err := json.Marshal(apiResponse)
+		if err != nil {
+			http.Error(w, "Error encoding to JSON", http.StatusInternalServerError)
+			return
+		}
+
+		w.Header().Set("Content-Type", "application/json")
+		_, err = w.Write(apiResponseJSON)
+		if err != nil {
+			http.Error(w, "Error writing out JSON", http.StatusNotFound)
+		}
+	})
+
+	r.HandleFunc("POST /project/{projectName}/container/{containerName}/deploy-hook/delete", func(w http.ResponseWriter, r *http.Request) {
+		apiResponse := IDeployHookResponse{}
+		projectName := r.PathValue("projectName")
+		containerName := r.PathValue("containerName")
+		value := r.Context().Value(types.Account{})
+		account := value.(types.Account)
+		thisProject, err := db.GetProjectByAccountAndName(adminDB, account, projectName)
+		if err != nil {
+			http.Error(w, err.Error(), http.StatusInternalServerError)
+			return
+		}
+		hook, err := db.GetDeployHookByProjectAndContainerName(adminDB, thisProject, containerName)
+		if err != nil {
+			http.Error(w, fmt.Sprintf("Container %s has no deploy hook", containerName), http.StatusNotFound)
+			return
+		}
+
+		err = db.DeleteDeployHookByProjectAndContainerName(adminDB, thisProject, containerName)
+		if err != nil {
+			http.Error(w, err.Error(), http.StatusInternalServerError)
+			return
+		}
+		apiResponse.DeployHook = &hook
+
+		apiResponseJSON, err := json.Marshal(apiResponse)
+		if err != nil {
+			http.Error(w, "Error encoding to JSON", http.StatusInternalServerError)
+			return
+		}
+
+		w.Header().Set("Content-Type", "application/json")
+		_, err = w.Write(apiResponseJSON)
+		if err != nil {
+			http.Error(w, "Error writing out JSON", http.StatusNotFound)
+		}
+	})
+
 	return r
 }
+
+func writeUsageReport(w http.ResponseWriter, filter types.UsageReportFilter, rows []types.UsageReportRow, fileName string) {
+	if filter.Format == "csv" {
+		w.Header().Set("Content-Type", "text/csv")
+		w.Header().Set("Content-Disposition", fmt.Sprintf("attachment; filename=%q", fmt.Sprintf("%s-%s-%s.csv", fileName, filter.From.Format("20060102"), filter.To.Format("20060102"))))
+		err := types.WriteUsageReportCSV(w, rows)
+		if err != nil {
+			http.Error(w, "Error writing out CSV", http.StatusNotFound)
+		}
+		return
+	}
+
+	apiResponseJSON, err := json.Marshal(IUsageReportResponse{From: filter.From, To: filter.To, Rows: rows})
+	if err != nil {
+		http.Error(w, "Error encoding to JSON", http.StatusInternalServerError)
+		return
+	}
+
+	w.Header().Set("Content-Type", "application/json")
+	_, err = w.Write(apiResponseJSON)
+	if err != nil {
+		http.Error(w, "Error writing out JSON", http.StatusNotFound)
+	}
+}
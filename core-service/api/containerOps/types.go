@@ -1,6 +1,8 @@
 package containerOps
 
 import (
+	"time"
+
 	"github.com/lu1a/lcaas/core-service/kubeOps"
 	"github.com/lu1a/lcaas/core-service/types"
 )
@@ -18,8 +20,9 @@ Route: /api/project/{projectName}/container/{containerName}
 Type: query
 */
 type IGetContainerResponse struct {
-	Container    types.ContainerClaim  `json:"container"`
-	LogsForZones []kubeOps.LogsForZone `json:"logs"`
+	Container    types.ContainerClaim        `json:"container"`
+	ZoneStatuses []types.ContainerZoneStatus `json:"zone_statuses"`
+	LogsForZones []kubeOps.LogsForZone       `json:"logs"`
 }
 
 /*
@@ -28,6 +31,7 @@ Type: query
 */
 type ICreateContainerResponse struct {
 	Container types.ContainerClaim `json:"container"`
+	Build     *types.ImageBuild    `json:"build"` // what the container's waiting on, if its image is being built
 }
 
 /*
@@ -45,3 +49,76 @@ Type: query
 type IRerunContainerResponse struct {
 	Container types.ContainerClaim `json:"container"`
 }
+
+/*
+Route: /api/project/{projectName}/container/{containerName}/revisions
+Type: query
+*/
+type IGetContainerRevisionsResponse struct {
+	Revisions []types.ContainerRevision `json:"revisions"`
+}
+
+/*
+Route: /api/project/{projectName}/container/{containerName}/revisions/diff?from={revision}&to={revision}
+Type: query
+*/
+type IDiffContainerRevisionsResponse struct {
+	From    types.ContainerRevision     `json:"from"`
+	To      types.ContainerRevision     `json:"to"`
+	Changes []types.RevisionFieldChange `json:"changes"`
+}
+
+/*
+Route: /api/project/{projectName}/container/{containerName}/rollback/{revision}
+Type: query
+*/
+type IRollbackContainerResponse struct {
+	Container types.ContainerClaim `json:"container"`
+}
+
+/*
+Route: /api/quota
+Type: query
+*/
+type IGetQuotaResponse struct {
+	Quotas       []types.ResourceQuota `json:"quotas"`
+	Explanations []string              `json:"explanations"` // one per zone, ie. "You have 400m of 2000m CPU and ... left in zone fi-hel1 (...)"
+}
+
+/*
+Route: /api/project/{projectName}/billing
+Type: query
+*/
+type IGetBillingResponse struct {
+	Balance     float64              `json:"balance"`
+	SuspendedAt *time.Time           `json:"suspended_at"` // set while the project can't start anything new for running out of credits
+	Entries     []types.BillingEntry `json:"entries"`      // newest first
+}
+
+/*
+Route: /api/project/{projectName}/container/{containerName}/metrics
+Type: query
+*/
+type IGetContainerMetricsResponse struct {
+	Range   string                 `json:"range,omitempty"` // empty when from and to were given
+	Metrics types.ContainerMetrics `json:"metrics"`
+}
+
+/*
+Route: /api/project/{projectName}/usage-report, /api/usage-report
+Type: query
+*/
+type IUsageReportResponse struct {
+	From time.Time              `json:"from"`
+	To   time.Time              `json:"to"`
+	Rows []types.UsageReportRow `json:"rows"` // one per container per zone
+}
+
+/*
+Route: /api/project/{projectName}/container/{containerName}/deploy-hook, and its create, follow-tag and delete
+Type: query, and mutation for the rest
+*/
+type IDeployHookResponse struct {
+	DeployHook *types.DeployHook `json:"deploy_hook"`
+	URL        string            `json:"url,omitempty"` // secret, anyone with it can deploy the container
+}
@@ -0,0 +1,341 @@
+package imageRegistryOps
+
+import (
+	"encoding/json"
+	"fmt"
+	"net/http"
+
+	"github.com/lu1a/lcaas/core-service/db"
+	"github.com/lu1a/lcaas/core-service/internalRegistry"
+	"github.com/lu1a/lcaas/core-service/kubeOps"
+	"github.com/lu1a/lcaas/core-service/registryOps"
+	"github.com/lu1a/lcaas/core-service/types"
+
+	"github.com/charmbracelet/log"
+
+	"github.com/jmoiron/sqlx"
+)
+
+func ImageRegistryOpsRouter(log *log.Logger, adminDB *sqlx.DB, zoneRegistry *kubeOps.ZoneRegistry, config *types.Config) *http.ServeMux {
+	r := http.NewServeMux()
+	// Everything's POST here too, same as containerOps. The registries themselves are logged in to with docker.
+
+	// What the project has in the zones' registries, as of the registry manager's last look
+	r.HandleFunc("POST /project/{projectName}/registry/images", func(w http.ResponseWriter, r *http.Request) {
+		apiResponse := IGetRegistryImagesResponse{}
+		projectName := r.PathValue("projectName")
+		value := r.Context().Value(types.Account{})
+		account := value.(types.Account)
+		thisProject, err := db.GetProjectByAccountAndName(adminDB, account, projectName)
+		if err != nil {
+			http.Error(w, err.Error(), http.StatusInternalServerError)
+			return
+		}
+
+		apiResponse.RepositoryPrefixes = internalRegistry.RepositoryPrefixes(zoneRegistry.Zones(), thisProject)
+		apiResponse.Usage, err = internalRegistry.ProjectUsage(adminDB, config.RegistryPolicy, thisProject)
+		if err != nil {
+			http.Error(w, err.Error(), http.StatusInternalServerError)
+			return
+		}
+		apiResponse.Images, err = db.GetRegistryImagesByProject(adminDB, thisProject)
+		if err != nil {
+			http.Error(w, err.Error(), http.StatusInternalServerError)
+			return
+		}
+
+		apiResponseJSON, err := json.Marshal(apiResponse)
+		if err != nil {
+			http.Error(w, "Error encoding to JSON", http.StatusInternalServerError)
+			return
+		}
+
+		w.Header().Set("Content-Type", "application/json")
+		_, err = w.Write(apiResponseJSON)
+		if err != nil {
+			http.Error(w, "Error writing out JSON", http.StatusNotFound)
+		}
+	})
+
+	r.HandleFunc("POST /project/{projectName}/registry/credentials", func(w http.ResponseWriter, r *http.Request) {
+		apiResponse := IGetRegistryCredentialsResponse{}
+		projectName := r.PathValue("projectName")
+		value := r.Context().Value(types.Account{})
+		account := value.(types.Account)
+		thisProject, err := db.GetProjectByAccountAndName(adminDB, account, projectName)
+		if err != nil {
+			http.Error(w, err.Error(), http.StatusInternalServerError)
+			return
+		}
+
+		apiResponse.Credentials, err = db.GetRegistryCredentialsByProject(adminDB, thisProject)
+		if err != nil {
+			http.Error(w, err.Error(), http.StatusInternalServerError)
+			return
+		}
+
+		apiResponseJSON, err := json.Marshal(apiResponse)
+		if err != nil {
+			http.Error(w, "Error encoding to JSON", http.StatusInternalServerError)
+			return
+		}
+
+		w.Header().Set("Content-Type", "application/json")
+		_, err = w.Write(apiResponseJSON)
+		if err != nil {
+			http.Error(w, "Error writing out JSON", http.StatusNotFound)
+		}
+	})
+
+	// name=ci, and push=true for one that can push too, ie. for CI outside of here
+	r.HandleFunc("POST /project/{projectName}/registry/credentials/create", func(w http.ResponseWriter, r *http.Request) {
+		apiResponse := ICreateRegistryCredentialResponse{}
+		projectName := r.PathValue("projectName")
+		value := r.Context().Value(types.Account{})
+		account := value.(types.Account)
+		thisProject, err := db.GetProjectByAccountAndName(adminDB, account, projectName)
+		if err != nil {
+			http.Error(w, err.Error(), http.StatusInternalServerError)
+			return
+		}
+
+		newCredential := types.RegistryCredential{}
+		err = newCredential.ParseFromHTTPForm(r)
+		if err != nil {
+			http.Error(w, err.Error(), http.StatusBadRequest)
+			return
+		}
+
+		apiResponse.Credential, err = db.CreateRegistryCredentialForProject(adminDB, config.CredentialsKey, thisProject, newCredential)
+		if err != nil {
+			http.Error(w, err.Error(), http.StatusInternalServerError)
+			return
+		}
+		apiResponse.Password = apiResponse.Credential.Password
+
+		apiResponseJSON, err := json.Marshal(apiResponse)
+		if err != nil {
+			http.Error(w, "Error encoding to JSON", http.StatusInternalServerError)
+			return
+		}
+
+		w.Header().Set("Content-Type", "application/json")
+		_, err = w.Write(apiResponseJSON)
+		if err != nil {
+			http.Error(w, "Error writing out JSON", http.StatusNotFound)
+		}
+	})
+
+	r.HandleFunc("POST /project/{projectName}/registry/credential/{credentialName}/delete", func(w http.ResponseWriter, r *http.Request) {
+		apiResponse := IDeleteRegistryCredentialResponse{}
+		credentialName := r.PathValue("credentialName")
+		projectName := r.PathValue("projectName")
+		value := r.Context().Value(types.Account{})
+		account := value.(types.Account)
+		thisProject, err := db.GetProjectByAccountAndName(adminDB, account, projectName)
+		if err != nil {
+			http.Error(w, err.Error(), http.StatusInternalServerError)
+			return
+		}
+		credential := types.RegistryCredential{Name: credentialName}
+		if credential.IsManaged() {
+			http.Error(w, "The lcaas- credentials are what the project's own containers and builds log in with, so they can't be deleted", http.StatusBadRequest)
+			return
+		}
+
+		err = db.DeleteRegistryCredentialByProjectAndName(adminDB, thisProject, credentialName)
+		if err != nil {
+			http.Error(w, err.Error(), http.StatusNotFound)
+			return
+		}
+		apiResponse.CredentialName = credentialName
+
+		apiResponseJSON, err := json.Marshal(apiResponse)
+		if err != nil {
+			http.Error(w, "Error encoding to JSON", http.StatusInternalServerError)
+			return
+		}
+
+		w.Header().Set("Content-Type", "application/json")
+		_, err = w.Write(apiResponseJSON)
+		if err != nil {
+			http.Error(w, "Error writing out JSON", http.StatusNotFound)
+		}
+	})
+
+	// Logins for other registries, to be picked for new containers. Only what's needed to recognise them comes back out.
+	r.HandleFunc("POST /project/{projectName}/registry/pull-credentials", func(w http.ResponseWriter, r *http.Request) {
+		apiResponse := IGetPullCredentialsResponse{}
+		projectName := r.PathValue("projectName")
+		value := r.Context().Value(types.Account{})
+		account := value.(types.Account)
+		thisProject, err := db.GetProjectByAccountAndName(adminDB, account, projectName)
+		if err != nil {
+			http.Error(w, err.Error(), http.StatusInternalServerError)
+			return
+		}
+
+		apiResponse.PullCredentials, err = db.GetPullCredentialsByProject(adminDB, thisProject)
+		if err != nil {
+			http.Error(w, err.Error(), http.StatusInternalServerError)
+			return
+		}
+
+		apiResponseJSON, err := json.Marshal(apiResponse)
+		if err != nil {
+			http.Error(w, "Error encoding to JSON", http.StatusInternalServerError)
+			return
+		}
+
+		w.Header().Set("Content-Type", "application/json")
+		_, err = w.Write(apiResponseJSON)
+		if err != nil {
+			http.Error(w, "Error writing out JSON", http.StatusNotFound)
+		}
+	})
+
+	// Logs in to the registry's /v2/ with it first, so that a typo doesn't turn up as a container that can't pull
+	r.HandleFunc("POST /project/{projectName}/registry/pull-credentials/create", func(w http.ResponseWriter, r *http.Request) {
+		apiResponse := ICreatePullCredentialResponse{}
+		projectName := r.PathValue("projectName")
+		value := r.Context().Value(types.Account{})
+		account := value.(types.Account)
+		thisProject, err := db.GetProjectByAccountAndName(adminDB, account, projectName)
+		if err != nil {
+			http.Error(w, err.Error(), http.StatusInternalServerError)
+			return
+		}
+
+		newCredential := types.PullCredential{}
+		err = newCredential.ParseFromHTTPForm(r, true)
+		if err != nil {
+			http.Error(w, err.Error(), http.StatusBadRequest)
+			return
+		}
+		err = registryOps.CheckLogin(newCredential.URL, newCredential.ImagePullSecret())
+		if err != nil {
+			http.Error(w, err.Error(), http.StatusBadRequest)
+			return
+		}
+		newCredential.ProjectID = thisProject.ProjectID
+		err = newCredential.Seal(config.CredentialsKey)
+		if err != nil {
+			http.Error(w, err.Error(), http.StatusInternalServerError)
+			return
+		}
+
+		apiResponse.PullCredential, err = db.CreatePullCredentialForProject(adminDB, thisProject, newCredential)
+		if err != nil {
+			http.Error(w, err.Error(), http.StatusInternalServerError)
+			return
+		}
+
+		apiResponseJSON, err := json.Marshal(apiResponse)
+		if err != nil {
+			http.Error(w, "Error encoding to JSON", http.StatusInternalServerError)
+			return
+		}
+
+		w.Header().Set("Content-Type", "application/json")
+		_, err = w.Write(apiResponseJSON)
+		if err != nil {
+			http.Error(w, "Error writing out JSON", http.StatusNotFound)
+		}
+	})
+
+	// Takes a new login for the same registry, and puts it in the pull secret of every container using the credential
+	r.HandleFunc("POST /project/{projectName}/registry/pull-credential/{credentialName}/rotate", func(w http.ResponseWriter, r *http.Request) {
+		apiResponse := IRotatePullCredentialResponse{}
+		credentialName := r.PathValue("credentialName")
+		projectName := r.PathValue("projectName")
+		value := r.Context().Value(types.Account{})
+		account := value.(types.Account)
+		thisProject, err := db.GetProjectByAccountAndName(adminDB, account, projectName)
+		if err != nil {
+			http.Error(w, err.Error(), http.StatusInternalServerError)
+			return
+		}
+		credential, err := db.OpenPullCredentialByProjectAndName(adminDB, config.CredentialsKey, thisProject, credentialName)
+		if err != nil {
+			http.Error(w, err.Error(), http.StatusNotFound)
+			return
+		}
+
+		err = credential.ParseFromHTTPForm(r, false)
+		if err != nil {
+			http.Error(w, err.Error(), http.StatusBadRequest)
+			return
+		}
+		err = registryOps.CheckLogin(credential.URL, credential.ImagePullSecret())
+		if err != nil {
+			http.Error(w, err.Error(), http.StatusBadRequest)
+			return
+		}
+		err = credential.Seal(config.CredentialsKey)
+		if err != nil {
+			http.Error(w, err.Error(), http.StatusInternalServerError)
+			return
+		}
+
+		apiResponse.PullCredential, err = kubeOps.RotatePullCredential(*log, adminDB, zoneRegistry.Zones(), thisProject, credential)
+		if err != nil {
+			http.Error(w, err.Error(), http.StatusInternalServerError)
+			return
+		}
+
+		apiResponseJSON, err := json.Marshal(apiResponse)
+		if err != nil {
+			http.Error(w, "Error encoding to JSON", http.StatusInternalServerError)
+			return
+		}
+
+		w.Header().Set("Content-Type", "application/json")
+		_, err = w.Write(apiResponseJSON)
+		if err != nil {
+			http.Error(w, "Error writing out JSON", http.StatusNotFound)
+		}
+	})
+
+	r.HandleFunc("POST /project/{projectName}/registry/pull-credential/{credentialName}/delete", func(w http.ResponseWriter, r *http.Request) {
+		apiResponse := IDeletePullCredentialResponse{}
+		credentialName := r.PathValue("credentialName")
+		projectName := r.PathValue("projectName")
+		value := r.Context().Value(types.Account{})
+		account := value.(types.Account)
+		thisProject, err := db.GetProjectByAccountAndName(adminDB, account, projectName)
+		if err != nil {
+			http.Error(w, err.Error(), http.StatusInternalServerError)
+			return
+		}
+
+		containers, err := db.GetContainersUsingPullCredential(adminDB, thisProject, credentialName)
+		if err != nil {
+			http.Error(w, err.Error(), http.StatusInternalServerError)
+			return
+		}
+		if len(containers) > 0 {
+			http.Error(w, fmt.Sprintf("%s is still used by %v container(s), delete them first", credentialName, len(containers)), http.StatusConflict)
+			return
+		}
+		err = db.DeletePullCredentialByProjectAndName(adminDB, thisProject, credentialName)
+		if err != nil {
+			http.Error(w, err.Error(), http.StatusNotFound)
+			return
+		}
+		apiResponse.CredentialName = credentialName
+
+		apiResponseJSON, err := json.Marshal(apiResponse)
+		if err != nil {
+			http.Error(w, "Error encoding to JSON", http.StatusInternalServerError)
+			return
+		}
+
+		w.Header().Set("Content-Type", "application/json")
+		_, err = w.Write(apiResponseJSON)
+		if err != nil {
+			http.Error(w, "Error writing out JSON", http.StatusNotFound)
+		}
+	})
+
+	return r
+}
@@ -0,0 +1,72 @@
+package imageRegistryOps
+
+import (
+	"github.com/lu1a/lcaas/core-service/types"
+)
+
+/*
+Route: /api/project/{projectName}/registry/images
+Type: query
+*/
+type IGetRegistryImagesResponse struct {
+	RepositoryPrefixes []string              `json:"repository_prefixes"` // one per zone with a registry, push to anything under them
+	Usage              []types.RegistryUsage `json:"usage"`
+	Images             []types.RegistryImage `json:"images"`
+}
+
+/*
+Route: /api/project/{projectName}/registry/credentials
+Type: query
+*/
+type IGetRegistryCredentialsResponse struct {
+	Credentials []types.RegistryCredential `json:"credentials"`
+}
+
+/*
+Route: /api/project/{projectName}/registry/credentials/create
+Type: mutation
+*/
+type ICreateRegistryCredentialResponse struct {
+	Credential types.RegistryCredential `json:"credential"`
+	Password   string                   `json:"password"` // only ever given out here, it's kept encrypted
+}
+
+/*
+Route: /api/project/{projectName}/registry/credential/{credentialName}/delete
+Type: mutation
+*/
+type IDeleteRegistryCredentialResponse struct {
+	CredentialName string `json:"credential_name"`
+}
+
+/*
+Route: /api/project/{projectName}/registry/pull-credentials
+Type: query
+*/
+type IGetPullCredentialsResponse struct {
+	PullCredentials []types.PullCredential `json:"pull_credentials"`
+}
+
+/*
+Route: /api/project/{projectName}/registry/pull-credentials/create
+Type: mutation
+*/
+type ICreatePullCredentialResponse struct {
+	PullCredential types.PullCredential `json:"pull_credential"`
+}
+
+/*
+Route: /api/project/{projectName}/registry/pull-credential/{credentialName}/rotate
+Type: mutation
+*/
+type IRotatePullCredentialResponse struct {
+	PullCredential types.PullCredential `json:"pull_credential"`
+}
+
+/*
+Route: /api/project/{projectName}/registry/pull-credential/{credentialName}/delete
+Type: mutation
+*/
+type IDeletePullCredentialResponse struct {
+	CredentialName string `json:"credential_name"`
+}
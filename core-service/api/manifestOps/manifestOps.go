@@ -0,0 +1,124 @@
+package manifestOps
+
+import (
+	"encoding/json"
+	"errors"
+	"io"
+	"net/http"
+
+	"github.com/lu1a/lcaas/core-service/db"
+	"github.com/lu1a/lcaas/core-service/kubeOps"
+	"github.com/lu1a/lcaas/core-service/manifests"
+	"github.com/lu1a/lcaas/core-service/types"
+
+	"github.com/charmbracelet/log"
+
+	"github.com/jmoiron/sqlx"
+	"sigs.k8s.io/yaml"
+)
+
+const maxManifestBytes = 1 << 20
+
+func ManifestOpsRouter(log *log.Logger, adminDB *sqlx.DB, config *types.Config, zoneRegistry *kubeOps.ZoneRegistry) *http.ServeMux {
+	r := http.NewServeMux()
+	// The one GET in the API, since exporting and applying are the same route
+
+	// The project's containers, database and buckets as a manifest, as format=yaml or JSON by default
+	r.HandleFunc("GET /project/{projectName}/apply", func(w http.ResponseWriter, r *http.Request) {
+		projectName := r.PathValue("projectName")
+		value := r.Context().Value(types.Account{})
+		account := value.(types.Account)
+		thisProject, err := db.GetProjectByAccountAndName(adminDB, account, projectName)
+		if err != nil {
+			http.Error(w, err.Error(), http.StatusInternalServerError)
+			return
+		}
+		manifest, err := manifests.Export(adminDB, thisProject)
+		if err != nil {
+			http.Error(w, err.Error(), http.StatusInternalServerError)
+			return
+		}
+
+		if r.FormValue("format") == "yaml" {
+			manifestYAML, err := yaml.Marshal(manifest)
+			if err != nil {
+				http.Error(w, "Error encoding to YAML", http.StatusInternalServerError)
+				return
+			}
+
+			w.Header().Set("Content-Type", "application/yaml")
+			_, err = w.Write(manifestYAML)
+			if err != nil {
+				http.Error(w, "Error writing out YAML", http.StatusNotFound)
+			}
+			return
+		}
+
+		apiResponseJSON, err := json.Marshal(IExportManifestResponse(manifest))
+		if err != nil {
+			http.Error(w, "Error encoding to JSON", http.StatusInternalServerError)
+			return
+		}
+
+		w.Header().Set("Content-Type", "application/json")
+		_, err = w.Write(apiResponseJSON)
+		if err != nil {
+			http.Error(w, "Error writing out JSON", http.StatusNotFound)
+		}
+	})
+
+	// Takes a manifest in YAML or JSON as the body, and makes the project look like it. With dry-run=true it only says
+	// what it would do, and with prune=true whatever isn't in the manifest gets deleted, the database included.
+	r.HandleFunc("POST /project/{projectName}/apply", func(w http.ResponseWriter, r *http.Request) {
+		apiResponse := IApplyManifestResponse{}
+		projectName := r.PathValue("projectName")
+		value := r.Context().Value(types.Account{})
+		account := value.(types.Account)
+		thisProject, err := db.GetProjectByAccountAndName(adminDB, account, projectName)
+		if err != nil {
+			http.Error(w, err.Error(), http.StatusInternalServerError)
+			return
+		}
+
+		// the body is the manifest, so the options are only read from the query
+		body, err := io.ReadAll(http.MaxBytesReader(w, r.Body, maxManifestBytes))
+		if err != nil {
+			http.Error(w, err.Error(), http.StatusBadRequest)
+			return
+		}
+		apiResponse.DryRun = r.URL.Query().Get("dry-run") == "true"
+		prune := r.URL.Query().Get("prune") == "true"
+		manifest, err := types.ParseProjectManifest(body, types.GetZonesFromContainerZones(zoneRegistry.Zones()), types.GetZonesFromUserDBConnections(zoneRegistry.UserDBConnections()))
+		if err != nil {
+			http.Error(w, err.Error(), http.StatusBadRequest)
+			return
+		}
+
+		if apiResponse.DryRun {
+			apiResponse.Changes, err = manifests.Plan(adminDB, thisProject, manifest, prune)
+		} else {
+			apiResponse.Changes, err = manifests.Apply(*log, adminDB, zoneRegistry, *config, account, thisProject, manifest, prune)
+		}
+		if errors.Is(err, manifests.ErrInvalidManifest) {
+			http.Error(w, err.Error(), http.StatusBadRequest)
+			return
+		} else if err != nil {
+			http.Error(w, err.Error(), http.StatusInternalServerError)
+			return
+		}
+
+		apiResponseJSON, err := json.Marshal(apiResponse)
+		if err != nil {
+			http.Error(w, "Error encoding to JSON", http.StatusInternalServerError)
+			return
+		}
+
+		w.Header().Set("Content-Type", "application/json")
+		_, err = w.Write(apiResponseJSON)
+		if err != nil {
+			http.Error(w, "Error writing out JSON", http.StatusNotFound)
+		}
+	})
+
+	return r
+}
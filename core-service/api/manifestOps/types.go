@@ -0,0 +1,20 @@
+package manifestOps
+
+import (
+	"github.com/lu1a/lcaas/core-service/types"
+)
+
+/*
+Route: /api/project/{projectName}/apply (GET)
+Type: query
+*/
+type IExportManifestResponse types.ProjectManifest
+
+/*
+Route: /api/project/{projectName}/apply?dry-run=true&prune=true
+Type: mutation, or query on a dry run
+*/
+type IApplyManifestResponse struct {
+	DryRun  bool                   `json:"dry_run"`
+	Changes []types.ManifestChange `json:"changes"` // in the order they're made in, empty if there was nothing to do
+}
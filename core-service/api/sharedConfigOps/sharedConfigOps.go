@@ -0,0 +1,241 @@
+package sharedConfigOps
+
+import (
+	"encoding/json"
+	"fmt"
+	"net/http"
+	"strings"
+
+	"github.com/lu1a/lcaas/core-service/db"
+	"github.com/lu1a/lcaas/core-service/kubeOps"
+	"github.com/lu1a/lcaas/core-service/types"
+
+	"github.com/charmbracelet/log"
+
+	"github.com/jmoiron/sqlx"
+)
+
+func SharedConfigOpsRouter(log *log.Logger, adminDB *sqlx.DB, zoneRegistry *kubeOps.ZoneRegistry) *http.ServeMux {
+	r := http.NewServeMux()
+	// Everything's POST here too, same as containerOps
+
+	// Get all shared secrets and configmaps of a project
+	r.HandleFunc("POST /project/{projectName}/shared-config/list", func(w http.ResponseWriter, r *http.Request) {
+		apiResponse := IGetAllSharedConfigsResponse{}
+		projectName := r.PathValue("projectName")
+		value := r.Context().Value(types.Account{})
+		account := value.(types.Account)
+		thisProject, err := db.GetProjectByAccountAndName(adminDB, account, projectName)
+		if err != nil {
+			http.Error(w, err.Error(), http.StatusInternalServerError)
+			return
+		}
+		apiResponse.SharedConfigs, err = db.GetSharedConfigsByProject(adminDB, thisProject)
+		if err != nil {
+			http.Error(w, err.Error(), http.StatusInternalServerError)
+			return
+		}
+
+		apiResponseJSON, err := json.Marshal(apiResponse)
+		if err != nil {
+			http.Error(w, "Error encoding to JSON", http.StatusInternalServerError)
+			return
+		}
+
+		w.Header().Set("Content-Type", "application/json")
+		_, err = w.Write(apiResponseJSON)
+		if err != nil {
+			http.Error(w, "Error writing out JSON", http.StatusNotFound)
+		}
+	})
+
+	// Create a shared secret or configmap, ie. name=stripe&kind=secret&key[]=STRIPE_KEY&value[]=sk_live_...
+	r.HandleFunc("POST /project/{projectName}/shared-config/create", func(w http.ResponseWriter, r *http.Request) {
+		apiResponse := ICreateSharedConfigResponse{}
+		projectName := r.PathValue("projectName")
+		value := r.Context().Value(types.Account{})
+		account := value.(types.Account)
+		thisProject, err := db.GetProjectByAccountAndName(adminDB, account, projectName)
+		if err != nil {
+			http.Error(w, err.Error(), http.StatusInternalServerError)
+			return
+		}
+
+		err = r.ParseForm()
+		if err != nil {
+			http.Error(w, err.Error(), http.StatusBadRequest)
+			return
+		}
+		values, _ := types.ParseSharedConfigValuesFromHTTPForm(r)
+		newSharedConfig := types.SharedConfig{
+			Name: r.FormValue("name"),
+			Kind: r.FormValue("kind"),
+		}
+
+		apiResponse.SharedConfig, err = kubeOps.CreateSharedConfig(*log, adminDB, zoneRegistry.Zones(), account, thisProject, newSharedConfig, values)
+		if err != nil {
+			http.Error(w, err.Error(), http.StatusInternalServerError)
+			return
+		}
+
+		apiResponseJSON, err := json.Marshal(apiResponse)
+		if err != nil {
+			http.Error(w, "Error encoding to JSON", http.StatusInternalServerError)
+			return
+		}
+
+		w.Header().Set("Content-Type", "application/json")
+		_, err = w.Write(apiResponseJSON)
+		if err != nil {
+			http.Error(w, "Error writing out JSON", http.StatusNotFound)
+		}
+	})
+
+	// Get a shared secret or configmap with its versions and the containers using it
+	r.HandleFunc("POST /project/{projectName}/shared-config/{sharedConfigName}", func(w http.ResponseWriter, r *http.Request) {
+		apiResponse := IGetSharedConfigResponse{}
+		projectName := r.PathValue("projectName")
+		sharedConfigName := r.PathValue("sharedConfigName")
+		value := r.Context().Value(types.Account{})
+		account := value.(types.Account)
+		thisProject, err := db.GetProjectByAccountAndName(adminDB, account, projectName)
+		if err != nil {
+			http.Error(w, err.Error(), http.StatusInternalServerError)
+			return
+		}
+		apiResponse.SharedConfig, err = db.GetSharedConfigByProjectAndName(adminDB, thisProject, sharedConfigName)
+		if err != nil {
+			http.Error(w, err.Error(), http.StatusNotFound)
+			return
+		}
+		apiResponse.Versions, err = db.GetSharedConfigVersions(adminDB, apiResponse.SharedConfig)
+		if err != nil {
+			http.Error(w, err.Error(), http.StatusInternalServerError)
+			return
+		}
+		containers, err := db.GetContainersUsingSharedConfig(adminDB, thisProject, sharedConfigName)
+		if err != nil {
+			http.Error(w, err.Error(), http.StatusInternalServerError)
+			return
+		}
+		for _, container := range containers {
+			apiResponse.UsedBy = append(apiResponse.UsedBy, container.Name)
+		}
+
+		apiResponseJSON, err := json.Marshal(apiResponse)
+		if err != nil {
+			http.Error(w, "Error encoding to JSON", http.StatusInternalServerError)
+			return
+		}
+
+		w.Header().Set("Content-Type", "application/json")
+		_, err = w.Write(apiResponseJSON)
+		if err != nil {
+			http.Error(w, "Error writing out JSON", http.StatusNotFound)
+		}
+	})
+
+	// Make a new version of a shared secret or configmap, ie. key[]=A&value[]=1&remove-key[]=B&restart-containers=true
+	r.HandleFunc("POST /project/{projectName}/shared-config/{sharedConfigName}/update", func(w http.ResponseWriter, r *http.Request) {
+		apiResponse := IUpdateSharedConfigResponse{}
+		projectName := r.PathValue("projectName")
+		sharedConfigName := r.PathValue("sharedConfigName")
+		value := r.Context().Value(types.Account{})
+		account := value.(types.Account)
+		thisProject, err := db.GetProjectByAccountAndName(adminDB, account, projectName)
+		if err != nil {
+			http.Error(w, err.Error(), http.StatusInternalServerError)
+			return
+		}
+		thisSharedConfig, err := db.GetSharedConfigByProjectAndName(adminDB, thisProject, sharedConfigName)
+		if err != nil {
+			http.Error(w, err.Error(), http.StatusNotFound)
+			return
+		}
+
+		err = r.ParseForm()
+		if err != nil {
+			http.Error(w, err.Error(), http.StatusBadRequest)
+			return
+		}
+		changes, removedKeys := types.ParseSharedConfigValuesFromHTTPForm(r)
+		restartContainers := r.FormValue("restart-containers") == "true" || r.FormValue("restart-containers") == "on"
+
+		apiResponse.SharedConfig, err = kubeOps.UpdateSharedConfig(*log, adminDB, zoneRegistry.Zones(), account, thisProject, thisSharedConfig, changes, removedKeys, restartContainers)
+		if err != nil {
+			http.Error(w, err.Error(), http.StatusInternalServerError)
+			return
+		}
+
+		apiResponseJSON, err := json.Marshal(apiResponse)
+		if err != nil {
+			http.Error(w, "Error encoding to JSON", http.StatusInternalServerError)
+			return
+		}
+
+		w.Header().Set("Content-Type", "application/json")
+		_, err = w.Write(apiResponseJSON)
+		if err != nil {
+			http.Error(w, "Error writing out JSON", http.StatusNotFound)
+		}
+	})
+
+	// Delete a shared secret or configmap, only once no container uses it anymore
+	r.HandleFunc("POST /project/{projectName}/shared-config/{sharedConfigName}/delete", func(w http.ResponseWriter, r *http.Request) {
+		apiResponse := IDeleteSharedConfigResponse{}
+		projectName := r.PathValue("projectName")
+		sharedConfigName := r.PathValue("sharedConfigName")
+		value := r.Context().Value(types.Account{})
+		account := value.(types.Account)
+		thisProject, err := db.GetProjectByAccountAndName(adminDB, account, projectName)
+		if err != nil {
+			http.Error(w, err.Error(), http.StatusInternalServerError)
+			return
+		}
+		thisSharedConfig, err := db.GetSharedConfigByProjectAndName(adminDB, thisProject, sharedConfigName)
+		if err != nil {
+			http.Error(w, err.Error(), http.StatusNotFound)
+			return
+		}
+
+		containers, err := db.GetContainersUsingSharedConfig(adminDB, thisProject, sharedConfigName)
+		if err != nil {
+			http.Error(w, err.Error(), http.StatusInternalServerError)
+			return
+		}
+		if len(containers) > 0 {
+			containerNames := []string{}
+			for _, container := range containers {
+				containerNames = append(containerNames, container.Name)
+			}
+			http.Error(w, fmt.Sprintf("%s is still used by: %s", sharedConfigName, strings.Join(containerNames, ", ")), http.StatusConflict)
+			return
+		}
+
+		err = kubeOps.DeleteSharedConfig(*log, zoneRegistry.Zones(), thisProject, thisSharedConfig)
+		if err != nil {
+			http.Error(w, err.Error(), http.StatusInternalServerError)
+			return
+		}
+		err = db.DeleteSharedConfigByProjectAndName(adminDB, thisProject, sharedConfigName)
+		if err != nil {
+			http.Error(w, err.Error(), http.StatusInternalServerError)
+			return
+		}
+		apiResponse.SharedConfig = thisSharedConfig
+
+		apiResponseJSON, err := json.Marshal(apiResponse)
+		if err != nil {
+			http.Error(w, "Error encoding to JSON", http.StatusInternalServerError)
+			return
+		}
+
+		w.Header().Set("Content-Type", "application/json")
+		_, err = w.Write(apiResponseJSON)
+		if err != nil {
+			http.Error(w, "Error writing out JSON", http.StatusNotFound)
+		}
+	})
+
+	return r
+}
@@ -0,0 +1,47 @@
+package sharedConfigOps
+
+import (
+	"github.com/lu1a/lcaas/core-service/types"
+)
+
+/*
+Route: /api/project/{projectName}/shared-config/list
+Type: query
+*/
+type IGetAllSharedConfigsResponse struct {
+	SharedConfigs []types.SharedConfig `json:"shared_configs"`
+}
+
+/*
+Route: /api/project/{projectName}/shared-config/{sharedConfigName}
+Type: query
+*/
+type IGetSharedConfigResponse struct {
+	SharedConfig types.SharedConfig          `json:"shared_config"`
+	Versions     []types.SharedConfigVersion `json:"versions"`
+	UsedBy       []string                    `json:"used_by"` // container names
+}
+
+/*
+Route: /api/project/{projectName}/shared-config/create
+Type: query
+*/
+type ICreateSharedConfigResponse struct {
+	SharedConfig types.SharedConfig `json:"shared_config"`
+}
+
+/*
+Route: /api/project/{projectName}/shared-config/{sharedConfigName}/update
+Type: query
+*/
+type IUpdateSharedConfigResponse struct {
+	SharedConfig types.SharedConfig `json:"shared_config"`
+}
+
+/*
+Route: /api/project/{projectName}/shared-config/{sharedConfigName}/delete
+Type: query
+*/
+type IDeleteSharedConfigResponse struct {
+	SharedConfig types.SharedConfig `json:"shared_config"`
+}
@@ -0,0 +1,46 @@
+package webhookOps
+
+import (
+	"github.com/lu1a/lcaas/core-service/types"
+)
+
+/*
+Route: /api/project/{projectName}/webhooks/list
+Type: query
+*/
+type IGetWebhooksResponse struct {
+	Webhooks []types.WebhookSubscription `json:"webhooks"`
+	Events   []string                    `json:"events"` // everything that can be subscribed to
+}
+
+/*
+Route: /api/project/{projectName}/webhooks/create
+Type: mutation
+*/
+type ICreateWebhookResponse struct {
+	Webhook types.WebhookSubscription `json:"webhook"`
+}
+
+/*
+Route: /api/project/{projectName}/webhooks/webhook/{webhookName}/delete
+Type: mutation
+*/
+type IDeleteWebhookResponse struct {
+	WebhookName string `json:"webhook_name"`
+}
+
+/*
+Route: /api/project/{projectName}/webhooks/deliveries
+Type: query
+*/
+type IGetWebhookDeliveriesResponse struct {
+	Deliveries []types.WebhookDelivery `json:"deliveries"` // newest first
+}
+
+/*
+Route: /api/project/{projectName}/webhooks/delivery/{deliveryID}/redeliver
+Type: mutation
+*/
+type IRedeliverWebhookResponse struct {
+	Delivery types.WebhookDelivery `json:"delivery"`
+}
@@ -0,0 +1,208 @@
+package webhookOps
+
+import (
+	"encoding/json"
+	"net/http"
+	"strconv"
+	"time"
+
+	"github.com/lu1a/lcaas/core-service/db"
+	"github.com/lu1a/lcaas/core-service/types"
+
+	"github.com/charmbracelet/log"
+
+	"github.com/jmoiron/sqlx"
+)
+
+func WebhookOpsRouter(log *log.Logger, adminDB *sqlx.DB) *http.ServeMux {
+	r := http.NewServeMux()
+	// Everything's POST here too, same as containerOps
+
+	// The project's webhooks, along with every event they can subscribe to
+	r.HandleFunc("POST /project/{projectName}/webhooks/list", func(w http.ResponseWriter, r *http.Request) {
+		apiResponse := IGetWebhooksResponse{Events: types.WebhookEvents}
+		projectName := r.PathValue("projectName")
+		value := r.Context().Value(types.Account{})
+		account := value.(types.Account)
+		thisProject, err := db.GetProjectByAccountAndName(adminDB, account, projectName)
+		if err != nil {
+			http.Error(w, err.Error(), http.StatusInternalServerError)
+			return
+		}
+		apiResponse.Webhooks, err = db.GetWebhookSubscriptionsByProject(adminDB, thisProject)
+		if err != nil {
+			http.Error(w, err.Error(), http.StatusInternalServerError)
+			return
+		}
+
+		apiResponseJSON, err := json.Marshal(apiResponse)
+		if err != nil {
+			http.Error(w, "Error encoding to JSON", http.StatusInternalServerError)
+			return
+		}
+
+		w.Header().Set("Content-Type", "application/json")
+		_, err = w.Write(apiResponseJSON)
+		if err != nil {
+			http.Error(w, "Error writing out JSON", http.StatusNotFound)
+		}
+	})
+
+	// Subscribe to the project's events, ie. name=deploys&url=https://example.com/hook&event[]=container.active&event[]=container.error
+	// Leaving out event[] subscribes to all of them, and leaving out secret has one generated
+	r.HandleFunc("POST /project/{projectName}/webhooks/create", func(w http.ResponseWriter, r *http.Request) {
+		apiResponse := ICreateWebhookResponse{}
+		projectName := r.PathValue("projectName")
+		value := r.Context().Value(types.Account{})
+		account := value.(types.Account)
+		thisProject, err := db.GetProjectByAccountAndName(adminDB, account, projectName)
+		if err != nil {
+			http.Error(w, err.Error(), http.StatusInternalServerError)
+			return
+		}
+
+		err = r.ParseForm()
+		if err != nil {
+			http.Error(w, err.Error(), http.StatusBadRequest)
+			return
+		}
+		newSubscription := types.WebhookSubscription{}
+		err = newSubscription.ParseFromHTTPForm(r)
+		if err != nil {
+			http.Error(w, err.Error(), http.StatusBadRequest)
+			return
+		}
+
+		apiResponse.Webhook, err = db.CreateWebhookSubscriptionForProject(adminDB, thisProject, newSubscription)
+		if err != nil {
+			http.Error(w, err.Error(), http.StatusInternalServerError)
+			return
+		}
+
+		apiResponseJSON, err := json.Marshal(apiResponse)
+		if err != nil {
+			http.Error(w, "Error encoding to JSON", http.StatusInternalServerError)
+			return
+		}
+
+		w.Header().Set("Content-Type", "application/json")
+		_, err = w.Write(apiResponseJSON)
+		if err != nil {
+			http.Error(w, "Error writing out JSON", http.StatusNotFound)
+		}
+	})
+
+	r.HandleFunc("POST /project/{projectName}/webhooks/webhook/{webhookName}/delete", func(w http.ResponseWriter, r *http.Request) {
+		apiResponse := IDeleteWebhookResponse{}
+		webhookName := r.PathValue("webhookName")
+		projectName := r.PathValue("projectName")
+		value := r.Context().Value(types.Account{})
+		account := value.(types.Account)
+		thisProject, err := db.GetProjectByAccountAndName(adminDB, account, projectName)
+		if err != nil {
+			http.Error(w, err.Error(), http.StatusInternalServerError)
+			return
+		}
+
+		err = db.DeleteWebhookSubscriptionByProjectAndName(adminDB, thisProject, webhookName)
+		if err != nil {
+			http.Error(w, err.Error(), http.StatusNotFound)
+			return
+		}
+		apiResponse.WebhookName = webhookName
+
+		apiResponseJSON, err := json.Marshal(apiResponse)
+		if err != nil {
+			http.Error(w, "Error encoding to JSON", http.StatusInternalServerError)
+			return
+		}
+
+		w.Header().Set("Content-Type", "application/json")
+		_, err = w.Write(apiResponseJSON)
+		if err != nil {
+			http.Error(w, "Error writing out JSON", http.StatusNotFound)
+		}
+	})
+
+	// The delivery log since=<RFC 3339 timestamp>, the last week by default
+	r.HandleFunc("POST /project/{projectName}/webhooks/deliveries", func(w http.ResponseWriter, r *http.Request) {
+		apiResponse := IGetWebhookDeliveriesResponse{}
+		projectName := r.PathValue("projectName")
+		value := r.Context().Value(types.Account{})
+		account := value.(types.Account)
+		thisProject, err := db.GetProjectByAccountAndName(adminDB, account, projectName)
+		if err != nil {
+			http.Error(w, err.Error(), http.StatusInternalServerError)
+			return
+		}
+		since := time.Now().AddDate(0, 0, -7)
+		if r.FormValue("since") != "" {
+			since, err = time.Parse(time.RFC3339, r.FormValue("since"))
+			if err != nil {
+				http.Error(w, "since has to be an RFC 3339 timestamp", http.StatusBadRequest)
+				return
+			}
+		}
+
+		apiResponse.Deliveries, err = db.GetWebhookDeliveriesByProject(adminDB, thisProject, since)
+		if err != nil {
+			http.Error(w, err.Error(), http.StatusInternalServerError)
+			return
+		}
+
+		apiResponseJSON, err := json.Marshal(apiResponse)
+		if err != nil {
+			http.Error(w, "Error encoding to JSON", http.StatusInternalServerError)
+			return
+		}
+
+		w.Header().Set("Content-Type", "application/json")
+		_, err = w.Write(apiResponseJSON)
+		if err != nil {
+			http.Error(w, "Error writing out JSON", http.StatusNotFound)
+		}
+	})
+
+	// Sends the delivery's event to its webhook again, whether or not it got there the first time
+	r.HandleFunc("POST /project/{projectName}/webhooks/delivery/{deliveryID}/redeliver", func(w http.ResponseWriter, r *http.Request) {
+		apiResponse := IRedeliverWebhookResponse{}
+		projectName := r.PathValue("projectName")
+		value := r.Context().Value(types.Account{})
+		account := value.(types.Account)
+		thisProject, err := db.GetProjectByAccountAndName(adminDB, account, projectName)
+		if err != nil {
+			http.Error(w, err.Error(), http.StatusInternalServerError)
+			return
+		}
+		deliveryID, err := strconv.Atoi(r.PathValue("deliveryID"))
+		if err != nil {
+			http.Error(w, "The delivery ID has to be a number", http.StatusBadRequest)
+			return
+		}
+		thisDelivery, err := db.GetWebhookDeliveryByProjectAndID(adminDB, thisProject, deliveryID)
+		if err != nil {
+			http.Error(w, err.Error(), http.StatusNotFound)
+			return
+		}
+
+		apiResponse.Delivery, err = db.RedeliverWebhookDelivery(adminDB, thisDelivery)
+		if err != nil {
+			http.Error(w, err.Error(), http.StatusConflict)
+			return
+		}
+
+		apiResponseJSON, err := json.Marshal(apiResponse)
+		if err != nil {
+			http.Error(w, "Error encoding to JSON", http.StatusInternalServerError)
+			return
+		}
+
+		w.Header().Set("Content-Type", "application/json")
+		_, err = w.Write(apiResponseJSON)
+		if err != nil {
+			http.Error(w, "Error writing out JSON", http.StatusNotFound)
+		}
+	})
+
+	return r
+}
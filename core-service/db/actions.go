@@ -5,10 +5,14 @@ import (
 	"encoding/json"
 	"fmt"
 	"math/rand"
+	"regexp"
+	"slices"
 	"strconv"
 	"strings"
+	"time"
 
 	"github.com/jmoiron/sqlx"
+	"github.com/lib/pq"
 	"github.com/lu1a/lcaas/core-service/types"
 )
 
@@ -17,18 +21,387 @@ type numRange struct {
 	hi  int
 }
 
+// Seeds the zones from KUBE_CLIENTS. Zones that are already in the DB are left as they are, since after the first seed
+// they're changed through the admin API. Zones registered through it only live in the DB.
 func InitialiseContainerZones(adminDB *sqlx.DB, kubeClients []types.ContainerZone) error {
 	for _, client := range kubeClients {
-		_, err := adminDB.Exec("INSERT INTO container_zone (name, default_routing_ip) VALUES($1, $2) ON CONFLICT DO NOTHING", client.Name, client.DefaultRoutingIP)
+		_, err := adminDB.Exec("INSERT INTO container_zone (name, default_routing_ip, runtime_class_name, kubeconfig_path, registry_host) VALUES($1, $2, $3, $4, $5) ON CONFLICT (name) DO NOTHING", client.Name, client.DefaultRoutingIP, client.RuntimeClassName, client.KubeconfigPath, client.RegistryHost)
 		if err != nil {
 			return fmt.Errorf("Initialising container_zones failed: %w", err)
 		}
+		err = initResourceUsagesForZone(adminDB, client.Name)
+		if err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+// Accounts only get usage rows for the zones that existed when they signed up, so every newer zone needs them added
+func initResourceUsagesForZone(adminDB *sqlx.DB, zoneName string) error {
+	query := `
+		INSERT INTO container_resource_usage_per_account_per_zone (zone_name, account_id)
+		SELECT $1, account_id FROM account WHERE deleted_at IS NULL
+		ON CONFLICT (zone_name, account_id) DO NOTHING
+	`
+
+	_, err := adminDB.Exec(query, zoneName)
+	if err != nil {
+		return fmt.Errorf("Initialising resource usages for container_zone %s failed: %w", zoneName, err)
+	}
+	return nil
+}
+
+func RegisterContainerZone(adminDB *sqlx.DB, zone types.ContainerZone) (types.ContainerZone, error) {
+	query := `
+		INSERT INTO container_zone (name, default_routing_ip, cpu_millicores, memory_mb, runtime_class_name, kubeconfig_path, kubeconfig, discover_capacity, quota_burst_factor, donor_credit_share, registry_host)
+		VALUES ($1, $2, $3, $4, $5, $6, $7, $8, $9, $10, $11)
+	`
+
+	_, err := adminDB.Exec(query, zone.Name, zone.DefaultRoutingIP, zone.CPUMilliCores, zone.MemoryMB, zone.RuntimeClassName, zone.KubeconfigPath, zone.Kubeconfig, zone.DiscoverCapacity, zone.QuotaBurstFactor, zone.DonorCreditShare, zone.RegistryHost)
+	if err != nil {
+		if strings.Contains(err.Error(), "duplicate key") {
+			return zone, fmt.Errorf("There's already a zone called %s", zone.Name)
+		}
+		return zone, fmt.Errorf("Registering container_zone %s failed: %w", zone.Name, err)
+	}
+
+	err = initResourceUsagesForZone(adminDB, zone.Name)
+	if err != nil {
+		return zone, err
+	}
+
+	return GetContainerZoneByName(adminDB, zone.Name)
+}
+
+func UpdateContainerZone(adminDB *sqlx.DB, zone types.ContainerZone) (types.ContainerZone, error) {
+	query := `
+		UPDATE container_zone
+		SET default_routing_ip = $1, cpu_millicores = $2, memory_mb = $3, runtime_class_name = $4, kubeconfig_path = $5, kubeconfig = $6,
+			cordoned = $7, cordon_reason = $8, discover_capacity = $9, quota_burst_factor = $10, donor_credit_share = $11, registry_host = $12
+		WHERE name = $13 AND retired_at IS NULL
+	`
+
+	_, err := adminDB.Exec(query, zone.DefaultRoutingIP, zone.CPUMilliCores, zone.MemoryMB, zone.RuntimeClassName, zone.KubeconfigPath, zone.Kubeconfig,
+		zone.Cordoned, zone.CordonReason, zone.DiscoverCapacity, zone.QuotaBurstFactor, zone.DonorCreditShare, zone.RegistryHost, zone.Name)
+	if err != nil {
+		return zone, fmt.Errorf("Updating container_zone %s failed: %w", zone.Name, err)
+	}
+
+	// the donor credit share might have changed
+	_, err = adminDB.Exec(updateDonorCreditsQuery, zone.Name)
+	if err != nil {
+		return zone, fmt.Errorf("Updating the donor credits in container_zone %s failed: %w", zone.Name, err)
+	}
+
+	return GetContainerZoneByName(adminDB, zone.Name)
+}
+
+// The zone stays in the DB for the claims' history, but is gone from everywhere else
+func RetireContainerZone(adminDB *sqlx.DB, zoneName string) error {
+	_, err := adminDB.Exec("UPDATE container_zone SET retired_at = now(), cordoned = true WHERE name = $1", zoneName)
+	if err != nil {
+		return fmt.Errorf("Retiring container_zone %s failed: %w", zoneName, err)
+	}
+	_, err = adminDB.Exec("DELETE FROM container_zone_status WHERE zone_name = $1", zoneName)
+	if err != nil {
+		return fmt.Errorf("Clearing the container statuses of container_zone %s failed: %w", zoneName, err)
+	}
+	return nil
+}
+
+func GetContainerZoneByName(adminDB *sqlx.DB, zoneName string) (zone types.ContainerZone, err error) {
+	err = adminDB.Get(&zone, "SELECT * FROM container_zone WHERE name = $1 AND retired_at IS NULL", zoneName)
+	if err != nil {
+		return zone, fmt.Errorf("Getting container_zone %s failed: %w", zoneName, err)
+	}
+	return zone, nil
+}
+
+// Seeds the DB instances from USER_DB_CONNECTIONS, leaving the ones already in the DB as they are like the zones. Ones
+// registered through the admin API only live in the DB.
+func InitialiseUserDBZones(adminDB *sqlx.DB, userDBConnections []types.UserDB) error {
+	for _, userDB := range userDBConnections {
+		_, err := adminDB.Exec("INSERT INTO user_db_zone (id, zone, connection_url) VALUES ($1, $2, $3) ON CONFLICT (zone, id) DO NOTHING", userDB.ID, userDB.Zone, userDB.ConnectionURL)
+		if err != nil {
+			return fmt.Errorf("Initialising user_db_zones failed: %w", err)
+		}
+	}
+	return nil
+}
+
+func GetUserDBZones(adminDB *sqlx.DB) (userDBs []types.UserDB, err error) {
+	err = adminDB.Select(&userDBs, "SELECT zone, id, connection_url FROM user_db_zone WHERE retired_at IS NULL ORDER BY zone, id")
+	if err != nil {
+		return userDBs, fmt.Errorf("Getting user_db_zones failed: %w", err)
+	}
+	return userDBs, nil
+}
+
+func RegisterUserDBZone(adminDB *sqlx.DB, userDB types.UserDB) error {
+	_, err := adminDB.Exec("INSERT INTO user_db_zone (id, zone, connection_url) VALUES ($1, $2, $3)", userDB.ID, userDB.Zone, userDB.ConnectionURL)
+	if err != nil {
+		if strings.Contains(err.Error(), "duplicate key") {
+			return fmt.Errorf("There's already a DB instance called %s in zone %s", userDB.ID, userDB.Zone)
+		}
+		return fmt.Errorf("Registering user_db_zone %s/%s failed: %w", userDB.Zone, userDB.ID, err)
+	}
+	return nil
+}
+
+func UpdateUserDBZone(adminDB *sqlx.DB, userDB types.UserDB) error {
+	result, err := adminDB.Exec("UPDATE user_db_zone SET connection_url = $1 WHERE zone = $2 AND id = $3 AND retired_at IS NULL", userDB.ConnectionURL, userDB.Zone, userDB.ID)
+	if err != nil {
+		return fmt.Errorf("Updating user_db_zone %s/%s failed: %w", userDB.Zone, userDB.ID, err)
+	}
+	if updated, _ := result.RowsAffected(); updated == 0 {
+		return fmt.Errorf("There's no DB instance called %s in zone %s", userDB.ID, userDB.Zone)
+	}
+	return nil
+}
+
+// Project DBs have a database on every instance in their zones, so an instance can't go while its zone has any
+func RetireUserDBZone(adminDB *sqlx.DB, zoneName, userDBID string) error {
+	var userDB types.UserDB
+	err := adminDB.Get(&userDB, "SELECT zone, id, connection_url FROM user_db_zone WHERE zone = $1 AND id = $2 AND retired_at IS NULL", zoneName, userDBID)
+	if err != nil {
+		return fmt.Errorf("There's no DB instance called %s in zone %s", userDBID, zoneName)
+	}
+
+	var userDBClaimCount int
+	err = adminDB.Get(&userDBClaimCount, "SELECT COUNT(*) FROM user_db_claim WHERE deleted_at IS NULL AND $1 = ANY(zones)", userDB.Zone)
+	if err != nil {
+		return err
+	}
+	if userDBClaimCount > 0 {
+		return fmt.Errorf("%v project DBs still live in zone %s, they have to be deleted before %s can be retired", userDBClaimCount, userDB.Zone, userDBID)
+	}
+
+	_, err = adminDB.Exec("UPDATE user_db_zone SET retired_at = now() WHERE zone = $1 AND id = $2", zoneName, userDBID)
+	if err != nil {
+		return fmt.Errorf("Retiring user_db_zone %s/%s failed: %w", zoneName, userDBID, err)
+	}
+	return nil
+}
+
+func SaveContainerZoneHealth(adminDB *sqlx.DB, zoneName, health string, healthError *string) error {
+	_, err := adminDB.Exec("UPDATE container_zone SET health = $1, health_error = $2, health_checked_at = now() WHERE name = $3", health, healthError, zoneName)
+	if err != nil {
+		return fmt.Errorf("Saving the health of container_zone %s failed: %w", zoneName, err)
+	}
+	return nil
+}
+
+// Keeps the reading in the zone's capacity history, and makes it the zone's capacity unless that's set by hand
+func SaveContainerZoneCapacity(adminDB *sqlx.DB, capacity types.ZoneCapacity) error {
+	tx, err := adminDB.Begin()
+	if err != nil {
+		return fmt.Errorf("Saving the capacity of container_zone %s failed: %w", capacity.ZoneName, err)
+	}
+
+	query := `
+		INSERT INTO container_zone_capacity (zone_name, node_count, schedulable_node_count, allocatable_cpu_millicores, allocatable_memory_mb, reserved_cpu_millicores, reserved_memory_mb, cpu_millicores, memory_mb)
+		VALUES ($1, $2, $3, $4, $5, $6, $7, $8, $9)
+	`
+	_, err = tx.Exec(query, capacity.ZoneName, capacity.NodeCount, capacity.SchedulableNodeCount, capacity.AllocatableCPUMilliCores, capacity.AllocatableMemoryMB,
+		capacity.ReservedCPUMilliCores, capacity.ReservedMemoryMB, capacity.CPUMilliCores, capacity.MemoryMB)
+	if err != nil {
+		_ = tx.Rollback()
+		return fmt.Errorf("Saving the capacity history of container_zone %s failed: %w", capacity.ZoneName, err)
+	}
+
+	query = `
+		UPDATE container_zone
+		SET cpu_millicores = $1, memory_mb = $2, node_count = $3, capacity_collected_at = now()
+		WHERE name = $4 AND discover_capacity
+	`
+	_, err = tx.Exec(query, capacity.CPUMilliCores, capacity.MemoryMB, capacity.SchedulableNodeCount, capacity.ZoneName)
+	if err != nil {
+		_ = tx.Rollback()
+		return fmt.Errorf("Saving the capacity of container_zone %s failed: %w", capacity.ZoneName, err)
+	}
+
+	err = tx.Commit()
+	if err != nil {
+		return fmt.Errorf("Saving the capacity of container_zone %s failed: %w", capacity.ZoneName, err)
+	}
+	return nil
+}
+
+// Takes in the donated nodes seen in a zone on this capacity collection: new ones get added as pending, and every
+// one that isn't retired has observedFor added to its observed time, and to its up time too if it's seen and ready.
+// Returns the ones that are new. The donors' credit in the zone is then worked out again.
+func SyncDonatedNodes(adminDB *sqlx.DB, zoneName string, seenNodes []types.DonatedNode, observedFor time.Duration) (newNodes []types.DonatedNode, err error) {
+	tx, err := adminDB.Beginx()
+	if err != nil {
+		return newNodes, err
+	}
+
+	observedSeconds := int64(observedFor.Seconds())
+	seenNodeNames := pq.StringArray{}
+	for _, seenNode := range seenNodes {
+		seenNodeNames = append(seenNodeNames, seenNode.NodeName)
+
+		// the account on a node stays the one it was detected with, a changed label needs the donation retired first
+		query := `
+			INSERT INTO donated_node (zone_name, node_name, account_id, last_seen_at, ready, cpu_millicores, memory_mb)
+			SELECT $1, $2, $3, now(), $4, $5, $6
+			WHERE EXISTS (SELECT 1 FROM account WHERE account_id = $3 AND deleted_at IS NULL)
+			ON CONFLICT (zone_name, node_name) WHERE status != 'retired' DO UPDATE
+			SET last_seen_at = now(), ready = EXCLUDED.ready, cpu_millicores = EXCLUDED.cpu_millicores, memory_mb = EXCLUDED.memory_mb,
+				up_seconds = donated_node.up_seconds + CASE WHEN EXCLUDED.ready THEN $7 ELSE 0 END,
+				observed_seconds = donated_node.observed_seconds + $7
+			RETURNING *, (xmax = 0) AS inserted
+		`
+		var upserted struct {
+			types.DonatedNode
+			Inserted bool `db:"inserted"`
+		}
+		err = tx.Get(&upserted, query, zoneName, seenNode.NodeName, seenNode.AccountID, seenNode.Ready, seenNode.CPUMilliCores, seenNode.MemoryMB, observedSeconds)
+		if err == sql.ErrNoRows {
+			// labelled with an account that doesn't exist
+			continue
+		}
+		if err != nil {
+			_ = tx.Rollback()
+			return newNodes, fmt.Errorf("Saving donated node %s in container_zone %s failed: %w", seenNode.NodeName, zoneName, err)
+		}
+		if upserted.Inserted {
+			newNodes = append(newNodes, upserted.DonatedNode)
+		}
+	}
+
+	_, err = tx.Exec(`
+		UPDATE donated_node
+		SET ready = false, observed_seconds = observed_seconds + $1
+		WHERE zone_name = $2 AND status != 'retired' AND NOT (node_name = ANY($3))
+		`, observedSeconds, zoneName, seenNodeNames)
+	if err != nil {
+		_ = tx.Rollback()
+		return newNodes, fmt.Errorf("Saving the donated nodes missing from container_zone %s failed: %w", zoneName, err)
+	}
+
+	_, err = tx.Exec(updateDonorCreditsQuery, zoneName)
+	if err != nil {
+		_ = tx.Rollback()
+		return newNodes, fmt.Errorf("Updating the donor credits in container_zone %s failed: %w", zoneName, err)
+	}
+
+	err = tx.Commit()
+	if err != nil {
+		return newNodes, err
+	}
+	return newNodes, nil
+}
+
+// Every account in the zone gets the zone's donor credit share of its approved, ready donated nodes set aside
+const updateDonorCreditsQuery = `
+	UPDATE container_resource_usage_per_account_per_zone ru
+	SET donated_cpu_millicores = COALESCE((
+			SELECT FLOOR(SUM(d.cpu_millicores) * cz.donor_credit_share)::INTEGER FROM donated_node d
+			WHERE d.zone_name = ru.zone_name AND d.account_id = ru.account_id AND d.status = 'approved' AND d.ready
+		), 0),
+		donated_memory_mb = COALESCE((
+			SELECT FLOOR(SUM(d.memory_mb) * cz.donor_credit_share)::INTEGER FROM donated_node d
+			WHERE d.zone_name = ru.zone_name AND d.account_id = ru.account_id AND d.status = 'approved' AND d.ready
+		), 0)
+	FROM container_zone cz
+	WHERE cz.name = ru.zone_name AND ru.zone_name = $1
+`
+
+func GetDonatedNodes(adminDB *sqlx.DB, includeRetired bool) (donatedNodes []types.DonatedNode, err error) {
+	query := "SELECT * FROM donated_node WHERE status != 'retired' OR $1 ORDER BY zone_name, node_name"
+	err = adminDB.Select(&donatedNodes, query, includeRetired)
+	if err != nil {
+		return donatedNodes, fmt.Errorf("Getting the donated nodes failed: %w", err)
+	}
+	return donatedNodes, nil
+}
+
+func GetDonatedNodeByID(adminDB *sqlx.DB, donatedNodeID int) (donatedNode types.DonatedNode, err error) {
+	err = adminDB.Get(&donatedNode, "SELECT * FROM donated_node WHERE donated_node_id = $1", donatedNodeID)
+	if err != nil {
+		return donatedNode, fmt.Errorf("Getting donated node %v failed: %w", donatedNodeID, err)
+	}
+	return donatedNode, nil
+}
+
+// Approves a pending donation or retires one, and credits the donor accordingly straight away
+func SetDonatedNodeStatus(adminDB *sqlx.DB, donatedNode types.DonatedNode, status string) (types.DonatedNode, error) {
+	query := `
+		UPDATE donated_node
+		SET status = $1,
+			approved_at = CASE WHEN $1 = 'approved' THEN now() ELSE approved_at END,
+			retired_at = CASE WHEN $1 = 'retired' THEN now() ELSE retired_at END
+		WHERE donated_node_id = $2 AND status != 'retired'
+		RETURNING *
+	`
+
+	tx, err := adminDB.Beginx()
+	if err != nil {
+		return donatedNode, err
+	}
+
+	err = tx.Get(&donatedNode, query, status, donatedNode.DonatedNodeID)
+	if err == sql.ErrNoRows {
+		_ = tx.Rollback()
+		return donatedNode, fmt.Errorf("Donated node %s is already retired", donatedNode.NodeName)
+	}
+	if err != nil {
+		_ = tx.Rollback()
+		return donatedNode, fmt.Errorf("Setting donated node %s as %s failed: %w", donatedNode.NodeName, status, err)
+	}
+
+	_, err = tx.Exec(updateDonorCreditsQuery, donatedNode.ZoneName)
+	if err != nil {
+		_ = tx.Rollback()
+		return donatedNode, fmt.Errorf("Updating the donor credits in container_zone %s failed: %w", donatedNode.ZoneName, err)
+	}
+
+	err = tx.Commit()
+	if err != nil {
+		return donatedNode, err
+	}
+	return donatedNode, nil
+}
+
+func GetContainerZoneCapacityHistory(adminDB *sqlx.DB, zoneName string, since time.Time) (capacities []types.ZoneCapacity, err error) {
+	query := `
+		SELECT * FROM container_zone_capacity
+		WHERE zone_name = $1 AND collected_at >= $2
+		ORDER BY collected_at
+	`
+
+	err = adminDB.Select(&capacities, query, zoneName, since)
+	if err != nil {
+		return capacities, fmt.Errorf("Getting the capacity history of container_zone %s failed: %w", zoneName, err)
+	}
+	return capacities, nil
+}
+
+// New containers can't go to cordoned zones, the ones already there keep running. Zones we can't reach are out too,
+// so that the claim fails right away instead of once we get to creating it there.
+func checkZonesTakeNewContainers(q sqlx.Queryer, zoneNames pq.StringArray) error {
+	unavailableZones := []types.ContainerZone{}
+	err := sqlx.Select(q, &unavailableZones, "SELECT * FROM container_zone WHERE (cordoned OR health = 'unreachable') AND name = ANY($1)", zoneNames)
+	if err != nil {
+		return fmt.Errorf("Checking for unavailable zones failed: %w", err)
+	}
+	for _, zone := range unavailableZones {
+		if !zone.Cordoned {
+			return fmt.Errorf("Zone %s is unreachable right now, pick another zone or try again later", zone.Name)
+		}
+		reason := "no reason given"
+		if zone.CordonReason != nil {
+			reason = *zone.CordonReason
+		}
+		return fmt.Errorf("Zone %s is in maintenance and doesn't take new containers right now (%s)", zone.Name, reason)
 	}
 	return nil
 }
 
 func GetContainerZonesFromDB(adminDB *sqlx.DB) (containerZones []types.ContainerZone, err error) {
-	err = adminDB.Select(&containerZones, "SELECT * FROM container_zone")
+	err = adminDB.Select(&containerZones, "SELECT * FROM container_zone WHERE retired_at IS NULL ORDER BY name")
 	if err != nil {
 		return containerZones, fmt.Errorf("Getting container_zones failed: %w", err)
 	}
@@ -283,7 +656,7 @@ func initDefaultResourceUsagesForAccountID(adminDB *sqlx.DB, accountID int) erro
 }
 
 func CreateProjectForAccount(adminDB *sqlx.DB, account types.Account, projectInput types.Project) (projectOutput types.Project, err error) {
-	tx, err := adminDB.Begin()
+	tx, err := adminDB.Beginx()
 	if err != nil {
 		return projectOutput, err
 	}
@@ -297,7 +670,6 @@ func CreateProjectForAccount(adminDB *sqlx.DB, account types.Account, projectInp
         SELECT project_id FROM inserted_project
     `
 	createAccountProjectMapQuery := `INSERT INTO account_project (account_id, project_id) VALUES ($1, $2)`
-	initialBillingRowQuery := `INSERT INTO billing (current_credits, credits_delta, details, project_id) VALUES ($1, $2, $3, $4)`
 
 	// Insert project and get its ID
 	var projectID int
@@ -314,8 +686,12 @@ func CreateProjectForAccount(adminDB *sqlx.DB, account types.Account, projectInp
 		return projectOutput, err
 	}
 
-	// Insert inital billing row of 0 credits
-	_, err = tx.Exec(initialBillingRowQuery, 0, 0, fmt.Sprintf("{\"project\": {\"id\": \"%d\", \"name\": \"%s\" }}", projectID, projectInput.Name), projectID)
+	// Open the project's ledger at 0 credits
+	_, err = insertBillingEntry(tx, types.BillingEntry{
+		ProjectID: projectID,
+		Kind:      "opening",
+		Details:   types.BillingDetails{Project: &types.BillingDetailsProject{ID: strconv.Itoa(projectID), Name: projectInput.Name}},
+	})
 	if err != nil {
 		_ = tx.Rollback()
 		return projectOutput, err
@@ -367,547 +743,2915 @@ func AddUserToProjectByUsername(adminDB *sqlx.DB, project types.Project, usernam
 }
 
 func CreateContainerClaimForProject(adminDB *sqlx.DB, account types.Account, project types.Project, containerInput types.ContainerClaim) (containerOutput types.ContainerClaim, err error) {
-	if containerInput.CPUMilliCores == 0 {
-		containerInput.CPUMilliCores = 100
-	}
-	if containerInput.MemoryMB == 0 {
-		containerInput.MemoryMB = 256
+	containerInput, err = checkContainerClaim(adminDB, project, containerInput)
+	if err != nil {
+		return containerOutput, err
 	}
 
-	createContainerQuery := `
-		WITH inserted_container_claim AS (
-			INSERT INTO container_claim (created_by_account_id, project_id, name, image_ref, image_tag, run_type, command, ports, target_ports, zones, env_var_names, cpu_millicores, memory_mb)
-			VALUES ($1, $2, $3, $4, $5, $6, $7, $8, $9, $10, $11, $12, $13)
-			RETURNING container_claim_id
-		)
-		SELECT container_claim_id FROM inserted_container_claim
-	`
+	// re-runs and rollbacks replace a container that's already in its zones, so only brand new ones are kept out
+	if containerInput.DeployReason == "" {
+		err = checkProjectNotSuspended(project)
+		if err != nil {
+			return containerOutput, err
+		}
+		err = checkZonesTakeNewContainers(adminDB, containerInput.Zones)
+		if err != nil {
+			return containerOutput, err
+		}
+	}
 
-	mayAccountFitThisContainerWithoutGoingOverResourceQuota, err := mayAccountFitThisContainerWithoutGoingOverResourceQuota(adminDB, containerInput, account)
+	containerInput.Name, err = appendNumberToContainerNameIfExists(adminDB, project, containerInput.Name)
 	if err != nil {
 		return containerOutput, err
 	}
-	if !mayAccountFitThisContainerWithoutGoingOverResourceQuota {
-		return containerOutput, fmt.Errorf("If you were to deploy this, you'd exceed your allocated resources. Please lower the CPU or RAM.")
-	}
 
-	containerInput.Name, err = appendNumberToContainerNameIfExists(adminDB, project, containerInput.Name)
+	tx, err := adminDB.Beginx()
 	if err != nil {
 		return containerOutput, err
 	}
 
-	var containerID int
-	err = adminDB.QueryRow(createContainerQuery, account.AccountID, project.ProjectID, containerInput.Name, containerInput.ImageRef, containerInput.ImageTag, containerInput.RunType, containerInput.Command, containerInput.Ports, containerInput.TargetPorts, containerInput.Zones, containerInput.EnvVarNames, containerInput.CPUMilliCores, containerInput.MemoryMB).Scan(&containerID)
+	containerOutput, err = insertContainerClaim(tx, account, project, containerInput)
 	if err != nil {
+		_ = tx.Rollback()
 		return containerOutput, err
 	}
 
-	containerOutput = containerInput
-	containerOutput.CreatedByAccountID = account.AccountID
-	containerOutput.ContainerClaimID = containerID
+	err = tx.Commit()
+	if err != nil {
+		return containerOutput, err
+	}
 
 	return containerOutput, nil
 }
 
-func mayAccountFitThisContainerWithoutGoingOverResourceQuota(adminDB *sqlx.DB, containerInput types.ContainerClaim, account types.Account) (mayAccountFitThisContainerWithoutGoingOverResourceQuota bool, err error) {
-	for _, zoneName := range containerInput.Zones {
-		err = adminDB.Get(&mayAccountFitThisContainerWithoutGoingOverResourceQuota, `
-		SELECT (
-			cz.cpu_millicores / (SELECT COUNT(*) FROM account a WHERE a.suspended_at IS NULL AND a.deleted_at IS NULL)) > (ru.used_cpu_millicores + $1)
-		FROM container_resource_usage_per_account_per_zone ru
-		JOIN container_zone cz ON ru.zone_name = cz.name
-		WHERE ru.zone_name = $2 AND ru.account_id = $3
-		`, containerInput.CPUMilliCores, zoneName, account.AccountID)
-		if err != nil {
-			return false, fmt.Errorf("Determining whether this account may provision another resource failed: %w", err)
-		}
-		if !mayAccountFitThisContainerWithoutGoingOverResourceQuota {
-			return false, nil
-		}
+// Swaps oldContainer's claim for newContainer's under the same name, for re-runs, rollbacks and redeploys. The new claim
+// is checked in the same transaction that deletes the old one, so that if it's refused the old one is left as it was, and
+// nothing has to be torn down before it's known that the new one may replace it.
+func ReplaceContainerClaim(adminDB *sqlx.DB, account types.Account, project types.Project, oldContainer types.ContainerClaim, newContainer types.ContainerClaim) (containerOutput types.ContainerClaim, err error) {
+	query := `
+		UPDATE container_claim
+		SET deleted_at = now(), status = 'inactive'
+		WHERE container_claim_id = $1 AND deleted_at IS NULL
+		RETURNING container_claim_id
+	`
 
-		err = adminDB.Get(&mayAccountFitThisContainerWithoutGoingOverResourceQuota, `
-		SELECT (
-			cz.memory_mb / (SELECT COUNT(*) FROM account a WHERE a.suspended_at IS NULL AND a.deleted_at IS NULL)) > (ru.used_memory_mb + $1)
-		FROM container_resource_usage_per_account_per_zone ru
-		JOIN container_zone cz ON ru.zone_name = cz.name
-		WHERE ru.zone_name = $2 AND ru.account_id = $3
-		`, containerInput.MemoryMB, zoneName, account.AccountID)
-		if err != nil {
-			return false, fmt.Errorf("Determining whether this account may provision another resource failed: %w", err)
-		}
+	tx, err := adminDB.Beginx()
+	if err != nil {
+		return containerOutput, err
 	}
 
-	return mayAccountFitThisContainerWithoutGoingOverResourceQuota, nil
-}
+	newContainer, err = checkContainerClaim(tx, project, newContainer)
+	if err != nil {
+		_ = tx.Rollback()
+		return containerOutput, err
+	}
+	newContainer.Name = oldContainer.Name
 
-func addToContainerResourceUsage(adminDB *sqlx.DB, container types.ContainerClaim) error {
-	if container.CreatedByAccountID == 0 {
-		return fmt.Errorf("Adding to container resource usage for account %v failed: there is no account ID", container.CreatedByAccountID)
+	// replacing a container as it was is fine anywhere, but growing it or moving it into new zones is starting something
+	// new, and so is anything a manifest changes
+	addedZones := pq.StringArray{}
+	for _, zone := range newContainer.Zones {
+		if !slices.Contains(oldContainer.Zones, zone) {
+			addedZones = append(addedZones, zone)
+		}
 	}
-	for _, zoneName := range container.Zones {
-		_, err := adminDB.Exec(`
-		UPDATE container_resource_usage_per_account_per_zone
-		SET used_cpu_millicores = used_cpu_millicores + $1, used_memory_mb = used_memory_mb + $2
-		WHERE zone_name = $3 AND account_id = $4
-		`, container.CPUMilliCores, container.MemoryMB, zoneName, container.CreatedByAccountID)
+	grows := len(addedZones) > 0 || newContainer.CPUMilliCores > oldContainer.CPUMilliCores || newContainer.MemoryMB > oldContainer.MemoryMB
+	if grows || newContainer.DeployReason == "manifest" {
+		err = checkProjectNotSuspended(project)
 		if err != nil {
-			return fmt.Errorf("Adding to container resource usage for account %v failed: %w", container.CreatedByAccountID, err)
+			_ = tx.Rollback()
+			return containerOutput, err
 		}
 	}
-	return nil
-}
-
-func removeFromContainerResourceUsage(adminDB *sqlx.DB, container types.ContainerClaim) error {
-	if container.CreatedByAccountID == 0 {
-		return fmt.Errorf("Removing container resource usage for account %v failed: there is no account ID", container.CreatedByAccountID)
-	}
-	for _, zoneName := range container.Zones {
-		_, err := adminDB.Exec(`
-		UPDATE container_resource_usage_per_account_per_zone
-		SET used_cpu_millicores = used_cpu_millicores - $1, used_memory_mb = used_memory_mb - $2
-		WHERE zone_name = $3 AND account_id = $4
-		`, container.CPUMilliCores, container.MemoryMB, zoneName, container.CreatedByAccountID)
-		if err != nil {
-			if strings.Contains(err.Error(), "violates check constraint") { // when removing usage would make user dip below 0
-				_, err := adminDB.Exec(`
-				UPDATE container_resource_usage_per_account_per_zone
-				SET used_cpu_millicores = 0, used_memory_mb = 0
-				WHERE zone_name = $1 AND account_id = $2
-				`, zoneName, container.CreatedByAccountID)
-				if err != nil {
-					return fmt.Errorf("Nuking resource usage for account %v failed: %w", container.CreatedByAccountID, err)
-				}
-			} else {
-				// all regular errors
-				return fmt.Errorf("Removing container resource usage for account %v failed: %w", container.CreatedByAccountID, err)
-			}
-
+	if len(addedZones) > 0 {
+		err = checkZonesTakeNewContainers(tx, addedZones)
+		if err != nil {
+			_ = tx.Rollback()
+			return containerOutput, err
 		}
 	}
-	return nil
-}
-
-func appendNumberToContainerNameIfExists(adminDB *sqlx.DB, project types.Project, containerName string) (outputContainerName string, err error) {
-	getLatestContainerNameQuery := `
-		SELECT name FROM container_claim
-		WHERE project_id = $1 AND name LIKE $2 AND status != 'inactive'
-		ORDER BY created_at DESC
-		LIMIT 1
-	`
 
-	err = adminDB.QueryRow(getLatestContainerNameQuery, project.ProjectID, containerName+"%").Scan(&outputContainerName)
-	if err != nil && err != sql.ErrNoRows {
-		return outputContainerName, err
+	var containerClaimID int
+	err = tx.QueryRow(query, oldContainer.ContainerClaimID).Scan(&containerClaimID)
+	if err == sql.ErrNoRows {
+		_ = tx.Rollback()
+		return containerOutput, fmt.Errorf("The container %s has already been replaced or deleted", oldContainer.Name)
+	}
+	if err != nil {
+		_ = tx.Rollback()
+		return containerOutput, err
 	}
 
-	// If there was nothing returned, just assume the container name
-	if len(outputContainerName) == 0 {
-		return containerName, nil
+	// usually given back already when it was set as deactivating, so that the new claim has its room
+	err = releaseContainerResourceUsage(tx, containerClaimID)
+	if err != nil {
+		_ = tx.Rollback()
+		return containerOutput, err
 	}
 
-	// Split the string by '-' delimiter
-	parts := strings.Split(outputContainerName, "-")
+	containerOutput, err = insertContainerClaim(tx, account, project, newContainer)
+	if err != nil {
+		_ = tx.Rollback()
+		return containerOutput, err
+	}
 
-	// Get the last part of the split string
-	lastPart := parts[len(parts)-1]
+	// already activating while the old one's torn down, so that it still reads as a deploy in progress
+	_, err = tx.Exec("UPDATE container_claim SET status = 'activating' WHERE container_claim_id = $1", containerOutput.ContainerClaimID)
+	if err != nil {
+		_ = tx.Rollback()
+		return containerOutput, err
+	}
+	containerOutput.Status = "activating"
 
-	// Check if the last part is a number
-	_, err = strconv.Atoi(lastPart)
+	err = tx.Commit()
 	if err != nil {
-		// If it's not a number, append "-1" to the input string
-		return outputContainerName + "-1", nil
+		return containerOutput, err
 	}
 
-	// If it's a number, increment it by 1 and append to the input string
-	num, _ := strconv.Atoi(lastPart)
-	num++
-	newLastPart := strconv.Itoa(num)
-	return strings.Join(parts[:len(parts)-1], "-") + "-" + newLastPart, nil
+	return containerOutput, nil
 }
 
-func GetContainersByProject(adminDB *sqlx.DB, project types.Project) (containers []types.ContainerClaim, err error) {
+// Undoes SetContainerAsDeactivating for a container that's still running because its replacement was refused, putting
+// it back in the status it had and charging its usage again
+func RestoreDeactivatingContainer(adminDB *sqlx.DB, container types.ContainerClaim) error {
 	query := `
-		SELECT * FROM container_claim
-		WHERE project_id = $1 AND deleted_at IS NULL
+		UPDATE container_claim
+		SET status = $2, usage_released_at = NULL
+		WHERE container_claim_id = $1 AND deleted_at IS NULL AND usage_released_at IS NOT NULL
+		RETURNING *
 	`
 
-	err = adminDB.Select(&containers, query, project.ProjectID)
+	status := container.Status
+	if status == "" || status == "deactivating" {
+		status = "active"
+	}
+
+	tx, err := adminDB.Beginx()
 	if err != nil {
-		return containers, err
+		return err
 	}
 
-	return containers, nil
+	var restoredContainers []types.ContainerClaim
+	err = tx.Select(&restoredContainers, query, container.ContainerClaimID, status)
+	if err != nil {
+		_ = tx.Rollback()
+		return fmt.Errorf("Restoring container %v failed: %w", container.ContainerClaimID, err)
+	}
+	for _, restoredContainer := range restoredContainers {
+		err = addToContainerResourceUsage(tx, restoredContainer)
+		if err != nil {
+			_ = tx.Rollback()
+			return err
+		}
+	}
+
+	return tx.Commit()
 }
 
-func GetContainerByProjectAndName(adminDB *sqlx.DB, project types.Project, containerName string) (container types.ContainerClaim, err error) {
+// Fills in the defaults of a container claim, and checks that whatever it refers to is there for it
+func checkContainerClaim(q sqlx.Queryer, project types.Project, containerInput types.ContainerClaim) (types.ContainerClaim, error) {
+	if containerInput.CPUMilliCores == 0 {
+		containerInput.CPUMilliCores = 100
+	}
+	if containerInput.MemoryMB == 0 {
+		containerInput.MemoryMB = 256
+	}
+	if containerInput.SecurityProfile == "" {
+		containerInput.SecurityProfile = "restricted"
+	}
+	if !slices.Contains(types.SecurityProfiles, containerInput.SecurityProfile) {
+		return containerInput, fmt.Errorf("Unknown security profile %q, pick one of: %s", containerInput.SecurityProfile, strings.Join(types.SecurityProfiles, ", "))
+	}
+	if containerInput.IsUnconfined() && !project.Trusted {
+		return containerInput, fmt.Errorf("Only trusted projects may run unconfined containers. Ask an operator to mark this project as trusted.")
+	}
+
+	for _, sharedConfigName := range containerInput.SharedConfigNames {
+		_, err := GetSharedConfigByProjectAndName(q, project, sharedConfigName)
+		if err != nil {
+			return containerInput, fmt.Errorf("There's no shared secret or config called %q in this project", sharedConfigName)
+		}
+	}
+
+	err := checkServiceBindingsFitZones(q, project, containerInput)
+	if err != nil {
+		return containerInput, err
+	}
+
+	return containerInput, nil
+}
+
+// Inserts a checked container claim along with its first revision, if it fits in the account's quota
+func insertContainerClaim(tx *sqlx.Tx, account types.Account, project types.Project, containerInput types.ContainerClaim) (containerOutput types.ContainerClaim, err error) {
+	createContainerQuery := `
+		WITH inserted_container_claim AS (
+			INSERT INTO container_claim (created_by_account_id, project_id, name, image_ref, image_tag, run_type, command, ports, target_ports, zones, env_var_names, cpu_millicores, memory_mb, security_profile, image_digest, zone_env_var_names, shared_config_names, bind_database, bound_object_storage, pull_credential_name)
+			VALUES ($1, $2, $3, $4, $5, $6, $7, $8, $9, $10, $11, $12, $13, $14, $15, $16, $17, $18, $19, $20)
+			RETURNING container_claim_id
+		)
+		SELECT container_claim_id FROM inserted_container_claim
+	`
+
+	err = checkResourceQuota(tx, account, containerInput)
+	if err != nil {
+		return containerOutput, err
+	}
+
+	var containerID int
+	err = tx.QueryRow(createContainerQuery, account.AccountID, project.ProjectID, containerInput.Name, containerInput.ImageRef, containerInput.ImageTag, containerInput.RunType, containerInput.Command, containerInput.Ports, containerInput.TargetPorts, containerInput.Zones, containerInput.EnvVarNames, containerInput.CPUMilliCores, containerInput.MemoryMB, containerInput.SecurityProfile, containerInput.ImageDigest, containerInput.ZoneEnvVarNames, containerInput.SharedConfigNames, containerInput.BindDatabase, containerInput.BoundObjectStorage, containerInput.PullCredentialName).Scan(&containerID)
+	if err != nil {
+		return containerOutput, err
+	}
+
+	containerOutput = containerInput
+	containerOutput.CreatedByAccountID = account.AccountID
+	containerOutput.ContainerClaimID = containerID
+
+	// charged in the same transaction the quota was checked in, with the zones' usage rows still locked, so that
+	// concurrent creates can't both squeeze into the same room
+	err = addToContainerResourceUsage(tx, containerOutput)
+	if err != nil {
+		return containerOutput, err
+	}
+
+	// every deploy gets a revision, so that it can be diffed against and rolled back to later
+	err = insertContainerRevision(tx, containerOutput)
+	if err != nil {
+		return containerOutput, err
+	}
+
+	return containerOutput, nil
+}
+
+// Bound services get injected from their zone-local instance, so they have to exist in all of the container's zones
+func checkServiceBindingsFitZones(q sqlx.Queryer, project types.Project, containerInput types.ContainerClaim) error {
+	if containerInput.BindDatabase {
+		userDBClaim, err := GetUserDBClaimByProject(q, project)
+		if err != nil {
+			return fmt.Errorf("This project has no database to bind to")
+		}
+		for _, zone := range containerInput.Zones {
+			if !slices.Contains(userDBClaim.Zones, zone) {
+				return fmt.Errorf("The project database isn't in zone %s, so it can't be bound to a container running there", zone)
+			}
+		}
+	}
+
+	if containerInput.BoundObjectStorage != nil {
+		objectStorage, err := GetObjectStorageByProjectAndName(q, project, *containerInput.BoundObjectStorage)
+		if err != nil {
+			return fmt.Errorf("There's no object storage called %q in this project", *containerInput.BoundObjectStorage)
+		}
+		for _, zone := range containerInput.Zones {
+			if !slices.Contains(objectStorage.Zones, zone) {
+				return fmt.Errorf("The object storage %s isn't in zone %s, so it can't be bound to a container running there", objectStorage.Name, zone)
+			}
+		}
+	}
+
+	return nil
+}
+
+func insertContainerRevision(tx *sqlx.Tx, container types.ContainerClaim) error {
+	reason := container.DeployReason
+	if reason == "" {
+		reason = "create"
+	}
+
 	query := `
-		SELECT container_claim.* FROM container_claim
-		WHERE container_claim.project_id = $1 AND container_claim.name = $2 AND deleted_at IS NULL
+		INSERT INTO container_revision (revision, reason, container_name, image_ref, image_tag, image_digest, command, env_var_names, zone_env_var_names, shared_config_names, bind_database, bound_object_storage, cpu_millicores, memory_mb, target_ports, zones, run_type, security_profile, container_claim_id, created_by_account_id, project_id, pull_credential_name)
+		SELECT COALESCE(MAX(revision), 0) + 1, $1, $2, $3, $4, $5, $6, $7, $8, $9, $10, $11, $12, $13, $14, $15, $16, $17, $18, $19, $20, $21
+		FROM container_revision
+		WHERE project_id = $20 AND container_name = $2
+	`
+	_, err := tx.Exec(query, reason, container.Name, container.ImageRef, container.ImageTag, container.ImageDigest, container.Command, container.EnvVarNames, container.ZoneEnvVarNames, container.SharedConfigNames, container.BindDatabase, container.BoundObjectStorage, container.CPUMilliCores, container.MemoryMB, container.TargetPorts, container.Zones, container.RunType, container.SecurityProfile, container.ContainerClaimID, container.CreatedByAccountID, container.ProjectID, container.PullCredentialName)
+	if err != nil {
+		return fmt.Errorf("Saving revision for container %s failed: %w", container.Name, err)
+	}
+	return nil
+}
+
+func GetContainerRevisionsByProjectAndName(adminDB *sqlx.DB, project types.Project, containerName string) (revisions []types.ContainerRevision, err error) {
+	query := `
+		SELECT * FROM container_revision
+		WHERE project_id = $1 AND container_name = $2
+		ORDER BY revision DESC
 	`
 
-	err = adminDB.Get(&container, query, project.ProjectID, containerName)
+	err = adminDB.Select(&revisions, query, project.ProjectID, containerName)
 	if err != nil {
-		return container, err
+		return revisions, err
 	}
 
-	return container, nil
+	return revisions, nil
 }
 
-func SetContainerAsActivating(adminDB *sqlx.DB, container types.ContainerClaim) error {
+func GetContainerRevision(adminDB *sqlx.DB, project types.Project, containerName string, revision int) (containerRevision types.ContainerRevision, err error) {
 	query := `
-		UPDATE container_claim
-		SET status = 'activating'
-		WHERE container_claim_id = $1
+		SELECT * FROM container_revision
+		WHERE project_id = $1 AND container_name = $2 AND revision = $3
 	`
 
-	_, err := adminDB.Exec(query, container.ContainerClaimID)
+	err = adminDB.Get(&containerRevision, query, project.ProjectID, containerName, revision)
 	if err != nil {
-		return err
+		return containerRevision, err
 	}
 
-	return nil
+	return containerRevision, nil
 }
 
-func SetContainerAsActive(adminDB *sqlx.DB, container types.ContainerClaim) error {
+// The account's quota in a zone as it is right now. With forUpdate the zone's usage rows stay locked until tx is
+// done, so that quota decisions in a zone happen one after the other instead of all passing on the same numbers.
+func getResourceQuota(q sqlx.Queryer, zoneName string, accountID int, forUpdate bool) (quota types.ResourceQuota, err error) {
+	var zone types.ContainerZone
+	err = sqlx.Get(q, &zone, "SELECT * FROM container_zone WHERE name = $1 AND retired_at IS NULL", zoneName)
+	if err != nil {
+		return quota, fmt.Errorf("Getting container_zone %s failed: %w", zoneName, err)
+	}
+
 	query := `
-		UPDATE container_claim
-		SET status = 'active'
-		WHERE container_claim_id = $1
+		SELECT ru.*, a.quota_weight FROM container_resource_usage_per_account_per_zone ru
+		JOIN account a ON a.account_id = ru.account_id
+		WHERE ru.zone_name = $1
 	`
+	if forUpdate {
+		query += " FOR UPDATE OF ru"
+	}
+	usages := []types.ContainerResourceUsagePerAccountPerZone{}
+	err = sqlx.Select(q, &usages, query, zoneName)
+	if err != nil {
+		return quota, fmt.Errorf("Getting the resource usages in container_zone %s failed: %w", zoneName, err)
+	}
 
-	_, err := adminDB.Exec(query, container.ContainerClaimID)
+	return types.ComputeResourceQuota(zone, usages, accountID), nil
+}
+
+// The account's quota in every zone, for explaining where it stands
+func GetResourceQuotasForAccount(adminDB *sqlx.DB, account types.Account) (quotas []types.ResourceQuota, err error) {
+	zones, err := GetContainerZonesFromDB(adminDB)
+	if err != nil {
+		return quotas, err
+	}
+	for _, zone := range zones {
+		quota, err := getResourceQuota(adminDB, zone.Name, account.AccountID, false)
+		if err != nil {
+			return quotas, err
+		}
+		quotas = append(quotas, quota)
+	}
+	return quotas, nil
+}
+
+func GetAccountByID(adminDB *sqlx.DB, accountID int) (account types.Account, err error) {
+	err = adminDB.Get(&account, "SELECT * FROM account WHERE account_id = $1 AND deleted_at IS NULL", accountID)
+	if err != nil {
+		return account, fmt.Errorf("Getting account %v failed: %w", accountID, err)
+	}
+	return account, nil
+}
+
+func SetAccountQuotaWeight(adminDB *sqlx.DB, account types.Account, quotaWeight float64) error {
+	_, err := adminDB.Exec("UPDATE account SET quota_weight = $1 WHERE account_id = $2", quotaWeight, account.AccountID)
 	if err != nil {
+		return fmt.Errorf("Setting the quota weight of account %v failed: %w", account.AccountID, err)
+	}
+	return nil
+}
+
+// Sets capacity in a zone aside for the account alone. All the reservations in a zone together can't go over its capacity.
+func SetResourceReservation(adminDB *sqlx.DB, account types.Account, zoneName string, cpuMilliCores, memoryMB int) error {
+	tx, err := adminDB.Beginx()
+	if err != nil {
+		return err
+	}
+
+	_, err = tx.Exec(`
+		UPDATE container_resource_usage_per_account_per_zone
+		SET reserved_cpu_millicores = $1, reserved_memory_mb = $2
+		WHERE zone_name = $3 AND account_id = $4
+		`, cpuMilliCores, memoryMB, zoneName, account.AccountID)
+	if err != nil {
+		_ = tx.Rollback()
+		return fmt.Errorf("Reserving resources in container_zone %s for account %v failed: %w", zoneName, account.AccountID, err)
+	}
+
+	// locked like any other quota decision, so that two reservations can't both squeeze into the same room
+	quota, err := getResourceQuota(tx, zoneName, account.AccountID, true)
+	if err != nil {
+		_ = tx.Rollback()
 		return err
 	}
+	if (quota.CPUMilliCores.Pool == 0 && cpuMilliCores > 0) || (quota.MemoryMB.Pool == 0 && memoryMB > 0) {
+		_ = tx.Rollback()
+		return fmt.Errorf("The reservations in zone %s would take up all of its capacity", zoneName)
+	}
 
-	err = addToContainerResourceUsage(adminDB, container)
+	err = tx.Commit()
 	if err != nil {
 		return err
 	}
+	return nil
+}
+
+// Both the CPU and the memory have to fit in every one of the container's zones
+func checkResourceQuota(tx *sqlx.Tx, account types.Account, containerInput types.ContainerClaim) error {
+	return checkResourceQuotaInZones(tx, account, containerInput.Zones, containerInput.CPUMilliCores, containerInput.MemoryMB, "deploy this")
+}
 
+// doing is what the account's trying to do, for the error
+func checkResourceQuotaInZones(tx *sqlx.Tx, account types.Account, zoneNames []string, cpuMilliCores, memoryMB int, doing string) error {
+	for _, zoneName := range zoneNames {
+		quota, err := getResourceQuota(tx, zoneName, account.AccountID, true)
+		if err != nil {
+			return fmt.Errorf("Determining whether this account may provision another resource failed: %w", err)
+		}
+		if !quota.Fits(cpuMilliCores, memoryMB) {
+			return fmt.Errorf("If you were to %s, you'd exceed your allocated resources. %s. Please lower the CPU or RAM.", doing, quota.Explain())
+		}
+	}
 	return nil
 }
 
-func SetContainerAsDeactivating(adminDB *sqlx.DB, container types.ContainerClaim) error {
+func addToContainerResourceUsage(tx sqlx.Execer, container types.ContainerClaim) error {
+	return addToResourceUsage(tx, container.CreatedByAccountID, container.Zones, container.CPUMilliCores, container.MemoryMB)
+}
+
+func addToResourceUsage(tx sqlx.Execer, accountID int, zoneNames []string, cpuMilliCores, memoryMB int) error {
+	if accountID == 0 {
+		return fmt.Errorf("Adding to container resource usage for account %v failed: there is no account ID", accountID)
+	}
+	for _, zoneName := range zoneNames {
+		_, err := tx.Exec(`
+		UPDATE container_resource_usage_per_account_per_zone
+		SET used_cpu_millicores = used_cpu_millicores + $1, used_memory_mb = used_memory_mb + $2
+		WHERE zone_name = $3 AND account_id = $4
+		`, cpuMilliCores, memoryMB, zoneName, accountID)
+		if err != nil {
+			return fmt.Errorf("Adding to container resource usage for account %v failed: %w", accountID, err)
+		}
+	}
+	return nil
+}
+
+// Recomputes every account's usage from its live claims and running builds and corrects the rows that are out of step. The usage table
+// stays locked against quota decisions meanwhile, so that nothing gets charged between reading and correcting it.
+func ReconcileResourceUsage(adminDB *sqlx.DB) (drifts []types.ResourceUsageDrift, err error) {
+	tx, err := adminDB.Beginx()
+	if err != nil {
+		return drifts, err
+	}
+
+	_, err = tx.Exec("LOCK TABLE container_resource_usage_per_account_per_zone IN SHARE ROW EXCLUSIVE MODE")
+	if err != nil {
+		_ = tx.Rollback()
+		return drifts, fmt.Errorf("Locking the resource usages for reconciliation failed: %w", err)
+	}
+
+	query := `
+		SELECT ru.zone_name, ru.account_id,
+			ru.used_cpu_millicores AS recorded_cpu_millicores, COALESCE(SUM(c.cpu_millicores), 0) AS actual_cpu_millicores,
+			ru.used_memory_mb AS recorded_memory_mb, COALESCE(SUM(c.memory_mb), 0) AS actual_memory_mb
+		FROM container_resource_usage_per_account_per_zone ru
+		LEFT JOIN (
+			SELECT created_by_account_id, zones, cpu_millicores, memory_mb FROM container_claim
+			WHERE deleted_at IS NULL AND usage_released_at IS NULL
+			UNION ALL
+			SELECT created_by_account_id, ARRAY[zone_name], cpu_millicores, memory_mb FROM image_build
+			WHERE usage_released_at IS NULL
+		) c ON c.created_by_account_id = ru.account_id AND ru.zone_name = ANY(c.zones)
+		GROUP BY ru.container_resource_usage_per_account_per_zone_id
+		HAVING ru.used_cpu_millicores != COALESCE(SUM(c.cpu_millicores), 0) OR ru.used_memory_mb != COALESCE(SUM(c.memory_mb), 0)
+	`
+	err = tx.Select(&drifts, query)
+	if err != nil {
+		_ = tx.Rollback()
+		return drifts, fmt.Errorf("Recomputing the resource usages failed: %w", err)
+	}
+
+	for i, drift := range drifts {
+		_, err = tx.Exec(`
+		UPDATE container_resource_usage_per_account_per_zone
+		SET used_cpu_millicores = $1, used_memory_mb = $2
+		WHERE zone_name = $3 AND account_id = $4
+		`, drift.ActualCPUMilliCores, drift.ActualMemoryMB, drift.ZoneName, drift.AccountID)
+		if err != nil {
+			_ = tx.Rollback()
+			return drifts, fmt.Errorf("Correcting the resource usage of account %v in container_zone %s failed: %w", drift.AccountID, drift.ZoneName, err)
+		}
+		err = tx.Get(&drifts[i], `
+		INSERT INTO container_resource_usage_drift (zone_name, account_id, recorded_cpu_millicores, actual_cpu_millicores, recorded_memory_mb, actual_memory_mb)
+		VALUES ($1, $2, $3, $4, $5, $6)
+		RETURNING *
+		`, drift.ZoneName, drift.AccountID, drift.RecordedCPUMilliCores, drift.ActualCPUMilliCores, drift.RecordedMemoryMB, drift.ActualMemoryMB)
+		if err != nil {
+			_ = tx.Rollback()
+			return drifts, fmt.Errorf("Saving the resource usage drift of account %v in container_zone %s failed: %w", drift.AccountID, drift.ZoneName, err)
+		}
+	}
+
+	err = tx.Commit()
+	if err != nil {
+		return drifts, err
+	}
+	return drifts, nil
+}
+
+func GetResourceUsageDrifts(adminDB *sqlx.DB, since time.Time) (drifts []types.ResourceUsageDrift, err error) {
+	err = adminDB.Select(&drifts, "SELECT * FROM container_resource_usage_drift WHERE found_at >= $1 ORDER BY found_at DESC", since)
+	if err != nil {
+		return drifts, fmt.Errorf("Getting the resource usage drifts failed: %w", err)
+	}
+	return drifts, nil
+}
+
+// Gives a claim's usage back to its account's quota, the first time it's called for that claim. Works off the claim
+// as it is in the DB, so that a caller holding an outdated copy can't give back the wrong amount.
+func releaseContainerResourceUsage(tx *sqlx.Tx, containerClaimID int) error {
 	query := `
 		UPDATE container_claim
-		SET status = 'deactivating'
-		WHERE container_claim_id = $1
+		SET usage_released_at = now()
+		WHERE container_claim_id = $1 AND usage_released_at IS NULL
+		RETURNING *
+	`
+
+	var releasedContainers []types.ContainerClaim
+	err := tx.Select(&releasedContainers, query, containerClaimID)
+	if err != nil {
+		return fmt.Errorf("Releasing the resource usage of container %v failed: %w", containerClaimID, err)
+	}
+	for _, releasedContainer := range releasedContainers {
+		err = removeFromContainerResourceUsage(tx, releasedContainer)
+		if err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+// Never takes the usage below 0, in case it got out of step with the containers
+func removeFromContainerResourceUsage(tx sqlx.Execer, container types.ContainerClaim) error {
+	return removeFromResourceUsage(tx, container.CreatedByAccountID, container.Zones, container.CPUMilliCores, container.MemoryMB)
+}
+
+func removeFromResourceUsage(tx sqlx.Execer, accountID int, zoneNames []string, cpuMilliCores, memoryMB int) error {
+	if accountID == 0 {
+		return fmt.Errorf("Removing container resource usage for account %v failed: there is no account ID", accountID)
+	}
+	for _, zoneName := range zoneNames {
+		_, err := tx.Exec(`
+		UPDATE container_resource_usage_per_account_per_zone
+		SET used_cpu_millicores = GREATEST(used_cpu_millicores - $1, 0), used_memory_mb = GREATEST(used_memory_mb - $2, 0)
+		WHERE zone_name = $3 AND account_id = $4
+		`, cpuMilliCores, memoryMB, zoneName, accountID)
+		if err != nil {
+			return fmt.Errorf("Removing container resource usage for account %v failed: %w", accountID, err)
+		}
+	}
+	return nil
+}
+
+func appendNumberToContainerNameIfExists(adminDB *sqlx.DB, project types.Project, containerName string) (outputContainerName string, err error) {
+	// only the name itself and its numbered copies, so that ie. "api" doesn't turn into "api-gateway-1"
+	getLatestContainerNameQuery := `
+		SELECT name FROM container_claim
+		WHERE project_id = $1 AND (name = $2 OR name ~ ('^' || $3 || '-[0-9]+$')) AND status != 'inactive'
+		ORDER BY created_at DESC
+		LIMIT 1
+	`
+
+	err = adminDB.QueryRow(getLatestContainerNameQuery, project.ProjectID, containerName, regexp.QuoteMeta(containerName)).Scan(&outputContainerName)
+	if err != nil && err != sql.ErrNoRows {
+		return outputContainerName, err
+	}
+
+	// If there was nothing returned, just assume the container name
+	if len(outputContainerName) == 0 {
+		return containerName, nil
+	}
+
+	// Split the string by '-' delimiter
+	parts := strings.Split(outputContainerName, "-")
+
+	// Get the last part of the split string
+	lastPart := parts[len(parts)-1]
+
+	// Check if the last part is a number
+	_, err = strconv.Atoi(lastPart)
+	if err != nil {
+		// If it's not a number, append "-1" to the input string
+		return outputContainerName + "-1", nil
+	}
+
+	// If it's a number, increment it by 1 and append to the input string
+	num, _ := strconv.Atoi(lastPart)
+	num++
+	newLastPart := strconv.Itoa(num)
+	return strings.Join(parts[:len(parts)-1], "-") + "-" + newLastPart, nil
+}
+
+func GetContainersByProject(adminDB *sqlx.DB, project types.Project) (containers []types.ContainerClaim, err error) {
+	query := `
+		SELECT * FROM container_claim
+		WHERE project_id = $1 AND deleted_at IS NULL
+	`
+
+	err = adminDB.Select(&containers, query, project.ProjectID)
+	if err != nil {
+		return containers, err
+	}
+
+	return containers, nil
+}
+
+func GetContainerByProjectAndName(adminDB *sqlx.DB, project types.Project, containerName string) (container types.ContainerClaim, err error) {
+	query := `
+		SELECT container_claim.* FROM container_claim
+		WHERE container_claim.project_id = $1 AND container_claim.name = $2 AND deleted_at IS NULL
+	`
+
+	err = adminDB.Get(&container, query, project.ProjectID, containerName)
+	if err != nil {
+		return container, err
+	}
+
+	return container, nil
+}
+
+// The containers (not deleted) in a project that get the project DB's or a bucket's creds injected
+func GetContainersWithServiceBindings(adminDB *sqlx.DB, project types.Project) (containers []types.ContainerClaim, err error) {
+	query := `
+		SELECT * FROM container_claim
+		WHERE project_id = $1 AND (bind_database OR bound_object_storage IS NOT NULL) AND deleted_at IS NULL
+	`
+
+	err = adminDB.Select(&containers, query, project.ProjectID)
+	if err != nil {
+		return containers, err
+	}
+
+	return containers, nil
+}
+
+func SetContainerAsActivating(adminDB *sqlx.DB, container types.ContainerClaim) error {
+	query := `
+		UPDATE container_claim
+		SET status = 'activating'
+		WHERE container_claim_id = $1
+	`
+
+	_, err := adminDB.Exec(query, container.ContainerClaimID)
+	if err != nil {
+		return err
+	}
+
+	return nil
+}
+
+// Its image is still being built, see image_build
+func SetContainerAsBuilding(adminDB *sqlx.DB, container types.ContainerClaim) error {
+	query := `
+		UPDATE container_claim
+		SET status = 'building'
+		WHERE container_claim_id = $1
+	`
+
+	_, err := adminDB.Exec(query, container.ContainerClaimID)
+	if err != nil {
+		return err
+	}
+
+	return nil
+}
+
+func SetContainerAsActive(adminDB *sqlx.DB, container types.ContainerClaim) error {
+	query := `
+		UPDATE container_claim
+		SET status = 'active'
+		WHERE container_claim_id = $1
+	`
+
+	_, err := adminDB.Exec(query, container.ContainerClaimID)
+	if err != nil {
+		return err
+	}
+
+	return nil
+}
+
+func SetContainerAsDeactivating(adminDB *sqlx.DB, container types.ContainerClaim) error {
+	query := `
+		UPDATE container_claim
+		SET status = 'deactivating'
+		WHERE container_claim_id = $1
+	`
+
+	tx, err := adminDB.Beginx()
+	if err != nil {
+		return err
+	}
+
+	_, err = tx.Exec(query, container.ContainerClaimID)
+	if err != nil {
+		_ = tx.Rollback()
+		return err
+	}
+
+	err = releaseContainerResourceUsage(tx, container.ContainerClaimID)
+	if err != nil {
+		_ = tx.Rollback()
+		return err
+	}
+
+	return tx.Commit()
+}
+
+func SetContainerAsErrorState(adminDB *sqlx.DB, container types.ContainerClaim) error {
+	query := `
+		UPDATE container_claim
+		SET status = 'error'
+		WHERE container_claim_id = $1
+	`
+
+	_, err := adminDB.Exec(query, container.ContainerClaimID)
+	if err != nil {
+		return err
+	}
+
+	return nil
+}
+
+func DeleteContainerByProjectAndName(adminDB *sqlx.DB, project types.Project, containerName string) error {
+	query := `
+		UPDATE container_claim
+		SET deleted_at = now(), status = 'inactive'
+		WHERE project_id = $1 AND name = $2 AND deleted_at IS NULL
+		RETURNING container_claim_id
+	`
+
+	tx, err := adminDB.Beginx()
+	if err != nil {
+		return err
+	}
+
+	var containerClaimIDs []int
+	err = tx.Select(&containerClaimIDs, query, project.ProjectID, containerName)
+	if err != nil {
+		_ = tx.Rollback()
+		return err
+	}
+
+	// usually given back already when it was set as deactivating, this is for the claims that skipped that
+	for _, containerClaimID := range containerClaimIDs {
+		err = releaseContainerResourceUsage(tx, containerClaimID)
+		if err != nil {
+			_ = tx.Rollback()
+			return err
+		}
+	}
+
+	return tx.Commit()
+}
+
+func FindRandomFreePortAndSave(adminDB *sqlx.DB, project types.Project, containerClaim types.ContainerClaim, targetPort int64) (freePortAttempt int, err error) {
+	KUBE_FREE_PORT_RANGE := numRange{10000, 60000}
+	MAX_ATTEMPTS := 100
+
+	tx, err := adminDB.Begin()
+	if err != nil {
+		return freePortAttempt, err
+	}
+
+	for range MAX_ATTEMPTS {
+		// try to find a free port
+		freePortAttempt = KUBE_FREE_PORT_RANGE.low + rand.Intn(KUBE_FREE_PORT_RANGE.hi-KUBE_FREE_PORT_RANGE.low)
+		query := `
+			SELECT COUNT(*) FROM container_claim
+			WHERE $1 = ANY(ports) AND deleted_at IS NULL
+		`
+		var takenPortCount int
+		err = tx.QueryRow(query, freePortAttempt).Scan(&takenPortCount)
+		if err != nil {
+			_ = tx.Rollback()
+			return freePortAttempt, err
+		}
+
+		if takenPortCount != 0 {
+			break
+		}
+	}
+	if freePortAttempt == 0 {
+		_ = tx.Rollback()
+		return freePortAttempt, fmt.Errorf("Ran out of attempts to find a free port!")
+	}
+
+	// reserve this port by saving it
+	savePortQuery := `
+		UPDATE container_claim
+		SET ports = array_replace(ports, $1, $2)
+		WHERE project_id = $3 AND name = $4 AND deleted_at IS NULL
+	`
+	_, err = tx.Exec(savePortQuery, targetPort, freePortAttempt, project.ProjectID, containerClaim.Name)
+	if err != nil {
+		_ = tx.Rollback()
+		return freePortAttempt, err
+	}
+	err = tx.Commit()
+	if err != nil {
+		return freePortAttempt, err
+	}
+	return freePortAttempt, nil
+}
+
+func SaveNodeIPOfRunningContainer(adminDB *sqlx.DB, project types.Project, containerClaim types.ContainerClaim, nodeIP string) error {
+	query := `
+		UPDATE container_claim
+		SET node_ip = $1
+		WHERE project_id = $2 AND name = $3
+	`
+
+	_, err := adminDB.Exec(query, nodeIP, project.ProjectID, containerClaim.Name)
+	if err != nil {
+		return err
+	}
+
+	return nil
+}
+
+func SaveNodeIPOfContainerByID(adminDB *sqlx.DB, containerClaimID int, nodeIP string) error {
+	query := `
+		UPDATE container_claim
+		SET node_ip = $1
+		WHERE container_claim_id = $2
+	`
+
+	_, err := adminDB.Exec(query, nodeIP, containerClaimID)
+	if err != nil {
+		return err
+	}
+
+	return nil
+}
+
+func GetContainersInZone(adminDB *sqlx.DB, zoneName string) (containers []types.ContainerClaim, err error) {
+	query := `
+		SELECT * FROM container_claim
+		WHERE $1 = ANY(zones) AND deleted_at IS NULL
+	`
+
+	err = adminDB.Select(&containers, query, zoneName)
+	if err != nil {
+		return containers, err
+	}
+
+	return containers, nil
+}
+
+// For when a zone goes away under a container that also runs elsewhere. Its usage in that zone is given back.
+func RemoveZoneFromContainer(adminDB *sqlx.DB, container types.ContainerClaim, zoneName string) error {
+	query := `
+		UPDATE container_claim
+		SET zones = array_remove(zones, $1)
+		WHERE container_claim_id = $2 AND $1 = ANY(zones)
+		RETURNING *
+	`
+
+	tx, err := adminDB.Beginx()
+	if err != nil {
+		return err
+	}
+
+	var updatedContainers []types.ContainerClaim
+	err = tx.Select(&updatedContainers, query, zoneName, container.ContainerClaimID)
+	if err != nil {
+		_ = tx.Rollback()
+		return fmt.Errorf("Removing zone %s from container %s failed: %w", zoneName, container.Name, err)
+	}
+
+	// only the first removal gives the zone's usage back, and only if the claim still holds it at all
+	if len(updatedContainers) == 1 && updatedContainers[0].UsageReleasedAt == nil {
+		updatedContainers[0].Zones = pq.StringArray{zoneName}
+		err = removeFromContainerResourceUsage(tx, updatedContainers[0])
+		if err != nil {
+			_ = tx.Rollback()
+			return err
+		}
+	}
+
+	return tx.Commit()
+}
+
+func GetProjectByID(adminDB *sqlx.DB, projectID int) (project types.Project, err error) {
+	err = adminDB.Get(&project, "SELECT * FROM project WHERE project_id = $1", projectID)
+	if err != nil {
+		return project, err
+	}
+
+	return project, nil
+}
+
+func GetContainerByID(adminDB *sqlx.DB, containerClaimID int) (container types.ContainerClaim, err error) {
+	query := `
+		SELECT * FROM container_claim
+		WHERE container_claim_id = $1
+	`
+
+	err = adminDB.Get(&container, query, containerClaimID)
+	if err != nil {
+		return container, err
+	}
+
+	return container, nil
+}
+
+func UpsertContainerZoneStatus(adminDB *sqlx.DB, zoneStatus types.ContainerZoneStatus) error {
+	query := `
+		INSERT INTO container_zone_status (container_claim_id, zone_name, node_ip, pod_phase, ready, ready_replicas, restart_count, last_termination_reason, waiting_reason, waiting_message, updated_at)
+		VALUES ($1, $2, $3, $4, $5, $6, $7, $8, $9, $10, now())
+		ON CONFLICT (container_claim_id, zone_name) DO UPDATE
+		SET node_ip = EXCLUDED.node_ip, pod_phase = EXCLUDED.pod_phase, ready = EXCLUDED.ready,
+			ready_replicas = EXCLUDED.ready_replicas, restart_count = EXCLUDED.restart_count,
+			last_termination_reason = COALESCE(EXCLUDED.last_termination_reason, container_zone_status.last_termination_reason),
+			waiting_reason = EXCLUDED.waiting_reason, waiting_message = EXCLUDED.waiting_message,
+			updated_at = now()
+	`
+
+	_, err := adminDB.Exec(query, zoneStatus.ContainerClaimID, zoneStatus.ZoneName, zoneStatus.NodeIP, zoneStatus.PodPhase, zoneStatus.Ready,
+		zoneStatus.ReadyReplicas, zoneStatus.RestartCount, zoneStatus.LastTerminationReason, zoneStatus.WaitingReason, zoneStatus.WaitingMessage)
+	if err != nil {
+		return fmt.Errorf("Saving the %s status of container %v failed: %w", zoneStatus.ZoneName, zoneStatus.ContainerClaimID, err)
+	}
+
+	return nil
+}
+
+func GetContainerZoneStatuses(adminDB *sqlx.DB, container types.ContainerClaim) (zoneStatuses []types.ContainerZoneStatus, err error) {
+	query := `
+		SELECT * FROM container_zone_status
+		WHERE container_claim_id = $1
+		ORDER BY zone_name
+	`
+
+	err = adminDB.Select(&zoneStatuses, query, container.ContainerClaimID)
+	if err != nil {
+		return zoneStatuses, err
+	}
+
+	return zoneStatuses, nil
+}
+
+// The zone statuses of all of a project's containers at once, keyed by container claim ID
+func GetContainerZoneStatusesByProject(adminDB *sqlx.DB, project types.Project) (zoneStatuses map[int][]types.ContainerZoneStatus, err error) {
+	query := `
+		SELECT container_zone_status.* FROM container_zone_status
+		JOIN container_claim ON container_claim.container_claim_id = container_zone_status.container_claim_id
+		WHERE container_claim.project_id = $1 AND container_claim.deleted_at IS NULL
+		ORDER BY container_zone_status.zone_name
+	`
+
+	var allZoneStatuses []types.ContainerZoneStatus
+	err = adminDB.Select(&allZoneStatuses, query, project.ProjectID)
+	if err != nil {
+		return zoneStatuses, err
+	}
+
+	zoneStatuses = map[int][]types.ContainerZoneStatus{}
+	for _, zoneStatus := range allZoneStatuses {
+		zoneStatuses[zoneStatus.ContainerClaimID] = append(zoneStatuses[zoneStatus.ContainerClaimID], zoneStatus)
+	}
+
+	return zoneStatuses, nil
+}
+
+// Flips a running container between active, degraded and error as its zones come and go. Containers that are still being
+// created or torn down are left to whoever is doing that.
+func SetContainerStatusFromZones(adminDB *sqlx.DB, containerClaimID int, status string) error {
+	query := `
+		UPDATE container_claim
+		SET status = $1
+		WHERE container_claim_id = $2 AND status IN ('active', 'degraded', 'error') AND deleted_at IS NULL
+	`
+
+	_, err := adminDB.Exec(query, status, containerClaimID)
+	if err != nil {
+		return err
+	}
+
+	return nil
+}
+
+var sharedConfigNameRegex = regexp.MustCompile(`^[a-z0-9]([-a-z0-9]{0,48}[a-z0-9])?$`)
+
+func CreateSharedConfigForProject(adminDB *sqlx.DB, account types.Account, project types.Project, sharedConfigInput types.SharedConfig) (sharedConfigOutput types.SharedConfig, err error) {
+	if sharedConfigInput.Kind != "secret" && sharedConfigInput.Kind != "configmap" {
+		return sharedConfigOutput, fmt.Errorf("Unknown kind %q, pick either secret or configmap", sharedConfigInput.Kind)
+	}
+	// it ends up in k8s object names and labels
+	if !sharedConfigNameRegex.MatchString(sharedConfigInput.Name) {
+		return sharedConfigOutput, fmt.Errorf("Shared config names can only have lowercase letters, numbers and dashes, and be at most 50 characters long")
+	}
+
+	tx, err := adminDB.Beginx()
+	if err != nil {
+		return sharedConfigOutput, err
+	}
+
+	var sharedConfigID int
+	err = tx.QueryRow(`
+		INSERT INTO project_shared_config (project_id, name, kind, version, keys, config_values)
+		VALUES ($1, $2, $3, 1, $4, $5)
+		RETURNING project_shared_config_id
+	`, project.ProjectID, sharedConfigInput.Name, sharedConfigInput.Kind, sharedConfigInput.Keys, sharedConfigInput.ConfigValues).Scan(&sharedConfigID)
+	if err != nil {
+		_ = tx.Rollback()
+		if strings.Contains(err.Error(), "unique_active_shared_configs") {
+			return sharedConfigOutput, fmt.Errorf("There's already a shared secret or config called %q in this project", sharedConfigInput.Name)
+		}
+		return sharedConfigOutput, err
+	}
+
+	sharedConfigOutput = sharedConfigInput
+	sharedConfigOutput.SharedConfigID = sharedConfigID
+	sharedConfigOutput.ProjectID = project.ProjectID
+	sharedConfigOutput.Version = 1
+
+	err = insertSharedConfigVersion(tx, account, sharedConfigOutput)
+	if err != nil {
+		_ = tx.Rollback()
+		return sharedConfigOutput, err
+	}
+
+	err = tx.Commit()
+	if err != nil {
+		return sharedConfigOutput, err
+	}
+
+	return sharedConfigOutput, nil
+}
+
+// Moves the shared config on to the version it's been given. The k8s objects for it have to exist already.
+func SaveNewSharedConfigVersion(adminDB *sqlx.DB, account types.Account, sharedConfig types.SharedConfig) (types.SharedConfig, error) {
+	tx, err := adminDB.Beginx()
+	if err != nil {
+		return sharedConfig, err
+	}
+
+	_, err = tx.Exec(`
+		UPDATE project_shared_config
+		SET version = $1, keys = $2, config_values = $3, updated_at = now()
+		WHERE project_shared_config_id = $4
+	`, sharedConfig.Version, sharedConfig.Keys, sharedConfig.ConfigValues, sharedConfig.SharedConfigID)
+	if err != nil {
+		_ = tx.Rollback()
+		return sharedConfig, err
+	}
+
+	err = insertSharedConfigVersion(tx, account, sharedConfig)
+	if err != nil {
+		_ = tx.Rollback()
+		return sharedConfig, err
+	}
+
+	err = tx.Commit()
+	if err != nil {
+		return sharedConfig, err
+	}
+
+	return sharedConfig, nil
+}
+
+func insertSharedConfigVersion(tx *sqlx.Tx, account types.Account, sharedConfig types.SharedConfig) error {
+	_, err := tx.Exec(`
+		INSERT INTO project_shared_config_version (project_shared_config_id, version, keys, config_values, created_by_account_id)
+		VALUES ($1, $2, $3, $4, $5)
+	`, sharedConfig.SharedConfigID, sharedConfig.Version, sharedConfig.Keys, sharedConfig.ConfigValues, account.AccountID)
+	if err != nil {
+		return fmt.Errorf("Saving version %v of %s failed: %w", sharedConfig.Version, sharedConfig.Name, err)
+	}
+	return nil
+}
+
+func GetSharedConfigsByProject(adminDB *sqlx.DB, project types.Project) (sharedConfigs []types.SharedConfig, err error) {
+	query := `
+		SELECT * FROM project_shared_config
+		WHERE project_id = $1 AND deleted_at IS NULL
+		ORDER BY name
+	`
+
+	err = adminDB.Select(&sharedConfigs, query, project.ProjectID)
+	if err != nil {
+		return sharedConfigs, err
+	}
+
+	return sharedConfigs, nil
+}
+
+func GetSharedConfigByProjectAndName(q sqlx.Queryer, project types.Project, sharedConfigName string) (sharedConfig types.SharedConfig, err error) {
+	query := `
+		SELECT * FROM project_shared_config
+		WHERE project_id = $1 AND name = $2 AND deleted_at IS NULL
+	`
+
+	err = sqlx.Get(q, &sharedConfig, query, project.ProjectID, sharedConfigName)
+	if err != nil {
+		return sharedConfig, err
+	}
+
+	return sharedConfig, nil
+}
+
+func GetSharedConfigVersions(adminDB *sqlx.DB, sharedConfig types.SharedConfig) (versions []types.SharedConfigVersion, err error) {
+	query := `
+		SELECT * FROM project_shared_config_version
+		WHERE project_shared_config_id = $1
+		ORDER BY version DESC
+	`
+
+	err = adminDB.Select(&versions, query, sharedConfig.SharedConfigID)
+	if err != nil {
+		return versions, err
+	}
+
+	return versions, nil
+}
+
+// The containers (not deleted) in a project that pull their env from the given shared config
+func GetContainersUsingSharedConfig(adminDB *sqlx.DB, project types.Project, sharedConfigName string) (containers []types.ContainerClaim, err error) {
+	query := `
+		SELECT * FROM container_claim
+		WHERE project_id = $1 AND $2 = ANY(shared_config_names) AND deleted_at IS NULL
+	`
+
+	err = adminDB.Select(&containers, query, project.ProjectID, sharedConfigName)
+	if err != nil {
+		return containers, err
+	}
+
+	return containers, nil
+}
+
+func DeleteSharedConfigByProjectAndName(adminDB *sqlx.DB, project types.Project, sharedConfigName string) error {
+	query := `
+		UPDATE project_shared_config
+		SET deleted_at = now()
+		WHERE project_id = $1 AND name = $2 AND deleted_at IS NULL
+	`
+
+	_, err := adminDB.Exec(query, project.ProjectID, sharedConfigName)
+	if err != nil {
+		return err
+	}
+
+	return nil
+}
+
+func CreateUserDBClaimForProject(adminDB *sqlx.DB, project types.Project, userDBClaimInput types.UserDBClaim) (userDBClaimOutput types.UserDBClaim, err error) {
+	createObjectStorageQuery := `
+		WITH inserted_user_db_claim AS (
+			INSERT INTO user_db_claim (project_id, zones)
+			VALUES ($1, $2)
+			RETURNING user_db_claim_id
+		)
+		SELECT user_db_claim_id FROM inserted_user_db_claim
+	`
+
+	err = checkProjectNotSuspended(project)
+	if err != nil {
+		return userDBClaimOutput, err
+	}
+
+	var userDBClaimID int
+	err = adminDB.QueryRow(createObjectStorageQuery, project.ProjectID, userDBClaimInput.Zones).Scan(&userDBClaimID)
+	if err != nil {
+		return userDBClaimOutput, err
+	}
+
+	userDBClaimOutput = userDBClaimInput
+	userDBClaimOutput.UserDBClaimID = userDBClaimID
+
+	return userDBClaimOutput, nil
+}
+
+func GetUserDBClaimsByProject(adminDB *sqlx.DB, project types.Project) (userDBClaims []types.UserDBClaim, err error) {
+	query := `
+		SELECT * FROM user_db_claim
+		WHERE project_id = $1 AND deleted_at IS NULL
+	`
+
+	err = adminDB.Select(&userDBClaims, query, project.ProjectID)
+	if err != nil {
+		return userDBClaims, err
+	}
+
+	return userDBClaims, nil
+}
+
+func GetUserDBClaimByProject(q sqlx.Queryer, project types.Project) (userDBClaim types.UserDBClaim, err error) {
+	query := `
+		SELECT user_db_claim.* FROM user_db_claim
+		WHERE user_db_claim.project_id = $1 AND deleted_at IS NULL
+	`
+
+	err = sqlx.Get(q, &userDBClaim, query, project.ProjectID)
+	if err != nil {
+		return userDBClaim, err
+	}
+
+	return userDBClaim, nil
+}
+
+func AddCredentialsToUserDBClaim(adminDB *sqlx.DB, userDBClaim types.UserDBClaim, newCreds types.UserDBClaimCredentials) error {
+	query := `
+		UPDATE user_db_claim
+		SET credentials = $2
+		WHERE user_db_claim_id = $1
+	`
+	newsCredsJSON, err := json.Marshal(&newCreds)
+	if err != nil {
+		return err
+	}
+
+	_, err = adminDB.Exec(query, userDBClaim.UserDBClaimID, newsCredsJSON)
+	if err != nil {
+		return err
+	}
+
+	return nil
+}
+
+func SetUserDBClaimAsActivating(adminDB *sqlx.DB, userDBClaim types.UserDBClaim) error {
+	query := `
+		UPDATE user_db_claim
+		SET status = 'activating'
+		WHERE user_db_claim_id = $1
+	`
+
+	_, err := adminDB.Exec(query, userDBClaim.UserDBClaimID)
+	if err != nil {
+		return err
+	}
+
+	return nil
+}
+
+func SetUserDBClaimAsActive(adminDB *sqlx.DB, userDBClaim types.UserDBClaim) error {
+	query := `
+		UPDATE user_db_claim
+		SET status = 'active'
+		WHERE user_db_claim_id = $1
+	`
+
+	_, err := adminDB.Exec(query, userDBClaim.UserDBClaimID)
+	if err != nil {
+		return err
+	}
+
+	return nil
+}
+
+func SetUserDBClaimAsDeactivating(adminDB *sqlx.DB, userDBClaim types.UserDBClaim) error {
+	query := `
+		UPDATE user_db_claim
+		SET status = 'deactivating'
+		WHERE user_db_claim_id = $1
+	`
+
+	_, err := adminDB.Exec(query, userDBClaim.UserDBClaimID)
+	if err != nil {
+		return err
+	}
+
+	return nil
+}
+
+func DeleteUserDBClaimByProject(adminDB *sqlx.DB, project types.Project) error {
+	query := `
+		UPDATE user_db_claim
+		SET deleted_at = now(), status = 'inactive'
+		WHERE project_id = $1 AND deleted_at IS NULL
+	`
+
+	_, err := adminDB.Exec(query, project.ProjectID)
+	if err != nil {
+		return err
+	}
+
+	return nil
+}
+
+func CreateObjectStorageForProject(adminDB *sqlx.DB, project types.Project, objectStorageInput types.ObjectStorageClaim) (objectStorageOutput types.ObjectStorageClaim, err error) {
+	createObjectStorageQuery := `
+		WITH inserted_object_storage_claim AS (
+			INSERT INTO object_storage_claim (project_id, name, zones)
+			VALUES ($1, $2, $3)
+			RETURNING object_storage_claim_id
+		)
+		SELECT object_storage_claim_id FROM inserted_object_storage_claim
+	`
+
+	err = checkProjectNotSuspended(project)
+	if err != nil {
+		return objectStorageOutput, err
+	}
+
+	var objectStorageClaimID int
+	err = adminDB.QueryRow(createObjectStorageQuery, project.ProjectID, objectStorageInput.Name, objectStorageInput.Zones).Scan(&objectStorageClaimID)
+	if err != nil {
+		return objectStorageOutput, err
+	}
+
+	objectStorageOutput = objectStorageInput
+	objectStorageOutput.ObjectStorageClaimID = objectStorageClaimID
+
+	return objectStorageOutput, nil
+}
+
+func GetObjectStoragesByProject(adminDB *sqlx.DB, project types.Project) (objectStorages []types.ObjectStorageClaim, err error) {
+	query := `
+		SELECT * FROM object_storage_claim
+		WHERE project_id = $1 AND deleted_at IS NULL
+	`
+
+	err = adminDB.Select(&objectStorages, query, project.ProjectID)
+	if err != nil {
+		return objectStorages, err
+	}
+
+	return objectStorages, nil
+}
+
+func GetObjectStorageByProjectAndName(q sqlx.Queryer, project types.Project, objectStorageName string) (objectStorage types.ObjectStorageClaim, err error) {
+	query := `
+		SELECT object_storage_claim.* FROM object_storage_claim
+		WHERE object_storage_claim.project_id = $1 AND object_storage_claim.name = $2 AND deleted_at IS NULL
+	`
+
+	err = sqlx.Get(q, &objectStorage, query, project.ProjectID, objectStorageName)
+	if err != nil {
+		return objectStorage, err
+	}
+
+	return objectStorage, nil
+}
+
+func SetObjectStorageCredentials(adminDB *sqlx.DB, objectStorage types.ObjectStorageClaim) error {
+	query := `
+		UPDATE object_storage_claim
+		SET credentials = $2
+		WHERE object_storage_claim_id = $1
+	`
+
+	_, err := adminDB.Exec(query, objectStorage.ObjectStorageClaimID, objectStorage.Credentials)
+	if err != nil {
+		return err
+	}
+
+	return nil
+}
+
+func SetObjectStorageAsDeactivating(adminDB *sqlx.DB, objectStorage types.ObjectStorageClaim) error {
+	query := `
+		UPDATE object_storage_claim
+		SET status = 'deactivating'
+		WHERE object_storage_claim_id = $1
+	`
+
+	_, err := adminDB.Exec(query, objectStorage.ObjectStorageClaimID)
+	if err != nil {
+		return err
+	}
+
+	return nil
+}
+
+func DeleteObjectStorageByProjectAndName(adminDB *sqlx.DB, project types.Project, objectStorageName string) error {
+	query := `
+		UPDATE object_storage_claim
+		SET deleted_at = now(), status = 'inactive'
+		WHERE project_id = $1 AND name = $2 AND deleted_at IS NULL
+	`
+
+	_, err := adminDB.Exec(query, project.ProjectID, objectStorageName)
+	if err != nil {
+		return err
+	}
+
+	return nil
+}
+
+// Appends an entry to the project's ledger, carrying the balance on from the one before. The project row gets locked
+// first, so that two entries going in at once can't both build on the same balance. An entry whose idempotency key
+// is already in the ledger gets skipped, and comes back without a billing_id.
+func insertBillingEntry(tx *sqlx.Tx, entry types.BillingEntry) (types.BillingEntry, error) {
+	_, err := tx.Exec("SELECT 1 FROM project WHERE project_id = $1 FOR UPDATE", entry.ProjectID)
+	if err != nil {
+		return entry, fmt.Errorf("Locking project %v for billing failed: %w", entry.ProjectID, err)
+	}
+
+	balance, err := getProjectBalance(tx, types.Project{ProjectID: entry.ProjectID})
+	if err != nil {
+		return entry, err
+	}
+	entry.CurrentCredits = balance + entry.CreditsDelta
+
+	query := `
+		INSERT INTO billing (project_id, kind, credits_delta, current_credits, details, period_start, period_end, cpu_millicore_hours, memory_mb_hours, storage_gb_hours, idempotency_key)
+		VALUES ($1, $2, $3, $4, $5, $6, $7, $8, $9, $10, $11)
+		ON CONFLICT (idempotency_key) DO NOTHING
+		RETURNING *
+	`
+	err = tx.Get(&entry, query, entry.ProjectID, entry.Kind, entry.CreditsDelta, entry.CurrentCredits, entry.Details, entry.PeriodStart,
+		entry.PeriodEnd, entry.CPUMilliCoreHours, entry.MemoryMBHours, entry.StorageGBHours, entry.IdempotencyKey)
+	if err == sql.ErrNoRows {
+		return types.BillingEntry{}, nil
+	}
+	if err != nil {
+		return entry, fmt.Errorf("Adding a %s entry for project %v failed: %w", entry.Kind, entry.ProjectID, err)
+	}
+
+	return entry, nil
+}
+
+// Suspended projects keep what they already run, but can't start anything new until they're topped up
+func checkProjectNotSuspended(project types.Project) error {
+	if project.SuspendedAt != nil {
+		return fmt.Errorf("This project has been suspended since %s for running out of credits, it can't start anything new until it's topped up",
+			project.SuspendedAt.Format("2006-01-02 15:04"))
+	}
+	return nil
+}
+
+func GetAllProjects(adminDB *sqlx.DB) (projects []types.Project, err error) {
+	err = adminDB.Select(&projects, "SELECT * FROM project WHERE deleted_at IS NULL ORDER BY project_id")
+	if err != nil {
+		return projects, fmt.Errorf("Getting all projects failed: %w", err)
+	}
+	return projects, nil
+}
+
+// Every claim of the project that was around for some of [$2, $3), with the part it was around for. Containers count
+// for as long as their usage is charged, the storage claims until they're deleted.
+const meteredClaimsQuery = `
+	SELECT 'container' AS kind, container_claim_id AS claim_id, name, cpu_millicores, memory_mb, 0 AS storage_gb, cardinality(zones) AS replicas,
+		GREATEST(created_at, $2) AS metered_from, LEAST(COALESCE(usage_released_at, $3), $3) AS metered_until
+	FROM container_claim
+	WHERE project_id = $1 AND created_at < $3 AND (usage_released_at IS NULL OR usage_released_at > $2)
+	UNION ALL
+	SELECT 'user_db', user_db_claim_id, 'database', 0, 0, storage_gb, cardinality(zones),
+		GREATEST(created_at, $2), LEAST(COALESCE(deleted_at, $3), $3)
+	FROM user_db_claim
+	WHERE project_id = $1 AND created_at < $3 AND (deleted_at IS NULL OR deleted_at > $2)
+	UNION ALL
+	SELECT 'object_storage', object_storage_claim_id, name, 0, 0, storage_gb, cardinality(zones),
+		GREATEST(created_at, $2), LEAST(COALESCE(deleted_at, $3), $3)
+	FROM object_storage_claim
+	WHERE project_id = $1 AND created_at < $3 AND (deleted_at IS NULL OR deleted_at > $2)
+	ORDER BY kind, claim_id
+`
+
+// Charges the project for what its claims used between where the last metering left off and until, as one usage
+// entry with every claim's part in its details. Periods without any claims around only move metered_until on.
+func MeterProjectUsage(adminDB *sqlx.DB, project types.Project, until time.Time, policy types.BillingPolicy) (entry types.BillingEntry, err error) {
+	tx, err := adminDB.Beginx()
+	if err != nil {
+		return entry, err
+	}
+
+	var meteredFrom time.Time
+	err = tx.Get(&meteredFrom, "SELECT COALESCE(metered_until, created_at) FROM project WHERE project_id = $1 FOR UPDATE", project.ProjectID)
+	if err != nil {
+		_ = tx.Rollback()
+		return entry, fmt.Errorf("Getting where metering project %v left off failed: %w", project.ProjectID, err)
+	}
+	if !until.After(meteredFrom) {
+		_ = tx.Rollback()
+		return entry, nil
+	}
+
+	claims := []types.MeteredClaim{}
+	err = tx.Select(&claims, meteredClaimsQuery, project.ProjectID, meteredFrom, until)
+	if err != nil {
+		_ = tx.Rollback()
+		return entry, fmt.Errorf("Getting the claims of project %v to meter failed: %w", project.ProjectID, err)
+	}
+
+	if len(claims) > 0 {
+		idempotencyKey := fmt.Sprintf("usage:%v:%s", project.ProjectID, meteredFrom.UTC().Format(time.RFC3339))
+		entry = types.BillingEntry{
+			ProjectID:      project.ProjectID,
+			Kind:           "usage",
+			PeriodStart:    &meteredFrom,
+			PeriodEnd:      &until,
+			IdempotencyKey: &idempotencyKey,
+		}
+		for i := range claims {
+			claims[i].Meter(policy)
+			entry.CreditsDelta -= claims[i].Credits
+			entry.CPUMilliCoreHours += claims[i].CPUMilliCoreHours
+			entry.MemoryMBHours += claims[i].MemoryMBHours
+			entry.StorageGBHours += claims[i].StorageGBHours
+		}
+		entry.Details.Claims = claims
+
+		entry, err = insertBillingEntry(tx, entry)
+		if err != nil {
+			_ = tx.Rollback()
+			return entry, err
+		}
+	}
+
+	_, err = tx.Exec("UPDATE project SET metered_until = $2 WHERE project_id = $1", project.ProjectID, until)
+	if err != nil {
+		_ = tx.Rollback()
+		return entry, fmt.Errorf("Moving project %v's metered_until on failed: %w", project.ProjectID, err)
+	}
+
+	err = tx.Commit()
+	if err != nil {
+		return entry, err
+	}
+
+	return entry, nil
+}
+
+// Gives the project its credits for the month that starts at month: the donation credits if one of its members has
+// an approved donated node, the flat fee's worth otherwise. Only one of them goes in per project per month, however
+// often this gets called.
+func AddMonthlyCredits(adminDB *sqlx.DB, project types.Project, month time.Time, policy types.BillingPolicy) (entry types.BillingEntry, err error) {
+	donatedNodesQuery := `
+		SELECT donated_node.donated_node_id FROM donated_node
+		JOIN account_project ON donated_node.account_id = account_project.account_id
+		WHERE account_project.project_id = $1 AND donated_node.status = 'approved'
+		ORDER BY donated_node.donated_node_id
+	`
+
+	donatedNodeIDs := []int{}
+	err = adminDB.Select(&donatedNodeIDs, donatedNodesQuery, project.ProjectID)
+	if err != nil {
+		return entry, fmt.Errorf("Getting the donated nodes of project %v's members failed: %w", project.ProjectID, err)
+	}
+
+	nextMonth := month.AddDate(0, 1, 0)
+	idempotencyKey := fmt.Sprintf("monthly:%v:%s", project.ProjectID, month.Format("2006-01"))
+	entry = types.BillingEntry{
+		ProjectID:      project.ProjectID,
+		Kind:           "flat_fee",
+		CreditsDelta:   policy.FlatFeeCredits,
+		PeriodStart:    &month,
+		PeriodEnd:      &nextMonth,
+		IdempotencyKey: &idempotencyKey,
+	}
+	if len(donatedNodeIDs) > 0 {
+		entry.Kind = "donation"
+		entry.CreditsDelta = policy.DonationCredits
+		entry.Details.DonatedNodeIDs = donatedNodeIDs
+	}
+	if entry.CreditsDelta == 0 {
+		return types.BillingEntry{}, nil
+	}
+
+	tx, err := adminDB.Beginx()
+	if err != nil {
+		return entry, err
+	}
+
+	entry, err = insertBillingEntry(tx, entry)
+	if err != nil {
+		_ = tx.Rollback()
+		return entry, err
+	}
+
+	err = tx.Commit()
+	if err != nil {
+		return entry, err
+	}
+
+	return entry, nil
+}
+
+// For operators to top a project up or correct its balance, since the ledger itself can't be changed
+func AddCreditsToProject(adminDB *sqlx.DB, account types.Account, project types.Project, kind string, credits float64, note string) (entry types.BillingEntry, err error) {
+	tx, err := adminDB.Beginx()
+	if err != nil {
+		return entry, err
+	}
+
+	entry, err = insertBillingEntry(tx, types.BillingEntry{
+		ProjectID:    project.ProjectID,
+		Kind:         kind,
+		CreditsDelta: credits,
+		Details:      types.BillingDetails{Note: note, ByAccountID: account.AccountID},
+	})
+	if err != nil {
+		_ = tx.Rollback()
+		return entry, err
+	}
+
+	err = tx.Commit()
+	if err != nil {
+		return entry, err
+	}
+
+	return entry, nil
+}
+
+// Suspends the project once it's been below the policy's threshold for longer than the grace period, and lifts the
+// suspension as soon as it's back above it. Returns the project as it is afterwards.
+func ApplySuspensionPolicy(adminDB *sqlx.DB, project types.Project, policy types.SuspensionPolicy, now time.Time) (types.Project, error) {
+	tx, err := adminDB.Beginx()
+	if err != nil {
+		return project, err
+	}
+
+	err = tx.Get(&project, "SELECT * FROM project WHERE project_id = $1 FOR UPDATE", project.ProjectID)
+	if err != nil {
+		_ = tx.Rollback()
+		return project, fmt.Errorf("Getting project %v to check its suspension failed: %w", project.ProjectID, err)
+	}
+
+	balance, err := getProjectBalance(tx, project)
+	if err != nil {
+		_ = tx.Rollback()
+		return project, err
+	}
+
+	if balance >= policy.BelowCredits {
+		project.CreditsExhaustedAt = nil
+		project.SuspendedAt = nil
+	} else {
+		if project.CreditsExhaustedAt == nil {
+			project.CreditsExhaustedAt = &now
+		}
+		if project.SuspendedAt == nil && policy.ShouldSuspend(*project.CreditsExhaustedAt, now) {
+			project.SuspendedAt = &now
+		}
+	}
+	// turning the policy off lifts every suspension
+	if !policy.Enabled {
+		project.SuspendedAt = nil
+	}
+
+	_, err = tx.Exec("UPDATE project SET credits_exhausted_at = $2, suspended_at = $3 WHERE project_id = $1", project.ProjectID, project.CreditsExhaustedAt, project.SuspendedAt)
+	if err != nil {
+		_ = tx.Rollback()
+		return project, fmt.Errorf("Saving project %v's suspension failed: %w", project.ProjectID, err)
+	}
+
+	err = tx.Commit()
+	if err != nil {
+		return project, err
+	}
+
+	return project, nil
+}
+
+func getProjectBalance(q sqlx.Queryer, project types.Project) (balance float64, err error) {
+	err = sqlx.Get(q, &balance, "SELECT COALESCE((SELECT current_credits FROM billing WHERE project_id = $1 ORDER BY billing_id DESC LIMIT 1), 0)", project.ProjectID)
+	if err != nil {
+		return balance, fmt.Errorf("Getting the balance of project %v failed: %w", project.ProjectID, err)
+	}
+	return balance, nil
+}
+
+func GetProjectBalance(adminDB *sqlx.DB, project types.Project) (balance float64, err error) {
+	return getProjectBalance(adminDB, project)
+}
+
+// The project's ledger since the given time, newest first
+func GetBillingEntriesByProject(adminDB *sqlx.DB, project types.Project, since time.Time) (entries []types.BillingEntry, err error) {
+	query := `
+		SELECT * FROM billing
+		WHERE project_id = $1 AND created_at >= $2
+		ORDER BY billing_id DESC
+	`
+
+	err = adminDB.Select(&entries, query, project.ProjectID, since)
+	if err != nil {
+		return entries, fmt.Errorf("Getting the billing of project %v failed: %w", project.ProjectID, err)
+	}
+
+	return entries, nil
+}
+
+// Adds the samples into their hour's rollup. Samples of containers we don't know (anymore) get dropped.
+func SaveContainerUsageSamples(adminDB *sqlx.DB, sampledAt time.Time, samples []types.ContainerUsageSample) error {
+	query := `
+		INSERT INTO container_usage_rollup (container_claim_id, zone_name, hour, sample_count, cpu_millicores_sum, cpu_millicores_max, memory_mb_sum, memory_mb_max)
+		SELECT $1, $2, date_trunc('hour', $3::TIMESTAMPTZ), 1, $4, $4, $5, $5
+		WHERE EXISTS (SELECT 1 FROM container_claim WHERE container_claim_id = $1)
+		ON CONFLICT (container_claim_id, zone_name, hour) DO UPDATE SET
+			sample_count = container_usage_rollup.sample_count + 1,
+			cpu_millicores_sum = container_usage_rollup.cpu_millicores_sum + EXCLUDED.cpu_millicores_sum,
+			cpu_millicores_max = GREATEST(container_usage_rollup.cpu_millicores_max, EXCLUDED.cpu_millicores_max),
+			memory_mb_sum = container_usage_rollup.memory_mb_sum + EXCLUDED.memory_mb_sum,
+			memory_mb_max = GREATEST(container_usage_rollup.memory_mb_max, EXCLUDED.memory_mb_max)
+	`
+
+	tx, err := adminDB.Begin()
+	if err != nil {
+		return err
+	}
+	for _, sample := range samples {
+		_, err = tx.Exec(query, sample.ContainerClaimID, sample.ZoneName, sampledAt, sample.CPUMilliCores, sample.MemoryMB)
+		if err != nil {
+			_ = tx.Rollback()
+			return fmt.Errorf("Saving the usage sample of container %v in zone %s failed: %w", sample.ContainerClaimID, sample.ZoneName, err)
+		}
+	}
+	return tx.Commit()
+}
+
+// Every container's usage in every zone between the filter's from and to, by the hour they were sampled in
+func GetUsageReport(adminDB *sqlx.DB, filter types.UsageReportFilter) (rows []types.UsageReportRow, err error) {
+	query := `
+		SELECT project.project_id, project.name AS project_name, account.account_id, account.username,
+			container_claim.container_claim_id, container_claim.name AS container_name, rollup.zone_name,
+			MIN(rollup.hour) AS first_sampled_at, MAX(rollup.hour) AS last_sampled_at, SUM(rollup.sample_count) AS sample_count,
+			container_claim.cpu_millicores AS requested_cpu_millicores,
+			SUM(rollup.cpu_millicores_sum)::DOUBLE PRECISION / SUM(rollup.sample_count) AS avg_cpu_millicores,
+			MAX(rollup.cpu_millicores_max) AS max_cpu_millicores,
+			container_claim.memory_mb AS requested_memory_mb,
+			SUM(rollup.memory_mb_sum)::DOUBLE PRECISION / SUM(rollup.sample_count) AS avg_memory_mb,
+			MAX(rollup.memory_mb_max) AS max_memory_mb
+		FROM container_usage_rollup rollup
+		JOIN container_claim ON rollup.container_claim_id = container_claim.container_claim_id
+		JOIN project ON container_claim.project_id = project.project_id
+		JOIN account ON container_claim.created_by_account_id = account.account_id
+		WHERE rollup.hour >= date_trunc('hour', $1::TIMESTAMPTZ) AND rollup.hour < $2
+			AND ($3 = 0 OR container_claim.project_id = $3)
+			AND ($4 = 0 OR container_claim.created_by_account_id = $4)
+		GROUP BY project.project_id, account.account_id, container_claim.container_claim_id, rollup.zone_name
+		ORDER BY project.name, container_claim.name, container_claim.container_claim_id, rollup.zone_name
+	`
+
+	rows = []types.UsageReportRow{}
+	err = adminDB.Select(&rows, query, filter.From, filter.To, filter.ProjectID, filter.AccountID)
+	if err != nil {
+		return rows, fmt.Errorf("Getting the usage report failed: %w", err)
+	}
+	for i := range rows {
+		rows[i].CompareRequestedWithUsed()
+	}
+
+	return rows, nil
+}
+
+// A container's hourly usage rollups in one zone between from and to, oldest first
+func GetContainerUsageRollups(adminDB *sqlx.DB, container types.ContainerClaim, zoneName string, from time.Time, to time.Time) (rollups []types.ContainerUsageRollup, err error) {
+	query := `
+		SELECT * FROM container_usage_rollup
+		WHERE container_claim_id = $1 AND zone_name = $2
+			AND hour >= date_trunc('hour', $3::TIMESTAMPTZ) AND hour < $4
+		ORDER BY hour
+	`
+
+	rollups = []types.ContainerUsageRollup{}
+	err = adminDB.Select(&rollups, query, container.ContainerClaimID, zoneName, from, to)
+	if err != nil {
+		return rollups, fmt.Errorf("Getting the usage rollups of container %v in zone %s failed: %w", container.ContainerClaimID, zoneName, err)
+	}
+
+	return rollups, nil
+}
+
+func GetContainerClaimCounts(adminDB *sqlx.DB) (counts []types.ContainerClaimCount, err error) {
+	query := `
+		SELECT status, run_type, count(*) AS count FROM container_claim
+		WHERE deleted_at IS NULL
+		GROUP BY status, run_type
+	`
+
+	err = adminDB.Select(&counts, query)
+	if err != nil {
+		return counts, fmt.Errorf("Counting container claims failed: %w", err)
+	}
+	return counts, nil
+}
+
+func GetZoneUsages(adminDB *sqlx.DB) (usages []types.ZoneUsage, err error) {
+	query := `
+		SELECT container_zone.name AS zone_name, container_zone.cpu_millicores, container_zone.memory_mb,
+			COALESCE(SUM(ru.used_cpu_millicores), 0) AS used_cpu_millicores, COALESCE(SUM(ru.used_memory_mb), 0) AS used_memory_mb
+		FROM container_zone
+		LEFT JOIN container_resource_usage_per_account_per_zone ru ON ru.zone_name = container_zone.name
+		WHERE container_zone.retired_at IS NULL
+		GROUP BY container_zone.name
+		ORDER BY container_zone.name
+	`
+
+	err = adminDB.Select(&usages, query)
+	if err != nil {
+		return usages, fmt.Errorf("Getting the zones' usage failed: %w", err)
+	}
+	return usages, nil
+}
+
+// The project's members, for alerting on their quotas
+func GetAccountsByProject(adminDB *sqlx.DB, project types.Project) (accounts []types.Account, err error) {
+	query := `
+		SELECT account.* FROM account
+		JOIN account_project ON account.account_id = account_project.account_id
+		WHERE account_project.project_id = $1 AND account.deleted_at IS NULL
+		ORDER BY account.account_id
+	`
+
+	err = adminDB.Select(&accounts, query, project.ProjectID)
+	if err != nil {
+		return accounts, fmt.Errorf("Getting the members of project %v failed: %w", project.ProjectID, err)
+	}
+	return accounts, nil
+}
+
+func CreateNotificationChannelForProject(adminDB *sqlx.DB, project types.Project, channelInput types.NotificationChannel) (channelOutput types.NotificationChannel, err error) {
+	query := `
+		INSERT INTO notification_channel (project_id, name, kind, url)
+		VALUES ($1, $2, $3, $4)
+		RETURNING *
+	`
+
+	err = adminDB.Get(&channelOutput, query, project.ProjectID, channelInput.Name, channelInput.Kind, channelInput.URL)
+	if err != nil {
+		return channelOutput, fmt.Errorf("Creating notification channel %s failed: %w", channelInput.Name, err)
+	}
+	return channelOutput, nil
+}
+
+func GetNotificationChannelsByProject(adminDB *sqlx.DB, project types.Project) (channels []types.NotificationChannel, err error) {
+	query := `
+		SELECT * FROM notification_channel
+		WHERE project_id = $1 AND deleted_at IS NULL
+		ORDER BY name
+	`
+
+	channels = []types.NotificationChannel{}
+	err = adminDB.Select(&channels, query, project.ProjectID)
+	if err != nil {
+		return channels, err
+	}
+	return channels, nil
+}
+
+// Rules that still send to the channel just stop sending there
+func DeleteNotificationChannelByProjectAndName(adminDB *sqlx.DB, project types.Project, channelName string) error {
+	query := `
+		UPDATE notification_channel
+		SET deleted_at = now()
+		WHERE project_id = $1 AND name = $2 AND deleted_at IS NULL
+	`
+
+	result, err := adminDB.Exec(query, project.ProjectID, channelName)
+	if err != nil {
+		return err
+	}
+	if rows, _ := result.RowsAffected(); rows == 0 {
+		return fmt.Errorf("There's no notification channel called %s in this project", channelName)
+	}
+	return nil
+}
+
+func CreateAlertRuleForProject(adminDB *sqlx.DB, account types.Account, project types.Project, ruleInput types.AlertRule) (ruleOutput types.AlertRule, err error) {
+	query := `
+		INSERT INTO alert_rule (project_id, created_by_account_id, name, kind, target, threshold, notification_channel_ids, repeat_after_minutes)
+		VALUES ($1, $2, $3, $4, $5, $6, $7, $8)
+		RETURNING *
+	`
+
+	err = adminDB.Get(&ruleOutput, query, project.ProjectID, account.AccountID, ruleInput.Name, ruleInput.Kind, ruleInput.Target,
+		ruleInput.Threshold, ruleInput.NotificationChannelIDs, ruleInput.RepeatAfterMinutes)
+	if err != nil {
+		return ruleOutput, fmt.Errorf("Creating alert rule %s failed: %w", ruleInput.Name, err)
+	}
+	return ruleOutput, nil
+}
+
+func GetAlertRulesByProject(adminDB *sqlx.DB, project types.Project) (rules []types.AlertRule, err error) {
+	query := `
+		SELECT * FROM alert_rule
+		WHERE project_id = $1 AND deleted_at IS NULL
+		ORDER BY name
+	`
+
+	rules = []types.AlertRule{}
+	err = adminDB.Select(&rules, query, project.ProjectID)
+	if err != nil {
+		return rules, err
+	}
+	return rules, nil
+}
+
+func GetAlertRuleByProjectAndName(adminDB *sqlx.DB, project types.Project, ruleName string) (rule types.AlertRule, err error) {
+	query := `
+		SELECT * FROM alert_rule
+		WHERE project_id = $1 AND name = $2 AND deleted_at IS NULL
+	`
+
+	err = adminDB.Get(&rule, query, project.ProjectID, ruleName)
+	if err != nil {
+		return rule, fmt.Errorf("Getting alert rule %s failed: %w", ruleName, err)
+	}
+	return rule, nil
+}
+
+// The rules of every project that's still around, for the evaluator
+func GetAllAlertRules(adminDB *sqlx.DB) (rules []types.AlertRule, err error) {
+	query := `
+		SELECT alert_rule.* FROM alert_rule
+		JOIN project ON alert_rule.project_id = project.project_id
+		WHERE alert_rule.deleted_at IS NULL AND project.deleted_at IS NULL
+		ORDER BY alert_rule.project_id, alert_rule.alert_rule_id
+	`
+
+	err = adminDB.Select(&rules, query)
+	if err != nil {
+		return rules, fmt.Errorf("Getting the alert rules failed: %w", err)
+	}
+	return rules, nil
+}
+
+// Whatever the rule was firing for is forgotten, so nothing gets resolved for it later
+func DeleteAlertRule(adminDB *sqlx.DB, rule types.AlertRule) error {
+	tx, err := adminDB.Beginx()
+	if err != nil {
+		return err
+	}
+	_, err = tx.Exec("UPDATE alert_rule SET deleted_at = now() WHERE alert_rule_id = $1", rule.AlertRuleID)
+	if err != nil {
+		_ = tx.Rollback()
+		return fmt.Errorf("Deleting alert rule %s failed: %w", rule.Name, err)
+	}
+	_, err = tx.Exec("DELETE FROM alert_firing WHERE alert_rule_id = $1", rule.AlertRuleID)
+	if err != nil {
+		_ = tx.Rollback()
+		return fmt.Errorf("Deleting alert rule %s failed: %w", rule.Name, err)
+	}
+	return tx.Commit()
+}
+
+// Until silencedUntil the rule's notifications are only kept, not sent. nil unsilences it.
+func SilenceAlertRule(adminDB *sqlx.DB, rule types.AlertRule, silencedUntil *time.Time) (types.AlertRule, error) {
+	err := adminDB.Get(&rule, "UPDATE alert_rule SET silenced_until = $2 WHERE alert_rule_id = $1 RETURNING *", rule.AlertRuleID, silencedUntil)
+	if err != nil {
+		return rule, fmt.Errorf("Silencing alert rule %s failed: %w", rule.Name, err)
+	}
+	return rule, nil
+}
+
+func GetAlertFirings(adminDB *sqlx.DB, rule types.AlertRule) (firings []types.AlertFiring, err error) {
+	err = adminDB.Select(&firings, "SELECT * FROM alert_firing WHERE alert_rule_id = $1", rule.AlertRuleID)
+	if err != nil {
+		return firings, fmt.Errorf("Getting what alert rule %v is firing for failed: %w", rule.AlertRuleID, err)
+	}
+	return firings, nil
+}
+
+func GetAlertFiringsByProject(adminDB *sqlx.DB, project types.Project) (firings []types.AlertFiring, err error) {
+	query := `
+		SELECT alert_firing.* FROM alert_firing
+		JOIN alert_rule ON alert_firing.alert_rule_id = alert_rule.alert_rule_id
+		WHERE alert_rule.project_id = $1 AND alert_rule.deleted_at IS NULL
+		ORDER BY alert_firing.firing_since DESC
+	`
+
+	firings = []types.AlertFiring{}
+	err = adminDB.Select(&firings, query, project.ProjectID)
+	if err != nil {
+		return firings, err
+	}
+	return firings, nil
+}
+
+// Saves that the rule's firing for the alert and was just notified about, whether it only started or is repeated
+func SaveAlertFiring(adminDB *sqlx.DB, rule types.AlertRule, alert types.Alert, notifiedAt time.Time) error {
+	query := `
+		INSERT INTO alert_firing (alert_rule_id, fingerprint, firing_since, last_notified_at, title)
+		VALUES ($1, $2, $3, $3, $4)
+		ON CONFLICT (alert_rule_id, fingerprint) DO UPDATE SET last_notified_at = EXCLUDED.last_notified_at, title = EXCLUDED.title
+	`
+
+	_, err := adminDB.Exec(query, rule.AlertRuleID, alert.Fingerprint, notifiedAt, alert.Title)
+	if err != nil {
+		return fmt.Errorf("Saving alert %s of rule %v failed: %w", alert.Fingerprint, rule.AlertRuleID, err)
+	}
+	return nil
+}
+
+func DeleteAlertFiring(adminDB *sqlx.DB, firing types.AlertFiring) error {
+	_, err := adminDB.Exec("DELETE FROM alert_firing WHERE alert_rule_id = $1 AND fingerprint = $2", firing.AlertRuleID, firing.Fingerprint)
+	if err != nil {
+		return fmt.Errorf("Resolving alert %s of rule %v failed: %w", firing.Fingerprint, firing.AlertRuleID, err)
+	}
+	return nil
+}
+
+func InsertNotification(adminDB *sqlx.DB, notification types.Notification) (types.Notification, error) {
+	query := `
+		INSERT INTO notification (project_id, alert_rule_id, fingerprint, title, message, resolved, silenced)
+		VALUES ($1, $2, $3, $4, $5, $6, $7)
+		RETURNING *
+	`
+
+	err := adminDB.Get(&notification, query, notification.ProjectID, notification.AlertRuleID, notification.Fingerprint,
+		notification.Title, notification.Message, notification.Resolved, notification.Silenced)
+	if err != nil {
+		return notification, fmt.Errorf("Saving notification %s failed: %w", notification.Title, err)
+	}
+	return notification, nil
+}
+
+func SaveNotificationDeliveries(adminDB *sqlx.DB, notification types.Notification) error {
+	_, err := adminDB.Exec("UPDATE notification SET deliveries = $2 WHERE notification_id = $1", notification.NotificationID, notification.Deliveries)
+	if err != nil {
+		return fmt.Errorf("Saving the deliveries of notification %v failed: %w", notification.NotificationID, err)
+	}
+	return nil
+}
+
+// The project's notification history, newest first
+func GetNotificationsByProject(adminDB *sqlx.DB, project types.Project, since time.Time) (notifications []types.Notification, err error) {
+	query := `
+		SELECT * FROM notification
+		WHERE project_id = $1 AND created_at >= $2
+		ORDER BY notification_id DESC
+	`
+
+	notifications = []types.Notification{}
+	err = adminDB.Select(&notifications, query, project.ProjectID, since)
+	if err != nil {
+		return notifications, err
+	}
+	return notifications, nil
+}
+
+func CountUnreadNotifications(adminDB *sqlx.DB, project types.Project) (count int, err error) {
+	err = adminDB.Get(&count, "SELECT count(*) FROM notification WHERE project_id = $1 AND read_at IS NULL", project.ProjectID)
+	if err != nil {
+		return count, err
+	}
+	return count, nil
+}
+
+func MarkNotificationsAsRead(adminDB *sqlx.DB, project types.Project) error {
+	_, err := adminDB.Exec("UPDATE notification SET read_at = now() WHERE project_id = $1 AND read_at IS NULL", project.ProjectID)
+	if err != nil {
+		return err
+	}
+	return nil
+}
+
+func CreateWebhookSubscriptionForProject(adminDB *sqlx.DB, project types.Project, subscriptionInput types.WebhookSubscription) (subscriptionOutput types.WebhookSubscription, err error) {
+	query := `
+		INSERT INTO webhook_subscription (project_id, name, url, secret, events)
+		VALUES ($1, $2, $3, $4, $5)
+		RETURNING *
+	`
+
+	err = adminDB.Get(&subscriptionOutput, query, project.ProjectID, subscriptionInput.Name, subscriptionInput.URL, subscriptionInput.Secret, subscriptionInput.Events)
+	if err != nil {
+		return subscriptionOutput, fmt.Errorf("Creating webhook %s failed: %w", subscriptionInput.Name, err)
+	}
+	return subscriptionOutput, nil
+}
+
+func GetWebhookSubscriptionsByProject(adminDB *sqlx.DB, project types.Project) (subscriptions []types.WebhookSubscription, err error) {
+	query := `
+		SELECT * FROM webhook_subscription
+		WHERE project_id = $1 AND deleted_at IS NULL
+		ORDER BY name
+	`
+
+	subscriptions = []types.WebhookSubscription{}
+	err = adminDB.Select(&subscriptions, query, project.ProjectID)
+	if err != nil {
+		return subscriptions, err
+	}
+	return subscriptions, nil
+}
+
+// Including deleted ones, whose deliveries are still in the log
+func GetWebhookSubscriptionByID(adminDB *sqlx.DB, subscriptionID int) (subscription types.WebhookSubscription, err error) {
+	err = adminDB.Get(&subscription, "SELECT * FROM webhook_subscription WHERE webhook_subscription_id = $1", subscriptionID)
+	if err != nil {
+		return subscription, err
+	}
+	return subscription, nil
+}
+
+// What's still waiting to be delivered to it is given up on
+func DeleteWebhookSubscriptionByProjectAndName(adminDB *sqlx.DB, project types.Project, subscriptionName string) error {
+	tx, err := adminDB.Beginx()
+	if err != nil {
+		return err
+	}
+	var subscriptionID int
+	err = tx.Get(&subscriptionID, `
+		UPDATE webhook_subscription
+		SET deleted_at = now()
+		WHERE project_id = $1 AND name = $2 AND deleted_at IS NULL
+		RETURNING webhook_subscription_id
+	`, project.ProjectID, subscriptionName)
+	if err == sql.ErrNoRows {
+		_ = tx.Rollback()
+		return fmt.Errorf("There's no webhook called %s in this project", subscriptionName)
+	}
+	if err != nil {
+		_ = tx.Rollback()
+		return fmt.Errorf("Deleting webhook %s failed: %w", subscriptionName, err)
+	}
+	_, err = tx.Exec(`
+		UPDATE webhook_delivery
+		SET status = 'failed', error = 'The webhook was deleted'
+		WHERE webhook_subscription_id = $1 AND status = 'pending'
+	`, subscriptionID)
+	if err != nil {
+		_ = tx.Rollback()
+		return fmt.Errorf("Deleting webhook %s failed: %w", subscriptionName, err)
+	}
+	return tx.Commit()
+}
+
+// Keeps the event along with a pending delivery for each of the subscriptions
+func CreateWebhookEvent(adminDB *sqlx.DB, eventInput types.WebhookEvent, subscriptions []types.WebhookSubscription) (eventOutput types.WebhookEvent, err error) {
+	tx, err := adminDB.Beginx()
+	if err != nil {
+		return eventOutput, err
+	}
+	err = tx.Get(&eventOutput, `
+		INSERT INTO webhook_event (project_id, event, data)
+		VALUES ($1, $2, $3)
+		RETURNING *
+	`, eventInput.ProjectID, eventInput.Event, eventInput.Data)
+	if err != nil {
+		_ = tx.Rollback()
+		return eventOutput, fmt.Errorf("Saving webhook event %s failed: %w", eventInput.Event, err)
+	}
+	for _, subscription := range subscriptions {
+		_, err = tx.Exec(`
+			INSERT INTO webhook_delivery (webhook_subscription_id, webhook_event_id)
+			VALUES ($1, $2)
+		`, subscription.WebhookSubscriptionID, eventOutput.WebhookEventID)
+		if err != nil {
+			_ = tx.Rollback()
+			return eventOutput, fmt.Errorf("Saving webhook event %s failed: %w", eventInput.Event, err)
+		}
+	}
+	return eventOutput, tx.Commit()
+}
+
+func GetWebhookEventByID(adminDB *sqlx.DB, eventID int) (event types.WebhookEvent, err error) {
+	err = adminDB.Get(&event, "SELECT * FROM webhook_event WHERE webhook_event_id = $1", eventID)
+	if err != nil {
+		return event, err
+	}
+	return event, nil
+}
+
+const webhookDeliveryColumns = `
+	webhook_delivery.*, webhook_event.event, webhook_subscription.name AS subscription_name
+	FROM webhook_delivery
+	JOIN webhook_event USING (webhook_event_id)
+	JOIN webhook_subscription USING (webhook_subscription_id)
+`
+
+// The ones whose next attempt is due, oldest first
+func GetDueWebhookDeliveries(adminDB *sqlx.DB, limit int) (deliveries []types.WebhookDelivery, err error) {
+	query := `SELECT ` + webhookDeliveryColumns + `
+		WHERE webhook_delivery.status = 'pending' AND webhook_delivery.next_attempt_at <= now()
+		ORDER BY webhook_delivery.next_attempt_at
+		LIMIT $1
+	`
+
+	deliveries = []types.WebhookDelivery{}
+	err = adminDB.Select(&deliveries, query, limit)
+	if err != nil {
+		return deliveries, err
+	}
+	return deliveries, nil
+}
+
+// Newest first
+func GetWebhookDeliveriesByProject(adminDB *sqlx.DB, project types.Project, since time.Time) (deliveries []types.WebhookDelivery, err error) {
+	query := `SELECT ` + webhookDeliveryColumns + `
+		WHERE webhook_subscription.project_id = $1 AND webhook_delivery.created_at >= $2
+		ORDER BY webhook_delivery.webhook_delivery_id DESC
+	`
+
+	deliveries = []types.WebhookDelivery{}
+	err = adminDB.Select(&deliveries, query, project.ProjectID, since)
+	if err != nil {
+		return deliveries, err
+	}
+	return deliveries, nil
+}
+
+func GetWebhookDeliveryByProjectAndID(adminDB *sqlx.DB, project types.Project, deliveryID int) (delivery types.WebhookDelivery, err error) {
+	query := `SELECT ` + webhookDeliveryColumns + `
+		WHERE webhook_subscription.project_id = $1 AND webhook_delivery.webhook_delivery_id = $2
+	`
+
+	err = adminDB.Get(&delivery, query, project.ProjectID, deliveryID)
+	if err != nil {
+		return delivery, fmt.Errorf("There's no webhook delivery %v in this project", deliveryID)
+	}
+	return delivery, nil
+}
+
+// Saves how the delivery's last attempt went, along with its status and next attempt
+func SaveWebhookDeliveryAttempt(adminDB *sqlx.DB, delivery types.WebhookDelivery) error {
+	query := `
+		UPDATE webhook_delivery
+		SET status = $2, attempts = $3, next_attempt_at = $4, last_attempt_at = $5, response_status = $6, error = $7
+		WHERE webhook_delivery_id = $1
+	`
+
+	_, err := adminDB.Exec(query, delivery.WebhookDeliveryID, delivery.Status, delivery.Attempts, delivery.NextAttemptAt, delivery.LastAttemptAt, delivery.ResponseStatus, delivery.Error)
+	if err != nil {
+		return fmt.Errorf("Saving webhook delivery %v failed: %w", delivery.WebhookDeliveryID, err)
+	}
+	return nil
+}
+
+// Sends the delivery's event to its subscription again, as a new delivery with retries of its own
+func RedeliverWebhookDelivery(adminDB *sqlx.DB, delivery types.WebhookDelivery) (redelivery types.WebhookDelivery, err error) {
+	query := `
+		INSERT INTO webhook_delivery (webhook_subscription_id, webhook_event_id, redelivery_of)
+		SELECT webhook_subscription_id, $2, $3 FROM webhook_subscription
+		WHERE webhook_subscription_id = $1 AND deleted_at IS NULL
+		RETURNING *
+	`
+
+	err = adminDB.Get(&redelivery, query, delivery.WebhookSubscriptionID, delivery.WebhookEventID, delivery.WebhookDeliveryID)
+	if err == sql.ErrNoRows {
+		return redelivery, fmt.Errorf("The webhook %s was deleted", delivery.SubscriptionName)
+	}
+	if err != nil {
+		return redelivery, fmt.Errorf("Redelivering webhook delivery %v failed: %w", delivery.WebhookDeliveryID, err)
+	}
+	redelivery.Event = delivery.Event
+	redelivery.SubscriptionName = delivery.SubscriptionName
+	return redelivery, nil
+}
+
+// Replaces the container's deploy hook if it already has one, so that the old URL stops working
+func CreateDeployHookForContainer(adminDB *sqlx.DB, account types.Account, project types.Project, container types.ContainerClaim, followTag bool) (hook types.DeployHook, err error) {
+	token, err := types.NewDeployHookToken()
+	if err != nil {
+		return hook, err
+	}
+
+	tx, err := adminDB.Beginx()
+	if err != nil {
+		return hook, err
+	}
+	_, err = tx.Exec(`
+		UPDATE deploy_hook
+		SET deleted_at = now()
+		WHERE project_id = $1 AND container_name = $2 AND deleted_at IS NULL
+	`, project.ProjectID, container.Name)
+	if err != nil {
+		_ = tx.Rollback()
+		return hook, fmt.Errorf("Creating deploy hook for container %s failed: %w", container.Name, err)
+	}
+	err = tx.Get(&hook, `
+		INSERT INTO deploy_hook (project_id, created_by_account_id, container_name, token, follow_tag)
+		VALUES ($1, $2, $3, $4, $5)
+		RETURNING *
+	`, project.ProjectID, account.AccountID, container.Name, token, followTag)
+	if err != nil {
+		_ = tx.Rollback()
+		return hook, fmt.Errorf("Creating deploy hook for container %s failed: %w", container.Name, err)
+	}
+	return hook, tx.Commit()
+}
+
+func GetDeployHookByProjectAndContainerName(adminDB *sqlx.DB, project types.Project, containerName string) (hook types.DeployHook, err error) {
+	query := `
+		SELECT * FROM deploy_hook
+		WHERE project_id = $1 AND container_name = $2 AND deleted_at IS NULL
+	`
+
+	err = adminDB.Get(&hook, query, project.ProjectID, containerName)
+	if err != nil {
+		return hook, err
+	}
+	return hook, nil
+}
+
+func GetDeployHookByToken(adminDB *sqlx.DB, token string) (hook types.DeployHook, err error) {
+	err = adminDB.Get(&hook, "SELECT * FROM deploy_hook WHERE token = $1 AND deleted_at IS NULL", token)
+	if err != nil {
+		return hook, err
+	}
+	return hook, nil
+}
+
+// The hooks that follow their container's tag, least recently polled first
+func GetFollowingDeployHooks(adminDB *sqlx.DB) (hooks []types.DeployHook, err error) {
+	query := `
+		SELECT * FROM deploy_hook
+		WHERE follow_tag AND deleted_at IS NULL
+		ORDER BY last_polled_at NULLS FIRST
+	`
+
+	hooks = []types.DeployHook{}
+	err = adminDB.Select(&hooks, query)
+	if err != nil {
+		return hooks, err
+	}
+	return hooks, nil
+}
+
+func SetDeployHookFollowTag(adminDB *sqlx.DB, hook types.DeployHook, followTag bool) (types.DeployHook, error) {
+	err := adminDB.Get(&hook, "UPDATE deploy_hook SET follow_tag = $2 WHERE deploy_hook_id = $1 RETURNING *", hook.DeployHookID, followTag)
+	if err != nil {
+		return hook, fmt.Errorf("Saving deploy hook of container %s failed: %w", hook.ContainerName, err)
+	}
+	return hook, nil
+}
+
+func SetDeployHookPolled(adminDB *sqlx.DB, hook types.DeployHook) error {
+	_, err := adminDB.Exec("UPDATE deploy_hook SET last_polled_at = now() WHERE deploy_hook_id = $1", hook.DeployHookID)
+	if err != nil {
+		return fmt.Errorf("Saving deploy hook of container %s failed: %w", hook.ContainerName, err)
+	}
+	return nil
+}
+
+func SetDeployHookResult(adminDB *sqlx.DB, hook types.DeployHook, result string) error {
+	_, err := adminDB.Exec("UPDATE deploy_hook SET last_triggered_at = now(), last_result = $2 WHERE deploy_hook_id = $1", hook.DeployHookID, result)
+	if err != nil {
+		return fmt.Errorf("Saving deploy hook of container %s failed: %w", hook.ContainerName, err)
+	}
+	return nil
+}
+
+// Doesn't mind the container not having one, since deleting the container deletes its hook too
+func DeleteDeployHookByProjectAndContainerName(adminDB *sqlx.DB, project types.Project, containerName string) error {
+	query := `
+		UPDATE deploy_hook
+		SET deleted_at = now()
+		WHERE project_id = $1 AND container_name = $2 AND deleted_at IS NULL
+	`
+
+	_, err := adminDB.Exec(query, project.ProjectID, containerName)
+	if err != nil {
+		return fmt.Errorf("Deleting the deploy hook of container %s failed: %w", containerName, err)
+	}
+	return nil
+}
+
+// Everything but the tarball and the logs, which can both be big
+const imageBuildSummaryColumns = `
+	image_build_id, created_at, project_id, created_by_account_id, source, git_url, git_ref, context_path, dockerfile_path,
+	context_token, zone_name, push_zones, image_ref, image_tag, image_digest, cpu_millicores, memory_mb, usage_released_at,
+	status, error, started_at, finished_at, container_claim_id
+`
+
+// Charges the build's CPU and memory to the account in the zone it runs in, in the same transaction its quota is
+// checked in, like a container's. It's given back once the build is done.
+func CreateImageBuildForProject(adminDB *sqlx.DB, account types.Account, project types.Project, buildInput types.ImageBuild) (buildOutput types.ImageBuild, err error) {
+	err = checkProjectNotSuspended(project)
+	if err != nil {
+		return buildOutput, err
+	}
+
+	tx, err := adminDB.Beginx()
+	if err != nil {
+		return buildOutput, err
+	}
+
+	err = checkResourceQuotaInZones(tx, account, []string{buildInput.ZoneName}, buildInput.CPUMilliCores, buildInput.MemoryMB, "run this build")
+	if err != nil {
+		_ = tx.Rollback()
+		return buildOutput, err
+	}
+
+	query := `
+		INSERT INTO image_build (project_id, created_by_account_id, source, git_url, git_ref, context_path, dockerfile_path, context_tarball, context_token, zone_name, push_zones, image_ref, image_tag, cpu_millicores, memory_mb, container_claim_id)
+		VALUES ($1, $2, $3, $4, $5, $6, $7, $8, $9, $10, $11, $12, $13, $14, $15, $16)
+		RETURNING ` + imageBuildSummaryColumns
+	err = tx.Get(&buildOutput, query, project.ProjectID, account.AccountID, buildInput.Source, buildInput.GitURL, buildInput.GitRef, buildInput.ContextPath, buildInput.DockerfilePath,
+		buildInput.ContextTarball, buildInput.ContextToken, buildInput.ZoneName, buildInput.PushZones, buildInput.ImageRef, buildInput.ImageTag, buildInput.CPUMilliCores, buildInput.MemoryMB, buildInput.ContainerClaimID)
+	if err != nil {
+		_ = tx.Rollback()
+		return buildOutput, fmt.Errorf("Saving the image build failed: %w", err)
+	}
+
+	err = addToResourceUsage(tx, account.AccountID, []string{buildOutput.ZoneName}, buildOutput.CPUMilliCores, buildOutput.MemoryMB)
+	if err != nil {
+		_ = tx.Rollback()
+		return buildOutput, err
+	}
+
+	err = tx.Commit()
+	if err != nil {
+		return buildOutput, err
+	}
+	return buildOutput, nil
+}
+
+// The claim gets its image from the build, so it can only be tied to it once both exist
+func SetImageBuildContainer(adminDB *sqlx.DB, build types.ImageBuild, container types.ContainerClaim) (types.ImageBuild, error) {
+	_, err := adminDB.Exec("UPDATE image_build SET container_claim_id = $2 WHERE image_build_id = $1", build.ImageBuildID, container.ContainerClaimID)
+	if err != nil {
+		return build, fmt.Errorf("Saving image build %v failed: %w", build.ImageBuildID, err)
+	}
+	build.ContainerClaimID = &container.ContainerClaimID
+	return build, nil
+}
+
+func GetImageBuildsByProject(adminDB *sqlx.DB, project types.Project, since time.Time) (builds []types.ImageBuild, err error) {
+	query := `
+		SELECT ` + imageBuildSummaryColumns + ` FROM image_build
+		WHERE project_id = $1 AND created_at >= $2
+		ORDER BY created_at DESC
+	`
+
+	err = adminDB.Select(&builds, query, project.ProjectID, since)
+	if err != nil {
+		return builds, fmt.Errorf("Getting the image builds of project %v failed: %w", project.ProjectID, err)
+	}
+	return builds, nil
+}
+
+// With its logs, if it's done
+func GetImageBuildByProjectAndID(adminDB *sqlx.DB, project types.Project, imageBuildID int) (build types.ImageBuild, err error) {
+	query := `
+		SELECT ` + imageBuildSummaryColumns + `, logs FROM image_build
+		WHERE project_id = $1 AND image_build_id = $2
 	`
 
-	_, err := adminDB.Exec(query, container.ContainerClaimID)
+	err = adminDB.Get(&build, query, project.ProjectID, imageBuildID)
 	if err != nil {
-		return err
+		return build, fmt.Errorf("Getting image build %v failed: %w", imageBuildID, err)
 	}
+	return build, nil
+}
+
+// The last build a container was deployed from, if it came from one
+func GetImageBuildByContainer(adminDB *sqlx.DB, container types.ContainerClaim) (build types.ImageBuild, err error) {
+	query := `
+		SELECT ` + imageBuildSummaryColumns + ` FROM image_build
+		WHERE project_id = $1 AND image_ref = $2 AND image_tag = $3
+		ORDER BY created_at DESC
+		LIMIT 1
+	`
 
-	err = removeFromContainerResourceUsage(adminDB, container)
+	err = adminDB.Get(&build, query, container.ProjectID, container.ImageRef, container.ImageTag)
 	if err != nil {
-		return err
+		return build, err
 	}
-
-	return nil
+	return build, nil
 }
 
-func SetContainerAsErrorState(adminDB *sqlx.DB, container types.ContainerClaim) error {
+// Only while the build's still going, the tarball's dropped after that
+func GetImageBuildByContextToken(adminDB *sqlx.DB, contextToken string) (build types.ImageBuild, err error) {
 	query := `
-		UPDATE container_claim
-		SET status = 'error'
-		WHERE container_claim_id = $1
+		SELECT * FROM image_build
+		WHERE context_token = $1 AND finished_at IS NULL
 	`
 
-	_, err := adminDB.Exec(query, container.ContainerClaimID)
+	err = adminDB.Get(&build, query, contextToken)
 	if err != nil {
-		return err
+		return build, err
 	}
-
-	return nil
+	return build, nil
 }
 
-func DeleteContainerByProjectAndName(adminDB *sqlx.DB, project types.Project, containerName string) error {
+func GetUnfinishedImageBuilds(adminDB *sqlx.DB) (builds []types.ImageBuild, err error) {
 	query := `
-		UPDATE container_claim
-		SET deleted_at = now(), status = 'inactive'
-		WHERE project_id = $1 AND name = $2
+		SELECT ` + imageBuildSummaryColumns + ` FROM image_build
+		WHERE finished_at IS NULL
+		ORDER BY created_at
 	`
 
-	_, err := adminDB.Exec(query, project.ProjectID, containerName)
+	err = adminDB.Select(&builds, query)
 	if err != nil {
-		return err
+		return builds, fmt.Errorf("Getting the unfinished image builds failed: %w", err)
 	}
-
-	return nil
+	return builds, nil
 }
 
-func FindRandomFreePortAndSave(adminDB *sqlx.DB, project types.Project, containerClaim types.ContainerClaim, targetPort int64) (freePortAttempt int, err error) {
-	KUBE_FREE_PORT_RANGE := numRange{10000, 60000}
-	MAX_ATTEMPTS := 100
+func SetImageBuildAsBuilding(adminDB *sqlx.DB, build types.ImageBuild) (types.ImageBuild, error) {
+	query := `
+		UPDATE image_build SET status = 'building', started_at = now()
+		WHERE image_build_id = $1
+		RETURNING ` + imageBuildSummaryColumns
 
-	tx, err := adminDB.Begin()
+	err := adminDB.Get(&build, query, build.ImageBuildID)
 	if err != nil {
-		return freePortAttempt, err
+		return build, fmt.Errorf("Saving image build %v failed: %w", build.ImageBuildID, err)
 	}
+	return build, nil
+}
 
-	for range MAX_ATTEMPTS {
-		// try to find a free port
-		freePortAttempt = KUBE_FREE_PORT_RANGE.low + rand.Intn(KUBE_FREE_PORT_RANGE.hi-KUBE_FREE_PORT_RANGE.low)
-		query := `
-			SELECT COUNT(*) FROM container_claim
-			WHERE $1 = ANY(ports) AND deleted_at IS NULL
-		`
-		var takenPortCount int
-		err = tx.QueryRow(query, freePortAttempt).Scan(&takenPortCount)
-		if err != nil {
-			_ = tx.Rollback()
-			return freePortAttempt, err
-		}
+// Saves how it went, drops the tarball and gives the build's CPU and memory back to the account's quota, all once:
+// a build that's already finished stays as it was
+func FinishImageBuild(adminDB *sqlx.DB, build types.ImageBuild, digest *string, buildError *string, logs string) (types.ImageBuild, error) {
+	status := "succeeded"
+	if buildError != nil {
+		status = "failed"
+	}
 
-		if takenPortCount != 0 {
-			break
-		}
+	tx, err := adminDB.Beginx()
+	if err != nil {
+		return build, err
 	}
-	if freePortAttempt == 0 {
+
+	query := `
+		UPDATE image_build
+		SET status = $2, image_digest = $3, error = $4, logs = $5, finished_at = now(), usage_released_at = now(), context_tarball = NULL, context_token = NULL
+		WHERE image_build_id = $1 AND finished_at IS NULL
+		RETURNING ` + imageBuildSummaryColumns + `, logs`
+	var finishedBuilds []types.ImageBuild
+	err = tx.Select(&finishedBuilds, query, build.ImageBuildID, status, digest, buildError, logs)
+	if err != nil {
 		_ = tx.Rollback()
-		return freePortAttempt, fmt.Errorf("Ran out of attempts to find a free port!")
+		return build, fmt.Errorf("Saving image build %v failed: %w", build.ImageBuildID, err)
 	}
+	if len(finishedBuilds) == 0 {
+		_ = tx.Rollback()
+		return build, fmt.Errorf("Image build %v was already finished", build.ImageBuildID)
+	}
+	build = finishedBuilds[0]
 
-	// reserve this port by saving it
-	savePortQuery := `
-		UPDATE container_claim
-		SET ports = array_replace(ports, $1, $2)
-		WHERE project_id = $3 AND name = $4 AND deleted_at IS NULL
-	`
-	_, err = tx.Exec(savePortQuery, targetPort, freePortAttempt, project.ProjectID, containerClaim.Name)
+	err = removeFromResourceUsage(tx, build.CreatedByAccountID, []string{build.ZoneName}, build.CPUMilliCores, build.MemoryMB)
 	if err != nil {
 		_ = tx.Rollback()
-		return freePortAttempt, err
+		return build, err
 	}
+
 	err = tx.Commit()
 	if err != nil {
-		return freePortAttempt, err
+		return build, err
 	}
-	return freePortAttempt, nil
+	return build, nil
 }
 
-func SaveNodeIPOfRunningContainer(adminDB *sqlx.DB, project types.Project, containerClaim types.ContainerClaim, nodeIP string) error {
-	query := `
-		UPDATE container_claim
-		SET node_ip = $1
-		WHERE project_id = $2 AND name = $3
-	`
+// Fills in the digest of a container that was waiting on its image being built, on its revision too
+func SetContainerImageDigest(adminDB *sqlx.DB, container types.ContainerClaim, digest string) (types.ContainerClaim, error) {
+	tx, err := adminDB.Beginx()
+	if err != nil {
+		return container, err
+	}
 
-	_, err := adminDB.Exec(query, nodeIP, project.ProjectID, containerClaim.Name)
+	_, err = tx.Exec("UPDATE container_claim SET image_digest = $2 WHERE container_claim_id = $1", container.ContainerClaimID, digest)
 	if err != nil {
-		return err
+		_ = tx.Rollback()
+		return container, fmt.Errorf("Saving the image digest of container %s failed: %w", container.Name, err)
+	}
+	_, err = tx.Exec("UPDATE container_revision SET image_digest = $2 WHERE container_claim_id = $1", container.ContainerClaimID, digest)
+	if err != nil {
+		_ = tx.Rollback()
+		return container, fmt.Errorf("Saving the image digest of container %s failed: %w", container.Name, err)
 	}
 
-	return nil
+	err = tx.Commit()
+	if err != nil {
+		return container, err
+	}
+	container.ImageDigest = &digest
+	return container, nil
 }
 
-func CreateUserDBClaimForProject(adminDB *sqlx.DB, project types.Project, userDBClaimInput types.UserDBClaim) (userDBClaimOutput types.UserDBClaim, err error) {
-	createObjectStorageQuery := `
-		WITH inserted_user_db_claim AS (
-			INSERT INTO user_db_claim (project_id, zones)
-			VALUES ($1, $2)
-			RETURNING user_db_claim_id
-		)
-		SELECT user_db_claim_id FROM inserted_user_db_claim
-	`
-
-	var userDBClaimID int
-	err = adminDB.QueryRow(createObjectStorageQuery, project.ProjectID, userDBClaimInput.Zones).Scan(&userDBClaimID)
+// The returned credential has its password, which is the only time it's around unencrypted
+func CreateRegistryCredentialForProject(adminDB *sqlx.DB, key []byte, project types.Project, credentialInput types.RegistryCredential) (credential types.RegistryCredential, err error) {
+	username, password, err := types.NewRegistryCredentialLogin(project, credentialInput.Name)
 	if err != nil {
-		return userDBClaimOutput, err
+		return credential, err
+	}
+	credentialInput.ProjectID = project.ProjectID
+	credentialInput.Password = password
+	err = credentialInput.Seal(key)
+	if err != nil {
+		return credential, err
 	}
 
-	userDBClaimOutput = userDBClaimInput
-	userDBClaimOutput.UserDBClaimID = userDBClaimID
+	query := `
+		INSERT INTO registry_credential (project_id, name, username, sealed_password, can_push)
+		VALUES ($1, $2, $3, $4, $5)
+		RETURNING *
+	`
+	err = adminDB.Get(&credential, query, project.ProjectID, credentialInput.Name, username, credentialInput.SealedPassword, credentialInput.CanPush)
+	if err != nil {
+		return credential, fmt.Errorf("Creating registry credential %s failed, is there one called that already? %w", credentialInput.Name, err)
+	}
+	credential.Password = password
+	return credential, nil
+}
 
-	return userDBClaimOutput, nil
+// For the credentials we use ourselves, which get made the first time they're needed. Comes opened.
+func GetOrCreateRegistryCredential(adminDB *sqlx.DB, key []byte, project types.Project, name string, canPush bool) (credential types.RegistryCredential, err error) {
+	query := `
+		SELECT * FROM registry_credential
+		WHERE project_id = $1 AND name = $2 AND deleted_at IS NULL
+	`
+	err = adminDB.Get(&credential, query, project.ProjectID, name)
+	if err == sql.ErrNoRows {
+		credential, err = CreateRegistryCredentialForProject(adminDB, key, project, types.RegistryCredential{Name: name, CanPush: canPush})
+		if err != nil {
+			createErr := err
+			// made by someone else in the meantime, or it couldn't be made at all
+			err = adminDB.Get(&credential, query, project.ProjectID, name)
+			if err == sql.ErrNoRows {
+				err = createErr
+			}
+		}
+	}
+	if err != nil {
+		return credential, fmt.Errorf("Getting registry credential %s failed: %w", name, err)
+	}
+	if credential.Password == "" {
+		err = credential.Open(key)
+		if err != nil {
+			return credential, err
+		}
+	}
+	return credential, nil
 }
 
-func GetUserDBClaimsByProject(adminDB *sqlx.DB, project types.Project) (userDBClaims []types.UserDBClaim, err error) {
+func GetRegistryCredentialsByProject(adminDB *sqlx.DB, project types.Project) (credentials []types.RegistryCredential, err error) {
 	query := `
-		SELECT * FROM user_db_claim
+		SELECT * FROM registry_credential
 		WHERE project_id = $1 AND deleted_at IS NULL
+		ORDER BY name
 	`
 
-	err = adminDB.Select(&userDBClaims, query, project.ProjectID)
+	err = adminDB.Select(&credentials, query, project.ProjectID)
 	if err != nil {
-		return userDBClaims, err
+		return credentials, fmt.Errorf("Getting the registry credentials of project %v failed: %w", project.ProjectID, err)
 	}
-
-	return userDBClaims, nil
+	return credentials, nil
 }
 
-func GetUserDBClaimByProject(adminDB *sqlx.DB, project types.Project) (userDBClaim types.UserDBClaim, err error) {
+// Comes opened, for checking the password against
+func GetRegistryCredentialByUsername(adminDB *sqlx.DB, key []byte, username string) (credential types.RegistryCredential, err error) {
 	query := `
-		SELECT user_db_claim.* FROM user_db_claim
-		WHERE user_db_claim.project_id = $1 AND deleted_at IS NULL
+		SELECT * FROM registry_credential
+		WHERE username = $1 AND deleted_at IS NULL
 	`
 
-	err = adminDB.Get(&userDBClaim, query, project.ProjectID)
+	err = adminDB.Get(&credential, query, username)
 	if err != nil {
-		return userDBClaim, err
+		return credential, err
+	}
+	err = credential.Open(key)
+	if err != nil {
+		return credential, err
 	}
+	return credential, nil
+}
 
-	return userDBClaim, nil
+func SetRegistryCredentialUsed(adminDB *sqlx.DB, credential types.RegistryCredential) error {
+	_, err := adminDB.Exec("UPDATE registry_credential SET last_used_at = now() WHERE registry_credential_id = $1", credential.RegistryCredentialID)
+	if err != nil {
+		return fmt.Errorf("Saving registry credential %s failed: %w", credential.Name, err)
+	}
+	return nil
 }
 
-func AddCredentialsToUserDBClaim(adminDB *sqlx.DB, userDBClaim types.UserDBClaim, newCreds types.UserDBClaimCredentials) error {
+func DeleteRegistryCredentialByProjectAndName(adminDB *sqlx.DB, project types.Project, name string) error {
 	query := `
-		UPDATE user_db_claim
-		SET credentials = $2
-		WHERE user_db_claim_id = $1
+		UPDATE registry_credential
+		SET deleted_at = now()
+		WHERE project_id = $1 AND name = $2 AND deleted_at IS NULL
 	`
-	newsCredsJSON, err := json.Marshal(&newCreds)
+
+	result, err := adminDB.Exec(query, project.ProjectID, name)
+	if err != nil {
+		return fmt.Errorf("Deleting registry credential %s failed: %w", name, err)
+	}
+	deleted, err := result.RowsAffected()
 	if err != nil {
 		return err
 	}
+	if deleted == 0 {
+		return fmt.Errorf("There's no registry credential called %s", name)
+	}
+	return nil
+}
 
-	_, err = adminDB.Exec(query, userDBClaim.UserDBClaimID, newsCredsJSON)
+// Saves what's in one of a zone's repositories now. Images that were there before but aren't tagged anymore stay
+// around untagged, they're only gone once they've been garbage-collected.
+func SaveRegistryRepositoryImages(adminDB *sqlx.DB, zoneName string, projectID int, repository string, images []types.RegistryImage) error {
+	tx, err := adminDB.Beginx()
 	if err != nil {
 		return err
 	}
 
-	return nil
+	seenDigests := pq.StringArray{}
+	for _, image := range images {
+		_, err = tx.Exec(`
+			INSERT INTO registry_image (zone_name, project_id, repository, digest, tags, size_bytes)
+			VALUES ($1, $2, $3, $4, $5, $6)
+			ON CONFLICT (zone_name, repository, digest) DO UPDATE
+			SET tags = EXCLUDED.tags, size_bytes = EXCLUDED.size_bytes, last_seen_at = now(), deleted_at = NULL,
+				first_seen_at = CASE WHEN registry_image.deleted_at IS NULL THEN registry_image.first_seen_at ELSE now() END
+		`, zoneName, projectID, repository, image.Digest, image.Tags, image.SizeBytes)
+		if err != nil {
+			_ = tx.Rollback()
+			return fmt.Errorf("Saving the images of %s in zone %s failed: %w", repository, zoneName, err)
+		}
+		seenDigests = append(seenDigests, image.Digest)
+	}
+
+	_, err = tx.Exec(`
+		UPDATE registry_image SET tags = '{}'
+		WHERE zone_name = $1 AND repository = $2 AND deleted_at IS NULL AND NOT (digest = ANY($3))
+	`, zoneName, repository, seenDigests)
+	if err != nil {
+		_ = tx.Rollback()
+		return fmt.Errorf("Saving the images of %s in zone %s failed: %w", repository, zoneName, err)
+	}
+
+	return tx.Commit()
 }
 
-func SetUserDBClaimAsActivating(adminDB *sqlx.DB, userDBClaim types.UserDBClaim) error {
+// Untags the images of repositories that aren't in the zone's registry anymore
+func ForgetRegistryRepositories(adminDB *sqlx.DB, zoneName string, seenRepositories []string) error {
 	query := `
-		UPDATE user_db_claim
-		SET status = 'activating'
-		WHERE user_db_claim_id = $1
+		UPDATE registry_image SET tags = '{}'
+		WHERE zone_name = $1 AND deleted_at IS NULL AND NOT (repository = ANY($2))
 	`
 
-	_, err := adminDB.Exec(query, userDBClaim.UserDBClaimID)
+	_, err := adminDB.Exec(query, zoneName, pq.StringArray(seenRepositories))
 	if err != nil {
-		return err
+		return fmt.Errorf("Saving the repositories of zone %s failed: %w", zoneName, err)
 	}
-
 	return nil
 }
 
-func SetUserDBClaimAsActive(adminDB *sqlx.DB, userDBClaim types.UserDBClaim) error {
+func GetRegistryImagesByProject(adminDB *sqlx.DB, project types.Project) (images []types.RegistryImage, err error) {
 	query := `
-		UPDATE user_db_claim
-		SET status = 'active'
-		WHERE user_db_claim_id = $1
+		SELECT * FROM registry_image
+		WHERE project_id = $1 AND deleted_at IS NULL
+		ORDER BY repository, zone_name, first_seen_at DESC
 	`
 
-	_, err := adminDB.Exec(query, userDBClaim.UserDBClaimID)
+	err = adminDB.Select(&images, query, project.ProjectID)
 	if err != nil {
-		return err
+		return images, fmt.Errorf("Getting the registry images of project %v failed: %w", project.ProjectID, err)
 	}
-
-	return nil
+	return images, nil
 }
 
-func SetUserDBClaimAsDeactivating(adminDB *sqlx.DB, userDBClaim types.UserDBClaim) error {
+// In every zone the project has anything in
+func GetRegistryUsageByProject(adminDB *sqlx.DB, project types.Project) (usages []types.RegistryUsage, err error) {
 	query := `
-		UPDATE user_db_claim
-		SET status = 'deactivating'
-		WHERE user_db_claim_id = $1
+		SELECT zone_name, SUM(size_bytes)::BIGINT AS size_bytes FROM registry_image
+		WHERE project_id = $1 AND deleted_at IS NULL
+		GROUP BY zone_name
+		ORDER BY zone_name
 	`
 
-	_, err := adminDB.Exec(query, userDBClaim.UserDBClaimID)
+	err = adminDB.Select(&usages, query, project.ProjectID)
 	if err != nil {
-		return err
+		return usages, fmt.Errorf("Getting the registry usage of project %v failed: %w", project.ProjectID, err)
 	}
-
-	return nil
+	return usages, nil
 }
 
-func DeleteUserDBClaimByProject(adminDB *sqlx.DB, project types.Project) error {
+func GetRegistryUsageInZone(adminDB *sqlx.DB, project types.Project, zoneName string) (sizeBytes int64, err error) {
 	query := `
-		UPDATE user_db_claim
-		SET deleted_at = now(), status = 'inactive'
-		WHERE project_id = $1
+		SELECT COALESCE(SUM(size_bytes), 0)::BIGINT FROM registry_image
+		WHERE project_id = $1 AND zone_name = $2 AND deleted_at IS NULL
 	`
 
-	_, err := adminDB.Exec(query, project.ProjectID)
+	err = adminDB.Get(&sizeBytes, query, project.ProjectID, zoneName)
 	if err != nil {
-		return err
+		return sizeBytes, fmt.Errorf("Getting the registry usage of project %v in zone %s failed: %w", project.ProjectID, zoneName, err)
 	}
-
-	return nil
+	return sizeBytes, nil
 }
 
-func CreateObjectStorageForProject(adminDB *sqlx.DB, project types.Project, objectStorageInput types.ObjectStorageClaim) (objectStorageOutput types.ObjectStorageClaim, err error) {
-	createObjectStorageQuery := `
-		WITH inserted_object_storage_claim AS (
-			INSERT INTO object_storage_claim (project_id, name, zones)
-			VALUES ($1, $2, $3)
-			RETURNING object_storage_claim_id
+// Images in the zone's registry that are older than the grace period, that no container of their project runs and
+// that none can be rolled back to
+func GetUnreferencedRegistryImages(adminDB *sqlx.DB, zoneName string, graceDays int) (images []types.RegistryImage, err error) {
+	query := `
+		SELECT * FROM registry_image
+		WHERE zone_name = $1 AND deleted_at IS NULL AND first_seen_at < now() - make_interval(days => $2)
+		AND NOT EXISTS (
+			SELECT 1 FROM container_claim
+			WHERE container_claim.project_id = registry_image.project_id AND container_claim.deleted_at IS NULL
+			AND container_claim.image_digest = registry_image.digest
 		)
-		SELECT object_storage_claim_id FROM inserted_object_storage_claim
+		AND NOT EXISTS (
+			SELECT 1 FROM container_revision
+			JOIN container_claim ON container_claim.container_claim_id = container_revision.container_claim_id
+			WHERE container_claim.project_id = registry_image.project_id AND container_claim.deleted_at IS NULL
+			AND container_revision.image_digest = registry_image.digest
+		)
+		ORDER BY first_seen_at
 	`
 
-	var objectStorageClaimID int
-	err = adminDB.QueryRow(createObjectStorageQuery, project.ProjectID, objectStorageInput.Name, objectStorageInput.Zones).Scan(&objectStorageClaimID)
+	err = adminDB.Select(&images, query, zoneName, graceDays)
 	if err != nil {
-		return objectStorageOutput, err
+		return images, fmt.Errorf("Getting the unreferenced registry images in zone %s failed: %w", zoneName, err)
 	}
+	return images, nil
+}
 
-	objectStorageOutput = objectStorageInput
-	objectStorageOutput.ObjectStorageClaimID = objectStorageClaimID
+func SetRegistryImageDeleted(adminDB *sqlx.DB, image types.RegistryImage) error {
+	_, err := adminDB.Exec("UPDATE registry_image SET deleted_at = now() WHERE registry_image_id = $1", image.RegistryImageID)
+	if err != nil {
+		return fmt.Errorf("Saving registry image %s@%s failed: %w", image.Repository, image.Digest, err)
+	}
+	return nil
+}
 
-	return objectStorageOutput, nil
+// credentialInput has to have been sealed for project already
+func CreatePullCredentialForProject(adminDB *sqlx.DB, project types.Project, credentialInput types.PullCredential) (credential types.PullCredential, err error) {
+	if credentialInput.ProjectID != project.ProjectID {
+		return credential, fmt.Errorf("Pull credential %s was sealed for another project", credentialInput.Name)
+	}
+	query := `
+		INSERT INTO pull_credential (project_id, name, url, email, username, sealed_secret)
+		VALUES ($1, $2, $3, $4, $5, $6)
+		RETURNING *
+	`
+	err = adminDB.Get(&credential, query, project.ProjectID, credentialInput.Name, credentialInput.URL, credentialInput.Email, credentialInput.Username, credentialInput.SealedSecret)
+	if err != nil {
+		return credential, fmt.Errorf("Creating pull credential %s failed, is there one called that already? %w", credentialInput.Name, err)
+	}
+	return credential, nil
 }
 
-func GetObjectStoragesByProject(adminDB *sqlx.DB, project types.Project) (objectStorages []types.ObjectStorageClaim, err error) {
+func GetPullCredentialsByProject(adminDB *sqlx.DB, project types.Project) (credentials []types.PullCredential, err error) {
 	query := `
-		SELECT * FROM object_storage_claim
+		SELECT * FROM pull_credential
 		WHERE project_id = $1 AND deleted_at IS NULL
+		ORDER BY name
 	`
 
-	err = adminDB.Select(&objectStorages, query, project.ProjectID)
+	err = adminDB.Select(&credentials, query, project.ProjectID)
 	if err != nil {
-		return objectStorages, err
+		return credentials, fmt.Errorf("Getting the pull credentials of project %v failed: %w", project.ProjectID, err)
 	}
-
-	return objectStorages, nil
+	return credentials, nil
 }
 
-func GetObjectStorageByProjectAndName(adminDB *sqlx.DB, project types.Project, objectStorageName string) (objectStorage types.ObjectStorageClaim, err error) {
+// Decrypted with key, ready to be used
+func OpenPullCredentialByProjectAndName(adminDB *sqlx.DB, key []byte, project types.Project, name string) (credential types.PullCredential, err error) {
 	query := `
-		SELECT object_storage_claim.* FROM object_storage_claim
-		WHERE object_storage_claim.project_id = $1 AND object_storage_claim.name = $2 AND deleted_at IS NULL
+		SELECT * FROM pull_credential
+		WHERE project_id = $1 AND name = $2 AND deleted_at IS NULL
 	`
 
-	err = adminDB.Get(&objectStorage, query, project.ProjectID, objectStorageName)
+	err = adminDB.Get(&credential, query, project.ProjectID, name)
+	if err == sql.ErrNoRows {
+		return credential, fmt.Errorf("There's no pull credential called %q in this project", name)
+	}
 	if err != nil {
-		return objectStorage, err
+		return credential, err
 	}
-
-	return objectStorage, nil
+	return credential, credential.Open(key)
 }
 
-func SetObjectStorageAsDeactivating(adminDB *sqlx.DB, objectStorage types.ObjectStorageClaim) error {
+// Saves a new login for the credential, which has to be sealed already
+func RotatePullCredential(adminDB *sqlx.DB, credential types.PullCredential) (types.PullCredential, error) {
 	query := `
-		UPDATE object_storage_claim
-		SET status = 'deactivating'
-		WHERE object_storage_claim_id = $1
+		UPDATE pull_credential
+		SET email = $2, username = $3, sealed_secret = $4, rotated_at = now()
+		WHERE pull_credential_id = $1 AND deleted_at IS NULL
+		RETURNING rotated_at
 	`
 
-	_, err := adminDB.Exec(query, objectStorage.ObjectStorageClaimID)
+	err := adminDB.Get(&credential.RotatedAt, query, credential.PullCredentialID, credential.Email, credential.Username, credential.SealedSecret)
 	if err != nil {
-		return err
+		return credential, fmt.Errorf("Saving pull credential %s failed: %w", credential.Name, err)
 	}
+	return credential, nil
+}
 
-	return nil
+// The containers (not deleted) in a project whose image pull secret comes from the given credential
+func GetContainersUsingPullCredential(adminDB *sqlx.DB, project types.Project, name string) (containers []types.ContainerClaim, err error) {
+	query := `
+		SELECT * FROM container_claim
+		WHERE project_id = $1 AND pull_credential_name = $2 AND deleted_at IS NULL
+	`
+
+	err = adminDB.Select(&containers, query, project.ProjectID, name)
+	if err != nil {
+		return containers, err
+	}
+	return containers, nil
 }
 
-func DeleteObjectStorageByProjectAndName(adminDB *sqlx.DB, project types.Project, objectStorageName string) error {
+func DeletePullCredentialByProjectAndName(adminDB *sqlx.DB, project types.Project, name string) error {
 	query := `
-		UPDATE object_storage_claim
-		SET deleted_at = now(), status = 'inactive'
-		WHERE project_id = $1 AND name = $2
+		UPDATE pull_credential
+		SET deleted_at = now()
+		WHERE project_id = $1 AND name = $2 AND deleted_at IS NULL
 	`
 
-	_, err := adminDB.Exec(query, project.ProjectID, objectStorageName)
+	result, err := adminDB.Exec(query, project.ProjectID, name)
 	if err != nil {
 		return err
 	}
-
+	deleted, err := result.RowsAffected()
+	if err != nil {
+		return err
+	}
+	if deleted == 0 {
+		return fmt.Errorf("There's no pull credential called %q in this project", name)
+	}
 	return nil
 }
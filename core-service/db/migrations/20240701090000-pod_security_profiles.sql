@@ -0,0 +1,11 @@
+-- +migrate Up
+ALTER TABLE project ADD COLUMN IF NOT EXISTS trusted BOOLEAN NOT NULL DEFAULT false; -- only ever set by an operator, lets this project's containers opt out of the hardened pod profile
+
+ALTER TABLE container_claim ADD COLUMN IF NOT EXISTS security_profile TEXT NOT NULL DEFAULT 'restricted'; -- restricted | restricted-readonly | unconfined
+
+ALTER TABLE container_zone ADD COLUMN IF NOT EXISTS runtime_class_name TEXT NOT NULL DEFAULT ''; -- optional sandboxed RuntimeClass (gVisor, Kata) for tenant pods in this zone
+
+-- +migrate Down
+ALTER TABLE container_zone DROP COLUMN IF EXISTS runtime_class_name;
+ALTER TABLE container_claim DROP COLUMN IF EXISTS security_profile;
+ALTER TABLE project DROP COLUMN IF EXISTS trusted;
@@ -0,0 +1,5 @@
+-- +migrate Up
+ALTER TABLE container_claim ADD COLUMN IF NOT EXISTS image_digest TEXT; -- the digest image_tag resolved to when this was deployed, ie. "sha256:abcd..."
+
+-- +migrate Down
+ALTER TABLE container_claim DROP COLUMN IF EXISTS image_digest;
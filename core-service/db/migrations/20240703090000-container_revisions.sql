@@ -0,0 +1,28 @@
+-- +migrate Up
+CREATE TABLE IF NOT EXISTS container_revision (
+    container_revision_id SERIAL PRIMARY KEY,
+    created_at TIMESTAMPTZ NOT NULL DEFAULT now(),
+    revision INTEGER NOT NULL, -- counts up from 1 per container name within a project
+    reason TEXT NOT NULL DEFAULT 'create', -- create | rerun | update | rollback to revision N
+
+    container_name TEXT NOT NULL,
+    image_ref TEXT NOT NULL,
+    image_tag TEXT NOT NULL,
+    image_digest TEXT,
+    command TEXT ARRAY,
+    env_var_names TEXT ARRAY, -- only the names, the values live in the retained k8s secrets
+    cpu_millicores INTEGER NOT NULL,
+    memory_mb INTEGER NOT NULL,
+    target_ports INTEGER ARRAY,
+    zones TEXT ARRAY NOT NULL,
+    run_type TEXT NOT NULL,
+    security_profile TEXT NOT NULL,
+
+    container_claim_id INTEGER REFERENCES container_claim(container_claim_id) NOT NULL,
+    created_by_account_id INTEGER REFERENCES account(account_id) NOT NULL,
+    project_id INTEGER REFERENCES project(project_id) NOT NULL,
+    UNIQUE (project_id, container_name, revision)
+);
+
+-- +migrate Down
+DROP TABLE IF EXISTS container_revision;
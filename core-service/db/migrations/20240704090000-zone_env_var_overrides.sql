@@ -0,0 +1,7 @@
+-- +migrate Up
+ALTER TABLE container_claim ADD COLUMN IF NOT EXISTS zone_env_var_names JSONB; -- ie. {"fi-hel1": ["DATABASE_URL"]}, env vars whose value differs in (or only exists in) that zone
+ALTER TABLE container_revision ADD COLUMN IF NOT EXISTS zone_env_var_names JSONB;
+
+-- +migrate Down
+ALTER TABLE container_revision DROP COLUMN IF EXISTS zone_env_var_names;
+ALTER TABLE container_claim DROP COLUMN IF EXISTS zone_env_var_names;
@@ -0,0 +1,38 @@
+-- +migrate Up
+CREATE TABLE IF NOT EXISTS project_shared_config (
+    project_shared_config_id SERIAL PRIMARY KEY,
+    created_at TIMESTAMPTZ NOT NULL DEFAULT now(),
+    updated_at TIMESTAMPTZ NOT NULL DEFAULT now(),
+    deleted_at TIMESTAMPTZ,
+    name TEXT NOT NULL,
+    kind TEXT NOT NULL, -- secret | configmap
+    version INTEGER NOT NULL DEFAULT 1, -- the version new and restarted containers get
+    keys TEXT ARRAY NOT NULL,
+    config_values JSONB, -- only for configmaps, secret values only ever live in k8s
+
+    project_id INTEGER REFERENCES project(project_id) NOT NULL
+);
+
+CREATE UNIQUE INDEX unique_active_shared_configs ON project_shared_config (name, project_id) WHERE (deleted_at IS NULL);
+
+CREATE TABLE IF NOT EXISTS project_shared_config_version (
+    project_shared_config_version_id SERIAL PRIMARY KEY,
+    created_at TIMESTAMPTZ NOT NULL DEFAULT now(),
+    version INTEGER NOT NULL,
+    keys TEXT ARRAY NOT NULL,
+    config_values JSONB,
+
+    created_by_account_id INTEGER REFERENCES account(account_id) NOT NULL,
+    project_shared_config_id INTEGER REFERENCES project_shared_config(project_shared_config_id) NOT NULL,
+    UNIQUE (project_shared_config_id, version)
+);
+
+ALTER TABLE container_claim ADD COLUMN IF NOT EXISTS shared_config_names TEXT ARRAY; -- shared secrets/configmaps this container gets all keys of as env vars
+ALTER TABLE container_revision ADD COLUMN IF NOT EXISTS shared_config_names TEXT ARRAY;
+
+-- +migrate Down
+ALTER TABLE container_revision DROP COLUMN IF EXISTS shared_config_names;
+ALTER TABLE container_claim DROP COLUMN IF EXISTS shared_config_names;
+DROP TABLE IF EXISTS project_shared_config_version;
+DROP INDEX IF EXISTS unique_active_shared_configs;
+DROP TABLE IF EXISTS project_shared_config;
@@ -0,0 +1,14 @@
+-- +migrate Up
+ALTER TABLE container_claim ADD COLUMN IF NOT EXISTS bind_database BOOLEAN NOT NULL DEFAULT false; -- inject DATABASE_URL/PG* for the project DB's zone-local instance
+ALTER TABLE container_claim ADD COLUMN IF NOT EXISTS bound_object_storage TEXT; -- name of the object_storage_claim to inject S3_* for
+ALTER TABLE container_revision ADD COLUMN IF NOT EXISTS bind_database BOOLEAN NOT NULL DEFAULT false;
+ALTER TABLE container_revision ADD COLUMN IF NOT EXISTS bound_object_storage TEXT;
+
+ALTER TABLE object_storage_claim ADD COLUMN IF NOT EXISTS credentials JSONB; -- per zone S3 access keys, scoped to just this bucket
+
+-- +migrate Down
+ALTER TABLE object_storage_claim DROP COLUMN IF EXISTS credentials;
+ALTER TABLE container_revision DROP COLUMN IF EXISTS bound_object_storage;
+ALTER TABLE container_revision DROP COLUMN IF EXISTS bind_database;
+ALTER TABLE container_claim DROP COLUMN IF EXISTS bound_object_storage;
+ALTER TABLE container_claim DROP COLUMN IF EXISTS bind_database;
@@ -0,0 +1,15 @@
+-- +migrate Up
+CREATE TABLE IF NOT EXISTS container_zone_status (
+    container_claim_id INTEGER REFERENCES container_claim(container_claim_id) NOT NULL,
+    zone_name TEXT NOT NULL,
+    updated_at TIMESTAMPTZ NOT NULL DEFAULT now(),
+
+    node_ip TEXT, -- the node the container's pod is on right now in this zone
+    pod_phase TEXT NOT NULL DEFAULT 'Pending', -- Pending | Running | Succeeded | Failed | Unknown, as k8s reports it
+    ready BOOLEAN NOT NULL DEFAULT false,
+
+    PRIMARY KEY (container_claim_id, zone_name)
+);
+
+-- +migrate Down
+DROP TABLE IF EXISTS container_zone_status;
@@ -0,0 +1,9 @@
+-- +migrate Up
+ALTER TABLE container_zone_status ADD COLUMN IF NOT EXISTS ready_replicas INTEGER NOT NULL DEFAULT 0;
+ALTER TABLE container_zone_status ADD COLUMN IF NOT EXISTS restart_count INTEGER NOT NULL DEFAULT 0;
+ALTER TABLE container_zone_status ADD COLUMN IF NOT EXISTS last_termination_reason TEXT; -- ie. OOMKilled, Error, Completed
+
+-- +migrate Down
+ALTER TABLE container_zone_status DROP COLUMN IF EXISTS last_termination_reason;
+ALTER TABLE container_zone_status DROP COLUMN IF EXISTS restart_count;
+ALTER TABLE container_zone_status DROP COLUMN IF EXISTS ready_replicas;
@@ -0,0 +1,15 @@
+-- +migrate Up
+ALTER TABLE container_zone ADD COLUMN IF NOT EXISTS health TEXT NOT NULL DEFAULT 'unknown'; -- unknown | healthy | degraded | unreachable
+ALTER TABLE container_zone ADD COLUMN IF NOT EXISTS health_error TEXT;
+ALTER TABLE container_zone ADD COLUMN IF NOT EXISTS health_checked_at TIMESTAMPTZ;
+
+-- a cordoned zone keeps running what it has, but doesn't take new containers
+ALTER TABLE container_zone ADD COLUMN IF NOT EXISTS cordoned BOOLEAN NOT NULL DEFAULT false;
+ALTER TABLE container_zone ADD COLUMN IF NOT EXISTS cordon_reason TEXT;
+
+-- +migrate Down
+ALTER TABLE container_zone DROP COLUMN IF EXISTS cordon_reason;
+ALTER TABLE container_zone DROP COLUMN IF EXISTS cordoned;
+ALTER TABLE container_zone DROP COLUMN IF EXISTS health_checked_at;
+ALTER TABLE container_zone DROP COLUMN IF EXISTS health_error;
+ALTER TABLE container_zone DROP COLUMN IF EXISTS health;
@@ -0,0 +1,22 @@
+-- +migrate Up
+ALTER TABLE account ADD COLUMN IF NOT EXISTS is_admin BOOLEAN NOT NULL DEFAULT false; -- operators, who get to manage zones
+
+ALTER TABLE container_zone ADD COLUMN IF NOT EXISTS kubeconfig_path TEXT NOT NULL DEFAULT ''; -- empty means ~/.kube/<name>.conf
+ALTER TABLE container_zone ADD COLUMN IF NOT EXISTS kubeconfig TEXT; -- an uploaded kubeconfig, used instead of the path when set
+ALTER TABLE container_zone ADD COLUMN IF NOT EXISTS retired_at TIMESTAMPTZ;
+
+CREATE TABLE IF NOT EXISTS user_db_zone (
+    id TEXT NOT NULL, -- only unique within its zone
+    created_at TIMESTAMPTZ NOT NULL DEFAULT now(),
+    retired_at TIMESTAMPTZ,
+    zone TEXT NOT NULL,
+    connection_url TEXT NOT NULL,
+    PRIMARY KEY (zone, id)
+);
+
+-- +migrate Down
+DROP TABLE IF EXISTS user_db_zone;
+ALTER TABLE container_zone DROP COLUMN IF EXISTS retired_at;
+ALTER TABLE container_zone DROP COLUMN IF EXISTS kubeconfig;
+ALTER TABLE container_zone DROP COLUMN IF EXISTS kubeconfig_path;
+ALTER TABLE account DROP COLUMN IF EXISTS is_admin;
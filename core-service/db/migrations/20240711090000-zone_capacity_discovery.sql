@@ -0,0 +1,30 @@
+-- +migrate Up
+-- when on, cpu_millicores and memory_mb follow what the zone's nodes can actually take instead of staying as configured
+ALTER TABLE container_zone ADD COLUMN IF NOT EXISTS discover_capacity BOOLEAN NOT NULL DEFAULT true;
+ALTER TABLE container_zone ADD COLUMN IF NOT EXISTS node_count INTEGER NOT NULL DEFAULT 0;
+ALTER TABLE container_zone ADD COLUMN IF NOT EXISTS capacity_collected_at TIMESTAMPTZ;
+
+CREATE TABLE IF NOT EXISTS container_zone_capacity (
+    container_zone_capacity_id SERIAL PRIMARY KEY,
+    collected_at TIMESTAMPTZ NOT NULL DEFAULT now(),
+    zone_name TEXT REFERENCES container_zone(name) NOT NULL,
+
+    node_count INTEGER NOT NULL,
+    schedulable_node_count INTEGER NOT NULL, -- ready and not cordoned in k8s, only these count towards the capacity
+
+    allocatable_cpu_millicores INTEGER NOT NULL,
+    allocatable_memory_mb INTEGER NOT NULL,
+    reserved_cpu_millicores INTEGER NOT NULL,
+    reserved_memory_mb INTEGER NOT NULL,
+
+    -- allocatable minus reserved, what the fair share gets split from
+    cpu_millicores INTEGER NOT NULL,
+    memory_mb INTEGER NOT NULL
+);
+CREATE INDEX IF NOT EXISTS container_zone_capacity_zone_collected_at ON container_zone_capacity (zone_name, collected_at);
+
+-- +migrate Down
+DROP TABLE IF EXISTS container_zone_capacity;
+ALTER TABLE container_zone DROP COLUMN IF EXISTS capacity_collected_at;
+ALTER TABLE container_zone DROP COLUMN IF EXISTS node_count;
+ALTER TABLE container_zone DROP COLUMN IF EXISTS discover_capacity;
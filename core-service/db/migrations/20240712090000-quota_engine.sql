@@ -0,0 +1,16 @@
+-- +migrate Up
+-- how much of a zone's shared capacity an account gets compared to the others, ie. 2 for someone who donated hardware
+ALTER TABLE account ADD COLUMN IF NOT EXISTS quota_weight DOUBLE PRECISION NOT NULL DEFAULT 1 CHECK (quota_weight > 0);
+
+-- how far past its guaranteed share an account may go into capacity nobody's using, as a multiple of that share
+ALTER TABLE container_zone ADD COLUMN IF NOT EXISTS quota_burst_factor DOUBLE PRECISION NOT NULL DEFAULT 1 CHECK (quota_burst_factor >= 0);
+
+-- set aside for this account alone, taken off the zone's capacity before the rest gets shared out
+ALTER TABLE container_resource_usage_per_account_per_zone ADD COLUMN IF NOT EXISTS reserved_cpu_millicores INTEGER NOT NULL DEFAULT 0 CHECK (reserved_cpu_millicores >= 0);
+ALTER TABLE container_resource_usage_per_account_per_zone ADD COLUMN IF NOT EXISTS reserved_memory_mb INTEGER NOT NULL DEFAULT 0 CHECK (reserved_memory_mb >= 0);
+
+-- +migrate Down
+ALTER TABLE container_resource_usage_per_account_per_zone DROP COLUMN IF EXISTS reserved_memory_mb;
+ALTER TABLE container_resource_usage_per_account_per_zone DROP COLUMN IF EXISTS reserved_cpu_millicores;
+ALTER TABLE container_zone DROP COLUMN IF EXISTS quota_burst_factor;
+ALTER TABLE account DROP COLUMN IF EXISTS quota_weight;
@@ -0,0 +1,22 @@
+-- +migrate Up
+-- set once the claim's usage has been given back, so that it can't be given back twice
+ALTER TABLE container_claim ADD COLUMN IF NOT EXISTS usage_released_at TIMESTAMPTZ;
+UPDATE container_claim SET usage_released_at = COALESCE(deleted_at, now()) WHERE deleted_at IS NOT NULL OR status IN ('deactivating', 'inactive');
+
+-- what the nightly reconciliation found out of step with the live claims, and corrected
+CREATE TABLE IF NOT EXISTS container_resource_usage_drift (
+    container_resource_usage_drift_id SERIAL PRIMARY KEY,
+    found_at TIMESTAMPTZ NOT NULL DEFAULT now(),
+    zone_name TEXT REFERENCES container_zone(name) NOT NULL,
+    account_id INTEGER REFERENCES account(account_id) NOT NULL,
+
+    recorded_cpu_millicores INTEGER NOT NULL,
+    actual_cpu_millicores INTEGER NOT NULL,
+    recorded_memory_mb INTEGER NOT NULL,
+    actual_memory_mb INTEGER NOT NULL
+);
+CREATE INDEX IF NOT EXISTS container_resource_usage_drift_found_at ON container_resource_usage_drift (found_at);
+
+-- +migrate Down
+DROP TABLE IF EXISTS container_resource_usage_drift;
+ALTER TABLE container_claim DROP COLUMN IF EXISTS usage_released_at;
@@ -0,0 +1,37 @@
+-- +migrate Up
+-- k8s nodes that an account donated into a zone, found through their lcaas/donor-account-id label
+CREATE TABLE IF NOT EXISTS donated_node (
+    donated_node_id SERIAL PRIMARY KEY,
+    detected_at TIMESTAMPTZ NOT NULL DEFAULT now(),
+    zone_name TEXT REFERENCES container_zone(name) NOT NULL,
+    node_name TEXT NOT NULL,
+    account_id INTEGER REFERENCES account(account_id) NOT NULL,
+
+    status TEXT NOT NULL DEFAULT 'pending', -- pending | approved | retired, only approved ones get the donor credit
+    approved_at TIMESTAMPTZ,
+    retired_at TIMESTAMPTZ,
+
+    -- as of the last time it was seen
+    last_seen_at TIMESTAMPTZ,
+    ready BOOLEAN NOT NULL DEFAULT false,
+    cpu_millicores INTEGER NOT NULL DEFAULT 0,
+    memory_mb INTEGER NOT NULL DEFAULT 0,
+
+    -- uptime is up_seconds / observed_seconds, counted from every capacity collection since it was detected
+    up_seconds BIGINT NOT NULL DEFAULT 0,
+    observed_seconds BIGINT NOT NULL DEFAULT 0
+);
+CREATE UNIQUE INDEX IF NOT EXISTS unique_donated_nodes ON donated_node (zone_name, node_name) WHERE (status != 'retired');
+
+-- how much of a donated node's capacity its donor gets set aside for themselves
+ALTER TABLE container_zone ADD COLUMN IF NOT EXISTS donor_credit_share DOUBLE PRECISION NOT NULL DEFAULT 0.5 CHECK (donor_credit_share >= 0 AND donor_credit_share <= 1);
+
+-- the donor credit, kept apart from the reservations operators set by hand
+ALTER TABLE container_resource_usage_per_account_per_zone ADD COLUMN IF NOT EXISTS donated_cpu_millicores INTEGER NOT NULL DEFAULT 0 CHECK (donated_cpu_millicores >= 0);
+ALTER TABLE container_resource_usage_per_account_per_zone ADD COLUMN IF NOT EXISTS donated_memory_mb INTEGER NOT NULL DEFAULT 0 CHECK (donated_memory_mb >= 0);
+
+-- +migrate Down
+ALTER TABLE container_resource_usage_per_account_per_zone DROP COLUMN IF EXISTS donated_memory_mb;
+ALTER TABLE container_resource_usage_per_account_per_zone DROP COLUMN IF EXISTS donated_cpu_millicores;
+ALTER TABLE container_zone DROP COLUMN IF EXISTS donor_credit_share;
+DROP TABLE IF EXISTS donated_node;
@@ -0,0 +1,55 @@
+-- +migrate Up
+-- a project's credits, as a ledger: every change is a new row carrying the balance after it, rows never get changed
+CREATE TABLE IF NOT EXISTS billing (
+    billing_id SERIAL PRIMARY KEY,
+    created_at TIMESTAMPTZ NOT NULL DEFAULT now(),
+    project_id INTEGER REFERENCES project(project_id) NOT NULL,
+
+    kind TEXT NOT NULL DEFAULT 'opening', -- opening | usage | flat_fee | donation | top_up | adjustment
+    credits_delta NUMERIC(16, 4) NOT NULL,
+    current_credits NUMERIC(16, 4) NOT NULL, -- the balance once credits_delta is applied
+    details JSONB NOT NULL DEFAULT '{}',
+
+    -- the stretch of time a usage or monthly entry covers
+    period_start TIMESTAMPTZ,
+    period_end TIMESTAMPTZ,
+    cpu_millicore_hours NUMERIC(16, 4) NOT NULL DEFAULT 0,
+    memory_mb_hours NUMERIC(16, 4) NOT NULL DEFAULT 0,
+    storage_gb_hours NUMERIC(16, 4) NOT NULL DEFAULT 0,
+
+    -- so that the same period can't be billed twice, eg. "monthly:12:2024-07"
+    idempotency_key TEXT UNIQUE
+);
+CREATE INDEX IF NOT EXISTS billing_by_project ON billing (project_id, billing_id);
+
+-- +migrate StatementBegin
+CREATE OR REPLACE FUNCTION billing_is_append_only() RETURNS trigger AS $$
+BEGIN
+    RAISE EXCEPTION 'billing is append-only, correct it with an adjustment entry instead';
+END;
+$$ LANGUAGE plpgsql;
+-- +migrate StatementEnd
+DROP TRIGGER IF EXISTS billing_append_only ON billing;
+CREATE TRIGGER billing_append_only BEFORE UPDATE OR DELETE ON billing FOR EACH ROW EXECUTE FUNCTION billing_is_append_only();
+
+-- projects made before the ledger start off at 0 credits
+INSERT INTO billing (project_id, kind, credits_delta, current_credits, details)
+SELECT project_id, 'opening', 0, 0, jsonb_build_object('project', jsonb_build_object('id', project_id::TEXT, 'name', name))
+FROM project
+WHERE NOT EXISTS (SELECT 1 FROM billing WHERE billing.project_id = project.project_id);
+
+-- usage is metered up to here, anything after gets billed by the next metering run
+ALTER TABLE project ADD COLUMN IF NOT EXISTS metered_until TIMESTAMPTZ;
+-- existing projects only get metered from now on, their ledgers open at 0 and what came before wasn't billed this way
+UPDATE project SET metered_until = now() WHERE metered_until IS NULL;
+-- when the balance went below the suspension threshold, cleared once it's back above
+ALTER TABLE project ADD COLUMN IF NOT EXISTS credits_exhausted_at TIMESTAMPTZ;
+-- suspended projects can't start anything new until they're topped up
+ALTER TABLE project ADD COLUMN IF NOT EXISTS suspended_at TIMESTAMPTZ;
+
+-- +migrate Down
+ALTER TABLE project DROP COLUMN IF EXISTS suspended_at;
+ALTER TABLE project DROP COLUMN IF EXISTS credits_exhausted_at;
+ALTER TABLE project DROP COLUMN IF EXISTS metered_until;
+DROP TABLE IF EXISTS billing;
+DROP FUNCTION IF EXISTS billing_is_append_only();
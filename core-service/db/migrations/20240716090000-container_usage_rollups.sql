@@ -0,0 +1,20 @@
+-- +migrate Up
+-- what containers actually used, from the zones' metrics API, rolled up by the hour as the samples come in
+CREATE TABLE IF NOT EXISTS container_usage_rollup (
+    container_claim_id INTEGER REFERENCES container_claim(container_claim_id) NOT NULL,
+    zone_name TEXT REFERENCES container_zone(name) NOT NULL,
+    hour TIMESTAMPTZ NOT NULL,
+
+    sample_count INTEGER NOT NULL DEFAULT 0,
+    -- the averages are the sums over sample_count
+    cpu_millicores_sum BIGINT NOT NULL DEFAULT 0,
+    cpu_millicores_max INTEGER NOT NULL DEFAULT 0,
+    memory_mb_sum BIGINT NOT NULL DEFAULT 0,
+    memory_mb_max INTEGER NOT NULL DEFAULT 0,
+
+    PRIMARY KEY (container_claim_id, zone_name, hour)
+);
+CREATE INDEX IF NOT EXISTS container_usage_rollup_by_hour ON container_usage_rollup (hour);
+
+-- +migrate Down
+DROP TABLE IF EXISTS container_usage_rollup;
@@ -0,0 +1,67 @@
+-- +migrate Up
+-- where a project's alerts get sent, on top of showing up in the app
+CREATE TABLE IF NOT EXISTS notification_channel (
+    notification_channel_id SERIAL PRIMARY KEY,
+    created_at TIMESTAMPTZ NOT NULL DEFAULT now(),
+    deleted_at TIMESTAMPTZ,
+    project_id INTEGER REFERENCES project(project_id) NOT NULL,
+
+    name TEXT NOT NULL,
+    kind TEXT NOT NULL, -- webhook | slack | discord
+    url TEXT NOT NULL
+);
+CREATE UNIQUE INDEX IF NOT EXISTS notification_channel_name_per_project ON notification_channel (project_id, name) WHERE deleted_at IS NULL;
+
+CREATE TABLE IF NOT EXISTS alert_rule (
+    alert_rule_id SERIAL PRIMARY KEY,
+    created_at TIMESTAMPTZ NOT NULL DEFAULT now(),
+    deleted_at TIMESTAMPTZ,
+    project_id INTEGER REFERENCES project(project_id) NOT NULL,
+    created_by_account_id INTEGER REFERENCES account(account_id) NOT NULL,
+
+    name TEXT NOT NULL,
+    kind TEXT NOT NULL, -- container_crash_looping | container_oom_killed | job_failed | db_storage | quota | certificate_expiring
+    target TEXT NOT NULL DEFAULT '', -- a container name, a member's username for quotas or host:port for certificates, empty for all of the project's
+    threshold NUMERIC(16, 4) NOT NULL DEFAULT 0, -- restarts, a share from 0 to 1 or days, depending on the kind
+    notification_channel_ids INTEGER[] NOT NULL DEFAULT '{}',
+
+    -- an alert that keeps firing gets sent again this often, never if 0
+    repeat_after_minutes INTEGER NOT NULL DEFAULT 0,
+    -- until then its notifications are only kept, not sent
+    silenced_until TIMESTAMPTZ
+);
+CREATE UNIQUE INDEX IF NOT EXISTS alert_rule_name_per_project ON alert_rule (project_id, name) WHERE deleted_at IS NULL;
+
+-- what each rule is firing for right now, so that the same thing doesn't get sent every time the rules are evaluated
+CREATE TABLE IF NOT EXISTS alert_firing (
+    alert_rule_id INTEGER REFERENCES alert_rule(alert_rule_id) NOT NULL,
+    fingerprint TEXT NOT NULL, -- what it's firing for, ie. "container:12:fi-hel1"
+    firing_since TIMESTAMPTZ NOT NULL DEFAULT now(),
+    last_notified_at TIMESTAMPTZ NOT NULL DEFAULT now(),
+    title TEXT NOT NULL,
+
+    PRIMARY KEY (alert_rule_id, fingerprint)
+);
+
+-- every notification that went out or would have, doubling as the in-app ones
+CREATE TABLE IF NOT EXISTS notification (
+    notification_id SERIAL PRIMARY KEY,
+    created_at TIMESTAMPTZ NOT NULL DEFAULT now(),
+    project_id INTEGER REFERENCES project(project_id) NOT NULL,
+    alert_rule_id INTEGER REFERENCES alert_rule(alert_rule_id) NOT NULL,
+
+    fingerprint TEXT NOT NULL,
+    title TEXT NOT NULL,
+    message TEXT NOT NULL,
+    resolved BOOLEAN NOT NULL DEFAULT false,
+    silenced BOOLEAN NOT NULL DEFAULT false, -- kept but not sent, since the rule was silenced
+    deliveries JSONB NOT NULL DEFAULT '[]', -- how sending it to each of the rule's channels went
+    read_at TIMESTAMPTZ
+);
+CREATE INDEX IF NOT EXISTS notification_by_project ON notification (project_id, notification_id);
+
+-- +migrate Down
+DROP TABLE IF EXISTS notification;
+DROP TABLE IF EXISTS alert_firing;
+DROP TABLE IF EXISTS alert_rule;
+DROP TABLE IF EXISTS notification_channel;
@@ -0,0 +1,47 @@
+-- +migrate Up
+-- where a project wants to hear about its resources being created, going active, failing and being deleted
+CREATE TABLE IF NOT EXISTS webhook_subscription (
+    webhook_subscription_id SERIAL PRIMARY KEY,
+    created_at TIMESTAMPTZ NOT NULL DEFAULT now(),
+    deleted_at TIMESTAMPTZ,
+    project_id INTEGER REFERENCES project(project_id) NOT NULL,
+
+    name TEXT NOT NULL,
+    url TEXT NOT NULL,
+    secret TEXT NOT NULL, -- what the payloads get signed with
+    events TEXT[] NOT NULL DEFAULT '{}' -- ie. container.active, every event if empty
+);
+CREATE UNIQUE INDEX IF NOT EXISTS webhook_subscription_name_per_project ON webhook_subscription (project_id, name) WHERE deleted_at IS NULL;
+
+-- something that happened, kept as it was so that redeliveries send the same thing
+CREATE TABLE IF NOT EXISTS webhook_event (
+    webhook_event_id SERIAL PRIMARY KEY,
+    created_at TIMESTAMPTZ NOT NULL DEFAULT now(),
+    project_id INTEGER REFERENCES project(project_id) NOT NULL,
+
+    event TEXT NOT NULL,
+    data JSONB NOT NULL
+);
+
+-- one event going to one subscription, a redelivery being a new one
+CREATE TABLE IF NOT EXISTS webhook_delivery (
+    webhook_delivery_id SERIAL PRIMARY KEY,
+    created_at TIMESTAMPTZ NOT NULL DEFAULT now(),
+    webhook_subscription_id INTEGER REFERENCES webhook_subscription(webhook_subscription_id) NOT NULL,
+    webhook_event_id INTEGER REFERENCES webhook_event(webhook_event_id) NOT NULL,
+    redelivery_of INTEGER REFERENCES webhook_delivery(webhook_delivery_id),
+
+    status TEXT NOT NULL DEFAULT 'pending', -- pending | delivered | failed
+    attempts INTEGER NOT NULL DEFAULT 0,
+    next_attempt_at TIMESTAMPTZ NOT NULL DEFAULT now(),
+    last_attempt_at TIMESTAMPTZ,
+    response_status INTEGER,
+    error TEXT
+);
+CREATE INDEX IF NOT EXISTS webhook_delivery_pending ON webhook_delivery (next_attempt_at) WHERE status = 'pending';
+CREATE INDEX IF NOT EXISTS webhook_delivery_by_subscription ON webhook_delivery (webhook_subscription_id, webhook_delivery_id);
+
+-- +migrate Down
+DROP TABLE IF EXISTS webhook_delivery;
+DROP TABLE IF EXISTS webhook_event;
+DROP TABLE IF EXISTS webhook_subscription;
@@ -0,0 +1,22 @@
+-- +migrate Up
+-- a secret URL per container that registries and CI call to have a new image deployed
+CREATE TABLE IF NOT EXISTS deploy_hook (
+    deploy_hook_id SERIAL PRIMARY KEY,
+    created_at TIMESTAMPTZ NOT NULL DEFAULT now(),
+    deleted_at TIMESTAMPTZ,
+    project_id INTEGER REFERENCES project(project_id) NOT NULL,
+    created_by_account_id INTEGER REFERENCES account(account_id) NOT NULL, -- the deploys it makes are on them
+
+    container_name TEXT NOT NULL, -- by name, since every deploy replaces the container's claim
+    token TEXT NOT NULL UNIQUE,
+    -- also poll the registry for a new digest of the container's tag, and deploy it
+    follow_tag BOOLEAN NOT NULL DEFAULT false,
+
+    last_polled_at TIMESTAMPTZ,
+    last_triggered_at TIMESTAMPTZ,
+    last_result TEXT -- what came of the last call or poll that found something to deploy
+);
+CREATE UNIQUE INDEX IF NOT EXISTS deploy_hook_per_container ON deploy_hook (project_id, container_name) WHERE deleted_at IS NULL;
+
+-- +migrate Down
+DROP TABLE IF EXISTS deploy_hook;
@@ -0,0 +1,44 @@
+-- +migrate Up
+-- where images built in the zone get pushed to and pulled from, ie. "registry.fi-hel1.internal", empty if it has none
+ALTER TABLE container_zone ADD COLUMN IF NOT EXISTS registry_host TEXT NOT NULL DEFAULT '';
+
+-- an image built from a git repo or an uploaded tarball by a Kaniko job in the project's namespace
+CREATE TABLE IF NOT EXISTS image_build (
+    image_build_id SERIAL PRIMARY KEY,
+    created_at TIMESTAMPTZ NOT NULL DEFAULT now(),
+    project_id INTEGER REFERENCES project(project_id) NOT NULL,
+    created_by_account_id INTEGER REFERENCES account(account_id) NOT NULL, -- its CPU and memory count against their quota
+
+    source TEXT NOT NULL, -- git | tarball
+    git_url TEXT,
+    git_ref TEXT, -- a branch, "refs/..." or a commit, the default branch if empty
+    context_path TEXT NOT NULL DEFAULT '', -- the build context's directory in the repo or tarball
+    dockerfile_path TEXT NOT NULL DEFAULT 'Dockerfile', -- relative to the context
+    context_tarball BYTEA, -- dropped once the build's done
+    context_token TEXT UNIQUE, -- what the job fetches the tarball with
+
+    zone_name TEXT REFERENCES container_zone(name) NOT NULL, -- where the job runs
+    push_zones TEXT[] NOT NULL, -- whose registries it pushes to
+    image_ref TEXT NOT NULL,
+    image_tag TEXT NOT NULL,
+    image_digest TEXT,
+
+    cpu_millicores INTEGER NOT NULL,
+    memory_mb INTEGER NOT NULL,
+    usage_released_at TIMESTAMPTZ, -- when the CPU and memory were given back to the account's quota
+
+    status TEXT NOT NULL DEFAULT 'queued', -- queued | building | succeeded | failed
+    error TEXT,
+    logs TEXT, -- kept once the build's done, the job goes away
+    started_at TIMESTAMPTZ,
+    finished_at TIMESTAMPTZ,
+
+    -- the container that gets deployed from it once it's built, if any
+    container_claim_id INTEGER REFERENCES container_claim(container_claim_id)
+);
+CREATE INDEX IF NOT EXISTS image_build_project_created_at ON image_build (project_id, created_at);
+CREATE INDEX IF NOT EXISTS image_build_unfinished ON image_build (status) WHERE finished_at IS NULL;
+
+-- +migrate Down
+DROP TABLE IF EXISTS image_build;
+ALTER TABLE container_zone DROP COLUMN IF EXISTS registry_host;
@@ -0,0 +1,34 @@
+-- +migrate Up
+-- logins for a project's repositories in the zones' registries, traded for tokens at /registry-token
+CREATE TABLE IF NOT EXISTS registry_credential (
+    registry_credential_id SERIAL PRIMARY KEY,
+    created_at TIMESTAMPTZ NOT NULL DEFAULT now(),
+    project_id INTEGER REFERENCES project(project_id) NOT NULL,
+    name TEXT NOT NULL, -- "lcaas-pull" and "lcaas-build" are ours, for image pull secrets and builds
+    username TEXT NOT NULL UNIQUE,
+    sealed_password BYTEA NOT NULL, -- encrypted with CREDENTIALS_KEY like the pull credentials
+    can_push BOOLEAN NOT NULL DEFAULT false,
+    last_used_at TIMESTAMPTZ,
+    deleted_at TIMESTAMPTZ
+);
+CREATE UNIQUE INDEX IF NOT EXISTS registry_credential_project_name ON registry_credential (project_id, name) WHERE deleted_at IS NULL;
+
+-- the manifests in the zones' registries, as the registry manager last saw them
+CREATE TABLE IF NOT EXISTS registry_image (
+    registry_image_id SERIAL PRIMARY KEY,
+    zone_name TEXT REFERENCES container_zone(name) NOT NULL,
+    project_id INTEGER REFERENCES project(project_id) NOT NULL,
+    repository TEXT NOT NULL,
+    digest TEXT NOT NULL,
+    tags TEXT[] NOT NULL DEFAULT '{}', -- empty once they've all moved on to other manifests
+    size_bytes BIGINT NOT NULL, -- its config and layers, counted against the project's quota
+    first_seen_at TIMESTAMPTZ NOT NULL DEFAULT now(),
+    last_seen_at TIMESTAMPTZ NOT NULL DEFAULT now(),
+    deleted_at TIMESTAMPTZ, -- garbage-collected
+    UNIQUE (zone_name, repository, digest)
+);
+CREATE INDEX IF NOT EXISTS registry_image_project ON registry_image (project_id) WHERE deleted_at IS NULL;
+
+-- +migrate Down
+DROP TABLE IF EXISTS registry_image;
+DROP TABLE IF EXISTS registry_credential;
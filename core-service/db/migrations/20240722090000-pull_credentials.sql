@@ -0,0 +1,23 @@
+-- +migrate Up
+-- logins for other registries, with the password and token encrypted with CREDENTIALS_KEY
+CREATE TABLE IF NOT EXISTS pull_credential (
+    pull_credential_id SERIAL PRIMARY KEY,
+    created_at TIMESTAMPTZ NOT NULL DEFAULT now(),
+    rotated_at TIMESTAMPTZ,
+    project_id INTEGER REFERENCES project(project_id) NOT NULL,
+    name TEXT NOT NULL,
+    url TEXT NOT NULL,
+    email TEXT NOT NULL DEFAULT '',
+    username TEXT NOT NULL DEFAULT '',
+    sealed_secret BYTEA NOT NULL,
+    deleted_at TIMESTAMPTZ
+);
+CREATE UNIQUE INDEX IF NOT EXISTS pull_credential_project_name ON pull_credential (project_id, name) WHERE deleted_at IS NULL;
+
+ALTER TABLE container_claim ADD COLUMN IF NOT EXISTS pull_credential_name TEXT; -- its image pull secret is kept up to date with this one
+ALTER TABLE container_revision ADD COLUMN IF NOT EXISTS pull_credential_name TEXT;
+
+-- +migrate Down
+ALTER TABLE container_revision DROP COLUMN IF EXISTS pull_credential_name;
+ALTER TABLE container_claim DROP COLUMN IF EXISTS pull_credential_name;
+DROP TABLE IF EXISTS pull_credential;
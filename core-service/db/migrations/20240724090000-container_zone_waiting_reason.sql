@@ -0,0 +1,7 @@
+-- +migrate Up
+ALTER TABLE container_zone_status ADD COLUMN IF NOT EXISTS waiting_reason TEXT; -- ie. ImagePullBackOff, CreateContainerConfigError
+ALTER TABLE container_zone_status ADD COLUMN IF NOT EXISTS waiting_message TEXT;
+
+-- +migrate Down
+ALTER TABLE container_zone_status DROP COLUMN IF EXISTS waiting_message;
+ALTER TABLE container_zone_status DROP COLUMN IF EXISTS waiting_reason;
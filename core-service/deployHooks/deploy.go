@@ -0,0 +1,86 @@
+package deployHooks
+
+import (
+	"errors"
+	"fmt"
+
+	"github.com/charmbracelet/log"
+	"github.com/jmoiron/sqlx"
+	"github.com/lu1a/lcaas/core-service/db"
+	"github.com/lu1a/lcaas/core-service/kubeOps"
+	"github.com/lu1a/lcaas/core-service/registryOps"
+	"github.com/lu1a/lcaas/core-service/types"
+)
+
+var ErrDeployInProgress = errors.New("The container is being deployed already, try again once that's done")
+
+// Deploys tag (the container's current one if empty) of the hook's container, pinned to digest if it's given and to
+// whatever the tag resolves to otherwise. It goes through the image policy like a new container would, and then the
+// same path as re-runs and rollbacks, which leaves a revision with reason in the container's history. Nothing gets
+// deployed if that's what's running already.
+func Deploy(log log.Logger, adminDB *sqlx.DB, zoneRegistry *kubeOps.ZoneRegistry, policy types.ImagePolicy, credentialsKey []byte, hook types.DeployHook, tag string, digest string, reason string) (newContainer types.ContainerClaim, deployed bool, err error) {
+	project, err := db.GetProjectByID(adminDB, hook.ProjectID)
+	if err != nil {
+		return newContainer, false, err
+	}
+	account, err := db.GetAccountByID(adminDB, hook.CreatedByAccountID)
+	if err != nil {
+		return newContainer, false, err
+	}
+	oldContainer, err := db.GetContainerByProjectAndName(adminDB, project, hook.ContainerName)
+	if err != nil {
+		return newContainer, false, fmt.Errorf("There's no container called %s anymore", hook.ContainerName)
+	}
+	if oldContainer.Status == "building" || oldContainer.Status == "activating" || oldContainer.Status == "deactivating" {
+		return oldContainer, false, ErrDeployInProgress
+	}
+
+	newContainer = oldContainer
+	if tag != "" {
+		newContainer.ImageTag = tag
+	}
+	newContainer.ImageDigest = nil
+	if digest != "" {
+		newContainer.ImageDigest = &digest
+	}
+	if newContainer.HasImagePullSecret() {
+		newContainer.ImagePullSecret, err = kubeOps.GetImagePullSecret(zoneRegistry.Zones(), project, oldContainer)
+		if err != nil {
+			return oldContainer, false, err
+		}
+	}
+	newContainer, err = registryOps.VerifyImage(policy, newContainer)
+	if err != nil {
+		return oldContainer, false, err
+	}
+	if newContainer.ImageTag == oldContainer.ImageTag && oldContainer.ImageDigest != nil && *newContainer.ImageDigest == *oldContainer.ImageDigest {
+		return oldContainer, false, nil
+	}
+
+	newContainer.DeployReason = reason
+	err = db.SetContainerAsDeactivating(adminDB, oldContainer)
+	if err != nil {
+		return oldContainer, false, err
+	}
+
+	// delete the old container, then instantiate the new one
+	go func() {
+		err := kubeOps.RecreateContainer(log, adminDB, zoneRegistry.Zones(), credentialsKey, account, project, oldContainer, newContainer)
+		if err != nil {
+			log.Error(err.Error())
+			return
+		}
+	}()
+	return newContainer, true, nil
+}
+
+// What the hook says about a deploy, or what stopped it
+func describeDeploy(newContainer types.ContainerClaim, deployed bool, err error) string {
+	if err != nil {
+		return "Not deployed: " + err.Error()
+	}
+	if !deployed {
+		return fmt.Sprintf("Already running %s:%s", newContainer.ImageRef, newContainer.ImageTag)
+	}
+	return fmt.Sprintf("Deploying %s:%s@%s", newContainer.ImageRef, newContainer.ImageTag, *newContainer.ImageDigest)
+}
@@ -0,0 +1,107 @@
+package deployHooks
+
+import (
+	"database/sql"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"net/http"
+	"strings"
+
+	"github.com/charmbracelet/log"
+	"github.com/jmoiron/sqlx"
+	"github.com/lu1a/lcaas/core-service/db"
+	"github.com/lu1a/lcaas/core-service/kubeOps"
+	"github.com/lu1a/lcaas/core-service/registryOps"
+	"github.com/lu1a/lcaas/core-service/types"
+)
+
+// Serves the hooks' secret URLs. The token is all the auth there is, so it sits in front of the auth middleware.
+func DeployHookRouter(log log.Logger, adminDB *sqlx.DB, zoneRegistry *kubeOps.ZoneRegistry, config types.Config) *http.ServeMux {
+	r := http.NewServeMux()
+
+	// Called by a registry's push webhook or a CI job, ie. curl -X POST -d "tag=v1.2.3" https://.../deploy-hook/<token>
+	r.HandleFunc("POST /deploy-hook/{token}", func(w http.ResponseWriter, r *http.Request) {
+		apiResponse := IDeployHookResponse{}
+		hook, err := db.GetDeployHookByToken(adminDB, r.PathValue("token"))
+		if err == sql.ErrNoRows {
+			http.Error(w, "There's no such deploy hook", http.StatusNotFound)
+			return
+		}
+		if err != nil {
+			http.Error(w, err.Error(), http.StatusInternalServerError)
+			return
+		}
+		apiResponse.ContainerName = hook.ContainerName
+
+		pushed, err := parsePush(r)
+		if err != nil {
+			http.Error(w, err.Error(), http.StatusBadRequest)
+			return
+		}
+
+		project, err := db.GetProjectByID(adminDB, hook.ProjectID)
+		if err != nil {
+			http.Error(w, err.Error(), http.StatusInternalServerError)
+			return
+		}
+		container, err := db.GetContainerByProjectAndName(adminDB, project, hook.ContainerName)
+		if err != nil {
+			http.Error(w, fmt.Sprintf("There's no container called %s anymore", hook.ContainerName), http.StatusNotFound)
+			return
+		}
+		// the hook only ever changes the tag or digest, so a push to some other repository has nothing to do with it
+		if pushed.repository != "" && !strings.EqualFold(pushed.repository, registryOps.RepositoryOf(container.ImageRef)) {
+			http.Error(w, fmt.Sprintf("%s pushed to %s, but the container runs %s", pushed.source, pushed.repository, container.ImageRef), http.StatusBadRequest)
+			return
+		}
+
+		reason := "deploy hook: " + pushed.source
+		if pushed.tag != "" {
+			reason += " pushed " + pushed.tag
+		}
+		newContainer, deployed, err := Deploy(log, adminDB, zoneRegistry, config.ImagePolicy, config.CredentialsKey, hook, pushed.tag, pushed.digest, reason)
+		apiResponse.Deployed = deployed
+		apiResponse.Result = describeDeploy(newContainer, deployed, err)
+		dberr := db.SetDeployHookResult(adminDB, hook, apiResponse.Result)
+		if dberr != nil {
+			log.Error(dberr.Error())
+		}
+		if errors.Is(err, ErrDeployInProgress) {
+			http.Error(w, err.Error(), http.StatusConflict)
+			return
+		}
+		if err != nil {
+			http.Error(w, apiResponse.Result, http.StatusUnprocessableEntity)
+			return
+		}
+		apiResponse.Image = newContainer.ImageRef + ":" + newContainer.ImageTag
+		apiResponse.ImageDigest = newContainer.ImageDigest
+
+		apiResponseJSON, err := json.Marshal(apiResponse)
+		if err != nil {
+			http.Error(w, "Error encoding to JSON", http.StatusInternalServerError)
+			return
+		}
+
+		w.Header().Set("Content-Type", "application/json")
+		if deployed {
+			w.WriteHeader(http.StatusAccepted)
+		}
+		_, err = w.Write(apiResponseJSON)
+		if err != nil {
+			http.Error(w, "Error writing out JSON", http.StatusNotFound)
+		}
+	})
+
+	return r
+}
+
+// Where this service is reached at, as far as the request goes, for showing the hooks' URLs
+func BaseURL(r *http.Request) string {
+	scheme := "http"
+	if r.TLS != nil || r.Header.Get("X-Forwarded-Proto") == "https" {
+		scheme = "https"
+	}
+	return scheme + "://" + r.Host
+}
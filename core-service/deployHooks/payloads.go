@@ -0,0 +1,111 @@
+package deployHooks
+
+import (
+	"encoding/json"
+	"fmt"
+	"io"
+	"mime"
+	"net/http"
+	"strings"
+)
+
+// What got pushed, as far as the caller said. Repository is empty for callers that don't say.
+type push struct {
+	source     string // docker hub | ghcr | harbor | ci
+	repository string
+	tag        string
+	digest     string
+}
+
+// The parts of the registries' push webhooks we read, all in one since they don't share any keys we care about
+type pushPayload struct {
+	// Docker Hub
+	PushData *struct {
+		Tag string `json:"tag"`
+	} `json:"push_data"`
+	Repository *struct {
+		RepoName string `json:"repo_name"`
+	} `json:"repository"`
+
+	// GHCR, as GitHub's package and registry_package events
+	Package         *githubPackage `json:"package"`
+	RegistryPackage *githubPackage `json:"registry_package"`
+
+	// Harbor
+	Type      string `json:"type"`
+	EventData *struct {
+		Resources []struct {
+			Digest string `json:"digest"`
+			Tag    string `json:"tag"`
+		} `json:"resources"`
+		Repository struct {
+			RepoFullName string `json:"repo_full_name"`
+		} `json:"repository"`
+	} `json:"event_data"`
+
+	// anything else, ie. a CI job
+	Tag    string `json:"tag"`
+	Digest string `json:"digest"`
+}
+
+type githubPackage struct {
+	Name           string `json:"name"`
+	Namespace      string `json:"namespace"`
+	PackageType    string `json:"package_type"`
+	PackageVersion struct {
+		ContainerMetadata struct {
+			Tag struct {
+				Name   string `json:"name"`
+				Digest string `json:"digest"`
+			} `json:"tag"`
+		} `json:"container_metadata"`
+	} `json:"package_version"`
+}
+
+// Reads a Docker Hub, GHCR or Harbor push webhook, or tag and digest from a JSON body, a form or the query string
+func parsePush(r *http.Request) (p push, err error) {
+	mediaType, _, _ := mime.ParseMediaType(r.Header.Get("Content-Type"))
+	if mediaType != "application/json" {
+		return push{source: "ci", tag: r.FormValue("tag"), digest: r.FormValue("digest")}, nil
+	}
+
+	var payload pushPayload
+	err = json.NewDecoder(io.LimitReader(r.Body, 1<<20)).Decode(&payload)
+	if err != nil && err != io.EOF {
+		return p, fmt.Errorf("The body isn't JSON: %w", err)
+	}
+
+	switch {
+	case payload.PushData != nil:
+		p = push{source: "docker hub", tag: payload.PushData.Tag}
+		if payload.Repository != nil {
+			p.repository = payload.Repository.RepoName
+		}
+	case payload.Package != nil || payload.RegistryPackage != nil:
+		pkg := payload.Package
+		if pkg == nil {
+			pkg = payload.RegistryPackage
+		}
+		if pkg.PackageType != "" && !strings.EqualFold(pkg.PackageType, "container") {
+			return p, fmt.Errorf("The package is a %s one, not a container image", pkg.PackageType)
+		}
+		tag := pkg.PackageVersion.ContainerMetadata.Tag
+		p = push{source: "ghcr", repository: pkg.Namespace + "/" + pkg.Name, tag: tag.Name, digest: tag.Digest}
+	case payload.EventData != nil:
+		if payload.Type != "PUSH_ARTIFACT" {
+			return p, fmt.Errorf("Only PUSH_ARTIFACT events deploy anything, this was %s", payload.Type)
+		}
+		if len(payload.EventData.Resources) == 0 {
+			return p, fmt.Errorf("The push has no artifacts in it")
+		}
+		resource := payload.EventData.Resources[0]
+		p = push{source: "harbor", repository: payload.EventData.Repository.RepoFullName, tag: resource.Tag, digest: resource.Digest}
+	default:
+		p = push{source: "ci", tag: payload.Tag, digest: payload.Digest}
+	}
+
+	if p.digest != "" && !strings.HasPrefix(p.digest, "sha256:") {
+		return p, fmt.Errorf("%s isn't a sha256 digest", p.digest)
+	}
+	return p, nil
+}
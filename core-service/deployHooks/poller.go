@@ -0,0 +1,113 @@
+package deployHooks
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"time"
+
+	"github.com/charmbracelet/log"
+	"github.com/jmoiron/sqlx"
+	"github.com/lu1a/lcaas/core-service/db"
+	"github.com/lu1a/lcaas/core-service/kubeOps"
+	"github.com/lu1a/lcaas/core-service/metrics"
+	"github.com/lu1a/lcaas/core-service/registryOps"
+	"github.com/lu1a/lcaas/core-service/types"
+)
+
+// Registries rate limit manifest requests (Docker Hub to a hundred every six hours without an account), so this
+// shouldn't get much more often
+const pollInterval = 5 * time.Minute
+
+// Polls the registry of every container whose deploy hook follows its tag, and deploys the tag's new digest when it
+// moves on. Containers that are in the middle of a deploy get another look on the next round.
+type Poller struct {
+	log            log.Logger
+	adminDB        *sqlx.DB
+	zoneRegistry   *kubeOps.ZoneRegistry
+	policy         types.ImagePolicy
+	credentialsKey []byte
+}
+
+func NewPoller(log log.Logger, adminDB *sqlx.DB, zoneRegistry *kubeOps.ZoneRegistry, policy types.ImagePolicy, credentialsKey []byte) *Poller {
+	return &Poller{log: log, adminDB: adminDB, zoneRegistry: zoneRegistry, policy: policy, credentialsKey: credentialsKey}
+}
+
+// Polls every pollInterval until ctx is done
+func (p *Poller) Run(ctx context.Context) {
+	ticker := time.NewTicker(pollInterval)
+	defer ticker.Stop()
+	for {
+		select {
+		case <-ctx.Done():
+			return
+		case <-ticker.C:
+			metrics.WorkerRan("deploy_hook_poller", p.poll(ctx))
+		}
+	}
+}
+
+func (p *Poller) poll(ctx context.Context) error {
+	hooks, err := db.GetFollowingDeployHooks(p.adminDB)
+	if err != nil {
+		p.log.Error(err.Error())
+		return err
+	}
+
+	var errs []error
+	for _, hook := range hooks {
+		if ctx.Err() != nil {
+			break
+		}
+		err = p.pollHook(hook)
+		if err != nil {
+			p.log.Error("Polling for the deploy hook failed", "project", hook.ProjectID, "container", hook.ContainerName, "err", err)
+			errs = append(errs, fmt.Errorf("deploy hook %v: %w", hook.DeployHookID, err))
+		}
+	}
+	return errors.Join(errs...)
+}
+
+// Only asks the registry here, and leaves checking the image to Deploy
+func (p *Poller) pollHook(hook types.DeployHook) error {
+	err := db.SetDeployHookPolled(p.adminDB, hook)
+	if err != nil {
+		return err
+	}
+	project, err := db.GetProjectByID(p.adminDB, hook.ProjectID)
+	if err != nil {
+		return err
+	}
+	container, err := db.GetContainerByProjectAndName(p.adminDB, project, hook.ContainerName)
+	if err != nil {
+		return err
+	}
+	if container.Status == "building" || container.Status == "activating" || container.Status == "deactivating" {
+		return nil
+	}
+
+	var creds *types.ImagePullSecret
+	if container.HasImagePullSecret() {
+		creds, err = kubeOps.GetImagePullSecret(p.zoneRegistry.Zones(), project, container)
+		if err != nil {
+			return err
+		}
+	}
+	digest, err := registryOps.ResolveDigest(container.ImageRef, container.ImageTag, creds)
+	if err != nil {
+		return err
+	}
+	if container.ImageDigest != nil && *container.ImageDigest == digest {
+		return nil
+	}
+
+	newContainer, deployed, err := Deploy(p.log, p.adminDB, p.zoneRegistry, p.policy, p.credentialsKey, hook, container.ImageTag, digest, "follow tag: "+container.ImageTag+" moved on")
+	if deployed {
+		p.log.Info("Deploying the new digest of a followed tag", "project", project.Name, "container", container.Name, "tag", container.ImageTag, "digest", digest)
+	}
+	// a deploy the image policy turned down is the project's to fix, so it only ends up on the hook
+	if err != nil {
+		p.log.Warn("Couldn't deploy the new digest of a followed tag", "project", project.Name, "container", container.Name, "err", err)
+	}
+	return db.SetDeployHookResult(p.adminDB, hook, describeDeploy(newContainer, deployed, err))
+}
@@ -0,0 +1,13 @@
+package deployHooks
+
+/*
+Route: /deploy-hook/{token}
+Type: mutation
+*/
+type IDeployHookResponse struct {
+	ContainerName string  `json:"container_name"`
+	Deployed      bool    `json:"deployed"` // false if it was running that already
+	Image         string  `json:"image"`
+	ImageDigest   *string `json:"image_digest"`
+	Result        string  `json:"result"`
+}
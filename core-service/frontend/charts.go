@@ -0,0 +1,151 @@
+package frontend
+
+import (
+	"fmt"
+	"math"
+	"strings"
+	"time"
+
+	"github.com/lu1a/lcaas/core-service/types"
+)
+
+// Charts get drawn here as inline SVG, so the pages don't need any JS for them
+const (
+	chartWidth  = 600
+	chartHeight = 160
+)
+
+type Chart struct {
+	Title  string
+	Width  int
+	Height int
+	Empty  bool
+
+	Lines []ChartLine
+
+	// the requested amount, which is also the limit. LimitLabel is empty for charts without one.
+	LimitY     float64
+	LimitLabel string
+	LimitHit   bool
+
+	TopLabel  string // what the top of the chart stands for
+	FromLabel string
+	ToLabel   string
+
+	Markers []ChartMarker
+}
+
+type ChartLine struct {
+	Name   string
+	Colour string
+	Dashed bool
+	Points string // as SVG polyline points
+}
+
+// A moment worth pointing out on a chart, ie. an OOM kill
+type ChartMarker struct {
+	X     float64
+	Label string
+}
+
+type chartSeries struct {
+	name   string
+	colour string
+	dashed bool
+	points []types.MetricPoint
+}
+
+// Scales everything to fit between from and to, and from 0 up to a bit over the highest of the limit and the series
+func newChart(title string, unit string, from time.Time, to time.Time, limit float64, limitHit bool, series ...chartSeries) Chart {
+	chart := Chart{Title: title, Width: chartWidth, Height: chartHeight, Empty: true, LimitHit: limitHit}
+
+	top := limit
+	for _, s := range series {
+		top = max(top, types.PeakOf(s.points))
+	}
+	top = max(1, top*1.1)
+
+	x := func(t time.Time) float64 {
+		return float64(t.Sub(from)) / float64(to.Sub(from)) * chartWidth
+	}
+	y := func(v float64) float64 {
+		return chartHeight - v/top*chartHeight
+	}
+
+	for _, s := range series {
+		if len(s.points) == 0 {
+			continue
+		}
+		chart.Empty = false
+		var points strings.Builder
+		for _, point := range s.points {
+			fmt.Fprintf(&points, "%.1f,%.1f ", x(point.Time), y(point.Value))
+		}
+		chart.Lines = append(chart.Lines, ChartLine{Name: s.name, Colour: s.colour, Dashed: s.dashed, Points: points.String()})
+	}
+
+	if limit > 0 {
+		chart.LimitY = math.Round(y(limit)*10) / 10
+		chart.LimitLabel = fmt.Sprintf("requested %s", formatChartValue(limit, unit))
+	}
+	chart.TopLabel = formatChartValue(top, unit)
+	chart.FromLabel = from.Format("2006-01-02 15:04")
+	chart.ToLabel = to.Format("2006-01-02 15:04")
+	return chart
+}
+
+func formatChartValue(v float64, unit string) string {
+	if unit == "%" {
+		return fmt.Sprintf("%.0f%%", v)
+	}
+	return fmt.Sprintf("%.0f%s", v, unit)
+}
+
+// The charts for one zone of a container
+type ZoneCharts struct {
+	Zone   types.ContainerZoneMetrics
+	Charts []Chart
+}
+
+func newZoneCharts(containerMetrics types.ContainerMetrics) (zoneCharts []ZoneCharts) {
+	from, to := containerMetrics.From, containerMetrics.To
+	for _, zone := range containerMetrics.Zones {
+		cpuChart := newChart("CPU", "m", from, to, float64(containerMetrics.RequestedCPUMilliCores), zone.CPULimitHit,
+			chartSeries{name: "used", colour: "#3b82f6", points: zone.CPUMilliCores},
+			chartSeries{name: "hourly peak", colour: "#3b82f6", dashed: true, points: zone.CPUMilliCoresPeak},
+		)
+		memoryChart := newChart("Memory", "MB", from, to, float64(containerMetrics.RequestedMemoryMB), zone.MemoryLimitHit,
+			chartSeries{name: "used", colour: "#10b981", points: zone.MemoryMB},
+			chartSeries{name: "hourly peak", colour: "#10b981", dashed: true, points: zone.MemoryMBPeak},
+		)
+		for _, oomKilledAt := range zone.OOMKills {
+			if oomKilledAt.Before(from) || oomKilledAt.After(to) {
+				continue
+			}
+			memoryChart.Markers = append(memoryChart.Markers, ChartMarker{
+				X:     math.Round(float64(oomKilledAt.Sub(from))/float64(to.Sub(from))*chartWidth*10) / 10,
+				Label: "OOM killed " + oomKilledAt.Format("2006-01-02 15:04"),
+			})
+		}
+		charts := []Chart{cpuChart, memoryChart}
+
+		if zone.Source == "prometheus" {
+			throttledPercent := make([]types.MetricPoint, len(zone.CPUThrottledRatio))
+			for i, point := range zone.CPUThrottledRatio {
+				throttledPercent[i] = types.MetricPoint{Time: point.Time, Value: point.Value * 100}
+			}
+			charts = append(charts,
+				newChart("CPU throttled", "%", from, to, 0, zone.CPULimitHit,
+					chartSeries{name: "periods throttled", colour: "#f59e0b", points: throttledPercent},
+				),
+				newChart("Network", "B/s", from, to, 0, false,
+					chartSeries{name: "received", colour: "#8b5cf6", points: zone.NetworkReceiveBytesPerSecond},
+					chartSeries{name: "sent", colour: "#ec4899", points: zone.NetworkTransmitBytesPerSecond},
+				),
+			)
+		}
+
+		zoneCharts = append(zoneCharts, ZoneCharts{Zone: zone, Charts: charts})
+	}
+	return zoneCharts
+}
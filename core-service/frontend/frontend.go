@@ -5,18 +5,26 @@ import (
 	"net/http"
 	"os/exec"
 	"path"
+	"strconv"
 	"strings"
 	"text/template"
+	"time"
 
 	"github.com/charmbracelet/log"
 	"github.com/jmoiron/sqlx"
 	"github.com/lu1a/lcaas/core-service/db"
+	"github.com/lu1a/lcaas/core-service/deployHooks"
+	"github.com/lu1a/lcaas/core-service/imageBuilds"
+	"github.com/lu1a/lcaas/core-service/internalRegistry"
 	"github.com/lu1a/lcaas/core-service/kubeOps"
 	"github.com/lu1a/lcaas/core-service/postgresOps"
+	"github.com/lu1a/lcaas/core-service/registryOps"
+	"github.com/lu1a/lcaas/core-service/seaweedOps"
 	"github.com/lu1a/lcaas/core-service/types"
+	"github.com/lu1a/lcaas/core-service/webhooks"
 )
 
-func FrontendRouter(log log.Logger, adminDB *sqlx.DB, kubeClients []types.ContainerZone, config types.Config) *http.ServeMux {
+func FrontendRouter(log log.Logger, adminDB *sqlx.DB, zoneRegistry *kubeOps.ZoneRegistry, config types.Config) *http.ServeMux {
 	r := http.NewServeMux()
 	r.Handle("GET /static/*", http.StripPrefix("/static/", http.FileServer(http.Dir(path.Join("frontend", "static")))))
 
@@ -103,6 +111,11 @@ func FrontendRouter(log log.Logger, adminDB *sqlx.DB, kubeClients []types.Contai
 			http.Error(w, err.Error(), http.StatusInternalServerError)
 			return
 		}
+		respData.UnreadNotifications, err = db.CountUnreadNotifications(adminDB, respData.Project)
+		if err != nil {
+			http.Error(w, err.Error(), http.StatusInternalServerError)
+			return
+		}
 
 		if err := tmpl.ExecuteTemplate(w, "base", respData); err != nil {
 			http.Error(w, err.Error(), http.StatusInternalServerError)
@@ -215,6 +228,12 @@ func FrontendRouter(log log.Logger, adminDB *sqlx.DB, kubeClients []types.Contai
 			return
 		}
 
+		respData.ZoneStatuses, err = db.GetContainerZoneStatusesByProject(adminDB, respData.Project)
+		if err != nil {
+			http.Error(w, err.Error(), http.StatusInternalServerError)
+			return
+		}
+
 		if err := tmpl.ExecuteTemplate(w, "base", respData); err != nil {
 			http.Error(w, err.Error(), http.StatusInternalServerError)
 		}
@@ -240,7 +259,34 @@ func FrontendRouter(log log.Logger, adminDB *sqlx.DB, kubeClients []types.Contai
 		respData.Account = account
 		respData.NavProps = NavProps{Account: account, PageTitle: projectName}
 		respData.ProjectName = projectName
-		respData.Zones = types.GetZonesFromContainerZones(kubeClients)
+		respData.Zones = types.GetSchedulableZonesFromContainerZones(zoneRegistry.Zones())
+
+		respData.Project, err = db.GetProjectByAccountAndName(adminDB, account, projectName)
+		if err != nil {
+			http.Error(w, err.Error(), http.StatusInternalServerError)
+			return
+		}
+		respData.SharedConfigs, err = db.GetSharedConfigsByProject(adminDB, respData.Project)
+		if err != nil {
+			http.Error(w, err.Error(), http.StatusInternalServerError)
+			return
+		}
+		userDBClaims, err := db.GetUserDBClaimsByProject(adminDB, respData.Project)
+		if err != nil {
+			http.Error(w, err.Error(), http.StatusInternalServerError)
+			return
+		}
+		respData.HasUserDB = len(userDBClaims) > 0
+		respData.ObjectStorages, err = db.GetObjectStoragesByProject(adminDB, respData.Project)
+		if err != nil {
+			http.Error(w, err.Error(), http.StatusInternalServerError)
+			return
+		}
+		respData.PullCredentials, err = db.GetPullCredentialsByProject(adminDB, respData.Project)
+		if err != nil {
+			http.Error(w, err.Error(), http.StatusInternalServerError)
+			return
+		}
 
 		if err := tmpl.ExecuteTemplate(w, "base", respData); err != nil {
 			http.Error(w, err.Error(), http.StatusInternalServerError)
@@ -251,8 +297,9 @@ func FrontendRouter(log log.Logger, adminDB *sqlx.DB, kubeClients []types.Contai
 		projectName := r.PathValue("projectName")
 		value := r.Context().Value(types.Account{})
 		account := value.(types.Account)
-		err := r.ParseForm()
-		if err != nil {
+		// multipart for the build context's upload, and the tarball's size gets checked once it's parsed
+		err := r.ParseMultipartForm(32 << 20)
+		if err != nil && err != http.ErrNotMultipart {
 			http.Error(w, err.Error(), http.StatusInternalServerError)
 			return
 		}
@@ -264,21 +311,62 @@ func FrontendRouter(log log.Logger, adminDB *sqlx.DB, kubeClients []types.Contai
 		}
 
 		newContainer := types.ContainerClaim{}
-		newContainer, err = newContainer.ParseContainerFieldsFromHTTPFormZoneProject(r, types.GetZonesFromContainerZones(kubeClients), thisProject.ProjectID)
+		newContainer, err = newContainer.ParseContainerFieldsFromHTTPFormZoneProject(r, types.GetZonesFromContainerZones(zoneRegistry.Zones()), thisProject.ProjectID)
+		if err != nil {
+			http.Error(w, err.Error(), http.StatusInternalServerError)
+			return
+		}
+
+		// built first, and deployed once that's done
+		if r.FormValue("build-source") != "" {
+			newBuild := types.ImageBuild{}
+			err = newBuild.ParseFromHTTPForm(r, int64(config.BuildPolicy.MaxContextMB)<<20)
+			if err != nil {
+				http.Error(w, err.Error(), http.StatusBadRequest)
+				return
+			}
+			newContainer, newBuild, err = imageBuilds.CreateContainerFromBuild(log, adminDB, zoneRegistry, config, account, thisProject, newContainer, newBuild, deployHooks.BaseURL(r))
+			if err != nil {
+				http.Error(w, err.Error(), http.StatusInternalServerError)
+				return
+			}
+			webhooks.EmitContainer(log, adminDB, thisProject, "container.created", newContainer)
+
+			http.Redirect(w, r, fmt.Sprintf("/project/%s/build/%v", projectName, newBuild.ImageBuildID), http.StatusSeeOther)
+			return
+		}
+
+		// pulled with one of the project's stored credentials instead of what was typed in, if one was picked
+		if pullCredentialName := r.FormValue("pull-credential"); pullCredentialName != "" {
+			credential, err := db.OpenPullCredentialByProjectAndName(adminDB, config.CredentialsKey, thisProject, pullCredentialName)
+			if err != nil {
+				http.Error(w, err.Error(), http.StatusBadRequest)
+				return
+			}
+			newContainer.UsePullCredential(credential)
+		}
+		// images in the zones' registries get pulled with the project's own credential
+		newContainer, err = internalRegistry.AttachPullSecret(adminDB, zoneRegistry.Zones(), config.RegistryPolicy, config.CredentialsKey, thisProject, newContainer)
 		if err != nil {
 			http.Error(w, err.Error(), http.StatusInternalServerError)
 			return
 		}
+		newContainer, err = registryOps.VerifyImage(config.ImagePolicy, newContainer)
+		if err != nil {
+			http.Error(w, err.Error(), registryOps.VerifyImageStatus(err))
+			return
+		}
 
 		newContainer, err = db.CreateContainerClaimForProject(adminDB, account, thisProject, newContainer)
 		if err != nil {
 			http.Error(w, err.Error(), http.StatusInternalServerError)
 			return
 		}
+		webhooks.EmitContainer(log, adminDB, thisProject, "container.created", newContainer)
 
 		// actually go and create the container
 		go func() {
-			err = kubeOps.CreateContainerFromClaim(log, adminDB, kubeClients, thisProject, newContainer, false)
+			err = kubeOps.CreateContainerFromClaim(log, adminDB, zoneRegistry.Zones(), config.CredentialsKey, thisProject, newContainer, false)
 			if err != nil {
 				log.Error(err.Error())
 				return
@@ -313,7 +401,7 @@ func FrontendRouter(log log.Logger, adminDB *sqlx.DB, kubeClients []types.Contai
 
 		// actually go and delete the container
 		go func() {
-			err = kubeOps.DeleteContainer(log, kubeClients, thisProject, thisContainer, false)
+			err = kubeOps.DeleteContainer(log, zoneRegistry.Zones(), thisProject, thisContainer, false)
 			if err != nil {
 				log.Error(err.Error())
 			}
@@ -323,6 +411,12 @@ func FrontendRouter(log log.Logger, adminDB *sqlx.DB, kubeClients []types.Contai
 				log.Error(err.Error())
 				return
 			}
+			webhooks.EmitContainer(log, adminDB, thisProject, "container.deleted", thisContainer)
+
+			err = db.DeleteDeployHookByProjectAndContainerName(adminDB, thisProject, containerName)
+			if err != nil {
+				log.Error(err.Error())
+			}
 		}()
 
 		http.Redirect(w, r, fmt.Sprintf("/project/%s", projectName), http.StatusSeeOther)
@@ -345,15 +439,24 @@ func FrontendRouter(log log.Logger, adminDB *sqlx.DB, kubeClients []types.Contai
 			return
 		}
 
-		// retain the same env vars, since we can't recreate them (we don't know the values of the secrets)
 		newContainer := oldContainer
-		for _, envVarName := range oldContainer.EnvVarNames {
-			if strings.HasSuffix(envVarName, "image-pull-secret") { // do the image-pull-secret separately
-				continue
+		newContainer.DeployReason = "rerun"
+
+		// a stored credential's login is known, so the image pull secret gets written again instead of being reused
+		if newContainer.PullCredentialName != nil {
+			credential, err := db.OpenPullCredentialByProjectAndName(adminDB, config.CredentialsKey, thisProject, *newContainer.PullCredentialName)
+			if err != nil {
+				http.Error(w, err.Error(), http.StatusConflict)
+				return
 			}
-			newContainer.EnvVars = append(newContainer.EnvVars, types.EnvVar{Name: envVarName})
+			newContainer.UsePullCredential(credential)
+		}
+		// the digest stays pinned, but the image still has to pass the policy as it is now
+		newContainer, err = registryOps.VerifyImage(config.ImagePolicy, newContainer)
+		if err != nil {
+			http.Error(w, err.Error(), registryOps.VerifyImageStatus(err))
+			return
 		}
-		newContainer.ImagePullSecret = &types.ImagePullSecret{}
 
 		err = db.SetContainerAsDeactivating(adminDB, oldContainer)
 		if err != nil {
@@ -363,23 +466,7 @@ func FrontendRouter(log log.Logger, adminDB *sqlx.DB, kubeClients []types.Contai
 
 		// delete the old container, then instantiate the new one
 		go func() {
-			err = kubeOps.DeleteContainer(log, kubeClients, thisProject, oldContainer, true)
-			if err != nil {
-				log.Error(err.Error())
-				return
-			}
-			err = db.DeleteContainerByProjectAndName(adminDB, thisProject, containerName)
-			if err != nil {
-				log.Error(err.Error())
-				return
-			}
-
-			newContainer, err = db.CreateContainerClaimForProject(adminDB, account, thisProject, newContainer)
-			if err != nil {
-				http.Error(w, err.Error(), http.StatusInternalServerError)
-				return
-			}
-			err = kubeOps.CreateContainerFromClaim(log, adminDB, kubeClients, thisProject, newContainer, true)
+			err := kubeOps.RecreateContainer(log, adminDB, zoneRegistry.Zones(), config.CredentialsKey, account, thisProject, oldContainer, newContainer)
 			if err != nil {
 				log.Error(err.Error())
 				return
@@ -423,7 +510,7 @@ func FrontendRouter(log log.Logger, adminDB *sqlx.DB, kubeClients []types.Contai
 		}
 		respData.Container = thisContainer
 
-		logs, err := kubeOps.GetContainerLogs(log, kubeClients, thisProject, thisContainer)
+		logs, err := kubeOps.GetContainerLogs(log, zoneRegistry.Zones(), thisProject, thisContainer)
 		if err != nil {
 			http.Error(w, err.Error(), http.StatusInternalServerError)
 			return
@@ -435,14 +522,16 @@ func FrontendRouter(log log.Logger, adminDB *sqlx.DB, kubeClients []types.Contai
 		}
 	})
 
-	r.HandleFunc("GET /project/{projectName}/database", func(w http.ResponseWriter, r *http.Request) {
+	r.HandleFunc("GET /project/{projectName}/c/{containerName}/history", func(w http.ResponseWriter, r *http.Request) {
 		projectName := r.PathValue("projectName")
+		containerName := r.PathValue("containerName")
+		respData := IContainerHistoryResponse{ProjectName: projectName}
+
 		fps := []string{
 			path.Join("frontend", "templates", "components", "base.html"),
-			path.Join("frontend", "templates", "pages", "project-database.html"),
+			path.Join("frontend", "templates", "pages", "container-history.html"),
 			path.Join("frontend", "templates", "components", "nav.html"),
 		}
-		respData := IProjectResponse{ProjectName: projectName}
 
 		tmpl, err := template.ParseFiles(fps...)
 		if err != nil {
@@ -454,19 +543,47 @@ func FrontendRouter(log log.Logger, adminDB *sqlx.DB, kubeClients []types.Contai
 		respData.Account = account
 		respData.NavProps = NavProps{Account: account, PageTitle: projectName}
 
-		respData.Project, err = db.GetProjectByAccountAndName(adminDB, account, projectName)
+		thisProject, err := db.GetProjectByAccountAndName(adminDB, account, projectName)
 		if err != nil {
 			http.Error(w, err.Error(), http.StatusInternalServerError)
 			return
 		}
 
-		pretendToHaveMultipleUserDBClaims, err := db.GetUserDBClaimsByProject(adminDB, respData.Project)
+		respData.Container, err = db.GetContainerByProjectAndName(adminDB, thisProject, containerName)
 		if err != nil {
 			http.Error(w, err.Error(), http.StatusInternalServerError)
 			return
 		}
-		if len(pretendToHaveMultipleUserDBClaims) > 0 {
-			respData.UserDBClaim = pretendToHaveMultipleUserDBClaims[0]
+
+		respData.Revisions, err = db.GetContainerRevisionsByProjectAndName(adminDB, thisProject, containerName)
+		if err != nil {
+			http.Error(w, err.Error(), http.StatusInternalServerError)
+			return
+		}
+
+		// diff two revisions if the form at the top of the page was used
+		fromRevision, fromErr := strconv.Atoi(r.FormValue("from"))
+		toRevision, toErr := strconv.Atoi(r.FormValue("to"))
+		if fromErr == nil && toErr == nil {
+			from, err := db.GetContainerRevision(adminDB, thisProject, containerName, fromRevision)
+			if err != nil {
+				http.Error(w, err.Error(), http.StatusNotFound)
+				return
+			}
+			to, err := db.GetContainerRevision(adminDB, thisProject, containerName, toRevision)
+			if err != nil {
+				http.Error(w, err.Error(), http.StatusNotFound)
+				return
+			}
+			respData.DiffFrom = from.Revision
+			respData.DiffTo = to.Revision
+			respData.Changes = from.Diff(to)
+		}
+
+		deployHook, err := db.GetDeployHookByProjectAndContainerName(adminDB, thisProject, containerName)
+		if err == nil {
+			respData.DeployHook = &deployHook
+			respData.DeployHookURL = deployHook.URL(deployHooks.BaseURL(r))
 		}
 
 		if err := tmpl.ExecuteTemplate(w, "base", respData); err != nil {
@@ -474,35 +591,39 @@ func FrontendRouter(log log.Logger, adminDB *sqlx.DB, kubeClients []types.Contai
 		}
 	})
 
-	r.HandleFunc("GET /project/{projectName}/new-db", func(w http.ResponseWriter, r *http.Request) {
+	r.HandleFunc("POST /project/{projectName}/{containerName}/create-deploy-hook", func(w http.ResponseWriter, r *http.Request) {
 		projectName := r.PathValue("projectName")
-		respData := INewDBResponse{}
-
-		fps := []string{
-			path.Join("frontend", "templates", "components", "base.html"),
-			path.Join("frontend", "templates", "pages", "new-db.html"),
-			path.Join("frontend", "templates", "components", "nav.html"),
+		containerName := r.PathValue("containerName")
+		value := r.Context().Value(types.Account{})
+		account := value.(types.Account)
+		err := r.ParseForm()
+		if err != nil {
+			http.Error(w, err.Error(), http.StatusInternalServerError)
+			return
 		}
 
-		tmpl, err := template.ParseFiles(fps...)
+		thisProject, err := db.GetProjectByAccountAndName(adminDB, account, projectName)
 		if err != nil {
 			http.Error(w, err.Error(), http.StatusInternalServerError)
 			return
 		}
-		value := r.Context().Value(types.Account{})
-		account := value.(types.Account)
-		respData.Account = account
-		respData.NavProps = NavProps{Account: account, PageTitle: projectName}
-		respData.ProjectName = projectName
-		respData.Zones = config.GetZonesFromUserDBConnections()
-
-		if err := tmpl.ExecuteTemplate(w, "base", respData); err != nil {
+		thisContainer, err := db.GetContainerByProjectAndName(adminDB, thisProject, containerName)
+		if err != nil {
+			http.Error(w, err.Error(), http.StatusNotFound)
+			return
+		}
+		_, err = db.CreateDeployHookForContainer(adminDB, account, thisProject, thisContainer, r.FormValue("follow-tag") == "on")
+		if err != nil {
 			http.Error(w, err.Error(), http.StatusInternalServerError)
+			return
 		}
+
+		http.Redirect(w, r, fmt.Sprintf("/project/%s/c/%s/history", projectName, containerName), http.StatusSeeOther)
 	})
 
-	r.HandleFunc("POST /project/{projectName}/new-db", func(w http.ResponseWriter, r *http.Request) {
+	r.HandleFunc("POST /project/{projectName}/{containerName}/follow-tag-deploy-hook", func(w http.ResponseWriter, r *http.Request) {
 		projectName := r.PathValue("projectName")
+		containerName := r.PathValue("containerName")
 		value := r.Context().Value(types.Account{})
 		account := value.(types.Account)
 		err := r.ParseForm()
@@ -516,43 +637,48 @@ func FrontendRouter(log log.Logger, adminDB *sqlx.DB, kubeClients []types.Contai
 			http.Error(w, err.Error(), http.StatusInternalServerError)
 			return
 		}
-
-		if len(r.Form["zone"]) != 1 {
-			http.Error(w, "Choose 1 and only 1 zone", http.StatusInternalServerError)
+		thisHook, err := db.GetDeployHookByProjectAndContainerName(adminDB, thisProject, containerName)
+		if err != nil {
+			http.Error(w, fmt.Sprintf("Container %s has no deploy hook", containerName), http.StatusNotFound)
 			return
 		}
-
-		newUserDBClaim := types.UserDBClaim{
-			ProjectID: thisProject.ProjectID,
-			Zones:     []string{r.FormValue("zone")},
+		_, err = db.SetDeployHookFollowTag(adminDB, thisHook, r.FormValue("follow-tag") == "true")
+		if err != nil {
+			http.Error(w, err.Error(), http.StatusInternalServerError)
+			return
 		}
 
-		newUserDBClaim, err = db.CreateUserDBClaimForProject(adminDB, thisProject, newUserDBClaim)
+		http.Redirect(w, r, fmt.Sprintf("/project/%s/c/%s/history", projectName, containerName), http.StatusSeeOther)
+	})
+
+	r.HandleFunc("POST /project/{projectName}/{containerName}/delete-deploy-hook", func(w http.ResponseWriter, r *http.Request) {
+		projectName := r.PathValue("projectName")
+		containerName := r.PathValue("containerName")
+		value := r.Context().Value(types.Account{})
+		account := value.(types.Account)
+
+		thisProject, err := db.GetProjectByAccountAndName(adminDB, account, projectName)
+		if err != nil {
+			http.Error(w, err.Error(), http.StatusInternalServerError)
+			return
+		}
+		err = db.DeleteDeployHookByProjectAndContainerName(adminDB, thisProject, containerName)
 		if err != nil {
 			http.Error(w, err.Error(), http.StatusInternalServerError)
 			return
 		}
 
-		// actually go and create database for this project
-		go func() {
-			err = postgresOps.CreateDatabaseForProject(log, adminDB, config.UserDBConnections, thisProject, newUserDBClaim)
-			if err != nil {
-				log.Error(err.Error())
-				return
-			}
-		}()
-
-		http.Redirect(w, r, fmt.Sprintf("/project/%s", projectName), http.StatusSeeOther)
+		http.Redirect(w, r, fmt.Sprintf("/project/%s/c/%s/history", projectName, containerName), http.StatusSeeOther)
 	})
 
-	r.HandleFunc("GET /project/{projectName}/db/{userDBName}", func(w http.ResponseWriter, r *http.Request) {
+	r.HandleFunc("GET /project/{projectName}/c/{containerName}/metrics", func(w http.ResponseWriter, r *http.Request) {
 		projectName := r.PathValue("projectName")
-		userDBName := r.PathValue("userDBName")
-		respData := IUserDBDetailsResponse{}
+		containerName := r.PathValue("containerName")
+		respData := IContainerMetricsResponse{ProjectName: projectName}
 
 		fps := []string{
 			path.Join("frontend", "templates", "components", "base.html"),
-			path.Join("frontend", "templates", "pages", "user-db-details.html"),
+			path.Join("frontend", "templates", "pages", "container-metrics.html"),
 			path.Join("frontend", "templates", "components", "nav.html"),
 		}
 
@@ -566,95 +692,122 @@ func FrontendRouter(log log.Logger, adminDB *sqlx.DB, kubeClients []types.Contai
 		respData.Account = account
 		respData.NavProps = NavProps{Account: account, PageTitle: projectName}
 
-		log.Debug("Logging this for no reason", "userDBName", userDBName)
 		thisProject, err := db.GetProjectByAccountAndName(adminDB, account, projectName)
 		if err != nil {
 			http.Error(w, err.Error(), http.StatusInternalServerError)
 			return
 		}
-		respData.Project = thisProject
 
-		thisUserDBClaim, err := db.GetUserDBClaimByProject(adminDB, thisProject)
+		thisContainer, err := db.GetContainerByProjectAndName(adminDB, thisProject, containerName)
 		if err != nil {
 			http.Error(w, err.Error(), http.StatusInternalServerError)
 			return
 		}
-		respData.UserDB = thisUserDBClaim
+		respData.Container = thisContainer
+
+		metricsRange := types.MetricsRange{}
+		err = metricsRange.ParseFromHTTPForm(r)
+		if err != nil {
+			http.Error(w, err.Error(), http.StatusBadRequest)
+			return
+		}
+		respData.Range = metricsRange.Name
+		for _, choice := range types.MetricsRanges {
+			respData.Ranges = append(respData.Ranges, choice.Name)
+		}
+		respData.Metrics = kubeOps.GetContainerMetrics(r.Context(), adminDB, zoneRegistry.Zones(), config.PrometheusURLs, thisProject, thisContainer, metricsRange)
+		respData.Zones = newZoneCharts(respData.Metrics)
 
 		if err := tmpl.ExecuteTemplate(w, "base", respData); err != nil {
 			http.Error(w, err.Error(), http.StatusInternalServerError)
 		}
 	})
 
-	r.HandleFunc("POST /project/{projectName}/db/{userDBName}/new-user", func(w http.ResponseWriter, r *http.Request) {
+	r.HandleFunc("POST /project/{projectName}/{containerName}/rollback-container", func(w http.ResponseWriter, r *http.Request) {
 		projectName := r.PathValue("projectName")
-		userDBName := r.PathValue("userDBName")
 		value := r.Context().Value(types.Account{})
 		account := value.(types.Account)
+		containerName := r.PathValue("containerName")
 		err := r.ParseForm()
 		if err != nil {
 			http.Error(w, err.Error(), http.StatusInternalServerError)
 			return
 		}
-
+		revisionNumber, err := strconv.Atoi(r.FormValue("revision"))
+		if err != nil {
+			http.Error(w, "revision has to be a number", http.StatusBadRequest)
+			return
+		}
 		thisProject, err := db.GetProjectByAccountAndName(adminDB, account, projectName)
 		if err != nil {
 			http.Error(w, err.Error(), http.StatusInternalServerError)
 			return
 		}
 
-		thisUserDBClaim, err := db.GetUserDBClaimByProject(adminDB, thisProject)
+		oldContainer, err := db.GetContainerByProjectAndName(adminDB, thisProject, containerName)
 		if err != nil {
 			http.Error(w, err.Error(), http.StatusInternalServerError)
 			return
 		}
-
-		err = postgresOps.CreateNewUserForUserDB(log, adminDB, config.UserDBConnections, thisProject, thisUserDBClaim, r.FormValue("username"))
+		revision, err := db.GetContainerRevision(adminDB, thisProject, containerName, revisionNumber)
 		if err != nil {
-			http.Error(w, err.Error(), http.StatusInternalServerError)
+			http.Error(w, err.Error(), http.StatusNotFound)
+			return
+		}
+		if missing := revision.MissingEnvVarNames(oldContainer); len(missing) > 0 {
+			http.Error(w, fmt.Sprintf("Can't roll back, the secrets for %s don't exist anymore", strings.Join(missing, ", ")), http.StatusConflict)
+			return
+		}
+		// its env var and image pull secrets get reused as they are, and they only exist in the zones it's in now
+		if zones := revision.ZonesNotIn(oldContainer); len(zones) > 0 {
+			http.Error(w, fmt.Sprintf("Can't roll back, the revision ran in %s, which the container isn't in anymore", strings.Join(zones, ", ")), http.StatusConflict)
 			return
 		}
 
-		http.Redirect(w, r, fmt.Sprintf("/project/%s/db/%s", projectName, userDBName), http.StatusSeeOther)
-	})
+		newContainer := revision.AppliedTo(oldContainer)
+		newContainer.DeployReason = fmt.Sprintf("rollback to revision %d", revision.Revision)
 
-	r.HandleFunc("POST /project/{projectName}/delete-db", func(w http.ResponseWriter, r *http.Request) {
-		projectName := r.PathValue("projectName")
-		value := r.Context().Value(types.Account{})
-		account := value.(types.Account)
-		thisProject, err := db.GetProjectByAccountAndName(adminDB, account, projectName)
+		// a stored credential's login is known, so the image pull secret gets written again instead of being reused
+		if newContainer.PullCredentialName != nil {
+			credential, err := db.OpenPullCredentialByProjectAndName(adminDB, config.CredentialsKey, thisProject, *newContainer.PullCredentialName)
+			if err != nil {
+				http.Error(w, err.Error(), http.StatusConflict)
+				return
+			}
+			newContainer.UsePullCredential(credential)
+		}
+		// the digest stays pinned, but the image still has to pass the policy as it is now
+		newContainer, err = registryOps.VerifyImage(config.ImagePolicy, newContainer)
 		if err != nil {
-			http.Error(w, err.Error(), http.StatusInternalServerError)
+			http.Error(w, err.Error(), registryOps.VerifyImageStatus(err))
 			return
 		}
 
-		thisUserDBClaim, err := db.GetUserDBClaimByProject(adminDB, thisProject)
+		err = db.SetContainerAsDeactivating(adminDB, oldContainer)
 		if err != nil {
 			http.Error(w, err.Error(), http.StatusInternalServerError)
 			return
 		}
 
-		// actually go and delete database
 		go func() {
-			err = postgresOps.DeleteDatabaseForProject(log, adminDB, config.UserDBConnections, thisProject, thisUserDBClaim)
+			err := kubeOps.RecreateContainer(log, adminDB, zoneRegistry.Zones(), config.CredentialsKey, account, thisProject, oldContainer, newContainer)
 			if err != nil {
 				log.Error(err.Error())
 				return
 			}
 		}()
 
-		http.Redirect(w, r, fmt.Sprintf("/project/%s", projectName), http.StatusSeeOther)
+		http.Redirect(w, r, fmt.Sprintf("/project/%s/c/%s/history", projectName, containerName), http.StatusSeeOther)
 	})
 
-	r.HandleFunc("GET /project/{projectName}/new-object-storage", func(w http.ResponseWriter, r *http.Request) {
+	r.HandleFunc("GET /project/{projectName}/database", func(w http.ResponseWriter, r *http.Request) {
 		projectName := r.PathValue("projectName")
-		respData := INewObjectStorageResponse{}
-
 		fps := []string{
 			path.Join("frontend", "templates", "components", "base.html"),
-			path.Join("frontend", "templates", "pages", "new-object-storage.html"),
+			path.Join("frontend", "templates", "pages", "project-database.html"),
 			path.Join("frontend", "templates", "components", "nav.html"),
 		}
+		respData := IProjectResponse{ProjectName: projectName}
 
 		tmpl, err := template.ParseFiles(fps...)
 		if err != nil {
@@ -665,60 +818,148 @@ func FrontendRouter(log log.Logger, adminDB *sqlx.DB, kubeClients []types.Contai
 		account := value.(types.Account)
 		respData.Account = account
 		respData.NavProps = NavProps{Account: account, PageTitle: projectName}
-		respData.ProjectName = projectName
-		respData.Zones = types.GetZonesFromContainerZones(kubeClients)
 
-		if err := tmpl.ExecuteTemplate(w, "base", respData); err != nil {
-			http.Error(w, err.Error(), http.StatusInternalServerError)
-		}
-	})
-
-	r.HandleFunc("POST /project/{projectName}/new-object-storage", func(w http.ResponseWriter, r *http.Request) {
-		projectName := r.PathValue("projectName")
-		value := r.Context().Value(types.Account{})
-		account := value.(types.Account)
-		err := r.ParseForm()
+		respData.Project, err = db.GetProjectByAccountAndName(adminDB, account, projectName)
 		if err != nil {
 			http.Error(w, err.Error(), http.StatusInternalServerError)
 			return
 		}
 
-		thisProject, err := db.GetProjectByAccountAndName(adminDB, account, projectName)
+		pretendToHaveMultipleUserDBClaims, err := db.GetUserDBClaimsByProject(adminDB, respData.Project)
 		if err != nil {
 			http.Error(w, err.Error(), http.StatusInternalServerError)
 			return
 		}
-
-		zones := []string{}
-		for i := 0; i < len(r.Form["zone[]"]); i++ {
-			zone := r.Form["zone[]"][i]
-			if zone != "" {
-				zones = append(zones, zone)
-			}
-		}
-		if len(zones) == 0 {
-			zones = types.GetZonesFromContainerZones(kubeClients)
-		}
-
-		newObjectStorage := types.ObjectStorageClaim{
-			Name:      r.FormValue("name"),
-			ProjectID: thisProject.ProjectID,
-			Zones:     zones,
+		if len(pretendToHaveMultipleUserDBClaims) > 0 {
+			respData.UserDBClaim = pretendToHaveMultipleUserDBClaims[0]
 		}
 
-		_, err = db.CreateObjectStorageForProject(adminDB, thisProject, newObjectStorage)
+		respData.ObjectStorages, err = db.GetObjectStoragesByProject(adminDB, respData.Project)
 		if err != nil {
 			http.Error(w, err.Error(), http.StatusInternalServerError)
 			return
 		}
 
-		// TODO: actually go and create the object storage
-
+		if err := tmpl.ExecuteTemplate(w, "base", respData); err != nil {
+			http.Error(w, err.Error(), http.StatusInternalServerError)
+		}
+	})
+
+	r.HandleFunc("GET /project/{projectName}/new-db", func(w http.ResponseWriter, r *http.Request) {
+		projectName := r.PathValue("projectName")
+		respData := INewDBResponse{}
+
+		fps := []string{
+			path.Join("frontend", "templates", "components", "base.html"),
+			path.Join("frontend", "templates", "pages", "new-db.html"),
+			path.Join("frontend", "templates", "components", "nav.html"),
+		}
+
+		tmpl, err := template.ParseFiles(fps...)
+		if err != nil {
+			http.Error(w, err.Error(), http.StatusInternalServerError)
+			return
+		}
+		value := r.Context().Value(types.Account{})
+		account := value.(types.Account)
+		respData.Account = account
+		respData.NavProps = NavProps{Account: account, PageTitle: projectName}
+		respData.ProjectName = projectName
+		respData.Zones = types.GetZonesFromUserDBConnections(zoneRegistry.UserDBConnections())
+
+		if err := tmpl.ExecuteTemplate(w, "base", respData); err != nil {
+			http.Error(w, err.Error(), http.StatusInternalServerError)
+		}
+	})
+
+	r.HandleFunc("POST /project/{projectName}/new-db", func(w http.ResponseWriter, r *http.Request) {
+		projectName := r.PathValue("projectName")
+		value := r.Context().Value(types.Account{})
+		account := value.(types.Account)
+		err := r.ParseForm()
+		if err != nil {
+			http.Error(w, err.Error(), http.StatusInternalServerError)
+			return
+		}
+
+		thisProject, err := db.GetProjectByAccountAndName(adminDB, account, projectName)
+		if err != nil {
+			http.Error(w, err.Error(), http.StatusInternalServerError)
+			return
+		}
+
+		if len(r.Form["zone"]) != 1 {
+			http.Error(w, "Choose 1 and only 1 zone", http.StatusInternalServerError)
+			return
+		}
+
+		newUserDBClaim := types.UserDBClaim{
+			ProjectID: thisProject.ProjectID,
+			Zones:     []string{r.FormValue("zone")},
+		}
+
+		newUserDBClaim, err = db.CreateUserDBClaimForProject(adminDB, thisProject, newUserDBClaim)
+		if err != nil {
+			http.Error(w, err.Error(), http.StatusInternalServerError)
+			return
+		}
+
+		// actually go and create database for this project
+		go func() {
+			err = postgresOps.CreateDatabaseForProject(log, adminDB, zoneRegistry.UserDBConnections(), thisProject, newUserDBClaim)
+			if err != nil {
+				log.Error(err.Error())
+				return
+			}
+		}()
+
 		http.Redirect(w, r, fmt.Sprintf("/project/%s", projectName), http.StatusSeeOther)
 	})
 
-	r.HandleFunc("POST /project/{projectName}/delete-object-storage", func(w http.ResponseWriter, r *http.Request) {
+	r.HandleFunc("GET /project/{projectName}/db/{userDBName}", func(w http.ResponseWriter, r *http.Request) {
+		projectName := r.PathValue("projectName")
+		userDBName := r.PathValue("userDBName")
+		respData := IUserDBDetailsResponse{}
+
+		fps := []string{
+			path.Join("frontend", "templates", "components", "base.html"),
+			path.Join("frontend", "templates", "pages", "user-db-details.html"),
+			path.Join("frontend", "templates", "components", "nav.html"),
+		}
+
+		tmpl, err := template.ParseFiles(fps...)
+		if err != nil {
+			http.Error(w, err.Error(), http.StatusInternalServerError)
+			return
+		}
+		value := r.Context().Value(types.Account{})
+		account := value.(types.Account)
+		respData.Account = account
+		respData.NavProps = NavProps{Account: account, PageTitle: projectName}
+
+		log.Debug("Logging this for no reason", "userDBName", userDBName)
+		thisProject, err := db.GetProjectByAccountAndName(adminDB, account, projectName)
+		if err != nil {
+			http.Error(w, err.Error(), http.StatusInternalServerError)
+			return
+		}
+		respData.Project = thisProject
+
+		thisUserDBClaim, err := db.GetUserDBClaimByProject(adminDB, thisProject)
+		if err != nil {
+			http.Error(w, err.Error(), http.StatusInternalServerError)
+			return
+		}
+		respData.UserDB = thisUserDBClaim
+
+		if err := tmpl.ExecuteTemplate(w, "base", respData); err != nil {
+			http.Error(w, err.Error(), http.StatusInternalServerError)
+		}
+	})
+
+	r.HandleFunc("POST /project/{projectName}/db/{userDBName}/new-user", func(w http.ResponseWriter, r *http.Request) {
 		projectName := r.PathValue("projectName")
+		userDBName := r.PathValue("userDBName")
 		value := r.Context().Value(types.Account{})
 		account := value.(types.Account)
 		err := r.ParseForm()
@@ -726,36 +967,1122 @@ func FrontendRouter(log log.Logger, adminDB *sqlx.DB, kubeClients []types.Contai
 			http.Error(w, err.Error(), http.StatusInternalServerError)
 			return
 		}
-		objectStorageName := r.FormValue("object-storage-name")
+
 		thisProject, err := db.GetProjectByAccountAndName(adminDB, account, projectName)
 		if err != nil {
 			http.Error(w, err.Error(), http.StatusInternalServerError)
 			return
 		}
 
-		thisObjectStorage, err := db.GetObjectStorageByProjectAndName(adminDB, thisProject, objectStorageName)
+		thisUserDBClaim, err := db.GetUserDBClaimByProject(adminDB, thisProject)
 		if err != nil {
 			http.Error(w, err.Error(), http.StatusInternalServerError)
 			return
 		}
 
-		err = db.SetObjectStorageAsDeactivating(adminDB, thisObjectStorage)
+		err = postgresOps.CreateNewUserForUserDB(log, adminDB, zoneRegistry.UserDBConnections(), thisProject, thisUserDBClaim, r.FormValue("username"))
 		if err != nil {
 			http.Error(w, err.Error(), http.StatusInternalServerError)
 			return
 		}
 
-		// TODO: actually go and delete the object storage
+		http.Redirect(w, r, fmt.Sprintf("/project/%s/db/%s", projectName, userDBName), http.StatusSeeOther)
+	})
 
-		err = db.DeleteObjectStorageByProjectAndName(adminDB, thisProject, objectStorageName)
+	r.HandleFunc("POST /project/{projectName}/db/{userDBName}/rotate-password", func(w http.ResponseWriter, r *http.Request) {
+		projectName := r.PathValue("projectName")
+		userDBName := r.PathValue("userDBName")
+		value := r.Context().Value(types.Account{})
+		account := value.(types.Account)
+		thisProject, err := db.GetProjectByAccountAndName(adminDB, account, projectName)
+		if err != nil {
+			http.Error(w, err.Error(), http.StatusInternalServerError)
+			return
+		}
+
+		thisUserDBClaim, err := db.GetUserDBClaimByProject(adminDB, thisProject)
+		if err != nil {
+			http.Error(w, err.Error(), http.StatusInternalServerError)
+			return
+		}
+
+		err = postgresOps.RotateUserDBPassword(log, adminDB, zoneRegistry.UserDBConnections(), thisProject, thisUserDBClaim)
+		if err != nil {
+			http.Error(w, err.Error(), http.StatusInternalServerError)
+			return
+		}
+
+		// hand the new password to the containers bound to the DB
+		go func() {
+			err := kubeOps.RefreshServiceBindings(log, adminDB, zoneRegistry.Zones(), config.CredentialsKey, thisProject)
+			if err != nil {
+				log.Error(err.Error())
+				return
+			}
+		}()
+
+		http.Redirect(w, r, fmt.Sprintf("/project/%s/db/%s", projectName, userDBName), http.StatusSeeOther)
+	})
+
+	r.HandleFunc("POST /project/{projectName}/delete-db", func(w http.ResponseWriter, r *http.Request) {
+		projectName := r.PathValue("projectName")
+		value := r.Context().Value(types.Account{})
+		account := value.(types.Account)
+		thisProject, err := db.GetProjectByAccountAndName(adminDB, account, projectName)
+		if err != nil {
+			http.Error(w, err.Error(), http.StatusInternalServerError)
+			return
+		}
+
+		thisUserDBClaim, err := db.GetUserDBClaimByProject(adminDB, thisProject)
+		if err != nil {
+			http.Error(w, err.Error(), http.StatusInternalServerError)
+			return
+		}
+
+		boundContainers, err := db.GetContainersWithServiceBindings(adminDB, thisProject)
+		if err != nil {
+			http.Error(w, err.Error(), http.StatusInternalServerError)
+			return
+		}
+		for _, container := range boundContainers {
+			if container.BindDatabase {
+				http.Error(w, fmt.Sprintf("The database is still bound to container %s", container.Name), http.StatusConflict)
+				return
+			}
+		}
+
+		// actually go and delete database
+		go func() {
+			err = postgresOps.DeleteDatabaseForProject(log, adminDB, zoneRegistry.UserDBConnections(), thisProject, thisUserDBClaim)
+			if err != nil {
+				log.Error(err.Error())
+				return
+			}
+		}()
+
+		http.Redirect(w, r, fmt.Sprintf("/project/%s", projectName), http.StatusSeeOther)
+	})
+
+	r.HandleFunc("GET /project/{projectName}/new-object-storage", func(w http.ResponseWriter, r *http.Request) {
+		projectName := r.PathValue("projectName")
+		respData := INewObjectStorageResponse{}
+
+		fps := []string{
+			path.Join("frontend", "templates", "components", "base.html"),
+			path.Join("frontend", "templates", "pages", "new-object-storage.html"),
+			path.Join("frontend", "templates", "components", "nav.html"),
+		}
+
+		tmpl, err := template.ParseFiles(fps...)
+		if err != nil {
+			http.Error(w, err.Error(), http.StatusInternalServerError)
+			return
+		}
+		value := r.Context().Value(types.Account{})
+		account := value.(types.Account)
+		respData.Account = account
+		respData.NavProps = NavProps{Account: account, PageTitle: projectName}
+		respData.ProjectName = projectName
+		respData.Zones = types.GetZonesFromContainerZones(zoneRegistry.Zones())
+
+		if err := tmpl.ExecuteTemplate(w, "base", respData); err != nil {
+			http.Error(w, err.Error(), http.StatusInternalServerError)
+		}
+	})
+
+	r.HandleFunc("POST /project/{projectName}/new-object-storage", func(w http.ResponseWriter, r *http.Request) {
+		projectName := r.PathValue("projectName")
+		value := r.Context().Value(types.Account{})
+		account := value.(types.Account)
+		err := r.ParseForm()
+		if err != nil {
+			http.Error(w, err.Error(), http.StatusInternalServerError)
+			return
+		}
+
+		thisProject, err := db.GetProjectByAccountAndName(adminDB, account, projectName)
+		if err != nil {
+			http.Error(w, err.Error(), http.StatusInternalServerError)
+			return
+		}
+
+		zones := []string{}
+		for i := 0; i < len(r.Form["zone[]"]); i++ {
+			zone := r.Form["zone[]"][i]
+			if zone != "" {
+				zones = append(zones, zone)
+			}
+		}
+		if len(zones) == 0 {
+			zones = types.GetZonesFromContainerZones(zoneRegistry.Zones())
+		}
+
+		newObjectStorage := types.ObjectStorageClaim{
+			Name:      r.FormValue("name"),
+			ProjectID: thisProject.ProjectID,
+			Zones:     zones,
+		}
+
+		newObjectStorage, err = db.CreateObjectStorageForProject(adminDB, thisProject, newObjectStorage)
 		if err != nil {
 			http.Error(w, err.Error(), http.StatusInternalServerError)
 			return
 		}
+		webhooks.EmitObjectStorage(log, adminDB, thisProject, "object_storage.created", newObjectStorage)
+
+		// TODO: actually go and create the object storage
 
 		http.Redirect(w, r, fmt.Sprintf("/project/%s", projectName), http.StatusSeeOther)
 	})
 
+	r.HandleFunc("POST /project/{projectName}/delete-object-storage", func(w http.ResponseWriter, r *http.Request) {
+		projectName := r.PathValue("projectName")
+		value := r.Context().Value(types.Account{})
+		account := value.(types.Account)
+		err := r.ParseForm()
+		if err != nil {
+			http.Error(w, err.Error(), http.StatusInternalServerError)
+			return
+		}
+		objectStorageName := r.FormValue("object-storage-name")
+		thisProject, err := db.GetProjectByAccountAndName(adminDB, account, projectName)
+		if err != nil {
+			http.Error(w, err.Error(), http.StatusInternalServerError)
+			return
+		}
+
+		thisObjectStorage, err := db.GetObjectStorageByProjectAndName(adminDB, thisProject, objectStorageName)
+		if err != nil {
+			http.Error(w, err.Error(), http.StatusInternalServerError)
+			return
+		}
+
+		boundContainers, err := db.GetContainersWithServiceBindings(adminDB, thisProject)
+		if err != nil {
+			http.Error(w, err.Error(), http.StatusInternalServerError)
+			return
+		}
+		for _, container := range boundContainers {
+			if container.BoundObjectStorage != nil && *container.BoundObjectStorage == objectStorageName {
+				http.Error(w, fmt.Sprintf("%s is still bound to container %s", objectStorageName, container.Name), http.StatusConflict)
+				return
+			}
+		}
+
+		err = db.SetObjectStorageAsDeactivating(adminDB, thisObjectStorage)
+		if err != nil {
+			http.Error(w, err.Error(), http.StatusInternalServerError)
+			return
+		}
+
+		// TODO: actually go and delete the object storage
+
+		err = db.DeleteObjectStorageByProjectAndName(adminDB, thisProject, objectStorageName)
+		if err != nil {
+			http.Error(w, err.Error(), http.StatusInternalServerError)
+			return
+		}
+		webhooks.EmitObjectStorage(log, adminDB, thisProject, "object_storage.deleted", thisObjectStorage)
+
+		http.Redirect(w, r, fmt.Sprintf("/project/%s", projectName), http.StatusSeeOther)
+	})
+
+	r.HandleFunc("GET /project/{projectName}/shared-configs", func(w http.ResponseWriter, r *http.Request) {
+		projectName := r.PathValue("projectName")
+		fps := []string{
+			path.Join("frontend", "templates", "components", "base.html"),
+			path.Join("frontend", "templates", "pages", "project-shared-configs.html"),
+			path.Join("frontend", "templates", "components", "nav.html"),
+		}
+		respData := IProjectSharedConfigsResponse{ProjectName: projectName}
+
+		tmpl, err := template.ParseFiles(fps...)
+		if err != nil {
+			http.Error(w, err.Error(), http.StatusInternalServerError)
+			return
+		}
+		value := r.Context().Value(types.Account{})
+		account := value.(types.Account)
+		respData.Account = account
+		respData.NavProps = NavProps{Account: account, PageTitle: projectName}
+
+		respData.Project, err = db.GetProjectByAccountAndName(adminDB, account, projectName)
+		if err != nil {
+			http.Error(w, err.Error(), http.StatusInternalServerError)
+			return
+		}
+
+		sharedConfigs, err := db.GetSharedConfigsByProject(adminDB, respData.Project)
+		if err != nil {
+			http.Error(w, err.Error(), http.StatusInternalServerError)
+			return
+		}
+		for _, sharedConfig := range sharedConfigs {
+			details := SharedConfigDetails{SharedConfig: sharedConfig}
+			details.Versions, err = db.GetSharedConfigVersions(adminDB, sharedConfig)
+			if err != nil {
+				http.Error(w, err.Error(), http.StatusInternalServerError)
+				return
+			}
+			containers, err := db.GetContainersUsingSharedConfig(adminDB, respData.Project, sharedConfig.Name)
+			if err != nil {
+				http.Error(w, err.Error(), http.StatusInternalServerError)
+				return
+			}
+			for _, container := range containers {
+				details.UsedBy = append(details.UsedBy, container.Name)
+			}
+			respData.SharedConfigs = append(respData.SharedConfigs, details)
+		}
+
+		if err := tmpl.ExecuteTemplate(w, "base", respData); err != nil {
+			http.Error(w, err.Error(), http.StatusInternalServerError)
+		}
+	})
+
+	r.HandleFunc("GET /project/{projectName}/billing", func(w http.ResponseWriter, r *http.Request) {
+		projectName := r.PathValue("projectName")
+		fps := []string{
+			path.Join("frontend", "templates", "components", "base.html"),
+			path.Join("frontend", "templates", "pages", "project-billing.html"),
+			path.Join("frontend", "templates", "components", "nav.html"),
+		}
+		respData := IProjectBillingResponse{ProjectName: projectName, Policy: config.BillingPolicy}
+
+		tmpl, err := template.ParseFiles(fps...)
+		if err != nil {
+			http.Error(w, err.Error(), http.StatusInternalServerError)
+			return
+		}
+		value := r.Context().Value(types.Account{})
+		account := value.(types.Account)
+		respData.Account = account
+		respData.NavProps = NavProps{Account: account, PageTitle: projectName}
+
+		respData.Project, err = db.GetProjectByAccountAndName(adminDB, account, projectName)
+		if err != nil {
+			http.Error(w, err.Error(), http.StatusInternalServerError)
+			return
+		}
+		respData.Balance, err = db.GetProjectBalance(adminDB, respData.Project)
+		if err != nil {
+			http.Error(w, err.Error(), http.StatusInternalServerError)
+			return
+		}
+		respData.Entries, err = db.GetBillingEntriesByProject(adminDB, respData.Project, time.Now().AddDate(0, -1, 0))
+		if err != nil {
+			http.Error(w, err.Error(), http.StatusInternalServerError)
+			return
+		}
+
+		if err := tmpl.ExecuteTemplate(w, "base", respData); err != nil {
+			http.Error(w, err.Error(), http.StatusInternalServerError)
+		}
+	})
+
+	// Seeing the notifications here is what marks them as read
+	r.HandleFunc("GET /project/{projectName}/alerts", func(w http.ResponseWriter, r *http.Request) {
+		projectName := r.PathValue("projectName")
+		fps := []string{
+			path.Join("frontend", "templates", "components", "base.html"),
+			path.Join("frontend", "templates", "pages", "project-alerts.html"),
+			path.Join("frontend", "templates", "components", "nav.html"),
+		}
+		respData := IProjectAlertsResponse{ProjectName: projectName, Kinds: types.AlertRuleKinds, ChannelKinds: types.NotificationChannelKinds, Now: time.Now()}
+
+		tmpl, err := template.ParseFiles(fps...)
+		if err != nil {
+			http.Error(w, err.Error(), http.StatusInternalServerError)
+			return
+		}
+		value := r.Context().Value(types.Account{})
+		account := value.(types.Account)
+		respData.Account = account
+		respData.NavProps = NavProps{Account: account, PageTitle: projectName}
+
+		respData.Project, err = db.GetProjectByAccountAndName(adminDB, account, projectName)
+		if err != nil {
+			http.Error(w, err.Error(), http.StatusInternalServerError)
+			return
+		}
+		respData.Rules, err = db.GetAlertRulesByProject(adminDB, respData.Project)
+		if err != nil {
+			http.Error(w, err.Error(), http.StatusInternalServerError)
+			return
+		}
+		respData.Channels, err = db.GetNotificationChannelsByProject(adminDB, respData.Project)
+		if err != nil {
+			http.Error(w, err.Error(), http.StatusInternalServerError)
+			return
+		}
+		respData.ChannelNames = map[int64]string{}
+		for _, channel := range respData.Channels {
+			respData.ChannelNames[int64(channel.NotificationChannelID)] = channel.Name
+		}
+		respData.Firing, err = db.GetAlertFiringsByProject(adminDB, respData.Project)
+		if err != nil {
+			http.Error(w, err.Error(), http.StatusInternalServerError)
+			return
+		}
+		respData.Notifications, err = db.GetNotificationsByProject(adminDB, respData.Project, time.Now().AddDate(0, 0, -7))
+		if err != nil {
+			http.Error(w, err.Error(), http.StatusInternalServerError)
+			return
+		}
+		err = db.MarkNotificationsAsRead(adminDB, respData.Project)
+		if err != nil {
+			http.Error(w, err.Error(), http.StatusInternalServerError)
+			return
+		}
+
+		if err := tmpl.ExecuteTemplate(w, "base", respData); err != nil {
+			http.Error(w, err.Error(), http.StatusInternalServerError)
+		}
+	})
+
+	r.HandleFunc("POST /project/{projectName}/new-alert-rule", func(w http.ResponseWriter, r *http.Request) {
+		projectName := r.PathValue("projectName")
+		value := r.Context().Value(types.Account{})
+		account := value.(types.Account)
+		err := r.ParseForm()
+		if err != nil {
+			http.Error(w, err.Error(), http.StatusInternalServerError)
+			return
+		}
+
+		thisProject, err := db.GetProjectByAccountAndName(adminDB, account, projectName)
+		if err != nil {
+			http.Error(w, err.Error(), http.StatusInternalServerError)
+			return
+		}
+		channels, err := db.GetNotificationChannelsByProject(adminDB, thisProject)
+		if err != nil {
+			http.Error(w, err.Error(), http.StatusInternalServerError)
+			return
+		}
+
+		newRule := types.AlertRule{}
+		err = newRule.ParseFromHTTPForm(r, channels)
+		if err != nil {
+			http.Error(w, err.Error(), http.StatusBadRequest)
+			return
+		}
+		_, err = db.CreateAlertRuleForProject(adminDB, account, thisProject, newRule)
+		if err != nil {
+			http.Error(w, err.Error(), http.StatusInternalServerError)
+			return
+		}
+
+		http.Redirect(w, r, fmt.Sprintf("/project/%s/alerts", projectName), http.StatusSeeOther)
+	})
+
+	r.HandleFunc("POST /project/{projectName}/{ruleName}/silence-alert-rule", func(w http.ResponseWriter, r *http.Request) {
+		projectName := r.PathValue("projectName")
+		ruleName := r.PathValue("ruleName")
+		value := r.Context().Value(types.Account{})
+		account := value.(types.Account)
+
+		thisProject, err := db.GetProjectByAccountAndName(adminDB, account, projectName)
+		if err != nil {
+			http.Error(w, err.Error(), http.StatusInternalServerError)
+			return
+		}
+		thisRule, err := db.GetAlertRuleByProjectAndName(adminDB, thisProject, ruleName)
+		if err != nil {
+			http.Error(w, err.Error(), http.StatusNotFound)
+			return
+		}
+
+		var silencedUntil *time.Time
+		if minutes, _ := strconv.Atoi(r.FormValue("minutes")); minutes > 0 {
+			until := time.Now().Add(time.Duration(minutes) * time.Minute)
+			silencedUntil = &until
+		}
+		_, err = db.SilenceAlertRule(adminDB, thisRule, silencedUntil)
+		if err != nil {
+			http.Error(w, err.Error(), http.StatusInternalServerError)
+			return
+		}
+
+		http.Redirect(w, r, fmt.Sprintf("/project/%s/alerts", projectName), http.StatusSeeOther)
+	})
+
+	r.HandleFunc("POST /project/{projectName}/{ruleName}/delete-alert-rule", func(w http.ResponseWriter, r *http.Request) {
+		projectName := r.PathValue("projectName")
+		ruleName := r.PathValue("ruleName")
+		value := r.Context().Value(types.Account{})
+		account := value.(types.Account)
+
+		thisProject, err := db.GetProjectByAccountAndName(adminDB, account, projectName)
+		if err != nil {
+			http.Error(w, err.Error(), http.StatusInternalServerError)
+			return
+		}
+		thisRule, err := db.GetAlertRuleByProjectAndName(adminDB, thisProject, ruleName)
+		if err != nil {
+			http.Error(w, err.Error(), http.StatusNotFound)
+			return
+		}
+		err = db.DeleteAlertRule(adminDB, thisRule)
+		if err != nil {
+			http.Error(w, err.Error(), http.StatusInternalServerError)
+			return
+		}
+
+		http.Redirect(w, r, fmt.Sprintf("/project/%s/alerts", projectName), http.StatusSeeOther)
+	})
+
+	r.HandleFunc("POST /project/{projectName}/new-notification-channel", func(w http.ResponseWriter, r *http.Request) {
+		projectName := r.PathValue("projectName")
+		value := r.Context().Value(types.Account{})
+		account := value.(types.Account)
+
+		thisProject, err := db.GetProjectByAccountAndName(adminDB, account, projectName)
+		if err != nil {
+			http.Error(w, err.Error(), http.StatusInternalServerError)
+			return
+		}
+
+		newChannel := types.NotificationChannel{}
+		err = newChannel.ParseFromHTTPForm(r)
+		if err != nil {
+			http.Error(w, err.Error(), http.StatusBadRequest)
+			return
+		}
+		_, err = db.CreateNotificationChannelForProject(adminDB, thisProject, newChannel)
+		if err != nil {
+			http.Error(w, err.Error(), http.StatusInternalServerError)
+			return
+		}
+
+		http.Redirect(w, r, fmt.Sprintf("/project/%s/alerts", projectName), http.StatusSeeOther)
+	})
+
+	r.HandleFunc("POST /project/{projectName}/{channelName}/delete-notification-channel", func(w http.ResponseWriter, r *http.Request) {
+		projectName := r.PathValue("projectName")
+		channelName := r.PathValue("channelName")
+		value := r.Context().Value(types.Account{})
+		account := value.(types.Account)
+
+		thisProject, err := db.GetProjectByAccountAndName(adminDB, account, projectName)
+		if err != nil {
+			http.Error(w, err.Error(), http.StatusInternalServerError)
+			return
+		}
+		err = db.DeleteNotificationChannelByProjectAndName(adminDB, thisProject, channelName)
+		if err != nil {
+			http.Error(w, err.Error(), http.StatusNotFound)
+			return
+		}
+
+		http.Redirect(w, r, fmt.Sprintf("/project/%s/alerts", projectName), http.StatusSeeOther)
+	})
+
+	r.HandleFunc("GET /project/{projectName}/webhooks", func(w http.ResponseWriter, r *http.Request) {
+		projectName := r.PathValue("projectName")
+		fps := []string{
+			path.Join("frontend", "templates", "components", "base.html"),
+			path.Join("frontend", "templates", "pages", "project-webhooks.html"),
+			path.Join("frontend", "templates", "components", "nav.html"),
+		}
+		respData := IProjectWebhooksResponse{ProjectName: projectName, Events: types.WebhookEvents}
+
+		tmpl, err := template.ParseFiles(fps...)
+		if err != nil {
+			http.Error(w, err.Error(), http.StatusInternalServerError)
+			return
+		}
+		value := r.Context().Value(types.Account{})
+		account := value.(types.Account)
+		respData.Account = account
+		respData.NavProps = NavProps{Account: account, PageTitle: projectName}
+
+		respData.Project, err = db.GetProjectByAccountAndName(adminDB, account, projectName)
+		if err != nil {
+			http.Error(w, err.Error(), http.StatusInternalServerError)
+			return
+		}
+		respData.Webhooks, err = db.GetWebhookSubscriptionsByProject(adminDB, respData.Project)
+		if err != nil {
+			http.Error(w, err.Error(), http.StatusInternalServerError)
+			return
+		}
+		respData.Deliveries, err = db.GetWebhookDeliveriesByProject(adminDB, respData.Project, time.Now().AddDate(0, 0, -7))
+		if err != nil {
+			http.Error(w, err.Error(), http.StatusInternalServerError)
+			return
+		}
+
+		if err := tmpl.ExecuteTemplate(w, "base", respData); err != nil {
+			http.Error(w, err.Error(), http.StatusInternalServerError)
+		}
+	})
+
+	r.HandleFunc("GET /project/{projectName}/builds", func(w http.ResponseWriter, r *http.Request) {
+		projectName := r.PathValue("projectName")
+		fps := []string{
+			path.Join("frontend", "templates", "components", "base.html"),
+			path.Join("frontend", "templates", "pages", "project-builds.html"),
+			path.Join("frontend", "templates", "components", "nav.html"),
+		}
+		respData := IProjectBuildsResponse{ProjectName: projectName}
+
+		tmpl, err := template.ParseFiles(fps...)
+		if err != nil {
+			http.Error(w, err.Error(), http.StatusInternalServerError)
+			return
+		}
+		value := r.Context().Value(types.Account{})
+		account := value.(types.Account)
+		respData.Account = account
+		respData.NavProps = NavProps{Account: account, PageTitle: projectName}
+
+		respData.Project, err = db.GetProjectByAccountAndName(adminDB, account, projectName)
+		if err != nil {
+			http.Error(w, err.Error(), http.StatusInternalServerError)
+			return
+		}
+		respData.Builds, err = db.GetImageBuildsByProject(adminDB, respData.Project, time.Now().AddDate(0, 0, -7))
+		if err != nil {
+			http.Error(w, err.Error(), http.StatusInternalServerError)
+			return
+		}
+
+		if err := tmpl.ExecuteTemplate(w, "base", respData); err != nil {
+			http.Error(w, err.Error(), http.StatusInternalServerError)
+		}
+	})
+
+	r.HandleFunc("GET /project/{projectName}/build/{buildID}", func(w http.ResponseWriter, r *http.Request) {
+		projectName := r.PathValue("projectName")
+		fps := []string{
+			path.Join("frontend", "templates", "components", "base.html"),
+			path.Join("frontend", "templates", "pages", "project-build.html"),
+			path.Join("frontend", "templates", "components", "nav.html"),
+		}
+		respData := IProjectBuildResponse{ProjectName: projectName}
+
+		tmpl, err := template.ParseFiles(fps...)
+		if err != nil {
+			http.Error(w, err.Error(), http.StatusInternalServerError)
+			return
+		}
+		value := r.Context().Value(types.Account{})
+		account := value.(types.Account)
+		respData.Account = account
+		respData.NavProps = NavProps{Account: account, PageTitle: projectName}
+
+		respData.Project, err = db.GetProjectByAccountAndName(adminDB, account, projectName)
+		if err != nil {
+			http.Error(w, err.Error(), http.StatusInternalServerError)
+			return
+		}
+		buildID, err := strconv.Atoi(r.PathValue("buildID"))
+		if err != nil {
+			http.Error(w, "The build ID has to be a number", http.StatusBadRequest)
+			return
+		}
+		build, err := db.GetImageBuildByProjectAndID(adminDB, respData.Project, buildID)
+		if err != nil {
+			http.Error(w, err.Error(), http.StatusNotFound)
+			return
+		}
+		respData.Build = &build
+		if respData.Build.Logs != nil {
+			respData.Logs = *respData.Build.Logs
+		}
+		if respData.Build.ContainerClaimID != nil {
+			container, err := db.GetContainerByID(adminDB, *respData.Build.ContainerClaimID)
+			if err == nil && container.DeletedAt == nil {
+				respData.ContainerName = container.Name
+			}
+		}
+
+		if err := tmpl.ExecuteTemplate(w, "base", respData); err != nil {
+			http.Error(w, err.Error(), http.StatusInternalServerError)
+		}
+	})
+
+	// fetched by the build's page, which shows them as they come
+	r.HandleFunc("GET /project/{projectName}/build/{buildID}/logs", func(w http.ResponseWriter, r *http.Request) {
+		projectName := r.PathValue("projectName")
+		value := r.Context().Value(types.Account{})
+		account := value.(types.Account)
+		thisProject, err := db.GetProjectByAccountAndName(adminDB, account, projectName)
+		if err != nil {
+			http.Error(w, err.Error(), http.StatusInternalServerError)
+			return
+		}
+		buildID, err := strconv.Atoi(r.PathValue("buildID"))
+		if err != nil {
+			http.Error(w, "The build ID has to be a number", http.StatusBadRequest)
+			return
+		}
+		build, err := db.GetImageBuildByProjectAndID(adminDB, thisProject, buildID)
+		if err != nil {
+			http.Error(w, err.Error(), http.StatusNotFound)
+			return
+		}
+		imageBuilds.ServeLogs(log, w, r, zoneRegistry, thisProject, build)
+	})
+
+	// newCredential is one that was just made, whose password gets shown this once
+	serveRegistryPage := func(w http.ResponseWriter, r *http.Request, newCredential *types.RegistryCredential) {
+		projectName := r.PathValue("projectName")
+		fps := []string{
+			path.Join("frontend", "templates", "components", "base.html"),
+			path.Join("frontend", "templates", "pages", "project-registry.html"),
+			path.Join("frontend", "templates", "components", "nav.html"),
+		}
+		respData := IProjectRegistryResponse{ProjectName: projectName, Enabled: config.RegistryPolicy.Enabled(), NewCredential: newCredential}
+
+		tmpl, err := template.ParseFiles(fps...)
+		if err != nil {
+			http.Error(w, err.Error(), http.StatusInternalServerError)
+			return
+		}
+		value := r.Context().Value(types.Account{})
+		account := value.(types.Account)
+		respData.Account = account
+		respData.NavProps = NavProps{Account: account, PageTitle: projectName}
+
+		respData.Project, err = db.GetProjectByAccountAndName(adminDB, account, projectName)
+		if err != nil {
+			http.Error(w, err.Error(), http.StatusInternalServerError)
+			return
+		}
+		respData.RepositoryPrefixes = internalRegistry.RepositoryPrefixes(zoneRegistry.Zones(), respData.Project)
+		respData.Usage, err = internalRegistry.ProjectUsage(adminDB, config.RegistryPolicy, respData.Project)
+		if err != nil {
+			http.Error(w, err.Error(), http.StatusInternalServerError)
+			return
+		}
+		respData.Images, err = db.GetRegistryImagesByProject(adminDB, respData.Project)
+		if err != nil {
+			http.Error(w, err.Error(), http.StatusInternalServerError)
+			return
+		}
+		respData.Credentials, err = db.GetRegistryCredentialsByProject(adminDB, respData.Project)
+		if err != nil {
+			http.Error(w, err.Error(), http.StatusInternalServerError)
+			return
+		}
+		respData.PullCredentials, err = db.GetPullCredentialsByProject(adminDB, respData.Project)
+		if err != nil {
+			http.Error(w, err.Error(), http.StatusInternalServerError)
+			return
+		}
+
+		if err := tmpl.ExecuteTemplate(w, "base", respData); err != nil {
+			http.Error(w, err.Error(), http.StatusInternalServerError)
+		}
+	}
+
+	r.HandleFunc("GET /project/{projectName}/registry", func(w http.ResponseWriter, r *http.Request) {
+		serveRegistryPage(w, r, nil)
+	})
+
+	r.HandleFunc("POST /project/{projectName}/new-webhook", func(w http.ResponseWriter, r *http.Request) {
+		projectName := r.PathValue("projectName")
+		value := r.Context().Value(types.Account{})
+		account := value.(types.Account)
+		err := r.ParseForm()
+		if err != nil {
+			http.Error(w, err.Error(), http.StatusInternalServerError)
+			return
+		}
+
+		thisProject, err := db.GetProjectByAccountAndName(adminDB, account, projectName)
+		if err != nil {
+			http.Error(w, err.Error(), http.StatusInternalServerError)
+			return
+		}
+
+		newSubscription := types.WebhookSubscription{}
+		err = newSubscription.ParseFromHTTPForm(r)
+		if err != nil {
+			http.Error(w, err.Error(), http.StatusBadRequest)
+			return
+		}
+		_, err = db.CreateWebhookSubscriptionForProject(adminDB, thisProject, newSubscription)
+		if err != nil {
+			http.Error(w, err.Error(), http.StatusInternalServerError)
+			return
+		}
+
+		http.Redirect(w, r, fmt.Sprintf("/project/%s/webhooks", projectName), http.StatusSeeOther)
+	})
+
+	r.HandleFunc("POST /project/{projectName}/{webhookName}/delete-webhook", func(w http.ResponseWriter, r *http.Request) {
+		projectName := r.PathValue("projectName")
+		webhookName := r.PathValue("webhookName")
+		value := r.Context().Value(types.Account{})
+		account := value.(types.Account)
+
+		thisProject, err := db.GetProjectByAccountAndName(adminDB, account, projectName)
+		if err != nil {
+			http.Error(w, err.Error(), http.StatusInternalServerError)
+			return
+		}
+		err = db.DeleteWebhookSubscriptionByProjectAndName(adminDB, thisProject, webhookName)
+		if err != nil {
+			http.Error(w, err.Error(), http.StatusNotFound)
+			return
+		}
+
+		http.Redirect(w, r, fmt.Sprintf("/project/%s/webhooks", projectName), http.StatusSeeOther)
+	})
+
+	r.HandleFunc("POST /project/{projectName}/{deliveryID}/redeliver-webhook", func(w http.ResponseWriter, r *http.Request) {
+		projectName := r.PathValue("projectName")
+		value := r.Context().Value(types.Account{})
+		account := value.(types.Account)
+
+		thisProject, err := db.GetProjectByAccountAndName(adminDB, account, projectName)
+		if err != nil {
+			http.Error(w, err.Error(), http.StatusInternalServerError)
+			return
+		}
+		deliveryID, err := strconv.Atoi(r.PathValue("deliveryID"))
+		if err != nil {
+			http.Error(w, "The delivery ID has to be a number", http.StatusBadRequest)
+			return
+		}
+		thisDelivery, err := db.GetWebhookDeliveryByProjectAndID(adminDB, thisProject, deliveryID)
+		if err != nil {
+			http.Error(w, err.Error(), http.StatusNotFound)
+			return
+		}
+		_, err = db.RedeliverWebhookDelivery(adminDB, thisDelivery)
+		if err != nil {
+			http.Error(w, err.Error(), http.StatusConflict)
+			return
+		}
+
+		http.Redirect(w, r, fmt.Sprintf("/project/%s/webhooks", projectName), http.StatusSeeOther)
+	})
+
+	r.HandleFunc("POST /project/{projectName}/new-registry-credential", func(w http.ResponseWriter, r *http.Request) {
+		projectName := r.PathValue("projectName")
+		value := r.Context().Value(types.Account{})
+		account := value.(types.Account)
+		err := r.ParseForm()
+		if err != nil {
+			http.Error(w, err.Error(), http.StatusInternalServerError)
+			return
+		}
+
+		thisProject, err := db.GetProjectByAccountAndName(adminDB, account, projectName)
+		if err != nil {
+			http.Error(w, err.Error(), http.StatusInternalServerError)
+			return
+		}
+
+		newCredential := types.RegistryCredential{}
+		err = newCredential.ParseFromHTTPForm(r)
+		if err != nil {
+			http.Error(w, err.Error(), http.StatusBadRequest)
+			return
+		}
+		newCredential, err = db.CreateRegistryCredentialForProject(adminDB, config.CredentialsKey, thisProject, newCredential)
+		if err != nil {
+			http.Error(w, err.Error(), http.StatusInternalServerError)
+			return
+		}
+
+		// its password is only kept encrypted, so it's shown right away instead of redirecting
+		serveRegistryPage(w, r, &newCredential)
+	})
+
+	r.HandleFunc("POST /project/{projectName}/{credentialName}/delete-registry-credential", func(w http.ResponseWriter, r *http.Request) {
+		projectName := r.PathValue("projectName")
+		credentialName := r.PathValue("credentialName")
+		value := r.Context().Value(types.Account{})
+		account := value.(types.Account)
+
+		thisProject, err := db.GetProjectByAccountAndName(adminDB, account, projectName)
+		if err != nil {
+			http.Error(w, err.Error(), http.StatusInternalServerError)
+			return
+		}
+		credential := types.RegistryCredential{Name: credentialName}
+		if credential.IsManaged() {
+			http.Error(w, "The lcaas- credentials are what the project's own containers and builds log in with, so they can't be deleted", http.StatusBadRequest)
+			return
+		}
+		err = db.DeleteRegistryCredentialByProjectAndName(adminDB, thisProject, credentialName)
+		if err != nil {
+			http.Error(w, err.Error(), http.StatusNotFound)
+			return
+		}
+
+		http.Redirect(w, r, fmt.Sprintf("/project/%s/registry", projectName), http.StatusSeeOther)
+	})
+
+	r.HandleFunc("POST /project/{projectName}/new-pull-credential", func(w http.ResponseWriter, r *http.Request) {
+		projectName := r.PathValue("projectName")
+		value := r.Context().Value(types.Account{})
+		account := value.(types.Account)
+		err := r.ParseForm()
+		if err != nil {
+			http.Error(w, err.Error(), http.StatusInternalServerError)
+			return
+		}
+
+		thisProject, err := db.GetProjectByAccountAndName(adminDB, account, projectName)
+		if err != nil {
+			http.Error(w, err.Error(), http.StatusInternalServerError)
+			return
+		}
+
+		newCredential := types.PullCredential{}
+		err = newCredential.ParseFromHTTPForm(r, true)
+		if err != nil {
+			http.Error(w, err.Error(), http.StatusBadRequest)
+			return
+		}
+		err = registryOps.CheckLogin(newCredential.URL, newCredential.ImagePullSecret())
+		if err != nil {
+			http.Error(w, err.Error(), http.StatusBadRequest)
+			return
+		}
+		newCredential.ProjectID = thisProject.ProjectID
+		err = newCredential.Seal(config.CredentialsKey)
+		if err != nil {
+			http.Error(w, err.Error(), http.StatusInternalServerError)
+			return
+		}
+		_, err = db.CreatePullCredentialForProject(adminDB, thisProject, newCredential)
+		if err != nil {
+			http.Error(w, err.Error(), http.StatusInternalServerError)
+			return
+		}
+
+		http.Redirect(w, r, fmt.Sprintf("/project/%s/registry", projectName), http.StatusSeeOther)
+	})
+
+	r.HandleFunc("POST /project/{projectName}/{credentialName}/rotate-pull-credential", func(w http.ResponseWriter, r *http.Request) {
+		projectName := r.PathValue("projectName")
+		credentialName := r.PathValue("credentialName")
+		value := r.Context().Value(types.Account{})
+		account := value.(types.Account)
+		err := r.ParseForm()
+		if err != nil {
+			http.Error(w, err.Error(), http.StatusInternalServerError)
+			return
+		}
+
+		thisProject, err := db.GetProjectByAccountAndName(adminDB, account, projectName)
+		if err != nil {
+			http.Error(w, err.Error(), http.StatusInternalServerError)
+			return
+		}
+		credential, err := db.OpenPullCredentialByProjectAndName(adminDB, config.CredentialsKey, thisProject, credentialName)
+		if err != nil {
+			http.Error(w, err.Error(), http.StatusNotFound)
+			return
+		}
+
+		err = credential.ParseFromHTTPForm(r, false)
+		if err != nil {
+			http.Error(w, err.Error(), http.StatusBadRequest)
+			return
+		}
+		err = registryOps.CheckLogin(credential.URL, credential.ImagePullSecret())
+		if err != nil {
+			http.Error(w, err.Error(), http.StatusBadRequest)
+			return
+		}
+		err = credential.Seal(config.CredentialsKey)
+		if err != nil {
+			http.Error(w, err.Error(), http.StatusInternalServerError)
+			return
+		}
+		_, err = kubeOps.RotatePullCredential(log, adminDB, zoneRegistry.Zones(), thisProject, credential)
+		if err != nil {
+			http.Error(w, err.Error(), http.StatusInternalServerError)
+			return
+		}
+
+		http.Redirect(w, r, fmt.Sprintf("/project/%s/registry", projectName), http.StatusSeeOther)
+	})
+
+	r.HandleFunc("POST /project/{projectName}/{credentialName}/delete-pull-credential", func(w http.ResponseWriter, r *http.Request) {
+		projectName := r.PathValue("projectName")
+		credentialName := r.PathValue("credentialName")
+		value := r.Context().Value(types.Account{})
+		account := value.(types.Account)
+
+		thisProject, err := db.GetProjectByAccountAndName(adminDB, account, projectName)
+		if err != nil {
+			http.Error(w, err.Error(), http.StatusInternalServerError)
+			return
+		}
+		containers, err := db.GetContainersUsingPullCredential(adminDB, thisProject, credentialName)
+		if err != nil {
+			http.Error(w, err.Error(), http.StatusInternalServerError)
+			return
+		}
+		if len(containers) > 0 {
+			http.Error(w, fmt.Sprintf("%s is still used by %v container(s), delete them first", credentialName, len(containers)), http.StatusConflict)
+			return
+		}
+		err = db.DeletePullCredentialByProjectAndName(adminDB, thisProject, credentialName)
+		if err != nil {
+			http.Error(w, err.Error(), http.StatusNotFound)
+			return
+		}
+
+		http.Redirect(w, r, fmt.Sprintf("/project/%s/registry", projectName), http.StatusSeeOther)
+	})
+
+	r.HandleFunc("POST /project/{projectName}/new-shared-config", func(w http.ResponseWriter, r *http.Request) {
+		projectName := r.PathValue("projectName")
+		value := r.Context().Value(types.Account{})
+		account := value.(types.Account)
+		err := r.ParseForm()
+		if err != nil {
+			http.Error(w, err.Error(), http.StatusInternalServerError)
+			return
+		}
+
+		thisProject, err := db.GetProjectByAccountAndName(adminDB, account, projectName)
+		if err != nil {
+			http.Error(w, err.Error(), http.StatusInternalServerError)
+			return
+		}
+
+		values, _ := types.ParseSharedConfigValuesFromHTTPForm(r)
+		newSharedConfig := types.SharedConfig{
+			Name: r.FormValue("name"),
+			Kind: r.FormValue("kind"),
+		}
+
+		_, err = kubeOps.CreateSharedConfig(log, adminDB, zoneRegistry.Zones(), account, thisProject, newSharedConfig, values)
+		if err != nil {
+			http.Error(w, err.Error(), http.StatusInternalServerError)
+			return
+		}
+
+		http.Redirect(w, r, fmt.Sprintf("/project/%s/shared-configs", projectName), http.StatusSeeOther)
+	})
+
+	r.HandleFunc("POST /project/{projectName}/{sharedConfigName}/update-shared-config", func(w http.ResponseWriter, r *http.Request) {
+		projectName := r.PathValue("projectName")
+		sharedConfigName := r.PathValue("sharedConfigName")
+		value := r.Context().Value(types.Account{})
+		account := value.(types.Account)
+		err := r.ParseForm()
+		if err != nil {
+			http.Error(w, err.Error(), http.StatusInternalServerError)
+			return
+		}
+
+		thisProject, err := db.GetProjectByAccountAndName(adminDB, account, projectName)
+		if err != nil {
+			http.Error(w, err.Error(), http.StatusInternalServerError)
+			return
+		}
+		thisSharedConfig, err := db.GetSharedConfigByProjectAndName(adminDB, thisProject, sharedConfigName)
+		if err != nil {
+			http.Error(w, err.Error(), http.StatusInternalServerError)
+			return
+		}
+
+		changes, removedKeys := types.ParseSharedConfigValuesFromHTTPForm(r)
+		restartContainers := r.FormValue("restart-containers") == "on"
+
+		_, err = kubeOps.UpdateSharedConfig(log, adminDB, zoneRegistry.Zones(), account, thisProject, thisSharedConfig, changes, removedKeys, restartContainers)
+		if err != nil {
+			http.Error(w, err.Error(), http.StatusInternalServerError)
+			return
+		}
+
+		http.Redirect(w, r, fmt.Sprintf("/project/%s/shared-configs", projectName), http.StatusSeeOther)
+	})
+
+	r.HandleFunc("POST /project/{projectName}/{sharedConfigName}/delete-shared-config", func(w http.ResponseWriter, r *http.Request) {
+		projectName := r.PathValue("projectName")
+		sharedConfigName := r.PathValue("sharedConfigName")
+		value := r.Context().Value(types.Account{})
+		account := value.(types.Account)
+		thisProject, err := db.GetProjectByAccountAndName(adminDB, account, projectName)
+		if err != nil {
+			http.Error(w, err.Error(), http.StatusInternalServerError)
+			return
+		}
+		thisSharedConfig, err := db.GetSharedConfigByProjectAndName(adminDB, thisProject, sharedConfigName)
+		if err != nil {
+			http.Error(w, err.Error(), http.StatusInternalServerError)
+			return
+		}
+
+		containers, err := db.GetContainersUsingSharedConfig(adminDB, thisProject, sharedConfigName)
+		if err != nil {
+			http.Error(w, err.Error(), http.StatusInternalServerError)
+			return
+		}
+		if len(containers) > 0 {
+			http.Error(w, fmt.Sprintf("%s is still used by %v container(s), remove it from them first", sharedConfigName, len(containers)), http.StatusConflict)
+			return
+		}
+
+		err = kubeOps.DeleteSharedConfig(log, zoneRegistry.Zones(), thisProject, thisSharedConfig)
+		if err != nil {
+			http.Error(w, err.Error(), http.StatusInternalServerError)
+			return
+		}
+		err = db.DeleteSharedConfigByProjectAndName(adminDB, thisProject, sharedConfigName)
+		if err != nil {
+			http.Error(w, err.Error(), http.StatusInternalServerError)
+			return
+		}
+
+		http.Redirect(w, r, fmt.Sprintf("/project/%s/shared-configs", projectName), http.StatusSeeOther)
+	})
+
+	r.HandleFunc("POST /project/{projectName}/rotate-object-storage-credentials", func(w http.ResponseWriter, r *http.Request) {
+		projectName := r.PathValue("projectName")
+		value := r.Context().Value(types.Account{})
+		account := value.(types.Account)
+		err := r.ParseForm()
+		if err != nil {
+			http.Error(w, err.Error(), http.StatusInternalServerError)
+			return
+		}
+		objectStorageName := r.FormValue("object-storage-name")
+		thisProject, err := db.GetProjectByAccountAndName(adminDB, account, projectName)
+		if err != nil {
+			http.Error(w, err.Error(), http.StatusInternalServerError)
+			return
+		}
+
+		thisObjectStorage, err := db.GetObjectStorageByProjectAndName(adminDB, thisProject, objectStorageName)
+		if err != nil {
+			http.Error(w, err.Error(), http.StatusInternalServerError)
+			return
+		}
+
+		thisObjectStorage, oldCreds, err := seaweedOps.RotateBucketCredentials(log, adminDB, config.CredentialsKey, config.ObjectStorageConnections, thisObjectStorage)
+		if err != nil {
+			http.Error(w, err.Error(), http.StatusInternalServerError)
+			return
+		}
+
+		// hand the new keys to the containers bound to the bucket, and only then take the old ones away from them
+		go func() {
+			err := kubeOps.RefreshServiceBindings(log, adminDB, zoneRegistry.Zones(), config.CredentialsKey, thisProject)
+			if err != nil {
+				log.Error("Refreshing the bindings failed, so the bucket's old keys are kept", "bucket", thisObjectStorage.BucketName(), "err", err)
+				return
+			}
+			seaweedOps.DeleteBucketAccessKeys(log, config.ObjectStorageConnections, thisObjectStorage, oldCreds)
+		}()
+
+		http.Redirect(w, r, fmt.Sprintf("/project/%s/database", projectName), http.StatusSeeOther)
+	})
+
 	r.HandleFunc("POST /project/{projectName}/share-with-user", func(w http.ResponseWriter, r *http.Request) {
 		projectName := r.PathValue("projectName")
 		value := r.Context().Value(types.Account{})
@@ -1,6 +1,8 @@
 package frontend
 
 import (
+	"time"
+
 	"github.com/lu1a/lcaas/core-service/kubeOps"
 	"github.com/lu1a/lcaas/core-service/types"
 )
@@ -32,8 +34,11 @@ type IProjectResponse struct {
 	ProjectName string
 
 	Containers     []types.ContainerClaim
+	ZoneStatuses   map[int][]types.ContainerZoneStatus // by container claim ID
 	UserDBClaim    types.UserDBClaim
 	ObjectStorages []types.ObjectStorageClaim
+
+	UnreadNotifications int
 }
 
 type INewContainerResponse struct {
@@ -42,7 +47,13 @@ type INewContainerResponse struct {
 	Project     types.Project
 	ProjectName string
 
-	Zones []string
+	Zones           []string
+	SharedConfigs   []types.SharedConfig
+	PullCredentials []types.PullCredential
+
+	// services that can be bound to the container
+	HasUserDB      bool
+	ObjectStorages []types.ObjectStorageClaim
 }
 
 type IContainerLogsResponse struct {
@@ -54,6 +65,123 @@ type IContainerLogsResponse struct {
 	LatestLogsForZones []kubeOps.LogsForZone
 }
 
+type IContainerMetricsResponse struct {
+	Account     types.Account
+	NavProps    NavProps
+	ProjectName string
+
+	Container types.ContainerClaim
+	Range     string
+	Ranges    []string
+	Metrics   types.ContainerMetrics
+	Zones     []ZoneCharts
+}
+
+type IContainerHistoryResponse struct {
+	Account     types.Account
+	NavProps    NavProps
+	ProjectName string
+
+	Container types.ContainerClaim
+	Revisions []types.ContainerRevision
+
+	DeployHook    *types.DeployHook // nil if the container has none
+	DeployHookURL string
+
+	// only set when two revisions are being compared
+	DiffFrom int
+	DiffTo   int
+	Changes  []types.RevisionFieldChange
+}
+
+type IProjectSharedConfigsResponse struct {
+	Account     types.Account
+	NavProps    NavProps
+	Project     types.Project
+	ProjectName string
+
+	SharedConfigs []SharedConfigDetails
+}
+
+type IProjectBillingResponse struct {
+	Account     types.Account
+	NavProps    NavProps
+	Project     types.Project
+	ProjectName string
+
+	Balance float64
+	Entries []types.BillingEntry // the last month's, newest first
+	Policy  types.BillingPolicy
+}
+
+type IProjectAlertsResponse struct {
+	Account     types.Account
+	NavProps    NavProps
+	Project     types.Project
+	ProjectName string
+
+	Rules         []types.AlertRule
+	Channels      []types.NotificationChannel
+	ChannelNames  map[int64]string // by notification channel ID
+	Firing        []types.AlertFiring
+	Notifications []types.Notification // the last week's, newest first
+	Kinds         []string
+	ChannelKinds  []string
+	Now           time.Time
+}
+
+type IProjectWebhooksResponse struct {
+	Account     types.Account
+	NavProps    NavProps
+	Project     types.Project
+	ProjectName string
+
+	Webhooks   []types.WebhookSubscription
+	Deliveries []types.WebhookDelivery // the last week's, newest first
+	Events     []string
+}
+
+type IProjectBuildsResponse struct {
+	Account     types.Account
+	NavProps    NavProps
+	Project     types.Project
+	ProjectName string
+
+	Builds []types.ImageBuild // the last week's, newest first
+}
+
+type IProjectBuildResponse struct {
+	Account     types.Account
+	NavProps    NavProps
+	Project     types.Project
+	ProjectName string
+
+	Build         *types.ImageBuild
+	Logs          string // kept once it's done, until then they're fetched as they come
+	ContainerName string // what's deployed from it, if it's still around
+}
+
+type IProjectRegistryResponse struct {
+	Account     types.Account
+	NavProps    NavProps
+	Project     types.Project
+	ProjectName string
+
+	Enabled            bool // whether the zones' registries are run here at all
+	RepositoryPrefixes []string
+	Usage              []types.RegistryUsage
+	Images             []types.RegistryImage
+	Credentials        []types.RegistryCredential
+	NewCredential      *types.RegistryCredential // just made, with its password
+	PullCredentials    []types.PullCredential    // for other registries
+}
+
+type SharedConfigDetails struct {
+	SharedConfig types.SharedConfig
+	Versions     []types.SharedConfigVersion
+	UsedBy       []string // container names
+}
+
 type INewDBResponse struct {
 	Account     types.Account
 	NavProps    NavProps
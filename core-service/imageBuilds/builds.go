@@ -0,0 +1,167 @@
+package imageBuilds
+
+import (
+	"fmt"
+	"slices"
+	"strings"
+	"time"
+
+	"github.com/charmbracelet/log"
+	"github.com/jmoiron/sqlx"
+	"github.com/lu1a/lcaas/core-service/db"
+	"github.com/lu1a/lcaas/core-service/internalRegistry"
+	"github.com/lu1a/lcaas/core-service/kubeOps"
+	"github.com/lu1a/lcaas/core-service/registryOps"
+	"github.com/lu1a/lcaas/core-service/types"
+	"github.com/lu1a/lcaas/core-service/webhooks"
+)
+
+// Builds buildInput in one of the container's zones, pushes it to the registry of every one of them, and saves the
+// container as waiting on it. The Watcher deploys the container once the build's done, the same way a recreated one
+// gets deployed, which is why its env vars' secrets get created here already.
+func CreateContainerFromBuild(log log.Logger, adminDB *sqlx.DB, zoneRegistry *kubeOps.ZoneRegistry, config types.Config, account types.Account, project types.Project, container types.ContainerClaim, buildInput types.ImageBuild, baseURL string) (types.ContainerClaim, types.ImageBuild, error) {
+	if config.ImagePolicy.RequireSignature {
+		return container, buildInput, fmt.Errorf("Images built here aren't signed, and the image policy only lets signed ones run")
+	}
+	zones := zoneRegistry.Zones()
+	buildZone, err := pickBuildZone(zones, project, container)
+	if err != nil {
+		return container, buildInput, err
+	}
+
+	policy := config.BuildPolicy.WithDefaults()
+	buildInput.ZoneName = buildZone.Name
+	buildInput.PushZones = container.Zones
+	buildInput.ImageRef = fmt.Sprintf("%s/%s/%s", buildZone.RegistryHost, project.NamespaceName(), strings.ToLower(container.Name))
+	buildInput.ImageTag = "build-" + time.Now().UTC().Format("20060102150405")
+	buildInput.CPUMilliCores = policy.CPUMilliCores
+	buildInput.MemoryMB = policy.MemoryMB
+	err = registryOps.CheckImagePolicy(config.ImagePolicy, buildInput.ImageRef)
+	if err != nil {
+		return container, buildInput, err
+	}
+
+	container.ImageRef = buildInput.ImageRef
+	container.ImageTag = buildInput.ImageTag
+	container.ImageDigest = nil
+	container.ImagePullSecret = nil
+	container.PullCredentialName = nil
+	container.EnvVarNames = slices.DeleteFunc(container.EnvVarNames, func(name string) bool { return name == "image-pull-secret" })
+	// the project's own pull credential, if the zones' registries want one
+	container, err = internalRegistry.AttachPullSecret(adminDB, zones, config.RegistryPolicy, config.CredentialsKey, project, container)
+	if err != nil {
+		return container, buildInput, err
+	}
+	pushAuth, err := internalRegistry.PushAuth(adminDB, zones, config.RegistryPolicy, config.CredentialsKey, project, container.Zones)
+	if err != nil {
+		return container, buildInput, err
+	}
+
+	build, err := db.CreateImageBuildForProject(adminDB, account, project, buildInput)
+	if err != nil {
+		return container, buildInput, err
+	}
+	container, err = db.CreateContainerClaimForProject(adminDB, account, project, container)
+	if err != nil {
+		failBuild(log, adminDB, build, err)
+		return container, build, err
+	}
+	build, err = db.SetImageBuildContainer(adminDB, build, container)
+	if err != nil {
+		return container, build, err
+	}
+	err = db.SetContainerAsBuilding(adminDB, container)
+	if err != nil {
+		return container, build, err
+	}
+	container.Status = "building"
+
+	err = kubeOps.CreateEnvVarSecretsForContainer(log, zones, project, container)
+	if err == nil && container.ImagePullSecret != nil {
+		err = kubeOps.SaveImagePullSecretForContainer(zones, project, container)
+	}
+	if err == nil {
+		var destinations []string
+		for _, zone := range zones {
+			if slices.Contains(build.PushZones, zone.Name) {
+				destinations = append(destinations, build.DestinationInZone(zone))
+			}
+		}
+		err = kubeOps.CreateBuildJob(buildZone, project, build, policy, destinations, build.ContextURL(baseURL), pushAuth)
+	}
+	if err != nil {
+		failBuild(log, adminDB, build, err)
+		dberr := db.SetContainerAsErrorState(adminDB, container)
+		if dberr != nil {
+			log.Error(dberr.Error())
+		}
+		return container, build, err
+	}
+
+	build, err = db.SetImageBuildAsBuilding(adminDB, build)
+	if err != nil {
+		return container, build, err
+	}
+	return container, build, nil
+}
+
+// The first reachable zone of the container's that has a registry and may run the build. Kaniko runs as root, which
+// Pod Security only lets through in trusted projects, or in a zone whose sandboxed runtime the operator exempted.
+func pickBuildZone(zones []types.ContainerZone, project types.Project, container types.ContainerClaim) (types.ContainerZone, error) {
+	for _, zoneName := range container.Zones {
+		i := slices.IndexFunc(zones, func(zone types.ContainerZone) bool { return zone.Name == zoneName })
+		if i < 0 {
+			return types.ContainerZone{}, fmt.Errorf("There's no zone called %s", zoneName)
+		}
+		if zones[i].RegistryHost == "" {
+			return types.ContainerZone{}, fmt.Errorf("Zone %s has no registry to push builds to, so images can't be built for it", zoneName)
+		}
+	}
+
+	for _, zone := range zones {
+		if !slices.Contains(container.Zones, zone.Name) || zone.CheckReachable() != nil {
+			continue
+		}
+		if project.Trusted || zone.RuntimeClassName != "" {
+			return zone, nil
+		}
+	}
+	return types.ContainerZone{}, fmt.Errorf("None of the container's zones can run builds for this project right now: they need a sandboxed runtime class outside of trusted projects, and to be reachable")
+}
+
+// Gives the build's CPU and memory back, for builds that never got going
+func failBuild(log log.Logger, adminDB *sqlx.DB, build types.ImageBuild, cause error) {
+	buildError := cause.Error()
+	_, err := db.FinishImageBuild(adminDB, build, nil, &buildError, "")
+	if err != nil {
+		log.Error(err.Error())
+	}
+}
+
+// Deploys a container whose image just got built, pinned to what the build pushed
+func deployBuiltContainer(log log.Logger, adminDB *sqlx.DB, zoneRegistry *kubeOps.ZoneRegistry, policy types.ImagePolicy, registryPolicy types.RegistryPolicy, credentialsKey []byte, project types.Project, container types.ContainerClaim, digest string) error {
+	container, err := db.SetContainerImageDigest(adminDB, container, digest)
+	if err != nil {
+		return err
+	}
+	// for looking the image up, its secret was created along with the build
+	if container.HasImagePullSecret() {
+		container, err = internalRegistry.AttachPullSecret(adminDB, zoneRegistry.Zones(), registryPolicy, credentialsKey, project, container)
+		if err != nil {
+			return err
+		}
+	}
+	container, err = registryOps.VerifyImage(policy, container)
+	if err != nil {
+		dberr := db.SetContainerAsErrorState(adminDB, container)
+		if dberr != nil {
+			log.Error(dberr.Error())
+		}
+		webhooks.EmitContainer(log, adminDB, project, "container.error", container)
+		return err
+	}
+
+	// the secrets were created along with the build
+	container.EnvVars = container.RetainedEnvVars()
+	return kubeOps.CreateContainerFromClaim(log, adminDB, zoneRegistry.Zones(), credentialsKey, project, container, true)
+}
@@ -0,0 +1,37 @@
+package imageBuilds
+
+import (
+	"database/sql"
+	"net/http"
+
+	"github.com/charmbracelet/log"
+	"github.com/jmoiron/sqlx"
+	"github.com/lu1a/lcaas/core-service/db"
+)
+
+// Serves uploaded build contexts to the builds' jobs. The token is all the auth there is, and it stops working once
+// the build's done, so this sits in front of the auth middleware.
+func ContextRouter(log log.Logger, adminDB *sqlx.DB) *http.ServeMux {
+	r := http.NewServeMux()
+
+	r.HandleFunc("GET /build-context/{token}", func(w http.ResponseWriter, r *http.Request) {
+		build, err := db.GetImageBuildByContextToken(adminDB, r.PathValue("token"))
+		if err == sql.ErrNoRows {
+			http.Error(w, "There's no such build context", http.StatusNotFound)
+			return
+		}
+		if err != nil {
+			log.Error(err.Error())
+			http.Error(w, err.Error(), http.StatusInternalServerError)
+			return
+		}
+
+		w.Header().Set("Content-Type", "application/gzip")
+		_, err = w.Write(build.ContextTarball)
+		if err != nil {
+			log.Error("Sending the build context failed", "build", build.ImageBuildID, "err", err)
+		}
+	})
+
+	return r
+}
@@ -0,0 +1,48 @@
+package imageBuilds
+
+import (
+	"net/http"
+	"slices"
+
+	"github.com/charmbracelet/log"
+	"github.com/lu1a/lcaas/core-service/kubeOps"
+	"github.com/lu1a/lcaas/core-service/types"
+)
+
+// Writes out a build's logs as plain text: what was kept of them once it's done, or as they come while it's going,
+// until it's done or the client goes away
+func ServeLogs(log log.Logger, w http.ResponseWriter, r *http.Request, zoneRegistry *kubeOps.ZoneRegistry, project types.Project, build types.ImageBuild) {
+	w.Header().Set("Content-Type", "text/plain; charset=utf-8")
+	if build.IsFinished() {
+		if build.Logs != nil {
+			_, _ = w.Write([]byte(*build.Logs))
+		}
+		return
+	}
+
+	zones := zoneRegistry.Zones()
+	i := slices.IndexFunc(zones, func(zone types.ContainerZone) bool { return zone.Name == build.ZoneName })
+	if i < 0 {
+		http.Error(w, "The build's zone is gone", http.StatusNotFound)
+		return
+	}
+	w.Header().Set("X-Content-Type-Options", "nosniff") // otherwise browsers hold the first bit back to sniff it
+	err := kubeOps.StreamBuildLogs(r.Context(), zones[i], project, build, flushingWriter{http.NewResponseController(w), w})
+	if err != nil && r.Context().Err() == nil {
+		log.Warn("Streaming the image build's logs stopped", "build", build.ImageBuildID, "err", err)
+	}
+}
+
+// Sends every bit of the logs on as soon as it's written
+type flushingWriter struct {
+	controller *http.ResponseController
+	w          http.ResponseWriter
+}
+
+func (f flushingWriter) Write(p []byte) (int, error) {
+	n, err := f.w.Write(p)
+	if err != nil {
+		return n, err
+	}
+	return n, f.controller.Flush()
+}
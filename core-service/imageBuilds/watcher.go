@@ -0,0 +1,152 @@
+package imageBuilds
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"slices"
+	"time"
+
+	"github.com/charmbracelet/log"
+	"github.com/jmoiron/sqlx"
+	"github.com/lu1a/lcaas/core-service/db"
+	"github.com/lu1a/lcaas/core-service/kubeOps"
+	"github.com/lu1a/lcaas/core-service/metrics"
+	"github.com/lu1a/lcaas/core-service/types"
+	"github.com/lu1a/lcaas/core-service/webhooks"
+)
+
+const watchInterval = 10 * time.Second
+
+// Builds are started right away, so one that's still queued after this never got its job
+const queuedTimeout = 5 * time.Minute
+
+// Keeps an eye on the builds' jobs, saves how they went, and deploys the containers that were waiting on them
+type Watcher struct {
+	log          log.Logger
+	adminDB      *sqlx.DB
+	zoneRegistry *kubeOps.ZoneRegistry
+	policy       types.ImagePolicy
+	registry     types.RegistryPolicy
+	// for opening the project's registry credential, which the deployed container pulls with
+	credentialsKey []byte
+}
+
+func NewWatcher(log log.Logger, adminDB *sqlx.DB, zoneRegistry *kubeOps.ZoneRegistry, policy types.ImagePolicy, registry types.RegistryPolicy, credentialsKey []byte) *Watcher {
+	return &Watcher{log: log, adminDB: adminDB, zoneRegistry: zoneRegistry, policy: policy, registry: registry, credentialsKey: credentialsKey}
+}
+
+// Looks every watchInterval until ctx is done
+func (w *Watcher) Run(ctx context.Context) {
+	ticker := time.NewTicker(watchInterval)
+	defer ticker.Stop()
+	for {
+		select {
+		case <-ctx.Done():
+			return
+		case <-ticker.C:
+			metrics.WorkerRan("build_watcher", w.watch(ctx))
+		}
+	}
+}
+
+func (w *Watcher) watch(ctx context.Context) error {
+	builds, err := db.GetUnfinishedImageBuilds(w.adminDB)
+	if err != nil {
+		w.log.Error(err.Error())
+		return err
+	}
+
+	var errs []error
+	for _, build := range builds {
+		if ctx.Err() != nil {
+			break
+		}
+		err = w.watchBuild(build)
+		if err != nil {
+			w.log.Error("Watching the image build failed", "build", build.ImageBuildID, "err", err)
+			errs = append(errs, fmt.Errorf("image build %v: %w", build.ImageBuildID, err))
+		}
+	}
+	return errors.Join(errs...)
+}
+
+func (w *Watcher) watchBuild(build types.ImageBuild) error {
+	if build.Status == "queued" {
+		if time.Since(build.CreatedAt) < queuedTimeout {
+			return nil
+		}
+		failBuild(w.log, w.adminDB, build, fmt.Errorf("It never got started"))
+		return nil
+	}
+
+	project, err := db.GetProjectByID(w.adminDB, build.ProjectID)
+	if err != nil {
+		return err
+	}
+	i := slices.IndexFunc(w.zoneRegistry.Zones(), func(zone types.ContainerZone) bool { return zone.Name == build.ZoneName })
+	if i < 0 {
+		failBuild(w.log, w.adminDB, build, fmt.Errorf("Zone %s is gone", build.ZoneName))
+		return nil
+	}
+	zone := w.zoneRegistry.Zones()[i]
+
+	var container *types.ContainerClaim
+	if build.ContainerClaimID != nil {
+		claim, err := db.GetContainerByID(w.adminDB, *build.ContainerClaimID)
+		if err != nil {
+			return err
+		}
+		// deleted while it was building, so there's nothing left to build for
+		if claim.DeletedAt != nil || claim.Status != "building" {
+			err = kubeOps.DeleteBuildJob(zone, project, build)
+			if err != nil {
+				return err
+			}
+			failBuild(w.log, w.adminDB, build, fmt.Errorf("Its container was deleted"))
+			return nil
+		}
+		container = &claim
+	}
+
+	// a zone that's down for a bit doesn't fail the build, the job's deadline takes care of the ones that are stuck
+	finished, digest, failure, err := kubeOps.GetBuildJobResult(zone, project, build)
+	if err != nil || !finished {
+		return err
+	}
+	logs, err := kubeOps.GetBuildLogs(zone, project, build)
+	if err != nil {
+		w.log.Warn("Couldn't keep the image build's logs", "build", build.ImageBuildID, "err", err)
+	}
+
+	var digestPtr, failurePtr *string
+	if failure != "" {
+		failurePtr = &failure
+	} else {
+		digestPtr = &digest
+	}
+	build, err = db.FinishImageBuild(w.adminDB, build, digestPtr, failurePtr, logs)
+	if err != nil {
+		return err
+	}
+	if container == nil {
+		return nil
+	}
+
+	if failure != "" {
+		err = db.SetContainerAsErrorState(w.adminDB, *container)
+		if err != nil {
+			return err
+		}
+		webhooks.EmitContainer(w.log, w.adminDB, project, "container.error", *container)
+		return nil
+	}
+
+	go func() {
+		err := deployBuiltContainer(w.log, w.adminDB, w.zoneRegistry, w.policy, w.registry, w.credentialsKey, project, *container, digest)
+		if err != nil {
+			w.log.Error(err.Error())
+		}
+	}()
+	return nil
+}
@@ -0,0 +1,171 @@
+package internalRegistry
+
+import (
+	"crypto/sha256"
+	"encoding/hex"
+	"encoding/json"
+	"fmt"
+	"io"
+	"net/http"
+	"net/url"
+	"strings"
+	"time"
+
+	"github.com/lu1a/lcaas/core-service/types"
+)
+
+var httpClient = &http.Client{Timeout: 30 * time.Second}
+
+var manifestMediaTypes = []string{
+	"application/vnd.oci.image.index.v1+json",
+	"application/vnd.docker.distribution.manifest.list.v2+json",
+	"application/vnd.oci.image.manifest.v1+json",
+	"application/vnd.docker.distribution.manifest.v2+json",
+}
+
+// Talks to a zone's registry with tokens we sign for ourselves
+type registryClient struct {
+	policy types.RegistryPolicy
+	host   string
+}
+
+type manifest struct {
+	Config struct {
+		Size int64 `json:"size"`
+	} `json:"config"`
+	Layers []struct {
+		Size int64 `json:"size"`
+	} `json:"layers"`
+	Manifests []struct {
+		Digest string `json:"digest"`
+	} `json:"manifests"` // for indexes
+}
+
+func (c registryClient) request(method, path string, access []tokenAccess, accept []string) (*http.Response, error) {
+	token, _, err := signToken(c.policy, c.host, "lcaas-registry-manager", access)
+	if err != nil {
+		return nil, err
+	}
+	req, err := http.NewRequest(method, "https://"+c.host+path, nil)
+	if err != nil {
+		return nil, err
+	}
+	req.Header.Set("Authorization", "Bearer "+token)
+	if len(accept) > 0 {
+		req.Header.Set("Accept", strings.Join(accept, ", "))
+	}
+	return httpClient.Do(req)
+}
+
+func (c registryClient) getJSON(path string, access []tokenAccess, accept []string, out any) (http.Header, []byte, error) {
+	resp, err := c.request(http.MethodGet, path, access, accept)
+	if err != nil {
+		return nil, nil, err
+	}
+	defer resp.Body.Close()
+	body, err := io.ReadAll(io.LimitReader(resp.Body, 4<<20))
+	if err != nil {
+		return nil, nil, err
+	}
+	if resp.StatusCode != http.StatusOK {
+		return nil, nil, fmt.Errorf("%s %s answered %s", c.host, path, resp.Status)
+	}
+	return resp.Header, body, json.Unmarshal(body, out)
+}
+
+// Every repository in the registry, a page at a time
+func (c registryClient) repositories() (repositories []string, err error) {
+	access := []tokenAccess{{Type: "registry", Name: "catalog", Actions: []string{"*"}}}
+	path := "/v2/_catalog?n=1000"
+	for path != "" {
+		var catalog struct {
+			Repositories []string `json:"repositories"`
+		}
+		header, _, err := c.getJSON(path, access, nil, &catalog)
+		if err != nil {
+			return repositories, err
+		}
+		repositories = append(repositories, catalog.Repositories...)
+		path = nextPage(header.Get("Link"))
+	}
+	return repositories, nil
+}
+
+// ie. `</v2/_catalog?last=b&n=1000>; rel="next"`
+func nextPage(link string) string {
+	target, _, found := strings.Cut(link, ">")
+	if !found || !strings.Contains(link, `rel="next"`) {
+		return ""
+	}
+	return strings.TrimPrefix(target, "<")
+}
+
+// The repository's tagged manifests, with the tags on each and the size of everything they're made of
+func (c registryClient) images(repository string) (images []types.RegistryImage, err error) {
+	access := []tokenAccess{{Type: "repository", Name: repository, Actions: []string{"pull"}}}
+	var tagList struct {
+		Tags []string `json:"tags"`
+	}
+	_, _, err = c.getJSON("/v2/"+repository+"/tags/list", access, nil, &tagList)
+	if err != nil {
+		return images, err
+	}
+
+	byDigest := map[string]int{}
+	for _, tag := range tagList.Tags {
+		digest, size, err := c.manifestSize(repository, url.PathEscape(tag), access, true)
+		if err != nil {
+			return images, err
+		}
+		if i, seen := byDigest[digest]; seen {
+			images[i].Tags = append(images[i].Tags, tag)
+			continue
+		}
+		byDigest[digest] = len(images)
+		images = append(images, types.RegistryImage{Repository: repository, Digest: digest, Tags: []string{tag}, SizeBytes: size})
+	}
+	return images, nil
+}
+
+// A manifest's digest and the size of its config and layers, and of those of every platform's manifest in an index
+func (c registryClient) manifestSize(repository, reference string, access []tokenAccess, followIndex bool) (digest string, size int64, err error) {
+	var m manifest
+	header, body, err := c.getJSON("/v2/"+repository+"/manifests/"+reference, access, manifestMediaTypes, &m)
+	if err != nil {
+		return "", 0, err
+	}
+	digest = header.Get("Docker-Content-Digest")
+	if digest == "" {
+		sum := sha256.Sum256(body)
+		digest = "sha256:" + hex.EncodeToString(sum[:])
+	}
+
+	size = int64(len(body)) + m.Config.Size
+	for _, layer := range m.Layers {
+		size += layer.Size
+	}
+	if followIndex {
+		for _, platformManifest := range m.Manifests {
+			_, platformSize, err := c.manifestSize(repository, platformManifest.Digest, access, false)
+			if err != nil {
+				return "", 0, err
+			}
+			size += platformSize
+		}
+	}
+	return digest, size, nil
+}
+
+// Deletes the manifest along with all of its tags. Its blobs are left to the registry's garbage collector.
+func (c registryClient) deleteManifest(repository, digest string) error {
+	access := []tokenAccess{{Type: "repository", Name: repository, Actions: []string{"delete"}}}
+	resp, err := c.request(http.MethodDelete, "/v2/"+repository+"/manifests/"+digest, access, nil)
+	if err != nil {
+		return err
+	}
+	defer resp.Body.Close()
+	if resp.StatusCode != http.StatusAccepted && resp.StatusCode != http.StatusNotFound {
+		return fmt.Errorf("Deleting %s@%s from %s failed: %s", repository, digest, c.host, resp.Status)
+	}
+	return nil
+}
@@ -0,0 +1,200 @@
+package internalRegistry
+
+import (
+	"crypto/subtle"
+	"encoding/json"
+	"fmt"
+	"net/http"
+	"slices"
+	"strconv"
+	"strings"
+	"time"
+
+	"github.com/charmbracelet/log"
+	"github.com/jmoiron/sqlx"
+	"github.com/lu1a/lcaas/core-service/db"
+	"github.com/lu1a/lcaas/core-service/kubeOps"
+	"github.com/lu1a/lcaas/core-service/types"
+)
+
+type tokenResponse struct {
+	Token       string `json:"token"`
+	AccessToken string `json:"access_token"` // the same, for OAuth-minded clients
+	ExpiresIn   int    `json:"expires_in"`
+	IssuedAt    string `json:"issued_at"`
+}
+
+// Who's asking for a token: an account with its API token as the password, or one of a project's credentials
+type principal struct {
+	account    *types.Account
+	credential *types.RegistryCredential
+}
+
+// Serves the tokens the zones' registries send docker to get. It does its own auth, docker only knows Basic, so it
+// sits in front of the auth middleware.
+func TokenRouter(log log.Logger, adminDB *sqlx.DB, zoneRegistry *kubeOps.ZoneRegistry, policy types.RegistryPolicy, key []byte) *http.ServeMux {
+	r := http.NewServeMux()
+
+	// ie. docker login registry.fi-hel1.example.com -u anything -p $LCAAS_API_TOKEN
+	r.HandleFunc("GET /registry-token", func(w http.ResponseWriter, r *http.Request) {
+		service := r.URL.Query().Get("service")
+		zoneIndex := slices.IndexFunc(zoneRegistry.Zones(), func(zone types.ContainerZone) bool {
+			return zone.RegistryHost != "" && zone.RegistryHost == service
+		})
+		if zoneIndex < 0 {
+			http.Error(w, fmt.Sprintf("There's no registry called %q here", service), http.StatusBadRequest)
+			return
+		}
+		zone := zoneRegistry.Zones()[zoneIndex]
+
+		username, password, ok := r.BasicAuth()
+		if !ok {
+			w.Header().Set("WWW-Authenticate", `Basic realm="lcaas registry"`)
+			http.Error(w, "Log in with your API token as the password, or with one of your project's registry credentials", http.StatusUnauthorized)
+			return
+		}
+		who, err := authenticate(adminDB, key, username, password)
+		if err != nil {
+			w.Header().Set("WWW-Authenticate", `Basic realm="lcaas registry"`)
+			http.Error(w, err.Error(), http.StatusUnauthorized)
+			return
+		}
+
+		var access []tokenAccess
+		for _, scope := range r.URL.Query()["scope"] {
+			requested, ok := parseScope(scope)
+			if !ok {
+				continue
+			}
+			granted := grantedActions(log, adminDB, policy, zone, who, requested)
+			if len(granted) > 0 {
+				access = append(access, tokenAccess{Type: requested.Type, Name: requested.Name, Actions: granted})
+			}
+		}
+
+		token, issuedAt, err := signToken(policy, service, who.subject(), access)
+		if err != nil {
+			log.Error(err.Error())
+			http.Error(w, "Signing the token failed", http.StatusInternalServerError)
+			return
+		}
+		apiResponseJSON, err := json.Marshal(tokenResponse{Token: token, AccessToken: token, ExpiresIn: int(tokenLifetime.Seconds()), IssuedAt: issuedAt.UTC().Format(time.RFC3339)})
+		if err != nil {
+			http.Error(w, "Error encoding to JSON", http.StatusInternalServerError)
+			return
+		}
+
+		w.Header().Set("Content-Type", "application/json")
+		_, err = w.Write(apiResponseJSON)
+		if err != nil {
+			http.Error(w, "Error writing out JSON", http.StatusNotFound)
+		}
+	})
+
+	return r
+}
+
+func authenticate(adminDB *sqlx.DB, key []byte, username, password string) (principal, error) {
+	credential, err := db.GetRegistryCredentialByUsername(adminDB, key, username)
+	if err == nil {
+		if subtle.ConstantTimeCompare([]byte(credential.Password), []byte(password)) != 1 {
+			return principal{}, fmt.Errorf("Wrong password")
+		}
+		err = db.SetRegistryCredentialUsed(adminDB, credential)
+		if err != nil {
+			return principal{}, err
+		}
+		return principal{credential: &credential}, nil
+	}
+
+	account, err := db.GetAccountByAPIToken(adminDB, password)
+	if err != nil || account.AccountID == 0 {
+		return principal{}, fmt.Errorf("Not authorised, wrong token")
+	}
+	return principal{account: &account}, nil
+}
+
+func (p principal) subject() string {
+	if p.credential != nil {
+		return p.credential.Username
+	}
+	return fmt.Sprintf("account-%v", p.account.AccountID)
+}
+
+// ie. "repository:namespace-my-project-1/api:pull,push"
+func parseScope(scope string) (tokenAccess, bool) {
+	resourceType, rest, found := strings.Cut(scope, ":")
+	if !found {
+		return tokenAccess{}, false
+	}
+	i := strings.LastIndex(rest, ":")
+	if i < 0 {
+		return tokenAccess{}, false
+	}
+	return tokenAccess{Type: resourceType, Name: rest[:i], Actions: strings.Split(rest[i+1:], ",")}, true
+}
+
+// Only ever a project's own repositories, which are the ones under its namespace. Its credentials can pull, and
+// push if they're allowed to, and its members can do everything. Nobody gets to push while the project's over its
+// quota in the zone, or while it's suspended.
+func grantedActions(log log.Logger, adminDB *sqlx.DB, policy types.RegistryPolicy, zone types.ContainerZone, who principal, requested tokenAccess) (granted []string) {
+	if requested.Type != "repository" {
+		return nil
+	}
+	project, ok := projectOfRepository(adminDB, requested.Name)
+	if !ok {
+		return nil
+	}
+
+	var allowed []string
+	if who.credential != nil && who.credential.ProjectID == project.ProjectID {
+		allowed = []string{"pull"}
+		if who.credential.CanPush {
+			allowed = append(allowed, "push")
+		}
+	}
+	if who.account != nil {
+		_, err := db.GetProjectByAccountAndName(adminDB, *who.account, project.Name)
+		if err == nil {
+			allowed = []string{"pull", "push", "delete"}
+		}
+	}
+
+	if slices.Contains(allowed, "push") && slices.Contains(requested.Actions, "push") {
+		usedBytes, err := db.GetRegistryUsageInZone(adminDB, project, zone.Name)
+		if err != nil {
+			log.Error(err.Error())
+		}
+		if err != nil || usedBytes >= int64(policy.ProjectQuotaMB)<<20 || project.SuspendedAt != nil {
+			allowed = slices.DeleteFunc(allowed, func(action string) bool { return action == "push" })
+		}
+	}
+
+	for _, action := range requested.Actions {
+		if slices.Contains(allowed, action) {
+			granted = append(granted, action)
+		}
+	}
+	return granted
+}
+
+// Repositories are named after the namespace of the project they're for, ie. "namespace-my-project-1/api"
+func projectOfRepository(adminDB *sqlx.DB, repository string) (types.Project, bool) {
+	namespace, _, found := strings.Cut(repository, "/")
+	if !found {
+		return types.Project{}, false
+	}
+	i := strings.LastIndex(namespace, "-")
+	if i < 0 {
+		return types.Project{}, false
+	}
+	projectID, err := strconv.Atoi(namespace[i+1:])
+	if err != nil {
+		return types.Project{}, false
+	}
+	project, err := db.GetProjectByID(adminDB, projectID)
+	if err != nil || project.DeletedAt != nil || project.NamespaceName() != namespace {
+		return types.Project{}, false
+	}
+	return project, true
+}
@@ -0,0 +1,126 @@
+package internalRegistry
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"os"
+	"time"
+
+	"github.com/charmbracelet/log"
+	"github.com/jmoiron/sqlx"
+	"github.com/lu1a/lcaas/core-service/db"
+	"github.com/lu1a/lcaas/core-service/kubeOps"
+	"github.com/lu1a/lcaas/core-service/metrics"
+	"github.com/lu1a/lcaas/core-service/types"
+)
+
+const manageInterval = 15 * time.Minute
+
+// Keeps a registry running in every zone with a registry_host, keeps track of what every project has in them, and
+// deletes the images nothing uses anymore
+type Manager struct {
+	log          log.Logger
+	adminDB      *sqlx.DB
+	zoneRegistry *kubeOps.ZoneRegistry
+	policy       types.RegistryPolicy
+}
+
+func NewManager(log log.Logger, adminDB *sqlx.DB, zoneRegistry *kubeOps.ZoneRegistry, policy types.RegistryPolicy) *Manager {
+	return &Manager{log: log, adminDB: adminDB, zoneRegistry: zoneRegistry, policy: policy}
+}
+
+// Manages right away, and then every manageInterval until ctx is done
+func (m *Manager) Run(ctx context.Context) {
+	metrics.WorkerRan("registry_manager", m.manage(ctx))
+	ticker := time.NewTicker(manageInterval)
+	defer ticker.Stop()
+	for {
+		select {
+		case <-ctx.Done():
+			return
+		case <-ticker.C:
+			metrics.WorkerRan("registry_manager", m.manage(ctx))
+		}
+	}
+}
+
+func (m *Manager) manage(ctx context.Context) error {
+	// the registries get the certificate as it is, as long as it's one
+	_, err := loadTokenCertDER(m.policy)
+	if err != nil {
+		m.log.Error(err.Error())
+		return err
+	}
+	certPEM, err := os.ReadFile(m.policy.TokenCertPath)
+	if err != nil {
+		return err
+	}
+
+	var errs []error
+	for _, zone := range m.zoneRegistry.Zones() {
+		if ctx.Err() != nil {
+			break
+		}
+		// a zone that's down gets another go next round
+		if zone.RegistryHost == "" || zone.CheckReachable() != nil {
+			continue
+		}
+		err = m.manageZone(zone, certPEM)
+		if err != nil {
+			m.log.Error("Managing the zone's registry failed", "zone", zone.Name, "err", err)
+			errs = append(errs, fmt.Errorf("zone %s: %w", zone.Name, err))
+		}
+	}
+	return errors.Join(errs...)
+}
+
+func (m *Manager) manageZone(zone types.ContainerZone, certPEM []byte) error {
+	err := kubeOps.ApplyZoneRegistry(zone, m.policy, certPEM)
+	if err != nil {
+		return err
+	}
+
+	client := registryClient{policy: m.policy, host: zone.RegistryHost}
+	repositories, err := client.repositories()
+	if err != nil {
+		return fmt.Errorf("Listing the repositories failed: %w", err)
+	}
+	var seenRepositories []string
+	for _, repository := range repositories {
+		project, ok := projectOfRepository(m.adminDB, repository)
+		if !ok {
+			continue
+		}
+		images, err := client.images(repository)
+		if err != nil {
+			return fmt.Errorf("Listing the images in %s failed: %w", repository, err)
+		}
+		err = db.SaveRegistryRepositoryImages(m.adminDB, zone.Name, project.ProjectID, repository, images)
+		if err != nil {
+			return err
+		}
+		seenRepositories = append(seenRepositories, repository)
+	}
+	err = db.ForgetRegistryRepositories(m.adminDB, zone.Name, seenRepositories)
+	if err != nil {
+		return err
+	}
+
+	unreferenced, err := db.GetUnreferencedRegistryImages(m.adminDB, zone.Name, m.policy.GCGraceDays)
+	if err != nil {
+		return err
+	}
+	for _, image := range unreferenced {
+		err = client.deleteManifest(image.Repository, image.Digest)
+		if err != nil {
+			return err
+		}
+		err = db.SetRegistryImageDeleted(m.adminDB, image)
+		if err != nil {
+			return err
+		}
+		m.log.Info("Deleted an unused image", "zone", zone.Name, "image", image.Repository+"@"+image.Digest)
+	}
+	return nil
+}
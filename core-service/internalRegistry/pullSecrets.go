@@ -0,0 +1,51 @@
+package internalRegistry
+
+import (
+	"encoding/base64"
+	"encoding/json"
+	"slices"
+
+	"github.com/jmoiron/sqlx"
+	"github.com/lu1a/lcaas/core-service/db"
+	"github.com/lu1a/lcaas/core-service/types"
+)
+
+// Gives a container whose image is in one of the zones' registries the project's pull credential as its image pull
+// secret, instead of whatever was typed in, so that nobody has to paste credentials for their own images
+func AttachPullSecret(adminDB *sqlx.DB, zones []types.ContainerZone, policy types.RegistryPolicy, key []byte, project types.Project, container types.ContainerClaim) (types.ContainerClaim, error) {
+	host := container.InternalRegistryHost(zones)
+	if !policy.Enabled() || host == "" {
+		return container, nil
+	}
+	credential, err := db.GetOrCreateRegistryCredential(adminDB, key, project, types.RegistryPullCredentialName, false)
+	if err != nil {
+		return container, err
+	}
+	container.ImagePullSecret = &types.ImagePullSecret{URL: host, Username: credential.Username, Password: credential.Password}
+	container.PullCredentialName = nil
+	if !container.HasImagePullSecret() {
+		container.EnvVarNames = append(container.EnvVarNames, "image-pull-secret")
+	}
+	return container, nil
+}
+
+// The docker config Kaniko logs in to every zone in pushZones with, using the project's build credential
+func PushAuth(adminDB *sqlx.DB, zones []types.ContainerZone, policy types.RegistryPolicy, key []byte, project types.Project, pushZones []string) ([]byte, error) {
+	if !policy.Enabled() {
+		return nil, nil
+	}
+	credential, err := db.GetOrCreateRegistryCredential(adminDB, key, project, types.RegistryPushCredentialName, true)
+	if err != nil {
+		return nil, err
+	}
+	type auth struct {
+		Auth string `json:"auth"`
+	}
+	auths := map[string]auth{}
+	for _, zone := range zones {
+		if zone.RegistryHost != "" && slices.Contains(pushZones, zone.Name) {
+			auths[zone.RegistryHost] = auth{Auth: base64.StdEncoding.EncodeToString([]byte(credential.Username + ":" + credential.Password))}
+		}
+	}
+	return json.Marshal(map[string]any{"auths": auths})
+}
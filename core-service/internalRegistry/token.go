@@ -0,0 +1,126 @@
+package internalRegistry
+
+import (
+	"crypto/ecdsa"
+	"crypto/elliptic"
+	"crypto/rand"
+	"crypto/sha256"
+	"crypto/x509"
+	"encoding/base64"
+	"encoding/hex"
+	"encoding/json"
+	"encoding/pem"
+	"fmt"
+	"os"
+	"time"
+
+	"github.com/lu1a/lcaas/core-service/types"
+)
+
+const tokenLifetime = 5 * time.Minute
+
+// What a token lets its bearer do, ie. {"type": "repository", "name": "namespace-my-project-1/api", "actions": ["pull"]}
+type tokenAccess struct {
+	Type    string   `json:"type"`
+	Name    string   `json:"name"`
+	Actions []string `json:"actions"`
+}
+
+// Signs a JWT for the registry at service, the way distribution's token auth wants it: ES256 with the certificate in
+// x5c, which the registries have as their root bundle
+func signToken(policy types.RegistryPolicy, service string, subject string, access []tokenAccess) (token string, issuedAt time.Time, err error) {
+	key, certDER, err := loadTokenKey(policy)
+	if err != nil {
+		return "", issuedAt, err
+	}
+	if access == nil {
+		access = []tokenAccess{}
+	}
+
+	header, err := json.Marshal(map[string]any{
+		"typ": "JWT",
+		"alg": "ES256",
+		"x5c": []string{base64.StdEncoding.EncodeToString(certDER)},
+	})
+	if err != nil {
+		return "", issuedAt, err
+	}
+	jwtID := make([]byte, 16)
+	_, err = rand.Read(jwtID)
+	if err != nil {
+		return "", issuedAt, err
+	}
+	issuedAt = time.Now()
+	claims, err := json.Marshal(map[string]any{
+		"iss":    types.RegistryTokenIssuer,
+		"sub":    subject,
+		"aud":    service,
+		"exp":    issuedAt.Add(tokenLifetime).Unix(),
+		"nbf":    issuedAt.Add(-10 * time.Second).Unix(),
+		"iat":    issuedAt.Unix(),
+		"jti":    hex.EncodeToString(jwtID),
+		"access": access,
+	})
+	if err != nil {
+		return "", issuedAt, err
+	}
+
+	signingInput := base64.RawURLEncoding.EncodeToString(header) + "." + base64.RawURLEncoding.EncodeToString(claims)
+	hash := sha256.Sum256([]byte(signingInput))
+	r, s, err := ecdsa.Sign(rand.Reader, key, hash[:])
+	if err != nil {
+		return "", issuedAt, err
+	}
+	// JWS wants r and s as two fixed-size big-endian numbers, not DER
+	signature := make([]byte, 64)
+	r.FillBytes(signature[:32])
+	s.FillBytes(signature[32:])
+	return signingInput + "." + base64.RawURLEncoding.EncodeToString(signature), issuedAt, nil
+}
+
+func loadTokenKey(policy types.RegistryPolicy) (*ecdsa.PrivateKey, []byte, error) {
+	keyPEM, err := os.ReadFile(policy.TokenKeyPath)
+	if err != nil {
+		return nil, nil, fmt.Errorf("Reading the registry token key failed: %w", err)
+	}
+	block, _ := pem.Decode(keyPEM)
+	if block == nil {
+		return nil, nil, fmt.Errorf("The registry token key at %s isn't PEM", policy.TokenKeyPath)
+	}
+	var key *ecdsa.PrivateKey
+	if block.Type == "EC PRIVATE KEY" {
+		key, err = x509.ParseECPrivateKey(block.Bytes)
+	} else {
+		var parsedKey any
+		parsedKey, err = x509.ParsePKCS8PrivateKey(block.Bytes)
+		key, _ = parsedKey.(*ecdsa.PrivateKey)
+	}
+	if err != nil || key == nil || key.Curve != elliptic.P256() {
+		return nil, nil, fmt.Errorf("The registry token key at %s has to be an ECDSA P-256 key", policy.TokenKeyPath)
+	}
+
+	certDER, err := loadTokenCertDER(policy)
+	if err != nil {
+		return nil, nil, err
+	}
+	cert, err := x509.ParseCertificate(certDER)
+	if err != nil {
+		return nil, nil, fmt.Errorf("Reading the registry token certificate failed: %w", err)
+	}
+	if !key.PublicKey.Equal(cert.PublicKey) {
+		return nil, nil, fmt.Errorf("The registry token certificate at %s isn't for the key at %s", policy.TokenCertPath, policy.TokenKeyPath)
+	}
+	return key, certDER, nil
+}
+
+func loadTokenCertDER(policy types.RegistryPolicy) ([]byte, error) {
+	certPEM, err := os.ReadFile(policy.TokenCertPath)
+	if err != nil {
+		return nil, fmt.Errorf("Reading the registry token certificate failed: %w", err)
+	}
+	block, _ := pem.Decode(certPEM)
+	if block == nil || block.Type != "CERTIFICATE" {
+		return nil, fmt.Errorf("The registry token certificate at %s isn't a PEM certificate", policy.TokenCertPath)
+	}
+	return block.Bytes, nil
+}
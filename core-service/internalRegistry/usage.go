@@ -0,0 +1,26 @@
+package internalRegistry
+
+import (
+	"github.com/jmoiron/sqlx"
+	"github.com/lu1a/lcaas/core-service/db"
+	"github.com/lu1a/lcaas/core-service/types"
+)
+
+// Where the project's images go in each zone's registry, ie. "registry.fi-hel1.example.com/namespace-my-project-1/"
+func RepositoryPrefixes(zones []types.ContainerZone, project types.Project) (prefixes []string) {
+	for _, zone := range zones {
+		if zone.RegistryHost != "" {
+			prefixes = append(prefixes, zone.RegistryHost+"/"+project.NamespaceName()+"/")
+		}
+	}
+	return prefixes
+}
+
+// What the project keeps in each zone's registry, against what it may
+func ProjectUsage(adminDB *sqlx.DB, policy types.RegistryPolicy, project types.Project) ([]types.RegistryUsage, error) {
+	usages, err := db.GetRegistryUsageByProject(adminDB, project)
+	for i := range usages {
+		usages[i].QuotaMB = policy.ProjectQuotaMB
+	}
+	return usages, err
+}
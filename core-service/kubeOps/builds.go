@@ -0,0 +1,279 @@
+package kubeOps
+
+import (
+	"context"
+	"fmt"
+	"io"
+	"strconv"
+	"strings"
+	"time"
+
+	"github.com/lu1a/lcaas/core-service/types"
+	batchv1 "k8s.io/api/batch/v1"
+	apiv1 "k8s.io/api/core/v1"
+	"k8s.io/apimachinery/pkg/api/errors"
+	"k8s.io/apimachinery/pkg/api/resource"
+	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+)
+
+const (
+	imageBuildIDLabel = "lcaas/image-build-id"
+	// what's kept of a build's logs once it's done
+	maxBuildLogLines = 5000
+	maxBuildLogBytes = 1 << 20
+	// finished build jobs stick around this long, the build's logs and result are in the DB by then
+	buildJobTTLSeconds = 60 * 60
+)
+
+// Starts a Kaniko job for the build in the project's namespace in client's zone, pushing to every destination.
+// Kaniko runs as root, so outside of trusted projects it has to run in a sandboxed runtime that's exempt from the
+// namespace's Pod Security enforcement, see the "Builds" section of the README.
+func CreateBuildJob(client types.ContainerZone, project types.Project, build types.ImageBuild, policy types.BuildPolicy, destinations []string, contextURL string, registryAuth []byte) error {
+	err := client.CheckReachable()
+	if err != nil {
+		return err
+	}
+	err = CreateNamespaceForNewProject([]types.ContainerZone{client}, project)
+	if err != nil {
+		return err
+	}
+
+	args := []string{
+		"--context=" + build.KanikoContext(),
+		"--dockerfile=" + build.DockerfilePath,
+		"--digest-file=/dev/termination-log",
+	}
+	if build.ContextPath != "" {
+		args = append(args, "--context-sub-path="+build.ContextPath)
+	}
+	for _, destination := range destinations {
+		args = append(args, "--destination="+destination)
+	}
+
+	buildIDLabelValue := strconv.Itoa(build.ImageBuildID)
+	resources := apiv1.ResourceList{
+		apiv1.ResourceCPU:    resource.MustParse(fmt.Sprintf("%vm", build.CPUMilliCores)),
+		apiv1.ResourceMemory: resource.MustParse(fmt.Sprintf("%vMi", build.MemoryMB)),
+	}
+	job := &batchv1.Job{
+		ObjectMeta: metav1.ObjectMeta{
+			Name:   build.JobName(),
+			Labels: map[string]string{imageBuildIDLabel: buildIDLabelValue},
+		},
+		Spec: batchv1.JobSpec{
+			BackoffLimit:            int32Ptr(0),
+			ActiveDeadlineSeconds:   int64Ptr(int64(policy.TimeoutMinutes * 60)),
+			TTLSecondsAfterFinished: int32Ptr(buildJobTTLSeconds),
+			Template: apiv1.PodTemplateSpec{
+				ObjectMeta: metav1.ObjectMeta{
+					Labels: map[string]string{imageBuildIDLabel: buildIDLabelValue},
+				},
+				Spec: apiv1.PodSpec{
+					Containers: []apiv1.Container{{
+						Name:                     "kaniko",
+						Image:                    policy.KanikoImage,
+						Args:                     args,
+						TerminationMessagePolicy: apiv1.TerminationMessageFallbackToLogsOnError,
+						Resources:                apiv1.ResourceRequirements{Requests: resources, Limits: resources},
+						SecurityContext: &apiv1.SecurityContext{
+							SeccompProfile: &apiv1.SeccompProfile{
+								Type: apiv1.SeccompProfileTypeRuntimeDefault,
+							},
+						},
+					}},
+					RestartPolicy:                "Never",
+					AutomountServiceAccountToken: boolPtr(false),
+				},
+			},
+		},
+	}
+	if client.RuntimeClassName != "" {
+		job.Spec.Template.Spec.RuntimeClassName = &client.RuntimeClassName
+	}
+
+	// Kaniko can clone git repos itself, an uploaded tarball gets fetched from us first
+	if build.Source == "tarball" {
+		job.Spec.Template.Spec.Volumes = []apiv1.Volume{{
+			Name:         "workspace",
+			VolumeSource: apiv1.VolumeSource{EmptyDir: &apiv1.EmptyDirVolumeSource{}},
+		}}
+		workspaceMount := []apiv1.VolumeMount{{Name: "workspace", MountPath: "/workspace"}}
+		job.Spec.Template.Spec.InitContainers = []apiv1.Container{{
+			Name:         "fetch-context",
+			Image:        policy.FetcherImage,
+			Command:      []string{"wget", "-q", "-O", "/workspace/context.tar.gz", contextURL},
+			VolumeMounts: workspaceMount,
+			SecurityContext: &apiv1.SecurityContext{
+				SeccompProfile: &apiv1.SeccompProfile{
+					Type: apiv1.SeccompProfileTypeRuntimeDefault,
+				},
+			},
+		}}
+		job.Spec.Template.Spec.Containers[0].VolumeMounts = workspaceMount
+	}
+
+	// pushing to the zones' registries needs logging in to them
+	registryAuthSecretName := build.JobName() + "-registry"
+	if registryAuth != nil {
+		job.Spec.Template.Spec.Volumes = append(job.Spec.Template.Spec.Volumes, apiv1.Volume{
+			Name: "registry-auth",
+			VolumeSource: apiv1.VolumeSource{Secret: &apiv1.SecretVolumeSource{
+				SecretName: registryAuthSecretName,
+				Items:      []apiv1.KeyToPath{{Key: ".dockerconfigjson", Path: "config.json"}},
+			}},
+		})
+		job.Spec.Template.Spec.Containers[0].VolumeMounts = append(job.Spec.Template.Spec.Containers[0].VolumeMounts, apiv1.VolumeMount{
+			Name: "registry-auth", MountPath: "/kaniko/.docker", ReadOnly: true,
+		})
+	}
+
+	createdJob, err := client.ClientSet.BatchV1().Jobs(project.NamespaceName()).Create(context.Background(), job, metav1.CreateOptions{})
+	if err != nil {
+		return fmt.Errorf("Starting the job for image build %v in zone %s failed: %w", build.ImageBuildID, client.Name, err)
+	}
+	if registryAuth != nil {
+		// owned by the job so that it goes when the job does, the pod waits for it in the meantime
+		_, err = client.ClientSet.CoreV1().Secrets(project.NamespaceName()).Create(context.Background(), &apiv1.Secret{
+			ObjectMeta: metav1.ObjectMeta{
+				Name:   registryAuthSecretName,
+				Labels: map[string]string{imageBuildIDLabel: buildIDLabelValue},
+				OwnerReferences: []metav1.OwnerReference{{
+					APIVersion: "batch/v1",
+					Kind:       "Job",
+					Name:       createdJob.Name,
+					UID:        createdJob.UID,
+				}},
+			},
+			Type: apiv1.SecretTypeDockerConfigJson,
+			Data: map[string][]byte{".dockerconfigjson": registryAuth},
+		}, metav1.CreateOptions{})
+		if err != nil {
+			return fmt.Errorf("Saving the registry login for image build %v in zone %s failed: %w", build.ImageBuildID, client.Name, err)
+		}
+	}
+	return nil
+}
+
+// Whether the build's job is done, and if so either the digest it pushed or why it failed. A job that's gone is
+// a failed build too.
+func GetBuildJobResult(client types.ContainerZone, project types.Project, build types.ImageBuild) (finished bool, digest string, failure string, err error) {
+	err = client.CheckReachable()
+	if err != nil {
+		return false, "", "", err
+	}
+
+	job, err := client.ClientSet.BatchV1().Jobs(project.NamespaceName()).Get(context.Background(), build.JobName(), metav1.GetOptions{})
+	if errors.IsNotFound(err) {
+		return true, "", "Its job is gone", nil
+	}
+	if err != nil {
+		return false, "", "", err
+	}
+
+	for _, condition := range job.Status.Conditions {
+		if condition.Status != apiv1.ConditionTrue {
+			continue
+		}
+		switch condition.Type {
+		case batchv1.JobFailed:
+			if condition.Reason == "DeadlineExceeded" {
+				return true, "", fmt.Sprintf("It took longer than %v minutes", *job.Spec.ActiveDeadlineSeconds/60), nil
+			}
+			return true, "", "It failed, see its logs", nil
+		case batchv1.JobComplete:
+			pod, err := getBuildPod(client, project, build)
+			if err != nil {
+				return false, "", "", err
+			}
+			for _, containerStatus := range pod.Status.ContainerStatuses {
+				if terminated := containerStatus.State.Terminated; containerStatus.Name == "kaniko" && terminated != nil {
+					digest = strings.TrimSpace(terminated.Message)
+				}
+			}
+			if !strings.HasPrefix(digest, "sha256:") {
+				return true, "", "It didn't say what digest it pushed", nil
+			}
+			return true, digest, "", nil
+		}
+	}
+	return false, "", "", nil
+}
+
+func getBuildPod(client types.ContainerZone, project types.Project, build types.ImageBuild) (*apiv1.Pod, error) {
+	pods, err := client.ClientSet.CoreV1().Pods(project.NamespaceName()).List(context.Background(), metav1.ListOptions{
+		LabelSelector: fmt.Sprintf("%s=%v", imageBuildIDLabel, build.ImageBuildID),
+	})
+	if err != nil {
+		return nil, err
+	}
+	if len(pods.Items) == 0 {
+		return nil, fmt.Errorf("Image build %v has no pod (yet)", build.ImageBuildID)
+	}
+	return &pods.Items[0], nil
+}
+
+// The end of what the build's logged so far, to keep once it's done
+func GetBuildLogs(client types.ContainerZone, project types.Project, build types.ImageBuild) (string, error) {
+	logs := new(strings.Builder)
+	err := writeBuildLogs(context.Background(), client, project, build, logs, false)
+	if logs.Len() > maxBuildLogBytes {
+		return logs.String()[logs.Len()-maxBuildLogBytes:], err
+	}
+	return logs.String(), err
+}
+
+// Writes the build's logs to w as they come, until the build's done or ctx is
+func StreamBuildLogs(ctx context.Context, client types.ContainerZone, project types.Project, build types.ImageBuild, w io.Writer) error {
+	// the pod takes a moment to show up and get going
+	for {
+		pod, err := getBuildPod(client, project, build)
+		if err == nil && pod.Status.Phase != apiv1.PodPending {
+			break
+		}
+		select {
+		case <-ctx.Done():
+			return ctx.Err()
+		case <-time.After(2 * time.Second):
+		}
+	}
+	return writeBuildLogs(ctx, client, project, build, w, true)
+}
+
+func writeBuildLogs(ctx context.Context, client types.ContainerZone, project types.Project, build types.ImageBuild, w io.Writer, follow bool) error {
+	err := client.CheckReachable()
+	if err != nil {
+		return err
+	}
+	pod, err := getBuildPod(client, project, build)
+	if err != nil {
+		return err
+	}
+
+	options := &apiv1.PodLogOptions{Container: "kaniko", Follow: follow}
+	if !follow {
+		options.TailLines = int64Ptr(maxBuildLogLines)
+	}
+	podLogs, err := client.ClientSet.CoreV1().Pods(project.NamespaceName()).GetLogs(pod.Name, options).Stream(ctx)
+	if err != nil {
+		return err
+	}
+	defer podLogs.Close()
+
+	_, err = io.Copy(w, podLogs)
+	return err
+}
+
+func DeleteBuildJob(client types.ContainerZone, project types.Project, build types.ImageBuild) error {
+	err := client.CheckReachable()
+	if err != nil {
+		return err
+	}
+	deletePolicy := metav1.DeletePropagationBackground
+	err = client.ClientSet.BatchV1().Jobs(project.NamespaceName()).Delete(context.Background(), build.JobName(), metav1.DeleteOptions{
+		PropagationPolicy: &deletePolicy,
+	})
+	if err != nil && !errors.IsNotFound(err) {
+		return err
+	}
+	return nil
+}
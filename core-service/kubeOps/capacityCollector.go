@@ -0,0 +1,175 @@
+package kubeOps
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"strconv"
+	"sync"
+	"time"
+
+	"github.com/charmbracelet/log"
+	"github.com/jmoiron/sqlx"
+	"github.com/lu1a/lcaas/core-service/db"
+	"github.com/lu1a/lcaas/core-service/metrics"
+	"github.com/lu1a/lcaas/core-service/types"
+	apiv1 "k8s.io/api/core/v1"
+	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+)
+
+const (
+	capacityCollectionInterval = 5 * time.Minute
+	// on nodes that someone donated, set to the donor's account ID
+	donorAccountIDLabel = "lcaas/donor-account-id"
+)
+
+// Keeps container_zone.cpu_millicores and memory_mb in line with what the zones' nodes can actually take, so that
+// the fair share every account gets follows nodes being added or going down. Every reading also goes into the
+// zone's capacity history. Nodes labelled as donated get tracked along the way, for their donors' credit.
+type CapacityCollector struct {
+	log          log.Logger
+	adminDB      *sqlx.DB
+	zoneRegistry *ZoneRegistry
+	reserve      types.CapacityReserve
+}
+
+func NewCapacityCollector(log log.Logger, adminDB *sqlx.DB, zoneRegistry *ZoneRegistry, reserve types.CapacityReserve) *CapacityCollector {
+	return &CapacityCollector{log: log, adminDB: adminDB, zoneRegistry: zoneRegistry, reserve: reserve}
+}
+
+// Collects every capacityCollectionInterval until ctx is done. The first round waits one interval too, so that
+// the zones have had the time to connect.
+func (c *CapacityCollector) Run(ctx context.Context) {
+	ticker := time.NewTicker(capacityCollectionInterval)
+	defer ticker.Stop()
+	for {
+		select {
+		case <-ctx.Done():
+			return
+		case <-ticker.C:
+		}
+
+		c.collect(ctx)
+	}
+}
+
+func (c *CapacityCollector) collect(ctx context.Context) {
+	var wg sync.WaitGroup
+	var errsMu sync.Mutex
+	var errs []error
+	for _, zone := range c.zoneRegistry.Zones() {
+		// a zone we can't reach keeps its last known capacity instead of dropping to nothing
+		if zone.CheckReachable() != nil {
+			continue
+		}
+
+		wg.Add(1)
+		go func() {
+			defer wg.Done()
+			err := c.collectZone(ctx, zone)
+			if err != nil {
+				errsMu.Lock()
+				errs = append(errs, err)
+				errsMu.Unlock()
+			}
+		}()
+	}
+	wg.Wait()
+
+	// so that the snapshots handlers get have the new numbers too
+	err := c.zoneRegistry.Reload()
+	if err != nil {
+		c.log.Error("Reloading zones after collecting their capacity failed", "err", err)
+		errs = append(errs, err)
+	}
+	metrics.WorkerRan("capacity_collector", errors.Join(errs...))
+}
+
+func (c *CapacityCollector) collectZone(ctx context.Context, zone types.ContainerZone) error {
+	nodes, err := zone.ClientSet.CoreV1().Nodes().List(ctx, metav1.ListOptions{})
+	if err != nil {
+		err = fmt.Errorf("listing the nodes failed: %w", err)
+		c.log.Warn("Collecting zone capacity failed", "zone", zone.Name, "err", err)
+		return err
+	}
+
+	// zones with a capacity set by hand still get the readings kept in their history
+	capacity := zoneCapacityFromNodes(zone.Name, nodes.Items, c.reserve)
+	capacityErr := db.SaveContainerZoneCapacity(c.adminDB, capacity)
+	if capacityErr != nil {
+		c.log.Error(capacityErr.Error())
+	} else if zone.DiscoverCapacity && (capacity.CPUMilliCores != zone.CPUMilliCores || capacity.MemoryMB != zone.MemoryMB) {
+		c.log.Info("Zone capacity changed", "zone", zone.Name, "nodes", capacity.SchedulableNodeCount,
+			"cpu_millicores", capacity.CPUMilliCores, "memory_mb", capacity.MemoryMB)
+	}
+
+	newDonatedNodes, err := db.SyncDonatedNodes(c.adminDB, zone.Name, c.donatedNodesFromNodes(zone.Name, nodes.Items), capacityCollectionInterval)
+	if err != nil {
+		c.log.Error(err.Error())
+		return err
+	}
+	for _, donatedNode := range newDonatedNodes {
+		c.log.Info("Found a newly donated node, it needs approving", "zone", zone.Name, "node", donatedNode.NodeName, "account_id", donatedNode.AccountID)
+	}
+	return capacityErr
+}
+
+// Sums up the allocatable CPU and memory of the zone's nodes that can take pods right now, minus the reserve per node
+func zoneCapacityFromNodes(zoneName string, nodes []apiv1.Node, reserve types.CapacityReserve) (capacity types.ZoneCapacity) {
+	capacity.ZoneName = zoneName
+	capacity.NodeCount = len(nodes)
+	for _, node := range nodes {
+		if !isNodeSchedulable(node) {
+			continue
+		}
+		capacity.SchedulableNodeCount++
+		capacity.AllocatableCPUMilliCores += int(node.Status.Allocatable.Cpu().MilliValue())
+		capacity.AllocatableMemoryMB += int(node.Status.Allocatable.Memory().Value() / 1024 / 1024)
+	}
+
+	capacity.ReservedCPUMilliCores = min(capacity.SchedulableNodeCount*reserve.CPUMilliCoresPerNode, capacity.AllocatableCPUMilliCores)
+	capacity.ReservedMemoryMB = min(capacity.SchedulableNodeCount*reserve.MemoryMBPerNode, capacity.AllocatableMemoryMB)
+	capacity.CPUMilliCores = capacity.AllocatableCPUMilliCores - capacity.ReservedCPUMilliCores
+	capacity.MemoryMB = capacity.AllocatableMemoryMB - capacity.ReservedMemoryMB
+
+	return capacity
+}
+
+// The nodes labelled with the account that donated them. Their capacity counts as what they bring after the
+// reserve, same as for the zone.
+func (c *CapacityCollector) donatedNodesFromNodes(zoneName string, nodes []apiv1.Node) (donatedNodes []types.DonatedNode) {
+	for _, node := range nodes {
+		accountIDLabel, ok := node.Labels[donorAccountIDLabel]
+		if !ok {
+			continue
+		}
+		accountID, err := strconv.Atoi(accountIDLabel)
+		if err != nil {
+			c.log.Warn("Donated node has a broken donor label", "zone", zoneName, "node", node.Name, "label", accountIDLabel)
+			continue
+		}
+
+		donatedNodes = append(donatedNodes, types.DonatedNode{
+			ZoneName:      zoneName,
+			NodeName:      node.Name,
+			AccountID:     accountID,
+			Ready:         isNodeSchedulable(node),
+			CPUMilliCores: max(0, int(node.Status.Allocatable.Cpu().MilliValue())-c.reserve.CPUMilliCoresPerNode),
+			MemoryMB:      max(0, int(node.Status.Allocatable.Memory().Value()/1024/1024)-c.reserve.MemoryMBPerNode),
+		})
+	}
+	return donatedNodes
+}
+
+// Ready and not cordoned with kubectl
+func isNodeSchedulable(node apiv1.Node) bool {
+	if node.Spec.Unschedulable {
+		return false
+	}
+	for _, condition := range node.Status.Conditions {
+		if condition.Type == apiv1.NodeReady {
+			return condition.Status == apiv1.ConditionTrue
+		}
+	}
+	return false
+}
@@ -0,0 +1,222 @@
+package kubeOps
+
+import (
+	"context"
+	"encoding/json"
+	"fmt"
+	"math"
+	"net/http"
+	"net/url"
+	"regexp"
+	"slices"
+	"strconv"
+	"time"
+
+	"github.com/jmoiron/sqlx"
+	"github.com/lu1a/lcaas/core-service/db"
+	"github.com/lu1a/lcaas/core-service/types"
+)
+
+var prometheusClient = &http.Client{Timeout: 10 * time.Second}
+
+// What a container used in each of its zones over metricsRange. Zones with a Prometheus in prometheusURLs get asked
+// that, which also knows about throttling and network; the rest make do with the usage sampler's hourly rollups plus
+// a fresh sample from their metrics API. Whatever goes wrong in a zone ends up in its Error, so the others still show.
+func GetContainerMetrics(ctx context.Context, adminDB *sqlx.DB, kubeClients []types.ContainerZone, prometheusURLs map[string]string, p types.Project, c types.ContainerClaim, metricsRange types.MetricsRange) (containerMetrics types.ContainerMetrics) {
+	containerMetrics = types.ContainerMetrics{
+		ContainerName:          c.Name,
+		From:                   metricsRange.From,
+		To:                     metricsRange.To,
+		StepSeconds:            int(metricsRange.Step.Seconds()),
+		RequestedCPUMilliCores: c.CPUMilliCores,
+		RequestedMemoryMB:      c.MemoryMB,
+		Zones:                  []types.ContainerZoneMetrics{},
+	}
+
+	for _, client := range kubeClients {
+		if !slices.Contains(c.Zones, client.Name) {
+			continue
+		}
+
+		zoneMetrics := types.ContainerZoneMetrics{ZoneName: client.Name, OOMKills: []time.Time{}}
+		var err error
+		if prometheusURL, ok := prometheusURLs[client.Name]; ok {
+			zoneMetrics.Source = "prometheus"
+			err = queryPrometheus(ctx, prometheusURL, p, c, metricsRange, &zoneMetrics)
+		} else {
+			zoneMetrics.Source = "metrics-api"
+			err = getMetricsAPIUsage(ctx, adminDB, client, p, c, metricsRange, &zoneMetrics)
+		}
+		if err != nil {
+			zoneMetrics.Error = err.Error()
+		}
+
+		if client.CheckReachable() == nil {
+			err = getPodTerminations(client, p, c, &zoneMetrics)
+			if err != nil && zoneMetrics.Error == "" {
+				zoneMetrics.Error = err.Error()
+			}
+		}
+
+		zoneMetrics.Highlight(c.CPUMilliCores, c.MemoryMB)
+		containerMetrics.Zones = append(containerMetrics.Zones, zoneMetrics)
+	}
+
+	return containerMetrics
+}
+
+func podSelectorName(c types.ContainerClaim) string {
+	if c.IsRunOnce() {
+		return c.JobName()
+	}
+	return c.DeploymentName()
+}
+
+// Restarts and OOM kills, as far as the container statuses of the pods that are still around go
+func getPodTerminations(client types.ContainerZone, p types.Project, c types.ContainerClaim, zoneMetrics *types.ContainerZoneMetrics) error {
+	pods, err := ListContainerPods(client, p.NamespaceName(), podSelectorName(c))
+	if err != nil {
+		return err
+	}
+	for _, pod := range pods {
+		for _, containerStatus := range pod.Status.ContainerStatuses {
+			zoneMetrics.Restarts += int(containerStatus.RestartCount)
+			if terminated := containerStatus.State.Terminated; terminated != nil && terminated.Reason == "OOMKilled" {
+				zoneMetrics.OOMKills = append(zoneMetrics.OOMKills, terminated.FinishedAt.Time)
+			}
+			if terminated := containerStatus.LastTerminationState.Terminated; terminated != nil && terminated.Reason == "OOMKilled" {
+				zoneMetrics.OOMKills = append(zoneMetrics.OOMKills, terminated.FinishedAt.Time)
+			}
+		}
+	}
+	return nil
+}
+
+// The hourly rollups, with the metrics API's current sample on the end if the range goes up to about now
+func getMetricsAPIUsage(ctx context.Context, adminDB *sqlx.DB, client types.ContainerZone, p types.Project, c types.ContainerClaim, metricsRange types.MetricsRange, zoneMetrics *types.ContainerZoneMetrics) error {
+	rollups, err := db.GetContainerUsageRollups(adminDB, c, client.Name, metricsRange.From, metricsRange.To)
+	if err != nil {
+		return err
+	}
+	for _, rollup := range rollups {
+		if rollup.SampleCount == 0 {
+			continue
+		}
+		// in the middle of the hour it covers
+		at := rollup.Hour.Add(30 * time.Minute)
+		zoneMetrics.CPUMilliCores = append(zoneMetrics.CPUMilliCores, types.MetricPoint{Time: at, Value: float64(rollup.CPUMilliCoresSum) / float64(rollup.SampleCount)})
+		zoneMetrics.CPUMilliCoresPeak = append(zoneMetrics.CPUMilliCoresPeak, types.MetricPoint{Time: at, Value: float64(rollup.CPUMilliCoresMax)})
+		zoneMetrics.MemoryMB = append(zoneMetrics.MemoryMB, types.MetricPoint{Time: at, Value: float64(rollup.MemoryMBSum) / float64(rollup.SampleCount)})
+		zoneMetrics.MemoryMBPeak = append(zoneMetrics.MemoryMBPeak, types.MetricPoint{Time: at, Value: float64(rollup.MemoryMBMax)})
+	}
+
+	if time.Since(metricsRange.To) > usageSampleInterval {
+		return nil
+	}
+	if err := client.CheckReachable(); err != nil {
+		return err
+	}
+	samples, err := samplePods(ctx, client,
+		fmt.Sprintf("/apis/metrics.k8s.io/v1beta1/namespaces/%s/pods", p.NamespaceName()),
+		fmt.Sprintf("%s=%v", containerClaimIDLabel, c.ContainerClaimID),
+	)
+	if err != nil {
+		return err
+	}
+	now := time.Now()
+	for _, sample := range samples {
+		zoneMetrics.CPUMilliCores = append(zoneMetrics.CPUMilliCores, types.MetricPoint{Time: now, Value: float64(sample.CPUMilliCores)})
+		zoneMetrics.MemoryMB = append(zoneMetrics.MemoryMB, types.MetricPoint{Time: now, Value: float64(sample.MemoryMB)})
+	}
+	return nil
+}
+
+// Only what we read of a Prometheus range query's response
+type prometheusResponse struct {
+	Status string `json:"status"`
+	Error  string `json:"error"`
+	Data   struct {
+		Result []struct {
+			Values [][2]any `json:"values"` // [unix seconds, "value"]
+		} `json:"result"`
+	} `json:"data"`
+}
+
+// Asks the zone's Prometheus for the cAdvisor metrics of the container's pods, which the kubelet exposes
+func queryPrometheus(ctx context.Context, prometheusURL string, p types.Project, c types.ContainerClaim, metricsRange types.MetricsRange, zoneMetrics *types.ContainerZoneMetrics) (err error) {
+	// every pod of the deployment is named after it plus its replica set's and its own hash, a job's just its own. It has
+	// to stop right there, or e.g. deployment-api-1 would match deployment-api-12's pods too
+	podNameSuffix := "-[a-z0-9]+-[a-z0-9]+"
+	if c.IsRunOnce() {
+		podNameSuffix = "-[a-z0-9]+"
+	}
+	podSelector := fmt.Sprintf(`namespace=%q, pod=~%q`, p.NamespaceName(), regexp.QuoteMeta(podSelectorName(c))+podNameSuffix)
+	containerSelector := podSelector + `, container!="", container!="POD"`
+	// rates need a few scrapes in their window, and shouldn't skip over anything between the steps
+	window := fmt.Sprintf("%vs", int(max(5*time.Minute, metricsRange.Step).Seconds()))
+
+	queries := []struct {
+		query  string
+		points *[]types.MetricPoint
+	}{
+		{fmt.Sprintf(`sum(rate(container_cpu_usage_seconds_total{%s}[%s])) * 1000`, containerSelector, window), &zoneMetrics.CPUMilliCores},
+		{fmt.Sprintf(`sum(container_memory_working_set_bytes{%s}) / 1048576`, containerSelector), &zoneMetrics.MemoryMB},
+		{fmt.Sprintf(`sum(rate(container_cpu_cfs_throttled_periods_total{%[1]s}[%[2]s])) / sum(rate(container_cpu_cfs_periods_total{%[1]s}[%[2]s]))`, containerSelector, window), &zoneMetrics.CPUThrottledRatio},
+		// network is per pod, not per container
+		{fmt.Sprintf(`sum(rate(container_network_receive_bytes_total{%s}[%s]))`, podSelector, window), &zoneMetrics.NetworkReceiveBytesPerSecond},
+		{fmt.Sprintf(`sum(rate(container_network_transmit_bytes_total{%s}[%s]))`, podSelector, window), &zoneMetrics.NetworkTransmitBytesPerSecond},
+	}
+	for _, q := range queries {
+		*q.points, err = queryPrometheusRange(ctx, prometheusURL, q.query, metricsRange)
+		if err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+func queryPrometheusRange(ctx context.Context, prometheusURL string, query string, metricsRange types.MetricsRange) (points []types.MetricPoint, err error) {
+	params := url.Values{}
+	params.Set("query", query)
+	params.Set("start", strconv.FormatInt(metricsRange.From.Unix(), 10))
+	params.Set("end", strconv.FormatInt(metricsRange.To.Unix(), 10))
+	params.Set("step", strconv.Itoa(int(metricsRange.Step.Seconds())))
+
+	req, err := http.NewRequestWithContext(ctx, http.MethodGet, prometheusURL+"/api/v1/query_range?"+params.Encode(), nil)
+	if err != nil {
+		return points, err
+	}
+	resp, err := prometheusClient.Do(req)
+	if err != nil {
+		return points, fmt.Errorf("asking Prometheus failed: %w", err)
+	}
+	defer resp.Body.Close()
+
+	var promResp prometheusResponse
+	err = json.NewDecoder(resp.Body).Decode(&promResp)
+	if err != nil {
+		return points, fmt.Errorf("reading Prometheus' response failed (%s): %w", resp.Status, err)
+	}
+	if promResp.Status != "success" {
+		return points, fmt.Errorf("Prometheus couldn't run the query: %s", promResp.Error)
+	}
+
+	points = []types.MetricPoint{}
+	// it's all summed up, so there's one series at most
+	for _, result := range promResp.Data.Result {
+		for _, value := range result.Values {
+			unixSeconds, ok := value[0].(float64)
+			valueStr, ok2 := value[1].(string)
+			if !ok || !ok2 {
+				continue
+			}
+			v, err := strconv.ParseFloat(valueStr, 64)
+			// ie. the throttled ratio while the container wasn't running
+			if err != nil || math.IsNaN(v) || math.IsInf(v, 0) {
+				continue
+			}
+			points = append(points, types.MetricPoint{Time: time.Unix(0, int64(unixSeconds*1e9)), Value: v})
+		}
+	}
+	return points, nil
+}
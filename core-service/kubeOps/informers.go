@@ -0,0 +1,430 @@
+package kubeOps
+
+import (
+	"context"
+	"slices"
+	"strconv"
+	"strings"
+	"time"
+
+	"github.com/charmbracelet/log"
+	"github.com/jmoiron/sqlx"
+	"github.com/lu1a/lcaas/core-service/db"
+	"github.com/lu1a/lcaas/core-service/types"
+	appsv1 "k8s.io/api/apps/v1"
+	batchv1 "k8s.io/api/batch/v1"
+	apiv1 "k8s.io/api/core/v1"
+	"k8s.io/apimachinery/pkg/api/errors"
+	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+	"k8s.io/apimachinery/pkg/labels"
+	"k8s.io/client-go/informers"
+	"k8s.io/client-go/tools/cache"
+)
+
+// set on everything we create for a container, so that the informers can map events back to the claim
+const containerClaimIDLabel = "lcaas/container-claim-id"
+
+const informerResyncPeriod = 10 * time.Minute
+
+// Starts watching pods, deployments, jobs and services in a zone. The watches keep container_zone_status and the
+// claims' node IPs up to date, and the factory's listers let reads come from the cache once it has synced.
+// Only what's labelled as belonging to a container gets cached, not every object in the cluster. They stop when ctx is
+// done.
+func startZoneInformers(ctx context.Context, log log.Logger, adminDB *sqlx.DB, zone types.ContainerZone) (informers.SharedInformerFactory, error) {
+	err := labelUnlabelledContainers(ctx, log, zone)
+	if err != nil {
+		// they just stay out of the cache, so it's not worth not watching the rest over
+		log.Error("Labelling the zone's older containers failed", "zone", zone.Name, "err", err)
+	}
+
+	factory := informers.NewSharedInformerFactoryWithOptions(zone.ClientSet, informerResyncPeriod, informers.WithTweakListOptions(func(options *metav1.ListOptions) {
+		options.LabelSelector = containerClaimIDLabel
+	}))
+	zone.Informers = factory
+	watcher := zoneWatcher{log: log, adminDB: adminDB, zone: zone}
+
+	_, err = factory.Core().V1().Pods().Informer().AddEventHandler(cache.ResourceEventHandlerFuncs{
+		AddFunc:    func(obj interface{}) { watcher.onPodEvent(obj) },
+		UpdateFunc: func(_, obj interface{}) { watcher.onPodEvent(obj) },
+		DeleteFunc: func(obj interface{}) { watcher.onPodEvent(obj) },
+	})
+	if err != nil {
+		return nil, err
+	}
+	_, err = factory.Apps().V1().Deployments().Informer().AddEventHandler(cache.ResourceEventHandlerFuncs{
+		AddFunc:    func(obj interface{}) { watcher.onWorkloadEvent(obj) },
+		UpdateFunc: func(_, obj interface{}) { watcher.onWorkloadEvent(obj) },
+		DeleteFunc: func(obj interface{}) { watcher.onWorkloadEvent(obj) },
+	})
+	if err != nil {
+		return nil, err
+	}
+	_, err = factory.Batch().V1().Jobs().Informer().AddEventHandler(cache.ResourceEventHandlerFuncs{
+		AddFunc:    func(obj interface{}) { watcher.onWorkloadEvent(obj) },
+		UpdateFunc: func(_, obj interface{}) { watcher.onWorkloadEvent(obj) },
+		DeleteFunc: func(obj interface{}) { watcher.onWorkloadEvent(obj) },
+	})
+	if err != nil {
+		return nil, err
+	}
+	_, err = factory.Core().V1().Services().Informer().AddEventHandler(cache.ResourceEventHandlerFuncs{
+		AddFunc:    func(obj interface{}) { watcher.onServiceEvent(obj) },
+		UpdateFunc: func(_, obj interface{}) { watcher.onServiceEvent(obj) },
+	})
+	if err != nil {
+		return nil, err
+	}
+
+	factory.Start(ctx.Done())
+	return factory, nil
+}
+
+type zoneWatcher struct {
+	log     log.Logger
+	adminDB *sqlx.DB
+	zone    types.ContainerZone
+}
+
+func (w zoneWatcher) onPodEvent(obj interface{}) {
+	pod, ok := unwrapDeleted(obj).(*apiv1.Pod)
+	if !ok {
+		return
+	}
+	w.syncContainer(pod.Namespace, pod.Labels["name"], pod.Labels)
+}
+
+func (w zoneWatcher) onWorkloadEvent(obj interface{}) {
+	switch workload := unwrapDeleted(obj).(type) {
+	case *appsv1.Deployment:
+		w.syncContainer(workload.Namespace, workload.Name, workload.Labels)
+	case *batchv1.Job:
+		w.syncContainer(workload.Namespace, workload.Name, workload.Labels)
+	}
+}
+
+// services are created with the zone's default IP and only get pointed at the right node once we know it
+func (w zoneWatcher) onServiceEvent(obj interface{}) {
+	service, ok := unwrapDeleted(obj).(*apiv1.Service)
+	if !ok || service.Spec.Selector["app"] == "" {
+		return
+	}
+	w.syncContainer(service.Namespace, service.Spec.Selector["app"], service.Labels)
+}
+
+// Works out how the container behind selectorName (a deployment or job name) is doing in this zone and saves that
+func (w zoneWatcher) syncContainer(namespace, selectorName string, objLabels map[string]string) {
+	if !strings.HasPrefix(namespace, "namespace-") || selectorName == "" {
+		return
+	}
+	containerClaimID, ok := containerClaimIDFromLabels(objLabels, selectorName)
+	if !ok {
+		return
+	}
+
+	containerClaim, err := db.GetContainerByID(w.adminDB, containerClaimID)
+	if err != nil {
+		w.log.Debug("Ignoring event for unknown container", "zone", w.zone.Name, "name", selectorName, "err", err)
+		return
+	}
+	if containerClaim.DeletedAt != nil || !slices.Contains(containerClaim.Zones, w.zone.Name) {
+		return
+	}
+
+	pods, err := ListContainerPods(w.zone, namespace, selectorName)
+	if err != nil {
+		w.log.Error("Listing pods from the cache failed", "zone", w.zone.Name, "name", selectorName, "err", err)
+		return
+	}
+
+	zoneStatus := types.ContainerZoneStatus{
+		ContainerClaimID: containerClaimID,
+		ZoneName:         w.zone.Name,
+		PodPhase:         "Pending",
+	}
+	if pod := mostRelevantPod(pods); pod != nil {
+		zoneStatus.PodPhase = string(pod.Status.Phase)
+		zoneStatus.Ready = isPodReady(pod)
+		if pod.Status.HostIP != "" {
+			zoneStatus.NodeIP = &pod.Status.HostIP
+		}
+		for _, containerStatus := range pod.Status.ContainerStatuses {
+			zoneStatus.RestartCount += int(containerStatus.RestartCount)
+			if terminated := containerStatus.State.Terminated; terminated != nil && terminated.Reason != "" {
+				zoneStatus.LastTerminationReason = &terminated.Reason
+			} else if terminated := containerStatus.LastTerminationState.Terminated; terminated != nil && terminated.Reason != "" {
+				zoneStatus.LastTerminationReason = &terminated.Reason
+			}
+			if waiting := containerStatus.State.Waiting; waiting != nil && waiting.Reason != "" {
+				zoneStatus.WaitingReason = &waiting.Reason
+				if waiting.Message != "" {
+					zoneStatus.WaitingMessage = &waiting.Message
+				}
+			}
+		}
+		for _, pod := range pods {
+			if pod.DeletionTimestamp == nil && isPodReady(pod) {
+				zoneStatus.ReadyReplicas++
+			}
+		}
+	} else if !w.workloadExists(namespace, selectorName) {
+		zoneStatus.PodPhase = "Missing"
+	}
+
+	err = db.UpsertContainerZoneStatus(w.adminDB, zoneStatus)
+	if err != nil {
+		w.log.Error(err.Error())
+		return
+	}
+
+	if zoneStatus.NodeIP != nil {
+		err = w.syncServiceExternalIPs(namespace, selectorName, *zoneStatus.NodeIP)
+		if err != nil {
+			w.log.Error("Pointing services at the new node failed", "zone", w.zone.Name, "name", selectorName, "err", err)
+		}
+	}
+
+	err = syncContainerFromZoneStatuses(w.adminDB, containerClaim)
+	if err != nil {
+		w.log.Error(err.Error())
+	}
+}
+
+func (w zoneWatcher) workloadExists(namespace, selectorName string) bool {
+	_, err := w.zone.Informers.Apps().V1().Deployments().Lister().Deployments(namespace).Get(selectorName)
+	if err == nil {
+		return true
+	}
+	_, err = w.zone.Informers.Batch().V1().Jobs().Lister().Jobs(namespace).Get(selectorName)
+	return err == nil
+}
+
+func (w zoneWatcher) syncServiceExternalIPs(namespace, selectorName, nodeIP string) error {
+	services, err := w.zone.Informers.Core().V1().Services().Lister().Services(namespace).List(labels.Everything())
+	if err != nil {
+		return err
+	}
+
+	for _, service := range services {
+		if service.Spec.Selector["app"] != selectorName {
+			continue
+		}
+		if len(service.Spec.ExternalIPs) == 1 && service.Spec.ExternalIPs[0] == nodeIP {
+			continue
+		}
+
+		updated := service.DeepCopy()
+		updated.Spec.ExternalIPs = []string{nodeIP}
+		_, err = w.zone.ClientSet.CoreV1().Services(namespace).Update(context.Background(), updated, metav1.UpdateOptions{})
+		if err != nil && !errors.IsNotFound(err) {
+			return err
+		}
+		w.log.Info("Pointed service at new node", "zone", w.zone.Name, "service", service.Name, "nodeIP", nodeIP)
+	}
+	return nil
+}
+
+// Rolls the per-zone statuses up into the claim: its node IP is the first of its zones that has one, and its status
+// follows types.AggregateContainerStatus
+func syncContainerFromZoneStatuses(adminDB *sqlx.DB, containerClaim types.ContainerClaim) error {
+	zoneStatuses, err := db.GetContainerZoneStatuses(adminDB, containerClaim)
+	if err != nil {
+		return err
+	}
+
+	var nodeIP *string
+	for _, zone := range containerClaim.Zones {
+		for _, zoneStatus := range zoneStatuses {
+			if zoneStatus.ZoneName == zone && zoneStatus.NodeIP != nil && nodeIP == nil {
+				nodeIP = zoneStatus.NodeIP
+			}
+		}
+	}
+	if nodeIP != nil && (containerClaim.NodeIP == nil || *containerClaim.NodeIP != *nodeIP) {
+		err = db.SaveNodeIPOfContainerByID(adminDB, containerClaim.ContainerClaimID, *nodeIP)
+		if err != nil {
+			return err
+		}
+	}
+
+	status := types.AggregateContainerStatus(containerClaim.Zones, zoneStatuses)
+	if status == containerClaim.Status {
+		return nil
+	}
+	return db.SetContainerStatusFromZones(adminDB, containerClaim.ContainerClaimID, status)
+}
+
+// The pods of a deployment or job, from the informer cache if the zone has one
+func ListContainerPods(client types.ContainerZone, namespace, selectorName string) ([]*apiv1.Pod, error) {
+	if client.Informers != nil {
+		return client.Informers.Core().V1().Pods().Lister().Pods(namespace).List(labels.SelectorFromSet(labels.Set{"name": selectorName}))
+	}
+
+	podList, err := client.ClientSet.CoreV1().Pods(namespace).List(context.Background(), metav1.ListOptions{LabelSelector: "name=" + selectorName})
+	if err != nil {
+		return nil, err
+	}
+	pods := []*apiv1.Pod{}
+	for i := range podList.Items {
+		pods = append(pods, &podList.Items[i])
+	}
+	return pods, nil
+}
+
+// during a rollout there can be a few pods, so prefer a ready one, then the newest
+func mostRelevantPod(pods []*apiv1.Pod) *apiv1.Pod {
+	var best *apiv1.Pod
+	for _, pod := range pods {
+		if pod.DeletionTimestamp != nil {
+			continue
+		}
+		if best == nil {
+			best = pod
+			continue
+		}
+		if isPodReady(pod) != isPodReady(best) {
+			if isPodReady(pod) {
+				best = pod
+			}
+			continue
+		}
+		if pod.CreationTimestamp.After(best.CreationTimestamp.Time) {
+			best = pod
+		}
+	}
+	return best
+}
+
+func isPodReady(pod *apiv1.Pod) bool {
+	for _, condition := range pod.Status.Conditions {
+		if condition.Type == apiv1.PodReady {
+			return condition.Status == apiv1.ConditionTrue
+		}
+	}
+	return false
+}
+
+// Everything we create is named "<kind>-<container name>-<claim ID>", the label is just there to not have to rely on that
+func containerClaimIDFromLabels(objLabels map[string]string, selectorName string) (int, bool) {
+	if id, err := strconv.Atoi(objLabels[containerClaimIDLabel]); err == nil {
+		return id, true
+	}
+	lastDash := strings.LastIndex(selectorName, "-")
+	if lastDash == -1 {
+		return 0, false
+	}
+	id, err := strconv.Atoi(selectorName[lastDash+1:])
+	return id, err == nil
+}
+
+// Deployments, jobs, pods and services made before everything of a container got containerClaimIDLabel would never
+// get into the informers' caches, so they're given it here from the claim ID at the end of their names. Labelling a
+// deployment's pod template rolls it once, its current pods get labelled as well in the meantime. Jobs' pod templates
+// can't be changed, so only their pods are.
+func labelUnlabelledContainers(ctx context.Context, log log.Logger, zone types.ContainerZone) error {
+	unlabelled := metav1.ListOptions{LabelSelector: "!" + containerClaimIDLabel}
+	labelled := 0
+
+	deployments, err := zone.ClientSet.AppsV1().Deployments("").List(ctx, unlabelled)
+	if err != nil {
+		return err
+	}
+	for _, deployment := range deployments.Items {
+		claimIDLabelValue, ok := unlabelledContainerClaimID(deployment.Namespace, deployment.Name)
+		if !ok {
+			continue
+		}
+		updated := deployment.DeepCopy()
+		updated.Labels = withContainerClaimIDLabel(updated.Labels, claimIDLabelValue)
+		updated.Spec.Template.Labels = withContainerClaimIDLabel(updated.Spec.Template.Labels, claimIDLabelValue)
+		_, err = zone.ClientSet.AppsV1().Deployments(deployment.Namespace).Update(ctx, updated, metav1.UpdateOptions{})
+		if err != nil && !errors.IsNotFound(err) {
+			return err
+		}
+		labelled++
+	}
+
+	jobs, err := zone.ClientSet.BatchV1().Jobs("").List(ctx, unlabelled)
+	if err != nil {
+		return err
+	}
+	for _, job := range jobs.Items {
+		claimIDLabelValue, ok := unlabelledContainerClaimID(job.Namespace, job.Name)
+		if !ok {
+			continue
+		}
+		updated := job.DeepCopy()
+		updated.Labels = withContainerClaimIDLabel(updated.Labels, claimIDLabelValue)
+		_, err = zone.ClientSet.BatchV1().Jobs(job.Namespace).Update(ctx, updated, metav1.UpdateOptions{})
+		if err != nil && !errors.IsNotFound(err) {
+			return err
+		}
+		labelled++
+	}
+
+	pods, err := zone.ClientSet.CoreV1().Pods("").List(ctx, unlabelled)
+	if err != nil {
+		return err
+	}
+	for _, pod := range pods.Items {
+		claimIDLabelValue, ok := unlabelledContainerClaimID(pod.Namespace, pod.Labels["name"])
+		if !ok {
+			continue
+		}
+		updated := pod.DeepCopy()
+		updated.Labels = withContainerClaimIDLabel(updated.Labels, claimIDLabelValue)
+		_, err = zone.ClientSet.CoreV1().Pods(pod.Namespace).Update(ctx, updated, metav1.UpdateOptions{})
+		if err != nil && !errors.IsNotFound(err) {
+			return err
+		}
+	}
+
+	services, err := zone.ClientSet.CoreV1().Services("").List(ctx, unlabelled)
+	if err != nil {
+		return err
+	}
+	for _, service := range services.Items {
+		claimIDLabelValue, ok := unlabelledContainerClaimID(service.Namespace, service.Spec.Selector["app"])
+		if !ok {
+			continue
+		}
+		updated := service.DeepCopy()
+		updated.Labels = withContainerClaimIDLabel(updated.Labels, claimIDLabelValue)
+		_, err = zone.ClientSet.CoreV1().Services(service.Namespace).Update(ctx, updated, metav1.UpdateOptions{})
+		if err != nil && !errors.IsNotFound(err) {
+			return err
+		}
+	}
+
+	if labelled > 0 {
+		log.Info("Labelled the zone's older containers", "zone", zone.Name, "workloads", labelled)
+	}
+	return nil
+}
+
+// The claim ID of a container's deployment or job, by its name. Anything else in the projects' namespaces, ie. builds,
+// isn't a container's.
+func unlabelledContainerClaimID(namespace, selectorName string) (string, bool) {
+	if !strings.HasPrefix(namespace, "namespace-") || (!strings.HasPrefix(selectorName, "deployment-") && !strings.HasPrefix(selectorName, "job-")) {
+		return "", false
+	}
+	containerClaimID, ok := containerClaimIDFromLabels(nil, selectorName)
+	if !ok {
+		return "", false
+	}
+	return strconv.Itoa(containerClaimID), true
+}
+
+func withContainerClaimIDLabel(objLabels map[string]string, claimIDLabelValue string) map[string]string {
+	if objLabels == nil {
+		objLabels = map[string]string{}
+	}
+	objLabels[containerClaimIDLabel] = claimIDLabelValue
+	return objLabels
+}
+
+func unwrapDeleted(obj interface{}) interface{} {
+	if tombstone, ok := obj.(cache.DeletedFinalStateUnknown); ok {
+		return tombstone.Obj
+	}
+	return obj
+}
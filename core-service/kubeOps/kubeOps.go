@@ -2,19 +2,23 @@ package kubeOps
 
 import (
 	"context"
+	"crypto/sha256"
 	"encoding/base64"
-	"flag"
+	"encoding/hex"
+	"encoding/json"
 	"fmt"
 	"io"
-	"path/filepath"
 	"slices"
+	"strconv"
 	"strings"
-	"time"
 
 	"github.com/charmbracelet/log"
 	"github.com/jmoiron/sqlx"
 	"github.com/lu1a/lcaas/core-service/db"
+	"github.com/lu1a/lcaas/core-service/metrics"
+	"github.com/lu1a/lcaas/core-service/seaweedOps"
 	"github.com/lu1a/lcaas/core-service/types"
+	"github.com/lu1a/lcaas/core-service/webhooks"
 	appsv1 "k8s.io/api/apps/v1"
 	batchv1 "k8s.io/api/batch/v1"
 	apiv1 "k8s.io/api/core/v1"
@@ -23,8 +27,6 @@ import (
 	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
 	"k8s.io/apimachinery/pkg/util/intstr"
 	"k8s.io/client-go/kubernetes"
-	"k8s.io/client-go/tools/clientcmd"
-	"k8s.io/client-go/util/homedir"
 	//
 	// Uncomment to load all auth plugins
 	// _ "k8s.io/client-go/plugin/pkg/client/auth"
@@ -37,15 +39,26 @@ import (
 
 func CreateNamespaceForNewProject(kubeClients []types.ContainerZone, project types.Project) error {
 	for _, client := range kubeClients {
+		// it gets created there the next time something needs it
+		if client.CheckReachable() != nil {
+			continue
+		}
 		clientset := client.ClientSet
 		nsName := &apiv1.Namespace{
 			ObjectMeta: metav1.ObjectMeta{
-				Name: project.NamespaceName(),
+				Name:   project.NamespaceName(),
+				Labels: podSecurityLabels(project),
 			},
 		}
 		_, err := clientset.CoreV1().Namespaces().Create(context.Background(), nsName, metav1.CreateOptions{})
 		if err != nil && !strings.Contains(err.Error(), "already exists") {
 			return err
+		} else if err != nil {
+			// namespaces from before we labelled them, or whose project has since been (un)trusted
+			err = syncPodSecurityLabels(clientset, project)
+			if err != nil {
+				return err
+			}
 		}
 
 		defaultLimitRange := &apiv1.LimitRange{
@@ -75,13 +88,13 @@ func CreateNamespaceForNewProject(kubeClients []types.ContainerZone, project typ
 	return nil
 }
 
-func CreateContainerFromClaim(log log.Logger, adminDB *sqlx.DB, kubeClients []types.ContainerZone, project types.Project, containerClaim types.ContainerClaim, areWeRecreating bool) error {
+func CreateContainerFromClaim(log log.Logger, adminDB *sqlx.DB, kubeClients []types.ContainerZone, credentialsKey []byte, project types.Project, containerClaim types.ContainerClaim, areWeRecreating bool) error {
 	err := db.SetContainerAsActivating(adminDB, containerClaim)
 	if err != nil {
 		log.Error(err.Error())
 		return err
 	}
-	err = createKubeResourcesForContainer(log, adminDB, kubeClients, project, containerClaim, areWeRecreating)
+	err = createKubeResourcesForContainer(log, adminDB, kubeClients, credentialsKey, project, containerClaim, areWeRecreating)
 	if err != nil {
 		log.Error(err.Error())
 		dberr := db.SetContainerAsErrorState(adminDB, containerClaim)
@@ -89,6 +102,7 @@ func CreateContainerFromClaim(log log.Logger, adminDB *sqlx.DB, kubeClients []ty
 			log.Error(dberr.Error())
 			return dberr
 		}
+		webhooks.EmitContainer(log, adminDB, project, "container.error", containerClaim)
 		return err
 	}
 	err = db.SetContainerAsActive(adminDB, containerClaim)
@@ -96,21 +110,32 @@ func CreateContainerFromClaim(log log.Logger, adminDB *sqlx.DB, kubeClients []ty
 		log.Error(err.Error())
 		return err
 	}
+	webhooks.EmitContainer(log, adminDB, project, "container.active", containerClaim)
 
 	return nil
 }
 
-func createKubeResourcesForContainer(log log.Logger, adminDB *sqlx.DB, kubeClients []types.ContainerZone, project types.Project, containerClaim types.ContainerClaim, areWeRecreating bool) error {
+func createKubeResourcesForContainer(log log.Logger, adminDB *sqlx.DB, kubeClients []types.ContainerZone, credentialsKey []byte, project types.Project, containerClaim types.ContainerClaim, areWeRecreating bool) error {
 	namespace := project.NamespaceName()
+
+	// rather than leaving the container half-created when we get to a zone that's down
+	err := checkZonesReachable(kubeClients, containerClaim.Zones)
+	if err != nil {
+		return err
+	}
+
 	log.Debug("Creating namespace if not exists", "namespace", namespace)
-	err := CreateNamespaceForNewProject(kubeClients, project)
+	err = CreateNamespaceForNewProject(kubeClients, project)
 	if err != nil {
 		return err
 	}
 
 	addedResourcesToRollBack := []addedResourceToRollBack{}
 
-	podEnvVarSpec := []apiv1.EnvVar{}
+	sharedConfigEnvFromSpec, err := sharedConfigEnvFromSpec(adminDB, project, containerClaim)
+	if err != nil {
+		return err
+	}
 
 	for _, client := range kubeClients {
 		if !slices.Contains(containerClaim.Zones, client.Name) {
@@ -120,7 +145,9 @@ func createKubeResourcesForContainer(log log.Logger, adminDB *sqlx.DB, kubeClien
 		clientset := client.ClientSet
 		deploymentsClient := clientset.AppsV1().Deployments(namespace)
 
-		for _, envVar := range containerClaim.EnvVars {
+		// each zone is its own cluster, so the same secret names can hold zone-specific values
+		podEnvVarSpec := []apiv1.EnvVar{}
+		for _, envVar := range containerClaim.EnvVarsForZone(client.Name) {
 
 			if !areWeRecreating {
 				secret := &apiv1.Secret{
@@ -165,28 +192,55 @@ func createKubeResourcesForContainer(log log.Logger, adminDB *sqlx.DB, kubeClien
 			})
 		}
 
+		envFromSpec := slices.Clone(sharedConfigEnvFromSpec)
+		serviceBindingsHash := ""
+		if containerClaim.HasServiceBindings() {
+			bindingValues, err := serviceBindingValues(log, adminDB, kubeClients, credentialsKey, client, project, containerClaim)
+			if err != nil {
+				rollbackErr := rollBackCreation(log, kubeClients, addedResourcesToRollBack)
+				if rollbackErr != nil {
+					return rollbackErr
+				}
+				return err
+			}
+			created, err := applyServiceBindingSecret(clientset, namespace, containerClaim, bindingValues)
+			if err != nil {
+				rollbackErr := rollBackCreation(log, kubeClients, addedResourcesToRollBack)
+				if rollbackErr != nil {
+					return rollbackErr
+				}
+				return err
+			}
+			if created {
+				addedResourcesToRollBack = append(addedResourcesToRollBack, addedResourceToRollBack{
+					zone:         client.Name,
+					resourceType: "secret",
+					namespace:    namespace,
+					name:         containerClaim.ServiceBindingSecretName(),
+				})
+			}
+			serviceBindingsHash = hashServiceBindingValues(bindingValues)
+			envFromSpec = append(envFromSpec, apiv1.EnvFromSource{
+				SecretRef: &apiv1.SecretEnvSource{
+					LocalObjectReference: apiv1.LocalObjectReference{Name: containerClaim.ServiceBindingSecretName()},
+				},
+			})
+		}
+
 		createdImagePullSecret := false
 		// create image pull secret so that we can actually pull image from private repo
 		if containerClaim.ImagePullSecret != nil && containerClaim.ImagePullSecret.URL != "" && !areWeRecreating {
 			ipsName := containerClaim.EnvVarSpecName("image-pull-secret")
+			// the credentials for the zones' registries work on all of them, so each zone gets its own
+			registryURL := containerClaim.ImagePullSecretURLForZone(client, kubeClients)
 
-			var encodedFullCreds []byte
-			if (containerClaim.ImagePullSecret.Username == "" || containerClaim.ImagePullSecret.Password == "") && containerClaim.ImagePullSecret.Token != "" { // just gonna only be able to use the token
-				encodedFullCreds = []byte(fmt.Sprintf("{\"auths\":{\"%s\":{\"auth\":\"%s\"}}}", containerClaim.ImagePullSecret.URL, containerClaim.ImagePullSecret.Token))
-
-			} else if containerClaim.ImagePullSecret.Token == "" { // or, if username and password both exist but there's no token
-				encodedUserAndPass := base64.StdEncoding.EncodeToString([]byte(fmt.Sprintf("%s:%s", containerClaim.ImagePullSecret.Username, containerClaim.ImagePullSecret.Password)))
-				encodedFullCreds = []byte(fmt.Sprintf("{\"auths\":{\"%s\":{\"username\":\"%s\",\"password\":\"%s\",\"email\":\"%s\",\"auth\":\"%s\"}}}", containerClaim.ImagePullSecret.URL, containerClaim.ImagePullSecret.Username, containerClaim.ImagePullSecret.Password, containerClaim.ImagePullSecret.Email, encodedUserAndPass))
-
-			} else if containerClaim.ImagePullSecret.Token != "" { // or, if username and password both exist AND there's also a token
-				encodedFullCreds = []byte(fmt.Sprintf("{\"auths\":{\"%s\":{\"username\":\"%s\",\"password\":\"%s\",\"email\":\"%s\",\"auth\":\"%s\"}}}", containerClaim.ImagePullSecret.URL, containerClaim.ImagePullSecret.Username, containerClaim.ImagePullSecret.Password, containerClaim.ImagePullSecret.Email, containerClaim.ImagePullSecret.Token))
-
-			} else { // error out if there's some random case
+			encodedFullCreds, err := dockerConfigJSON(containerClaim.ImagePullSecret, registryURL)
+			if err != nil { // error out if there's some random case
 				rollbackErr := rollBackCreation(log, kubeClients, addedResourcesToRollBack)
 				if rollbackErr != nil {
 					return rollbackErr
 				}
-				return fmt.Errorf("Something went wrong when creating ImagePullSecret")
+				return err
 			}
 
 			imagePullSecret := &apiv1.Secret{
@@ -199,7 +253,7 @@ func createKubeResourcesForContainer(log log.Logger, adminDB *sqlx.DB, kubeClien
 				},
 			}
 
-			_, err := clientset.CoreV1().Secrets(namespace).Create(context.Background(), imagePullSecret, metav1.CreateOptions{})
+			_, err = clientset.CoreV1().Secrets(namespace).Create(context.Background(), imagePullSecret, metav1.CreateOptions{})
 			if err != nil && !strings.Contains(err.Error(), "already exists") {
 				rollbackErr := rollBackCreation(log, kubeClients, addedResourcesToRollBack)
 				if rollbackErr != nil {
@@ -213,11 +267,13 @@ func createKubeResourcesForContainer(log log.Logger, adminDB *sqlx.DB, kubeClien
 				namespace:    namespace,
 				name:         ipsName,
 			})
+			createdImagePullSecret = true
 		} else if containerClaim.ImagePullSecret != nil && areWeRecreating { // don't create any secrets if we're recreating, just use what already exists since we don't know the secret values anymore
 			createdImagePullSecret = true
 		}
 
 		containerSelectorName := ""
+		claimIDLabelValue := strconv.Itoa(containerClaim.ContainerClaimID)
 		var containerPorts []apiv1.ContainerPort
 		for _, targetPort := range containerClaim.TargetPorts {
 			containerPorts = append(containerPorts, apiv1.ContainerPort{
@@ -231,23 +287,27 @@ func createKubeResourcesForContainer(log log.Logger, adminDB *sqlx.DB, kubeClien
 			containerSelectorName = containerClaim.JobName()
 			job := &batchv1.Job{
 				ObjectMeta: metav1.ObjectMeta{
-					Name: containerSelectorName,
+					Name:   containerSelectorName,
+					Labels: map[string]string{containerClaimIDLabel: claimIDLabelValue},
 				},
 				Spec: batchv1.JobSpec{
 					Template: apiv1.PodTemplateSpec{
 						ObjectMeta: metav1.ObjectMeta{
 							Name: containerClaim.JobName(),
 							Labels: map[string]string{
-								"name": containerSelectorName,
+								"name":                containerSelectorName,
+								containerClaimIDLabel: claimIDLabelValue,
 							},
 						},
 						Spec: apiv1.PodSpec{
 							Containers: []apiv1.Container{
 								{
-									Name:  containerSelectorName,
-									Image: containerClaim.WholeImageWithTag(),
-									Env:   podEnvVarSpec,
-									Ports: containerPorts,
+									Name:            containerSelectorName,
+									Image:           containerClaim.PinnedImageForZone(client, kubeClients),
+									Env:             podEnvVarSpec,
+									EnvFrom:         envFromSpec,
+									Ports:           containerPorts,
+									SecurityContext: containerSecurityContext(containerClaim),
 
 									Resources: apiv1.ResourceRequirements{
 										Requests: apiv1.ResourceList{
@@ -261,7 +321,8 @@ func createKubeResourcesForContainer(log log.Logger, adminDB *sqlx.DB, kubeClien
 									},
 								},
 							},
-							RestartPolicy: "Never",
+							RestartPolicy:   "Never",
+							SecurityContext: podSecurityContext(containerClaim),
 						},
 					},
 				},
@@ -269,6 +330,7 @@ func createKubeResourcesForContainer(log log.Logger, adminDB *sqlx.DB, kubeClien
 			if containerClaim.Command != nil {
 				job.Spec.Template.Spec.Containers[0].Command = containerClaim.Command
 			}
+			hardenPodSpec(&job.Spec.Template.Spec, client, containerClaim)
 			if createdImagePullSecret {
 				job.Spec.Template.Spec.ImagePullSecrets = []apiv1.LocalObjectReference{{
 					Name: containerClaim.EnvVarSpecName("image-pull-secret"),
@@ -284,7 +346,8 @@ func createKubeResourcesForContainer(log log.Logger, adminDB *sqlx.DB, kubeClien
 			containerSelectorName = containerClaim.DeploymentName()
 			deployment := &appsv1.Deployment{
 				ObjectMeta: metav1.ObjectMeta{
-					Name: containerSelectorName,
+					Name:   containerSelectorName,
+					Labels: map[string]string{containerClaimIDLabel: claimIDLabelValue},
 				},
 				Spec: appsv1.DeploymentSpec{
 					Replicas: int32Ptr(1),
@@ -297,17 +360,20 @@ func createKubeResourcesForContainer(log log.Logger, adminDB *sqlx.DB, kubeClien
 						ObjectMeta: metav1.ObjectMeta{
 							Name: containerSelectorName,
 							Labels: map[string]string{
-								"app":  containerSelectorName,
-								"name": containerSelectorName,
+								"app":                 containerSelectorName,
+								"name":                containerSelectorName,
+								containerClaimIDLabel: claimIDLabelValue,
 							},
 						},
 						Spec: apiv1.PodSpec{
 							Containers: []apiv1.Container{
 								{
-									Name:  containerSelectorName,
-									Image: containerClaim.WholeImageWithTag(),
-									Env:   podEnvVarSpec,
-									Ports: containerPorts,
+									Name:            containerSelectorName,
+									Image:           containerClaim.PinnedImageForZone(client, kubeClients),
+									Env:             podEnvVarSpec,
+									EnvFrom:         envFromSpec,
+									Ports:           containerPorts,
+									SecurityContext: containerSecurityContext(containerClaim),
 
 									Resources: apiv1.ResourceRequirements{
 										Requests: apiv1.ResourceList{
@@ -321,6 +387,7 @@ func createKubeResourcesForContainer(log log.Logger, adminDB *sqlx.DB, kubeClien
 									},
 								},
 							},
+							SecurityContext: podSecurityContext(containerClaim),
 						},
 					},
 				},
@@ -328,6 +395,12 @@ func createKubeResourcesForContainer(log log.Logger, adminDB *sqlx.DB, kubeClien
 			if containerClaim.Command != nil {
 				deployment.Spec.Template.Spec.Containers[0].Command = containerClaim.Command
 			}
+			hardenPodSpec(&deployment.Spec.Template.Spec, client, containerClaim)
+			if serviceBindingsHash != "" {
+				deployment.Spec.Template.Annotations = map[string]string{
+					serviceBindingsHashAnnotation: serviceBindingsHash,
+				}
+			}
 			if createdImagePullSecret {
 				deployment.Spec.Template.Spec.ImagePullSecrets = []apiv1.LocalObjectReference{{
 					Name: containerClaim.EnvVarSpecName("image-pull-secret"),
@@ -351,34 +424,14 @@ func createKubeResourcesForContainer(log log.Logger, adminDB *sqlx.DB, kubeClien
 				name:         containerSelectorName,
 			})
 		}
-		// the IP of the node this pod is on, so that we can use that node as the service too
-		hostIP := ""
-
-		// wait until the HostIP is set before initialising the service
-		maxPingAttempts := 30 // will turn into 30 seconds of wait time
-		for range maxPingAttempts {
-			// have to "list" instead of "get" because the client API sucks
-			pods, err := clientset.CoreV1().Pods(namespace).List(context.Background(), metav1.ListOptions{LabelSelector: fmt.Sprintf("name=%s", containerSelectorName)})
-			if err != nil {
-				hostIP = client.DefaultRoutingIP
-				break
-			}
-			if len(pods.Items) == 0 {
-				hostIP = client.DefaultRoutingIP
-				break
-			}
-			actualPod := pods.Items[0]
-			if actualPod.Status.Phase == apiv1.PodFailed {
-				hostIP = client.DefaultRoutingIP
-				break
+		// the IP of the node this pod is on, so that we can use that node as the service too. The pod likely isn't
+		// scheduled yet, so start on the zone's default IP and let the informers repoint the services once it is
+		hostIP := client.DefaultRoutingIP
+		if pods, err := ListContainerPods(client, namespace, containerSelectorName); err == nil {
+			if pod := mostRelevantPod(pods); pod != nil && pod.Status.HostIP != "" {
+				hostIP = pod.Status.HostIP
 			}
-			if len(actualPod.Status.HostIP) > 0 {
-				hostIP = actualPod.Status.HostIP
-				break
-			}
-			time.Sleep(1 * time.Second)
 		}
-		// 👆 all that code just to find the damn IP of the node, geez
 
 		err = db.SaveNodeIPOfRunningContainer(adminDB, project, containerClaim, hostIP)
 		if err != nil {
@@ -410,7 +463,8 @@ func createKubeResourcesForContainer(log log.Logger, adminDB *sqlx.DB, kubeClien
 					Name:      containerClaim.ServiceName(targetPort),
 					Namespace: namespace,
 					Labels: map[string]string{
-						"app": containerClaim.ServiceName(targetPort),
+						"app":                 containerClaim.ServiceName(targetPort),
+						containerClaimIDLabel: claimIDLabelValue,
 					},
 				},
 				Spec: apiv1.ServiceSpec{
@@ -452,8 +506,282 @@ func createKubeResourcesForContainer(log log.Logger, adminDB *sqlx.DB, kubeClien
 	return nil
 }
 
-func rollBackCreation(log log.Logger, kubeClients []types.ContainerZone, addedResourcesToRollBack []addedResourceToRollBack) error {
+// Every key of the container's shared configs, at whatever version they're at right now
+func sharedConfigEnvFromSpec(adminDB *sqlx.DB, project types.Project, containerClaim types.ContainerClaim) (envFromSpec []apiv1.EnvFromSource, err error) {
+	for _, sharedConfigName := range containerClaim.SharedConfigNames {
+		sharedConfig, err := db.GetSharedConfigByProjectAndName(adminDB, project, sharedConfigName)
+		if err != nil {
+			return envFromSpec, fmt.Errorf("Getting shared config %s for container %s failed: %w", sharedConfigName, containerClaim.Name, err)
+		}
+
+		if sharedConfig.IsSecret() {
+			envFromSpec = append(envFromSpec, apiv1.EnvFromSource{
+				SecretRef: &apiv1.SecretEnvSource{
+					LocalObjectReference: apiv1.LocalObjectReference{Name: sharedConfig.CurrentObjectName()},
+				},
+			})
+		} else {
+			envFromSpec = append(envFromSpec, apiv1.EnvFromSource{
+				ConfigMapRef: &apiv1.ConfigMapEnvSource{
+					LocalObjectReference: apiv1.LocalObjectReference{Name: sharedConfig.CurrentObjectName()},
+				},
+			})
+		}
+	}
+	return envFromSpec, nil
+}
+
+// changing this on a deployment's pod template is what makes k8s restart its pods with the new creds
+const serviceBindingsHashAnnotation = "lcaas/service-bindings-hash"
+
+// The env vars for the container's bound services, from the instances in the given zone
+func serviceBindingValues(log log.Logger, adminDB *sqlx.DB, kubeClients []types.ContainerZone, credentialsKey []byte, client types.ContainerZone, project types.Project, containerClaim types.ContainerClaim) (map[string]string, error) {
+	values := map[string]string{}
+
+	if containerClaim.BindDatabase {
+		userDBClaim, err := db.GetUserDBClaimByProject(adminDB, project)
+		if err != nil {
+			return nil, fmt.Errorf("Getting the project database to bind to %s failed: %w", containerClaim.Name, err)
+		}
+		found := false
+		for _, userDB := range client.UserDBs {
+			creds, ok := userDBClaim.Credentials.ForUserDB(userDB, project.UserDBClaimRWUsername())
+			if !ok {
+				continue
+			}
+			dbValues, err := userDB.BindingEnvVars(project.UserDBClaimName(), creds)
+			if err != nil {
+				return nil, err
+			}
+			for k, v := range dbValues {
+				values[k] = v
+			}
+			found = true
+			break
+		}
+		if !found {
+			return nil, fmt.Errorf("The project database has no instance in zone %s to bind to %s", client.Name, containerClaim.Name)
+		}
+	}
+
+	if containerClaim.BoundObjectStorage != nil {
+		if client.ObjectStorage == nil {
+			return nil, fmt.Errorf("Zone %s has no object storage to bind to %s", client.Name, containerClaim.Name)
+		}
+		objectStorage, err := db.GetObjectStorageByProjectAndName(adminDB, project, *containerClaim.BoundObjectStorage)
+		if err != nil {
+			return nil, fmt.Errorf("Getting object storage %s to bind to %s failed: %w", *containerClaim.BoundObjectStorage, containerClaim.Name, err)
+		}
+		objectStorage, err = seaweedOps.EnsureBucketCredentials(log, adminDB, credentialsKey, objectStorageConnections(kubeClients), objectStorage)
+		if err != nil {
+			return nil, err
+		}
+		creds, ok := objectStorage.CredentialsForZone(client.Name)
+		if !ok {
+			return nil, fmt.Errorf("The object storage %s has no credentials in zone %s", objectStorage.Name, client.Name)
+		}
+		for k, v := range creds.BindingEnvVars(*client.ObjectStorage, objectStorage.BucketName()) {
+			values[k] = v
+		}
+	}
+
+	return values, nil
+}
+
+// Creates or overwrites the binding secret, returning whether it had to be created
+func applyServiceBindingSecret(clientset *kubernetes.Clientset, namespace string, containerClaim types.ContainerClaim, values map[string]string) (created bool, err error) {
+	secretsClient := clientset.CoreV1().Secrets(namespace)
+	secret, err := secretsClient.Get(context.Background(), containerClaim.ServiceBindingSecretName(), metav1.GetOptions{})
+	if errors.IsNotFound(err) {
+		_, err = secretsClient.Create(context.Background(), &apiv1.Secret{
+			ObjectMeta: metav1.ObjectMeta{
+				Name: containerClaim.ServiceBindingSecretName(),
+			},
+			StringData: values,
+		}, metav1.CreateOptions{})
+		return err == nil, err
+	} else if err != nil {
+		return false, err
+	}
+
+	secret.Data = nil
+	secret.StringData = values
+	_, err = secretsClient.Update(context.Background(), secret, metav1.UpdateOptions{})
+	return false, err
+}
+
+func hashServiceBindingValues(values map[string]string) string {
+	keys := []string{}
+	for key := range values {
+		keys = append(keys, key)
+	}
+	slices.Sort(keys)
+
+	hash := sha256.New()
+	for _, key := range keys {
+		fmt.Fprintf(hash, "%s=%s\n", key, values[key])
+	}
+	return hex.EncodeToString(hash.Sum(nil))[:16]
+}
+
+func objectStorageConnections(kubeClients []types.ContainerZone) (connections []types.ObjectStorageConnection) {
+	for _, client := range kubeClients {
+		if client.ObjectStorage != nil {
+			connections = append(connections, *client.ObjectStorage)
+		}
+	}
+	return connections
+}
+
+// Rewrites the binding secrets of every container in the project that has bound services, for after the DB password
+// or a bucket's keys got rotated. Permanent containers whose creds changed get their pods rolled; run-once containers
+// read the new secret on their next run.
+func RefreshServiceBindings(log log.Logger, adminDB *sqlx.DB, kubeClients []types.ContainerZone, credentialsKey []byte, project types.Project) error {
+	namespace := project.NamespaceName()
+	containers, err := db.GetContainersWithServiceBindings(adminDB, project)
+	if err != nil {
+		return err
+	}
+
+	for _, containerClaim := range containers {
+		for _, client := range kubeClients {
+			if !slices.Contains(containerClaim.Zones, client.Name) {
+				continue
+			}
+			if err := client.CheckReachable(); err != nil {
+				log.Warn("Skipping zone when refreshing service bindings", "container", containerClaim.Name, "err", err)
+				continue
+			}
+
+			bindingValues, err := serviceBindingValues(log, adminDB, kubeClients, credentialsKey, client, project, containerClaim)
+			if err != nil {
+				return err
+			}
+			_, err = applyServiceBindingSecret(client.ClientSet, namespace, containerClaim, bindingValues)
+			if err != nil {
+				return err
+			}
+
+			if containerClaim.RunType == "once" {
+				continue
+			}
+			deploymentsClient := client.ClientSet.AppsV1().Deployments(namespace)
+			deployment, err := deploymentsClient.Get(context.Background(), containerClaim.DeploymentName(), metav1.GetOptions{})
+			if errors.IsNotFound(err) {
+				continue
+			} else if err != nil {
+				return err
+			}
+
+			newHash := hashServiceBindingValues(bindingValues)
+			if deployment.Spec.Template.Annotations[serviceBindingsHashAnnotation] == newHash {
+				continue
+			}
+			if deployment.Spec.Template.Annotations == nil {
+				deployment.Spec.Template.Annotations = map[string]string{}
+			}
+			deployment.Spec.Template.Annotations[serviceBindingsHashAnnotation] = newHash
+
+			log.Debug("Rolling deployment onto refreshed service bindings", "deployment", containerClaim.DeploymentName(), "zone", client.Name)
+			_, err = deploymentsClient.Update(context.Background(), deployment, metav1.UpdateOptions{})
+			if err != nil {
+				return err
+			}
+		}
+	}
+
+	return nil
+}
+
+// Pod Security Admission labels for a project's namespace. Everyone gets "restricted" audited and warned on,
+// but only untrusted projects get it enforced, see the "Pod security" section of the README.
+func podSecurityLabels(project types.Project) map[string]string {
+	return map[string]string{
+		"pod-security.kubernetes.io/enforce":         project.PodSecurityEnforceLevel(),
+		"pod-security.kubernetes.io/enforce-version": "latest",
+		"pod-security.kubernetes.io/audit":           "restricted",
+		"pod-security.kubernetes.io/warn":            "restricted",
+	}
+}
+
+func syncPodSecurityLabels(clientset *kubernetes.Clientset, project types.Project) error {
+	ns, err := clientset.CoreV1().Namespaces().Get(context.Background(), project.NamespaceName(), metav1.GetOptions{})
+	if err != nil {
+		return err
+	}
+	if ns.Labels == nil {
+		ns.Labels = map[string]string{}
+	}
+	for k, v := range podSecurityLabels(project) {
+		ns.Labels[k] = v
+	}
+	_, err = clientset.CoreV1().Namespaces().Update(context.Background(), ns, metav1.UpdateOptions{})
+	return err
+}
+
+func podSecurityContext(containerClaim types.ContainerClaim) *apiv1.PodSecurityContext {
+	if containerClaim.IsUnconfined() {
+		return nil
+	}
+	return &apiv1.PodSecurityContext{
+		RunAsNonRoot: boolPtr(true),
+		SeccompProfile: &apiv1.SeccompProfile{
+			Type: apiv1.SeccompProfileTypeRuntimeDefault,
+		},
+	}
+}
+
+func containerSecurityContext(containerClaim types.ContainerClaim) *apiv1.SecurityContext {
+	if containerClaim.IsUnconfined() {
+		return nil
+	}
+	return &apiv1.SecurityContext{
+		RunAsNonRoot:             boolPtr(true),
+		AllowPrivilegeEscalation: boolPtr(false),
+		ReadOnlyRootFilesystem:   boolPtr(containerClaim.HasReadOnlyRootFilesystem()),
+		Capabilities: &apiv1.Capabilities{
+			Drop: []apiv1.Capability{"ALL"},
+		},
+		SeccompProfile: &apiv1.SeccompProfile{
+			Type: apiv1.SeccompProfileTypeRuntimeDefault,
+		},
+	}
+}
+
+// the bits of hardening that don't fit into the security contexts: sandboxed runtimes and a writable /tmp for read-only containers
+func hardenPodSpec(podSpec *apiv1.PodSpec, client types.ContainerZone, containerClaim types.ContainerClaim) {
+	if containerClaim.IsUnconfined() {
+		return
+	}
+	podSpec.AutomountServiceAccountToken = boolPtr(false)
+	if client.RuntimeClassName != "" {
+		podSpec.RuntimeClassName = &client.RuntimeClassName
+	}
+	if containerClaim.HasReadOnlyRootFilesystem() {
+		podSpec.Volumes = append(podSpec.Volumes, apiv1.Volume{
+			Name: "tmp",
+			VolumeSource: apiv1.VolumeSource{
+				EmptyDir: &apiv1.EmptyDirVolumeSource{},
+			},
+		})
+		for i := range podSpec.Containers {
+			podSpec.Containers[i].VolumeMounts = append(podSpec.Containers[i].VolumeMounts, apiv1.VolumeMount{
+				Name:      "tmp",
+				MountPath: "/tmp",
+			})
+		}
+	}
+}
+
+func rollBackCreation(log log.Logger, kubeClients []types.ContainerZone, addedResourcesToRollBack []addedResourceToRollBack) (err error) {
 	deletePolicy := metav1.DeletePropagationForeground
+	defer func() {
+		if err != nil {
+			metrics.ContainerRollbacks.Inc("failed")
+		} else {
+			metrics.ContainerRollbacks.Inc("ok")
+		}
+	}()
 
 	log.Info("🧹 Rolling back a failing half-creation")
 
@@ -462,6 +790,10 @@ func rollBackCreation(log log.Logger, kubeClients []types.ContainerZone, addedRe
 			if resourceToRollBack.zone != client.Name {
 				continue
 			}
+			if err := client.CheckReachable(); err != nil {
+				log.Error("Can't roll back in this zone", "resourceType", resourceToRollBack.resourceType, "name", resourceToRollBack.name, "err", err)
+				continue
+			}
 
 			clientset := client.ClientSet
 
@@ -474,6 +806,14 @@ func rollBackCreation(log log.Logger, kubeClients []types.ContainerZone, addedRe
 				}
 				log.Info("Deleted secret (env var)")
 
+			case "configmap":
+				if err := clientset.CoreV1().ConfigMaps(resourceToRollBack.namespace).Delete(context.TODO(), resourceToRollBack.name, metav1.DeleteOptions{
+					PropagationPolicy: &deletePolicy,
+				}); err != nil {
+					return err
+				}
+				log.Info("Deleted configmap", "configmap", resourceToRollBack.name)
+
 			case "deployment":
 				deploymentsClient := clientset.AppsV1().Deployments(resourceToRollBack.namespace)
 				if err := deploymentsClient.Delete(context.Background(), resourceToRollBack.name, metav1.DeleteOptions{
@@ -511,6 +851,13 @@ func rollBackCreation(log log.Logger, kubeClients []types.ContainerZone, addedRe
 
 func GetContainerLogs(log log.Logger, kubeClients []types.ContainerZone, p types.Project, c types.ContainerClaim) (logsForZones []LogsForZone, err error) {
 	for _, client := range kubeClients {
+		if !slices.Contains(c.Zones, client.Name) {
+			continue
+		}
+		if err := client.CheckReachable(); err != nil {
+			logsForZones = append(logsForZones, LogsForZone{Zone: client.Name, Error: err.Error()})
+			continue
+		}
 		clientset := client.ClientSet
 
 		podName := ""
@@ -520,13 +867,13 @@ func GetContainerLogs(log log.Logger, kubeClients []types.ContainerZone, p types
 			podName = c.DeploymentName()
 		}
 
-		allPodsWithName, err := clientset.CoreV1().Pods(p.NamespaceName()).List(context.Background(), metav1.ListOptions{LabelSelector: fmt.Sprintf("name=%s", podName)})
+		allPodsWithName, err := ListContainerPods(client, p.NamespaceName(), podName)
 		if err != nil {
 			return logsForZones, err
 		}
 
 		// should be only 1 pod with that name, but whatever
-		for _, pod := range allPodsWithName.Items {
+		for _, pod := range allPodsWithName {
 			req := clientset.CoreV1().Pods(p.NamespaceName()).GetLogs(pod.Name, &apiv1.PodLogOptions{
 				Timestamps: true,
 			})
@@ -556,6 +903,9 @@ func GetContainerInstances(kubeClients []types.ContainerZone, containerName stri
 	deployment := containerName
 
 	for _, client := range kubeClients {
+		if err := client.CheckReachable(); err != nil {
+			return containers, err
+		}
 		clientset := client.ClientSet
 		deploymentDetails, err := clientset.AppsV1().Deployments(namespace).Get(context.Background(), deployment, metav1.GetOptions{})
 		if errors.IsNotFound(err) {
@@ -583,6 +933,11 @@ func DeleteContainer(log log.Logger, kubeClients []types.ContainerZone, project
 	log.Debug("Deleting container", "container", containerClaim.Name)
 	namespace := project.NamespaceName()
 
+	err := checkZonesReachable(kubeClients, containerClaim.Zones)
+	if err != nil {
+		return err
+	}
+
 	for _, client := range kubeClients {
 		if !slices.Contains(containerClaim.Zones, client.Name) {
 			continue
@@ -602,7 +957,7 @@ func DeleteContainer(log log.Logger, kubeClients []types.ContainerZone, project
 
 		if !areWeRecreating {
 			log.Debug("Deleting secrets (env vars)", "container", containerClaim.Name)
-			for _, envVarName := range containerClaim.EnvVarNames {
+			for _, envVarName := range containerClaim.EnvVarNamesForZone(client.Name) {
 				if err := clientset.CoreV1().Secrets(namespace).Delete(context.TODO(), containerClaim.EnvVarSpecName(envVarName), metav1.DeleteOptions{
 					PropagationPolicy: &deletePolicy,
 				}); err != nil {
@@ -613,6 +968,17 @@ func DeleteContainer(log log.Logger, kubeClients []types.ContainerZone, project
 			log.Debug("Not deleting secrets because we are recreating this container", "container", containerClaim.Name)
 		}
 
+		// binding secrets belong to just this claim, so they go even when recreating
+		if containerClaim.HasServiceBindings() {
+			log.Debug("Deleting service binding secret", "container", containerClaim.Name)
+			err := clientset.CoreV1().Secrets(namespace).Delete(context.Background(), containerClaim.ServiceBindingSecretName(), metav1.DeleteOptions{
+				PropagationPolicy: &deletePolicy,
+			})
+			if err != nil && !errors.IsNotFound(err) {
+				return err
+			}
+		}
+
 		if containerClaim.RunType == "once" {
 			log.Debug("Deleting job", "container", containerClaim.Name)
 			err := clientset.BatchV1().Jobs(namespace).Delete(context.Background(), containerClaim.JobName(), metav1.DeleteOptions{
@@ -635,34 +1001,430 @@ func DeleteContainer(log log.Logger, kubeClients []types.ContainerZone, project
 	return nil
 }
 
-func InitialiseKubeClients(notConnectedClients []types.ContainerZone) (connectedClients []types.ContainerZone, err error) {
-	for _, c := range notConnectedClients {
-		clientset, err := createKubeClient(c.Name)
+// Replaces oldContainer with newContainer under the same name, for re-runs and rollbacks. The env var secrets are
+// reused as they are, so newContainer can't bring any env vars that oldContainer didn't have. So is the image pull
+// secret, unless newContainer comes with one that's known, ie. from one of the project's stored credentials, which
+// then gets written again. The caller is expected to have already set oldContainer as deactivating; nothing of it is
+// torn down until newContainer's claim has been accepted, and if it isn't, oldContainer is put back as it was.
+func RecreateContainer(log log.Logger, adminDB *sqlx.DB, kubeClients []types.ContainerZone, credentialsKey []byte, account types.Account, project types.Project, oldContainer types.ContainerClaim, newContainer types.ContainerClaim) error {
+	newContainer.EnvVars = newContainer.RetainedEnvVars()
+	knownImagePullSecret := newContainer.ImagePullSecret != nil && newContainer.ImagePullSecret.URL != ""
+	if !knownImagePullSecret {
+		newContainer.ImagePullSecret = nil
+		if newContainer.HasImagePullSecret() {
+			newContainer.ImagePullSecret = &types.ImagePullSecret{}
+		}
+	}
+	// the public ports get picked again when the services are recreated
+	newContainer.Ports = newContainer.TargetPorts
+
+	newContainer, err := db.ReplaceContainerClaim(adminDB, account, project, oldContainer, newContainer)
+	if err != nil {
+		restoreErr := db.RestoreDeactivatingContainer(adminDB, oldContainer)
+		if restoreErr != nil {
+			log.Error("Restoring a container whose replacement was refused failed", "container", oldContainer.Name, "error", restoreErr)
+		}
+		return err
+	}
+
+	err = DeleteContainer(log, kubeClients, project, oldContainer, true)
+	if err != nil {
+		_ = db.SetContainerAsErrorState(adminDB, newContainer)
+		return err
+	}
+	if knownImagePullSecret {
+		err = SaveImagePullSecretForContainer(kubeClients, project, newContainer)
+		if err != nil {
+			_ = db.SetContainerAsErrorState(adminDB, newContainer)
+			return err
+		}
+	}
+	// it's a new claim under the same name, so webhooks hear about it like any other
+	webhooks.EmitContainer(log, adminDB, project, "container.deleted", oldContainer)
+	webhooks.EmitContainer(log, adminDB, project, "container.created", newContainer)
+	return CreateContainerFromClaim(log, adminDB, kubeClients, credentialsKey, project, newContainer, true)
+}
+
+// Creates the env var secrets of a container whose image isn't there yet, since their values are only around while
+// it's being created. Once the image is, the container gets created from its claim as if it was being recreated.
+func CreateEnvVarSecretsForContainer(log log.Logger, kubeClients []types.ContainerZone, project types.Project, containerClaim types.ContainerClaim) error {
+	err := checkZonesReachable(kubeClients, containerClaim.Zones)
+	if err != nil {
+		return err
+	}
+	err = CreateNamespaceForNewProject(kubeClients, project)
+	if err != nil {
+		return err
+	}
+
+	for _, client := range kubeClients {
+		if !slices.Contains(containerClaim.Zones, client.Name) {
+			continue
+		}
+		for _, envVar := range containerClaim.EnvVarsForZone(client.Name) {
+			secret := &apiv1.Secret{
+				ObjectMeta: metav1.ObjectMeta{
+					Name: containerClaim.EnvVarSpecName(envVar.Name),
+				},
+				StringData: map[string]string{
+					envVar.Name: envVar.Value,
+				},
+			}
+			_, err := client.ClientSet.CoreV1().Secrets(project.NamespaceName()).Create(context.Background(), secret, metav1.CreateOptions{})
+			if err != nil && !strings.Contains(err.Error(), "already exists") {
+				return err
+			}
+			log.Debug("Secret created", "secret", secret.Name, "zone", client.Name)
+		}
+	}
+	return nil
+}
+
+// The .dockerconfigjson of an image pull secret for the registry at registryURL
+func dockerConfigJSON(ips *types.ImagePullSecret, registryURL string) ([]byte, error) {
+	if (ips.Username == "" || ips.Password == "") && ips.Token != "" { // just gonna only be able to use the token
+		return []byte(fmt.Sprintf("{\"auths\":{\"%s\":{\"auth\":\"%s\"}}}", registryURL, ips.Token)), nil
+
+	} else if ips.Token == "" { // or, if username and password both exist but there's no token
+		encodedUserAndPass := base64.StdEncoding.EncodeToString([]byte(fmt.Sprintf("%s:%s", ips.Username, ips.Password)))
+		return []byte(fmt.Sprintf("{\"auths\":{\"%s\":{\"username\":\"%s\",\"password\":\"%s\",\"email\":\"%s\",\"auth\":\"%s\"}}}", registryURL, ips.Username, ips.Password, ips.Email, encodedUserAndPass)), nil
+
+	} else if ips.Token != "" { // or, if username and password both exist AND there's also a token
+		return []byte(fmt.Sprintf("{\"auths\":{\"%s\":{\"username\":\"%s\",\"password\":\"%s\",\"email\":\"%s\",\"auth\":\"%s\"}}}", registryURL, ips.Username, ips.Password, ips.Email, ips.Token)), nil
+	}
+	return nil, fmt.Errorf("Something went wrong when creating ImagePullSecret")
+}
+
+// Creates the container's image pull secret in each of its zones, or overwrites the one that's there. For
+// containers that get deployed later on as if they were being recreated, whose secrets have to exist by then.
+func SaveImagePullSecretForContainer(kubeClients []types.ContainerZone, project types.Project, containerClaim types.ContainerClaim) error {
+	err := checkZonesReachable(kubeClients, containerClaim.Zones)
+	if err != nil {
+		return err
+	}
+	for _, client := range kubeClients {
+		if !slices.Contains(containerClaim.Zones, client.Name) {
+			continue
+		}
+		encodedFullCreds, err := dockerConfigJSON(containerClaim.ImagePullSecret, containerClaim.ImagePullSecretURLForZone(client, kubeClients))
+		if err != nil {
+			return err
+		}
+		secret := &apiv1.Secret{
+			ObjectMeta: metav1.ObjectMeta{
+				Name: containerClaim.EnvVarSpecName("image-pull-secret"),
+			},
+			Type: apiv1.SecretTypeDockerConfigJson,
+			Data: map[string][]byte{
+				".dockerconfigjson": encodedFullCreds,
+			},
+		}
+		secretsClient := client.ClientSet.CoreV1().Secrets(project.NamespaceName())
+		_, err = secretsClient.Update(context.Background(), secret, metav1.UpdateOptions{})
+		if errors.IsNotFound(err) {
+			_, err = secretsClient.Create(context.Background(), secret, metav1.CreateOptions{})
+		}
+		if err != nil {
+			return fmt.Errorf("Saving the image pull secret of container %s in zone %s failed: %w", containerClaim.Name, client.Name, err)
+		}
+	}
+	return nil
+}
+
+// Reads the container's image pull secret back out of the first of its zones that's reachable, for looking at its
+// registry again after it was created, since we don't keep the credentials anywhere else
+func GetImagePullSecret(kubeClients []types.ContainerZone, project types.Project, containerClaim types.ContainerClaim) (*types.ImagePullSecret, error) {
+	for _, client := range kubeClients {
+		if !slices.Contains(containerClaim.Zones, client.Name) || client.CheckReachable() != nil {
+			continue
+		}
+		secret, err := client.ClientSet.CoreV1().Secrets(project.NamespaceName()).Get(context.Background(), containerClaim.EnvVarSpecName("image-pull-secret"), metav1.GetOptions{})
 		if err != nil {
 			return nil, err
 		}
-		c.ClientSet = clientset
-		connectedClients = append(connectedClients, c)
+
+		var dockerConfig struct {
+			Auths map[string]struct {
+				Username string `json:"username"`
+				Password string `json:"password"`
+				Email    string `json:"email"`
+				Auth     string `json:"auth"`
+			} `json:"auths"`
+		}
+		err = json.Unmarshal(secret.Data[apiv1.DockerConfigJsonKey], &dockerConfig)
+		if err != nil {
+			return nil, fmt.Errorf("Reading the image pull secret of container %s failed: %w", containerClaim.Name, err)
+		}
+		for registryURL, auth := range dockerConfig.Auths {
+			return &types.ImagePullSecret{URL: registryURL, Email: auth.Email, Username: auth.Username, Password: auth.Password, Token: auth.Auth}, nil
+		}
+		return nil, fmt.Errorf("The image pull secret of container %s is empty", containerClaim.Name)
 	}
+	return nil, fmt.Errorf("None of the zones of container %s are reachable to get its image pull secret from", containerClaim.Name)
+}
 
-	return connectedClients, err
+// Saves a new shared config and creates its first version in every zone
+func CreateSharedConfig(log log.Logger, adminDB *sqlx.DB, kubeClients []types.ContainerZone, account types.Account, project types.Project, sharedConfig types.SharedConfig, values map[string]string) (types.SharedConfig, error) {
+	sharedConfig.Keys = types.SharedConfigKeys(values)
+	sharedConfig.ConfigValues = nil
+	if !sharedConfig.IsSecret() {
+		configValues := types.SharedConfigValues(values)
+		sharedConfig.ConfigValues = &configValues
+	}
+
+	sharedConfig, err := db.CreateSharedConfigForProject(adminDB, account, project, sharedConfig)
+	if err != nil {
+		return sharedConfig, err
+	}
+
+	err = CreateSharedConfigVersion(log, kubeClients, project, sharedConfig, values)
+	if err != nil {
+		dberr := db.DeleteSharedConfigByProjectAndName(adminDB, project, sharedConfig.Name)
+		if dberr != nil {
+			log.Error(dberr.Error())
+		}
+		return sharedConfig, err
+	}
+
+	return sharedConfig, nil
+}
+
+// Saves the credential's new login, which has to be sealed already, and writes it into the image pull secrets of
+// every container using it in each of their zones. The running pods don't need restarting for that, the next pull
+// picks it up.
+func RotatePullCredential(log log.Logger, adminDB *sqlx.DB, kubeClients []types.ContainerZone, project types.Project, credential types.PullCredential) (types.PullCredential, error) {
+	credential, err := db.RotatePullCredential(adminDB, credential)
+	if err != nil {
+		return credential, err
+	}
+	containers, err := db.GetContainersUsingPullCredential(adminDB, project, credential.Name)
+	if err != nil {
+		return credential, err
+	}
+
+	var failed []string
+	for _, container := range containers {
+		container.UsePullCredential(credential)
+		err = SaveImagePullSecretForContainer(kubeClients, project, container)
+		if err != nil {
+			log.Error("Updating the image pull secret failed", "container", container.Name, "err", err)
+			failed = append(failed, container.Name)
+		}
+	}
+	if len(failed) > 0 {
+		return credential, fmt.Errorf("The new login is saved, but the image pull secrets of %s couldn't be updated everywhere, rotate it again once their zones are reachable", strings.Join(failed, ", "))
+	}
+	return credential, nil
 }
 
-func createKubeClient(clusterName string) (*kubernetes.Clientset, error) {
-	var kubeconfig *string
-	if home := homedir.HomeDir(); home != "" {
-		kubeconfig = flag.String(fmt.Sprintf("kubeconfig-%s", clusterName), filepath.Join(home, ".kube", fmt.Sprintf("%s.conf", clusterName)), "(optional) absolute path to the kubeconfig file")
-	} else {
-		kubeconfig = flag.String(fmt.Sprintf("kubeconfig-%s", clusterName), "", "absolute path to the kubeconfig file")
+// Makes the next version of a shared config out of the current one plus the changes, and if asked to,
+// rolls the permanent containers using it onto the new version
+func UpdateSharedConfig(log log.Logger, adminDB *sqlx.DB, kubeClients []types.ContainerZone, account types.Account, project types.Project, sharedConfig types.SharedConfig, changes map[string]string, removedKeys []string, restartContainers bool) (types.SharedConfig, error) {
+	previousValues, err := GetSharedConfigValues(kubeClients, project, sharedConfig)
+	if err != nil {
+		return sharedConfig, err
+	}
+	values := types.MergeSharedConfigValues(previousValues, changes, removedKeys)
+
+	nextSharedConfig := sharedConfig
+	nextSharedConfig.Version++
+	nextSharedConfig.Keys = types.SharedConfigKeys(values)
+	if !sharedConfig.IsSecret() {
+		configValues := types.SharedConfigValues(values)
+		nextSharedConfig.ConfigValues = &configValues
 	}
-	flag.Parse()
 
-	config, err := clientcmd.BuildConfigFromFlags("", *kubeconfig)
+	err = CreateSharedConfigVersion(log, kubeClients, project, nextSharedConfig, values)
 	if err != nil {
-		return nil, err
+		return sharedConfig, err
 	}
 
-	return kubernetes.NewForConfig(config)
+	nextSharedConfig, err = db.SaveNewSharedConfigVersion(adminDB, account, nextSharedConfig)
+	if err != nil {
+		return sharedConfig, err
+	}
+
+	if !restartContainers {
+		return nextSharedConfig, nil
+	}
+	containers, err := db.GetContainersUsingSharedConfig(adminDB, project, sharedConfig.Name)
+	if err != nil {
+		return nextSharedConfig, err
+	}
+	return nextSharedConfig, RestartContainersUsingSharedConfig(log, kubeClients, project, nextSharedConfig, containers)
+}
+
+// Creates the Secret/ConfigMap for the shared config's current version in every zone. Versions are immutable,
+// so containers still on an older version keep running with exactly what they started with.
+func CreateSharedConfigVersion(log log.Logger, kubeClients []types.ContainerZone, project types.Project, sharedConfig types.SharedConfig, values map[string]string) error {
+	namespace := project.NamespaceName()
+	// every zone has to have every version, or containers there would miss it
+	err := checkZonesReachable(kubeClients, types.GetZonesFromContainerZones(kubeClients))
+	if err != nil {
+		return err
+	}
+	err = CreateNamespaceForNewProject(kubeClients, project)
+	if err != nil {
+		return err
+	}
+
+	objectMeta := metav1.ObjectMeta{
+		Name: sharedConfig.CurrentObjectName(),
+		Labels: map[string]string{
+			"lcaas/shared-config": sharedConfig.Name,
+		},
+	}
+
+	addedResourcesToRollBack := []addedResourceToRollBack{}
+	for _, client := range kubeClients {
+		clientset := client.ClientSet
+		resourceType := "configmap"
+
+		if sharedConfig.IsSecret() {
+			resourceType = "secret"
+			secret := &apiv1.Secret{
+				ObjectMeta: objectMeta,
+				Immutable:  boolPtr(true),
+				StringData: values,
+			}
+			_, err = clientset.CoreV1().Secrets(namespace).Create(context.Background(), secret, metav1.CreateOptions{})
+		} else {
+			configMap := &apiv1.ConfigMap{
+				ObjectMeta: objectMeta,
+				Immutable:  boolPtr(true),
+				Data:       values,
+			}
+			_, err = clientset.CoreV1().ConfigMaps(namespace).Create(context.Background(), configMap, metav1.CreateOptions{})
+		}
+		if err != nil {
+			rollbackErr := rollBackCreation(log, kubeClients, addedResourcesToRollBack)
+			if rollbackErr != nil {
+				return rollbackErr
+			}
+			return err
+		}
+		addedResourcesToRollBack = append(addedResourcesToRollBack, addedResourceToRollBack{
+			zone:         client.Name,
+			resourceType: resourceType,
+			namespace:    namespace,
+			name:         sharedConfig.CurrentObjectName(),
+		})
+		log.Debug("Shared config version created", "name", sharedConfig.CurrentObjectName(), "zone", client.Name)
+	}
+
+	return nil
+}
+
+// The values of the shared config's current version. We don't keep secret values in the DB, so those
+// come back out of the first zone that has them.
+func GetSharedConfigValues(kubeClients []types.ContainerZone, project types.Project, sharedConfig types.SharedConfig) (map[string]string, error) {
+	if !sharedConfig.IsSecret() {
+		values := map[string]string{}
+		if sharedConfig.ConfigValues != nil {
+			values = *sharedConfig.ConfigValues
+		}
+		return values, nil
+	}
+
+	var lastErr error
+	for _, client := range kubeClients {
+		if err := client.CheckReachable(); err != nil {
+			lastErr = err
+			continue
+		}
+		secret, err := client.ClientSet.CoreV1().Secrets(project.NamespaceName()).Get(context.Background(), sharedConfig.CurrentObjectName(), metav1.GetOptions{})
+		if err != nil {
+			lastErr = err
+			continue
+		}
+		values := map[string]string{}
+		for key, value := range secret.Data {
+			values[key] = string(value)
+		}
+		return values, nil
+	}
+
+	return nil, fmt.Errorf("Couldn't read %s from any zone: %w", sharedConfig.CurrentObjectName(), lastErr)
+}
+
+// Points the deployments of the given containers at the shared config's current version, which makes k8s roll
+// their pods over to it. Run-once containers are left alone, they get the new version on their next run.
+func RestartContainersUsingSharedConfig(log log.Logger, kubeClients []types.ContainerZone, project types.Project, sharedConfig types.SharedConfig, containers []types.ContainerClaim) error {
+	namespace := project.NamespaceName()
+
+	for _, containerClaim := range containers {
+		if containerClaim.RunType == "once" {
+			continue
+		}
+
+		for _, client := range kubeClients {
+			if !slices.Contains(containerClaim.Zones, client.Name) {
+				continue
+			}
+			if err := client.CheckReachable(); err != nil {
+				log.Warn("Skipping zone when restarting containers", "container", containerClaim.Name, "err", err)
+				continue
+			}
+			deploymentsClient := client.ClientSet.AppsV1().Deployments(namespace)
+
+			deployment, err := deploymentsClient.Get(context.Background(), containerClaim.DeploymentName(), metav1.GetOptions{})
+			if errors.IsNotFound(err) {
+				continue
+			} else if err != nil {
+				return err
+			}
+
+			podSpec := &deployment.Spec.Template.Spec
+			for i := range podSpec.Containers {
+				for j, envFrom := range podSpec.Containers[i].EnvFrom {
+					if envFrom.SecretRef != nil && sharedConfig.OwnsObjectName(envFrom.SecretRef.Name) {
+						podSpec.Containers[i].EnvFrom[j].SecretRef.Name = sharedConfig.CurrentObjectName()
+					}
+					if envFrom.ConfigMapRef != nil && sharedConfig.OwnsObjectName(envFrom.ConfigMapRef.Name) {
+						podSpec.Containers[i].EnvFrom[j].ConfigMapRef.Name = sharedConfig.CurrentObjectName()
+					}
+				}
+			}
+
+			log.Debug("Rolling deployment onto new shared config version", "deployment", containerClaim.DeploymentName(), "sharedConfig", sharedConfig.CurrentObjectName(), "zone", client.Name)
+			_, err = deploymentsClient.Update(context.Background(), deployment, metav1.UpdateOptions{})
+			if err != nil {
+				return err
+			}
+		}
+	}
+
+	return nil
+}
+
+// Deletes every version of the shared config from every zone
+func DeleteSharedConfig(log log.Logger, kubeClients []types.ContainerZone, project types.Project, sharedConfig types.SharedConfig) error {
+	namespace := project.NamespaceName()
+	listOptions := metav1.ListOptions{LabelSelector: fmt.Sprintf("lcaas/shared-config=%s", sharedConfig.Name)}
+
+	err := checkZonesReachable(kubeClients, types.GetZonesFromContainerZones(kubeClients))
+	if err != nil {
+		return err
+	}
+
+	for _, client := range kubeClients {
+		var err error
+		if sharedConfig.IsSecret() {
+			err = client.ClientSet.CoreV1().Secrets(namespace).DeleteCollection(context.Background(), metav1.DeleteOptions{}, listOptions)
+		} else {
+			err = client.ClientSet.CoreV1().ConfigMaps(namespace).DeleteCollection(context.Background(), metav1.DeleteOptions{}, listOptions)
+		}
+		if err != nil {
+			return err
+		}
+		log.Debug("Deleted shared config", "name", sharedConfig.Name, "zone", client.Name)
+	}
+
+	return nil
 }
 
 func int32Ptr(i int32) *int32 { return &i }
+
+func int64Ptr(i int64) *int64 { return &i }
+
+func boolPtr(b bool) *bool { return &b }
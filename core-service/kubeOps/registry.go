@@ -0,0 +1,157 @@
+package kubeOps
+
+import (
+	"context"
+	"crypto/sha256"
+	"encoding/hex"
+	"fmt"
+
+	"github.com/lu1a/lcaas/core-service/types"
+	appsv1 "k8s.io/api/apps/v1"
+	apiv1 "k8s.io/api/core/v1"
+	"k8s.io/apimachinery/pkg/api/errors"
+	"k8s.io/apimachinery/pkg/api/resource"
+	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+	"k8s.io/apimachinery/pkg/util/intstr"
+)
+
+const (
+	registryNamespace = "lcaas-registry"
+	registryName      = "registry"
+	registryPort      = 5000
+	// blobs no manifest points to anymore get deleted this often, after the registry manager deleted the manifests
+	registryGCIntervalSeconds = 24 * 60 * 60
+)
+
+// Creates or updates the registry in the zone, which takes the tokens signed with the key of tokenCertPEM. It's only
+// reachable in the cluster as the "registry" Service in the "lcaas-registry" namespace, exposing it at the zone's
+// registry_host with TLS is up to the operator.
+func ApplyZoneRegistry(client types.ContainerZone, policy types.RegistryPolicy, tokenCertPEM []byte) error {
+	err := client.CheckReachable()
+	if err != nil {
+		return err
+	}
+	clientset := client.ClientSet
+	ctx := context.Background()
+
+	_, err = clientset.CoreV1().Namespaces().Create(ctx, &apiv1.Namespace{
+		ObjectMeta: metav1.ObjectMeta{
+			Name: registryNamespace,
+			// the registry image runs as root
+			Labels: map[string]string{"pod-security.kubernetes.io/enforce": "baseline"},
+		},
+	}, metav1.CreateOptions{})
+	if err != nil && !errors.IsAlreadyExists(err) {
+		return fmt.Errorf("Creating the registry namespace in zone %s failed: %w", client.Name, err)
+	}
+
+	certSecret := &apiv1.Secret{
+		ObjectMeta: metav1.ObjectMeta{Name: "registry-token-cert"},
+		Data:       map[string][]byte{"token.crt": tokenCertPEM},
+	}
+	_, err = clientset.CoreV1().Secrets(registryNamespace).Update(ctx, certSecret, metav1.UpdateOptions{})
+	if errors.IsNotFound(err) {
+		_, err = clientset.CoreV1().Secrets(registryNamespace).Create(ctx, certSecret, metav1.CreateOptions{})
+	}
+	if err != nil {
+		return fmt.Errorf("Saving the registry's token certificate in zone %s failed: %w", client.Name, err)
+	}
+
+	volumeClaim := &apiv1.PersistentVolumeClaim{
+		ObjectMeta: metav1.ObjectMeta{Name: "registry-data"},
+		Spec: apiv1.PersistentVolumeClaimSpec{
+			AccessModes: []apiv1.PersistentVolumeAccessMode{apiv1.ReadWriteOnce},
+			Resources: apiv1.VolumeResourceRequirements{
+				Requests: apiv1.ResourceList{apiv1.ResourceStorage: resource.MustParse(fmt.Sprintf("%vGi", policy.StorageGB))},
+			},
+		},
+	}
+	if policy.StorageClassName != "" {
+		volumeClaim.Spec.StorageClassName = &policy.StorageClassName
+	}
+	// it only ever gets created, growing it is up to the operator
+	_, err = clientset.CoreV1().PersistentVolumeClaims(registryNamespace).Create(ctx, volumeClaim, metav1.CreateOptions{})
+	if err != nil && !errors.IsAlreadyExists(err) {
+		return fmt.Errorf("Creating the registry's volume in zone %s failed: %w", client.Name, err)
+	}
+
+	env := []apiv1.EnvVar{
+		{Name: "REGISTRY_AUTH", Value: "token"},
+		{Name: "REGISTRY_AUTH_TOKEN_REALM", Value: policy.TokenURL()},
+		{Name: "REGISTRY_AUTH_TOKEN_SERVICE", Value: client.RegistryHost},
+		{Name: "REGISTRY_AUTH_TOKEN_ISSUER", Value: types.RegistryTokenIssuer},
+		{Name: "REGISTRY_AUTH_TOKEN_ROOTCERTBUNDLE", Value: "/etc/registry-token/token.crt"},
+		{Name: "REGISTRY_STORAGE_DELETE_ENABLED", Value: "true"},
+	}
+	volumeMounts := []apiv1.VolumeMount{
+		{Name: "data", MountPath: "/var/lib/registry"},
+		{Name: "token-cert", MountPath: "/etc/registry-token", ReadOnly: true},
+	}
+	certHash := sha256.Sum256(tokenCertPEM)
+	labels := map[string]string{"app": registryName}
+	deployment := &appsv1.Deployment{
+		ObjectMeta: metav1.ObjectMeta{Name: registryName, Labels: labels},
+		Spec: appsv1.DeploymentSpec{
+			Replicas: int32Ptr(1),
+			Selector: &metav1.LabelSelector{MatchLabels: labels},
+			// the volume can only be mounted by one pod at a time
+			Strategy: appsv1.DeploymentStrategy{Type: appsv1.RecreateDeploymentStrategyType},
+			Template: apiv1.PodTemplateSpec{
+				ObjectMeta: metav1.ObjectMeta{
+					Labels: labels,
+					// so that a new certificate gets picked up
+					Annotations: map[string]string{"lcaas/token-cert-hash": hex.EncodeToString(certHash[:])[:16]},
+				},
+				Spec: apiv1.PodSpec{
+					Containers: []apiv1.Container{
+						{
+							Name:         registryName,
+							Image:        policy.Image,
+							Env:          env,
+							Ports:        []apiv1.ContainerPort{{Name: "http", ContainerPort: registryPort, Protocol: apiv1.ProtocolTCP}},
+							VolumeMounts: volumeMounts,
+							ReadinessProbe: &apiv1.Probe{
+								// /v2/ only answers with a 401 without a token
+								ProbeHandler: apiv1.ProbeHandler{TCPSocket: &apiv1.TCPSocketAction{Port: intstr.FromInt32(registryPort)}},
+							},
+						},
+						{
+							Name:         "garbage-collector",
+							Image:        policy.Image,
+							Command:      []string{"/bin/sh", "-c", fmt.Sprintf("while sleep %v; do registry garbage-collect /etc/docker/registry/config.yml; done", registryGCIntervalSeconds)},
+							Env:          env,
+							VolumeMounts: volumeMounts,
+						},
+					},
+					Volumes: []apiv1.Volume{
+						{Name: "data", VolumeSource: apiv1.VolumeSource{PersistentVolumeClaim: &apiv1.PersistentVolumeClaimVolumeSource{ClaimName: "registry-data"}}},
+						{Name: "token-cert", VolumeSource: apiv1.VolumeSource{Secret: &apiv1.SecretVolumeSource{SecretName: "registry-token-cert"}}},
+					},
+					AutomountServiceAccountToken: boolPtr(false),
+				},
+			},
+		},
+	}
+	existing, err := clientset.AppsV1().Deployments(registryNamespace).Get(ctx, registryName, metav1.GetOptions{})
+	if errors.IsNotFound(err) {
+		_, err = clientset.AppsV1().Deployments(registryNamespace).Create(ctx, deployment, metav1.CreateOptions{})
+	} else if err == nil {
+		existing.Spec = deployment.Spec
+		_, err = clientset.AppsV1().Deployments(registryNamespace).Update(ctx, existing, metav1.UpdateOptions{})
+	}
+	if err != nil {
+		return fmt.Errorf("Saving the registry in zone %s failed: %w", client.Name, err)
+	}
+
+	_, err = clientset.CoreV1().Services(registryNamespace).Create(ctx, &apiv1.Service{
+		ObjectMeta: metav1.ObjectMeta{Name: registryName, Labels: labels},
+		Spec: apiv1.ServiceSpec{
+			Selector: labels,
+			Ports:    []apiv1.ServicePort{{Name: "http", Port: registryPort, TargetPort: intstr.FromInt32(registryPort)}},
+		},
+	}, metav1.CreateOptions{})
+	if err != nil && !errors.IsAlreadyExists(err) {
+		return fmt.Errorf("Creating the registry's service in zone %s failed: %w", client.Name, err)
+	}
+	return nil
+}
@@ -8,6 +8,7 @@ type addedResourceToRollBack struct {
 }
 
 type LogsForZone struct {
-	Zone string   `json:"zone"`
-	Logs []string `json:"logs"`
+	Zone  string   `json:"zone"`
+	Logs  []string `json:"logs"`
+	Error string   `json:"error,omitempty"` // when the zone couldn't be asked
 }
@@ -0,0 +1,133 @@
+package kubeOps
+
+import (
+	"context"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"strconv"
+	"sync"
+	"time"
+
+	"github.com/charmbracelet/log"
+	"github.com/jmoiron/sqlx"
+	"github.com/lu1a/lcaas/core-service/db"
+	"github.com/lu1a/lcaas/core-service/metrics"
+	"github.com/lu1a/lcaas/core-service/types"
+	apiv1 "k8s.io/api/core/v1"
+	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+)
+
+const usageSampleInterval = time.Minute
+
+// Only what we read of the metrics API's PodMetrics, so that we don't need k8s.io/metrics for it
+type podMetricsList struct {
+	Items []podMetrics `json:"items"`
+}
+
+type podMetrics struct {
+	metav1.ObjectMeta `json:"metadata"`
+	Containers        []struct {
+		Usage apiv1.ResourceList `json:"usage"`
+	} `json:"containers"`
+}
+
+// Samples what the containers actually use from every zone's metrics API (metrics-server has to run in the zone's
+// cluster), and adds it into the hourly rollups that the usage reports get made from.
+type UsageSampler struct {
+	log          log.Logger
+	adminDB      *sqlx.DB
+	zoneRegistry *ZoneRegistry
+}
+
+func NewUsageSampler(log log.Logger, adminDB *sqlx.DB, zoneRegistry *ZoneRegistry) *UsageSampler {
+	return &UsageSampler{log: log, adminDB: adminDB, zoneRegistry: zoneRegistry}
+}
+
+// Samples every usageSampleInterval until ctx is done
+func (s *UsageSampler) Run(ctx context.Context) {
+	ticker := time.NewTicker(usageSampleInterval)
+	defer ticker.Stop()
+	for {
+		select {
+		case <-ctx.Done():
+			return
+		case sampledAt := <-ticker.C:
+			s.sample(ctx, sampledAt)
+		}
+	}
+}
+
+func (s *UsageSampler) sample(ctx context.Context, sampledAt time.Time) {
+	var wg sync.WaitGroup
+	var errsMu sync.Mutex
+	var errs []error
+	for _, zone := range s.zoneRegistry.Zones() {
+		if zone.CheckReachable() != nil {
+			continue
+		}
+
+		wg.Add(1)
+		go func() {
+			defer wg.Done()
+			samples, err := sampleZone(ctx, zone)
+			if err != nil {
+				s.log.Warn("Sampling container usage failed", "zone", zone.Name, "err", err)
+			} else {
+				err = db.SaveContainerUsageSamples(s.adminDB, sampledAt, samples)
+				if err != nil {
+					s.log.Error(err.Error())
+				}
+			}
+			if err != nil {
+				errsMu.Lock()
+				errs = append(errs, err)
+				errsMu.Unlock()
+			}
+		}()
+	}
+	wg.Wait()
+	metrics.WorkerRan("usage_sampler", errors.Join(errs...))
+}
+
+func sampleZone(ctx context.Context, zone types.ContainerZone) (samples []types.ContainerUsageSample, err error) {
+	return samplePods(ctx, zone, "/apis/metrics.k8s.io/v1beta1/pods", containerClaimIDLabel)
+}
+
+// Sums up the usage of every pod we made for a container, since a rollout can have two of them running for a bit
+func samplePods(ctx context.Context, zone types.ContainerZone, path string, labelSelector string) (samples []types.ContainerUsageSample, err error) {
+	raw, err := zone.ClientSet.CoreV1().RESTClient().Get().
+		AbsPath(path).
+		Param("labelSelector", labelSelector).
+		DoRaw(ctx)
+	if err != nil {
+		return samples, fmt.Errorf("asking the metrics API failed, is metrics-server running? %w", err)
+	}
+	var metricsList podMetricsList
+	err = json.Unmarshal(raw, &metricsList)
+	if err != nil {
+		return samples, fmt.Errorf("reading the pod metrics failed: %w", err)
+	}
+
+	byContainerClaimID := map[int]*types.ContainerUsageSample{}
+	for _, pod := range metricsList.Items {
+		containerClaimID, err := strconv.Atoi(pod.Labels[containerClaimIDLabel])
+		if err != nil {
+			continue
+		}
+		sample, ok := byContainerClaimID[containerClaimID]
+		if !ok {
+			sample = &types.ContainerUsageSample{ContainerClaimID: containerClaimID, ZoneName: zone.Name}
+			byContainerClaimID[containerClaimID] = sample
+		}
+		for _, container := range pod.Containers {
+			sample.CPUMilliCores += int(container.Usage.Cpu().MilliValue())
+			sample.MemoryMB += int(container.Usage.Memory().Value() / 1024 / 1024)
+		}
+	}
+
+	for _, sample := range byContainerClaimID {
+		samples = append(samples, *sample)
+	}
+	return samples, nil
+}
@@ -0,0 +1,457 @@
+package kubeOps
+
+import (
+	"context"
+	"crypto/sha256"
+	"encoding/hex"
+	"fmt"
+	"os"
+	"path/filepath"
+	"slices"
+	"sync"
+	"time"
+
+	"github.com/charmbracelet/log"
+	"github.com/jmoiron/sqlx"
+	"github.com/lu1a/lcaas/core-service/db"
+	"github.com/lu1a/lcaas/core-service/metrics"
+	"github.com/lu1a/lcaas/core-service/registryOps"
+	"github.com/lu1a/lcaas/core-service/types"
+	"github.com/lu1a/lcaas/core-service/webhooks"
+	"k8s.io/client-go/kubernetes"
+	"k8s.io/client-go/rest"
+	"k8s.io/client-go/tools/clientcmd"
+	"k8s.io/client-go/util/homedir"
+)
+
+const (
+	zoneProbeInterval = 30 * time.Second
+	zoneProbeTimeout  = 5 * time.Second
+	// a probe slower than this still counts, but marks the zone as degraded
+	zoneSlowProbeThreshold = 2 * time.Second
+	// how many probes in a row have to fail before a zone counts as unreachable instead of degraded
+	zoneUnreachableAfterFailures = 3
+	// so that handlers give up on a cluster that stopped answering instead of hanging
+	kubeRequestTimeout = 15 * time.Second
+)
+
+// Keeps track of the zones' clusters: loads their kubeconfigs when first needed and again whenever they change,
+// probes them, and writes how they're doing to container_zone. The zones themselves and the DB instances in them
+// come from the DB, so they can be changed through the admin API without a restart. Handlers take a fresh snapshot
+// with Zones() and should check types.ContainerZone.CheckReachable before talking to a zone.
+type ZoneRegistry struct {
+	log     log.Logger
+	adminDB *sqlx.DB
+
+	checkMu                  sync.Mutex // so that a Refresh and the regular probes don't both (re)connect a zone
+	mu                       sync.RWMutex
+	ctx                      context.Context // set by Run, informers live as long as it does
+	zones                    []*registeredZone
+	userDBConnections        []types.UserDB
+	objectStorageConnections []types.ObjectStorageConnection
+}
+
+type registeredZone struct {
+	zone types.ContainerZone
+
+	kubeconfigVersion string // the file's mod time, or a hash of the uploaded kubeconfig
+	failedProbes      int
+	stopInformers     context.CancelFunc
+}
+
+func NewZoneRegistry(log log.Logger, adminDB *sqlx.DB, objectStorageConnections []types.ObjectStorageConnection) *ZoneRegistry {
+	return &ZoneRegistry{log: log, adminDB: adminDB, objectStorageConnections: objectStorageConnections}
+}
+
+// A copy of every zone as it is right now
+func (r *ZoneRegistry) Zones() []types.ContainerZone {
+	r.mu.RLock()
+	defer r.mu.RUnlock()
+
+	zones := []types.ContainerZone{}
+	for _, registered := range r.zones {
+		zones = append(zones, registered.zone)
+	}
+	return types.AttachServiceConnections(zones, r.userDBConnections, r.objectStorageConnections)
+}
+
+func (r *ZoneRegistry) UserDBConnections() []types.UserDB {
+	r.mu.RLock()
+	defer r.mu.RUnlock()
+
+	return slices.Clone(r.userDBConnections)
+}
+
+// Probes all zones straight away and then every zoneProbeInterval, until ctx is done
+func (r *ZoneRegistry) Run(ctx context.Context) {
+	r.mu.Lock()
+	r.ctx = ctx
+	r.mu.Unlock()
+
+	ticker := time.NewTicker(zoneProbeInterval)
+	defer ticker.Stop()
+	for {
+		r.checkZones(ctx)
+
+		select {
+		case <-ctx.Done():
+			return
+		case <-ticker.C:
+		}
+	}
+}
+
+// For after a zone got changed through the admin API: picks the change up straight away instead of on the next probe
+func (r *ZoneRegistry) Refresh() {
+	r.mu.RLock()
+	ctx := r.ctx
+	r.mu.RUnlock()
+	if ctx == nil {
+		return
+	}
+	go r.checkZones(ctx)
+}
+
+// Syncs the zones and DB instances with the DB: new ones get added, retired ones dropped, and changes to the rest
+// taken over. Zones whose kubeconfig changed get reconnected on their next check.
+func (r *ZoneRegistry) Reload() error {
+	zonesFromDB, err := db.GetContainerZonesFromDB(r.adminDB)
+	if err != nil {
+		return err
+	}
+	userDBConnections, err := db.GetUserDBZones(r.adminDB)
+	if err != nil {
+		return err
+	}
+
+	r.mu.Lock()
+	defer r.mu.Unlock()
+
+	r.userDBConnections = userDBConnections
+
+	registeredZones := []*registeredZone{}
+	for _, zoneFromDB := range zonesFromDB {
+		if zoneFromDB.KubeconfigPath == "" {
+			zoneFromDB.KubeconfigPath = defaultKubeconfigPath(zoneFromDB.Name)
+		}
+
+		i := slices.IndexFunc(r.zones, func(registered *registeredZone) bool { return registered.zone.Name == zoneFromDB.Name })
+		if i == -1 {
+			registeredZones = append(registeredZones, &registeredZone{zone: zoneFromDB})
+			continue
+		}
+
+		// the connection and how it's doing are ours, everything else comes from the DB
+		registered := r.zones[i]
+		zoneFromDB.ClientSet = registered.zone.ClientSet
+		zoneFromDB.Informers = registered.zone.Informers
+		zoneFromDB.Health = registered.zone.Health
+		zoneFromDB.HealthError = registered.zone.HealthError
+		zoneFromDB.HealthCheckedAt = registered.zone.HealthCheckedAt
+		registered.zone = zoneFromDB
+		registeredZones = append(registeredZones, registered)
+	}
+
+	for _, registered := range r.zones {
+		if slices.Contains(registeredZones, registered) {
+			continue
+		}
+		r.log.Info("Zone is gone", "zone", registered.zone.Name)
+		if registered.stopInformers != nil {
+			registered.stopInformers()
+		}
+	}
+	r.zones = registeredZones
+
+	registryHosts := []string{}
+	for _, registered := range r.zones {
+		if registered.zone.RegistryHost != "" {
+			registryHosts = append(registryHosts, registered.zone.RegistryHost)
+		}
+	}
+	registryOps.SetInternalRegistryHosts(registryHosts)
+
+	return nil
+}
+
+func (r *ZoneRegistry) checkZones(ctx context.Context) {
+	r.checkMu.Lock()
+	defer r.checkMu.Unlock()
+
+	err := r.Reload()
+	if err != nil {
+		r.log.Error("Reloading zones from the DB failed", "err", err)
+	}
+	metrics.WorkerRan("zone_registry", err)
+
+	r.mu.RLock()
+	registeredZones := slices.Clone(r.zones)
+	r.mu.RUnlock()
+
+	var wg sync.WaitGroup
+	for _, registered := range registeredZones {
+		wg.Add(1)
+		go func() {
+			defer wg.Done()
+			r.checkZone(ctx, registered)
+		}()
+	}
+	wg.Wait()
+}
+
+func (r *ZoneRegistry) checkZone(ctx context.Context, registered *registeredZone) {
+	r.mu.RLock()
+	zone := registered.zone
+	r.mu.RUnlock()
+
+	err := r.connectIfKubeconfigChanged(ctx, registered)
+	if err != nil {
+		r.setHealth(registered, "unreachable", err)
+		if zone.Health != "unreachable" {
+			r.log.Warn("Zone unreachable", "zone", zone.Name, "err", err)
+		}
+		return
+	}
+
+	r.mu.RLock()
+	clientSet := registered.zone.ClientSet
+	r.mu.RUnlock()
+
+	probeCtx, cancel := context.WithTimeout(ctx, zoneProbeTimeout)
+	defer cancel()
+	started := time.Now()
+	_, err = clientSet.Discovery().RESTClient().Get().AbsPath("/readyz").DoRaw(probeCtx)
+	took := time.Since(started)
+
+	switch {
+	case err != nil:
+		r.mu.Lock()
+		registered.failedProbes++
+		failedProbes := registered.failedProbes
+		r.mu.Unlock()
+
+		health := "degraded"
+		if failedProbes >= zoneUnreachableAfterFailures {
+			health = "unreachable"
+		}
+		r.setHealth(registered, health, fmt.Errorf("health probe failed: %w", err))
+	case took > zoneSlowProbeThreshold:
+		r.resetFailedProbes(registered)
+		r.setHealth(registered, "degraded", fmt.Errorf("health probe took %s", took.Round(time.Millisecond)))
+	default:
+		r.resetFailedProbes(registered)
+		r.setHealth(registered, "healthy", nil)
+	}
+
+	r.mu.RLock()
+	newHealth := registered.zone.Health
+	r.mu.RUnlock()
+	if zone.Health != newHealth {
+		r.log.Info("Zone health changed", "zone", zone.Name, "from", zone.Health, "to", newHealth)
+	}
+}
+
+// (Re)loads the zone's kubeconfig if it's new or changed, and restarts its informers on the new client
+func (r *ZoneRegistry) connectIfKubeconfigChanged(ctx context.Context, registered *registeredZone) error {
+	r.mu.RLock()
+	zone := registered.zone
+	lastVersion := registered.kubeconfigVersion
+	r.mu.RUnlock()
+
+	var kubeconfig []byte
+	var version string
+	if zone.Kubeconfig != nil {
+		kubeconfig = []byte(*zone.Kubeconfig)
+		hash := sha256.Sum256(kubeconfig)
+		version = hex.EncodeToString(hash[:])
+	} else {
+		fileInfo, err := os.Stat(zone.KubeconfigPath)
+		if err != nil {
+			return fmt.Errorf("reading the kubeconfig failed: %w", err)
+		}
+		version = fileInfo.ModTime().String()
+	}
+	if zone.ClientSet != nil && version == lastVersion {
+		return nil
+	}
+
+	var config *rest.Config
+	var err error
+	if kubeconfig != nil {
+		config, err = clientcmd.RESTConfigFromKubeConfig(kubeconfig)
+	} else {
+		config, err = clientcmd.BuildConfigFromFlags("", zone.KubeconfigPath)
+	}
+	if err != nil {
+		return fmt.Errorf("loading the kubeconfig failed: %w", err)
+	}
+	clientSet, watchClientSet, err := loadKubeClients(zone.Name, config)
+	if err != nil {
+		return fmt.Errorf("loading the kubeconfig failed: %w", err)
+	}
+
+	r.mu.Lock()
+	if registered.stopInformers != nil {
+		registered.stopInformers()
+	}
+	registered.zone.ClientSet = clientSet
+	registered.zone.Informers = nil
+	registered.kubeconfigVersion = version
+	informersCtx, stopInformers := context.WithCancel(ctx)
+	registered.stopInformers = stopInformers
+	zoneForInformers := registered.zone
+	zoneForInformers.ClientSet = watchClientSet
+	r.mu.Unlock()
+
+	if lastVersion != "" {
+		r.log.Info("Reloaded changed kubeconfig", "zone", zone.Name)
+	}
+
+	factory, err := startZoneInformers(informersCtx, r.log, r.adminDB, zoneForInformers)
+	if err != nil {
+		r.log.Error("Starting informers failed", "zone", zone.Name, "err", err)
+		return nil
+	}
+	// only hand out the cache once it's complete, reads go to the cluster until then
+	go func() {
+		for informerType, synced := range factory.WaitForCacheSync(informersCtx.Done()) {
+			if !synced {
+				r.log.Warn("Informer cache never synced", "zone", zone.Name, "type", informerType.String())
+				return
+			}
+		}
+		r.mu.Lock()
+		if registered.zone.ClientSet == clientSet {
+			registered.zone.Informers = factory
+		}
+		r.mu.Unlock()
+	}()
+
+	return nil
+}
+
+func (r *ZoneRegistry) resetFailedProbes(registered *registeredZone) {
+	r.mu.Lock()
+	registered.failedProbes = 0
+	r.mu.Unlock()
+}
+
+func (r *ZoneRegistry) setHealth(registered *registeredZone, health string, healthErr error) {
+	var healthError *string
+	if healthErr != nil {
+		errStr := healthErr.Error()
+		healthError = &errStr
+	}
+	checkedAt := time.Now()
+
+	r.mu.Lock()
+	registered.zone.Health = health
+	registered.zone.HealthError = healthError
+	registered.zone.HealthCheckedAt = &checkedAt
+	zoneName := registered.zone.Name
+	r.mu.Unlock()
+
+	err := db.SaveContainerZoneHealth(r.adminDB, zoneName, health, healthError)
+	if err != nil {
+		r.log.Error(err.Error())
+	}
+}
+
+// Fails with the first of the named zones that doesn't exist or can't be talked to right now
+func checkZonesReachable(kubeClients []types.ContainerZone, zoneNames []string) error {
+	for _, zoneName := range zoneNames {
+		i := slices.IndexFunc(kubeClients, func(client types.ContainerZone) bool { return client.Name == zoneName })
+		if i == -1 {
+			return fmt.Errorf("Zone %s doesn't exist", zoneName)
+		}
+		if err := kubeClients[i].CheckReachable(); err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+func defaultKubeconfigPath(zoneName string) string {
+	return filepath.Join(homedir.HomeDir(), ".kube", fmt.Sprintf("%s.conf", zoneName))
+}
+
+// Whether an uploaded kubeconfig is something we could connect with at all
+func ValidateKubeconfig(kubeconfig string) error {
+	_, err := clientcmd.RESTConfigFromKubeConfig([]byte(kubeconfig))
+	if err != nil {
+		return fmt.Errorf("That kubeconfig doesn't work: %w", err)
+	}
+	return nil
+}
+
+// The second client has no request timeout, for the informers' long-running watches
+func loadKubeClients(zoneName string, config *rest.Config) (clientSet *kubernetes.Clientset, watchClientSet *kubernetes.Clientset, err error) {
+	config.Wrap(metrics.InstrumentKubeTransport(zoneName))
+
+	watchClientSet, err = kubernetes.NewForConfig(config)
+	if err != nil {
+		return nil, nil, err
+	}
+
+	config.Timeout = kubeRequestTimeout
+	clientSet, err = kubernetes.NewForConfig(config)
+	if err != nil {
+		return nil, nil, err
+	}
+
+	return clientSet, watchClientSet, nil
+}
+
+// Moves every container off a zone that's being retired. Containers that also run elsewhere just lose this zone,
+// the ones that only ran here are deleted. If the zone can't be reached anymore its resources are left for whoever
+// tears the cluster down, the claims are cleaned up either way.
+func DrainZone(log log.Logger, adminDB *sqlx.DB, kubeClients []types.ContainerZone, zoneName string) error {
+	i := slices.IndexFunc(kubeClients, func(client types.ContainerZone) bool { return client.Name == zoneName })
+	if i == -1 {
+		return fmt.Errorf("Zone %s doesn't exist", zoneName)
+	}
+	zoneClients := []types.ContainerZone{kubeClients[i]}
+
+	containers, err := db.GetContainersInZone(adminDB, zoneName)
+	if err != nil {
+		return err
+	}
+
+	for _, containerClaim := range containers {
+		project, err := db.GetProjectByID(adminDB, containerClaim.ProjectID)
+		if err != nil {
+			return err
+		}
+		onlyThisZone := containerClaim
+		onlyThisZone.Zones = []string{zoneName}
+
+		log.Info("Draining container off zone", "zone", zoneName, "project", project.Name, "container", containerClaim.Name)
+		err = DeleteContainer(log, zoneClients, project, onlyThisZone, false)
+		if err != nil {
+			log.Warn("Couldn't delete the container's resources in the zone", "zone", zoneName, "container", containerClaim.Name, "err", err)
+		}
+
+		if len(containerClaim.Zones) > 1 {
+			err = db.RemoveZoneFromContainer(adminDB, containerClaim, zoneName)
+			if err != nil {
+				return err
+			}
+			continue
+		}
+		err = db.SetContainerAsDeactivating(adminDB, containerClaim)
+		if err != nil {
+			return err
+		}
+		err = db.DeleteContainerByProjectAndName(adminDB, project, containerClaim.Name)
+		if err != nil {
+			return err
+		}
+		webhooks.EmitContainer(log, adminDB, project, "container.deleted", containerClaim)
+		err = db.DeleteDeployHookByProjectAndContainerName(adminDB, project, containerClaim.Name)
+		if err != nil {
+			return err
+		}
+	}
+
+	return nil
+}
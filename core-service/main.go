@@ -1,6 +1,7 @@
 package main
 
 import (
+	"encoding/base64"
 	"encoding/json"
 	"os"
 	"os/signal"
@@ -39,6 +40,79 @@ func main() {
 		log.Fatal("Pls set the USER_DB_CONNECTIONS correctly", "err", err)
 	}
 
+	var objectStorageConnectionsRaw types.ObjectStorageConnectionsRaw
+	if objectStorageConnectionsString := os.Getenv("OBJECT_STORAGE_CONNECTIONS"); objectStorageConnectionsString != "" {
+		err = json.Unmarshal([]byte(objectStorageConnectionsString), &objectStorageConnectionsRaw)
+		if err != nil {
+			log.Fatal("Pls set the OBJECT_STORAGE_CONNECTIONS correctly", "err", err)
+		}
+	}
+
+	var imagePolicy types.ImagePolicy
+	if imagePolicyString := os.Getenv("IMAGE_POLICY"); imagePolicyString != "" {
+		err = json.Unmarshal([]byte(imagePolicyString), &imagePolicy)
+		if err != nil {
+			log.Fatal("Pls set the IMAGE_POLICY correctly", "err", err)
+		}
+	}
+
+	var capacityReserve types.CapacityReserve
+	if capacityReserveString := os.Getenv("CAPACITY_RESERVE"); capacityReserveString != "" {
+		err = json.Unmarshal([]byte(capacityReserveString), &capacityReserve)
+		if err != nil {
+			log.Fatal("Pls set the CAPACITY_RESERVE correctly", "err", err)
+		}
+	}
+
+	var billingPolicy types.BillingPolicy
+	if billingPolicyString := os.Getenv("BILLING_POLICY"); billingPolicyString != "" {
+		err = json.Unmarshal([]byte(billingPolicyString), &billingPolicy)
+		if err != nil {
+			log.Fatal("Pls set the BILLING_POLICY correctly", "err", err)
+		}
+	}
+
+	var buildPolicy types.BuildPolicy
+	if buildPolicyString := os.Getenv("BUILD_POLICY"); buildPolicyString != "" {
+		err = json.Unmarshal([]byte(buildPolicyString), &buildPolicy)
+		if err != nil {
+			log.Fatal("Pls set the BUILD_POLICY correctly", "err", err)
+		}
+	}
+
+	var registryPolicy types.RegistryPolicy
+	if registryPolicyString := os.Getenv("REGISTRY_POLICY"); registryPolicyString != "" {
+		err = json.Unmarshal([]byte(registryPolicyString), &registryPolicy)
+		if err != nil {
+			log.Fatal("Pls set the REGISTRY_POLICY correctly", "err", err)
+		}
+		if registryPolicy.Enabled() && (registryPolicy.TokenCertPath == "" || registryPolicy.PublicURL == "") {
+			log.Fatal("Pls set the REGISTRY_POLICY's token_cert_path and public_url too")
+		}
+	}
+
+	var credentialsKey []byte
+	if credentialsKeyString := os.Getenv("CREDENTIALS_KEY"); credentialsKeyString != "" {
+		credentialsKey, err = base64.StdEncoding.DecodeString(credentialsKeyString)
+		if err != nil || len(credentialsKey) != 32 {
+			log.Fatal("Pls set the CREDENTIALS_KEY to 32 random bytes in base64, ie. from `openssl rand -base64 32`")
+		}
+	}
+	if registryPolicy.Enabled() && credentialsKey == nil {
+		log.Fatal("Pls set the CREDENTIALS_KEY too, the registries' logins are kept encrypted with it")
+	}
+	if len(objectStorageConnectionsRaw.Zones) > 0 && credentialsKey == nil {
+		log.Fatal("Pls set the CREDENTIALS_KEY too, the buckets' keys are kept encrypted with it")
+	}
+
+	var prometheusURLs map[string]string
+	if prometheusURLsString := os.Getenv("PROMETHEUS_URLS"); prometheusURLsString != "" {
+		err = json.Unmarshal([]byte(prometheusURLsString), &prometheusURLs)
+		if err != nil {
+			log.Fatal("Pls set the PROMETHEUS_URLS correctly", "err", err)
+		}
+	}
+
 	config := types.Config{
 		ListenURL:       listenURL,
 		ShutdownTimeout: shutdownTimeout,
@@ -50,6 +124,24 @@ func main() {
 
 		KubeClients:       kubeClientsRaw.Clients,
 		UserDBConnections: userDBConnectionsRaw.Zones,
+
+		ObjectStorageConnections: objectStorageConnectionsRaw.Zones,
+
+		ImagePolicy: imagePolicy,
+
+		CapacityReserve: capacityReserve,
+
+		BillingPolicy: billingPolicy,
+
+		BuildPolicy: buildPolicy.WithDefaults(),
+
+		RegistryPolicy: registryPolicy.WithDefaults(),
+
+		CredentialsKey: credentialsKey,
+
+		MetricsToken: os.Getenv("METRICS_TOKEN"),
+
+		PrometheusURLs: prometheusURLs,
 	}
 
 	err = runService(config)
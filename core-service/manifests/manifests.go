@@ -0,0 +1,409 @@
+package manifests
+
+import (
+	"errors"
+	"fmt"
+	"slices"
+	"strings"
+
+	"github.com/charmbracelet/log"
+	"github.com/jmoiron/sqlx"
+	"github.com/lu1a/lcaas/core-service/db"
+	"github.com/lu1a/lcaas/core-service/internalRegistry"
+	"github.com/lu1a/lcaas/core-service/kubeOps"
+	"github.com/lu1a/lcaas/core-service/postgresOps"
+	"github.com/lu1a/lcaas/core-service/registryOps"
+	"github.com/lu1a/lcaas/core-service/types"
+	"github.com/lu1a/lcaas/core-service/webhooks"
+)
+
+var ErrInvalidManifest = errors.New("The manifest can't be applied")
+
+func invalid(format string, args ...any) error {
+	return fmt.Errorf("%w: %s", ErrInvalidManifest, fmt.Sprintf(format, args...))
+}
+
+// The project as it is, to plan a manifest against
+type projectState struct {
+	containers          map[string]types.ContainerClaim
+	database            *types.UserDBClaim
+	objectStorages      map[string]types.ObjectStorageClaim
+	sharedConfigNames   []string
+	pullCredentialNames []string
+}
+
+func loadProjectState(adminDB *sqlx.DB, project types.Project) (state projectState, err error) {
+	containers, err := db.GetContainersByProject(adminDB, project)
+	if err != nil {
+		return state, err
+	}
+	state.containers = map[string]types.ContainerClaim{}
+	for _, container := range containers {
+		state.containers[container.Name] = container
+	}
+
+	// there's only ever the one, same as on the database page
+	userDBClaims, err := db.GetUserDBClaimsByProject(adminDB, project)
+	if err != nil {
+		return state, err
+	}
+	if len(userDBClaims) > 0 {
+		state.database = &userDBClaims[0]
+	}
+
+	objectStorages, err := db.GetObjectStoragesByProject(adminDB, project)
+	if err != nil {
+		return state, err
+	}
+	state.objectStorages = map[string]types.ObjectStorageClaim{}
+	for _, objectStorage := range objectStorages {
+		state.objectStorages[objectStorage.Name] = objectStorage
+	}
+
+	sharedConfigs, err := db.GetSharedConfigsByProject(adminDB, project)
+	if err != nil {
+		return state, err
+	}
+	for _, sharedConfig := range sharedConfigs {
+		state.sharedConfigNames = append(state.sharedConfigNames, sharedConfig.Name)
+	}
+	pullCredentials, err := db.GetPullCredentialsByProject(adminDB, project)
+	if err != nil {
+		return state, err
+	}
+	for _, pullCredential := range pullCredentials {
+		state.pullCredentialNames = append(state.pullCredentialNames, pullCredential.Name)
+	}
+	return state, nil
+}
+
+// The project's containers, database and buckets as a manifest, which applies back without any changes
+func Export(adminDB *sqlx.DB, project types.Project) (manifest types.ProjectManifest, err error) {
+	state, err := loadProjectState(adminDB, project)
+	if err != nil {
+		return manifest, err
+	}
+
+	manifest.Containers = []types.ManifestContainer{}
+	for _, container := range state.containers {
+		manifest.Containers = append(manifest.Containers, types.ManifestContainerFromClaim(container))
+	}
+	slices.SortFunc(manifest.Containers, func(a, b types.ManifestContainer) int { return strings.Compare(a.Name, b.Name) })
+
+	if state.database != nil && len(state.database.Zones) > 0 {
+		manifest.Database = &types.ManifestDatabase{Zone: state.database.Zones[0]}
+	}
+
+	manifest.ObjectStorages = []types.ManifestObjectStorage{}
+	for _, objectStorage := range state.objectStorages {
+		manifest.ObjectStorages = append(manifest.ObjectStorages, types.ManifestObjectStorage{Name: objectStorage.Name, Zones: objectStorage.Zones})
+	}
+	slices.SortFunc(manifest.ObjectStorages, func(a, b types.ManifestObjectStorage) int { return strings.Compare(a.Name, b.Name) })
+	return manifest, nil
+}
+
+// What applying the manifest would do, in the order it'd do it in. Without prune, whatever isn't in the manifest is
+// left alone; with it, it gets deleted.
+func Plan(adminDB *sqlx.DB, project types.Project, manifest types.ProjectManifest, prune bool) ([]types.ManifestChange, error) {
+	state, err := loadProjectState(adminDB, project)
+	if err != nil {
+		return nil, err
+	}
+	return plan(state, manifest, prune)
+}
+
+// Creates come first and deletes last, so that whatever a container gets bound to is there before it is, and is
+// only deleted after the containers bound to it. Everything that can be told without deploying anything is checked
+// here, so that a manifest that can't work doesn't get half applied.
+func plan(state projectState, manifest types.ProjectManifest, prune bool) (changes []types.ManifestChange, err error) {
+	var deletes []types.ManifestChange
+
+	// what the database and buckets will be once it's applied, for checking the containers' bindings against
+	var databaseZones []string
+	switch {
+	case manifest.Database != nil && state.database == nil:
+		changes = append(changes, types.ManifestChange{Kind: "database", Action: "create", Fields: []types.RevisionFieldChange{{Field: "zone", To: manifest.Database.Zone}}})
+		databaseZones = []string{manifest.Database.Zone}
+	case manifest.Database != nil:
+		if !slices.Contains(state.database.Zones, manifest.Database.Zone) {
+			return nil, invalid("The database is in %s and can't be moved to %s, delete it first", strings.Join(state.database.Zones, ", "), manifest.Database.Zone)
+		}
+		databaseZones = state.database.Zones
+	case state.database != nil && prune:
+		deletes = append(deletes, types.ManifestChange{Kind: "database", Action: "delete"})
+	case state.database != nil:
+		databaseZones = state.database.Zones
+	}
+
+	objectStorageZones := map[string][]string{}
+	for _, objectStorage := range manifest.ObjectStorages {
+		existing, ok := state.objectStorages[objectStorage.Name]
+		if !ok {
+			changes = append(changes, types.ManifestChange{Kind: "object_storage", Name: objectStorage.Name, Action: "create", Fields: []types.RevisionFieldChange{{Field: "zones", To: strings.Join(objectStorage.Zones, ", ")}}})
+			objectStorageZones[objectStorage.Name] = objectStorage.Zones
+			continue
+		}
+		if !sameZones(existing.Zones, objectStorage.Zones) {
+			return nil, invalid("Object storage %s is in %s and can't be moved to %s, delete it first", objectStorage.Name, strings.Join(existing.Zones, ", "), strings.Join(objectStorage.Zones, ", "))
+		}
+		objectStorageZones[objectStorage.Name] = existing.Zones
+	}
+	var objectStorageDeletes []types.ManifestChange
+	for name, existing := range state.objectStorages {
+		if slices.ContainsFunc(manifest.ObjectStorages, func(o types.ManifestObjectStorage) bool { return o.Name == name }) {
+			continue
+		}
+		if prune {
+			objectStorageDeletes = append(objectStorageDeletes, types.ManifestChange{Kind: "object_storage", Name: name, Action: "delete"})
+		} else {
+			objectStorageZones[name] = existing.Zones
+		}
+	}
+
+	for _, container := range manifest.Containers {
+		err = checkContainer(state, container, databaseZones, objectStorageZones)
+		if err != nil {
+			return nil, err
+		}
+
+		existing, ok := state.containers[container.Name]
+		if !ok {
+			changes = append(changes, types.ManifestChange{Kind: "container", Name: container.Name, Action: "create", Fields: types.ManifestContainer{}.Diff(container)})
+			continue
+		}
+		fields := types.ManifestContainerFromClaim(existing).Diff(container)
+		if len(fields) == 0 {
+			continue
+		}
+		if existing.Status == "building" || existing.Status == "activating" || existing.Status == "deactivating" {
+			return nil, invalid("Container %s is being deployed right now, apply it again once that's done", container.Name)
+		}
+		// its env var secrets get reused as they are, and they only exist in the zones it's in
+		if len(existing.RetainedEnvVars()) > 0 || len(existing.ZoneEnvVarNames) > 0 {
+			for _, zone := range container.Zones {
+				if !slices.Contains(existing.Zones, zone) {
+					return nil, invalid("Container %s has env vars of its own, which only exist in %s, so it can't be moved to %s by a manifest. Delete it and make it again there instead", container.Name, strings.Join(existing.Zones, ", "), zone)
+				}
+			}
+		}
+		changes = append(changes, types.ManifestChange{Kind: "container", Name: container.Name, Action: "update", Fields: fields})
+	}
+	var containerDeletes []types.ManifestChange
+	for name, existing := range state.containers {
+		if !prune || slices.ContainsFunc(manifest.Containers, func(c types.ManifestContainer) bool { return c.Name == name }) {
+			continue
+		}
+		if existing.Status == "deactivating" {
+			return nil, invalid("Container %s is being replaced or deleted right now, apply it again once that's done", name)
+		}
+		containerDeletes = append(containerDeletes, types.ManifestChange{Kind: "container", Name: name, Action: "delete"})
+	}
+
+	// maps don't keep an order, but plans should
+	byName := func(a, b types.ManifestChange) int { return strings.Compare(a.Name, b.Name) }
+	slices.SortFunc(containerDeletes, byName)
+	slices.SortFunc(objectStorageDeletes, byName)
+	changes = append(changes, containerDeletes...)
+	changes = append(changes, objectStorageDeletes...)
+	return append(changes, deletes...), nil
+}
+
+// The same checks a new container goes through, against what the project will have once the manifest is applied
+func checkContainer(state projectState, container types.ManifestContainer, databaseZones []string, objectStorageZones map[string][]string) error {
+	for _, sharedConfigName := range container.SharedConfigs {
+		if !slices.Contains(state.sharedConfigNames, sharedConfigName) {
+			return invalid("Container %s: there's no shared secret or config called %q in this project", container.Name, sharedConfigName)
+		}
+	}
+	if container.PullCredential != "" && !slices.Contains(state.pullCredentialNames, container.PullCredential) {
+		return invalid("Container %s: there's no registry credential called %q in this project", container.Name, container.PullCredential)
+	}
+	if container.BindDatabase {
+		if databaseZones == nil {
+			return invalid("Container %s is bound to the database, but the project won't have one", container.Name)
+		}
+		for _, zone := range container.Zones {
+			if !slices.Contains(databaseZones, zone) {
+				return invalid("Container %s: the project database isn't in zone %s, so it can't be bound to a container running there", container.Name, zone)
+			}
+		}
+	}
+	if container.BindObjectStorage != "" {
+		zones, ok := objectStorageZones[container.BindObjectStorage]
+		if !ok {
+			return invalid("Container %s is bound to object storage %s, but the project won't have it", container.Name, container.BindObjectStorage)
+		}
+		for _, zone := range container.Zones {
+			if !slices.Contains(zones, zone) {
+				return invalid("Container %s: the object storage %s isn't in zone %s, so it can't be bound to a container running there", container.Name, container.BindObjectStorage, zone)
+			}
+		}
+	}
+	return nil
+}
+
+func sameZones(a, b []string) bool {
+	a, b = slices.Clone(a), slices.Clone(b)
+	slices.Sort(a)
+	slices.Sort(b)
+	return slices.Equal(a, b)
+}
+
+// Plans the manifest and converges the project on it, through the same paths as the UI and the API take one thing
+// at a time. The images go through the image policy before anything changes. The containers get deployed in the
+// background, like they always do, so what comes back is what was started.
+func Apply(log log.Logger, adminDB *sqlx.DB, zoneRegistry *kubeOps.ZoneRegistry, config types.Config, account types.Account, project types.Project, manifest types.ProjectManifest, prune bool) ([]types.ManifestChange, error) {
+	state, err := loadProjectState(adminDB, project)
+	if err != nil {
+		return nil, err
+	}
+	changes, err := plan(state, manifest, prune)
+	if err != nil {
+		return nil, err
+	}
+
+	deploys := map[string]types.ContainerClaim{}
+	for _, container := range manifest.Containers {
+		if !slices.ContainsFunc(changes, func(c types.ManifestChange) bool { return c.Kind == "container" && c.Name == container.Name }) {
+			continue
+		}
+		deploys[container.Name], err = prepareContainer(adminDB, zoneRegistry.Zones(), config, project, state, container)
+		if err != nil {
+			return nil, invalid("Container %s: %s", container.Name, err.Error())
+		}
+	}
+
+	for i, change := range changes {
+		err = applyChange(log, adminDB, zoneRegistry, config.CredentialsKey, account, project, state, manifest, deploys, change)
+		if err != nil {
+			return changes[:i], fmt.Errorf("Applying the manifest stopped after %d of %d changes, at the %s of %s %s: %w", i, len(changes), change.Action, change.Kind, change.Name, err)
+		}
+	}
+	return changes, nil
+}
+
+// The container the manifest wants, on top of the running one if there is one, with its image pull secret and its
+// image checked the way a new container's are
+func prepareContainer(adminDB *sqlx.DB, zones []types.ContainerZone, config types.Config, project types.Project, state projectState, container types.ManifestContainer) (newContainer types.ContainerClaim, err error) {
+	existing, running := state.containers[container.Name]
+	newContainer = types.ContainerClaim{ProjectID: project.ProjectID, ImagePullSecret: &types.ImagePullSecret{}}
+	if running {
+		newContainer = existing
+		newContainer.DeployReason = "manifest"
+	}
+	newContainer = container.AppliedTo(newContainer)
+
+	if newContainer.PullCredentialName != nil {
+		credential, err := db.OpenPullCredentialByProjectAndName(adminDB, config.CredentialsKey, project, *newContainer.PullCredentialName)
+		if err != nil {
+			return newContainer, err
+		}
+		newContainer.UsePullCredential(credential)
+	} else if running && newContainer.HasImagePullSecret() {
+		// the login it was made with, which is only kept in k8s
+		newContainer.ImagePullSecret, err = kubeOps.GetImagePullSecret(zones, project, existing)
+		if err != nil {
+			return newContainer, err
+		}
+	}
+	newContainer, err = internalRegistry.AttachPullSecret(adminDB, zones, config.RegistryPolicy, config.CredentialsKey, project, newContainer)
+	if err != nil {
+		return newContainer, err
+	}
+	return registryOps.VerifyImage(config.ImagePolicy, newContainer)
+}
+
+func applyChange(log log.Logger, adminDB *sqlx.DB, zoneRegistry *kubeOps.ZoneRegistry, credentialsKey []byte, account types.Account, project types.Project, state projectState, manifest types.ProjectManifest, deploys map[string]types.ContainerClaim, change types.ManifestChange) error {
+	switch change.Kind + " " + change.Action {
+	case "database create":
+		userDBClaim, err := db.CreateUserDBClaimForProject(adminDB, project, types.UserDBClaim{ProjectID: project.ProjectID, Zones: []string{manifest.Database.Zone}})
+		if err != nil {
+			return err
+		}
+		go func() {
+			err := postgresOps.CreateDatabaseForProject(log, adminDB, zoneRegistry.UserDBConnections(), project, userDBClaim)
+			if err != nil {
+				log.Error(err.Error())
+			}
+		}()
+
+	case "database delete":
+		userDBClaim := *state.database
+		go func() {
+			err := postgresOps.DeleteDatabaseForProject(log, adminDB, zoneRegistry.UserDBConnections(), project, userDBClaim)
+			if err != nil {
+				log.Error(err.Error())
+			}
+		}()
+
+	case "object_storage create":
+		i := slices.IndexFunc(manifest.ObjectStorages, func(o types.ManifestObjectStorage) bool { return o.Name == change.Name })
+		objectStorage, err := db.CreateObjectStorageForProject(adminDB, project, types.ObjectStorageClaim{Name: change.Name, ProjectID: project.ProjectID, Zones: manifest.ObjectStorages[i].Zones})
+		if err != nil {
+			return err
+		}
+		webhooks.EmitObjectStorage(log, adminDB, project, "object_storage.created", objectStorage)
+
+	case "object_storage delete":
+		objectStorage := state.objectStorages[change.Name]
+		err := db.SetObjectStorageAsDeactivating(adminDB, objectStorage)
+		if err != nil {
+			return err
+		}
+		err = db.DeleteObjectStorageByProjectAndName(adminDB, project, change.Name)
+		if err != nil {
+			return err
+		}
+		webhooks.EmitObjectStorage(log, adminDB, project, "object_storage.deleted", objectStorage)
+
+	case "container create":
+		newContainer, err := db.CreateContainerClaimForProject(adminDB, account, project, deploys[change.Name])
+		if err != nil {
+			return err
+		}
+		webhooks.EmitContainer(log, adminDB, project, "container.created", newContainer)
+		go func() {
+			err := kubeOps.CreateContainerFromClaim(log, adminDB, zoneRegistry.Zones(), credentialsKey, project, newContainer, true)
+			if err != nil {
+				log.Error(err.Error())
+			}
+		}()
+
+	case "container update":
+		oldContainer, newContainer := state.containers[change.Name], deploys[change.Name]
+		err := db.SetContainerAsDeactivating(adminDB, oldContainer)
+		if err != nil {
+			return err
+		}
+		go func() {
+			err := kubeOps.RecreateContainer(log, adminDB, zoneRegistry.Zones(), credentialsKey, account, project, oldContainer, newContainer)
+			if err != nil {
+				log.Error(err.Error())
+			}
+		}()
+
+	case "container delete":
+		oldContainer := state.containers[change.Name]
+		err := db.SetContainerAsDeactivating(adminDB, oldContainer)
+		if err != nil {
+			return err
+		}
+		go func() {
+			err := kubeOps.DeleteContainer(log, zoneRegistry.Zones(), project, oldContainer, false)
+			if err != nil {
+				log.Error(err.Error())
+			}
+			err = db.DeleteContainerByProjectAndName(adminDB, project, oldContainer.Name)
+			if err != nil {
+				log.Error(err.Error())
+				return
+			}
+			webhooks.EmitContainer(log, adminDB, project, "container.deleted", oldContainer)
+			err = db.DeleteDeployHookByProjectAndContainerName(adminDB, project, oldContainer.Name)
+			if err != nil {
+				log.Error(err.Error())
+			}
+		}()
+	}
+	return nil
+}
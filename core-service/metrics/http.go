@@ -0,0 +1,76 @@
+package metrics
+
+import (
+	"net/http"
+	"strconv"
+	"time"
+)
+
+// Counts and times the requests a router handles, by the pattern they matched in it. Meant for the routers that
+// have the actual routes, not the ones that only mount others.
+func InstrumentRouter(router string, mux *http.ServeMux) http.Handler {
+	return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		_, route := mux.Handler(r)
+		if route == "" {
+			route = "unmatched"
+		}
+
+		recorder := &statusRecorder{ResponseWriter: w, status: http.StatusOK}
+		started := time.Now()
+		mux.ServeHTTP(recorder, r)
+		HTTPRequestDuration.Observe(time.Since(started).Seconds(), router, route)
+		HTTPRequests.Inc(router, route, strconv.Itoa(recorder.status))
+	})
+}
+
+type statusRecorder struct {
+	http.ResponseWriter
+	status      int
+	wroteHeader bool
+}
+
+func (r *statusRecorder) WriteHeader(status int) {
+	if !r.wroteHeader {
+		r.status = status
+		r.wroteHeader = true
+	}
+	r.ResponseWriter.WriteHeader(status)
+}
+
+// So that http.NewResponseController can still get at Flush, for the handlers that stream
+func (r *statusRecorder) Unwrap() http.ResponseWriter {
+	return r.ResponseWriter
+}
+
+// Counts and times the requests made to a zone's Kubernetes API, for rest.Config.WrapTransport
+func InstrumentKubeTransport(zone string) func(http.RoundTripper) http.RoundTripper {
+	return func(next http.RoundTripper) http.RoundTripper {
+		return roundTripperFunc(func(req *http.Request) (*http.Response, error) {
+			started := time.Now()
+			resp, err := next.RoundTrip(req)
+			took := time.Since(started)
+
+			verb := req.Method
+			watching := req.URL.Query().Get("watch") == "true"
+			if watching {
+				verb = "WATCH"
+			}
+			code := "error"
+			if err == nil {
+				code = strconv.Itoa(resp.StatusCode)
+			}
+			KubeRequests.Inc(zone, verb, code)
+			// a watch takes as long as it's left open, so its time says nothing about the API
+			if !watching {
+				KubeRequestDuration.Observe(took.Seconds(), zone, verb)
+			}
+			return resp, err
+		})
+	}
+}
+
+type roundTripperFunc func(*http.Request) (*http.Response, error)
+
+func (f roundTripperFunc) RoundTrip(req *http.Request) (*http.Response, error) {
+	return f(req)
+}
@@ -0,0 +1,44 @@
+package metrics
+
+import (
+	"time"
+)
+
+var (
+	HTTPRequests        = NewCounter("lcaas_http_requests_total", "HTTP requests handled, by router, route pattern and status code.", "router", "route", "code")
+	HTTPRequestDuration = NewHistogram("lcaas_http_request_duration_seconds", "How long HTTP requests took to handle, by router and route pattern.", DefaultBuckets, "router", "route")
+
+	KubeRequests        = NewCounter("lcaas_kube_api_requests_total", "Requests to the zones' Kubernetes APIs, by zone, verb and status code (\"error\" when there was no response).", "zone", "verb", "code")
+	KubeRequestDuration = NewHistogram("lcaas_kube_api_request_duration_seconds", "How long requests to the zones' Kubernetes APIs took, watches left out.", DefaultBuckets, "zone", "verb")
+
+	ContainerRollbacks = NewCounter("lcaas_container_creation_rollbacks_total", "Half-created containers that got rolled back, by whether the rollback went through.", "result")
+
+	ContainerClaims = NewGauge("lcaas_container_claims", "Container claims that aren't deleted, by status and run type.", "status", "run_type")
+
+	ZoneCapacity    = NewGauge("lcaas_zone_capacity", "What a zone can take, in millicores for CPU and MB for memory.", "zone", "resource")
+	ZoneUsed        = NewGauge("lcaas_zone_used", "What the containers in a zone are charged for, in millicores for CPU and MB for memory.", "zone", "resource")
+	ZoneUtilisation = NewGauge("lcaas_zone_quota_utilisation", "The share of a zone's capacity that's charged to containers, from 0 up.", "zone", "resource")
+
+	DBOpenConnections   = NewGauge("lcaas_db_open_connections", "Connections to the admin DB, by whether they're in use or idle.", "state")
+	DBMaxOpen           = NewGauge("lcaas_db_max_open_connections", "The most connections the admin DB pool opens, 0 for no limit.")
+	DBWaits             = NewCounter("lcaas_db_waits_total", "How often a query had to wait for an admin DB connection.")
+	DBWaitDuration      = NewCounter("lcaas_db_wait_duration_seconds_total", "How long queries waited for admin DB connections altogether.")
+	DBClosedMaxIdle     = NewCounter("lcaas_db_closed_max_idle_total", "Admin DB connections closed for SetMaxIdleConns.")
+	DBClosedMaxLifetime = NewCounter("lcaas_db_closed_max_lifetime_total", "Admin DB connections closed for SetConnMaxLifetime.")
+
+	WorkerRuns        = NewCounter("lcaas_worker_runs_total", "Rounds the background workers went through, by worker and whether they failed.", "worker", "result")
+	WorkerLastRun     = NewGauge("lcaas_worker_last_run_timestamp_seconds", "When the worker last went through a round.", "worker")
+	WorkerLastSuccess = NewGauge("lcaas_worker_last_success_timestamp_seconds", "When the worker last went through a round without failing.", "worker")
+)
+
+// For the background workers to call after every round, so that one that fails or stops can be alerted on
+func WorkerRan(worker string, err error) {
+	now := float64(time.Now().Unix())
+	WorkerLastRun.Set(now, worker)
+	if err != nil {
+		WorkerRuns.Inc(worker, "failed")
+		return
+	}
+	WorkerRuns.Inc(worker, "ok")
+	WorkerLastSuccess.Set(now, worker)
+}
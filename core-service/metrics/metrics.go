@@ -0,0 +1,246 @@
+package metrics
+
+import (
+	"crypto/subtle"
+	"fmt"
+	"io"
+	"math"
+	"net/http"
+	"slices"
+	"strconv"
+	"strings"
+	"sync"
+)
+
+// A small registry that writes the Prometheus text format, which is all we need of the client library.
+// Metrics get made once as package variables (see instruments.go) and registered as they're made.
+
+var (
+	registryMu sync.Mutex
+	registry   []*metricVec
+	onScrape   []func()
+)
+
+var DefaultBuckets = []float64{.005, .01, .025, .05, .1, .25, .5, 1, 2.5, 5, 10}
+
+type metricVec struct {
+	name       string
+	help       string
+	kind       string // counter | gauge | histogram
+	labelNames []string
+	buckets    []float64 // histograms only
+
+	mu     sync.Mutex
+	series map[string]*series // by their label values joined with labelSeparator
+}
+
+type series struct {
+	labelValues []string
+	value       float64 // the count or value, or the sum for histograms
+
+	bucketCounts []uint64 // histograms only, not cumulative
+	count        uint64
+}
+
+const labelSeparator = "\xff"
+
+func newMetricVec(name, help, kind string, buckets []float64, labelNames []string) *metricVec {
+	vec := &metricVec{name: name, help: help, kind: kind, labelNames: labelNames, buckets: buckets, series: map[string]*series{}}
+	registryMu.Lock()
+	registry = append(registry, vec)
+	registryMu.Unlock()
+	return vec
+}
+
+// Has to be called with vec.mu held
+func (vec *metricVec) get(labelValues []string) *series {
+	if len(labelValues) != len(vec.labelNames) {
+		panic(fmt.Sprintf("metric %s takes %v label values, got %v", vec.name, len(vec.labelNames), len(labelValues)))
+	}
+	key := strings.Join(labelValues, labelSeparator)
+	s, ok := vec.series[key]
+	if !ok {
+		s = &series{labelValues: slices.Clone(labelValues)}
+		if vec.kind == "histogram" {
+			s.bucketCounts = make([]uint64, len(vec.buckets))
+		}
+		vec.series[key] = s
+	}
+	return s
+}
+
+func (vec *metricVec) reset() {
+	vec.mu.Lock()
+	vec.series = map[string]*series{}
+	vec.mu.Unlock()
+}
+
+type Counter struct{ vec *metricVec }
+
+func NewCounter(name, help string, labelNames ...string) *Counter {
+	return &Counter{vec: newMetricVec(name, help, "counter", nil, labelNames)}
+}
+
+func (c *Counter) Inc(labelValues ...string) {
+	c.Add(1, labelValues...)
+}
+
+func (c *Counter) Add(v float64, labelValues ...string) {
+	c.vec.mu.Lock()
+	c.vec.get(labelValues).value += v
+	c.vec.mu.Unlock()
+}
+
+// For counts that are kept somewhere else and only read at scrape time, like the DB pool's
+func (c *Counter) Set(v float64, labelValues ...string) {
+	c.vec.mu.Lock()
+	c.vec.get(labelValues).value = v
+	c.vec.mu.Unlock()
+}
+
+type Gauge struct{ vec *metricVec }
+
+func NewGauge(name, help string, labelNames ...string) *Gauge {
+	return &Gauge{vec: newMetricVec(name, help, "gauge", nil, labelNames)}
+}
+
+func (g *Gauge) Set(v float64, labelValues ...string) {
+	g.vec.mu.Lock()
+	g.vec.get(labelValues).value = v
+	g.vec.mu.Unlock()
+}
+
+// Drops every series, for gauges that get filled in from scratch on every scrape
+func (g *Gauge) Reset() {
+	g.vec.reset()
+}
+
+type Histogram struct{ vec *metricVec }
+
+func NewHistogram(name, help string, buckets []float64, labelNames ...string) *Histogram {
+	return &Histogram{vec: newMetricVec(name, help, "histogram", buckets, labelNames)}
+}
+
+func (h *Histogram) Observe(v float64, labelValues ...string) {
+	h.vec.mu.Lock()
+	s := h.vec.get(labelValues)
+	if i, _ := slices.BinarySearch(h.vec.buckets, v); i < len(s.bucketCounts) {
+		s.bucketCounts[i]++
+	}
+	s.value += v
+	s.count++
+	h.vec.mu.Unlock()
+}
+
+// Registers something to run before every scrape, ie. to fill in gauges from the DB
+func OnScrape(f func()) {
+	registryMu.Lock()
+	onScrape = append(onScrape, f)
+	registryMu.Unlock()
+}
+
+// Serves every metric in the Prometheus text format to whoever sends the token as a bearer token. Without a token
+// there's nothing to serve.
+func Handler(token string) http.Handler {
+	return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		if token == "" {
+			http.NotFound(w, r)
+			return
+		}
+		sentToken, _ := strings.CutPrefix(r.Header.Get("Authorization"), "Bearer ")
+		if subtle.ConstantTimeCompare([]byte(sentToken), []byte(token)) != 1 {
+			http.Error(w, "Not authorised, wrong token", http.StatusUnauthorized)
+			return
+		}
+
+		w.Header().Set("Content-Type", "text/plain; version=0.0.4; charset=utf-8")
+		err := WriteTo(w)
+		if err != nil {
+			http.Error(w, "Error writing out metrics", http.StatusInternalServerError)
+		}
+	})
+}
+
+func WriteTo(w io.Writer) error {
+	registryMu.Lock()
+	hooks := slices.Clone(onScrape)
+	vecs := slices.Clone(registry)
+	registryMu.Unlock()
+
+	for _, hook := range hooks {
+		hook()
+	}
+
+	var b strings.Builder
+	for _, vec := range vecs {
+		vec.writeTo(&b)
+	}
+	_, err := io.WriteString(w, b.String())
+	return err
+}
+
+func (vec *metricVec) writeTo(b *strings.Builder) {
+	vec.mu.Lock()
+	defer vec.mu.Unlock()
+
+	fmt.Fprintf(b, "# HELP %s %s\n", vec.name, escapeHelp(vec.help))
+	fmt.Fprintf(b, "# TYPE %s %s\n", vec.name, vec.kind)
+
+	keys := make([]string, 0, len(vec.series))
+	for key := range vec.series {
+		keys = append(keys, key)
+	}
+	slices.Sort(keys)
+
+	for _, key := range keys {
+		s := vec.series[key]
+		if vec.kind != "histogram" {
+			fmt.Fprintf(b, "%s%s %s\n", vec.name, formatLabels(vec.labelNames, s.labelValues), formatValue(s.value))
+			continue
+		}
+
+		cumulative := uint64(0)
+		for i, upperBound := range vec.buckets {
+			cumulative += s.bucketCounts[i]
+			fmt.Fprintf(b, "%s_bucket%s %v\n", vec.name, formatLabels(append(slices.Clone(vec.labelNames), "le"), append(slices.Clone(s.labelValues), formatValue(upperBound))), cumulative)
+		}
+		fmt.Fprintf(b, "%s_bucket%s %v\n", vec.name, formatLabels(append(slices.Clone(vec.labelNames), "le"), append(slices.Clone(s.labelValues), "+Inf")), s.count)
+		fmt.Fprintf(b, "%s_sum%s %s\n", vec.name, formatLabels(vec.labelNames, s.labelValues), formatValue(s.value))
+		fmt.Fprintf(b, "%s_count%s %v\n", vec.name, formatLabels(vec.labelNames, s.labelValues), s.count)
+	}
+}
+
+func formatLabels(names, values []string) string {
+	if len(names) == 0 {
+		return ""
+	}
+	pairs := make([]string, len(names))
+	for i, name := range names {
+		pairs[i] = fmt.Sprintf("%s=\"%s\"", name, escapeLabelValue(values[i]))
+	}
+	return "{" + strings.Join(pairs, ",") + "}"
+}
+
+func formatValue(v float64) string {
+	switch {
+	case math.IsInf(v, 1):
+		return "+Inf"
+	case math.IsInf(v, -1):
+		return "-Inf"
+	case math.IsNaN(v):
+		return "NaN"
+	}
+	return strconv.FormatFloat(v, 'g', -1, 64)
+}
+
+var labelValueEscaper = strings.NewReplacer(`\`, `\\`, "\n", `\n`, `"`, `\"`)
+
+func escapeLabelValue(v string) string {
+	return labelValueEscaper.Replace(v)
+}
+
+var helpEscaper = strings.NewReplacer(`\`, `\\`, "\n", `\n`)
+
+func escapeHelp(help string) string {
+	return helpEscaper.Replace(help)
+}
@@ -10,6 +10,7 @@ import (
 	"github.com/jmoiron/sqlx"
 	"github.com/lu1a/lcaas/core-service/db"
 	"github.com/lu1a/lcaas/core-service/types"
+	"github.com/lu1a/lcaas/core-service/webhooks"
 )
 
 func CreateDatabaseForProject(log log.Logger, adminDB *sqlx.DB, userDBConnections []types.UserDB, project types.Project, userDBClaim types.UserDBClaim) error {
@@ -18,6 +19,7 @@ func CreateDatabaseForProject(log log.Logger, adminDB *sqlx.DB, userDBConnection
 		return err
 	}
 
+	allCreds := types.UserDBClaimCredentials{}
 	for _, userDBConnection := range userDBConnections {
 		if !slices.Contains(userDBClaim.Zones, userDBConnection.Zone) {
 			continue
@@ -60,12 +62,12 @@ func CreateDatabaseForProject(log log.Logger, adminDB *sqlx.DB, userDBConnection
 			return err
 		}
 
-		newCreds := types.Credentials{
+		// each instance got its own password, so keep all of them
+		allCreds = allCreds.WithCredentials(types.Credentials{
 			Username: project.UserDBClaimRWUsername(), Password: generatedPassword, AccessControlType: "rw",
-		}
-		err = db.AddCredentialsToUserDBClaim(adminDB, userDBClaim, types.UserDBClaimCredentials{
-			Credentials: []types.Credentials{newCreds},
+			Zone: userDBConnection.Zone, UserDBID: userDBConnection.ID,
 		})
+		err = db.AddCredentialsToUserDBClaim(adminDB, userDBClaim, allCreds)
 		if err != nil {
 			return err
 		}
@@ -75,10 +77,12 @@ func CreateDatabaseForProject(log log.Logger, adminDB *sqlx.DB, userDBConnection
 	if err != nil {
 		return err
 	}
+	webhooks.EmitDB(log, adminDB, project, "db.created", userDBClaim, project.UserDBClaimRWUsername())
 	return nil
 }
 
 func CreateNewUserForUserDB(log log.Logger, adminDB *sqlx.DB, userDBConnections []types.UserDB, project types.Project, userDBClaim types.UserDBClaim, newUsername string) error {
+	allCreds := userDBClaim.Credentials
 	for _, userDBConnection := range userDBConnections {
 		if !slices.Contains(userDBClaim.Zones, userDBConnection.Zone) {
 			continue
@@ -109,15 +113,55 @@ func CreateNewUserForUserDB(log log.Logger, adminDB *sqlx.DB, userDBConnections
 			return err
 		}
 
-		newCreds := append(userDBClaim.Credentials.Credentials, types.Credentials{
+		newCreds := allCreds.WithCredentials(types.Credentials{
 			Username: newUsername, Password: newUsersGeneratedPassword, AccessControlType: "rw",
+			Zone: userDBConnection.Zone, UserDBID: userDBConnection.ID,
 		})
-		err = db.AddCredentialsToUserDBClaim(adminDB, userDBClaim, types.UserDBClaimCredentials{
-			Credentials: newCreds,
+		allCreds = &newCreds
+		err = db.AddCredentialsToUserDBClaim(adminDB, userDBClaim, newCreds)
+		if err != nil {
+			return err
+		}
+	}
+
+	webhooks.EmitDB(log, adminDB, project, "db.user_created", userDBClaim, newUsername)
+	return nil
+}
+
+// Gives the project's rw user a new password on every instance. Containers bound to the DB only pick it
+// up once kubeOps.RefreshServiceBindings has run.
+func RotateUserDBPassword(log log.Logger, adminDB *sqlx.DB, userDBConnections []types.UserDB, project types.Project, userDBClaim types.UserDBClaim) error {
+	allCreds := userDBClaim.Credentials
+	for _, userDBConnection := range userDBConnections {
+		if !slices.Contains(userDBClaim.Zones, userDBConnection.Zone) {
+			continue
+		}
+		userDB, err := initDatabase(userDBConnection.DefaultParentEnvironmentURL())
+		if err != nil {
+			return err
+		}
+
+		generatedPassword := randSeq(10)
+
+		unsanitaryAlterUserQuery := fmt.Sprintf(`ALTER USER %s WITH PASSWORD '%s'`, project.UserDBClaimRWUsername(), generatedPassword)
+		_, err = userDB.Exec(unsanitaryAlterUserQuery)
+		// not deferred, there's one per zone
+		userDB.Close()
+		if err != nil {
+			return err
+		}
+
+		newCreds := allCreds.WithCredentials(types.Credentials{
+			Username: project.UserDBClaimRWUsername(), Password: generatedPassword, AccessControlType: "rw",
+			Zone: userDBConnection.Zone, UserDBID: userDBConnection.ID,
 		})
+		allCreds = &newCreds
+		// saved straight away, since the old password stopped working as soon as it was altered
+		err = db.AddCredentialsToUserDBClaim(adminDB, userDBClaim, newCreds)
 		if err != nil {
 			return err
 		}
+		log.Info("Rotated DB password", "project", project.Name, "zone", userDBConnection.Zone, "id", userDBConnection.ID)
 	}
 
 	return nil
@@ -172,9 +216,33 @@ func DeleteDatabaseForProject(log log.Logger, adminDB *sqlx.DB, userDBConnection
 	if err != nil {
 		return err
 	}
+	webhooks.EmitDB(log, adminDB, project, "db.deleted", userDBClaim, "")
 	return nil
 }
 
+// How big the project's database is in each of its zones, in bytes
+func GetDatabaseSizes(userDBConnections []types.UserDB, project types.Project, userDBClaim types.UserDBClaim) (sizes map[string]int64, err error) {
+	sizes = map[string]int64{}
+	for _, userDBConnection := range userDBConnections {
+		if !slices.Contains(userDBClaim.Zones, userDBConnection.Zone) {
+			continue
+		}
+		userDB, err := initDatabase(userDBConnection.DefaultParentEnvironmentURL())
+		if err != nil {
+			return sizes, err
+		}
+
+		var size int64
+		err = userDB.Get(&size, `SELECT pg_database_size($1)`, project.UserDBClaimName())
+		userDB.Close()
+		if err != nil {
+			return sizes, fmt.Errorf("Getting the size of the database in zone %s failed: %w", userDBConnection.Zone, err)
+		}
+		sizes[userDBConnection.Zone] = max(sizes[userDBConnection.Zone], size)
+	}
+	return sizes, nil
+}
+
 func initDatabase(connURL string) (db *sqlx.DB, err error) {
 	db, err = sqlx.Connect("postgres", connURL)
 	if err != nil {
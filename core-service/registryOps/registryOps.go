@@ -0,0 +1,497 @@
+package registryOps
+
+import (
+	"crypto/ecdsa"
+	"crypto/sha256"
+	"crypto/x509"
+	"encoding/base64"
+	"encoding/hex"
+	"encoding/json"
+	"encoding/pem"
+	"errors"
+	"fmt"
+	"io"
+	"net"
+	"net/http"
+	"net/url"
+	"os"
+	"path"
+	"slices"
+	"strings"
+	"sync"
+	"time"
+
+	"github.com/lu1a/lcaas/core-service/types"
+	"github.com/lu1a/lcaas/core-service/webhooks"
+)
+
+// For the zones' own registries, which are the operator's and can be anywhere
+var httpClient = &http.Client{Timeout: 15 * time.Second}
+
+// For every other registry, which is one a project picked. Unlike the webhooks' client it follows redirects, as
+// registries send blobs off to their CDNs, but each address it gets sent to is still checked when it's dialled.
+var publicHTTPClient = newPublicHTTPClient(15 * time.Second)
+
+var (
+	internalRegistryHostsMu sync.RWMutex
+	internalRegistryHosts   []string
+)
+
+// Why an image couldn't be verified when it isn't the image being refused, ie. the registry being down or not having it
+var (
+	ErrRegistryUnreachable = errors.New("couldn't reach the registry")
+	ErrImageNotResolved    = errors.New("Couldn't resolve the image to a digest")
+)
+
+var manifestMediaTypes = []string{
+	"application/vnd.oci.image.index.v1+json",
+	"application/vnd.docker.distribution.manifest.list.v2+json",
+	"application/vnd.oci.image.manifest.v1+json",
+	"application/vnd.docker.distribution.manifest.v2+json",
+}
+
+// Admission check for a container claim before it's saved: the image has to pass the operator's allow/deny
+// patterns, its tag gets resolved to a digest, and (if configured) that digest must carry a valid cosign signature.
+// The returned claim has ImageDigest set so that the Deployment can be pinned to exactly what we checked. A claim that
+// comes with its digest already (ie. from a registry's push webhook) keeps it instead of having its tag resolved.
+func VerifyImage(policy types.ImagePolicy, containerClaim types.ContainerClaim) (types.ContainerClaim, error) {
+	name := parseImageName(containerClaim.ImageRef)
+
+	err := checkImageAgainstPolicy(policy, name)
+	if err != nil {
+		return containerClaim, err
+	}
+
+	var digest string
+	if containerClaim.ImageDigest != nil {
+		digest = *containerClaim.ImageDigest
+	} else {
+		digest, err = resolveDigest(name, containerClaim.ImageTag, containerClaim.ImagePullSecret)
+		if err != nil {
+			return containerClaim, fmt.Errorf("%w (%s:%s): %w", ErrImageNotResolved, name, containerClaim.ImageTag, err)
+		}
+	}
+
+	if policy.RequireSignature {
+		err = verifyCosignSignature(policy, name, digest, containerClaim.ImagePullSecret)
+		if err != nil {
+			return containerClaim, fmt.Errorf("Signature check for %s@%s failed: %w", name, digest, err)
+		}
+	}
+
+	containerClaim.ImageDigest = &digest
+	return containerClaim, nil
+}
+
+// What to answer a request with whose image didn't get through VerifyImage
+func VerifyImageStatus(err error) int {
+	switch {
+	case errors.Is(err, ErrRegistryUnreachable):
+		return http.StatusBadGateway
+	case errors.Is(err, ErrImageNotResolved):
+		return http.StatusUnprocessableEntity
+	default:
+		return http.StatusForbidden
+	}
+}
+
+// The zones' registry hosts, which get reached without checking they're public
+func SetInternalRegistryHosts(hosts []string) {
+	internalRegistryHostsMu.Lock()
+	defer internalRegistryHostsMu.Unlock()
+	internalRegistryHosts = hosts
+}
+
+// Just the allow/deny patterns, for images that don't exist yet (ie. ones that are about to be built)
+func CheckImagePolicy(policy types.ImagePolicy, imageRef string) error {
+	return checkImageAgainstPolicy(policy, parseImageName(imageRef))
+}
+
+// Just the tag -> digest lookup, without any of the policy checks
+func ResolveDigest(imageRef, imageTag string, creds *types.ImagePullSecret) (string, error) {
+	return resolveDigest(parseImageName(imageRef), imageTag, creds)
+}
+
+// Logs in to the registry at registryURL the way docker login does, by getting its /v2/ with creds. A registry that
+// lets anyone in passes too.
+func CheckLogin(registryURL string, creds *types.ImagePullSecret) error {
+	host := strings.TrimPrefix(strings.TrimPrefix(registryURL, "https://"), "http://")
+	host, _, _ = strings.Cut(host, "/")
+	if host == "" {
+		return fmt.Errorf("The registry URL is missing")
+	}
+	if host == "docker.io" || host == "index.docker.io" {
+		host = "registry-1.docker.io"
+	}
+	reqURL := "https://" + host + "/v2/"
+	client := clientFor(host)
+
+	res, err := client.Get(reqURL)
+	if err != nil {
+		return fmt.Errorf("Couldn't reach the registry: %w", err)
+	}
+	res.Body.Close()
+	if res.StatusCode == http.StatusOK {
+		return nil
+	}
+	if res.StatusCode != http.StatusUnauthorized {
+		return fmt.Errorf("The registry answered %s, is %s a registry?", res.Status, host)
+	}
+	if !hasCredentials(creds) {
+		return fmt.Errorf("The registry wants credentials but none were given")
+	}
+
+	req, err := http.NewRequest(http.MethodGet, reqURL, nil)
+	if err != nil {
+		return err
+	}
+	challenge := res.Header.Get("WWW-Authenticate")
+	scheme, params := parseAuthChallenge(challenge)
+	switch strings.ToLower(scheme) {
+	case "bearer":
+		token, err := fetchBearerToken(params, imageName{apiHost: host}, creds)
+		if err != nil {
+			return fmt.Errorf("The registry didn't take the credentials: %w", err)
+		}
+		req.Header.Set("Authorization", "Bearer "+token)
+	case "basic":
+		req.Header.Set("Authorization", basicAuthHeader(creds))
+	default:
+		return fmt.Errorf("unsupported registry auth challenge %q", challenge)
+	}
+
+	res, err = client.Do(req)
+	if err != nil {
+		return fmt.Errorf("Couldn't reach the registry: %w", err)
+	}
+	res.Body.Close()
+	if res.StatusCode != http.StatusOK {
+		return fmt.Errorf("The registry didn't take the credentials, it answered %s", res.Status)
+	}
+	return nil
+}
+
+// The repository part of an image ref, ie. "library/nginx" for "nginx" or "my-org/app" for "ghcr.io/my-org/app"
+func RepositoryOf(imageRef string) string {
+	return parseImageName(imageRef).repository
+}
+
+func checkImageAgainstPolicy(policy types.ImagePolicy, name imageName) error {
+	for _, pattern := range policy.Deny {
+		if imageMatchesPattern(name, pattern) {
+			return fmt.Errorf("The image %s is not allowed on this platform (matches deny pattern %q)", name, pattern)
+		}
+	}
+
+	if len(policy.Allow) == 0 {
+		return nil
+	}
+	for _, pattern := range policy.Allow {
+		if imageMatchesPattern(name, pattern) {
+			return nil
+		}
+	}
+	return fmt.Errorf("The image %s is not on this platform's allow list", name)
+}
+
+// patterns are globs on the normalised image name, so "docker.io/library/*" or "ghcr.io/my-org/*"
+func imageMatchesPattern(name imageName, pattern string) bool {
+	matched, err := path.Match(pattern, name.String())
+	if err != nil {
+		return false
+	}
+	if matched {
+		return true
+	}
+	// let "ghcr.io/my-org/**" style patterns match any depth
+	if strings.HasSuffix(pattern, "/**") {
+		return strings.HasPrefix(name.String(), strings.TrimSuffix(pattern, "**"))
+	}
+	return false
+}
+
+// turn whatever users type into the image-ref field into a registry host + repository
+func parseImageName(imageRef string) imageName {
+	ref := strings.TrimPrefix(strings.TrimPrefix(imageRef, "https://"), "http://")
+	ref = strings.TrimSuffix(ref, "/")
+
+	name := imageName{registry: "docker.io", repository: ref}
+	firstPart, rest, found := strings.Cut(ref, "/")
+	if found && (strings.ContainsAny(firstPart, ".:") || firstPart == "localhost") {
+		name.registry = firstPart
+		name.repository = rest
+	}
+
+	name.apiHost = name.registry
+	if name.registry == "docker.io" || name.registry == "index.docker.io" {
+		name.registry = "docker.io"
+		name.apiHost = "registry-1.docker.io"
+		if !strings.Contains(name.repository, "/") {
+			name.repository = "library/" + name.repository
+		}
+	}
+	return name
+}
+
+func resolveDigest(name imageName, tag string, creds *types.ImagePullSecret) (string, error) {
+	if tag == "" {
+		tag = "latest"
+	}
+	manifestURL := fmt.Sprintf("https://%s/v2/%s/manifests/%s", name.apiHost, name.repository, tag)
+
+	res, err := registryRequest(http.MethodHead, manifestURL, name, creds)
+	if err != nil {
+		return "", err
+	}
+	res.Body.Close()
+	if res.StatusCode >= http.StatusInternalServerError {
+		return "", fmt.Errorf("%w, it answered %s", ErrRegistryUnreachable, res.Status)
+	} else if res.StatusCode != http.StatusOK {
+		return "", fmt.Errorf("registry answered %s", res.Status)
+	}
+	if digest := res.Header.Get("Docker-Content-Digest"); digest != "" {
+		return digest, nil
+	}
+
+	// some registries don't bother with the digest header on HEAD, so hash the manifest ourselves
+	res, err = registryRequest(http.MethodGet, manifestURL, name, creds)
+	if err != nil {
+		return "", err
+	}
+	defer res.Body.Close()
+	if res.StatusCode >= http.StatusInternalServerError {
+		return "", fmt.Errorf("%w, it answered %s", ErrRegistryUnreachable, res.Status)
+	} else if res.StatusCode != http.StatusOK {
+		return "", fmt.Errorf("registry answered %s", res.Status)
+	}
+	if digest := res.Header.Get("Docker-Content-Digest"); digest != "" {
+		return digest, nil
+	}
+	body, err := io.ReadAll(res.Body)
+	if err != nil {
+		return "", err
+	}
+	sum := sha256.Sum256(body)
+	return "sha256:" + hex.EncodeToString(sum[:]), nil
+}
+
+// cosign stores signatures as an OCI artifact tagged "sha256-<digest>.sig" next to the image
+func verifyCosignSignature(policy types.ImagePolicy, name imageName, digest string, creds *types.ImagePullSecret) error {
+	publicKey, err := loadCosignPublicKey(policy.CosignPublicKeyPath)
+	if err != nil {
+		return err
+	}
+
+	sigTag := strings.Replace(digest, ":", "-", 1) + ".sig"
+	res, err := registryRequest(http.MethodGet, fmt.Sprintf("https://%s/v2/%s/manifests/%s", name.apiHost, name.repository, sigTag), name, creds)
+	if err != nil {
+		return err
+	}
+	defer res.Body.Close()
+	if res.StatusCode == http.StatusNotFound {
+		return fmt.Errorf("the image isn't signed")
+	} else if res.StatusCode != http.StatusOK {
+		return fmt.Errorf("fetching signature manifest: registry answered %s", res.Status)
+	}
+
+	var sigManifest manifest
+	err = json.NewDecoder(res.Body).Decode(&sigManifest)
+	if err != nil {
+		return err
+	}
+
+	for _, layer := range sigManifest.Layers {
+		sig, err := base64.StdEncoding.DecodeString(layer.Annotations["dev.cosignproject.cosign/signature"])
+		if err != nil || len(sig) == 0 {
+			continue
+		}
+
+		payload, err := fetchBlob(name, layer.Digest, creds)
+		if err != nil {
+			return err
+		}
+		payloadHash := sha256.Sum256(payload)
+		if !ecdsa.VerifyASN1(publicKey, payloadHash[:], sig) {
+			continue
+		}
+
+		var signed cosignPayload
+		err = json.Unmarshal(payload, &signed)
+		if err != nil {
+			continue
+		}
+		if signed.Critical.Image.DockerManifestDigest == digest {
+			return nil
+		}
+	}
+
+	return fmt.Errorf("no signature on the image was made with the trusted key")
+}
+
+func loadCosignPublicKey(keyPath string) (*ecdsa.PublicKey, error) {
+	if keyPath == "" {
+		return nil, fmt.Errorf("signatures are required but no cosign public key is configured")
+	}
+	keyPEM, err := os.ReadFile(keyPath)
+	if err != nil {
+		return nil, err
+	}
+	block, _ := pem.Decode(keyPEM)
+	if block == nil {
+		return nil, fmt.Errorf("cosign public key at %s isn't PEM", keyPath)
+	}
+	key, err := x509.ParsePKIXPublicKey(block.Bytes)
+	if err != nil {
+		return nil, err
+	}
+	ecdsaKey, ok := key.(*ecdsa.PublicKey)
+	if !ok {
+		return nil, fmt.Errorf("only ECDSA cosign keys are supported")
+	}
+	return ecdsaKey, nil
+}
+
+func fetchBlob(name imageName, digest string, creds *types.ImagePullSecret) ([]byte, error) {
+	res, err := registryRequest(http.MethodGet, fmt.Sprintf("https://%s/v2/%s/blobs/%s", name.apiHost, name.repository, digest), name, creds)
+	if err != nil {
+		return nil, err
+	}
+	defer res.Body.Close()
+	if res.StatusCode != http.StatusOK {
+		return nil, fmt.Errorf("fetching blob %s: registry answered %s", digest, res.Status)
+	}
+	return io.ReadAll(io.LimitReader(res.Body, 1<<20))
+}
+
+// Does a request against the registry, going through the token dance if the registry asks for it
+func registryRequest(method, reqURL string, name imageName, creds *types.ImagePullSecret) (*http.Response, error) {
+	req, err := http.NewRequest(method, reqURL, nil)
+	if err != nil {
+		return nil, err
+	}
+	req.Header.Set("Accept", strings.Join(manifestMediaTypes, ", "))
+
+	res, err := name.client().Do(req)
+	if err != nil {
+		return nil, fmt.Errorf("%w: %w", ErrRegistryUnreachable, err)
+	}
+	if res.StatusCode != http.StatusUnauthorized {
+		return res, nil
+	}
+	res.Body.Close()
+
+	challenge := res.Header.Get("WWW-Authenticate")
+	scheme, params := parseAuthChallenge(challenge)
+	switch strings.ToLower(scheme) {
+	case "bearer":
+		token, err := fetchBearerToken(params, name, creds)
+		if err != nil {
+			return nil, err
+		}
+		req.Header.Set("Authorization", "Bearer "+token)
+	case "basic":
+		if !hasCredentials(creds) {
+			return nil, fmt.Errorf("the registry wants credentials but none were given")
+		}
+		req.Header.Set("Authorization", basicAuthHeader(creds))
+	default:
+		return nil, fmt.Errorf("unsupported registry auth challenge %q", challenge)
+	}
+
+	res, err = name.client().Do(req)
+	if err != nil {
+		return nil, fmt.Errorf("%w: %w", ErrRegistryUnreachable, err)
+	}
+	return res, nil
+}
+
+func fetchBearerToken(params map[string]string, name imageName, creds *types.ImagePullSecret) (string, error) {
+	realm := params["realm"]
+	if realm == "" {
+		return "", fmt.Errorf("registry auth challenge has no realm")
+	}
+	scope := params["scope"]
+	if scope == "" && name.repository != "" {
+		scope = fmt.Sprintf("repository:%s:pull", name.repository)
+	}
+
+	query := url.Values{}
+	if scope != "" { // none for just logging in
+		query.Set("scope", scope)
+	}
+	if params["service"] != "" {
+		query.Set("service", params["service"])
+	}
+	req, err := http.NewRequest(http.MethodGet, realm+"?"+query.Encode(), nil)
+	if err != nil {
+		return "", err
+	}
+	if hasCredentials(creds) {
+		req.Header.Set("Authorization", basicAuthHeader(creds))
+	}
+
+	// the realm is the registry's pick, so it's only as trusted as the registry is
+	res, err := name.client().Do(req)
+	if err != nil {
+		return "", fmt.Errorf("%w: %w", ErrRegistryUnreachable, err)
+	}
+	defer res.Body.Close()
+	if res.StatusCode >= http.StatusInternalServerError {
+		return "", fmt.Errorf("%w, its token endpoint answered %s", ErrRegistryUnreachable, res.Status)
+	} else if res.StatusCode != http.StatusOK {
+		return "", fmt.Errorf("registry token endpoint answered %s", res.Status)
+	}
+
+	var t tokenResponse
+	err = json.NewDecoder(res.Body).Decode(&t)
+	if err != nil {
+		return "", err
+	}
+	if t.Token != "" {
+		return t.Token, nil
+	}
+	return t.AccessToken, nil
+}
+
+func newPublicHTTPClient(timeout time.Duration) *http.Client {
+	dialer := &net.Dialer{Timeout: timeout, Control: webhooks.DialPublicOnly}
+	transport := http.DefaultTransport.(*http.Transport).Clone()
+	transport.Proxy = nil
+	transport.DialContext = dialer.DialContext
+	return &http.Client{Timeout: timeout, Transport: transport}
+}
+
+func clientFor(host string) *http.Client {
+	internalRegistryHostsMu.RLock()
+	defer internalRegistryHostsMu.RUnlock()
+	if slices.Contains(internalRegistryHosts, host) {
+		return httpClient
+	}
+	return publicHTTPClient
+}
+
+// parses `Bearer realm="https://auth.docker.io/token",service="registry.docker.io"`
+func parseAuthChallenge(challenge string) (scheme string, params map[string]string) {
+	params = map[string]string{}
+	scheme, rest, _ := strings.Cut(challenge, " ")
+	for _, part := range strings.Split(rest, ",") {
+		key, value, found := strings.Cut(strings.TrimSpace(part), "=")
+		if !found {
+			continue
+		}
+		params[strings.ToLower(key)] = strings.Trim(value, `"`)
+	}
+	return scheme, params
+}
+
+func hasCredentials(creds *types.ImagePullSecret) bool {
+	return creds != nil && ((creds.Username != "" && creds.Password != "") || creds.Token != "")
+}
+
+// same rules as the image pull secret we create in kubeOps: a lone token is already the base64 "auth" value
+func basicAuthHeader(creds *types.ImagePullSecret) string {
+	if creds.Username == "" || creds.Password == "" {
+		return "Basic " + creds.Token
+	}
+	return "Basic " + base64.StdEncoding.EncodeToString([]byte(creds.Username+":"+creds.Password))
+}
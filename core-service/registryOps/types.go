@@ -0,0 +1,44 @@
+package registryOps
+
+import "net/http"
+
+// An image reference split into the parts the registry v2 API cares about
+type imageName struct {
+	registry   string // as written by the user, ie. "docker.io"
+	apiHost    string // where the v2 API actually lives, ie. "registry-1.docker.io"
+	repository string // ie. "library/nginx"
+}
+
+func (n imageName) String() string {
+	return n.registry + "/" + n.repository
+}
+
+func (n imageName) client() *http.Client {
+	return clientFor(n.apiHost)
+}
+
+type tokenResponse struct {
+	Token       string `json:"token"`
+	AccessToken string `json:"access_token"`
+}
+
+type manifest struct {
+	MediaType string          `json:"mediaType"`
+	Layers    []manifestLayer `json:"layers"`
+}
+
+type manifestLayer struct {
+	MediaType   string            `json:"mediaType"`
+	Digest      string            `json:"digest"`
+	Annotations map[string]string `json:"annotations"`
+}
+
+// The "simple signing" payload that cosign signs
+type cosignPayload struct {
+	Critical struct {
+		Image struct {
+			DockerManifestDigest string `json:"docker-manifest-digest"`
+		} `json:"image"`
+		Type string `json:"type"`
+	} `json:"critical"`
+}
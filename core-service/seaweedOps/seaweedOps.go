@@ -1,11 +1,307 @@
 package seaweedOps
 
-// Create a bucket as "me", the admin, because seaweed doesn't have separate sub-users or IAM
-func CreateBucket() {
-	// TODO: makey bucket with the project's name and ID
+import (
+	"bytes"
+	"crypto/hmac"
+	"crypto/sha256"
+	"encoding/hex"
+	"encoding/json"
+	"encoding/xml"
+	"fmt"
+	"io"
+	"net/http"
+	"net/url"
+	"slices"
+	"strings"
+	"time"
+
+	"github.com/charmbracelet/log"
+	"github.com/jmoiron/sqlx"
+	"github.com/lu1a/lcaas/core-service/db"
+	"github.com/lu1a/lcaas/core-service/types"
+)
+
+var httpClient = &http.Client{Timeout: 15 * time.Second}
+
+// Create a bucket as "me", the admin. Tenants only ever get keys scoped to their own buckets, see EnsureBucketCredentials.
+func CreateBucket(connection types.ObjectStorageConnection, bucketName string) error {
+	res, body, err := signedRequest(connection, "s3", http.MethodPut, strings.TrimSuffix(connection.S3Endpoint, "/")+"/"+bucketName, nil)
+	if err != nil {
+		return err
+	}
+	if res.StatusCode == http.StatusConflict { // already there, and the name has the project in it so it's ours
+		return nil
+	}
+	if res.StatusCode != http.StatusOK {
+		return fmt.Errorf("Creating bucket %s in %s failed: %s", bucketName, connection.Zone, describeError(res, body))
+	}
+	return nil
 }
 
 // We want to replicate the s3 API provided by seaweed s3 without rewriting and proxying every single indivudal file CRUD operation
 func ProxyOperationToBucket() {
 	// TODO: In general just a check here that the user owns the bucket they're trying to mess with
 }
+
+// Makes sure the bucket exists in each of its zones, with an IAM user that may only touch that bucket and
+// an access key for it. Zones that already have a key are left alone. The keys come back decrypted.
+func EnsureBucketCredentials(log log.Logger, adminDB *sqlx.DB, credentialsKey []byte, connections []types.ObjectStorageConnection, objectStorage types.ObjectStorageClaim) (types.ObjectStorageClaim, error) {
+	allCreds := types.ObjectStorageClaimCredentials{}
+	if objectStorage.Credentials != nil {
+		allCreds = *objectStorage.Credentials
+	}
+
+	changed := false
+	for _, connection := range connections {
+		if !slices.Contains(objectStorage.Zones, connection.Zone) {
+			continue
+		}
+		if _, found := objectStorage.CredentialsForZone(connection.Zone); found {
+			continue
+		}
+
+		err := CreateBucket(connection, objectStorage.BucketName())
+		if err != nil {
+			return objectStorage, err
+		}
+		err = createBucketUser(connection, objectStorage.BucketName())
+		if err != nil {
+			return objectStorage, err
+		}
+		newCreds, err := createAccessKey(connection, objectStorage.BucketName())
+		if err != nil {
+			return objectStorage, err
+		}
+		allCreds.Credentials = append(allCreds.Credentials, newCreds)
+		changed = true
+		log.Info("Created bucket credentials", "bucket", objectStorage.BucketName(), "zone", connection.Zone)
+	}
+
+	if changed {
+		objectStorage.Credentials = &allCreds
+		err := saveBucketCredentials(adminDB, credentialsKey, objectStorage)
+		if err != nil {
+			return objectStorage, err
+		}
+	}
+	err := objectStorage.OpenCredentials(credentialsKey)
+	if err != nil {
+		return objectStorage, err
+	}
+	return objectStorage, nil
+}
+
+// Gives the bucket a new access key in every zone, and hands back the old ones. Those keep working until they're
+// deleted with DeleteBucketAccessKeys, which should only be once the containers bound to the bucket got the new ones
+// from kubeOps.RefreshServiceBindings.
+func RotateBucketCredentials(log log.Logger, adminDB *sqlx.DB, credentialsKey []byte, connections []types.ObjectStorageConnection, objectStorage types.ObjectStorageClaim) (types.ObjectStorageClaim, []types.ObjectStorageCredentials, error) {
+	if objectStorage.Credentials == nil {
+		objectStorage, err := EnsureBucketCredentials(log, adminDB, credentialsKey, connections, objectStorage)
+		return objectStorage, nil, err
+	}
+
+	allCreds := types.ObjectStorageClaimCredentials{}
+	rotatedCreds := []types.ObjectStorageCredentials{}
+	for _, oldCreds := range objectStorage.Credentials.Credentials {
+		connectionIndex := slices.IndexFunc(connections, func(c types.ObjectStorageConnection) bool { return c.Zone == oldCreds.Zone })
+		if connectionIndex == -1 {
+			allCreds.Credentials = append(allCreds.Credentials, oldCreds)
+			continue
+		}
+		connection := connections[connectionIndex]
+
+		newCreds, err := createAccessKey(connection, objectStorage.BucketName())
+		if err != nil {
+			return objectStorage, nil, err
+		}
+		allCreds.Credentials = append(allCreds.Credentials, newCreds)
+		rotatedCreds = append(rotatedCreds, oldCreds)
+		log.Info("Rotated bucket credentials", "bucket", objectStorage.BucketName(), "zone", connection.Zone)
+	}
+
+	objectStorage.Credentials = &allCreds
+	err := saveBucketCredentials(adminDB, credentialsKey, objectStorage)
+	if err != nil {
+		return objectStorage, nil, err
+	}
+	return objectStorage, rotatedCreds, nil
+}
+
+// Deletes the access keys RotateBucketCredentials swapped out
+func DeleteBucketAccessKeys(log log.Logger, connections []types.ObjectStorageConnection, objectStorage types.ObjectStorageClaim, oldCreds []types.ObjectStorageCredentials) {
+	for _, creds := range oldCreds {
+		connectionIndex := slices.IndexFunc(connections, func(c types.ObjectStorageConnection) bool { return c.Zone == creds.Zone })
+		if connectionIndex == -1 {
+			continue
+		}
+		err := deleteAccessKey(connections[connectionIndex], objectStorage.BucketName(), creds.AccessKeyID)
+		if err != nil {
+			log.Error("Couldn't delete old bucket access key", "bucket", objectStorage.BucketName(), "zone", creds.Zone, "err", err)
+		}
+	}
+}
+
+func saveBucketCredentials(adminDB *sqlx.DB, credentialsKey []byte, objectStorage types.ObjectStorageClaim) error {
+	err := objectStorage.SealCredentials(credentialsKey)
+	if err != nil {
+		return err
+	}
+	return db.SetObjectStorageCredentials(adminDB, objectStorage)
+}
+
+func createBucketUser(connection types.ObjectStorageConnection, bucketName string) error {
+	_, err := iamRequest(connection, url.Values{"Action": {"CreateUser"}, "UserName": {bucketName}})
+	if err != nil && !strings.Contains(err.Error(), "EntityAlreadyExists") {
+		return err
+	}
+
+	policy, err := json.Marshal(bucketPolicy{
+		Version: "2012-10-17",
+		Statement: []bucketPolicyStatement{{
+			Effect:   "Allow",
+			Action:   []string{"s3:*"},
+			Resource: []string{"arn:aws:s3:::" + bucketName, "arn:aws:s3:::" + bucketName + "/*"},
+		}},
+	})
+	if err != nil {
+		return err
+	}
+	_, err = iamRequest(connection, url.Values{
+		"Action":         {"PutUserPolicy"},
+		"UserName":       {bucketName},
+		"PolicyName":     {bucketName + "-only"},
+		"PolicyDocument": {string(policy)},
+	})
+	return err
+}
+
+func createAccessKey(connection types.ObjectStorageConnection, bucketName string) (types.ObjectStorageCredentials, error) {
+	body, err := iamRequest(connection, url.Values{"Action": {"CreateAccessKey"}, "UserName": {bucketName}})
+	if err != nil {
+		return types.ObjectStorageCredentials{}, err
+	}
+
+	var res createAccessKeyResponse
+	err = xml.Unmarshal(body, &res)
+	if err != nil {
+		return types.ObjectStorageCredentials{}, err
+	}
+	if res.AccessKey.AccessKeyID == "" || res.AccessKey.SecretAccessKey == "" {
+		return types.ObjectStorageCredentials{}, fmt.Errorf("Seaweed in %s didn't hand back an access key for %s", connection.Zone, bucketName)
+	}
+
+	return types.ObjectStorageCredentials{
+		Zone:            connection.Zone,
+		AccessKeyID:     res.AccessKey.AccessKeyID,
+		SecretAccessKey: res.AccessKey.SecretAccessKey,
+	}, nil
+}
+
+func deleteAccessKey(connection types.ObjectStorageConnection, bucketName, accessKeyID string) error {
+	_, err := iamRequest(connection, url.Values{"Action": {"DeleteAccessKey"}, "UserName": {bucketName}, "AccessKeyId": {accessKeyID}})
+	return err
+}
+
+func iamRequest(connection types.ObjectStorageConnection, params url.Values) ([]byte, error) {
+	params.Set("Version", "2010-05-08")
+	res, body, err := signedRequest(connection, "iam", http.MethodPost, strings.TrimSuffix(connection.IAMEndpoint, "/")+"/", []byte(params.Encode()))
+	if err != nil {
+		return nil, err
+	}
+	if res.StatusCode != http.StatusOK {
+		return nil, fmt.Errorf("Seaweed IAM %s in %s failed: %s", params.Get("Action"), connection.Zone, describeError(res, body))
+	}
+	return body, nil
+}
+
+func describeError(res *http.Response, body []byte) string {
+	var errRes errorResponse
+	if xml.Unmarshal(body, &errRes) == nil && errRes.Code != "" {
+		return fmt.Sprintf("%s: %s", errRes.Code, errRes.Message)
+	}
+	return res.Status
+}
+
+// Does a request signed with AWS signature v4, which is what both seaweed's S3 and IAM APIs expect
+func signedRequest(connection types.ObjectStorageConnection, service, method, reqURL string, body []byte) (*http.Response, []byte, error) {
+	req, err := http.NewRequest(method, reqURL, bytes.NewReader(body))
+	if err != nil {
+		return nil, nil, err
+	}
+	if body != nil {
+		req.Header.Set("Content-Type", "application/x-www-form-urlencoded")
+	}
+	signRequest(req, body, connection, service, time.Now())
+
+	res, err := httpClient.Do(req)
+	if err != nil {
+		return nil, nil, err
+	}
+	defer res.Body.Close()
+	resBody, err := io.ReadAll(io.LimitReader(res.Body, 1<<20))
+	if err != nil {
+		return nil, nil, err
+	}
+	return res, resBody, nil
+}
+
+func signRequest(req *http.Request, body []byte, connection types.ObjectStorageConnection, service string, now time.Time) {
+	region := connection.Region
+	if region == "" {
+		region = "us-east-1"
+	}
+	amzDate := now.UTC().Format("20060102T150405Z")
+	date := amzDate[:8]
+	payloadHash := sha256Hex(body)
+
+	req.Header.Set("X-Amz-Date", amzDate)
+	req.Header.Set("X-Amz-Content-Sha256", payloadHash)
+
+	headers := map[string]string{
+		"host":                 req.URL.Host,
+		"x-amz-content-sha256": payloadHash,
+		"x-amz-date":           amzDate,
+	}
+	if contentType := req.Header.Get("Content-Type"); contentType != "" {
+		headers["content-type"] = contentType
+	}
+	headerNames := []string{}
+	for name := range headers {
+		headerNames = append(headerNames, name)
+	}
+	slices.Sort(headerNames)
+	canonicalHeaders := ""
+	for _, name := range headerNames {
+		canonicalHeaders += name + ":" + strings.TrimSpace(headers[name]) + "\n"
+	}
+	signedHeaders := strings.Join(headerNames, ";")
+
+	canonicalURI := req.URL.EscapedPath()
+	if canonicalURI == "" {
+		canonicalURI = "/"
+	}
+	canonicalQuery := strings.ReplaceAll(req.URL.Query().Encode(), "+", "%20")
+
+	canonicalRequest := strings.Join([]string{req.Method, canonicalURI, canonicalQuery, canonicalHeaders, signedHeaders, payloadHash}, "\n")
+	scope := strings.Join([]string{date, region, service, "aws4_request"}, "/")
+	stringToSign := strings.Join([]string{"AWS4-HMAC-SHA256", amzDate, scope, sha256Hex([]byte(canonicalRequest))}, "\n")
+
+	signingKey := hmacSHA256([]byte("AWS4"+connection.SecretAccessKey), date)
+	signingKey = hmacSHA256(signingKey, region)
+	signingKey = hmacSHA256(signingKey, service)
+	signingKey = hmacSHA256(signingKey, "aws4_request")
+	signature := hex.EncodeToString(hmacSHA256(signingKey, stringToSign))
+
+	req.Header.Set("Authorization", fmt.Sprintf("AWS4-HMAC-SHA256 Credential=%s/%s, SignedHeaders=%s, Signature=%s", connection.AccessKeyID, scope, signedHeaders, signature))
+}
+
+func sha256Hex(data []byte) string {
+	sum := sha256.Sum256(data)
+	return hex.EncodeToString(sum[:])
+}
+
+func hmacSHA256(key []byte, data string) []byte {
+	mac := hmac.New(sha256.New, key)
+	mac.Write([]byte(data))
+	return mac.Sum(nil)
+}
@@ -0,0 +1,28 @@
+package seaweedOps
+
+import "encoding/xml"
+
+// What seaweed's IAM API answers to CreateAccessKey, same shape as AWS IAM
+type createAccessKeyResponse struct {
+	XMLName   xml.Name `xml:"CreateAccessKeyResponse"`
+	AccessKey struct {
+		AccessKeyID     string `xml:"AccessKeyId"`
+		SecretAccessKey string `xml:"SecretAccessKey"`
+	} `xml:"CreateAccessKeyResult>AccessKey"`
+}
+
+type errorResponse struct {
+	Code    string `xml:"Error>Code"`
+	Message string `xml:"Error>Message"`
+}
+
+type bucketPolicy struct {
+	Version   string                  `json:"Version"`
+	Statement []bucketPolicyStatement `json:"Statement"`
+}
+
+type bucketPolicyStatement struct {
+	Effect   string   `json:"Effect"`
+	Action   []string `json:"Action"`
+	Resource []string `json:"Resource"`
+}
@@ -13,14 +13,26 @@ import (
 
 	"github.com/charmbracelet/log"
 	"github.com/jmoiron/sqlx"
+	"github.com/lu1a/lcaas/core-service/alerting"
 	"github.com/lu1a/lcaas/core-service/api"
 	"github.com/lu1a/lcaas/core-service/db"
+	"github.com/lu1a/lcaas/core-service/deployHooks"
 	"github.com/lu1a/lcaas/core-service/frontend"
+	"github.com/lu1a/lcaas/core-service/imageBuilds"
+	"github.com/lu1a/lcaas/core-service/internalRegistry"
 	"github.com/lu1a/lcaas/core-service/kubeOps"
+	"github.com/lu1a/lcaas/core-service/metrics"
 	middleware "github.com/lu1a/lcaas/core-service/middleware/auth"
 	"github.com/lu1a/lcaas/core-service/types"
+	"github.com/lu1a/lcaas/core-service/webhooks"
 )
 
+// when the nightly usage reconciliation runs, in the server's local time
+const usageReconciliationHour = 3
+
+// how often the projects get charged for their claims, metering periods line up with it
+const billingMeterInterval = time.Hour
+
 type Service struct {
 	config            types.Config
 	log               log.Logger
@@ -32,7 +44,7 @@ type Service struct {
 	db  *sqlx.DB
 	API *http.Server
 
-	kubeClients []types.ContainerZone
+	zoneRegistry *kubeOps.ZoneRegistry
 }
 
 func New(config types.Config, log log.Logger) *Service {
@@ -55,9 +67,17 @@ func (s *Service) Start() (context.Context, error) {
 	if err := s.initDatabase(); err != nil {
 		return nil, startError(err)
 	}
-	if err := s.initKubeClients(); err != nil {
+	s.registerMetrics()
+	if err := s.initZoneRegistry(closeCtx); err != nil {
 		return nil, startError(err)
 	}
+	s.startUsageReconciliation(closeCtx)
+	s.startBillingMeter(closeCtx)
+	s.startAlertEvaluator(closeCtx)
+	s.startWebhookDispatcher(closeCtx)
+	s.startDeployHookPoller(closeCtx)
+	s.startBuildWatcher(closeCtx)
+	s.startRegistryManager(closeCtx)
 	if err := s.startAPI(); err != nil {
 		return nil, startError(err)
 	}
@@ -73,31 +93,270 @@ func (s *Service) initDatabase() (err error) {
 	return nil
 }
 
-func (s *Service) initKubeClients() (err error) {
-	clients, err := kubeOps.InitialiseKubeClients(s.config.KubeClients)
+// The zones' clusters get connected in the background, so one that's down doesn't keep the service from starting.
+// Their capacity and the containers' actual usage get collected in the background too.
+func (s *Service) initZoneRegistry(ctx context.Context) (err error) {
+	err = db.InitialiseContainerZones(s.db, s.config.KubeClients)
+	if err != nil {
+		return err
+	}
+	err = db.InitialiseUserDBZones(s.db, s.config.UserDBConnections)
 	if err != nil {
 		return err
 	}
-	s.kubeClients = clients
 
-	err = db.InitialiseContainerZones(s.db, s.kubeClients)
+	s.zoneRegistry = kubeOps.NewZoneRegistry(s.log, s.db, s.config.ObjectStorageConnections)
+	err = s.zoneRegistry.Reload()
 	if err != nil {
 		return err
 	}
+	s.wg.Add(1)
+	go func() {
+		defer s.wg.Done()
+		s.zoneRegistry.Run(ctx)
+	}()
+
+	capacityCollector := kubeOps.NewCapacityCollector(s.log, s.db, s.zoneRegistry, s.config.CapacityReserve)
+	s.wg.Add(1)
+	go func() {
+		defer s.wg.Done()
+		capacityCollector.Run(ctx)
+	}()
+
+	usageSampler := kubeOps.NewUsageSampler(s.log, s.db, s.zoneRegistry)
+	s.wg.Add(1)
+	go func() {
+		defer s.wg.Done()
+		usageSampler.Run(ctx)
+	}()
 
 	return nil
 }
 
+// Every night the resource usages get recomputed from the live claims, in case something charged or gave back
+// usage it shouldn't have. Whatever was out of step gets corrected, logged and kept in container_resource_usage_drift.
+func (s *Service) startUsageReconciliation(ctx context.Context) {
+	s.wg.Add(1)
+	go func() {
+		defer s.wg.Done()
+		for {
+			now := time.Now()
+			nextRun := time.Date(now.Year(), now.Month(), now.Day(), usageReconciliationHour, 0, 0, 0, now.Location())
+			if !nextRun.After(now) {
+				nextRun = nextRun.AddDate(0, 0, 1)
+			}
+
+			select {
+			case <-ctx.Done():
+				return
+			case <-time.After(time.Until(nextRun)):
+			}
+
+			drifts, err := db.ReconcileResourceUsage(s.db)
+			metrics.WorkerRan("usage_reconciliation", err)
+			if err != nil {
+				s.log.Error("Reconciling resource usage failed", "err", err)
+				continue
+			}
+			for _, drift := range drifts {
+				s.log.Warn("Resource usage was out of step with the claims, corrected it", "zone", drift.ZoneName, "account_id", drift.AccountID,
+					"recorded_cpu_millicores", drift.RecordedCPUMilliCores, "actual_cpu_millicores", drift.ActualCPUMilliCores,
+					"recorded_memory_mb", drift.RecordedMemoryMB, "actual_memory_mb", drift.ActualMemoryMB)
+			}
+			s.log.Info("Reconciled resource usage", "drifted", len(drifts))
+		}
+	}()
+}
+
+// Every billingMeterInterval each project gets charged for what its claims used since the last round, gets its
+// monthly credits if it doesn't have them yet, and gets suspended or let off as the billing policy says.
+func (s *Service) startBillingMeter(ctx context.Context) {
+	s.wg.Add(1)
+	go func() {
+		defer s.wg.Done()
+		for {
+			nextRun := time.Now().Truncate(billingMeterInterval).Add(billingMeterInterval)
+
+			select {
+			case <-ctx.Done():
+				return
+			case <-time.After(time.Until(nextRun)):
+			}
+
+			metrics.WorkerRan("billing_meter", s.meterProjects(nextRun))
+		}
+	}()
+}
+
+// Returns what went wrong along the way, projects that failed don't keep the rest from getting metered
+func (s *Service) meterProjects(until time.Time) error {
+	projects, err := db.GetAllProjects(s.db)
+	if err != nil {
+		s.log.Error("Metering projects failed", "err", err)
+		return err
+	}
+
+	policy := s.config.BillingPolicy
+	month := time.Date(until.Year(), until.Month(), 1, 0, 0, 0, 0, until.Location())
+	var errs []error
+	for _, project := range projects {
+		usage, err := db.MeterProjectUsage(s.db, project, until, policy)
+		if err != nil {
+			s.log.Error("Metering project usage failed", "project_id", project.ProjectID, "err", err)
+			errs = append(errs, err)
+			continue
+		}
+		if usage.BillingID != 0 {
+			s.log.Debug("Metered project usage", "project_id", project.ProjectID, "credits", usage.CreditsDelta, "balance", usage.CurrentCredits)
+		}
+
+		monthly, err := db.AddMonthlyCredits(s.db, project, month, policy)
+		if err != nil {
+			s.log.Error("Adding monthly credits failed", "project_id", project.ProjectID, "err", err)
+			errs = append(errs, err)
+			continue
+		}
+		if monthly.BillingID != 0 {
+			s.log.Info("Added monthly credits", "project_id", project.ProjectID, "kind", monthly.Kind, "credits", monthly.CreditsDelta)
+		}
+
+		wasSuspended := project.SuspendedAt != nil
+		project, err = db.ApplySuspensionPolicy(s.db, project, policy.Suspension, until)
+		if err != nil {
+			s.log.Error("Applying the suspension policy failed", "project_id", project.ProjectID, "err", err)
+			errs = append(errs, err)
+			continue
+		}
+		if !wasSuspended && project.SuspendedAt != nil {
+			s.log.Warn("Suspended project for running out of credits", "project_id", project.ProjectID, "name", project.Name)
+		} else if wasSuspended && project.SuspendedAt == nil {
+			s.log.Info("Lifted project suspension", "project_id", project.ProjectID, "name", project.Name)
+		}
+	}
+
+	return errors.Join(errs...)
+}
+
+// Every project's alert rules get evaluated in the background, see alerting.Evaluator
+func (s *Service) startAlertEvaluator(ctx context.Context) {
+	evaluator := alerting.NewEvaluator(s.log, s.db, s.zoneRegistry)
+	s.wg.Add(1)
+	go func() {
+		defer s.wg.Done()
+		evaluator.Run(ctx)
+	}()
+}
+
+func (s *Service) startWebhookDispatcher(ctx context.Context) {
+	dispatcher := webhooks.NewDispatcher(s.log, s.db)
+	s.wg.Add(1)
+	go func() {
+		defer s.wg.Done()
+		dispatcher.Run(ctx)
+	}()
+}
+
+func (s *Service) startDeployHookPoller(ctx context.Context) {
+	poller := deployHooks.NewPoller(s.log, s.db, s.zoneRegistry, s.config.ImagePolicy, s.config.CredentialsKey)
+	s.wg.Add(1)
+	go func() {
+		defer s.wg.Done()
+		poller.Run(ctx)
+	}()
+}
+
+func (s *Service) startBuildWatcher(ctx context.Context) {
+	watcher := imageBuilds.NewWatcher(s.log, s.db, s.zoneRegistry, s.config.ImagePolicy, s.config.RegistryPolicy, s.config.CredentialsKey)
+	s.wg.Add(1)
+	go func() {
+		defer s.wg.Done()
+		watcher.Run(ctx)
+	}()
+}
+
+func (s *Service) startRegistryManager(ctx context.Context) {
+	if !s.config.RegistryPolicy.Enabled() {
+		return
+	}
+	manager := internalRegistry.NewManager(s.log, s.db, s.zoneRegistry, s.config.RegistryPolicy)
+	s.wg.Add(1)
+	go func() {
+		defer s.wg.Done()
+		manager.Run(ctx)
+	}()
+}
+
+// Fills in what's read from the DB on every scrape, on top of what gets counted as it happens
+func (s *Service) registerMetrics() {
+	metrics.OnScrape(func() {
+		stats := s.db.Stats()
+		metrics.DBOpenConnections.Set(float64(stats.InUse), "in_use")
+		metrics.DBOpenConnections.Set(float64(stats.Idle), "idle")
+		metrics.DBMaxOpen.Set(float64(stats.MaxOpenConnections))
+		metrics.DBWaits.Set(float64(stats.WaitCount))
+		metrics.DBWaitDuration.Set(stats.WaitDuration.Seconds())
+		metrics.DBClosedMaxIdle.Set(float64(stats.MaxIdleClosed))
+		metrics.DBClosedMaxLifetime.Set(float64(stats.MaxLifetimeClosed))
+	})
+
+	metrics.OnScrape(func() {
+		counts, err := db.GetContainerClaimCounts(s.db)
+		if err != nil {
+			s.log.Error("Collecting container claim metrics failed", "err", err)
+			return
+		}
+		metrics.ContainerClaims.Reset()
+		for _, count := range counts {
+			metrics.ContainerClaims.Set(float64(count.Count), count.Status, count.RunType)
+		}
+	})
+
+	metrics.OnScrape(func() {
+		usages, err := db.GetZoneUsages(s.db)
+		if err != nil {
+			s.log.Error("Collecting zone usage metrics failed", "err", err)
+			return
+		}
+		metrics.ZoneCapacity.Reset()
+		metrics.ZoneUsed.Reset()
+		metrics.ZoneUtilisation.Reset()
+		for _, usage := range usages {
+			metrics.ZoneCapacity.Set(float64(usage.CPUMilliCores), usage.ZoneName, "cpu")
+			metrics.ZoneCapacity.Set(float64(usage.MemoryMB), usage.ZoneName, "memory")
+			metrics.ZoneUsed.Set(float64(usage.UsedCPUMilliCores), usage.ZoneName, "cpu")
+			metrics.ZoneUsed.Set(float64(usage.UsedMemoryMB), usage.ZoneName, "memory")
+			if usage.CPUMilliCores > 0 {
+				metrics.ZoneUtilisation.Set(float64(usage.UsedCPUMilliCores)/float64(usage.CPUMilliCores), usage.ZoneName, "cpu")
+			}
+			if usage.MemoryMB > 0 {
+				metrics.ZoneUtilisation.Set(float64(usage.UsedMemoryMB)/float64(usage.MemoryMB), usage.ZoneName, "memory")
+			}
+		}
+	})
+}
+
 func (s *Service) startAPI() (err error) {
 	log.Info("Starting server..")
 
 	r := http.NewServeMux()
 
-	r.Handle("/api/", http.StripPrefix("/api", api.APIRouter(s.log, s.db, s.kubeClients, s.config)))
+	r.Handle("/api/", http.StripPrefix("/api", api.APIRouter(s.log, s.db, s.zoneRegistry, s.config)))
+
+	r.Handle("/", metrics.InstrumentRouter("frontend", frontend.FrontendRouter(s.log, s.db, s.zoneRegistry, s.config)))
 
-	r.Handle("/", frontend.FrontendRouter(s.log, s.db, s.kubeClients, s.config))
+	// scraped with METRICS_TOKEN instead of an account, so it sits in front of the auth middleware
+	root := http.NewServeMux()
+	root.Handle("GET /metrics", metrics.Handler(s.config.MetricsToken))
+	// the hooks' tokens are their auth
+	root.Handle("POST /deploy-hook/{token}", metrics.InstrumentRouter("deploy-hooks", deployHooks.DeployHookRouter(s.log, s.db, s.zoneRegistry, s.config)))
+	// fetched by the builds' jobs, with a token that only works while the build's going
+	root.Handle("GET /build-context/{token}", metrics.InstrumentRouter("build-contexts", imageBuilds.ContextRouter(s.log, s.db)))
+	if s.config.RegistryPolicy.Enabled() {
+		root.Handle("GET /registry-token", metrics.InstrumentRouter("registry-tokens", internalRegistry.TokenRouter(s.log, s.db, s.zoneRegistry, s.config.RegistryPolicy, s.config.CredentialsKey)))
+	}
+	root.Handle("/", middleware.AuthMiddleware(r, s.db))
 
-	if s.API, err = s.initHTTPServer(r); err != nil {
+	if s.API, err = s.initHTTPServer(root); err != nil {
 		return err
 	}
 	return nil
@@ -111,7 +370,7 @@ func (s *Service) initHTTPServer(r *http.ServeMux) (*http.Server, error) {
 
 	serv := &http.Server{
 		Addr:    s.config.ListenURL,
-		Handler: middleware.AuthMiddleware(r, s.db),
+		Handler: r,
 	}
 
 	s.wg.Add(1)
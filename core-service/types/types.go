@@ -1,15 +1,29 @@
 package types
 
 import (
+	"bytes"
+	"crypto/aes"
+	"crypto/cipher"
+	"crypto/rand"
+	"database/sql/driver"
+	"encoding/csv"
+	"encoding/hex"
 	"encoding/json"
 	"fmt"
+	"io"
+	"net"
 	"net/http"
+	"net/netip"
+	"net/url"
+	"slices"
 	"strconv"
 	"strings"
 	"time"
 
 	"github.com/lib/pq"
+	"k8s.io/client-go/informers"
 	"k8s.io/client-go/kubernetes"
+	"sigs.k8s.io/yaml"
 )
 
 type Config struct {
@@ -24,12 +38,142 @@ type Config struct {
 	KubeClients []ContainerZone
 
 	UserDBConnections []UserDB
+
+	ObjectStorageConnections []ObjectStorageConnection
+
+	ImagePolicy ImagePolicy
+
+	CapacityReserve CapacityReserve
+
+	BillingPolicy BillingPolicy
+
+	BuildPolicy BuildPolicy
+
+	RegistryPolicy RegistryPolicy
+
+	CredentialsKey []byte // AES-256 key the projects' stored pull credentials, registry logins and bucket keys are encrypted with, only optional without registries or object storage
+
+	MetricsToken string // what Prometheus has to send as a bearer token to scrape /metrics
+
+	PrometheusURLs map[string]string // by zone name, optional, for the containers' metrics
+}
+
+// What's held back on every node for the kubelet, system daemons and such, on top of what k8s already keeps out of allocatable
+type CapacityReserve struct {
+	CPUMilliCoresPerNode int `json:"cpu_millicores_per_node"`
+	MemoryMBPerNode      int `json:"memory_mb_per_node"`
+}
+
+// What projects get charged for what they run, what they get every month, and what happens once they run out.
+// Everything's in credits.
+type BillingPolicy struct {
+	CreditsPerCPUMilliCoreHour float64 `json:"credits_per_cpu_millicore_hour"`
+	CreditsPerMemoryMBHour     float64 `json:"credits_per_memory_mb_hour"`
+	CreditsPerStorageGBHour    float64 `json:"credits_per_storage_gb_hour"`
+
+	// every project gets one of these at the start of each month: the donation credits if one of its members has
+	// an approved donated node, the flat fee's worth otherwise
+	FlatFeeCredits  float64 `json:"flat_fee_credits"`
+	DonationCredits float64 `json:"donation_credits"`
+
+	Suspension SuspensionPolicy `json:"suspension"`
+}
+
+// Projects that stay below BelowCredits for longer than the grace period get suspended, until they're topped up again
+type SuspensionPolicy struct {
+	Enabled          bool    `json:"enabled"`
+	BelowCredits     float64 `json:"below_credits"`
+	GracePeriodHours int     `json:"grace_period_hours"`
+}
+
+// How images get built in the zones, everything's optional
+type BuildPolicy struct {
+	KanikoImage  string `json:"kaniko_image"`  // the executor, "gcr.io/kaniko-project/executor:v1.23.2" by default
+	FetcherImage string `json:"fetcher_image"` // fetches uploaded tarballs for the executor, "busybox:1.36" by default
+
+	CPUMilliCores  int `json:"cpu_millicores"`  // what every build gets, 1000 by default
+	MemoryMB       int `json:"memory_mb"`       // 2048 by default
+	TimeoutMinutes int `json:"timeout_minutes"` // builds that take longer get stopped, 30 by default
+	MaxContextMB   int `json:"max_context_mb"`  // how big an uploaded tarball may be, 50 by default
+}
+
+func (p BuildPolicy) WithDefaults() BuildPolicy {
+	if p.KanikoImage == "" {
+		p.KanikoImage = "gcr.io/kaniko-project/executor:v1.23.2"
+	}
+	if p.FetcherImage == "" {
+		p.FetcherImage = "busybox:1.36"
+	}
+	if p.CPUMilliCores == 0 {
+		p.CPUMilliCores = 1000
+	}
+	if p.MemoryMB == 0 {
+		p.MemoryMB = 2048
+	}
+	if p.TimeoutMinutes == 0 {
+		p.TimeoutMinutes = 30
+	}
+	if p.MaxContextMB == 0 {
+		p.MaxContextMB = 50
+	}
+	return p
+}
+
+// The registries run in every zone with a registry_host. They're only run, and only ask for credentials, with a token
+// key and certificate.
+type RegistryPolicy struct {
+	TokenKeyPath  string `json:"token_key_path"`  // ECDSA P-256 key in PEM, that the registries' tokens get signed with
+	TokenCertPath string `json:"token_cert_path"` // a self-signed certificate for that key, which the registries trust
+	PublicURL     string `json:"public_url"`      // where docker and the registries reach this service at, ie. "https://lcaas.example.com"
+
+	Image            string `json:"image"`              // "registry:2.8.3" by default
+	StorageGB        int    `json:"storage_gb"`         // every zone's registry volume, 100 by default
+	StorageClassName string `json:"storage_class_name"` // optional, the cluster's default otherwise
+	ProjectQuotaMB   int    `json:"project_quota_mb"`   // what a project may keep in a zone's registry, 5120 by default
+	GCGraceDays      int    `json:"gc_grace_days"`      // images no container uses get deleted once they're this old, 7 by default
+}
+
+func (p RegistryPolicy) WithDefaults() RegistryPolicy {
+	if p.Image == "" {
+		p.Image = "registry:2.8.3"
+	}
+	if p.StorageGB == 0 {
+		p.StorageGB = 100
+	}
+	if p.ProjectQuotaMB == 0 {
+		p.ProjectQuotaMB = 5120
+	}
+	if p.GCGraceDays == 0 {
+		p.GCGraceDays = 7
+	}
+	return p
+}
+
+// What the registries expect the tokens to be issued by
+const RegistryTokenIssuer = "lcaas"
+
+func (p RegistryPolicy) Enabled() bool {
+	return p.TokenKeyPath != ""
+}
+
+// The realm the registries send docker to for a token
+func (p RegistryPolicy) TokenURL() string {
+	return strings.TrimSuffix(p.PublicURL, "/") + "/registry-token"
+}
+
+// Operator-configured rules for which images tenants may deploy
+type ImagePolicy struct {
+	Allow []string `json:"allow"` // globs on the normalised image name like "docker.io/library/*", empty means allow everything not denied
+	Deny  []string `json:"deny"`  // checked before the allow list
+
+	RequireSignature    bool   `json:"require_signature"`      // if true, images need a cosign signature made with the key below
+	CosignPublicKeyPath string `json:"cosign_public_key_path"` // PEM encoded ECDSA public key
 }
 
 type UserDB struct {
-	Zone          string `json:"zone"`
-	ID            string `json:"id"`
-	ConnectionURL string `json:"connection_url"`
+	Zone          string `json:"zone" db:"zone"`
+	ID            string `json:"id" db:"id"`
+	ConnectionURL string `json:"connection_url" db:"connection_url"`
 }
 
 func (userDB UserDB) DefaultParentEnvironmentURL() string {
@@ -40,28 +184,134 @@ func (userDB UserDB) ConnWithSuffix(suffix string) string {
 	return userDB.ConnectionURL + "/" + suffix
 }
 
+// DATABASE_URL etc. for the given user on this DB instance
+func (userDB UserDB) BindingEnvVars(dbName string, creds Credentials) (map[string]string, error) {
+	connURL, err := url.Parse(userDB.ConnectionURL)
+	if err != nil {
+		return nil, fmt.Errorf("Parsing the connection URL of user DB %s/%s failed: %w", userDB.Zone, userDB.ID, err)
+	}
+	port := connURL.Port()
+	if port == "" {
+		port = "5432"
+	}
+
+	connURL.User = url.UserPassword(creds.Username, creds.Password)
+	connURL.Path = "/" + dbName
+	return map[string]string{
+		"DATABASE_URL": connURL.String(),
+		"PGHOST":       connURL.Hostname(),
+		"PGPORT":       port,
+		"PGDATABASE":   dbName,
+		"PGUSER":       creds.Username,
+		"PGPASSWORD":   creds.Password,
+	}, nil
+}
+
 type UserDBConnectionsRaw struct {
 	Zones []UserDB `json:"zones"`
 }
 
-func (c *Config) GetZonesFromUserDBConnections() (zones []string) {
-	for _, userDBConn := range c.UserDBConnections {
-		zones = append(zones, userDBConn.Zone)
+func GetZonesFromUserDBConnections(userDBConnections []UserDB) (zones []string) {
+	for _, userDBConn := range userDBConnections {
+		if !slices.Contains(zones, userDBConn.Zone) {
+			zones = append(zones, userDBConn.Zone)
+		}
 	}
 	return zones
 }
 
+// A zone's seaweedfs S3 gateway, plus its IAM API for handing out per-bucket keys
+type ObjectStorageConnection struct {
+	Zone        string `json:"zone"`
+	S3Endpoint  string `json:"s3_endpoint"`  // what containers get as S3_ENDPOINT, ie. "http://seaweed-s3.fi-hel1.internal:8333"
+	IAMEndpoint string `json:"iam_endpoint"` // ie. "http://seaweed-iam.fi-hel1.internal:8111"
+	Region      string `json:"region"`
+
+	// the admin identity we sign S3/IAM requests with
+	AccessKeyID     string `json:"access_key_id"`
+	SecretAccessKey string `json:"secret_access_key"`
+}
+
+type ObjectStorageConnectionsRaw struct {
+	Zones []ObjectStorageConnection `json:"zones"`
+}
+
 type KubeClientsRaw struct {
 	Clients []ContainerZone `json:"clients"`
 }
 
 type ContainerZone struct {
-	Name             string `json:"name" db:"name"`
-	DefaultRoutingIP string `json:"default_routing_ip" db:"default_routing_ip"`
-	CPUMilliCores    int    `json:"cpu_millicores" db:"cpu_millicores"`
-	MemoryMB         int    `json:"memory_mb" db:"memory_mb"`
+	Name             string     `json:"name" db:"name"`
+	DefaultRoutingIP string     `json:"default_routing_ip" db:"default_routing_ip"`
+	CPUMilliCores    int        `json:"cpu_millicores" db:"cpu_millicores"`
+	MemoryMB         int        `json:"memory_mb" db:"memory_mb"`
+	RuntimeClassName string     `json:"runtime_class_name" db:"runtime_class_name"` // optional, ie. "gvisor" or "kata" if the zone has that RuntimeClass installed
+	KubeconfigPath   string     `json:"kubeconfig_path" db:"kubeconfig_path"`       // optional, defaults to ~/.kube/<name>.conf
+	RegistryHost     string     `json:"registry_host" db:"registry_host"`           // optional, where images built in the zone get pushed to, ie. "registry.fi-hel1.internal"
+	Kubeconfig       *string    `json:"-" db:"kubeconfig"`                          // uploaded through the admin API, takes precedence over the path
+	RetiredAt        *time.Time `json:"retired_at" db:"retired_at"`
+
+	Health          string     `json:"health" db:"health"` // unknown | healthy | degraded | unreachable
+	HealthError     *string    `json:"health_error" db:"health_error"`
+	HealthCheckedAt *time.Time `json:"health_checked_at" db:"health_checked_at"`
+	Cordoned        bool       `json:"cordoned" db:"cordoned"`
+	CordonReason    *string    `json:"cordon_reason" db:"cordon_reason"`
+
+	// kept up to date from the nodes' allocatable resources by kubeOps.CapacityCollector, unless switched off
+	DiscoverCapacity    bool       `json:"discover_capacity" db:"discover_capacity"`
+	NodeCount           int        `json:"node_count" db:"node_count"`
+	CapacityCollectedAt *time.Time `json:"capacity_collected_at" db:"capacity_collected_at"`
+
+	// how far past its guaranteed share an account may go into capacity nobody's using, as a multiple of that share
+	QuotaBurstFactor float64 `json:"quota_burst_factor" db:"quota_burst_factor"`
+	// how much of an approved donated node's capacity its donor gets set aside for themselves, from 0 to 1
+	DonorCreditShare float64 `json:"donor_credit_share" db:"donor_credit_share"`
+
+	// nil until kubeOps.ZoneRegistry has managed to load the zone's kubeconfig
+	ClientSet *kubernetes.Clientset `json:"-" db:"-"`
+
+	// the managed services living in this zone, for binding them to containers
+	UserDBs       []UserDB                 `json:"-" db:"-"`
+	ObjectStorage *ObjectStorageConnection `json:"-" db:"-"`
+
+	// watches on the zone's tenant workloads, nil until the ZoneRegistry's informer cache has synced
+	Informers informers.SharedInformerFactory `json:"-" db:"-"`
+}
+
+// One reading of what a zone's nodes can take
+type ZoneCapacity struct {
+	ZoneCapacityID int       `json:"container_zone_capacity_id" db:"container_zone_capacity_id"`
+	CollectedAt    time.Time `json:"collected_at" db:"collected_at"`
+	ZoneName       string    `json:"zone_name" db:"zone_name"`
+
+	NodeCount            int `json:"node_count" db:"node_count"`
+	SchedulableNodeCount int `json:"schedulable_node_count" db:"schedulable_node_count"`
+
+	AllocatableCPUMilliCores int `json:"allocatable_cpu_millicores" db:"allocatable_cpu_millicores"`
+	AllocatableMemoryMB      int `json:"allocatable_memory_mb" db:"allocatable_memory_mb"`
+	ReservedCPUMilliCores    int `json:"reserved_cpu_millicores" db:"reserved_cpu_millicores"`
+	ReservedMemoryMB         int `json:"reserved_memory_mb" db:"reserved_memory_mb"`
+
+	CPUMilliCores int `json:"cpu_millicores" db:"cpu_millicores"`
+	MemoryMB      int `json:"memory_mb" db:"memory_mb"`
+}
 
-	ClientSet *kubernetes.Clientset
+// Lets every zone know about the DB instances and object storage running in it
+func AttachServiceConnections(clients []ContainerZone, userDBConnections []UserDB, objectStorageConnections []ObjectStorageConnection) []ContainerZone {
+	for i := range clients {
+		clients[i].UserDBs = nil
+		for _, userDBConnection := range userDBConnections {
+			if userDBConnection.Zone == clients[i].Name {
+				clients[i].UserDBs = append(clients[i].UserDBs, userDBConnection)
+			}
+		}
+		for j := range objectStorageConnections {
+			if objectStorageConnections[j].Zone == clients[i].Name {
+				clients[i].ObjectStorage = &objectStorageConnections[j]
+			}
+		}
+	}
+	return clients
 }
 
 func GetZonesFromContainerZones(clients []ContainerZone) (zones []string) {
@@ -71,6 +321,114 @@ func GetZonesFromContainerZones(clients []ContainerZone) (zones []string) {
 	return zones
 }
 
+// Applies the zone fields that were sent along, so that the same form works for registering and updating a zone.
+// A kubeconfig can come as a file upload or as a plain field.
+func (z *ContainerZone) ParseZoneFieldsFromHTTPForm(r *http.Request) (err error) {
+	if r.FormValue("name") != "" {
+		z.Name = r.FormValue("name")
+	}
+	if r.FormValue("default-routing-ip") != "" {
+		z.DefaultRoutingIP = r.FormValue("default-routing-ip")
+	}
+	if r.FormValue("cpu-millicores") != "" {
+		z.CPUMilliCores, err = strconv.Atoi(r.FormValue("cpu-millicores"))
+		if err != nil {
+			return fmt.Errorf("cpu-millicores has to be a number")
+		}
+	}
+	if r.FormValue("memory-mb") != "" {
+		z.MemoryMB, err = strconv.Atoi(r.FormValue("memory-mb"))
+		if err != nil {
+			return fmt.Errorf("memory-mb has to be a number")
+		}
+	}
+	if _, sent := r.Form["runtime-class-name"]; sent {
+		z.RuntimeClassName = r.FormValue("runtime-class-name")
+	}
+	if _, sent := r.Form["kubeconfig-path"]; sent {
+		z.KubeconfigPath = r.FormValue("kubeconfig-path")
+	}
+	if _, sent := r.Form["registry-host"]; sent {
+		z.RegistryHost = strings.TrimSuffix(strings.TrimPrefix(r.FormValue("registry-host"), "https://"), "/")
+	}
+
+	kubeconfig := r.FormValue("kubeconfig")
+	if file, _, fileErr := r.FormFile("kubeconfig"); fileErr == nil {
+		defer file.Close()
+		content, err := io.ReadAll(io.LimitReader(file, 1<<20))
+		if err != nil {
+			return fmt.Errorf("Reading the uploaded kubeconfig failed: %w", err)
+		}
+		kubeconfig = string(content)
+	}
+	if kubeconfig != "" {
+		z.Kubeconfig = &kubeconfig
+	}
+
+	if r.FormValue("quota-burst-factor") != "" {
+		z.QuotaBurstFactor, err = strconv.ParseFloat(r.FormValue("quota-burst-factor"), 64)
+		if err != nil || z.QuotaBurstFactor < 0 {
+			return fmt.Errorf("quota-burst-factor has to be a number, 0 or more")
+		}
+	}
+	if r.FormValue("donor-credit-share") != "" {
+		z.DonorCreditShare, err = strconv.ParseFloat(r.FormValue("donor-credit-share"), 64)
+		if err != nil || z.DonorCreditShare < 0 || z.DonorCreditShare > 1 {
+			return fmt.Errorf("donor-credit-share has to be a number from 0 to 1")
+		}
+	}
+	if _, sent := r.Form["discover-capacity"]; sent {
+		z.DiscoverCapacity = r.FormValue("discover-capacity") == "true"
+	}
+
+	if _, sent := r.Form["cordoned"]; sent {
+		z.Cordoned = r.FormValue("cordoned") == "true"
+		z.CordonReason = nil
+		if reason := r.FormValue("cordon-reason"); z.Cordoned && reason != "" {
+			z.CordonReason = &reason
+		}
+	}
+
+	if z.Name == "" || z.DefaultRoutingIP == "" {
+		return fmt.Errorf("A zone needs at least a name and a default routing IP")
+	}
+	if z.CPUMilliCores <= 0 || z.MemoryMB <= 0 {
+		return fmt.Errorf("A zone needs some CPU and memory")
+	}
+	return nil
+}
+
+// The zones new containers may go to right now
+func GetSchedulableZonesFromContainerZones(clients []ContainerZone) (zones []string) {
+	for _, client := range clients {
+		if client.Cordoned || client.CheckReachable() != nil {
+			continue
+		}
+		zones = append(zones, client.Name)
+	}
+	return zones
+}
+
+// Whether we should be talking to this zone's cluster at all right now, so that callers fail fast instead of
+// waiting on a cluster that's gone
+func (z ContainerZone) CheckReachable() error {
+	if z.ClientSet == nil {
+		reason := "its kubeconfig couldn't be loaded"
+		if z.HealthError != nil {
+			reason = *z.HealthError
+		}
+		return fmt.Errorf("Zone %s isn't connected: %s", z.Name, reason)
+	}
+	if z.Health == "unreachable" {
+		reason := "the health checks are failing"
+		if z.HealthError != nil {
+			reason = *z.HealthError
+		}
+		return fmt.Errorf("Zone %s is unreachable right now, try again later: %s", z.Name, reason)
+	}
+	return nil
+}
+
 type Account struct {
 	AccountID      int        `json:"account_id" db:"account_id"`
 	CreatedAt      time.Time  `json:"created_at" db:"created_at"`
@@ -84,6 +442,8 @@ type Account struct {
 	PasswordSalt   *string    `json:"-" db:"password_salt"`
 	SuspendedAt    *time.Time `json:"suspended_at" db:"suspended_at"`
 	BecameMemberAt *time.Time `json:"became_member_at" db:"became_member_at"`
+	IsAdmin        bool       `json:"is_admin" db:"is_admin"`
+	QuotaWeight    float64    `json:"quota_weight" db:"quota_weight"` // how much of a zone's shared capacity this account gets compared to others
 }
 
 type GitHubAccountProfile struct {
@@ -141,6 +501,11 @@ type Project struct {
 	DeletedAt   *time.Time `json:"deleted_at" db:"deleted_at"`
 	Name        string     `json:"name" db:"name"`
 	Description string     `json:"description" db:"description"`
+	Trusted     bool       `json:"trusted" db:"trusted"` // set by an operator, allows the "unconfined" security profile
+
+	MeteredUntil       *time.Time `json:"metered_until" db:"metered_until"`
+	CreditsExhaustedAt *time.Time `json:"credits_exhausted_at" db:"credits_exhausted_at"`
+	SuspendedAt        *time.Time `json:"suspended_at" db:"suspended_at"`
 
 	SharedUsernames []string `json:"shared_users"`
 }
@@ -149,6 +514,14 @@ func (p *Project) NamespaceName() string {
 	return fmt.Sprintf("namespace-%s-%v", p.Name, p.ProjectID)
 }
 
+// The Pod Security Admission level enforced on this project's namespaces
+func (p Project) PodSecurityEnforceLevel() string {
+	if p.Trusted {
+		return "privileged"
+	}
+	return "restricted"
+}
+
 func (p Project) UserDBClaimName() string {
 	return fmt.Sprintf("db_%s_%v", strings.ReplaceAll(strings.ToLower(p.Name), "-", "_"), p.ProjectID)
 }
@@ -177,6 +550,7 @@ type ContainerClaim struct {
 	Name             string     `json:"name" db:"name"`
 	ImageRef         string     `json:"image_ref" db:"image_ref"`
 	ImageTag         string     `json:"image_tag" db:"image_tag"`
+	ImageDigest      *string    `json:"image_digest" db:"image_digest"` // what the tag resolved to at deploy time, the pods are pinned to this
 
 	Command pq.StringArray `json:"command" db:"command"` // optional: such as the command: ["perl",  "-Mbignum=bpi", "-wle", "print bpi(2000)"]
 
@@ -184,19 +558,28 @@ type ContainerClaim struct {
 	Ports       pq.Int64Array `json:"ports" db:"ports"`               // the public ports I'm going to route to this container
 	TargetPorts pq.Int64Array `json:"target_ports" db:"target_ports"` // the ports the user actually wants to expose
 
-	CPUMilliCores int `json:"cpu_millicores" db:"cpu_millicores"`
-	MemoryMB      int `json:"memory_mb" db:"memory_mb"`
-
-	Status      string         `json:"status" db:"status"`     // inactive | active | deactivating | activating | error
-	RunType     string         `json:"run_type" db:"run_type"` // permanent | once | schedule
-	Zones       pq.StringArray `json:"zones" db:"zones"`
-	EnvVarNames pq.StringArray `json:"env_var_names" db:"env_var_names"`
+	CPUMilliCores   int        `json:"cpu_millicores" db:"cpu_millicores"`
+	MemoryMB        int        `json:"memory_mb" db:"memory_mb"`
+	UsageReleasedAt *time.Time `json:"usage_released_at" db:"usage_released_at"` // when the CPU and memory were given back to the account's quota
+
+	Status             string          `json:"status" db:"status"`                     // inactive | building | active | degraded | deactivating | activating | error
+	RunType            string          `json:"run_type" db:"run_type"`                 // permanent | once | schedule
+	SecurityProfile    string          `json:"security_profile" db:"security_profile"` // restricted | restricted-readonly | unconfined
+	Zones              pq.StringArray  `json:"zones" db:"zones"`
+	EnvVarNames        pq.StringArray  `json:"env_var_names" db:"env_var_names"`
+	ZoneEnvVarNames    ZoneEnvVarNames `json:"zone_env_var_names" db:"zone_env_var_names"`
+	SharedConfigNames  pq.StringArray  `json:"shared_config_names" db:"shared_config_names"`   // project shared secrets/configmaps to pull all keys from
+	BindDatabase       bool            `json:"bind_database" db:"bind_database"`               // inject the project DB's creds as DATABASE_URL/PG*
+	BoundObjectStorage *string         `json:"bound_object_storage" db:"bound_object_storage"` // inject S3_* creds for this bucket
+	PullCredentialName *string         `json:"pull_credential_name" db:"pull_credential_name"` // the project's stored credential its image pull secret comes from
 
 	CreatedByAccountID int `json:"created_by_account_id" db:"created_by_account_id"`
 	ProjectID          int `json:"project_id" db:"project_id"`
 
-	EnvVars         []EnvVar         `json:"-"`
-	ImagePullSecret *ImagePullSecret `json:"-"`
+	EnvVars         []EnvVar            `json:"-"`
+	ZoneEnvVars     map[string][]EnvVar `json:"-"` // per zone overrides on top of EnvVars
+	ImagePullSecret *ImagePullSecret    `json:"-"`
+	DeployReason    string              `json:"-" db:"-"` // what ends up as the reason on this deploy's revision, ie. "rerun"
 }
 
 // For permanently running containers
@@ -205,6 +588,16 @@ func (c *ContainerClaim) DeploymentName() string {
 }
 
 // For run-once containers
+// Holds the injected DB/object storage creds. Unlike env var secrets its values can always be made again,
+// so it belongs to just this claim and is rewritten whenever the creds rotate.
+func (c *ContainerClaim) ServiceBindingSecretName() string {
+	return fmt.Sprintf("binding-%s-%v", c.Name, c.ContainerClaimID)
+}
+
+func (c *ContainerClaim) HasServiceBindings() bool {
+	return c.BindDatabase || c.BoundObjectStorage != nil
+}
+
 func (c *ContainerClaim) JobName() string {
 	return fmt.Sprintf("job-%s-%v", c.Name, c.ContainerClaimID)
 }
@@ -213,6 +606,17 @@ func (c *ContainerClaim) IsRunOnce() bool {
 	return c.RunType == "once"
 }
 
+// Pod security profiles, from most to least locked down. Only trusted projects may use "unconfined".
+var SecurityProfiles = []string{"restricted", "restricted-readonly", "unconfined"}
+
+func (c *ContainerClaim) IsUnconfined() bool {
+	return c.SecurityProfile == "unconfined"
+}
+
+func (c *ContainerClaim) HasReadOnlyRootFilesystem() bool {
+	return c.SecurityProfile == "restricted-readonly"
+}
+
 func (c *ContainerClaim) ServiceName(targetPort int64) string {
 	return fmt.Sprintf("service-%s-%v-%v", c.Name, c.ContainerClaimID, targetPort)
 }
@@ -225,6 +629,53 @@ func (c *ContainerClaim) WholeImageWithTag() string {
 	return fmt.Sprintf("%s:%s", c.ImageRef, c.ImageTag)
 }
 
+// The image the pods actually run: pinned to the digest if we resolved one, so a re-pushed tag can't sneak in
+func (c *ContainerClaim) PinnedImage() string {
+	if c.ImageDigest == nil || *c.ImageDigest == "" {
+		return c.WholeImageWithTag()
+	}
+	return fmt.Sprintf("%s@%s", c.ImageRef, *c.ImageDigest)
+}
+
+// Images in the zones' registries get pulled by each zone from its own, with the same credentials
+func (c *ContainerClaim) ImagePullSecretURLForZone(zone ContainerZone, zones []ContainerZone) string {
+	if zone.RegistryHost == "" {
+		return c.ImagePullSecret.URL
+	}
+	for _, otherZone := range zones {
+		if otherZone.RegistryHost != "" && c.ImagePullSecret.URL == otherZone.RegistryHost {
+			return zone.RegistryHost
+		}
+	}
+	return c.ImagePullSecret.URL
+}
+
+// The zone's registry the container's image is in, if it's in one
+func (c *ContainerClaim) InternalRegistryHost(zones []ContainerZone) string {
+	host := strings.SplitN(c.ImageRef, "/", 2)[0]
+	for _, zone := range zones {
+		if zone.RegistryHost != "" && zone.RegistryHost == host {
+			return host
+		}
+	}
+	return ""
+}
+
+// Images built here get pushed to the registry of every zone the container runs in, so each zone pulls them from
+// its own registry instead of the one the claim happens to name
+func (c *ContainerClaim) PinnedImageForZone(zone ContainerZone, zones []ContainerZone) string {
+	image := c.PinnedImage()
+	if zone.RegistryHost == "" {
+		return image
+	}
+	for _, otherZone := range zones {
+		if otherZone.RegistryHost != "" && strings.HasPrefix(image, otherZone.RegistryHost+"/") {
+			return zone.RegistryHost + strings.TrimPrefix(image, otherZone.RegistryHost)
+		}
+	}
+	return image
+}
+
 func (c *ContainerClaim) CPUMilliCoresAsResourceListStr() string {
 	return fmt.Sprintf("%vm", c.CPUMilliCores)
 }
@@ -248,11 +699,254 @@ func (c *ContainerClaim) IPWithPortsDisplayStr() string {
 	return strings.Join(portMappingDisplay, ", ")
 }
 
+// How a container is doing in one zone, as last seen by that zone's informers
+type ContainerZoneStatus struct {
+	ContainerClaimID int       `json:"container_claim_id" db:"container_claim_id"`
+	ZoneName         string    `json:"zone_name" db:"zone_name"`
+	UpdatedAt        time.Time `json:"updated_at" db:"updated_at"`
+
+	NodeIP                *string `json:"node_ip" db:"node_ip"`
+	PodPhase              string  `json:"pod_phase" db:"pod_phase"`
+	Ready                 bool    `json:"ready" db:"ready"`
+	ReadyReplicas         int     `json:"ready_replicas" db:"ready_replicas"`
+	RestartCount          int     `json:"restart_count" db:"restart_count"`
+	LastTerminationReason *string `json:"last_termination_reason" db:"last_termination_reason"`
+	WaitingReason         *string `json:"waiting_reason" db:"waiting_reason"`
+	WaitingMessage        *string `json:"waiting_message" db:"waiting_message"`
+}
+
+// Why a container can be stuck waiting that won't go away on its own
+var stuckWaitingReasons = []string{"ImagePullBackOff", "ErrImagePull", "InvalidImageName", "CreateContainerConfigError"}
+
+// A pod that keeps restarting without ever getting ready counts as down too, even though k8s calls it Running, and so
+// does one that's stuck Pending because its image can't be pulled or its container can't be created
+func (s ContainerZoneStatus) IsDown() bool {
+	if s.PodPhase == "Running" && !s.Ready && s.RestartCount > 0 {
+		return true
+	}
+	if s.WaitingReason != nil && slices.Contains(stuckWaitingReasons, *s.WaitingReason) {
+		return true
+	}
+	return s.PodPhase == "Failed" || s.PodPhase == "Unknown" || s.PodPhase == "Missing"
+}
+
+// What to do about the one stuck waiting reason that's down to us rather than the image: the kubelet won't start an
+// image as root, or as a user it can't tell isn't root, under the confined security profiles' runAsNonRoot
+func (s ContainerZoneStatus) WaitingHint() string {
+	if s.WaitingReason == nil || *s.WaitingReason != "CreateContainerConfigError" || s.WaitingMessage == nil {
+		return ""
+	}
+	if !strings.Contains(*s.WaitingMessage, "runAsNonRoot") {
+		return ""
+	}
+	return "The image runs as root or as a user by name, which its security profile doesn't allow. Give it a non-root USER by UID (ie. USER 1000), or ask for the project to be trusted."
+}
+
+// What a running container's status should be given how its zones are doing: active when no zone is down, degraded
+// when some are, error when all are. Zones that haven't reported yet are still coming up, so they don't count as down.
+func AggregateContainerStatus(zones []string, zoneStatuses []ContainerZoneStatus) string {
+	downZones := 0
+	for _, zoneStatus := range zoneStatuses {
+		if slices.Contains(zones, zoneStatus.ZoneName) && zoneStatus.IsDown() {
+			downZones++
+		}
+	}
+
+	switch {
+	case downZones == 0:
+		return "active"
+	case downZones < len(zones):
+		return "degraded"
+	default:
+		return "error"
+	}
+}
+
+// A snapshot of what a container looked like each time it was deployed
+type ContainerRevision struct {
+	ContainerRevisionID int       `json:"container_revision_id" db:"container_revision_id"`
+	CreatedAt           time.Time `json:"created_at" db:"created_at"`
+	Revision            int       `json:"revision" db:"revision"`
+	Reason              string    `json:"reason" db:"reason"`
+
+	ContainerName      string          `json:"container_name" db:"container_name"`
+	ImageRef           string          `json:"image_ref" db:"image_ref"`
+	ImageTag           string          `json:"image_tag" db:"image_tag"`
+	ImageDigest        *string         `json:"image_digest" db:"image_digest"`
+	Command            pq.StringArray  `json:"command" db:"command"`
+	EnvVarNames        pq.StringArray  `json:"env_var_names" db:"env_var_names"`
+	ZoneEnvVarNames    ZoneEnvVarNames `json:"zone_env_var_names" db:"zone_env_var_names"`
+	SharedConfigNames  pq.StringArray  `json:"shared_config_names" db:"shared_config_names"`
+	BindDatabase       bool            `json:"bind_database" db:"bind_database"`
+	BoundObjectStorage *string         `json:"bound_object_storage" db:"bound_object_storage"`
+	PullCredentialName *string         `json:"pull_credential_name" db:"pull_credential_name"`
+	CPUMilliCores      int             `json:"cpu_millicores" db:"cpu_millicores"`
+	MemoryMB           int             `json:"memory_mb" db:"memory_mb"`
+	TargetPorts        pq.Int64Array   `json:"target_ports" db:"target_ports"`
+	Zones              pq.StringArray  `json:"zones" db:"zones"`
+	RunType            string          `json:"run_type" db:"run_type"`
+	SecurityProfile    string          `json:"security_profile" db:"security_profile"`
+
+	ContainerClaimID   int `json:"container_claim_id" db:"container_claim_id"`
+	CreatedByAccountID int `json:"created_by_account_id" db:"created_by_account_id"`
+	ProjectID          int `json:"project_id" db:"project_id"`
+}
+
+type RevisionFieldChange struct {
+	Field string `json:"field"`
+	From  string `json:"from"`
+	To    string `json:"to"`
+}
+
+// Everything that differs between two revisions, as display strings
+func (r ContainerRevision) Diff(other ContainerRevision) (changes []RevisionFieldChange) {
+	optionalStr := func(d *string) string {
+		if d == nil {
+			return ""
+		}
+		return *d
+	}
+	fields := []struct {
+		name     string
+		from, to string
+	}{
+		{"image_ref", r.ImageRef, other.ImageRef},
+		{"image_tag", r.ImageTag, other.ImageTag},
+		{"image_digest", optionalStr(r.ImageDigest), optionalStr(other.ImageDigest)},
+		{"command", strings.Join(r.Command, " "), strings.Join(other.Command, " ")},
+		{"env_var_names", strings.Join(r.EnvVarNames, ", "), strings.Join(other.EnvVarNames, ", ")},
+		{"zone_env_var_names", r.ZoneEnvVarNames.String(), other.ZoneEnvVarNames.String()},
+		{"shared_config_names", strings.Join(r.SharedConfigNames, ", "), strings.Join(other.SharedConfigNames, ", ")},
+		{"bind_database", strconv.FormatBool(r.BindDatabase), strconv.FormatBool(other.BindDatabase)},
+		{"bound_object_storage", optionalStr(r.BoundObjectStorage), optionalStr(other.BoundObjectStorage)},
+		{"pull_credential_name", optionalStr(r.PullCredentialName), optionalStr(other.PullCredentialName)},
+		{"cpu_millicores", strconv.Itoa(r.CPUMilliCores), strconv.Itoa(other.CPUMilliCores)},
+		{"memory_mb", strconv.Itoa(r.MemoryMB), strconv.Itoa(other.MemoryMB)},
+		{"target_ports", fmt.Sprint([]int64(r.TargetPorts)), fmt.Sprint([]int64(other.TargetPorts))},
+		{"zones", strings.Join(r.Zones, ", "), strings.Join(other.Zones, ", ")},
+		{"run_type", r.RunType, other.RunType},
+		{"security_profile", r.SecurityProfile, other.SecurityProfile},
+	}
+	for _, f := range fields {
+		if f.from != f.to {
+			changes = append(changes, RevisionFieldChange{Field: f.name, From: f.from, To: f.to})
+		}
+	}
+	return changes
+}
+
+// Env vars this revision needs that the running container doesn't have a secret for anymore
+func (r ContainerRevision) MissingEnvVarNames(c ContainerClaim) (missing []string) {
+	for _, name := range r.EnvVarNames {
+		if !slices.Contains(c.EnvVarNames, name) {
+			missing = append(missing, name)
+		}
+	}
+	for zone, names := range r.ZoneEnvVarNames {
+		for _, name := range names {
+			if !slices.Contains(c.EnvVarNamesForZone(zone), name) {
+				missing = append(missing, fmt.Sprintf("%s (in %s)", name, zone))
+			}
+		}
+	}
+	return missing
+}
+
+// Zones this revision ran in that the running container isn't in anymore, so its secrets don't exist there
+func (r ContainerRevision) ZonesNotIn(c ContainerClaim) (zones []string) {
+	for _, zone := range r.Zones {
+		if !slices.Contains(c.Zones, zone) {
+			zones = append(zones, zone)
+		}
+	}
+	return zones
+}
+
+// Applies this revision's settings on top of the currently running container, for rolling back
+func (r ContainerRevision) AppliedTo(c ContainerClaim) ContainerClaim {
+	c.ImageRef = r.ImageRef
+	c.ImageTag = r.ImageTag
+	c.ImageDigest = r.ImageDigest
+	c.Command = r.Command
+	c.EnvVarNames = r.EnvVarNames
+	c.ZoneEnvVarNames = r.ZoneEnvVarNames
+	c.SharedConfigNames = r.SharedConfigNames
+	c.BindDatabase = r.BindDatabase
+	c.BoundObjectStorage = r.BoundObjectStorage
+	c.PullCredentialName = r.PullCredentialName
+	c.CPUMilliCores = r.CPUMilliCores
+	c.MemoryMB = r.MemoryMB
+	c.TargetPorts = r.TargetPorts
+	c.Zones = r.Zones
+	c.RunType = r.RunType
+	c.SecurityProfile = r.SecurityProfile
+	return c
+}
+
 type EnvVar struct {
 	Name  string `json:"name" db:"name"`
 	Value string `json:"value" db:"value"`
 }
 
+// Names of the env vars that are overridden in (or only exist in) a given zone, keyed by zone name
+type ZoneEnvVarNames map[string][]string
+
+func (z *ZoneEnvVarNames) Scan(src interface{}) error {
+	return parseJSONToModel(src, z)
+}
+
+func (z ZoneEnvVarNames) Value() (driver.Value, error) {
+	if z == nil {
+		return nil, nil
+	}
+	return json.Marshal(z)
+}
+
+func (z ZoneEnvVarNames) String() string {
+	zones := make([]string, 0, len(z))
+	for zone := range z {
+		zones = append(zones, zone)
+	}
+	slices.Sort(zones)
+
+	parts := []string{}
+	for _, zone := range zones {
+		parts = append(parts, fmt.Sprintf("%s: %s", zone, strings.Join(z[zone], ", ")))
+	}
+	return strings.Join(parts, "; ")
+}
+
+// The env vars a container gets in one zone: the base ones, with that zone's overrides on top
+func (c *ContainerClaim) EnvVarsForZone(zone string) []EnvVar {
+	envVars := slices.Clone(c.EnvVars)
+	for _, override := range c.ZoneEnvVars[zone] {
+		i := slices.IndexFunc(envVars, func(e EnvVar) bool { return e.Name == override.Name })
+		if i >= 0 {
+			envVars[i] = override
+		} else {
+			envVars = append(envVars, override)
+		}
+	}
+	// when recreating we only know the names, so make sure zone-only vars still get referenced
+	for _, name := range c.ZoneEnvVarNames[zone] {
+		if !slices.ContainsFunc(envVars, func(e EnvVar) bool { return e.Name == name }) {
+			envVars = append(envVars, EnvVar{Name: name})
+		}
+	}
+	return envVars
+}
+
+// Names of all the secrets this container has in one zone
+func (c *ContainerClaim) EnvVarNamesForZone(zone string) []string {
+	names := slices.Clone([]string(c.EnvVarNames))
+	for _, name := range c.ZoneEnvVarNames[zone] {
+		if !slices.Contains(names, name) {
+			names = append(names, name)
+		}
+	}
+	return names
+}
+
 func (c *ContainerClaim) GetEnvNamesFromVars() (names []string) {
 	for _, envVar := range c.EnvVars {
 		names = append(names, envVar.Name)
@@ -260,6 +954,31 @@ func (c *ContainerClaim) GetEnvNamesFromVars() (names []string) {
 	return names
 }
 
+// Placeholder env vars for recreating a container: only the names, since we don't know the secrets' values anymore
+// and just reuse the secrets that are already there
+func (c *ContainerClaim) RetainedEnvVars() (envVars []EnvVar) {
+	for _, envVarName := range c.EnvVarNames {
+		if strings.HasSuffix(envVarName, "image-pull-secret") { // the image-pull-secret isn't a real env var
+			continue
+		}
+		envVars = append(envVars, EnvVar{Name: envVarName})
+	}
+	return envVars
+}
+
+// Pulls the image with the project's stored credential, which has to be opened already
+func (c *ContainerClaim) UsePullCredential(credential PullCredential) {
+	c.ImagePullSecret = credential.ImagePullSecret()
+	c.PullCredentialName = &credential.Name
+	if !c.HasImagePullSecret() {
+		c.EnvVarNames = append(c.EnvVarNames, "image-pull-secret")
+	}
+}
+
+func (c *ContainerClaim) HasImagePullSecret() bool {
+	return slices.Contains(c.EnvVarNames, "image-pull-secret")
+}
+
 func (c *ContainerClaim) EnvVarSpecName(envVarName string) string {
 	return fmt.Sprintf("secret-%s-%s", c.Name, strings.ReplaceAll(strings.ToLower(envVarName), "_", "-"))
 }
@@ -287,6 +1006,30 @@ func (c *ContainerClaim) ParseContainerFieldsFromHTTPFormZoneProject(r *http.Req
 		}
 	}
 
+	for i := 0; i < len(r.Form["zone-env-var-name[]"]) && i < len(r.Form["zone-env-var-zone[]"]) && i < len(r.Form["zone-env-var-value[]"]); i++ {
+		zone := r.Form["zone-env-var-zone[]"][i]
+		envVar := EnvVar{
+			Name:  r.Form["zone-env-var-name[]"][i],
+			Value: r.Form["zone-env-var-value[]"][i],
+		}
+		if zone == "" || envVar.Name == "" {
+			continue
+		}
+		if c.ZoneEnvVars == nil {
+			c.ZoneEnvVars = map[string][]EnvVar{}
+			c.ZoneEnvVarNames = ZoneEnvVarNames{}
+		}
+		c.ZoneEnvVars[zone] = append(c.ZoneEnvVars[zone], envVar)
+		c.ZoneEnvVarNames[zone] = append(c.ZoneEnvVarNames[zone], envVar.Name)
+	}
+
+	for i := 0; i < len(r.Form["shared-config[]"]); i++ {
+		sharedConfigName := r.Form["shared-config[]"][i]
+		if sharedConfigName != "" {
+			c.SharedConfigNames = append(c.SharedConfigNames, sharedConfigName)
+		}
+	}
+
 	for i := 0; i < len(r.Form["command[]"]); i++ {
 		newCommandSubSection := r.Form["command[]"][i]
 		if newCommandSubSection != "" {
@@ -334,6 +1077,11 @@ func (c *ContainerClaim) ParseContainerFieldsFromHTTPFormZoneProject(r *http.Req
 	c.ImageRef = r.FormValue("image-ref")
 	c.ImageTag = r.FormValue("image-tag")
 	c.RunType = r.FormValue("run-type")
+	c.SecurityProfile = r.FormValue("security-profile")
+	c.BindDatabase = r.FormValue("bind-database") == "on" || r.FormValue("bind-database") == "true"
+	if boundObjectStorage := r.FormValue("bind-object-storage"); boundObjectStorage != "" {
+		c.BoundObjectStorage = &boundObjectStorage
+	}
 	cpuMilliCores, err := strconv.Atoi(r.FormValue("cpu-millicores"))
 	if err != nil {
 		return *c, err
@@ -356,39 +1104,189 @@ func (c *ContainerClaim) ParseContainerFieldsFromHTTPFormZoneProject(r *http.Req
 	if c.RunType == "" {
 		c.RunType = "permanent"
 	}
+	if c.SecurityProfile == "" {
+		c.SecurityProfile = "restricted"
+	}
 
 	return *c, nil
 }
 
-type UserDBClaim struct {
-	UserDBClaimID int        `json:"user_db_claim_id" db:"user_db_claim_id"`
-	CreatedAt     time.Time  `json:"created_at" db:"created_at"`
-	DeletedAt     *time.Time `json:"deleted_at" db:"deleted_at"`
+// A named set of env vars shared by many containers in a project. Every change makes a new version, which is its
+// own immutable Secret/ConfigMap in k8s, so containers only move to it when they're (re)started.
+type SharedConfig struct {
+	SharedConfigID int        `json:"project_shared_config_id" db:"project_shared_config_id"`
+	CreatedAt      time.Time  `json:"created_at" db:"created_at"`
+	UpdatedAt      time.Time  `json:"updated_at" db:"updated_at"`
+	DeletedAt      *time.Time `json:"deleted_at" db:"deleted_at"`
+	Name           string     `json:"name" db:"name"`
 
-	// start billable fields
-	StorageGB int `json:"storage_gb" db:"storage_gb"` // default 10GB storage
-	// end billable fields
+	Kind         string              `json:"kind" db:"kind"` // secret | configmap
+	Version      int                 `json:"version" db:"version"`
+	Keys         pq.StringArray      `json:"keys" db:"keys"`
+	ConfigValues *SharedConfigValues `json:"config_values" db:"config_values"` // nil for secrets, we never keep those values
 
-	Status      string                  `json:"status" db:"status"` // inactive | active | deactivating | activating | error
-	Zones       pq.StringArray          `json:"zones" db:"zones"`
-	Credentials *UserDBClaimCredentials `json:"credentials" db:"credentials"`
-	ProjectID   int                     `json:"project_id" db:"project_id"`
+	ProjectID int `json:"project_id" db:"project_id"`
 }
 
-type UserDBClaimCredentials struct {
-	Credentials []Credentials `json:"credentials" db:"credentials"`
+func (s *SharedConfig) IsSecret() bool {
+	return s.Kind == "secret"
 }
 
-type Credentials struct {
-	Username          string `json:"username" db:"username"`
-	Password          string `json:"password" db:"password"`
-	AccessControlType string `json:"access_control_type" db:"access_control_type"`
+// The name of the k8s Secret/ConfigMap holding the given version
+func (s *SharedConfig) ObjectName(version int) string {
+	return fmt.Sprintf("shared-%s-v%v", strings.ReplaceAll(strings.ToLower(s.Name), "_", "-"), version)
 }
 
-func parseJSONToModel(src interface{}, dest interface{}) error {
-	var data []byte
+func (s *SharedConfig) CurrentObjectName() string {
+	return s.ObjectName(s.Version)
+}
 
-	if b, ok := src.([]byte); ok {
+// Whether a Secret/ConfigMap name is one of this shared config's versions
+func (s *SharedConfig) OwnsObjectName(objectName string) bool {
+	version, found := strings.CutPrefix(objectName, strings.TrimSuffix(s.ObjectName(0), "0"))
+	if !found {
+		return false
+	}
+	_, err := strconv.Atoi(version)
+	return err == nil
+}
+
+// The key/values from a shared config create/update form. On updates, keys in "remove-key[]" get dropped and keys
+// that are left out keep whatever value they had in the previous version.
+func ParseSharedConfigValuesFromHTTPForm(r *http.Request) (values map[string]string, removedKeys []string) {
+	values = map[string]string{}
+	for i := 0; i < len(r.Form["key[]"]) && i < len(r.Form["value[]"]); i++ {
+		key := r.Form["key[]"][i]
+		if key != "" {
+			values[key] = r.Form["value[]"][i]
+		}
+	}
+
+	for i := 0; i < len(r.Form["remove-key[]"]); i++ {
+		removedKey := r.Form["remove-key[]"][i]
+		if removedKey != "" {
+			removedKeys = append(removedKeys, removedKey)
+		}
+	}
+
+	return values, removedKeys
+}
+
+// Merges an update into the previous version's values
+func MergeSharedConfigValues(previous, changes map[string]string, removedKeys []string) map[string]string {
+	merged := map[string]string{}
+	for key, value := range previous {
+		merged[key] = value
+	}
+	for key, value := range changes {
+		merged[key] = value
+	}
+	for _, removedKey := range removedKeys {
+		delete(merged, removedKey)
+	}
+	return merged
+}
+
+func SharedConfigKeys(values map[string]string) (keys pq.StringArray) {
+	for key := range values {
+		keys = append(keys, key)
+	}
+	slices.Sort(keys)
+	return keys
+}
+
+type SharedConfigValues map[string]string
+
+func (v *SharedConfigValues) Scan(src interface{}) error {
+	return parseJSONToModel(src, v)
+}
+
+func (v SharedConfigValues) Value() (driver.Value, error) {
+	if v == nil {
+		return nil, nil
+	}
+	return json.Marshal(v)
+}
+
+type SharedConfigVersion struct {
+	SharedConfigVersionID int                 `json:"project_shared_config_version_id" db:"project_shared_config_version_id"`
+	CreatedAt             time.Time           `json:"created_at" db:"created_at"`
+	Version               int                 `json:"version" db:"version"`
+	Keys                  pq.StringArray      `json:"keys" db:"keys"`
+	ConfigValues          *SharedConfigValues `json:"config_values" db:"config_values"`
+
+	CreatedByAccountID int `json:"created_by_account_id" db:"created_by_account_id"`
+	SharedConfigID     int `json:"project_shared_config_id" db:"project_shared_config_id"`
+}
+
+type UserDBClaim struct {
+	UserDBClaimID int        `json:"user_db_claim_id" db:"user_db_claim_id"`
+	CreatedAt     time.Time  `json:"created_at" db:"created_at"`
+	DeletedAt     *time.Time `json:"deleted_at" db:"deleted_at"`
+
+	// start billable fields
+	StorageGB int `json:"storage_gb" db:"storage_gb"` // default 10GB storage
+	// end billable fields
+
+	Status      string                  `json:"status" db:"status"` // inactive | active | deactivating | activating | error
+	Zones       pq.StringArray          `json:"zones" db:"zones"`
+	Credentials *UserDBClaimCredentials `json:"credentials" db:"credentials"`
+	ProjectID   int                     `json:"project_id" db:"project_id"`
+}
+
+type UserDBClaimCredentials struct {
+	Credentials []Credentials `json:"credentials" db:"credentials"`
+}
+
+type Credentials struct {
+	Username          string `json:"username" db:"username"`
+	Password          string `json:"password" db:"password"`
+	AccessControlType string `json:"access_control_type" db:"access_control_type"`
+
+	// every DB instance has its own password for the same user, older creds don't have these set
+	Zone     string `json:"zone,omitempty" db:"zone"`
+	UserDBID string `json:"user_db_id,omitempty" db:"user_db_id"`
+}
+
+// The creds of the given user on one specific DB instance
+func (r *UserDBClaimCredentials) ForUserDB(userDB UserDB, username string) (Credentials, bool) {
+	if r == nil {
+		return Credentials{}, false
+	}
+	for _, creds := range r.Credentials {
+		if creds.Username == username && creds.Zone == userDB.Zone && creds.UserDBID == userDB.ID {
+			return creds, true
+		}
+	}
+	// from before we kept track of the instance
+	for _, creds := range r.Credentials {
+		if creds.Username == username && creds.Zone == "" {
+			return creds, true
+		}
+	}
+	return Credentials{}, false
+}
+
+// Swaps in creds for a user on an instance, keeping everyone else's
+func (r *UserDBClaimCredentials) WithCredentials(newCreds Credentials) UserDBClaimCredentials {
+	updated := UserDBClaimCredentials{}
+	if r != nil {
+		for _, creds := range r.Credentials {
+			sameUserAndInstance := creds.Username == newCreds.Username && creds.Zone == newCreds.Zone && creds.UserDBID == newCreds.UserDBID
+			fromBeforeInstances := creds.Username == newCreds.Username && creds.Zone == ""
+			if !sameUserAndInstance && !fromBeforeInstances {
+				updated.Credentials = append(updated.Credentials, creds)
+			}
+		}
+	}
+	updated.Credentials = append(updated.Credentials, newCreds)
+	return updated
+}
+
+func parseJSONToModel(src interface{}, dest interface{}) error {
+	var data []byte
+
+	if b, ok := src.([]byte); ok {
 		data = b
 	} else if s, ok := src.(string); ok {
 		data = []byte(s)
@@ -413,9 +1311,96 @@ type ObjectStorageClaim struct {
 	StorageGB int `json:"storage_gb" db:"storage_gb"` // default 10GB storage
 	// end billable fields
 
-	Status    string         `json:"status" db:"status"` // inactive | active | deactivating | activating | error
-	Zones     pq.StringArray `json:"zones" db:"zones"`
-	ProjectID int            `json:"project_id" db:"project_id"`
+	Status      string                         `json:"status" db:"status"` // inactive | active | deactivating | activating | error
+	Zones       pq.StringArray                 `json:"zones" db:"zones"`
+	Credentials *ObjectStorageClaimCredentials `json:"-" db:"credentials"`
+	ProjectID   int                            `json:"project_id" db:"project_id"`
+}
+
+// S3 bucket names are global to the seaweed instance, so they need the project in them
+func (o *ObjectStorageClaim) BucketName() string {
+	return fmt.Sprintf("p%v-%s", o.ProjectID, strings.ReplaceAll(strings.ToLower(o.Name), "_", "-"))
+}
+
+func (o *ObjectStorageClaim) CredentialsForZone(zone string) (ObjectStorageCredentials, bool) {
+	if o.Credentials == nil {
+		return ObjectStorageCredentials{}, false
+	}
+	for _, creds := range o.Credentials.Credentials {
+		if creds.Zone == zone {
+			return creds, true
+		}
+	}
+	return ObjectStorageCredentials{}, false
+}
+
+// Encrypts the secret access keys that aren't yet into SealedSecretAccessKey, bound to the bucket's project, name and zone
+func (o *ObjectStorageClaim) SealCredentials(key []byte) error {
+	if o.Credentials == nil {
+		return nil
+	}
+	for i, creds := range o.Credentials.Credentials {
+		if creds.SecretAccessKey == "" {
+			continue
+		}
+		sealed, err := SealSecret(key, []byte(creds.SecretAccessKey), secretAdditionalData("object_storage_credentials", o.ProjectID, o.Name+"/"+creds.Zone))
+		if err != nil {
+			return err
+		}
+		o.Credentials.Credentials[i].SealedSecretAccessKey = sealed
+	}
+	return nil
+}
+
+// Decrypts the SealedSecretAccessKeys back into the secret access keys
+func (o *ObjectStorageClaim) OpenCredentials(key []byte) error {
+	if o.Credentials == nil {
+		return nil
+	}
+	for i, creds := range o.Credentials.Credentials {
+		plaintext, err := OpenSecret(key, creds.SealedSecretAccessKey, secretAdditionalData("object_storage_credentials", o.ProjectID, o.Name+"/"+creds.Zone))
+		if err != nil {
+			return fmt.Errorf("Decrypting the %s keys of object storage %s failed: %w", creds.Zone, o.Name, err)
+		}
+		o.Credentials.Credentials[i].SecretAccessKey = string(plaintext)
+	}
+	return nil
+}
+
+type ObjectStorageClaimCredentials struct {
+	Credentials []ObjectStorageCredentials `json:"credentials"`
+}
+
+func (r *ObjectStorageClaimCredentials) Scan(src interface{}) error {
+	return parseJSONToModel(src, r)
+}
+
+func (r ObjectStorageClaimCredentials) Value() (driver.Value, error) {
+	return json.Marshal(r)
+}
+
+// An access key that can only touch one bucket, on one zone's seaweed. The secret is only kept encrypted, in
+// SealedSecretAccessKey.
+type ObjectStorageCredentials struct {
+	Zone                  string `json:"zone"`
+	AccessKeyID           string `json:"access_key_id"`
+	SealedSecretAccessKey []byte `json:"sealed_secret_access_key"`
+
+	SecretAccessKey string `json:"-"`
+}
+
+// S3_* for the bucket, plus the AWS_* names most S3 SDKs look for by default
+func (c ObjectStorageCredentials) BindingEnvVars(connection ObjectStorageConnection, bucketName string) map[string]string {
+	return map[string]string{
+		"S3_ENDPOINT":           connection.S3Endpoint,
+		"S3_REGION":             connection.Region,
+		"S3_BUCKET":             bucketName,
+		"S3_ACCESS_KEY_ID":      c.AccessKeyID,
+		"S3_SECRET_ACCESS_KEY":  c.SecretAccessKey,
+		"AWS_ACCESS_KEY_ID":     c.AccessKeyID,
+		"AWS_SECRET_ACCESS_KEY": c.SecretAccessKey,
+		"AWS_REGION":            connection.Region,
+	}
 }
 
 type ContainerResourceUsagePerAccountPerZone struct {
@@ -424,6 +1409,1489 @@ type ContainerResourceUsagePerAccountPerZone struct {
 	UsedCPUMilliCores int `json:"used_cpu_millicores" db:"used_cpu_millicores"`
 	UsedMemoryMB      int `json:"used_memory_mb" db:"used_memory_mb"`
 
+	ReservedCPUMilliCores int `json:"reserved_cpu_millicores" db:"reserved_cpu_millicores"`
+	ReservedMemoryMB      int `json:"reserved_memory_mb" db:"reserved_memory_mb"`
+
+	// the donor credit for the account's donated nodes in the zone, set aside just like a reservation
+	DonatedCPUMilliCores int `json:"donated_cpu_millicores" db:"donated_cpu_millicores"`
+	DonatedMemoryMB      int `json:"donated_memory_mb" db:"donated_memory_mb"`
+
 	ZoneName  string `json:"zone_name" db:"zone_name"`
 	AccountID int    `json:"account_id" db:"account_id"`
+
+	QuotaWeight float64 `json:"quota_weight" db:"quota_weight"` // the account's, joined in
+}
+
+// A k8s node that an account donated into a zone
+type DonatedNode struct {
+	DonatedNodeID int       `json:"donated_node_id" db:"donated_node_id"`
+	DetectedAt    time.Time `json:"detected_at" db:"detected_at"`
+	ZoneName      string    `json:"zone_name" db:"zone_name"`
+	NodeName      string    `json:"node_name" db:"node_name"`
+	AccountID     int       `json:"account_id" db:"account_id"`
+
+	Status     string     `json:"status" db:"status"` // pending | approved | retired
+	ApprovedAt *time.Time `json:"approved_at" db:"approved_at"`
+	RetiredAt  *time.Time `json:"retired_at" db:"retired_at"`
+
+	LastSeenAt    *time.Time `json:"last_seen_at" db:"last_seen_at"`
+	Ready         bool       `json:"ready" db:"ready"`
+	CPUMilliCores int        `json:"cpu_millicores" db:"cpu_millicores"` // allocatable
+	MemoryMB      int        `json:"memory_mb" db:"memory_mb"`           // allocatable
+
+	UpSeconds       int64 `json:"up_seconds" db:"up_seconds"`
+	ObservedSeconds int64 `json:"observed_seconds" db:"observed_seconds"`
+}
+
+// The share of the time since it was detected that the node was up, from 0 to 1
+func (d DonatedNode) Uptime() float64 {
+	if d.ObservedSeconds == 0 {
+		return 0
+	}
+	return float64(d.UpSeconds) / float64(d.ObservedSeconds)
+}
+
+// A usage row that didn't add up to the account's live claims, found (and corrected) by the reconciliation
+type ResourceUsageDrift struct {
+	ResourceUsageDriftID int       `json:"container_resource_usage_drift_id" db:"container_resource_usage_drift_id"`
+	FoundAt              time.Time `json:"found_at" db:"found_at"`
+	ZoneName             string    `json:"zone_name" db:"zone_name"`
+	AccountID            int       `json:"account_id" db:"account_id"`
+
+	RecordedCPUMilliCores int `json:"recorded_cpu_millicores" db:"recorded_cpu_millicores"`
+	ActualCPUMilliCores   int `json:"actual_cpu_millicores" db:"actual_cpu_millicores"`
+	RecordedMemoryMB      int `json:"recorded_memory_mb" db:"recorded_memory_mb"`
+	ActualMemoryMB        int `json:"actual_memory_mb" db:"actual_memory_mb"`
+}
+
+// Whether the account takes anything out of the shared pool, ie. uses more than it has reserved or earned by donating
+func (u ContainerResourceUsagePerAccountPerZone) IsUsingPool() bool {
+	return u.UsedCPUMilliCores > u.ReservedCPUMilliCores+u.DonatedCPUMilliCores || u.UsedMemoryMB > u.ReservedMemoryMB+u.DonatedMemoryMB
+}
+
+// An account's quota for one resource in one zone. Every number is in millicores for CPU and MB for memory.
+type ResourceQuotaLimit struct {
+	Capacity   int `json:"capacity"`   // all of the zone
+	Pool       int `json:"pool"`       // what's left to share out once every account's reservation is taken off
+	Reserved   int `json:"reserved"`   // set aside for this account alone
+	Donated    int `json:"donated"`    // the part of Reserved that's credit for donated nodes
+	Share      int `json:"share"`      // this account's weighted part of the pool
+	Guaranteed int `json:"guaranteed"` // reserved + share
+	Burst      int `json:"burst"`      // on top of that, borrowed from pool capacity nobody's using right now
+	Limit      int `json:"limit"`      // guaranteed + burst
+	Used       int `json:"used"`
+	Available  int `json:"available"` // limit - used
+}
+
+type ResourceQuota struct {
+	ZoneName       string             `json:"zone_name"`
+	Weight         float64            `json:"weight"`
+	ActiveAccounts int                `json:"active_accounts"` // the pool gets shared between these: the ones using it, plus this account
+	CPUMilliCores  ResourceQuotaLimit `json:"cpu_millicores"`
+	MemoryMB       ResourceQuotaLimit `json:"memory_mb"`
+}
+
+func (q ResourceQuota) Fits(cpuMilliCores, memoryMB int) bool {
+	return cpuMilliCores <= q.CPUMilliCores.Available && memoryMB <= q.MemoryMB.Available
+}
+
+func (q ResourceQuota) Explain() string {
+	return fmt.Sprintf("You have %vm of %vm CPU and %vMB of %vMB memory left in zone %s (%vm CPU and %vMB memory guaranteed, the rest is borrowed while nobody else needs it)",
+		q.CPUMilliCores.Available, q.CPUMilliCores.Limit, q.MemoryMB.Available, q.MemoryMB.Limit, q.ZoneName, q.CPUMilliCores.Guaranteed, q.MemoryMB.Guaranteed)
+}
+
+// Works out an account's quota in a zone from everyone's usage there. Reservations and donor credit come off the
+// zone's capacity first, and the rest is shared between the accounts using it by weight, so that accounts which don't use a zone
+// don't shrink anyone's share. On top of its share an account may borrow pool capacity nobody's using, up to the
+// zone's burst factor times its share, but never what it'd take for itself to get to its own share.
+func ComputeResourceQuota(zone ContainerZone, usages []ContainerResourceUsagePerAccountPerZone, accountID int) (quota ResourceQuota) {
+	quota.ZoneName = zone.Name
+
+	own := ContainerResourceUsagePerAccountPerZone{AccountID: accountID, QuotaWeight: 1}
+	totalWeight := 0.0
+	for _, usage := range usages {
+		if usage.AccountID == accountID {
+			own = usage
+			continue
+		}
+		if usage.IsUsingPool() {
+			totalWeight += usage.QuotaWeight
+			quota.ActiveAccounts++
+		}
+	}
+	totalWeight += own.QuotaWeight
+	quota.ActiveAccounts++
+	quota.Weight = own.QuotaWeight
+	weightShare := own.QuotaWeight / totalWeight
+
+	quota.CPUMilliCores = computeResourceQuotaLimit(zone.CPUMilliCores, zone.QuotaBurstFactor, weightShare, own, usages,
+		func(u ContainerResourceUsagePerAccountPerZone) (used, reserved, donated int) {
+			return u.UsedCPUMilliCores, u.ReservedCPUMilliCores, u.DonatedCPUMilliCores
+		})
+	quota.MemoryMB = computeResourceQuotaLimit(zone.MemoryMB, zone.QuotaBurstFactor, weightShare, own, usages,
+		func(u ContainerResourceUsagePerAccountPerZone) (used, reserved, donated int) {
+			return u.UsedMemoryMB, u.ReservedMemoryMB, u.DonatedMemoryMB
+		})
+
+	return quota
+}
+
+func computeResourceQuotaLimit(capacity int, burstFactor, weightShare float64, own ContainerResourceUsagePerAccountPerZone, usages []ContainerResourceUsagePerAccountPerZone,
+	resource func(ContainerResourceUsagePerAccountPerZone) (used, reserved, donated int)) (limit ResourceQuotaLimit) {
+	limit.Capacity = capacity
+	var ownReserved int
+	limit.Used, ownReserved, limit.Donated = resource(own)
+	limit.Reserved = ownReserved + limit.Donated
+
+	totalReserved, totalPoolUsed := 0, 0
+	for _, usage := range usages {
+		used, reserved, donated := resource(usage)
+		totalReserved += reserved + donated
+		totalPoolUsed += max(0, used-reserved-donated)
+	}
+	limit.Pool = max(0, capacity-totalReserved)
+	limit.Share = int(float64(limit.Pool) * weightShare)
+	limit.Guaranteed = limit.Reserved + limit.Share
+
+	ownPoolUsed := max(0, limit.Used-limit.Reserved)
+	alreadyBorrowed := max(0, ownPoolUsed-limit.Share)
+	freeBeyondOwnShare := max(0, limit.Pool-totalPoolUsed-max(0, limit.Share-ownPoolUsed))
+	limit.Burst = min(alreadyBorrowed+freeBeyondOwnShare, int(float64(limit.Share)*burstFactor))
+
+	limit.Limit = limit.Guaranteed + limit.Burst
+	limit.Available = max(0, limit.Limit-limit.Used)
+	return limit
+}
+
+// One row of a project's credit ledger
+type BillingEntry struct {
+	BillingID      int            `json:"billing_id" db:"billing_id"`
+	CreatedAt      time.Time      `json:"created_at" db:"created_at"`
+	ProjectID      int            `json:"project_id" db:"project_id"`
+	Kind           string         `json:"kind" db:"kind"` // opening | usage | flat_fee | donation | top_up | adjustment
+	CreditsDelta   float64        `json:"credits_delta" db:"credits_delta"`
+	CurrentCredits float64        `json:"current_credits" db:"current_credits"` // the running balance
+	Details        BillingDetails `json:"details" db:"details"`
+
+	PeriodStart       *time.Time `json:"period_start" db:"period_start"`
+	PeriodEnd         *time.Time `json:"period_end" db:"period_end"`
+	CPUMilliCoreHours float64    `json:"cpu_millicore_hours" db:"cpu_millicore_hours"`
+	MemoryMBHours     float64    `json:"memory_mb_hours" db:"memory_mb_hours"`
+	StorageGBHours    float64    `json:"storage_gb_hours" db:"storage_gb_hours"`
+
+	IdempotencyKey *string `json:"idempotency_key" db:"idempotency_key"`
+}
+
+type BillingDetails struct {
+	Project        *BillingDetailsProject `json:"project,omitempty"`          // opening entries
+	Claims         []MeteredClaim         `json:"claims,omitempty"`           // usage entries
+	DonatedNodeIDs []int                  `json:"donated_node_ids,omitempty"` // donation entries
+	Note           string                 `json:"note,omitempty"`             // top-ups and adjustments
+	ByAccountID    int                    `json:"by_account_id,omitempty"`
+}
+
+type BillingDetailsProject struct {
+	ID   string `json:"id"`
+	Name string `json:"name"`
+}
+
+func (d *BillingDetails) Scan(src interface{}) error {
+	return parseJSONToModel(src, d)
+}
+
+func (d BillingDetails) Value() (driver.Value, error) {
+	return json.Marshal(d)
+}
+
+// What one claim used in a metering period. Claims running in several zones count once per zone.
+type MeteredClaim struct {
+	Kind          string    `json:"kind" db:"kind"` // container | user_db | object_storage
+	ClaimID       int       `json:"claim_id" db:"claim_id"`
+	Name          string    `json:"name" db:"name"`
+	CPUMilliCores int       `json:"cpu_millicores" db:"cpu_millicores"`
+	MemoryMB      int       `json:"memory_mb" db:"memory_mb"`
+	StorageGB     int       `json:"storage_gb" db:"storage_gb"`
+	Replicas      int       `json:"replicas" db:"replicas"`
+	From          time.Time `json:"from" db:"metered_from"` // the part of the period it was around for
+	Until         time.Time `json:"until" db:"metered_until"`
+
+	CPUMilliCoreHours float64 `json:"cpu_millicore_hours"`
+	MemoryMBHours     float64 `json:"memory_mb_hours"`
+	StorageGBHours    float64 `json:"storage_gb_hours"`
+	Credits           float64 `json:"credits"`
+}
+
+// Works out the claim's resource-hours and what they cost
+func (c *MeteredClaim) Meter(policy BillingPolicy) {
+	replicaHours := c.Until.Sub(c.From).Hours() * float64(max(c.Replicas, 1))
+	if replicaHours < 0 {
+		replicaHours = 0
+	}
+	c.CPUMilliCoreHours = float64(c.CPUMilliCores) * replicaHours
+	c.MemoryMBHours = float64(c.MemoryMB) * replicaHours
+	c.StorageGBHours = float64(c.StorageGB) * replicaHours
+	c.Credits = c.CPUMilliCoreHours*policy.CreditsPerCPUMilliCoreHour + c.MemoryMBHours*policy.CreditsPerMemoryMBHour +
+		c.StorageGBHours*policy.CreditsPerStorageGBHour
+}
+
+// Whether a project that's been below the threshold since exhaustedAt should be suspended by now
+func (p SuspensionPolicy) ShouldSuspend(exhaustedAt time.Time, now time.Time) bool {
+	return p.Enabled && !now.Before(exhaustedAt.Add(time.Duration(p.GracePeriodHours)*time.Hour))
+}
+
+// What one container used in one zone at the time it was sampled, summed over its pods
+type ContainerUsageSample struct {
+	ContainerClaimID int
+	ZoneName         string
+	CPUMilliCores    int
+	MemoryMB         int
+}
+
+// Which usage to report on. ProjectID and AccountID are left at 0 to not filter on them.
+type UsageReportFilter struct {
+	ProjectID int
+	AccountID int // the account that created the containers
+	From      time.Time
+	To        time.Time
+	Format    string // json | csv
+}
+
+// Reads from, to (RFC 3339 timestamps, the last week by default) and format (json by default)
+func (f *UsageReportFilter) ParseFromHTTPForm(r *http.Request) (err error) {
+	f.To = time.Now()
+	if r.FormValue("to") != "" {
+		f.To, err = time.Parse(time.RFC3339, r.FormValue("to"))
+		if err != nil {
+			return fmt.Errorf("to has to be an RFC 3339 timestamp")
+		}
+	}
+	f.From = f.To.AddDate(0, 0, -7)
+	if r.FormValue("from") != "" {
+		f.From, err = time.Parse(time.RFC3339, r.FormValue("from"))
+		if err != nil {
+			return fmt.Errorf("from has to be an RFC 3339 timestamp")
+		}
+	}
+	if !f.From.Before(f.To) {
+		return fmt.Errorf("from has to be before to")
+	}
+
+	f.Format = r.FormValue("format")
+	if f.Format == "" {
+		f.Format = "json"
+	}
+	if f.Format != "json" && f.Format != "csv" {
+		return fmt.Errorf("format has to be json or csv")
+	}
+	return nil
+}
+
+// How one container did in one zone over the report's range, next to what it asked for
+type UsageReportRow struct {
+	ProjectID     int    `json:"project_id" db:"project_id"`
+	ProjectName   string `json:"project_name" db:"project_name"`
+	AccountID     int    `json:"account_id" db:"account_id"`
+	Username      string `json:"username" db:"username"`
+	ContainerID   int    `json:"container_claim_id" db:"container_claim_id"`
+	ContainerName string `json:"container_name" db:"container_name"`
+	ZoneName      string `json:"zone_name" db:"zone_name"`
+
+	FirstSampledAt time.Time `json:"first_sampled_at" db:"first_sampled_at"` // to the hour
+	LastSampledAt  time.Time `json:"last_sampled_at" db:"last_sampled_at"`
+	SampleCount    int       `json:"sample_count" db:"sample_count"`
+
+	RequestedCPUMilliCores int     `json:"requested_cpu_millicores" db:"requested_cpu_millicores"`
+	AvgCPUMilliCores       float64 `json:"avg_cpu_millicores" db:"avg_cpu_millicores"`
+	MaxCPUMilliCores       int     `json:"max_cpu_millicores" db:"max_cpu_millicores"`
+	RequestedMemoryMB      int     `json:"requested_memory_mb" db:"requested_memory_mb"`
+	AvgMemoryMB            float64 `json:"avg_memory_mb" db:"avg_memory_mb"`
+	MaxMemoryMB            int     `json:"max_memory_mb" db:"max_memory_mb"`
+
+	CPU    RightSizing `json:"cpu"`
+	Memory RightSizing `json:"memory"`
+}
+
+// Whether a claim asks for about what it uses. The suggestion leaves a quarter on top of the peak.
+type RightSizing struct {
+	Utilisation float64 `json:"utilisation"` // the average over what was requested, from 0 up
+	Verdict     string  `json:"verdict"`     // oversized | right-sized | undersized
+	Suggested   int     `json:"suggested"`
+}
+
+func (r *UsageReportRow) CompareRequestedWithUsed() {
+	r.CPU = rightSize(r.RequestedCPUMilliCores, r.AvgCPUMilliCores, r.MaxCPUMilliCores)
+	r.Memory = rightSize(r.RequestedMemoryMB, r.AvgMemoryMB, r.MaxMemoryMB)
+}
+
+func rightSize(requested int, avg float64, peak int) (sizing RightSizing) {
+	if requested > 0 {
+		sizing.Utilisation = avg / float64(requested)
+	}
+	// rounded up to the next 10
+	sizing.Suggested = max(10, (peak*5/4+9)/10*10)
+	switch {
+	case peak*2 < requested:
+		sizing.Verdict = "oversized"
+	case peak*10 >= requested*9:
+		sizing.Verdict = "undersized"
+	default:
+		sizing.Verdict = "right-sized"
+		sizing.Suggested = requested
+	}
+	return sizing
+}
+
+var usageReportCSVHeader = []string{
+	"project_id", "project_name", "account_id", "username", "container_claim_id", "container_name", "zone_name",
+	"first_sampled_at", "last_sampled_at", "sample_count",
+	"requested_cpu_millicores", "avg_cpu_millicores", "max_cpu_millicores", "cpu_utilisation", "cpu_verdict", "suggested_cpu_millicores",
+	"requested_memory_mb", "avg_memory_mb", "max_memory_mb", "memory_utilisation", "memory_verdict", "suggested_memory_mb",
+}
+
+func WriteUsageReportCSV(w io.Writer, rows []UsageReportRow) error {
+	csvWriter := csv.NewWriter(w)
+	err := csvWriter.Write(usageReportCSVHeader)
+	if err != nil {
+		return err
+	}
+	for _, row := range rows {
+		err = csvWriter.Write([]string{
+			strconv.Itoa(row.ProjectID), row.ProjectName, strconv.Itoa(row.AccountID), row.Username, strconv.Itoa(row.ContainerID), row.ContainerName, row.ZoneName,
+			row.FirstSampledAt.Format(time.RFC3339), row.LastSampledAt.Format(time.RFC3339), strconv.Itoa(row.SampleCount),
+			strconv.Itoa(row.RequestedCPUMilliCores), strconv.FormatFloat(row.AvgCPUMilliCores, 'f', 1, 64), strconv.Itoa(row.MaxCPUMilliCores),
+			strconv.FormatFloat(row.CPU.Utilisation, 'f', 3, 64), row.CPU.Verdict, strconv.Itoa(row.CPU.Suggested),
+			strconv.Itoa(row.RequestedMemoryMB), strconv.FormatFloat(row.AvgMemoryMB, 'f', 1, 64), strconv.Itoa(row.MaxMemoryMB),
+			strconv.FormatFloat(row.Memory.Utilisation, 'f', 3, 64), row.Memory.Verdict, strconv.Itoa(row.Memory.Suggested),
+		})
+		if err != nil {
+			return err
+		}
+	}
+	csvWriter.Flush()
+	return csvWriter.Error()
+}
+
+// The stretches of time container metrics can be looked at over, from the shortest
+var MetricsRanges = []struct {
+	Name     string
+	Duration time.Duration
+}{
+	{"1h", time.Hour},
+	{"6h", 6 * time.Hour},
+	{"24h", 24 * time.Hour},
+	{"7d", 7 * 24 * time.Hour},
+	{"30d", 30 * 24 * time.Hour},
+}
+
+// About how many points a metric gets over its range, whatever the range is
+const metricsPointsPerRange = 120
+
+// Which stretch of a container's metrics to get, and how far apart its points are
+type MetricsRange struct {
+	Name string // one of MetricsRanges, or empty when from and to were given
+	From time.Time
+	To   time.Time
+	Step time.Duration
+}
+
+// Reads either range (one of MetricsRanges, 24h by default) or from and to (RFC 3339 timestamps)
+func (m *MetricsRange) ParseFromHTTPForm(r *http.Request) (err error) {
+	m.To = time.Now()
+	if r.FormValue("from") != "" || r.FormValue("to") != "" {
+		if r.FormValue("to") != "" {
+			m.To, err = time.Parse(time.RFC3339, r.FormValue("to"))
+			if err != nil {
+				return fmt.Errorf("to has to be an RFC 3339 timestamp")
+			}
+		}
+		m.From, err = time.Parse(time.RFC3339, r.FormValue("from"))
+		if err != nil {
+			return fmt.Errorf("from has to be an RFC 3339 timestamp")
+		}
+		if !m.From.Before(m.To) {
+			return fmt.Errorf("from has to be before to")
+		}
+	} else {
+		m.Name = r.FormValue("range")
+		if m.Name == "" {
+			m.Name = "24h"
+		}
+		for _, metricsRange := range MetricsRanges {
+			if metricsRange.Name == m.Name {
+				m.From = m.To.Add(-metricsRange.Duration)
+			}
+		}
+		if m.From.IsZero() {
+			return fmt.Errorf("range has to be one of 1h, 6h, 24h, 7d or 30d")
+		}
+	}
+
+	m.Step = max(30*time.Second, (m.To.Sub(m.From) / metricsPointsPerRange).Truncate(time.Second))
+	return nil
+}
+
+// One value of a metric at one point in time
+type MetricPoint struct {
+	Time  time.Time `json:"time"`
+	Value float64   `json:"value"`
+}
+
+func PeakOf(points ...[]MetricPoint) (peak float64) {
+	for _, somePoints := range points {
+		for _, point := range somePoints {
+			peak = max(peak, point.Value)
+		}
+	}
+	return peak
+}
+
+// How close to its limit a container has to get for it to count as hitting it, and how much of the time its CPU has
+// to be throttled for that
+const (
+	limitHitRatio        = 0.9
+	cpuThrottledHitRatio = 0.25
+)
+
+// What a container used in one zone over a MetricsRange, summed over its pods. Its limits are the same as what it
+// requests, so going over them means getting throttled (CPU) or OOM killed (memory).
+type ContainerZoneMetrics struct {
+	ZoneName string `json:"zone_name"`
+	Source   string `json:"source"` // prometheus | metrics-api
+	Error    string `json:"error,omitempty"`
+
+	CPUMilliCores []MetricPoint `json:"cpu_millicores"`
+	MemoryMB      []MetricPoint `json:"memory_mb"`
+	// metrics-api only: its history comes from the hourly usage rollups, where the averages hide the peaks
+	CPUMilliCoresPeak []MetricPoint `json:"cpu_millicores_peak,omitempty"`
+	MemoryMBPeak      []MetricPoint `json:"memory_mb_peak,omitempty"`
+	// prometheus only, the share of CPU periods the container got throttled in
+	CPUThrottledRatio             []MetricPoint `json:"cpu_throttled_ratio,omitempty"`
+	NetworkReceiveBytesPerSecond  []MetricPoint `json:"network_receive_bytes_per_second,omitempty"`
+	NetworkTransmitBytesPerSecond []MetricPoint `json:"network_transmit_bytes_per_second,omitempty"`
+
+	// from the pods that are around now, so only their last termination is known
+	Restarts int         `json:"restarts"`
+	OOMKills []time.Time `json:"oom_kills"`
+
+	CPULimitHit    bool     `json:"cpu_limit_hit"`
+	MemoryLimitHit bool     `json:"memory_limit_hit"`
+	Highlights     []string `json:"highlights"`
+}
+
+// Works out whether the limits got hit and sums up what went wrong in a few sentences
+func (z *ContainerZoneMetrics) Highlight(cpuMilliCoresLimit int, memoryMBLimit int) {
+	z.Highlights = []string{}
+
+	peakCPU := PeakOf(z.CPUMilliCores, z.CPUMilliCoresPeak)
+	peakThrottled := PeakOf(z.CPUThrottledRatio)
+	z.CPULimitHit = peakCPU >= limitHitRatio*float64(cpuMilliCoresLimit) || peakThrottled >= cpuThrottledHitRatio
+	if z.CPULimitHit {
+		z.Highlights = append(z.Highlights, fmt.Sprintf("CPU got up to %.0f of its %v millicores", peakCPU, cpuMilliCoresLimit))
+		if peakThrottled > 0 {
+			z.Highlights = append(z.Highlights, fmt.Sprintf("Throttled in up to %.0f%% of CPU periods", peakThrottled*100))
+		}
+	}
+
+	peakMemory := PeakOf(z.MemoryMB, z.MemoryMBPeak)
+	z.MemoryLimitHit = peakMemory >= limitHitRatio*float64(memoryMBLimit)
+	if z.MemoryLimitHit {
+		z.Highlights = append(z.Highlights, fmt.Sprintf("Memory got up to %.0f of its %vMB", peakMemory, memoryMBLimit))
+	}
+
+	if len(z.OOMKills) > 0 {
+		z.Highlights = append(z.Highlights, fmt.Sprintf("OOM killed, last at %s", slices.MaxFunc(z.OOMKills, time.Time.Compare).Format("2006-01-02 15:04")))
+	}
+	if z.Restarts > 0 {
+		z.Highlights = append(z.Highlights, fmt.Sprintf("Restarted %v times", z.Restarts))
+	}
+}
+
+type ContainerMetrics struct {
+	ContainerName string    `json:"container_name"`
+	From          time.Time `json:"from"`
+	To            time.Time `json:"to"`
+	StepSeconds   int       `json:"step_seconds"`
+	// the same as its limits
+	RequestedCPUMilliCores int                    `json:"requested_cpu_millicores"`
+	RequestedMemoryMB      int                    `json:"requested_memory_mb"`
+	Zones                  []ContainerZoneMetrics `json:"zones"`
+}
+
+// One hour of a container's usage in one zone, as the usage sampler rolled it up
+type ContainerUsageRollup struct {
+	ContainerClaimID int       `db:"container_claim_id"`
+	ZoneName         string    `db:"zone_name"`
+	Hour             time.Time `db:"hour"`
+	SampleCount      int       `db:"sample_count"`
+	CPUMilliCoresSum int       `db:"cpu_millicores_sum"`
+	CPUMilliCoresMax int       `db:"cpu_millicores_max"`
+	MemoryMBSum      int       `db:"memory_mb_sum"`
+	MemoryMBMax      int       `db:"memory_mb_max"`
+}
+
+type ContainerClaimCount struct {
+	Status  string `db:"status"`
+	RunType string `db:"run_type"`
+	Count   int    `db:"count"`
+}
+
+// A zone's capacity next to what's charged to the containers in it
+type ZoneUsage struct {
+	ZoneName          string `db:"zone_name"`
+	CPUMilliCores     int    `db:"cpu_millicores"`
+	MemoryMB          int    `db:"memory_mb"`
+	UsedCPUMilliCores int    `db:"used_cpu_millicores"`
+	UsedMemoryMB      int    `db:"used_memory_mb"`
+}
+
+// What alert rules can watch for, and what their threshold means for each
+var AlertRuleKinds = []string{"container_crash_looping", "container_oom_killed", "job_failed", "db_storage", "quota", "certificate_expiring"}
+
+var alertRuleDefaultThresholds = map[string]float64{
+	"container_crash_looping": 3,   // restarts while down
+	"db_storage":              0.8, // share of the claim's storage_gb
+	"quota":                   0.9, // share of a member's CPU or memory quota in a zone
+	"certificate_expiring":    14,  // days left
+}
+
+type AlertRule struct {
+	AlertRuleID        int        `json:"alert_rule_id" db:"alert_rule_id"`
+	CreatedAt          time.Time  `json:"created_at" db:"created_at"`
+	DeletedAt          *time.Time `json:"deleted_at" db:"deleted_at"`
+	ProjectID          int        `json:"project_id" db:"project_id"`
+	CreatedByAccountID int        `json:"created_by_account_id" db:"created_by_account_id"`
+
+	Name                   string        `json:"name" db:"name"`
+	Kind                   string        `json:"kind" db:"kind"`
+	Target                 string        `json:"target" db:"target"` // a container name, a member's username for quotas or host:port for certificates, empty for all of the project's
+	Threshold              float64       `json:"threshold" db:"threshold"`
+	NotificationChannelIDs pq.Int64Array `json:"notification_channel_ids" db:"notification_channel_ids"`
+
+	RepeatAfterMinutes int        `json:"repeat_after_minutes" db:"repeat_after_minutes"` // 0 to only send it when it starts firing
+	SilencedUntil      *time.Time `json:"silenced_until" db:"silenced_until"`
+}
+
+// Whether an alert that's still firing should be sent again
+func (a AlertRule) IsDueAgain(lastNotifiedAt time.Time, now time.Time) bool {
+	return a.RepeatAfterMinutes > 0 && now.Sub(lastNotifiedAt) >= time.Duration(a.RepeatAfterMinutes)*time.Minute
+}
+
+func (a AlertRule) IsSilenced(now time.Time) bool {
+	return a.SilencedUntil != nil && now.Before(*a.SilencedUntil)
+}
+
+// Reads name, kind, target, threshold (the kind's default if left out), repeat-after-minutes and channel[] (names of
+// the project's channels)
+func (a *AlertRule) ParseFromHTTPForm(r *http.Request, channels []NotificationChannel) (err error) {
+	a.Name = r.FormValue("name")
+	if a.Name == "" {
+		return fmt.Errorf("The alert rule needs a name")
+	}
+	a.Kind = r.FormValue("kind")
+	if !slices.Contains(AlertRuleKinds, a.Kind) {
+		return fmt.Errorf("kind has to be one of %s", strings.Join(AlertRuleKinds, ", "))
+	}
+	a.Target = r.FormValue("target")
+	if a.Kind == "certificate_expiring" {
+		if a.Target == "" {
+			return fmt.Errorf("Certificate alerts need a host:port to check as their target")
+		}
+		// it gets connected to from here, so like webhooks it has to be somewhere public
+		host, _, err := net.SplitHostPort(a.Target)
+		if err != nil {
+			host = a.Target
+		}
+		err = CheckPublicHost(host)
+		if err != nil {
+			return err
+		}
+	}
+
+	a.Threshold = alertRuleDefaultThresholds[a.Kind]
+	if r.FormValue("threshold") != "" {
+		a.Threshold, err = strconv.ParseFloat(r.FormValue("threshold"), 64)
+		if err != nil || a.Threshold < 0 {
+			return fmt.Errorf("threshold has to be a number, 0 or more")
+		}
+	}
+	if (a.Kind == "db_storage" || a.Kind == "quota") && a.Threshold > 1 {
+		return fmt.Errorf("threshold has to be a share from 0 to 1 for %s alerts", a.Kind)
+	}
+
+	if r.FormValue("repeat-after-minutes") != "" {
+		a.RepeatAfterMinutes, err = strconv.Atoi(r.FormValue("repeat-after-minutes"))
+		if err != nil || a.RepeatAfterMinutes < 0 {
+			return fmt.Errorf("repeat-after-minutes has to be a number, 0 or more")
+		}
+	}
+
+	a.NotificationChannelIDs = pq.Int64Array{}
+	for _, channelName := range r.Form["channel[]"] {
+		i := slices.IndexFunc(channels, func(channel NotificationChannel) bool { return channel.Name == channelName })
+		if i == -1 {
+			return fmt.Errorf("There's no notification channel called %s in this project", channelName)
+		}
+		a.NotificationChannelIDs = append(a.NotificationChannelIDs, int64(channels[i].NotificationChannelID))
+	}
+	return nil
+}
+
+var NotificationChannelKinds = []string{"webhook", "slack", "discord"}
+
+// Somewhere a project's alerts get sent to. Webhooks get the notification as generic JSON, slack and discord get
+// what their incoming webhooks take.
+type NotificationChannel struct {
+	NotificationChannelID int        `json:"notification_channel_id" db:"notification_channel_id"`
+	CreatedAt             time.Time  `json:"created_at" db:"created_at"`
+	DeletedAt             *time.Time `json:"deleted_at" db:"deleted_at"`
+	ProjectID             int        `json:"project_id" db:"project_id"`
+
+	Name string `json:"name" db:"name"`
+	Kind string `json:"kind" db:"kind"`
+	URL  string `json:"url" db:"url"`
+}
+
+func (c *NotificationChannel) ParseFromHTTPForm(r *http.Request) error {
+	c.Name = r.FormValue("name")
+	if c.Name == "" {
+		return fmt.Errorf("The notification channel needs a name")
+	}
+	c.Kind = r.FormValue("kind")
+	if !slices.Contains(NotificationChannelKinds, c.Kind) {
+		return fmt.Errorf("kind has to be one of %s", strings.Join(NotificationChannelKinds, ", "))
+	}
+	c.URL = r.FormValue("url")
+	return CheckOutboundURL(c.URL)
+}
+
+// Something a rule found to be wrong, ie. one container crash-looping in one zone
+type Alert struct {
+	Fingerprint string // stays the same while it's the same thing that's wrong
+	Title       string
+	Message     string
+}
+
+type AlertFiring struct {
+	AlertRuleID    int       `json:"alert_rule_id" db:"alert_rule_id"`
+	Fingerprint    string    `json:"fingerprint" db:"fingerprint"`
+	FiringSince    time.Time `json:"firing_since" db:"firing_since"`
+	LastNotifiedAt time.Time `json:"last_notified_at" db:"last_notified_at"`
+	Title          string    `json:"title" db:"title"`
+}
+
+type Notification struct {
+	NotificationID int       `json:"notification_id" db:"notification_id"`
+	CreatedAt      time.Time `json:"created_at" db:"created_at"`
+	ProjectID      int       `json:"project_id" db:"project_id"`
+	AlertRuleID    int       `json:"alert_rule_id" db:"alert_rule_id"`
+
+	Fingerprint string                 `json:"fingerprint" db:"fingerprint"`
+	Title       string                 `json:"title" db:"title"`
+	Message     string                 `json:"message" db:"message"`
+	Resolved    bool                   `json:"resolved" db:"resolved"`
+	Silenced    bool                   `json:"silenced" db:"silenced"`
+	Deliveries  NotificationDeliveries `json:"deliveries" db:"deliveries"`
+	ReadAt      *time.Time             `json:"read_at" db:"read_at"`
+}
+
+type NotificationDelivery struct {
+	NotificationChannelID int    `json:"notification_channel_id"`
+	ChannelName           string `json:"channel_name"`
+	OK                    bool   `json:"ok"`
+	Error                 string `json:"error,omitempty"`
+}
+
+type NotificationDeliveries []NotificationDelivery
+
+func (d *NotificationDeliveries) Scan(src interface{}) error {
+	return parseJSONToModel(src, d)
+}
+
+func (d NotificationDeliveries) Value() (driver.Value, error) {
+	if d == nil {
+		return []byte("[]"), nil
+	}
+	return json.Marshal(d)
+}
+
+var WebhookEvents = []string{
+	"container.created", "container.active", "container.error", "container.deleted",
+	"db.created", "db.user_created", "db.deleted",
+	"object_storage.created", "object_storage.deleted",
+}
+
+// A delivery that keeps failing gets tried this many times, waiting webhookRetryBackoff and then four times as long
+// after every failed attempt (so for about three hours in all) before it's given up on
+const (
+	webhookMaxAttempts  = 6
+	webhookRetryBackoff = 30 * time.Second
+)
+
+// Somewhere a project's lifecycle events get sent, signed with the secret
+type WebhookSubscription struct {
+	WebhookSubscriptionID int        `json:"webhook_subscription_id" db:"webhook_subscription_id"`
+	CreatedAt             time.Time  `json:"created_at" db:"created_at"`
+	DeletedAt             *time.Time `json:"deleted_at" db:"deleted_at"`
+	ProjectID             int        `json:"project_id" db:"project_id"`
+
+	Name   string         `json:"name" db:"name"`
+	URL    string         `json:"url" db:"url"`
+	Secret string         `json:"secret" db:"secret"`
+	Events pq.StringArray `json:"events" db:"events"` // every event if empty
+}
+
+func (s WebhookSubscription) Wants(event string) bool {
+	return len(s.Events) == 0 || slices.Contains(s.Events, event)
+}
+
+// Reads name, url, event[] (every event if left out) and secret, which gets generated if it's left out
+func (s *WebhookSubscription) ParseFromHTTPForm(r *http.Request) error {
+	s.Name = r.FormValue("name")
+	if s.Name == "" {
+		return fmt.Errorf("The webhook needs a name")
+	}
+	s.URL = r.FormValue("url")
+	err := CheckOutboundURL(s.URL)
+	if err != nil {
+		return err
+	}
+
+	s.Events = pq.StringArray{}
+	for _, event := range r.Form["event[]"] {
+		if !slices.Contains(WebhookEvents, event) {
+			return fmt.Errorf("%s isn't an event, they're %s", event, strings.Join(WebhookEvents, ", "))
+		}
+		s.Events = append(s.Events, event)
+	}
+
+	s.Secret = r.FormValue("secret")
+	if s.Secret == "" {
+		s.Secret, err = newSecret()
+		if err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+// Something that happened to one of a project's resources. Data is whatever the event says about it.
+type WebhookEvent struct {
+	WebhookEventID int             `json:"webhook_event_id" db:"webhook_event_id"`
+	CreatedAt      time.Time       `json:"created_at" db:"created_at"`
+	ProjectID      int             `json:"project_id" db:"project_id"`
+	Event          string          `json:"event" db:"event"`
+	Data           json.RawMessage `json:"data" db:"data"`
+}
+
+// What gets POSTed to the subscriptions, the same every time the event's (re)delivered
+type WebhookPayload struct {
+	ID        int             `json:"id"` // the event's, so receivers can tell redeliveries apart from new events
+	Event     string          `json:"event"`
+	CreatedAt time.Time       `json:"created_at"`
+	Project   string          `json:"project"`
+	Data      json.RawMessage `json:"data"`
+}
+
+type WebhookDelivery struct {
+	WebhookDeliveryID     int       `json:"webhook_delivery_id" db:"webhook_delivery_id"`
+	CreatedAt             time.Time `json:"created_at" db:"created_at"`
+	WebhookSubscriptionID int       `json:"webhook_subscription_id" db:"webhook_subscription_id"`
+	WebhookEventID        int       `json:"webhook_event_id" db:"webhook_event_id"`
+	RedeliveryOf          *int      `json:"redelivery_of" db:"redelivery_of"`
+
+	Status         string     `json:"status" db:"status"` // pending | delivered | failed
+	Attempts       int        `json:"attempts" db:"attempts"`
+	NextAttemptAt  time.Time  `json:"next_attempt_at" db:"next_attempt_at"`
+	LastAttemptAt  *time.Time `json:"last_attempt_at" db:"last_attempt_at"`
+	ResponseStatus *int       `json:"response_status" db:"response_status"`
+	Error          *string    `json:"error" db:"error"`
+
+	// joined in for the delivery log
+	Event            string `json:"event" db:"event"`
+	SubscriptionName string `json:"subscription_name" db:"subscription_name"`
+}
+
+// When to try again after the attempt that just failed, if at all
+func (d WebhookDelivery) RetryAt(now time.Time) (time.Time, bool) {
+	if d.Attempts >= webhookMaxAttempts {
+		return now, false
+	}
+	return now.Add(webhookRetryBackoff << (2 * (d.Attempts - 1))), true
+}
+
+// A container's secret deploy URL. Registry push webhooks and CI jobs call it to have a new tag or digest of the
+// container's image deployed, and with FollowTag the registry gets polled for the tag moving on as well.
+type DeployHook struct {
+	DeployHookID       int        `json:"deploy_hook_id" db:"deploy_hook_id"`
+	CreatedAt          time.Time  `json:"created_at" db:"created_at"`
+	DeletedAt          *time.Time `json:"deleted_at" db:"deleted_at"`
+	ProjectID          int        `json:"project_id" db:"project_id"`
+	CreatedByAccountID int        `json:"created_by_account_id" db:"created_by_account_id"`
+
+	ContainerName string `json:"container_name" db:"container_name"`
+	Token         string `json:"-" db:"token"`
+	FollowTag     bool   `json:"follow_tag" db:"follow_tag"`
+
+	LastPolledAt    *time.Time `json:"last_polled_at" db:"last_polled_at"`
+	LastTriggeredAt *time.Time `json:"last_triggered_at" db:"last_triggered_at"`
+	LastResult      *string    `json:"last_result" db:"last_result"`
+}
+
+func NewDeployHookToken() (string, error) {
+	return newSecret()
+}
+
+// For URLs the service sends requests to on a project's behalf: they have to be http(s), and their host can't be this
+// machine, a private network or the cloud's metadata service. The host is checked again when it's dialled, since what
+// it resolves to can change after it's been saved.
+func CheckOutboundURL(rawURL string) error {
+	parsedURL, err := url.Parse(rawURL)
+	if err != nil || (parsedURL.Scheme != "https" && parsedURL.Scheme != "http") || parsedURL.Hostname() == "" {
+		return fmt.Errorf("url has to be an http(s) URL")
+	}
+	return CheckPublicHost(parsedURL.Hostname())
+}
+
+// Whether everything host resolves to is public, see CheckOutboundURL
+func CheckPublicHost(host string) error {
+	ips, err := net.LookupIP(host)
+	if err != nil {
+		return fmt.Errorf("%s couldn't be looked up", host)
+	}
+	for _, ip := range ips {
+		if !IsPublicIP(ip) {
+			return fmt.Errorf("Only public hosts can be reached from here, and %s is at %s", host, ip)
+		}
+	}
+	return nil
+}
+
+// Ranges that aren't on the internet either, but that net.IP doesn't know about
+var nonPublicPrefixes = []netip.Prefix{
+	netip.MustParsePrefix("0.0.0.0/8"),      // "this network"
+	netip.MustParsePrefix("100.64.0.0/10"),  // CGNAT, ie. Tailscale and some cluster networks
+	netip.MustParsePrefix("64:ff9b::/96"),   // NAT64, which reaches IPv4 addresses through the IPv6 ones
+	netip.MustParsePrefix("64:ff9b:1::/48"), // local NAT64
+}
+
+// Whether ip is out on the internet, rather than loopback, private, link-local (ie. 169.254.169.254) or one of
+// nonPublicPrefixes
+func IsPublicIP(ip net.IP) bool {
+	if ip.IsLoopback() || ip.IsPrivate() || ip.IsLinkLocalUnicast() || ip.IsLinkLocalMulticast() || ip.IsInterfaceLocalMulticast() || ip.IsMulticast() || ip.IsUnspecified() {
+		return false
+	}
+	addr, ok := netip.AddrFromSlice(ip)
+	if !ok {
+		return false
+	}
+	addr = addr.Unmap()
+	return !slices.ContainsFunc(nonPublicPrefixes, func(prefix netip.Prefix) bool { return prefix.Contains(addr) })
+}
+
+// 32 random bytes as hex, for webhook secrets and deploy hook tokens
+func newSecret() (string, error) {
+	secret := make([]byte, 32)
+	_, err := rand.Read(secret)
+	if err != nil {
+		return "", err
+	}
+	return hex.EncodeToString(secret), nil
+}
+
+// Where it gets called, with baseURL being wherever this service is reached at
+func (h DeployHook) URL(baseURL string) string {
+	return strings.TrimSuffix(baseURL, "/") + "/deploy-hook/" + h.Token
+}
+
+// An image built by a Kaniko job in the project's namespace, from a git repo or an uploaded tarball. It gets pushed
+// to the registry of every zone in PushZones, and a container waiting on it gets deployed once it's done.
+type ImageBuild struct {
+	ImageBuildID       int       `json:"image_build_id" db:"image_build_id"`
+	CreatedAt          time.Time `json:"created_at" db:"created_at"`
+	ProjectID          int       `json:"project_id" db:"project_id"`
+	CreatedByAccountID int       `json:"created_by_account_id" db:"created_by_account_id"`
+
+	Source         string  `json:"source" db:"source"` // git | tarball
+	GitURL         *string `json:"git_url" db:"git_url"`
+	GitRef         *string `json:"git_ref" db:"git_ref"`
+	ContextPath    string  `json:"context_path" db:"context_path"`
+	DockerfilePath string  `json:"dockerfile_path" db:"dockerfile_path"`
+	ContextTarball []byte  `json:"-" db:"context_tarball"`
+	ContextToken   *string `json:"-" db:"context_token"`
+
+	ZoneName    string         `json:"zone_name" db:"zone_name"`
+	PushZones   pq.StringArray `json:"push_zones" db:"push_zones"`
+	ImageRef    string         `json:"image_ref" db:"image_ref"`
+	ImageTag    string         `json:"image_tag" db:"image_tag"`
+	ImageDigest *string        `json:"image_digest" db:"image_digest"`
+
+	CPUMilliCores   int        `json:"cpu_millicores" db:"cpu_millicores"`
+	MemoryMB        int        `json:"memory_mb" db:"memory_mb"`
+	UsageReleasedAt *time.Time `json:"usage_released_at" db:"usage_released_at"`
+
+	Status     string     `json:"status" db:"status"` // queued | building | succeeded | failed
+	Error      *string    `json:"error" db:"error"`
+	Logs       *string    `json:"-" db:"logs"`
+	StartedAt  *time.Time `json:"started_at" db:"started_at"`
+	FinishedAt *time.Time `json:"finished_at" db:"finished_at"`
+
+	ContainerClaimID *int `json:"container_claim_id" db:"container_claim_id"`
+}
+
+func (b *ImageBuild) JobName() string {
+	return fmt.Sprintf("build-%v", b.ImageBuildID)
+}
+
+func (b *ImageBuild) IsFinished() bool {
+	return b.Status == "succeeded" || b.Status == "failed"
+}
+
+// The repo or tarball to build from, with maxContextBytes capping the tarball. Where it runs and what it's called
+// are up to whoever starts it.
+func (b *ImageBuild) ParseFromHTTPForm(r *http.Request, maxContextBytes int64) error {
+	b.Source = r.FormValue("build-source")
+	b.ContextPath = strings.Trim(r.FormValue("build-context-path"), "/")
+	b.DockerfilePath = r.FormValue("build-dockerfile")
+	if b.DockerfilePath == "" {
+		b.DockerfilePath = "Dockerfile"
+	}
+	if strings.HasPrefix(b.DockerfilePath, "/") || strings.Contains(b.DockerfilePath, "..") || strings.Contains(b.ContextPath, "..") {
+		return fmt.Errorf("build-dockerfile and build-context-path have to be paths inside the build context")
+	}
+
+	switch b.Source {
+	case "git":
+		gitURL := r.FormValue("build-git-url")
+		parsedURL, err := url.Parse(gitURL)
+		if err != nil || parsedURL.Scheme != "https" || parsedURL.Host == "" || parsedURL.User != nil || strings.Contains(gitURL, "#") {
+			return fmt.Errorf("build-git-url has to be a public https:// git repo URL, without credentials")
+		}
+		b.GitURL = &gitURL
+		if gitRef := r.FormValue("build-git-ref"); gitRef != "" {
+			if strings.ContainsAny(gitRef, "# ") {
+				return fmt.Errorf("build-git-ref has to be a branch, a \"refs/...\" ref or a commit")
+			}
+			b.GitRef = &gitRef
+		}
+	case "tarball":
+		file, _, err := r.FormFile("build-context")
+		if err != nil {
+			return fmt.Errorf("A tarball build needs a .tar.gz of the build context as build-context")
+		}
+		defer file.Close()
+		b.ContextTarball, err = io.ReadAll(io.LimitReader(file, maxContextBytes+1))
+		if err != nil {
+			return fmt.Errorf("Reading the uploaded build context failed: %w", err)
+		}
+		if int64(len(b.ContextTarball)) > maxContextBytes {
+			return fmt.Errorf("The build context can be %vMB at most", maxContextBytes>>20)
+		}
+		contextToken, err := newSecret()
+		if err != nil {
+			return err
+		}
+		b.ContextToken = &contextToken
+	default:
+		return fmt.Errorf("build-source has to be git or tarball")
+	}
+	return nil
+}
+
+// What Kaniko gets as its --context: a git:// URL with the ref or commit after a #, or the fetched tarball
+func (b *ImageBuild) KanikoContext() string {
+	if b.Source == "tarball" {
+		return "tar:///workspace/context.tar.gz"
+	}
+	context := "git://" + strings.TrimPrefix(*b.GitURL, "https://")
+	if b.GitRef == nil {
+		return context
+	}
+	gitRef := *b.GitRef
+	if !isCommitHash(gitRef) && !strings.HasPrefix(gitRef, "refs/") {
+		gitRef = "refs/heads/" + gitRef
+	}
+	return context + "#" + gitRef
+}
+
+func isCommitHash(gitRef string) bool {
+	if len(gitRef) != 40 {
+		return false
+	}
+	_, err := hex.DecodeString(gitRef)
+	return err == nil
+}
+
+// Where the job fetches an uploaded tarball from, with baseURL being wherever this service is reached at
+func (b *ImageBuild) ContextURL(baseURL string) string {
+	if b.ContextToken == nil {
+		return ""
+	}
+	return strings.TrimSuffix(baseURL, "/") + "/build-context/" + *b.ContextToken
+}
+
+// Where the build gets pushed to in the zone, ie. "registry.fi-hel1.internal/namespace-my-project-1/api:build-12"
+func (b *ImageBuild) DestinationInZone(zone ContainerZone) string {
+	return zone.RegistryHost + strings.TrimPrefix(b.ImageRef, strings.SplitN(b.ImageRef, "/", 2)[0]) + ":" + b.ImageTag
+}
+
+func (b *ImageBuild) Describe() string {
+	if b.Source == "tarball" {
+		return "an uploaded tarball"
+	}
+	if b.GitRef == nil {
+		return *b.GitURL
+	}
+	return *b.GitURL + "@" + *b.GitRef
+}
+
+// Credentials for a project's repositories in the zones' registries, which docker trades for a token at
+// /registry-token. The ones named "lcaas-..." are made and used by us, for image pull secrets and builds. The
+// password is only kept encrypted, in SealedPassword, and only shown once, when the credential's made.
+type RegistryCredential struct {
+	RegistryCredentialID int        `json:"registry_credential_id" db:"registry_credential_id"`
+	CreatedAt            time.Time  `json:"created_at" db:"created_at"`
+	ProjectID            int        `json:"project_id" db:"project_id"`
+	Name                 string     `json:"name" db:"name"`
+	Username             string     `json:"username" db:"username"`
+	SealedPassword       []byte     `json:"-" db:"sealed_password"`
+	CanPush              bool       `json:"can_push" db:"can_push"`
+	LastUsedAt           *time.Time `json:"last_used_at" db:"last_used_at"`
+	DeletedAt            *time.Time `json:"deleted_at" db:"deleted_at"`
+
+	Password string `json:"-" db:"-"`
+}
+
+const (
+	RegistryPullCredentialName = "lcaas-pull"
+	RegistryPushCredentialName = "lcaas-build"
+)
+
+func (c *RegistryCredential) IsManaged() bool {
+	return strings.HasPrefix(c.Name, "lcaas-")
+}
+
+// The name and whether it may push, ie. name=ci&push=true
+func (c *RegistryCredential) ParseFromHTTPForm(r *http.Request) error {
+	c.Name = r.FormValue("name")
+	if c.Name == "" || strings.Trim(c.Name, "abcdefghijklmnopqrstuvwxyzABCDEFGHIJKLMNOPQRSTUVWXYZ0123456789-_") != "" {
+		return fmt.Errorf("A credential's name can only have a-z, A-Z, 0-9, '-' and '_' in it")
+	}
+	if c.IsManaged() {
+		return fmt.Errorf("Names starting with \"lcaas-\" are kept for the credentials we make ourselves")
+	}
+	c.CanPush = r.FormValue("push") == "on" || r.FormValue("push") == "true"
+	return nil
+}
+
+// Encrypts the password into SealedPassword, bound to the credential's project and name
+func (c *RegistryCredential) Seal(key []byte) (err error) {
+	c.SealedPassword, err = SealSecret(key, []byte(c.Password), secretAdditionalData("registry_credential", c.ProjectID, c.Name))
+	return err
+}
+
+// Decrypts SealedPassword back into the password
+func (c *RegistryCredential) Open(key []byte) error {
+	plaintext, err := OpenSecret(key, c.SealedPassword, secretAdditionalData("registry_credential", c.ProjectID, c.Name))
+	if err != nil {
+		return fmt.Errorf("Decrypting registry credential %s failed: %w", c.Name, err)
+	}
+	c.Password = string(plaintext)
+	return nil
+}
+
+// A fresh username and password, with the username telling whose it is
+func NewRegistryCredentialLogin(project Project, name string) (username string, password string, err error) {
+	suffix, err := newSecret()
+	if err != nil {
+		return "", "", err
+	}
+	password, err = newSecret()
+	if err != nil {
+		return "", "", err
+	}
+	return fmt.Sprintf("%s-%s-%s", project.Name, strings.ToLower(name), suffix[:8]), password, nil
+}
+
+// Logins for registries other than the zones' own, kept per project so that they can be picked for new containers
+// instead of being typed in every time. The password and token are only kept encrypted, in SealedSecret.
+type PullCredential struct {
+	PullCredentialID int        `json:"pull_credential_id" db:"pull_credential_id"`
+	CreatedAt        time.Time  `json:"created_at" db:"created_at"`
+	RotatedAt        *time.Time `json:"rotated_at" db:"rotated_at"`
+	ProjectID        int        `json:"project_id" db:"project_id"`
+	Name             string     `json:"name" db:"name"`
+	URL              string     `json:"url" db:"url"`
+	Email            string     `json:"email" db:"email"`
+	Username         string     `json:"username" db:"username"`
+	SealedSecret     []byte     `json:"-" db:"sealed_secret"`
+	DeletedAt        *time.Time `json:"deleted_at" db:"deleted_at"`
+
+	Password string `json:"-" db:"-"`
+	Token    string `json:"-" db:"-"`
+}
+
+type pullCredentialSecret struct {
+	Password string `json:"password"`
+	Token    string `json:"token"`
+}
+
+// The name and the login, ie. name=ghcr&url=ghcr.io&username=me&password=ghp_... Rotating only takes the login.
+func (c *PullCredential) ParseFromHTTPForm(r *http.Request, withName bool) error {
+	if withName {
+		c.Name = r.FormValue("name")
+		if c.Name == "" || strings.Trim(c.Name, "abcdefghijklmnopqrstuvwxyzABCDEFGHIJKLMNOPQRSTUVWXYZ0123456789-_") != "" {
+			return fmt.Errorf("A credential's name can only have a-z, A-Z, 0-9, '-' and '_' in it")
+		}
+		c.URL = r.FormValue("url")
+		if c.URL == "" {
+			return fmt.Errorf("The registry's URL is missing")
+		}
+	}
+	c.Email = r.FormValue("email")
+	c.Username = r.FormValue("username")
+	c.Password = r.FormValue("password")
+	c.Token = r.FormValue("token")
+	if (c.Username == "" || c.Password == "") && c.Token == "" {
+		return fmt.Errorf("Either a username and password or a token is needed")
+	}
+	return nil
+}
+
+// Encrypts the password and token into SealedSecret, bound to the credential's project and name
+func (c *PullCredential) Seal(key []byte) (err error) {
+	plaintext, err := json.Marshal(pullCredentialSecret{Password: c.Password, Token: c.Token})
+	if err != nil {
+		return err
+	}
+	c.SealedSecret, err = SealSecret(key, plaintext, secretAdditionalData("pull_credential", c.ProjectID, c.Name))
+	return err
+}
+
+// Decrypts SealedSecret back into the password and token
+func (c *PullCredential) Open(key []byte) error {
+	plaintext, err := OpenSecret(key, c.SealedSecret, secretAdditionalData("pull_credential", c.ProjectID, c.Name))
+	if err != nil {
+		return fmt.Errorf("Decrypting pull credential %s failed: %w", c.Name, err)
+	}
+	var secret pullCredentialSecret
+	err = json.Unmarshal(plaintext, &secret)
+	if err != nil {
+		return err
+	}
+	c.Password, c.Token = secret.Password, secret.Token
+	return nil
+}
+
+// What a container that uses it gets as its image pull secret, once it's been opened
+func (c *PullCredential) ImagePullSecret() *ImagePullSecret {
+	return &ImagePullSecret{URL: c.URL, Email: c.Email, Username: c.Username, Password: c.Password, Token: c.Token}
+}
+
+// What a sealed secret is bound to, so that it can't be opened as anything else, ie. after being copied over to
+// another project's credential in the DB. Names can't have a "/" in them.
+func secretAdditionalData(kind string, projectID int, name string) []byte {
+	return []byte(fmt.Sprintf("%s/%d/%s", kind, projectID, name))
+}
+
+// AES-256-GCM, with the nonce in front. additionalData has to be the same to open it again.
+func SealSecret(key []byte, plaintext []byte, additionalData []byte) ([]byte, error) {
+	if len(key) == 0 {
+		return nil, fmt.Errorf("There's no key to encrypt credentials with here, ask an operator to set CREDENTIALS_KEY")
+	}
+	block, err := aes.NewCipher(key)
+	if err != nil {
+		return nil, err
+	}
+	gcm, err := cipher.NewGCM(block)
+	if err != nil {
+		return nil, err
+	}
+	nonce := make([]byte, gcm.NonceSize())
+	_, err = rand.Read(nonce)
+	if err != nil {
+		return nil, err
+	}
+	return gcm.Seal(nonce, nonce, plaintext, additionalData), nil
+}
+
+func OpenSecret(key []byte, sealed []byte, additionalData []byte) ([]byte, error) {
+	if len(key) == 0 {
+		return nil, fmt.Errorf("There's no key to decrypt credentials with here, ask an operator to set CREDENTIALS_KEY")
+	}
+	block, err := aes.NewCipher(key)
+	if err != nil {
+		return nil, err
+	}
+	gcm, err := cipher.NewGCM(block)
+	if err != nil {
+		return nil, err
+	}
+	if len(sealed) < gcm.NonceSize() {
+		return nil, fmt.Errorf("The sealed secret is too short")
+	}
+	return gcm.Open(nil, sealed[:gcm.NonceSize()], sealed[gcm.NonceSize():], additionalData)
+}
+
+// A manifest in one of the zones' registries, as last seen there. An image whose tags all moved on to other
+// manifests is still there, untagged, until it's garbage-collected.
+type RegistryImage struct {
+	RegistryImageID int            `json:"registry_image_id" db:"registry_image_id"`
+	ZoneName        string         `json:"zone_name" db:"zone_name"`
+	ProjectID       int            `json:"project_id" db:"project_id"`
+	Repository      string         `json:"repository" db:"repository"`
+	Digest          string         `json:"digest" db:"digest"`
+	Tags            pq.StringArray `json:"tags" db:"tags"`
+	SizeBytes       int64          `json:"size_bytes" db:"size_bytes"`
+	FirstSeenAt     time.Time      `json:"first_seen_at" db:"first_seen_at"`
+	LastSeenAt      time.Time      `json:"last_seen_at" db:"last_seen_at"`
+	DeletedAt       *time.Time     `json:"deleted_at" db:"deleted_at"`
+}
+
+func (i RegistryImage) SizeMB() float64 {
+	return float64(i.SizeBytes) / (1 << 20)
+}
+
+// What a project keeps in a zone's registry
+type RegistryUsage struct {
+	ZoneName  string `json:"zone_name" db:"zone_name"`
+	SizeBytes int64  `json:"size_bytes" db:"size_bytes"`
+	QuotaMB   int    `json:"quota_mb" db:"-"`
+}
+
+func (u RegistryUsage) SizeMB() float64 {
+	return float64(u.SizeBytes) / (1 << 20)
+}
+
+// A project's containers, database and buckets written down, to be applied all at once instead of being clicked
+// through. Env var values never go in here: containers get their config from shared configs and service bindings, and
+// keep whatever env vars they were created with.
+type ProjectManifest struct {
+	Containers     []ManifestContainer     `json:"containers"`
+	Database       *ManifestDatabase       `json:"database,omitempty"`
+	ObjectStorages []ManifestObjectStorage `json:"object_storages"`
+}
+
+type ManifestContainer struct {
+	Name              string   `json:"name"`
+	Image             string   `json:"image"`
+	Tag               string   `json:"tag,omitempty"`
+	Command           []string `json:"command,omitempty"`
+	Ports             []int64  `json:"ports,omitempty"`
+	CPUMilliCores     int      `json:"cpu_millicores,omitempty"`
+	MemoryMB          int      `json:"memory_mb,omitempty"`
+	Zones             []string `json:"zones,omitempty"`
+	RunType           string   `json:"run_type,omitempty"`
+	SecurityProfile   string   `json:"security_profile,omitempty"`
+	SharedConfigs     []string `json:"shared_configs,omitempty"`
+	BindDatabase      bool     `json:"bind_database,omitempty"`
+	BindObjectStorage string   `json:"bind_object_storage,omitempty"`
+	PullCredential    string   `json:"pull_credential,omitempty"` // one of the project's stored registry credentials
+}
+
+type ManifestDatabase struct {
+	Zone string `json:"zone"`
+}
+
+type ManifestObjectStorage struct {
+	Name  string   `json:"name"`
+	Zones []string `json:"zones,omitempty"`
+}
+
+// One thing applying a manifest does, or would do on a dry run
+type ManifestChange struct {
+	Kind   string                `json:"kind"`   // container | database | object_storage
+	Name   string                `json:"name"`   // empty for the database
+	Action string                `json:"action"` // create | update | delete
+	Fields []RevisionFieldChange `json:"fields,omitempty"`
+}
+
+var manifestNameChars = "abcdefghijklmnopqrstuvwxyzABCDEFGHIJKLMNOPQRSTUVWXYZ0123456789-_"
+
+// A manifest in YAML or JSON, with everything that was left out filled in the way the new container form does, so
+// that an exported manifest applies without changes. Zones are checked against containerZones and userDBZones.
+func ParseProjectManifest(body []byte, containerZones []string, userDBZones []string) (manifest ProjectManifest, err error) {
+	// YAML is a superset of JSON, so this takes both
+	manifestJSON, err := yaml.YAMLToJSON(body)
+	if err != nil {
+		return manifest, fmt.Errorf("The manifest isn't valid YAML or JSON: %w", err)
+	}
+	decoder := json.NewDecoder(bytes.NewReader(manifestJSON))
+	decoder.DisallowUnknownFields()
+	err = decoder.Decode(&manifest)
+	if err != nil {
+		return manifest, fmt.Errorf("Reading the manifest failed: %w", err)
+	}
+
+	checkZones := func(what string, zones []string, knownZones []string) error {
+		for _, zone := range zones {
+			if !slices.Contains(knownZones, zone) {
+				return fmt.Errorf("%s: there's no zone called %q, pick from: %s", what, zone, strings.Join(knownZones, ", "))
+			}
+		}
+		return nil
+	}
+
+	seenContainers := map[string]bool{}
+	for i := range manifest.Containers {
+		c := &manifest.Containers[i]
+		if c.Name == "" || strings.Trim(c.Name, manifestNameChars) != "" {
+			return manifest, fmt.Errorf("Container %q: a name can only have a-z, A-Z, 0-9, '-' and '_' in it", c.Name)
+		}
+		if seenContainers[c.Name] {
+			return manifest, fmt.Errorf("Container %s is in the manifest more than once", c.Name)
+		}
+		seenContainers[c.Name] = true
+		if c.Image == "" {
+			return manifest, fmt.Errorf("Container %s has no image", c.Name)
+		}
+		if c.Tag == "" {
+			c.Tag = "latest"
+		}
+		if c.CPUMilliCores == 0 {
+			c.CPUMilliCores = 100
+		}
+		if c.MemoryMB == 0 {
+			c.MemoryMB = 256
+		}
+		if len(c.Zones) == 0 {
+			c.Zones = containerZones
+		}
+		err = checkZones("Container "+c.Name, c.Zones, containerZones)
+		if err != nil {
+			return manifest, err
+		}
+		if c.RunType == "" {
+			c.RunType = "permanent"
+		}
+		if c.RunType != "permanent" && c.RunType != "once" {
+			return manifest, fmt.Errorf("Container %s: run_type has to be permanent or once", c.Name)
+		}
+		if c.SecurityProfile == "" {
+			c.SecurityProfile = "restricted"
+		}
+		if !slices.Contains(SecurityProfiles, c.SecurityProfile) {
+			return manifest, fmt.Errorf("Container %s: unknown security profile %q, pick one of: %s", c.Name, c.SecurityProfile, strings.Join(SecurityProfiles, ", "))
+		}
+	}
+
+	if manifest.Database != nil {
+		// same as the new DB form, there's no point in asking which zone when there's only one
+		if manifest.Database.Zone == "" && len(userDBZones) == 1 {
+			manifest.Database.Zone = userDBZones[0]
+		}
+		err = checkZones("The database", []string{manifest.Database.Zone}, userDBZones)
+		if err != nil {
+			return manifest, err
+		}
+	}
+
+	seenObjectStorages := map[string]bool{}
+	for i := range manifest.ObjectStorages {
+		o := &manifest.ObjectStorages[i]
+		if o.Name == "" || strings.Trim(o.Name, manifestNameChars) != "" {
+			return manifest, fmt.Errorf("Object storage %q: a name can only have a-z, A-Z, 0-9, '-' and '_' in it", o.Name)
+		}
+		if seenObjectStorages[o.Name] {
+			return manifest, fmt.Errorf("Object storage %s is in the manifest more than once", o.Name)
+		}
+		seenObjectStorages[o.Name] = true
+		if len(o.Zones) == 0 {
+			o.Zones = containerZones
+		}
+		err = checkZones("Object storage "+o.Name, o.Zones, containerZones)
+		if err != nil {
+			return manifest, err
+		}
+	}
+
+	return manifest, nil
+}
+
+// How a running container looks in a manifest
+func ManifestContainerFromClaim(c ContainerClaim) ManifestContainer {
+	m := ManifestContainer{
+		Name:            c.Name,
+		Image:           c.ImageRef,
+		Tag:             c.ImageTag,
+		Command:         c.Command,
+		Ports:           c.TargetPorts,
+		CPUMilliCores:   c.CPUMilliCores,
+		MemoryMB:        c.MemoryMB,
+		Zones:           c.Zones,
+		RunType:         c.RunType,
+		SecurityProfile: c.SecurityProfile,
+		SharedConfigs:   c.SharedConfigNames,
+		BindDatabase:    c.BindDatabase,
+	}
+	if c.BoundObjectStorage != nil {
+		m.BindObjectStorage = *c.BoundObjectStorage
+	}
+	if c.PullCredentialName != nil {
+		m.PullCredential = *c.PullCredentialName
+	}
+	return m
+}
+
+// Everything that differs between what's running (m) and what the manifest wants, as display strings. The order of
+// the zones doesn't matter.
+func (m ManifestContainer) Diff(other ManifestContainer) (changes []RevisionFieldChange) {
+	sortedZones := func(zones []string) string {
+		zones = slices.Clone(zones)
+		slices.Sort(zones)
+		return strings.Join(zones, ", ")
+	}
+	fields := []struct {
+		name     string
+		from, to string
+	}{
+		{"image", m.Image, other.Image},
+		{"tag", m.Tag, other.Tag},
+		{"command", strings.Join(m.Command, " "), strings.Join(other.Command, " ")},
+		{"ports", fmt.Sprint(m.Ports), fmt.Sprint(other.Ports)},
+		{"cpu_millicores", strconv.Itoa(m.CPUMilliCores), strconv.Itoa(other.CPUMilliCores)},
+		{"memory_mb", strconv.Itoa(m.MemoryMB), strconv.Itoa(other.MemoryMB)},
+		{"zones", sortedZones(m.Zones), sortedZones(other.Zones)},
+		{"run_type", m.RunType, other.RunType},
+		{"security_profile", m.SecurityProfile, other.SecurityProfile},
+		{"shared_configs", strings.Join(m.SharedConfigs, ", "), strings.Join(other.SharedConfigs, ", ")},
+		{"bind_database", strconv.FormatBool(m.BindDatabase), strconv.FormatBool(other.BindDatabase)},
+		{"bind_object_storage", m.BindObjectStorage, other.BindObjectStorage},
+		{"pull_credential", m.PullCredential, other.PullCredential},
+	}
+	for _, f := range fields {
+		if f.from != f.to {
+			changes = append(changes, RevisionFieldChange{Field: f.name, From: f.from, To: f.to})
+		}
+	}
+	return changes
+}
+
+// Applies the manifest's settings on top of a container, the running one when updating and an empty one when creating.
+// A new image or tag gets resolved to a digest again.
+func (m ManifestContainer) AppliedTo(c ContainerClaim) ContainerClaim {
+	if c.ImageRef != m.Image || c.ImageTag != m.Tag {
+		c.ImageDigest = nil
+	}
+	c.Name = m.Name
+	c.ImageRef = m.Image
+	c.ImageTag = m.Tag
+	c.Command = m.Command
+	c.TargetPorts = m.Ports
+	c.Ports = m.Ports
+	c.CPUMilliCores = m.CPUMilliCores
+	c.MemoryMB = m.MemoryMB
+	c.Zones = m.Zones
+	c.RunType = m.RunType
+	c.SecurityProfile = m.SecurityProfile
+	c.SharedConfigNames = m.SharedConfigs
+	c.BindDatabase = m.BindDatabase
+	c.BoundObjectStorage = nil
+	if m.BindObjectStorage != "" {
+		c.BoundObjectStorage = &m.BindObjectStorage
+	}
+	c.PullCredentialName = nil
+	if m.PullCredential != "" {
+		c.PullCredentialName = &m.PullCredential
+	}
+	return c
 }
@@ -0,0 +1,43 @@
+package webhooks
+
+import (
+	"fmt"
+	"net"
+	"net/http"
+	"syscall"
+	"time"
+
+	"github.com/lu1a/lcaas/core-service/types"
+)
+
+// An HTTP client for sending to URLs that projects picked. It only connects to public addresses, checked on the
+// address that's actually dialled so that a host can't be pointed inside after its URL got through
+// types.CheckOutboundURL, and it doesn't follow redirects, which could point it there as well.
+func NewClient(timeout time.Duration) *http.Client {
+	dialer := &net.Dialer{Timeout: timeout, Control: DialPublicOnly}
+	transport := http.DefaultTransport.(*http.Transport).Clone()
+	// a proxy would be what gets dialled instead of the receiver
+	transport.Proxy = nil
+	transport.DialContext = dialer.DialContext
+	return &http.Client{
+		Timeout:   timeout,
+		Transport: transport,
+		CheckRedirect: func(*http.Request, []*http.Request) error {
+			return http.ErrUseLastResponse
+		},
+	}
+}
+
+// A net.Dialer Control that refuses to connect to anything but public addresses, for whatever else connects to
+// places that projects picked
+func DialPublicOnly(network, address string, _ syscall.RawConn) error {
+	host, _, err := net.SplitHostPort(address)
+	if err != nil {
+		return err
+	}
+	ip := net.ParseIP(host)
+	if ip == nil || !types.IsPublicIP(ip) {
+		return fmt.Errorf("Not connecting to %s, it isn't a public address", host)
+	}
+	return nil
+}
@@ -0,0 +1,171 @@
+package webhooks
+
+import (
+	"bytes"
+	"context"
+	"crypto/hmac"
+	"crypto/sha256"
+	"encoding/hex"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"io"
+	"net/http"
+	"strconv"
+	"sync"
+	"time"
+
+	"github.com/charmbracelet/log"
+	"github.com/jmoiron/sqlx"
+	"github.com/lu1a/lcaas/core-service/db"
+	"github.com/lu1a/lcaas/core-service/metrics"
+	"github.com/lu1a/lcaas/core-service/types"
+)
+
+const (
+	dispatchInterval = 15 * time.Second
+	// at most this many deliveries get attempted in a round, the rest wait for the next one
+	dispatchBatchSize = 100
+	// and this many at once, so that a few receivers that take their time don't hold up everyone else's
+	dispatchConcurrency = 10
+)
+
+// redirects come back as they are, and count as a failed delivery
+var webhookClient = NewClient(10 * time.Second)
+
+// Sends the pending webhook deliveries whose next attempt is due, every dispatchInterval. A failed attempt gets
+// retried with backoff until the delivery runs out of attempts, see types.WebhookDelivery.RetryAt.
+type Dispatcher struct {
+	log     log.Logger
+	adminDB *sqlx.DB
+}
+
+func NewDispatcher(log log.Logger, adminDB *sqlx.DB) *Dispatcher {
+	return &Dispatcher{log: log, adminDB: adminDB}
+}
+
+// Dispatches every dispatchInterval until ctx is done
+func (d *Dispatcher) Run(ctx context.Context) {
+	ticker := time.NewTicker(dispatchInterval)
+	defer ticker.Stop()
+	for {
+		select {
+		case <-ctx.Done():
+			return
+		case <-ticker.C:
+			metrics.WorkerRan("webhook_dispatcher", d.dispatch(ctx))
+		}
+	}
+}
+
+// Only errors from keeping track of the deliveries count, a receiver that's down is the delivery's problem
+func (d *Dispatcher) dispatch(ctx context.Context) error {
+	deliveries, err := db.GetDueWebhookDeliveries(d.adminDB, dispatchBatchSize)
+	if err != nil {
+		d.log.Error(err.Error())
+		return err
+	}
+
+	var wg sync.WaitGroup
+	var errsMu sync.Mutex
+	var errs []error
+	slots := make(chan struct{}, dispatchConcurrency)
+	for _, delivery := range deliveries {
+		slots <- struct{}{}
+		wg.Add(1)
+		go func() {
+			defer wg.Done()
+			defer func() { <-slots }()
+			err := d.attempt(ctx, delivery)
+			if err != nil {
+				d.log.Error("Attempting webhook delivery failed", "delivery", delivery.WebhookDeliveryID, "err", err)
+				errsMu.Lock()
+				errs = append(errs, fmt.Errorf("webhook delivery %v: %w", delivery.WebhookDeliveryID, err))
+				errsMu.Unlock()
+			}
+		}()
+	}
+	wg.Wait()
+	return errors.Join(errs...)
+}
+
+func (d *Dispatcher) attempt(ctx context.Context, delivery types.WebhookDelivery) error {
+	subscription, err := db.GetWebhookSubscriptionByID(d.adminDB, delivery.WebhookSubscriptionID)
+	if err != nil {
+		return err
+	}
+	event, err := db.GetWebhookEventByID(d.adminDB, delivery.WebhookEventID)
+	if err != nil {
+		return err
+	}
+	project, err := db.GetProjectByID(d.adminDB, event.ProjectID)
+	if err != nil {
+		return err
+	}
+	body, err := json.Marshal(types.WebhookPayload{
+		ID:        event.WebhookEventID,
+		Event:     event.Event,
+		CreatedAt: event.CreatedAt,
+		Project:   project.Name,
+		Data:      event.Data,
+	})
+	if err != nil {
+		return err
+	}
+
+	now := time.Now()
+	responseStatus, err := send(ctx, subscription, delivery, body, now)
+	delivery.Attempts++
+	delivery.LastAttemptAt = &now
+	delivery.ResponseStatus = nil
+	if responseStatus != 0 {
+		delivery.ResponseStatus = &responseStatus
+	}
+	delivery.Error = nil
+	delivery.Status = "delivered"
+	if err != nil {
+		errStr := err.Error()
+		delivery.Error = &errStr
+		retryAt, retrying := delivery.RetryAt(now)
+		if retrying {
+			delivery.Status = "pending"
+			delivery.NextAttemptAt = retryAt
+		} else {
+			delivery.Status = "failed"
+		}
+		d.log.Warn("Webhook delivery failed", "project", project.Name, "webhook", subscription.Name, "event", event.Event, "attempt", delivery.Attempts, "retrying", retrying, "err", err)
+	}
+	return db.SaveWebhookDeliveryAttempt(d.adminDB, delivery)
+}
+
+// POSTs the payload, signed with the subscription's secret: X-LCaaS-Signature-256 is sha256= followed by the hex
+// HMAC-SHA256 of the X-LCaaS-Timestamp, a dot and the body, so that old payloads can't be replayed as new ones
+func send(ctx context.Context, subscription types.WebhookSubscription, delivery types.WebhookDelivery, body []byte, now time.Time) (responseStatus int, err error) {
+	timestamp := strconv.FormatInt(now.Unix(), 10)
+	mac := hmac.New(sha256.New, []byte(subscription.Secret))
+	mac.Write([]byte(timestamp + "."))
+	mac.Write(body)
+
+	req, err := http.NewRequestWithContext(ctx, http.MethodPost, subscription.URL, bytes.NewReader(body))
+	if err != nil {
+		return 0, err
+	}
+	req.Header.Set("Content-Type", "application/json")
+	req.Header.Set("User-Agent", "lcaas-webhooks")
+	req.Header.Set("X-LCaaS-Event", delivery.Event)
+	req.Header.Set("X-LCaaS-Delivery", strconv.Itoa(delivery.WebhookDeliveryID))
+	req.Header.Set("X-LCaaS-Timestamp", timestamp)
+	req.Header.Set("X-LCaaS-Signature-256", "sha256="+hex.EncodeToString(mac.Sum(nil)))
+
+	resp, err := webhookClient.Do(req)
+	if err != nil {
+		return 0, err
+	}
+	defer resp.Body.Close()
+	if resp.StatusCode >= 300 {
+		// a bit of what it said helps with figuring out why
+		answer, _ := io.ReadAll(io.LimitReader(resp.Body, 256))
+		return resp.StatusCode, fmt.Errorf("answered %s: %s", resp.Status, bytes.TrimSpace(answer))
+	}
+	return resp.StatusCode, nil
+}
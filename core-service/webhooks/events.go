@@ -0,0 +1,83 @@
+package webhooks
+
+import (
+	"encoding/json"
+
+	"github.com/charmbracelet/log"
+	"github.com/jmoiron/sqlx"
+	"github.com/lu1a/lcaas/core-service/db"
+	"github.com/lu1a/lcaas/core-service/types"
+)
+
+// What the container.* events say about the container
+type containerData struct {
+	ContainerClaimID int      `json:"container_claim_id"`
+	Name             string   `json:"name"`
+	ImageRef         string   `json:"image_ref"`
+	ImageTag         string   `json:"image_tag"`
+	ImageDigest      *string  `json:"image_digest"`
+	RunType          string   `json:"run_type"`
+	Zones            []string `json:"zones"`
+}
+
+// What the db.* events say about the project database, Username being the user that db.user_created is about
+type dbData struct {
+	UserDBClaimID int      `json:"user_db_claim_id"`
+	Zones         []string `json:"zones"`
+	Username      string   `json:"username,omitempty"`
+}
+
+type objectStorageData struct {
+	ObjectStorageClaimID int      `json:"object_storage_claim_id"`
+	Name                 string   `json:"name"`
+	Zones                []string `json:"zones"`
+}
+
+func EmitContainer(log log.Logger, adminDB *sqlx.DB, project types.Project, event string, c types.ContainerClaim) {
+	emit(log, adminDB, project, event, containerData{
+		ContainerClaimID: c.ContainerClaimID,
+		Name:             c.Name,
+		ImageRef:         c.ImageRef,
+		ImageTag:         c.ImageTag,
+		ImageDigest:      c.ImageDigest,
+		RunType:          c.RunType,
+		Zones:            c.Zones,
+	})
+}
+
+func EmitDB(log log.Logger, adminDB *sqlx.DB, project types.Project, event string, userDBClaim types.UserDBClaim, username string) {
+	emit(log, adminDB, project, event, dbData{UserDBClaimID: userDBClaim.UserDBClaimID, Zones: userDBClaim.Zones, Username: username})
+}
+
+func EmitObjectStorage(log log.Logger, adminDB *sqlx.DB, project types.Project, event string, objectStorage types.ObjectStorageClaim) {
+	emit(log, adminDB, project, event, objectStorageData{ObjectStorageClaimID: objectStorage.ObjectStorageClaimID, Name: objectStorage.Name, Zones: objectStorage.Zones})
+}
+
+// Queues the event up for every subscription of the project that wants it, for the dispatcher to send. Whatever
+// goes wrong only gets logged, since what the event is about already happened either way.
+func emit(log log.Logger, adminDB *sqlx.DB, project types.Project, event string, data any) {
+	subscriptions, err := db.GetWebhookSubscriptionsByProject(adminDB, project)
+	if err != nil {
+		log.Error("Couldn't get the project's webhooks", "project", project.Name, "event", event, "err", err)
+		return
+	}
+	wanting := []types.WebhookSubscription{}
+	for _, subscription := range subscriptions {
+		if subscription.Wants(event) {
+			wanting = append(wanting, subscription)
+		}
+	}
+	if len(wanting) == 0 {
+		return
+	}
+
+	dataJSON, err := json.Marshal(data)
+	if err != nil {
+		log.Error("Couldn't encode the webhook event", "project", project.Name, "event", event, "err", err)
+		return
+	}
+	_, err = db.CreateWebhookEvent(adminDB, types.WebhookEvent{ProjectID: project.ProjectID, Event: event, Data: dataJSON}, wanting)
+	if err != nil {
+		log.Error(err.Error())
+	}
+}